@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewAuditCommand() *cobra.Command {
+	var opts auditOptions
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the tool execution audit log",
+		Example: `  picoclaw audit --since 24h
+  picoclaw audit --tool exec --errors
+  picoclaw audit --sender telegram:123456 -n 0
+  picoclaw audit -f --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return auditCmd(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.since, "since", "", "Start date (YYYY-MM-DD) or lookback window (e.g. 24h, 7d)")
+	cmd.Flags().StringVar(&opts.until, "until", "", "End date (YYYY-MM-DD, inclusive)")
+	cmd.Flags().StringVar(&opts.agent, "agent", "", "Only show this agent ID")
+	cmd.Flags().StringVar(&opts.session, "session", "", "Only show this session key")
+	cmd.Flags().StringVar(&opts.channel, "channel", "", "Only show this channel")
+	cmd.Flags().StringVar(&opts.chat, "chat", "", "Only show this chat ID")
+	cmd.Flags().StringVar(&opts.sender, "sender", "", "Only show this sender (canonical ID, e.g. telegram:123456)")
+	cmd.Flags().StringVar(&opts.tool, "tool", "", "Only show this tool")
+	cmd.Flags().BoolVar(&opts.errors, "errors", false, "Only show failed tool calls")
+	cmd.Flags().IntVarP(&opts.limit, "limit", "n", 50, "Show the last N entries (0: all)")
+	cmd.Flags().BoolVarP(&opts.follow, "follow", "f", false, "Keep printing new entries as they are written")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Print entries as JSON lines")
+
+	return cmd
+}
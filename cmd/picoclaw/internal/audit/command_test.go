@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipeed/picoclaw/pkg/audit"
+)
+
+func TestNewAuditCommand(t *testing.T) {
+	cmd := NewAuditCommand()
+
+	require.NotNil(t, cmd)
+
+	assert.Equal(t, "audit", cmd.Use)
+	assert.Equal(t, "Show the tool execution audit log", cmd.Short)
+	assert.False(t, cmd.HasSubCommands())
+	assert.NotNil(t, cmd.RunE)
+
+	for _, name := range []string{
+		"since", "until", "agent", "session", "channel", "chat", "sender", "tool", "errors", "limit", "follow", "json",
+	} {
+		assert.NotNil(t, cmd.Flags().Lookup(name), "missing flag %s", name)
+	}
+	assert.Equal(t, "50", cmd.Flags().Lookup("limit").DefValue)
+	assert.Equal(t, "f", cmd.Flags().Lookup("follow").Shorthand)
+}
+
+func TestBuildFilter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	f, err := buildFilter(auditOptions{since: "24h", until: "2026-03-10", tool: "exec", errors: true}, now)
+	require.NoError(t, err)
+	assert.True(t, f.Since.Equal(now.Add(-24*time.Hour)))
+	assert.True(t, f.Until.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, time.Local)))
+	assert.Equal(t, "exec", f.Tool)
+	assert.True(t, f.ErrorsOnly)
+
+	_, err = buildFilter(auditOptions{since: "yesterday"}, now)
+	assert.Error(t, err)
+}
+
+func TestPrintEntries(t *testing.T) {
+	entries := []audit.Entry{{
+		Time:       time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local),
+		AgentID:    "main",
+		Channel:    "telegram",
+		ChatID:     "42",
+		Sender:     "telegram:7",
+		Tool:       "exec",
+		Args:       map[string]any{"command": "ls"},
+		DurationMS: 15,
+		IsError:    true,
+		ResultSize: 120,
+	}}
+
+	var table bytes.Buffer
+	require.NoError(t, printEntries(&table, entries, false, true))
+	out := table.String()
+	assert.True(t, strings.HasPrefix(out, "TIME"), out)
+	for _, want := range []string{"2026-03-10 12:00:00", "telegram:42", "telegram:7", "exec", "error", `{"command":"ls"}`} {
+		assert.Contains(t, out, want)
+	}
+
+	var lines bytes.Buffer
+	require.NoError(t, printEntries(&lines, entries, true, true))
+	assert.Contains(t, lines.String(), `"tool":"exec"`)
+	assert.NotContains(t, lines.String(), "TIME")
+}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sipeed/picoclaw/cmd/picoclaw/internal"
+	"github.com/sipeed/picoclaw/pkg/audit"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const followInterval = 500 * time.Millisecond
+
+type auditOptions struct {
+	since   string
+	until   string
+	agent   string
+	session string
+	channel string
+	chat    string
+	sender  string
+	tool    string
+	errors  bool
+	limit   int
+	follow  bool
+	json    bool
+}
+
+func auditCmd(ctx context.Context, opts auditOptions) error {
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	filter, err := buildFilter(opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	path := cfg.AuditLogPath()
+	var entries []audit.Entry
+	err = audit.Read(path, filter, func(e audit.Entry) error {
+		entries = append(entries, e)
+		if opts.limit > 0 && len(entries) > opts.limit {
+			entries = entries[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 && !opts.follow {
+		if _, statErr := os.Stat(path); statErr != nil {
+			fmt.Printf("No audit log at %s.\n", path)
+		} else {
+			fmt.Println("No tool calls match the selected filters.")
+		}
+		return nil
+	}
+	if err := printEntries(os.Stdout, entries, opts.json, true); err != nil {
+		return err
+	}
+	if !opts.follow {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	return audit.Follow(ctx, path, filter, followInterval, func(e audit.Entry) error {
+		return printEntries(os.Stdout, []audit.Entry{e}, opts.json, false)
+	})
+}
+
+func buildFilter(opts auditOptions, now time.Time) (audit.Filter, error) {
+	filter := audit.Filter{
+		AgentID:    opts.agent,
+		SessionKey: opts.session,
+		Channel:    opts.channel,
+		ChatID:     opts.chat,
+		Sender:     opts.sender,
+		Tool:       opts.tool,
+		ErrorsOnly: opts.errors,
+	}
+	var err error
+	if opts.since != "" {
+		if filter.Since, err = internal.ParseSince(opts.since, now); err != nil {
+			return filter, err
+		}
+	}
+	if opts.until != "" {
+		if filter.Until, err = internal.ParseUntil(opts.until); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// printEntries writes entries as a table or, with asJSON, as JSON lines.
+func printEntries(out io.Writer, entries []audit.Entry, asJSON, header bool) error {
+	if asJSON {
+		enc := json.NewEncoder(out)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(w, "TIME\tAGENT\tCHAT\tSENDER\tTOOL\tSTATUS\tMS\tSIZE\tARGS")
+	}
+	for _, e := range entries {
+		status := "ok"
+		if e.IsError {
+			status = "error"
+		}
+		chat := e.Channel
+		if e.ChatID != "" {
+			chat += ":" + e.ChatID
+		}
+		args, _ := json.Marshal(e.Args)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			orDash(e.AgentID), orDash(chat), orDash(e.Sender), e.Tool, status,
+			e.DurationMS, e.ResultSize, utils.Truncate(string(args), 80))
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -5,8 +5,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/redact"
 )
 
 const Logo = "🦞"
@@ -27,7 +33,29 @@ func GetConfigPath() string {
 }
 
 func LoadConfig() (*config.Config, error) {
-	return config.LoadConfig(GetConfigPath())
+	cfg, err := config.LoadConfig(GetConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	setupRedaction(cfg)
+	return cfg, nil
+}
+
+// setupRedaction registers the secrets in cfg and in the auth store with
+// the redactor applied to logs, tool results and outbound messages.
+func setupRedaction(cfg *config.Config) {
+	var extra []string
+	if store, err := auth.LoadStore(); err == nil {
+		extra = redact.CollectSecrets(store)
+	}
+	if err := redact.Configure(cfg, extra...); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if redact.Default() != nil {
+		logger.SetRedactor(redact.String)
+	} else {
+		logger.SetRedactor(nil)
+	}
 }
 
 // FormatVersion returns the version string with optional git commit
@@ -53,3 +81,30 @@ func FormatBuildInfo() (string, string) {
 func GetVersion() string {
 	return version
 }
+
+// ParseSince parses a --since flag: a YYYY-MM-DD date or a lookback window
+// such as "24h", "90m" or "7d" (days are not supported by time.ParseDuration).
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: expected YYYY-MM-DD or a window like 24h or 7d", s)
+}
+
+// ParseUntil parses an inclusive --until date (YYYY-MM-DD) and returns the
+// exclusive end of that day.
+func ParseUntil(s string) (time.Time, error) {
+	until, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --until %q: expected YYYY-MM-DD", s)
+	}
+	return until.AddDate(0, 0, 1), nil
+}
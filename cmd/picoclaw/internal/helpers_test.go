@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -105,3 +106,35 @@ func TestGetConfigPath_WithEnv(t *testing.T) {
 
 	assert.Equal(t, want, got)
 }
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{in: "2026-03-01", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+		{in: "7d", want: now.AddDate(0, 0, -7)},
+		{in: "24h", want: now.Add(-24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.in, now)
+		require.NoError(t, err, tt.in)
+		assert.True(t, got.Equal(tt.want), "%s: got %v, want %v", tt.in, got, tt.want)
+	}
+
+	for _, bad := range []string{"yesterday", "-3d", "03/01/2026"} {
+		_, err := ParseSince(bad, now)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	got, err := ParseUntil("2026-03-31")
+	require.NoError(t, err)
+	assert.True(t, got.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local)), "got %v", got)
+
+	_, err = ParseUntil("31/03/2026")
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewModelsCommand() *cobra.Command {
+	var host string
+
+	cmd := &cobra.Command{
+		Use:   "models",
+		Short: "List and pull models on the Ollama server",
+		Example: `  picoclaw models
+  picoclaw models pull qwen3:8b
+  picoclaw models list --host http://192.168.1.20:11434`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return modelsListCmd(cmd.Context(), host)
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&host, "host", "", "Ollama server URL (default: the ollama entry in model_list)")
+
+	cmd.AddCommand(
+		newListCommand(&host),
+		newPullCommand(&host),
+	)
+
+	return cmd
+}
+
+func newListCommand(host *string) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List installed models and whether they are loaded",
+		Example: `picoclaw models list`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return modelsListCmd(cmd.Context(), *host)
+		},
+	}
+}
+
+func newPullCommand(host *string) *cobra.Command {
+	return &cobra.Command{
+		Use:     "pull <model>...",
+		Short:   "Download models from the Ollama registry",
+		Example: `picoclaw models pull llama3.2 nomic-embed-text`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return modelsPullCmd(cmd.Context(), *host, args)
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestNewModelsCommand(t *testing.T) {
+	cmd := NewModelsCommand()
+
+	require.NotNil(t, cmd)
+
+	assert.Equal(t, "models", cmd.Use)
+	assert.NotNil(t, cmd.RunE)
+	assert.True(t, cmd.HasSubCommands())
+	assert.NotNil(t, cmd.PersistentFlags().Lookup("host"))
+
+	names := make([]string, 0, len(cmd.Commands()))
+	for _, sub := range cmd.Commands() {
+		names = append(names, sub.Name())
+	}
+	assert.ElementsMatch(t, []string{"list", "pull"}, names)
+}
+
+func TestOllamaModelConfig(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.ModelList = []config.ModelConfig{
+		{ModelName: "gpt", Model: "openai/gpt-5.2"},
+		{ModelName: "llama", Model: "ollama/llama3", APIBase: "http://gpu-box:11434"},
+		{ModelName: "qwen", Model: "ollama/qwen3", APIBase: "http://other:11434"},
+	}
+
+	cfg.Agents.Defaults.ModelName = "gpt"
+	assert.Equal(t, "http://gpu-box:11434", ollamaModelConfig(cfg).APIBase)
+
+	cfg.Agents.Defaults.ModelName = "qwen"
+	assert.Equal(t, "http://other:11434", ollamaModelConfig(cfg).APIBase)
+
+	cfg.ModelList = nil
+	assert.Equal(t, "", ollamaModelConfig(cfg).APIBase)
+}
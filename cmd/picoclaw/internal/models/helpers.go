@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sipeed/picoclaw/cmd/picoclaw/internal"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	ollamaprovider "github.com/sipeed/picoclaw/pkg/providers/ollama"
+)
+
+// newClient returns a client for host, or for the Ollama server configured
+// in model_list when host is empty.
+func newClient(host string) (*ollamaprovider.Provider, error) {
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	mc := ollamaModelConfig(cfg)
+	if host != "" {
+		mc.APIBase = host
+	}
+	return providers.NewOllamaProviderFromConfig(mc).Client(), nil
+}
+
+// ollamaModelConfig picks the model_list entry that describes the Ollama
+// server: the default model if it is an Ollama model, else the first
+// Ollama entry.
+func ollamaModelConfig(cfg *config.Config) *config.ModelConfig {
+	isOllama := func(mc *config.ModelConfig) bool {
+		protocol, _ := providers.ExtractProtocol(mc.Model)
+		return protocol == "ollama"
+	}
+	if mc, err := cfg.GetModelConfig(cfg.Agents.Defaults.GetModelName()); err == nil && isOllama(mc) {
+		copied := *mc
+		return &copied
+	}
+	for i := range cfg.ModelList {
+		if isOllama(&cfg.ModelList[i]) {
+			copied := cfg.ModelList[i]
+			return &copied
+		}
+	}
+	return &config.ModelConfig{Model: "ollama/"}
+}
+
+func modelsListCmd(ctx context.Context, host string) error {
+	client, err := newClient(host)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("listing models on %s: %w", client.BaseURL(), err)
+	}
+	if len(models) == 0 {
+		fmt.Printf("No models installed on %s. Run: picoclaw models pull <model>\n", client.BaseURL())
+		return nil
+	}
+	printModels(models, time.Now())
+	return nil
+}
+
+func printModels(models []ollamaprovider.ModelInfo, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tPARAMS\tQUANT\tMODIFIED\tLOADED")
+	for _, m := range models {
+		loaded := "-"
+		if m.Loaded {
+			loaded = "yes"
+			if !m.ExpiresAt.IsZero() && m.ExpiresAt.After(now) {
+				loaded = "until " + m.ExpiresAt.Local().Format("15:04")
+			}
+		}
+		modified := "-"
+		if !m.ModifiedAt.IsZero() {
+			modified = m.ModifiedAt.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			m.Name, formatSize(m.Size), dash(m.ParameterSize), dash(m.Quantization), modified, loaded)
+	}
+	w.Flush()
+}
+
+func modelsPullCmd(ctx context.Context, host string, names []string) error {
+	client, err := newClient(host)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Printf("Pulling %s from %s...\n", name, client.BaseURL())
+		lastStatus, inProgress := "", false
+		err := client.Pull(ctx, name, func(p ollamaprovider.PullProgress) {
+			if p.Total > 0 {
+				fmt.Printf("\r  %s %3d%% (%s/%s)", p.Status, p.Completed*100/p.Total,
+					formatSize(p.Completed), formatSize(p.Total))
+				inProgress = true
+				return
+			}
+			if p.Status == lastStatus {
+				return
+			}
+			if inProgress {
+				fmt.Println()
+				inProgress = false
+			}
+			fmt.Printf("  %s\n", p.Status)
+			lastStatus = p.Status
+		})
+		if inProgress {
+			fmt.Println()
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s is ready\n", name)
+	}
+	return nil
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func dash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/sipeed/picoclaw/cmd/picoclaw/internal"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/egress"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
@@ -67,6 +68,7 @@ func skillsInstallFromRegistry(cfg *config.Config, registryName, slug string) er
 	registryMgr := skills.NewRegistryManagerFromConfig(skills.RegistryConfig{
 		MaxConcurrentSearches: cfg.Tools.Skills.MaxConcurrentSearches,
 		ClawHub:               skills.ClawHubConfig(cfg.Tools.Skills.Registries.ClawHub),
+		Egress:                egressPolicy(cfg),
 	})
 
 	registry := registryMgr.GetRegistry(registryName)
@@ -229,6 +231,7 @@ func skillsSearchCmd(query string) {
 	registryMgr := skills.NewRegistryManagerFromConfig(skills.RegistryConfig{
 		MaxConcurrentSearches: cfg.Tools.Skills.MaxConcurrentSearches,
 		ClawHub:               skills.ClawHubConfig(cfg.Tools.Skills.Registries.ClawHub),
+		Egress:                egressPolicy(cfg),
 	})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -304,3 +307,14 @@ func copyDirectory(src, dst string) error {
 		return err
 	})
 }
+
+// egressPolicy returns the configured outbound HTTP policy, or the default
+// policy if the config is invalid.
+func egressPolicy(cfg *config.Config) *egress.Policy {
+	policy, err := egress.NewPolicy(cfg.Tools.Egress)
+	if err != nil {
+		fmt.Printf("Warning: %v; using the default egress policy\n", err)
+		return egress.DefaultPolicy()
+	}
+	return policy
+}
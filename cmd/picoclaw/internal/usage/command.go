@@ -0,0 +1,31 @@
+package usage
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewUsageCommand() *cobra.Command {
+	var opts usageOptions
+
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show token usage and cost",
+		Example: `  picoclaw usage --since 7d
+  picoclaw usage --by day --agent main
+  picoclaw usage --by session --channel telegram --since 2026-03-01 --until 2026-04-01`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return usageCmd(opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.since, "since", "", "Start date (YYYY-MM-DD) or lookback window (e.g. 24h, 7d)")
+	cmd.Flags().StringVar(&opts.until, "until", "", "End date (YYYY-MM-DD, inclusive)")
+	cmd.Flags().StringVar(&opts.by, "by", "model", "Group by: agent, session, channel, model, provider, kind or day")
+	cmd.Flags().StringVar(&opts.agent, "agent", "", "Only count this agent ID")
+	cmd.Flags().StringVar(&opts.session, "session", "", "Only count this session key")
+	cmd.Flags().StringVar(&opts.channel, "channel", "", "Only count this channel")
+	cmd.Flags().StringVar(&opts.model, "model", "", "Only count this model")
+
+	return cmd
+}
@@ -0,0 +1,24 @@
+package usage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUsageCommand(t *testing.T) {
+	cmd := NewUsageCommand()
+
+	require.NotNil(t, cmd)
+
+	assert.Equal(t, "usage", cmd.Use)
+	assert.Equal(t, "Show token usage and cost", cmd.Short)
+	assert.False(t, cmd.HasSubCommands())
+	assert.NotNil(t, cmd.RunE)
+
+	for _, name := range []string{"since", "until", "by", "agent", "session", "channel", "model"} {
+		assert.NotNil(t, cmd.Flags().Lookup(name), "missing flag %s", name)
+	}
+	assert.Equal(t, "model", cmd.Flags().Lookup("by").DefValue)
+}
@@ -0,0 +1,97 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sipeed/picoclaw/cmd/picoclaw/internal"
+	"github.com/sipeed/picoclaw/pkg/usage"
+)
+
+type usageOptions struct {
+	since   string
+	until   string
+	by      string
+	agent   string
+	session string
+	channel string
+	model   string
+}
+
+func usageCmd(opts usageOptions) error {
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	group, err := usage.ParseGroupBy(opts.by)
+	if err != nil {
+		return err
+	}
+	filter := usage.Filter{
+		AgentID:    opts.agent,
+		SessionKey: opts.session,
+		Channel:    opts.channel,
+		Model:      opts.model,
+	}
+	if opts.since != "" {
+		if filter.Since, err = internal.ParseSince(opts.since, time.Now()); err != nil {
+			return err
+		}
+	}
+	if opts.until != "" {
+		if filter.Until, err = internal.ParseUntil(opts.until); err != nil {
+			return err
+		}
+	}
+
+	path := usage.DefaultPath(cfg.WorkspacePath())
+	if _, err := os.Stat(path); err != nil {
+		fmt.Println("No usage recorded yet.")
+		return nil
+	}
+	ledger, err := usage.Open(path)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	totals, err := ledger.Totals(context.Background(), filter, group)
+	if err != nil {
+		return err
+	}
+	if len(totals) == 0 {
+		fmt.Println("No usage recorded for the selected period.")
+		return nil
+	}
+	printTotals(totals, string(group))
+	return nil
+}
+
+func printTotals(totals []usage.Total, group string) {
+	header := strings.ToUpper(group)
+	if header == "" {
+		header = "TOTAL"
+	}
+
+	var sum usage.Total
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tCALLS\tPROMPT\tCOMPLETION\tTOTAL\tCOST\t\n", header)
+	for _, t := range totals {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.4f\t\n",
+			t.Key, t.Calls, t.PromptTokens, t.CompletionTokens, t.TotalTokens(), t.Cost)
+		sum.Calls += t.Calls
+		sum.PromptTokens += t.PromptTokens
+		sum.CompletionTokens += t.CompletionTokens
+		sum.Cost += t.Cost
+	}
+	if len(totals) > 1 {
+		fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t%d\t%.4f\t\n",
+			sum.Calls, sum.PromptTokens, sum.CompletionTokens, sum.TotalTokens(), sum.Cost)
+	}
+	w.Flush()
+}
@@ -34,13 +34,16 @@ func TestNewPicoclawCommand(t *testing.T) {
 
 	allowedCommands := []string{
 		"agent",
+		"audit",
 		"auth",
 		"cron",
 		"gateway",
 		"migrate",
+		"models",
 		"onboard",
 		"skills",
 		"status",
+		"usage",
 		"version",
 	}
 
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// newApprovalManager creates the tool approval manager, sends its prompts
+// through the bus and installs it on every agent's tool registry. Decisions
+// are logged to state/approvals.jsonl in the workspace.
+func newApprovalManager(
+	cfg config.ApprovalConfig,
+	workspace string,
+	msgBus *bus.MessageBus,
+	registry *AgentRegistry,
+) *tools.ApprovalManager {
+	m := tools.NewApprovalManager(cfg, filepath.Join(workspace, "state", "approvals.jsonl"))
+	m.SetPromptFunc(func(ctx context.Context, channel, chatID, text string) error {
+		pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return msgBus.PublishOutbound(pubCtx, bus.OutboundMessage{
+			Channel: channel,
+			ChatID:  chatID,
+			Content: text,
+		})
+	})
+	for _, agentID := range registry.ListAgentIDs() {
+		if agent, ok := registry.GetAgent(agentID); ok {
+			agent.Tools.SetApprovalManager(m)
+		}
+	}
+	return m
+}
+
+// isApprovalCommand reports whether content is /approve or /deny. These are
+// handled as soon as they arrive, since the turn they decide is blocked.
+func isApprovalCommand(content string) bool {
+	cmd, _, _ := strings.Cut(strings.TrimSpace(content), " ")
+	return cmd == "/approve" || cmd == "/deny"
+}
+
+// approvalCommand handles "/approve <id>" and "/deny <id>".
+func (al *AgentLoop) approvalCommand(msg bus.InboundMessage, args []string, approve bool) string {
+	if al.approvals == nil {
+		return "Tool approval is not enabled"
+	}
+	cmd := "/deny"
+	if approve {
+		cmd = "/approve"
+	}
+
+	if len(args) != 1 {
+		pending := al.approvals.Pending(msg.Channel, msg.ChatID)
+		if len(pending) == 0 {
+			return fmt.Sprintf("Usage: %s <id>\nNo tool calls are waiting for approval.", cmd)
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Usage: %s <id>\nWaiting for approval:", cmd)
+		for _, req := range pending {
+			fmt.Fprintf(&sb, "\n- %s: %s", req.ID, req.Tool)
+		}
+		return sb.String()
+	}
+
+	sender := msg.Sender
+	if sender.PlatformID == "" {
+		sender.PlatformID = msg.SenderID
+	}
+	req, err := al.approvals.Resolve(args[0], approve, msg.Channel, msg.ChatID, sender)
+	if err != nil {
+		return fmt.Sprintf("Cannot decide %s: %v", args[0], err)
+	}
+	if approve {
+		return fmt.Sprintf("Approved %s, running %s.", req.ID, req.Tool)
+	}
+	return fmt.Sprintf("Denied %s, %s will not run.", req.ID, req.Tool)
+}
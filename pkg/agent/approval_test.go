@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestIsApprovalCommand(t *testing.T) {
+	for content, want := range map[string]bool{
+		"/approve ab12": true,
+		" /deny ab12 ":  true,
+		"/approve":      true,
+		"/approved":     false,
+		"please /deny":  false,
+	} {
+		if got := isApprovalCommand(content); got != want {
+			t.Errorf("isApprovalCommand(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
+
+func TestApprovalCommand_Disabled(t *testing.T) {
+	al := NewAgentLoop(newQuotaTestConfig(t, config.QuotaConfig{}), bus.NewMessageBus(), &mockProvider{})
+	got := al.approvalCommand(bus.InboundMessage{Channel: "telegram", ChatID: "chat1"}, []string{"ab12"}, true)
+	if got != "Tool approval is not enabled" {
+		t.Errorf("response = %q", got)
+	}
+}
+
+// TestRun_ToolApproval checks that a turn waiting for approval does not
+// block the /approve and /deny replies that decide it.
+func TestRun_ToolApproval(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{})
+	cfg.Quotas.Enabled = false
+	cfg.Tools.Approval = config.ApprovalConfig{
+		Enabled:        true,
+		Tools:          []string{"counting_tool"},
+		TimeoutSeconds: 10,
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &toolCallingProvider{})
+	tool := &countingTool{}
+	al.RegisterTool(tool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(ctx, bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "do things",
+	})
+
+	idPattern := regexp.MustCompile(`Approval required \[([0-9a-f]+)\]`)
+	var ids []string
+	for len(ids) < 3 {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatalf("timed out waiting for approval prompts, got %d", len(ids))
+		}
+		if m := idPattern.FindStringSubmatch(out.Content); m != nil {
+			ids = append(ids, m[1])
+		}
+	}
+
+	for i, id := range ids {
+		cmd := "/approve "
+		if i == 0 {
+			cmd = "/deny "
+		}
+		msgBus.PublishInbound(ctx, bus.InboundMessage{
+			Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: cmd + id,
+		})
+	}
+
+	var replies []string
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatalf("timed out waiting for the final response; replies: %q", replies)
+		}
+		if out.Content == "done" {
+			break
+		}
+		replies = append(replies, out.Content)
+	}
+
+	if n := tool.executions.Load(); n != 2 {
+		t.Errorf("tool executed %d times, want 2", n)
+	}
+	joined := strings.Join(replies, "\n")
+	if !strings.Contains(joined, "Denied "+ids[0]) || !strings.Contains(joined, "Approved "+ids[1]) {
+		t.Errorf("missing decision replies: %q", replies)
+	}
+}
+
+// TestRun_ApprovalWaitDoesNotBlockOtherChats checks that other chats are
+// answered while a turn waits for approval.
+func TestRun_ApprovalWaitDoesNotBlockOtherChats(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{})
+	cfg.Quotas.Enabled = false
+	cfg.Tools.Approval = config.ApprovalConfig{
+		Enabled:        true,
+		Tools:          []string{"counting_tool"},
+		TimeoutSeconds: 10,
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &routedProvider{reply: func(messages []providers.Message) (*providers.LLMResponse, error) {
+		last := messages[len(messages)-1]
+		switch {
+		case last.Role == "tool":
+			return &providers.LLMResponse{Content: "done"}, nil
+		case strings.Contains(last.Content, "do things"):
+			return &providers.LLMResponse{ToolCalls: []providers.ToolCall{
+				{ID: "a", Name: "counting_tool", Arguments: map[string]any{}},
+			}}, nil
+		default:
+			return &providers.LLMResponse{Content: "hi"}, nil
+		}
+	}}
+	al := NewAgentLoop(cfg, msgBus, provider)
+	tool := &countingTool{}
+	al.RegisterTool(tool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(ctx, bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "do things",
+	})
+	idPattern := regexp.MustCompile(`Approval required \[([0-9a-f]+)\]`)
+	var id string
+	for id == "" {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatal("timed out waiting for the approval prompt")
+		}
+		if m := idPattern.FindStringSubmatch(out.Content); m != nil {
+			id = m[1]
+		}
+	}
+
+	msgBus.PublishInbound(ctx, bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat2", SenderID: "43", Content: "hello",
+	})
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatal("timed out waiting for the other chat's reply")
+		}
+		if out.ChatID == "chat2" && out.Content == "hi" {
+			break
+		}
+	}
+
+	msgBus.PublishInbound(ctx, bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "/approve " + id,
+	})
+	for {
+		out, ok := msgBus.SubscribeOutbound(ctx)
+		if !ok {
+			t.Fatal("timed out waiting for the approved turn to finish")
+		}
+		if out.ChatID == "chat1" && out.Content == "done" {
+			break
+		}
+	}
+	if n := tool.executions.Load(); n != 1 {
+		t.Errorf("tool executed %d times, want 1", n)
+	}
+}
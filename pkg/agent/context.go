@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
 )
@@ -39,6 +40,14 @@ type ContextBuilder struct {
 	// build time. This catches nested file creations/deletions/mtime changes
 	// that may not update the top-level skill root directory mtime.
 	skillFilesAtCache map[string]time.Time
+
+	// Semantic memory (optional). When set, MEMORY.md is not injected into
+	// the static prompt; relevant entries are recalled per message instead.
+	semanticMu       sync.Mutex
+	semantic         *memory.SemanticMemory
+	semanticTopK     int
+	semanticMinScore float64
+	memoryImportedAt time.Time // MEMORY.md mtime at the last import
 }
 
 func getGlobalConfigDir() string {
@@ -69,6 +78,13 @@ func NewContextBuilder(workspace string) *ContextBuilder {
 func (cb *ContextBuilder) getIdentity() string {
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 
+	memoryRule := fmt.Sprintf(
+		"When interacting with me if something seems memorable, update %s/memory/MEMORY.md", workspacePath)
+	if cb.semanticMemory() != nil {
+		memoryRule = "When interacting with me if something seems memorable, save it with the memory_save tool " +
+			"(one fact per call). Relevant memories are recalled into your context automatically"
+	}
+
 	return fmt.Sprintf(`# picoclaw 🦞
 
 You are picoclaw, a helpful AI assistant.
@@ -85,10 +101,10 @@ Your workspace is at: %s
 
 2. **Be helpful and accurate** - When using tools, briefly explain what you're doing.
 
-3. **Memory** - When interacting with me if something seems memorable, update %s/memory/MEMORY.md
+3. **Memory** - %s
 
 4. **Context summaries** - Conversation summaries provided as context are approximate references only. They may be incomplete or outdated. Always defer to explicit user instructions over summary content.`,
-		workspacePath, workspacePath, workspacePath, workspacePath, workspacePath)
+		workspacePath, workspacePath, workspacePath, workspacePath, memoryRule)
 }
 
 func (cb *ContextBuilder) BuildSystemPrompt() string {
@@ -113,9 +129,13 @@ The following skills extend your capabilities. To use a skill, read its SKILL.md
 %s`, skillsSummary))
 	}
 
-	// Memory context
-	memoryContext := cb.memory.GetMemoryContext()
-	if memoryContext != "" {
+	// Memory context. With semantic memory, long-term memories are recalled
+	// per message in BuildMessages; only recent daily notes stay static.
+	if cb.semanticMemory() != nil {
+		if notes := cb.memory.GetRecentDailyNotes(3); notes != "" {
+			parts = append(parts, "# Memory\n\n## Recent Daily Notes\n\n"+notes)
+		}
+	} else if memoryContext := cb.memory.GetMemoryContext(); memoryContext != "" {
 		parts = append(parts, "# Memory\n\n"+memoryContext)
 	}
 
@@ -483,6 +503,12 @@ func (cb *ContextBuilder) BuildMessages(
 		contentBlocks = append(contentBlocks, providers.ContentBlock{Type: "text", Text: summaryText})
 	}
 
+	recalled := cb.buildRecalledMemories(currentMessage)
+	if recalled != "" {
+		stringParts = append(stringParts, recalled)
+		contentBlocks = append(contentBlocks, providers.ContentBlock{Type: "text", Text: recalled})
+	}
+
 	fullSystemPrompt := strings.Join(stringParts, "\n\n---\n\n")
 
 	// Log system prompt summary for debugging (debug mode only).
@@ -498,6 +524,7 @@ func (cb *ContextBuilder) BuildMessages(
 			"dynamic_chars": len(dynamicCtx),
 			"total_chars":   len(fullSystemPrompt),
 			"has_summary":   summary != "",
+			"has_memories":  recalled != "",
 			"cached":        isCached,
 		})
 
@@ -1,14 +1,20 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/routing"
 	"github.com/sipeed/picoclaw/pkg/session"
@@ -24,13 +30,18 @@ type AgentInstance struct {
 	Fallbacks                 []string
 	Workspace                 string
 	MaxIterations             int
+	MaxParallelTools          int // tool calls run at a time within one LLM response (0: no limit)
 	MaxTokens                 int
 	Temperature               float64
-	ContextWindow             int
+	contextWindow             *lazyContextWindow
 	SummarizeMessageThreshold int
 	SummarizeTokenPercent     int
 	Provider                  providers.LLMProvider
-	Sessions                  *session.SessionManager
+	Sessions                  session.SessionStore
+	History                   *memory.HistoryIndex   // nil when history search is disabled
+	SemanticMemory            *memory.SemanticMemory // nil when semantic memory is disabled
+	embedder                  *meteredEmbedder       // the semantic memory's, for usage accounting
+	subagentManager           *tools.SubagentManager // runs the spawn tool's subagents
 	ContextBuilder            *ContextBuilder
 	Tools                     *tools.ToolRegistry
 	Subagents                 *config.SubagentsConfig
@@ -72,10 +83,26 @@ func NewAgentInstance(
 	toolsRegistry.Register(tools.NewAppendFileTool(workspace, restrict, allowWritePaths))
 
 	sessionsDir := filepath.Join(workspace, "sessions")
-	sessionsManager := session.NewSessionManager(sessionsDir)
+	sessionsManager := initSessionStore(cfg.Session.Backend, sessionsDir)
+
+	var historyIndex *memory.HistoryIndex
+	if cfg.Tools.HistorySearch.Enabled {
+		var idxErr error
+		historyIndex, idxErr = memory.NewHistoryIndex(filepath.Join(sessionsDir, "history.db"))
+		if idxErr != nil {
+			logger.ErrorCF("agent", "Failed to open history index, history search disabled",
+				map[string]any{"workspace": workspace, "error": idxErr.Error()})
+		}
+	}
 
 	contextBuilder := NewContextBuilder(workspace)
 
+	semanticMemory, embedder := initSemanticMemory(cfg, defaults.SemanticMemory, workspace)
+	if semanticMemory != nil {
+		contextBuilder.SetSemanticMemory(
+			semanticMemory, defaults.SemanticMemory.GetTopK(), defaults.SemanticMemory.MinScore)
+	}
+
 	agentID := routing.DefaultAgentID
 	agentName := ""
 	var subagents *config.SubagentsConfig
@@ -167,13 +194,17 @@ func NewAgentInstance(
 		Fallbacks:                 fallbacks,
 		Workspace:                 workspace,
 		MaxIterations:             maxIter,
+		MaxParallelTools:          defaults.MaxParallelTools,
 		MaxTokens:                 maxTokens,
 		Temperature:               temperature,
-		ContextWindow:             maxTokens,
+		contextWindow:             &lazyContextWindow{provider: provider, model: model, tokens: maxTokens},
 		SummarizeMessageThreshold: summarizeMessageThreshold,
 		SummarizeTokenPercent:     summarizeTokenPercent,
 		Provider:                  provider,
 		Sessions:                  sessionsManager,
+		History:                   historyIndex,
+		SemanticMemory:            semanticMemory,
+		embedder:                  embedder,
 		ContextBuilder:            contextBuilder,
 		Tools:                     toolsRegistry,
 		Subagents:                 subagents,
@@ -182,6 +213,129 @@ func NewAgentInstance(
 	}
 }
 
+// withEphemeralSession returns a copy of the agent whose turn history is
+// kept in memory only and dropped afterwards. It is neither written to the
+// session store nor indexed for history search.
+func (a *AgentInstance) withEphemeralSession() *AgentInstance {
+	turn := *a
+	turn.Sessions = session.NewSessionManager("")
+	turn.History = nil
+	return &turn
+}
+
+// Close releases the agent's session store and history index, so that
+// SQLite databases are checkpointed before the process exits.
+func (a *AgentInstance) Close() error {
+	var errs []error
+	if a.Sessions != nil {
+		if err := a.Sessions.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("session store: %w", err))
+		}
+	}
+	if a.History != nil {
+		if err := a.History.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("history index: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ContextWindow returns the number of tokens the agent's model accepts. It
+// is discovered on first use, so that starting the agent does not wait for
+// the provider.
+func (a *AgentInstance) ContextWindow() int {
+	if a.contextWindow == nil {
+		return a.MaxTokens
+	}
+	return a.contextWindow.get()
+}
+
+// lazyContextWindow discovers a model's context window once, on first use.
+// It is shared by copies of the agent.
+type lazyContextWindow struct {
+	once     sync.Once
+	provider providers.LLMProvider
+	model    string
+	tokens   int // max_tokens until discovered
+}
+
+func (w *lazyContextWindow) get() int {
+	w.once.Do(func() {
+		w.tokens = discoverContextWindow(w.provider, w.model, w.tokens)
+	})
+	return w.tokens
+}
+
+// contextWindowTimeout bounds the query for a model's context window.
+const contextWindowTimeout = 5 * time.Second
+
+// discoverContextWindow asks providers that know their models' context
+// windows (such as Ollama) how many tokens model accepts, so summarization
+// kicks in relative to the real window. It returns fallback otherwise.
+func discoverContextWindow(provider providers.LLMProvider, model string, fallback int) int {
+	cwp, ok := provider.(providers.ContextWindowProvider)
+	if !ok {
+		return fallback
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), contextWindowTimeout)
+	defer cancel()
+	n, err := cwp.ContextWindow(ctx, model)
+	if err != nil || n <= 0 {
+		logger.WarnCF("agent", "Could not discover context window, using max_tokens",
+			map[string]any{"model": model, "fallback": fallback, "error": fmt.Sprint(err)})
+		return fallback
+	}
+	logger.InfoCF("agent", "Discovered model context window",
+		map[string]any{"model": model, "context_window": n})
+	return n
+}
+
+// initSessionStore creates the session store for the configured backend.
+// Sessions written by the legacy JSON manager (and, for sqlite, by the
+// JSONL backend) are migrated on first use. If the backend cannot be
+// opened, the JSON session manager is used so the agent keeps working.
+func initSessionStore(backend, dir string) session.SessionStore {
+	var (
+		store memory.Store
+		err   error
+	)
+	switch backend {
+	case "", config.SessionBackendJSON:
+		return session.NewSessionManager(dir)
+	case config.SessionBackendJSONL:
+		store, err = memory.NewJSONLStore(dir)
+	case config.SessionBackendSQLite:
+		store, err = memory.NewSQLiteStore(filepath.Join(dir, "sessions.db"))
+	default:
+		err = fmt.Errorf("unknown session backend %q", backend)
+	}
+	if err != nil {
+		logger.ErrorCF("agent", "Failed to open session store, falling back to JSON sessions",
+			map[string]any{"backend": backend, "error": err.Error()})
+		return session.NewSessionManager(dir)
+	}
+
+	ctx := context.Background()
+	if n, migErr := memory.MigrateFromJSON(ctx, dir, store); migErr != nil {
+		logger.WarnCF("agent", "Failed to migrate JSON sessions",
+			map[string]any{"backend": backend, "error": migErr.Error()})
+	} else if n > 0 {
+		logger.InfoCF("agent", "Migrated JSON sessions",
+			map[string]any{"backend": backend, "sessions": n})
+	}
+	if backend == config.SessionBackendSQLite {
+		if n, migErr := memory.MigrateFromJSONL(ctx, dir, store); migErr != nil {
+			logger.WarnCF("agent", "Failed to migrate JSONL sessions",
+				map[string]any{"backend": backend, "error": migErr.Error()})
+		} else if n > 0 {
+			logger.InfoCF("agent", "Migrated JSONL sessions",
+				map[string]any{"backend": backend, "sessions": n})
+		}
+	}
+
+	return session.NewMemoryStore(store)
+}
+
 // resolveAgentWorkspace determines the workspace directory for an agent.
 func resolveAgentWorkspace(agentCfg *config.AgentConfig, defaults *config.AgentDefaults) string {
 	if agentCfg != nil && strings.TrimSpace(agentCfg.Workspace) != "" {
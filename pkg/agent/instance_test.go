@@ -1,10 +1,14 @@
 package agent
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/session"
 )
 
 func TestNewAgentInstance_UsesDefaultsTemperatureAndMaxTokens(t *testing.T) {
@@ -39,6 +43,48 @@ func TestNewAgentInstance_UsesDefaultsTemperatureAndMaxTokens(t *testing.T) {
 	}
 }
 
+// contextWindowProvider reports a fixed context window per model.
+type contextWindowProvider struct {
+	mockProvider
+	windows map[string]int
+	calls   int
+}
+
+func (p *contextWindowProvider) ContextWindow(_ context.Context, model string) (int, error) {
+	p.calls++
+	if n, ok := p.windows[model]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown model %q", model)
+}
+
+func TestNewAgentInstance_DiscoversContextWindow(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace: t.TempDir(),
+				Model:     "qwen3:8b",
+				MaxTokens: 4096,
+			},
+		},
+	}
+	provider := &contextWindowProvider{windows: map[string]int{"qwen3:8b": 40960}}
+
+	agent := NewAgentInstance(nil, &cfg.Agents.Defaults, cfg, provider)
+	if provider.calls != 0 {
+		t.Fatal("the context window should be discovered on first use, not at startup")
+	}
+	if agent.ContextWindow() != 40960 {
+		t.Fatalf("ContextWindow = %d, want the discovered 40960", agent.ContextWindow())
+	}
+
+	cfg.Agents.Defaults.Model = "other"
+	agent = NewAgentInstance(nil, &cfg.Agents.Defaults, cfg, provider)
+	if agent.ContextWindow() != 4096 {
+		t.Fatalf("ContextWindow = %d, want max_tokens when discovery fails", agent.ContextWindow())
+	}
+}
+
 func TestNewAgentInstance_DefaultsTemperatureWhenZero(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "agent-instance-test-*")
 	if err != nil {
@@ -68,6 +114,25 @@ func TestNewAgentInstance_DefaultsTemperatureWhenZero(t *testing.T) {
 	}
 }
 
+func TestNewAgentInstance_ZeroMaxParallelToolsMeansNoLimit(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxToolIterations: 5,
+			},
+		},
+	}
+
+	agent := NewAgentInstance(nil, &cfg.Agents.Defaults, cfg, &mockProvider{})
+
+	// Like tools.ExecuteToolCalls and subagents, the agent treats 0 as no limit.
+	if agent.MaxParallelTools != 0 {
+		t.Fatalf("MaxParallelTools = %d, want 0", agent.MaxParallelTools)
+	}
+}
+
 func TestNewAgentInstance_DefaultsTemperatureWhenUnset(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "agent-instance-test-*")
 	if err != nil {
@@ -160,3 +225,81 @@ func TestNewAgentInstance_ResolveCandidatesFromModelListAlias(t *testing.T) {
 		})
 	}
 }
+
+func TestInitSessionStore_Backends(t *testing.T) {
+	tests := []struct {
+		backend    string
+		wantMemory bool
+	}{
+		{backend: "", wantMemory: false},
+		{backend: config.SessionBackendJSON, wantMemory: false},
+		{backend: config.SessionBackendJSONL, wantMemory: true},
+		{backend: config.SessionBackendSQLite, wantMemory: true},
+		{backend: "bogus", wantMemory: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			store := initSessionStore(tt.backend, t.TempDir())
+			defer store.Close()
+			_, isMemory := store.(*session.MemoryStore)
+			if isMemory != tt.wantMemory {
+				t.Fatalf("initSessionStore(%q) = %T, want memory-backed %v", tt.backend, store, tt.wantMemory)
+			}
+		})
+	}
+}
+
+func TestAgentInstance_CloseCheckpointsSQLite(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxToolIterations: 5,
+			},
+		},
+		Session: config.SessionConfig{Backend: config.SessionBackendSQLite},
+	}
+	cfg.Tools.HistorySearch.Enabled = true
+
+	agent := NewAgentInstance(nil, &cfg.Agents.Defaults, cfg, &mockProvider{})
+	if agent.History == nil {
+		t.Fatal("history index should be open")
+	}
+	agent.Sessions.AddMessage("telegram:42", "user", "hello")
+	if err := agent.Sessions.Save("telegram:42"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := agent.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	sessionsDir := filepath.Join(cfg.Agents.Defaults.Workspace, "sessions")
+	for _, name := range []string{"sessions.db-wal", "history.db-wal"} {
+		if _, err := os.Stat(filepath.Join(sessionsDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be checkpointed and removed on close, stat error = %v", name, err)
+		}
+	}
+}
+
+func TestInitSessionStore_MigratesJSONSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	legacy := session.NewSessionManager(dir)
+	legacy.AddMessage("telegram:42", "user", "hello")
+	legacy.SetSummary("telegram:42", "greeting")
+	if err := legacy.Save("telegram:42"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	store := initSessionStore(config.SessionBackendSQLite, dir)
+	defer store.Close()
+
+	history := store.GetHistory("telegram:42")
+	if len(history) != 1 || history[0].Content != "hello" {
+		t.Fatalf("unexpected migrated history: %+v", history)
+	}
+	if got := store.GetSummary("telegram:42"); got != "greeting" {
+		t.Fatalf("GetSummary = %q, want %q", got, "greeting")
+	}
+}
@@ -18,19 +18,26 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/sipeed/picoclaw/pkg/audit"
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/egress"
+	"github.com/sipeed/picoclaw/pkg/identity"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/mcp"
 	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/quota"
 	"github.com/sipeed/picoclaw/pkg/routing"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/utils"
+	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
 type AgentLoop struct {
@@ -43,19 +50,32 @@ type AgentLoop struct {
 	fallback       *providers.FallbackChain
 	channelManager *channels.Manager
 	mediaStore     media.MediaStore
+	ledger         *usage.Ledger // nil if the usage ledger could not be opened
+	prices         *usage.PriceTable
+	quotas         *quota.Manager         // nil when quotas are disabled
+	approvals      *tools.ApprovalManager // nil when tool approval is disabled
+	auditLog       *audit.Logger          // nil when the tool audit log is disabled
+	transcriber    voice.Transcriber      // nil when voice transcription is unavailable
+	synthesizer    voice.Synthesizer      // nil when voice replies are unavailable
+	router         *ModelRouter           // nil when model routing is disabled
 }
 
 // processOptions configures how a message is processed
 type processOptions struct {
-	SessionKey      string   // Session identifier for history/context
-	Channel         string   // Target channel for tool execution
-	ChatID          string   // Target chat ID for tool execution
-	UserMessage     string   // User message content (may include prefix)
-	Media           []string // media:// refs from inbound message
-	DefaultResponse string   // Response when LLM returns empty
-	EnableSummary   bool     // Whether to trigger summarization
-	SendResponse    bool     // Whether to send response via bus
-	NoHistory       bool     // If true, don't load session history (for heartbeat)
+	SessionKey      string        // Session identifier for history/context
+	Channel         string        // Target channel for tool execution
+	ChatID          string        // Target chat ID for tool execution
+	UserMessage     string        // User message content (may include prefix)
+	Media           []string      // media:// refs from inbound message
+	DefaultResponse string        // Response when LLM returns empty
+	EnableSummary   bool          // Whether to trigger summarization
+	SendResponse    bool          // Whether to send response via bus
+	NoHistory       bool          // If true, don't load session history (for heartbeat)
+	Quota           quota.Subject // Who LLM tokens are charged to (zero: not limited)
+	MaxToolCalls    int           // Tool executions allowed in this turn (0: unlimited)
+	Sender          string        // Canonical sender ID, recorded in the tool audit log
+	ModelHint       string        // Model named by an explicit "/model <name>" prefix
+	Route           RouteDecision // Model chosen for this turn (zero: the agent's model)
 }
 
 const defaultResponse = "I've completed processing but have no response to give. Increase `max_tool_iterations` in config.json."
@@ -77,18 +97,105 @@ func NewAgentLoop(
 	// Create state manager using default agent's workspace for channel recording
 	defaultAgent := registry.GetDefaultAgent()
 	var stateManager *state.Manager
+	var ledger *usage.Ledger
+	var quotas *quota.Manager
+	var approvals *tools.ApprovalManager
 	if defaultAgent != nil {
 		stateManager = state.NewManager(defaultAgent.Workspace)
+
+		var err error
+		ledger, err = usage.Open(usage.DefaultPath(defaultAgent.Workspace))
+		if err != nil {
+			logger.ErrorCF("agent", "Failed to open usage ledger, token usage will not be recorded",
+				map[string]any{"error": err.Error()})
+		}
+
+		if cfg.Quotas.Enabled {
+			quotas = quota.NewManager(quota.DefaultPath(defaultAgent.Workspace), cfg.Quotas)
+		}
+
+		if cfg.Tools.Approval.Enabled {
+			approvals = newApprovalManager(cfg.Tools.Approval, defaultAgent.Workspace, msgBus, registry)
+		}
+	}
+
+	var auditLog *audit.Logger
+	if cfg.Tools.Audit.Enabled {
+		var err error
+		auditLog, err = audit.Open(cfg.AuditLogPath(), cfg.Tools.Audit.MaxSizeMB, cfg.Tools.Audit.MaxBackups)
+		if err != nil {
+			logger.ErrorCF("agent", "Failed to open tool audit log, tool calls will not be audited",
+				map[string]any{"path": cfg.AuditLogPath(), "error": err.Error()})
+		} else {
+			for _, agentID := range registry.ListAgentIDs() {
+				if agent, ok := registry.GetAgent(agentID); ok {
+					agent.Tools.SetAuditLog(auditLog)
+				}
+			}
+		}
 	}
 
-	return &AgentLoop{
+	transcriber, err := voice.NewTranscriber(cfg)
+	if err != nil {
+		logger.ErrorCF("agent", "Failed to set up voice transcription, voice messages will not be transcribed",
+			map[string]any{"error": err.Error()})
+	}
+
+	synthesizer, err := voice.NewSynthesizer(cfg)
+	if err != nil {
+		logger.ErrorCF("agent", "Failed to set up speech synthesis, replies will be sent as text",
+			map[string]any{"error": err.Error()})
+	}
+
+	al := &AgentLoop{
 		bus:         msgBus,
 		cfg:         cfg,
 		registry:    registry,
 		state:       stateManager,
 		summarizing: sync.Map{},
 		fallback:    fallbackChain,
+		ledger:      ledger,
+		prices:      usage.NewPriceTable(cfg.ModelList),
+		quotas:      quotas,
+		approvals:   approvals,
+		auditLog:    auditLog,
+		transcriber: transcriber,
+		synthesizer: synthesizer,
+		router:      NewModelRouter(cfg),
 	}
+
+	for _, agentID := range registry.ListAgentIDs() {
+		agent, ok := registry.GetAgent(agentID)
+		if !ok {
+			continue
+		}
+		// Record the embedding calls of semantic memory in the usage ledger.
+		if agent.embedder != nil {
+			agent.embedder.setRecorder(func(sessionKey, provider, model string, u *providers.UsageInfo) {
+				al.recordUsage(agent, sessionKey, "", usage.KindEmbedding, provider, model,
+					&providers.LLMResponse{Usage: u})
+			})
+		}
+		// Charge subagent turns to whoever made the agent spawn them.
+		if agent.subagentManager != nil {
+			agent.subagentManager.SetUsageHook(
+				func(ctx context.Context, messages []providers.Message, resp *providers.LLMResponse) {
+					al.chargeQuota(quotaSubjectFrom(ctx), messages, resp)
+				})
+		}
+	}
+	return al
+}
+
+// newEgressPolicy builds the outbound HTTP policy for tools. An invalid
+// config falls back to the default policy rather than allowing everything.
+func newEgressPolicy(cfg *config.Config) *egress.Policy {
+	policy, err := egress.NewPolicy(cfg.Tools.Egress)
+	if err != nil {
+		logger.ErrorCF("agent", "Invalid egress policy, using defaults", map[string]any{"error": err.Error()})
+		return egress.DefaultPolicy()
+	}
+	return policy
 }
 
 // registerSharedTools registers tools that are shared across all agents (web, message, spawn).
@@ -98,6 +205,8 @@ func registerSharedTools(
 	registry *AgentRegistry,
 	provider providers.LLMProvider,
 ) {
+	egressPolicy := newEgressPolicy(cfg)
+
 	for _, agentID := range registry.ListAgentIDs() {
 		agent, ok := registry.GetAgent(agentID)
 		if !ok {
@@ -130,7 +239,12 @@ func registerSharedTools(
 		} else if searchTool != nil {
 			agent.Tools.Register(searchTool)
 		}
-		fetchTool, err := tools.NewWebFetchToolWithProxy(50000, cfg.Tools.Web.Proxy, cfg.Tools.Web.FetchLimitBytes)
+		fetchTool, err := tools.NewWebFetchToolWithOptions(tools.WebFetchToolOptions{
+			MaxChars:        50000,
+			Proxy:           cfg.Tools.Web.Proxy,
+			FetchLimitBytes: cfg.Tools.Web.FetchLimitBytes,
+			Egress:          egressPolicy,
+		})
 		if err != nil {
 			logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 		} else {
@@ -141,6 +255,19 @@ func registerSharedTools(
 		agent.Tools.Register(tools.NewI2CTool())
 		agent.Tools.Register(tools.NewSPITool())
 
+		// Conversation history search
+		if agent.History != nil {
+			agent.Tools.Register(tools.NewHistorySearchTool(agent.History,
+				cfg.Tools.HistorySearch.MaxResults, cfg.Tools.HistorySearch.AllowCrossChat))
+		}
+
+		// Semantic long-term memory
+		if agent.SemanticMemory != nil {
+			smCfg := cfg.Agents.Defaults.SemanticMemory
+			agent.Tools.Register(tools.NewMemorySaveTool(agent.SemanticMemory))
+			agent.Tools.Register(tools.NewMemoryRecallTool(agent.SemanticMemory, smCfg.GetTopK(), smCfg.MinScore))
+		}
+
 		// Message tool
 		messageTool := tools.NewMessageTool()
 		messageTool.SetSendCallback(func(channel, chatID, content string) error {
@@ -158,6 +285,7 @@ func registerSharedTools(
 		registryMgr := skills.NewRegistryManagerFromConfig(skills.RegistryConfig{
 			MaxConcurrentSearches: cfg.Tools.Skills.MaxConcurrentSearches,
 			ClawHub:               skills.ClawHubConfig(cfg.Tools.Skills.Registries.ClawHub),
+			Egress:                egressPolicy,
 		})
 		searchCache := skills.NewSearchCache(
 			cfg.Tools.Skills.SearchCache.MaxSize,
@@ -169,6 +297,8 @@ func registerSharedTools(
 		// Spawn tool with allowlist checker
 		subagentManager := tools.NewSubagentManager(provider, agent.Model, agent.Workspace, msgBus)
 		subagentManager.SetLLMOptions(agent.MaxTokens, agent.Temperature)
+		subagentManager.SetMaxParallelTools(agent.MaxParallelTools)
+		agent.subagentManager = subagentManager
 		spawnTool := tools.NewSpawnTool(subagentManager)
 		currentAgentID := agentID
 		spawnTool.SetAllowlistChecker(func(targetAgentID string) bool {
@@ -184,6 +314,7 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 	// Initialize MCP servers for all agents
 	if al.cfg.Tools.MCP.Enabled {
 		mcpManager := mcp.NewManager()
+		mcpManager.SetEgressPolicy(newEgressPolicy(al.cfg))
 		// Ensure MCP connections are cleaned up on exit, regardless of initialization success
 		// This fixes resource leak when LoadFromMCPConfig partially succeeds then fails
 		defer func() {
@@ -247,6 +378,12 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 		}
 	}
 
+	// Turns run on the scheduler so that the loop keeps receiving /approve
+	// and /deny, and other chats are served, while a tool call waits for a
+	// decision.
+	turns := newTurnScheduler(al.handleInbound)
+	defer turns.wait()
+
 	for al.running.Load() {
 		select {
 		case <-ctx.Done():
@@ -257,71 +394,116 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			// Process message
-			func() {
-				// TODO: Re-enable media cleanup after inbound media is properly consumed by the agent.
-				// Currently disabled because files are deleted before the LLM can access their content.
-				// defer func() {
-				// 	if al.mediaStore != nil && msg.MediaScope != "" {
-				// 		if releaseErr := al.mediaStore.ReleaseAll(msg.MediaScope); releaseErr != nil {
-				// 			logger.WarnCF("agent", "Failed to release media", map[string]any{
-				// 				"scope": msg.MediaScope,
-				// 				"error": releaseErr.Error(),
-				// 			})
-				// 		}
-				// 	}
-				// }()
-
-				response, err := al.processMessage(ctx, msg)
-				if err != nil {
-					response = fmt.Sprintf("Error processing message: %v", err)
+			if isApprovalCommand(msg.Content) {
+				if response, handled := al.handleCommand(ctx, msg); handled && response != "" {
+					al.bus.PublishOutbound(ctx, bus.OutboundMessage{
+						Channel: msg.Channel,
+						ChatID:  msg.ChatID,
+						Content: response,
+						Final:   true,
+					})
 				}
+				continue
+			}
 
-				if response != "" {
-					// Check if the message tool already sent a response during this round.
-					// If so, skip publishing to avoid duplicate messages to the user.
-					// Use default agent's tools to check (message tool is shared).
-					alreadySent := false
-					defaultAgent := al.registry.GetDefaultAgent()
-					if defaultAgent != nil {
-						if tool, ok := defaultAgent.Tools.Get("message"); ok {
-							if mt, ok := tool.(*tools.MessageTool); ok {
-								alreadySent = mt.HasSentInRound()
-							}
-						}
-					}
-
-					if !alreadySent {
-						al.bus.PublishOutbound(ctx, bus.OutboundMessage{
-							Channel: msg.Channel,
-							ChatID:  msg.ChatID,
-							Content: response,
-						})
-						logger.InfoCF("agent", "Published outbound response",
-							map[string]any{
-								"channel":     msg.Channel,
-								"chat_id":     msg.ChatID,
-								"content_len": len(response),
-							})
-					} else {
-						logger.DebugCF(
-							"agent",
-							"Skipped outbound (message tool already sent)",
-							map[string]any{"channel": msg.Channel},
-						)
-					}
-				}
-			}()
+			if !turns.submit(ctx, msg) {
+				return nil
+			}
 		}
 	}
 
 	return nil
 }
 
+// handleInbound processes one inbound message and publishes the response.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	// TODO: Re-enable media cleanup after inbound media is properly consumed by the agent.
+	// Currently disabled because files are deleted before the LLM can access their content.
+	// defer func() {
+	// 	if al.mediaStore != nil && msg.MediaScope != "" {
+	// 		if releaseErr := al.mediaStore.ReleaseAll(msg.MediaScope); releaseErr != nil {
+	// 			logger.WarnCF("agent", "Failed to release media", map[string]any{
+	// 				"scope": msg.MediaScope,
+	// 				"error": releaseErr.Error(),
+	// 			})
+	// 		}
+	// 	}
+	// }()
+
+	response, err := al.processMessage(ctx, msg)
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	// The final reply is published even when there is nothing left to say,
+	// so that channels answering one request per turn know it is over.
+	out := replyTo(msg, response)
+	out.Final = true
+	if response != "" {
+		// Check if the message tool already sent a response during this round.
+		// If so, skip publishing to avoid duplicate messages to the user.
+		// Use default agent's tools to check (message tool is shared).
+		alreadySent := false
+		defaultAgent := al.registry.GetDefaultAgent()
+		if defaultAgent != nil {
+			if tool, ok := defaultAgent.Tools.Get("message"); ok {
+				if mt, ok := tool.(*tools.MessageTool); ok {
+					alreadySent = mt.HasSentInRound()
+				}
+			}
+		}
+
+		if alreadySent {
+			logger.DebugCF(
+				"agent",
+				"Skipped outbound (message tool already sent)",
+				map[string]any{"channel": msg.Channel},
+			)
+			out.Content = ""
+		} else if err == nil && al.sendVoiceReply(ctx, msg, response) {
+			out.Content = ""
+		}
+	}
+	al.bus.PublishOutbound(ctx, out)
+	if out.Content != "" {
+		logger.InfoCF("agent", "Published outbound response",
+			map[string]any{
+				"channel":     msg.Channel,
+				"chat_id":     msg.ChatID,
+				"content_len": len(out.Content),
+			})
+	}
+}
+
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
 }
 
+// Close releases files held by the loop. Call it after Stop, once no more
+// messages are being processed.
+func (al *AgentLoop) Close() {
+	if al.auditLog != nil {
+		if err := al.auditLog.Close(); err != nil {
+			logger.ErrorCF("agent", "Failed to close tool audit log", map[string]any{"error": err.Error()})
+		}
+	}
+	if al.ledger != nil {
+		if err := al.ledger.Close(); err != nil {
+			logger.ErrorCF("agent", "Failed to close usage ledger", map[string]any{"error": err.Error()})
+		}
+	}
+	for _, agentID := range al.registry.ListAgentIDs() {
+		agent, ok := al.registry.GetAgent(agentID)
+		if !ok {
+			continue
+		}
+		if err := agent.Close(); err != nil {
+			logger.ErrorCF("agent", "Failed to close agent sessions",
+				map[string]any{"agent_id": agentID, "error": err.Error()})
+		}
+	}
+}
+
 func (al *AgentLoop) RegisterTool(tool tools.Tool) {
 	for _, agentID := range al.registry.ListAgentIDs() {
 		if agent, ok := al.registry.GetAgent(agentID); ok {
@@ -334,6 +516,18 @@ func (al *AgentLoop) SetChannelManager(cm *channels.Manager) {
 	al.channelManager = cm
 }
 
+// SetTranscriber replaces the speech-to-text backend for voice messages.
+// A nil transcriber leaves audio untranscribed.
+func (al *AgentLoop) SetTranscriber(t voice.Transcriber) {
+	al.transcriber = t
+}
+
+// SetSynthesizer replaces the text-to-speech backend for voice replies.
+// A nil synthesizer sends all replies as text.
+func (al *AgentLoop) SetSynthesizer(s voice.Synthesizer) {
+	al.synthesizer = s
+}
+
 // SetMediaStore injects a MediaStore for media lifecycle management.
 func (al *AgentLoop) SetMediaStore(s media.MediaStore) {
 	al.mediaStore = s
@@ -461,21 +655,18 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 	}
 
 	// Route to determine agent and session key
-	route := al.registry.ResolveRoute(routing.RouteInput{
-		Channel:    msg.Channel,
-		AccountID:  msg.Metadata["account_id"],
-		Peer:       extractPeer(msg),
-		ParentPeer: extractParentPeer(msg),
-		GuildID:    msg.Metadata["guild_id"],
-		TeamID:     msg.Metadata["team_id"],
-	})
-
-	agent, ok := al.registry.GetAgent(route.AgentID)
-	if !ok {
-		agent = al.registry.GetDefaultAgent()
+	agent, sessionKey, route, err := al.routeMessage(msg)
+	if err != nil {
+		return "", err
 	}
-	if agent == nil {
-		return "", fmt.Errorf("no agent available for route (agent_id=%s)", route.AgentID)
+	if msg.Metadata[bus.MetadataEphemeral] == "true" {
+		agent = agent.withEphemeralSession()
+	}
+
+	// Enforce quotas before any provider call.
+	quotaSubject, maxToolCalls, refusal := al.checkQuota(msg)
+	if refusal != "" {
+		return refusal, nil
 	}
 
 	// Reset message-tool state for this round so we don't skip publishing due to a previous round.
@@ -485,12 +676,6 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		}
 	}
 
-	// Use routed session key, but honor pre-set agent-scoped keys (for ProcessDirect/cron)
-	sessionKey := route.SessionKey
-	if msg.SessionKey != "" && strings.HasPrefix(msg.SessionKey, "agent:") {
-		sessionKey = msg.SessionKey
-	}
-
 	logger.InfoCF("agent", "Routed message",
 		map[string]any{
 			"agent_id":    agent.ID,
@@ -498,18 +683,70 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 			"matched_by":  route.MatchedBy,
 		})
 
+	// An explicit "/model <name>" prefix picks the model for this turn.
+	content := msg.Content
+	var modelHint string
+	if al.router != nil {
+		modelHint, content, _ = parseModelHint(content)
+	}
+
+	// Voice messages reach the model as text.
+	content, mediaRefs := al.transcribeAudio(ctx, content, msg.Media)
+	content = withQuotedParent(msg, content)
+
 	return al.runAgentLoop(ctx, agent, processOptions{
 		SessionKey:      sessionKey,
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
-		UserMessage:     msg.Content,
-		Media:           msg.Media,
+		UserMessage:     content,
+		Media:           mediaRefs,
 		DefaultResponse: defaultResponse,
 		EnableSummary:   true,
 		SendResponse:    false,
+		Quota:           quotaSubject,
+		MaxToolCalls:    maxToolCalls,
+		Sender:          senderCanonicalID(msg),
+		ModelHint:       modelHint,
 	})
 }
 
+// routeMessage resolves the agent and session key that handle msg.
+func (al *AgentLoop) routeMessage(msg bus.InboundMessage) (*AgentInstance, string, routing.ResolvedRoute, error) {
+	route := al.registry.ResolveRoute(routing.RouteInput{
+		Channel:    msg.Channel,
+		AccountID:  msg.Metadata["account_id"],
+		Peer:       extractPeer(msg),
+		ParentPeer: extractParentPeer(msg),
+		GuildID:    msg.Metadata["guild_id"],
+		TeamID:     msg.Metadata["team_id"],
+	})
+
+	agent, ok := al.registry.GetAgent(route.AgentID)
+	if !ok {
+		agent = al.registry.GetDefaultAgent()
+	}
+	if agent == nil {
+		return nil, "", route, fmt.Errorf("no agent available for route (agent_id=%s)", route.AgentID)
+	}
+
+	// Use routed session key, but honor pre-set agent-scoped keys (for ProcessDirect/cron).
+	// A pre-set key of the message's own channel ("agent:<id>:<channel>:...")
+	// also selects its agent when that agent is registered, which lets channels
+	// such as the OpenAI-compatible API address agents directly.
+	sessionKey := route.SessionKey
+	if msg.SessionKey != "" && strings.HasPrefix(msg.SessionKey, "agent:") {
+		sessionKey = msg.SessionKey
+		parsed := routing.ParseAgentSessionKey(msg.SessionKey)
+		if parsed != nil && msg.Channel != "" && strings.HasPrefix(parsed.Rest, msg.Channel+":") {
+			if keyed, ok := al.registry.GetAgent(parsed.AgentID); ok {
+				agent = keyed
+				route.AgentID = keyed.ID
+			}
+		}
+	}
+	return agent, sessionKey, route, nil
+}
+
 func (al *AgentLoop) processSystemMessage(
 	ctx context.Context,
 	msg bus.InboundMessage,
@@ -619,8 +856,14 @@ func (al *AgentLoop) runAgentLoop(
 	maxMediaSize := al.cfg.Agents.Defaults.GetMaxMediaSize()
 	messages = resolveMediaRefs(messages, al.mediaStore, maxMediaSize)
 
+	// Pick the model that serves this turn
+	if al.router != nil {
+		opts.Route = al.routeTurn(ctx, agent, opts, history)
+	}
+
 	// 3. Save user message to session
 	agent.Sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
+	al.recordHistory(ctx, agent, opts, "user", opts.UserMessage)
 
 	// 4. Run LLM iteration loop
 	finalContent, iteration, err := al.runLLMIteration(ctx, agent, messages, opts)
@@ -639,10 +882,11 @@ func (al *AgentLoop) runAgentLoop(
 	// 6. Save final assistant message to session
 	agent.Sessions.AddMessage(opts.SessionKey, "assistant", finalContent)
 	agent.Sessions.Save(opts.SessionKey)
+	al.recordHistory(ctx, agent, opts, "assistant", finalContent)
 
 	// 7. Optional: summarization
 	if opts.EnableSummary {
-		al.maybeSummarize(agent, opts.SessionKey, opts.Channel, opts.ChatID)
+		al.maybeSummarize(agent, opts.SessionKey, opts.Channel, opts.ChatID, opts.Quota)
 	}
 
 	// 8. Optional: send response via bus
@@ -667,6 +911,32 @@ func (al *AgentLoop) runAgentLoop(
 	return finalContent, nil
 }
 
+// recordHistory adds a message to the agent's searchable history index.
+// Heartbeat turns (NoHistory) are not recorded.
+func (al *AgentLoop) recordHistory(
+	ctx context.Context,
+	agent *AgentInstance,
+	opts processOptions,
+	role, content string,
+) {
+	if agent.History == nil || opts.NoHistory {
+		return
+	}
+	if err := agent.History.Add(ctx, memory.HistoryEntry{
+		SessionKey: opts.SessionKey,
+		Channel:    opts.Channel,
+		ChatID:     opts.ChatID,
+		Role:       role,
+		Content:    content,
+	}); err != nil {
+		logger.WarnCF("agent", "Failed to index message for history search", map[string]any{
+			"agent_id":    agent.ID,
+			"session_key": opts.SessionKey,
+			"error":       err.Error(),
+		})
+	}
+}
+
 func (al *AgentLoop) targetReasoningChannelID(channelName string) (chatID string) {
 	if al.channelManager == nil {
 		return ""
@@ -731,16 +1001,54 @@ func (al *AgentLoop) runLLMIteration(
 	opts processOptions,
 ) (string, int, error) {
 	iteration := 0
+	toolCalls := 0
 	var finalContent string
 
-	for iteration < agent.MaxIterations {
+	// Stream partial replies into the channel's placeholder when possible.
+	streamer := al.newStreamPublisher(ctx, opts)
+
+	// The model serving the turn: the agent's own unless the router picked
+	// another. Once per turn, a failing or looping model is replaced by the
+	// escalation model, which gets a fresh iteration budget.
+	current := agentTurnModel(agent)
+	if opts.Route.target != nil {
+		current = opts.Route.target
+	}
+	maxIterations := agent.MaxIterations
+	escalated := false
+	escalate := func(why string) bool {
+		if escalated {
+			return false
+		}
+		next := al.router.escalation(agent, current)
+		if next == nil {
+			return false
+		}
+		logger.WarnCF("agent", "Escalating turn to a stronger model",
+			map[string]any{
+				"agent_id":  agent.ID,
+				"from":      current.name,
+				"to":        next.name,
+				"reason":    why,
+				"iteration": iteration,
+			})
+		opts.Route.Model = next.name
+		opts.Route.Reason = fmt.Sprintf("escalated from %s: %s", current.name, why)
+		opts.Route.target = next
+		al.router.record(opts.SessionKey, opts.Route)
+		current, escalated = next, true
+		maxIterations = iteration + agent.MaxIterations
+		return true
+	}
+
+	for iteration < maxIterations {
 		iteration++
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]any{
 				"agent_id":  agent.ID,
 				"iteration": iteration,
-				"max":       agent.MaxIterations,
+				"max":       maxIterations,
 			})
 
 		// Build tool definitions
@@ -751,7 +1059,7 @@ func (al *AgentLoop) runLLMIteration(
 			map[string]any{
 				"agent_id":          agent.ID,
 				"iteration":         iteration,
-				"model":             agent.Model,
+				"model":             current.model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
 				"max_tokens":        agent.MaxTokens,
@@ -771,28 +1079,33 @@ func (al *AgentLoop) runLLMIteration(
 		var response *providers.LLMResponse
 		var err error
 
+		llmOpts := map[string]any{
+			"max_tokens":       agent.MaxTokens,
+			"temperature":      agent.Temperature,
+			"prompt_cache_key": agent.ID,
+		}
+
+		// The provider/model that actually served the call, for the usage ledger.
+		// Candidates carry resolved model IDs, the same key the fallback
+		// chain reports, so a model is recorded under one name either way.
+		usedProvider, usedModel := "", current.model
+		if len(current.candidates) > 0 {
+			usedProvider, usedModel = current.candidates[0].Provider, current.candidates[0].Model
+		}
+
 		callLLM := func() (*providers.LLMResponse, error) {
-			if len(agent.Candidates) > 1 && al.fallback != nil {
+			if len(current.candidates) > 1 && al.fallback != nil {
 				fbResult, fbErr := al.fallback.Execute(
 					ctx,
-					agent.Candidates,
+					current.candidates,
 					func(ctx context.Context, provider, model string) (*providers.LLMResponse, error) {
-						return agent.Provider.Chat(
-							ctx,
-							messages,
-							providerToolDefs,
-							model,
-							map[string]any{
-								"max_tokens":       agent.MaxTokens,
-								"temperature":      agent.Temperature,
-								"prompt_cache_key": agent.ID,
-							},
-						)
+						return streamer.chat(ctx, current.provider, messages, providerToolDefs, model, llmOpts)
 					},
 				)
 				if fbErr != nil {
 					return nil, fbErr
 				}
+				usedProvider, usedModel = fbResult.Provider, fbResult.Model
 				if fbResult.Provider != "" && len(fbResult.Attempts) > 0 {
 					logger.InfoCF(
 						"agent",
@@ -803,11 +1116,7 @@ func (al *AgentLoop) runLLMIteration(
 				}
 				return fbResult.Response, nil
 			}
-			return agent.Provider.Chat(ctx, messages, providerToolDefs, agent.Model, map[string]any{
-				"max_tokens":       agent.MaxTokens,
-				"temperature":      agent.Temperature,
-				"prompt_cache_key": agent.ID,
-			})
+			return streamer.chat(ctx, current.provider, messages, providerToolDefs, current.model, llmOpts)
 		}
 
 		// Retry loop for context/token errors
@@ -884,11 +1193,18 @@ func (al *AgentLoop) runLLMIteration(
 				map[string]any{
 					"agent_id":  agent.ID,
 					"iteration": iteration,
+					"model":     current.name,
 					"error":     err.Error(),
 				})
+			if ctx.Err() == nil && escalate(fmt.Sprintf("%s failed", current.name)) {
+				continue
+			}
 			return "", iteration, fmt.Errorf("LLM call failed after retries: %w", err)
 		}
 
+		al.recordUsage(agent, opts.SessionKey, opts.Channel, usage.KindChat, usedProvider, usedModel, response)
+		al.chargeQuota(opts.Quota, messages, response)
+
 		go al.handleReasoning(
 			ctx,
 			response.Reasoning,
@@ -969,22 +1285,21 @@ func (al *AgentLoop) runLLMIteration(
 		// Save assistant message with tool calls to session
 		agent.Sessions.AddFullMessage(opts.SessionKey, assistantMsg)
 
-		// Execute tool calls in parallel
-		type indexedAgentResult struct {
-			result *tools.ToolResult
-			tc     providers.ToolCall
+		// Execute tool calls in parallel. Calls beyond the per-turn tool
+		// limit are not run.
+		runnable := normalizedToolCalls
+		if opts.MaxToolCalls > 0 && len(runnable) > opts.MaxToolCalls-toolCalls {
+			runnable = runnable[:max(opts.MaxToolCalls-toolCalls, 0)]
 		}
-
-		agentResults := make([]indexedAgentResult, len(normalizedToolCalls))
-		var wg sync.WaitGroup
-
-		for i, tc := range normalizedToolCalls {
-			agentResults[i].tc = tc
-
-			wg.Add(1)
-			go func(idx int, tc providers.ToolCall) {
-				defer wg.Done()
-
+		toolCalls += len(runnable)
+
+		toolCtx := withQuotaSubject(tools.WithCallInfo(ctx, tools.CallInfo{
+			AgentID:    agent.ID,
+			SessionKey: opts.SessionKey,
+			Sender:     opts.Sender,
+		}), opts.Quota)
+		toolResults := tools.ExecuteToolCalls(toolCtx, agent.Tools, runnable, agent.MaxParallelTools,
+			func(toolCtx context.Context, tc providers.ToolCall) *tools.ToolResult {
 				argsJSON, _ := json.Marshal(tc.Arguments)
 				argsPreview := utils.Truncate(string(argsJSON), 200)
 				logger.InfoCF("agent", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
@@ -1005,39 +1320,43 @@ func (al *AgentLoop) runLLMIteration(
 					}
 				}
 
-				toolResult := agent.Tools.ExecuteWithContext(
-					ctx,
+				return agent.Tools.ExecuteWithContext(
+					toolCtx,
 					tc.Name,
 					tc.Arguments,
 					opts.Channel,
 					opts.ChatID,
 					asyncCallback,
 				)
-				agentResults[idx].result = toolResult
-			}(i, tc)
+			})
+		for range normalizedToolCalls[len(runnable):] {
+			toolResults = append(toolResults, tools.ErrorResult(fmt.Sprintf(
+				"Tool call limit reached (%d per message). Answer with the information you already have.",
+				opts.MaxToolCalls)))
 		}
-		wg.Wait()
 
 		// Process results in original order (send to user, save to session)
-		for _, r := range agentResults {
+		for i, result := range toolResults {
+			tc := normalizedToolCalls[i]
+
 			// Send ForUser content to user immediately if not Silent
-			if !r.result.Silent && r.result.ForUser != "" && opts.SendResponse {
+			if !result.Silent && result.ForUser != "" && opts.SendResponse {
 				al.bus.PublishOutbound(ctx, bus.OutboundMessage{
 					Channel: opts.Channel,
 					ChatID:  opts.ChatID,
-					Content: r.result.ForUser,
+					Content: result.ForUser,
 				})
 				logger.DebugCF("agent", "Sent tool result to user",
 					map[string]any{
-						"tool":        r.tc.Name,
-						"content_len": len(r.result.ForUser),
+						"tool":        tc.Name,
+						"content_len": len(result.ForUser),
 					})
 			}
 
 			// If tool returned media refs, publish them as outbound media
-			if len(r.result.Media) > 0 && opts.SendResponse {
-				parts := make([]bus.MediaPart, 0, len(r.result.Media))
-				for _, ref := range r.result.Media {
+			if len(result.Media) > 0 && opts.SendResponse {
+				parts := make([]bus.MediaPart, 0, len(result.Media))
+				for _, ref := range result.Media {
 					part := bus.MediaPart{Ref: ref}
 					if al.mediaStore != nil {
 						if _, meta, err := al.mediaStore.ResolveWithMeta(ref); err == nil {
@@ -1056,21 +1375,29 @@ func (al *AgentLoop) runLLMIteration(
 			}
 
 			// Determine content for LLM based on tool result
-			contentForLLM := r.result.ForLLM
-			if contentForLLM == "" && r.result.Err != nil {
-				contentForLLM = r.result.Err.Error()
+			contentForLLM := result.ForLLM
+			if contentForLLM == "" && result.Err != nil {
+				contentForLLM = result.Err.Error()
 			}
 
 			toolResultMsg := providers.Message{
 				Role:       "tool",
 				Content:    contentForLLM,
-				ToolCallID: r.tc.ID,
+				ToolCallID: tc.ID,
 			}
 			messages = append(messages, toolResultMsg)
 
 			// Save tool result message to session
 			agent.Sessions.AddFullMessage(opts.SessionKey, toolResultMsg)
 		}
+
+		if err := ctx.Err(); err != nil {
+			return "", iteration, fmt.Errorf("turn aborted: %w", err)
+		}
+
+		if iteration == maxIterations {
+			escalate(fmt.Sprintf("%s used up max_tool_iterations", current.name))
+		}
 	}
 
 	return finalContent, iteration, nil
@@ -1097,10 +1424,15 @@ func (al *AgentLoop) updateToolContexts(agent *AgentInstance, channel, chatID st
 }
 
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
-func (al *AgentLoop) maybeSummarize(agent *AgentInstance, sessionKey, channel, chatID string) {
+// Its LLM calls are charged to subject.
+func (al *AgentLoop) maybeSummarize(
+	agent *AgentInstance,
+	sessionKey, channel, chatID string,
+	subject quota.Subject,
+) {
 	newHistory := agent.Sessions.GetHistory(sessionKey)
 	tokenEstimate := al.estimateTokens(newHistory)
-	threshold := agent.ContextWindow * agent.SummarizeTokenPercent / 100
+	threshold := agent.ContextWindow() * agent.SummarizeTokenPercent / 100
 
 	if len(newHistory) > agent.SummarizeMessageThreshold || tokenEstimate > threshold {
 		summarizeKey := agent.ID + ":" + sessionKey
@@ -1108,7 +1440,7 @@ func (al *AgentLoop) maybeSummarize(agent *AgentInstance, sessionKey, channel, c
 			go func() {
 				defer al.summarizing.Delete(summarizeKey)
 				logger.Debug("Memory threshold reached. Optimizing conversation history...")
-				al.summarizeSession(agent, sessionKey)
+				al.summarizeSession(agent, sessionKey, subject)
 			}()
 		}
 	}
@@ -1254,8 +1586,9 @@ func formatToolsForLog(toolDefs []providers.ToolDefinition) string {
 	return sb.String()
 }
 
-// summarizeSession summarizes the conversation history for a session.
-func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
+// summarizeSession summarizes the conversation history for a session,
+// charging the LLM calls to subject.
+func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string, subject quota.Subject) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
@@ -1270,7 +1603,7 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 	toSummarize := history[:len(history)-4]
 
 	// Oversized Message Guard
-	maxMessageTokens := agent.ContextWindow / 2
+	maxMessageTokens := agent.ContextWindow() / 2
 	validMessages := make([]providers.Message, 0)
 	omitted := false
 
@@ -1297,17 +1630,18 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 		part1 := validMessages[:mid]
 		part2 := validMessages[mid:]
 
-		s1, _ := al.summarizeBatch(ctx, agent, part1, "")
-		s2, _ := al.summarizeBatch(ctx, agent, part2, "")
+		s1, _ := al.summarizeBatch(ctx, agent, sessionKey, subject, part1, "")
+		s2, _ := al.summarizeBatch(ctx, agent, sessionKey, subject, part2, "")
 
 		mergePrompt := fmt.Sprintf(
 			"Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s",
 			s1,
 			s2,
 		)
+		mergeMessages := []providers.Message{{Role: "user", Content: mergePrompt}}
 		resp, err := agent.Provider.Chat(
 			ctx,
-			[]providers.Message{{Role: "user", Content: mergePrompt}},
+			mergeMessages,
 			nil,
 			agent.Model,
 			map[string]any{
@@ -1317,12 +1651,14 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 			},
 		)
 		if err == nil {
+			al.recordUsage(agent, sessionKey, "", usage.KindSummary, "", agent.Model, resp)
+			al.chargeQuota(subject, mergeMessages, resp)
 			finalSummary = resp.Content
 		} else {
 			finalSummary = s1 + " " + s2
 		}
 	} else {
-		finalSummary, _ = al.summarizeBatch(ctx, agent, validMessages, summary)
+		finalSummary, _ = al.summarizeBatch(ctx, agent, sessionKey, subject, validMessages, summary)
 	}
 
 	if omitted && finalSummary != "" {
@@ -1340,6 +1676,8 @@ func (al *AgentLoop) summarizeSession(agent *AgentInstance, sessionKey string) {
 func (al *AgentLoop) summarizeBatch(
 	ctx context.Context,
 	agent *AgentInstance,
+	sessionKey string,
+	subject quota.Subject,
 	batch []providers.Message,
 	existingSummary string,
 ) (string, error) {
@@ -1356,11 +1694,11 @@ func (al *AgentLoop) summarizeBatch(
 	for _, m := range batch {
 		fmt.Fprintf(&sb, "%s: %s\n", m.Role, m.Content)
 	}
-	prompt := sb.String()
+	messages := []providers.Message{{Role: "user", Content: sb.String()}}
 
 	response, err := agent.Provider.Chat(
 		ctx,
-		[]providers.Message{{Role: "user", Content: prompt}},
+		messages,
 		nil,
 		agent.Model,
 		map[string]any{
@@ -1372,6 +1710,8 @@ func (al *AgentLoop) summarizeBatch(
 	if err != nil {
 		return "", err
 	}
+	al.recordUsage(agent, sessionKey, "", usage.KindSummary, "", agent.Model, response)
+	al.chargeQuota(subject, messages, response)
 	return response.Content, nil
 }
 
@@ -1402,6 +1742,18 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 	args := parts[1:]
 
 	switch cmd {
+	case "/usage":
+		return al.usageCommand(ctx, msg, args), true
+
+	case "/approve", "/deny":
+		return al.approvalCommand(msg, args, cmd == "/approve"), true
+
+	case "/voice":
+		return al.voiceCommand(msg, args), true
+
+	case "/model":
+		return al.modelCommand(args)
+
 	case "/show":
 		if len(args) < 1 {
 			return "Usage: /show [model|channel|agents]", true
@@ -1412,7 +1764,15 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 			if defaultAgent == nil {
 				return "No default agent configured", true
 			}
-			return fmt.Sprintf("Current model: %s", defaultAgent.Model), true
+			reply := fmt.Sprintf("Current model: %s", defaultAgent.Model)
+			if al.router != nil {
+				if _, sessionKey, _, err := al.routeMessage(msg); err == nil {
+					if d, ok := al.router.LastDecision(sessionKey); ok {
+						reply += fmt.Sprintf("\nLast turn: %s (%s)", d.Model, d.Reason)
+					}
+				}
+			}
+			return reply, true
 		case "channel":
 			return fmt.Sprintf("Current channel: %s", msg.Channel), true
 		case "agents":
@@ -1477,6 +1837,14 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 	return "", false
 }
 
+// senderCanonicalID returns the "platform:id" identity of the sender of msg.
+func senderCanonicalID(msg bus.InboundMessage) string {
+	if msg.Sender.CanonicalID != "" {
+		return msg.Sender.CanonicalID
+	}
+	return identity.BuildCanonicalID(msg.Channel, msg.SenderID)
+}
+
 // extractPeer extracts the routing peer from the inbound message's structured Peer field.
 func extractPeer(msg bus.InboundMessage) *routing.RoutePeer {
 	if msg.Peer.Kind == "" {
@@ -1493,6 +1861,39 @@ func extractPeer(msg bus.InboundMessage) *routing.RoutePeer {
 	return &routing.RoutePeer{Kind: msg.Peer.Kind, ID: peerID}
 }
 
+// maxQuotedParentLen bounds the quoted parent text included in a user turn.
+const maxQuotedParentLen = 500
+
+// replyTo builds the outbound reply to msg. Replies stay in the message's
+// thread, and in group chats they answer the triggering message so that it
+// is clear who the bot is talking to.
+func replyTo(msg bus.InboundMessage, content string) bus.OutboundMessage {
+	out := bus.OutboundMessage{
+		Channel:  msg.Channel,
+		ChatID:   msg.ChatID,
+		Content:  content,
+		ThreadID: msg.Metadata[bus.MetadataThreadID],
+	}
+	if msg.Peer.Kind == "group" || msg.Peer.Kind == "channel" {
+		out.ReplyToMessageID = msg.MessageID
+	}
+	return out
+}
+
+// withQuotedParent prefixes content with the text of the message the user
+// replied to, so that the model knows what "this" refers to.
+func withQuotedParent(msg bus.InboundMessage, content string) string {
+	parent := strings.TrimSpace(msg.Metadata[bus.MetadataReplyToText])
+	if parent == "" {
+		return content
+	}
+	parent = utils.Truncate(parent, maxQuotedParentLen)
+	if sender := msg.Metadata[bus.MetadataReplyToSender]; sender != "" {
+		return fmt.Sprintf("[Replying to %s: %q]\n%s", sender, parent, content)
+	}
+	return fmt.Sprintf("[Replying to: %q]\n%s", parent, content)
+}
+
 // extractParentPeer extracts the parent peer (reply-to) from inbound message metadata.
 func extractParentPeer(msg bus.InboundMessage) *routing.RoutePeer {
 	parentKind := msg.Metadata["parent_peer_kind"]
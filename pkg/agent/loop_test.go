@@ -7,13 +7,16 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/audit"
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/tools"
 )
@@ -949,3 +952,216 @@ func TestResolveMediaRefs_UsesMetaContentType(t *testing.T) {
 		t.Fatalf("expected jpeg prefix, got %q", result[0].Media[0][:30])
 	}
 }
+
+func TestProcessMessage_RecordsSearchableHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Tools: config.ToolsConfig{
+			HistorySearch: config.HistorySearchConfig{Enabled: true, MaxResults: 5},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "Budget approved"})
+	agent := al.registry.GetDefaultAgent()
+	if agent.History == nil {
+		t.Fatal("expected history index to be enabled")
+	}
+	defer agent.History.Close()
+	if _, ok := agent.Tools.Get("history_search"); !ok {
+		t.Fatal("expected history_search tool to be registered")
+	}
+
+	helper := testHelper{al: al}
+	helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "user1",
+		ChatID:   "chat1",
+		Content:  "What about the marketing budget?",
+	})
+
+	hits, err := agent.History.Search(context.Background(), memory.HistoryQuery{Text: "budget", Channel: "telegram"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("got %d hits, want user and assistant messages: %+v", len(hits), hits)
+	}
+}
+
+func TestProcessMessage_EphemeralSessionIsDiscarded(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Tools: config.ToolsConfig{
+			HistorySearch: config.HistorySearchConfig{Enabled: true, MaxResults: 5},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "Budget approved"})
+	agent := al.registry.GetDefaultAgent()
+	defer agent.History.Close()
+
+	sessionKey := "agent:main:openai_api:request:chatcmpl-1"
+	helper := testHelper{al: al}
+	response := helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "openai_api",
+		SenderID:   "anonymous",
+		ChatID:     "chatcmpl-1",
+		Content:    "What about the marketing budget?",
+		SessionKey: sessionKey,
+		Metadata:   map[string]string{bus.MetadataEphemeral: "true"},
+	})
+	if response != "Budget approved" {
+		t.Fatalf("response = %q", response)
+	}
+
+	if history := agent.Sessions.GetHistory(sessionKey); len(history) != 0 {
+		t.Errorf("ephemeral turn was kept in the session: %+v", history)
+	}
+	hits, err := agent.History.Search(context.Background(), memory.HistoryQuery{Text: "budget"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("ephemeral turn was indexed: %+v", hits)
+	}
+}
+
+func TestProcessMessage_AuditsToolCalls(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{})
+	cfg.Quotas.Enabled = false
+	cfg.Tools.Audit = config.AuditConfig{
+		Enabled: true,
+		Path:    filepath.Join(t.TempDir(), "audit.jsonl"),
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &toolCallingProvider{})
+	al.RegisterTool(&countingTool{})
+
+	helper := testHelper{al: al}
+	helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "do things",
+	})
+
+	var entries []audit.Entry
+	if err := audit.Read(cfg.AuditLogPath(), audit.Filter{}, func(e audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("audit.Read: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d audit entries, want 3", len(entries))
+	}
+	for _, e := range entries {
+		if e.AgentID != "main" || e.Sender != "telegram:42" || e.SessionKey == "" || e.Tool != "counting_tool" {
+			t.Errorf("unexpected audit entry: %+v", e)
+		}
+	}
+}
+
+// slowTool sleeps and records how many executions overlap.
+type slowTool struct {
+	running, peak atomic.Int32
+}
+
+func (t *slowTool) Name() string               { return "slow_tool" }
+func (t *slowTool) Description() string        { return "sleeps" }
+func (t *slowTool) Parameters() map[string]any { return map[string]any{"type": "object"} }
+
+func (t *slowTool) Execute(ctx context.Context, args map[string]any) *tools.ToolResult {
+	n := t.running.Add(1)
+	defer t.running.Add(-1)
+	for {
+		peak := t.peak.Load()
+		if n <= peak || t.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return tools.SilentResult(fmt.Sprintf("fetched %v", args["url"]))
+}
+
+func TestProcessMessage_ToolCallsRunInParallelInOrder(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{})
+	cfg.Agents.Defaults.MaxParallelTools = 2
+	var results []string
+	provider := &routedProvider{reply: func(messages []providers.Message) (*providers.LLMResponse, error) {
+		if messages[len(messages)-1].Role == "tool" {
+			for _, m := range messages {
+				if m.Role == "tool" {
+					results = append(results, m.ToolCallID+"="+m.Content)
+				}
+			}
+			return &providers.LLMResponse{Content: "done"}, nil
+		}
+		var calls []providers.ToolCall
+		for i := range 4 {
+			calls = append(calls, providers.ToolCall{
+				ID: fmt.Sprintf("call_%d", i), Name: "slow_tool", Arguments: map[string]any{"url": i},
+			})
+		}
+		return &providers.LLMResponse{ToolCalls: calls}, nil
+	}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	tool := &slowTool{}
+	al.RegisterTool(tool)
+
+	helper := testHelper{al: al}
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "fetch"}
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "done" {
+		t.Fatalf("response = %q", got)
+	}
+	if peak := tool.peak.Load(); peak != 2 {
+		t.Errorf("peak concurrency = %d, want max_parallel_tools (2)", peak)
+	}
+
+	want := []string{"call_0=fetched 0", "call_1=fetched 1", "call_2=fetched 2", "call_3=fetched 3"}
+	if !slices.Equal(results, want) {
+		t.Errorf("tool results = %v, want %v", results, want)
+	}
+}
+
+func TestReplyTo(t *testing.T) {
+	group := bus.InboundMessage{
+		Channel:   "telegram",
+		ChatID:    "-100",
+		MessageID: "7",
+		Peer:      bus.Peer{Kind: "group", ID: "-100"},
+		Metadata:  map[string]string{bus.MetadataThreadID: "3"},
+	}
+	out := replyTo(group, "hi")
+	if out.ReplyToMessageID != "7" || out.ThreadID != "3" || out.ChatID != "-100" || out.Content != "hi" {
+		t.Errorf("group reply = %+v", out)
+	}
+
+	direct := bus.InboundMessage{Channel: "telegram", ChatID: "5", MessageID: "8", Peer: bus.Peer{Kind: "direct"}}
+	if out := replyTo(direct, "hi"); out.ReplyToMessageID != "" || out.ThreadID != "" {
+		t.Errorf("direct reply should not quote: %+v", out)
+	}
+}
+
+func TestWithQuotedParent(t *testing.T) {
+	msg := bus.InboundMessage{Metadata: map[string]string{
+		bus.MetadataReplyToText:   "the build is red",
+		bus.MetadataReplyToSender: "Bob",
+	}}
+	if got := withQuotedParent(msg, "why?"); got != "[Replying to Bob: \"the build is red\"]\nwhy?" {
+		t.Errorf("got %q", got)
+	}
+	if got := withQuotedParent(bus.InboundMessage{}, "why?"); got != "why?" {
+		t.Errorf("got %q without a parent", got)
+	}
+}
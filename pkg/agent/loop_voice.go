@@ -0,0 +1,202 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/voice"
+)
+
+// audioMarker matches the placeholders channels put in the content for
+// voice and audio attachments: "[voice]", "[audio]", "[audio: memo.mp3]".
+var audioMarker = regexp.MustCompile(`\[(?:voice|audio)(?::[^\]]*)?\]`)
+
+// transcribeAudio transcribes the audio refs in mediaRefs and substitutes the
+// transcripts into content, replacing the channel's audio placeholders in
+// order (or appending when there are none). Transcribed refs are removed
+// from the returned media; refs that fail to transcribe are kept.
+func (al *AgentLoop) transcribeAudio(ctx context.Context, content string, mediaRefs []string) (string, []string) {
+	if al.transcriber == nil || al.mediaStore == nil || len(mediaRefs) == 0 {
+		return content, mediaRefs
+	}
+
+	remaining := make([]string, 0, len(mediaRefs))
+	for _, ref := range mediaRefs {
+		path, meta, err := al.mediaStore.ResolveWithMeta(ref)
+		if err != nil || inferMediaType(meta.Filename, meta.ContentType) != "audio" {
+			remaining = append(remaining, ref)
+			continue
+		}
+
+		result, err := al.transcriber.Transcribe(ctx, path)
+		if err != nil || strings.TrimSpace(result.Text) == "" {
+			fields := map[string]any{"ref": ref}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			logger.WarnCF("agent", "Voice transcription failed", fields)
+			remaining = append(remaining, ref)
+			continue
+		}
+
+		transcript := fmt.Sprintf("[voice transcript: %s]", strings.TrimSpace(result.Text))
+		if loc := audioMarker.FindStringIndex(content); loc != nil {
+			content = content[:loc[0]] + transcript + content[loc[1]:]
+		} else if content == "" {
+			content = transcript
+		} else {
+			content += "\n" + transcript
+		}
+		logger.InfoCF("agent", "Transcribed voice message",
+			map[string]any{"ref": ref, "chars": len(result.Text)})
+	}
+	return content, remaining
+}
+
+// voiceReplyKey identifies a chat in the persisted voice reply settings.
+func voiceReplyKey(msg bus.InboundMessage) string {
+	return msg.Channel + ":" + msg.ChatID
+}
+
+// voiceReplyMode returns the voice reply mode for the chat of msg: the
+// chat's /voice setting, else the channel's configured mode, else the
+// global one.
+func (al *AgentLoop) voiceReplyMode(msg bus.InboundMessage) string {
+	if al.state != nil {
+		if mode := al.state.GetVoiceReply(voiceReplyKey(msg)); mode != "" {
+			return mode
+		}
+	}
+	sc := al.cfg.Voice.Speech
+	if mode, ok := sc.Channels[msg.Channel]; ok {
+		return mode
+	}
+	if sc.Reply != "" {
+		return sc.Reply
+	}
+	return voice.ReplyOff
+}
+
+// wantsVoiceReply reports whether the reply to msg should be spoken.
+func (al *AgentLoop) wantsVoiceReply(msg bus.InboundMessage) bool {
+	switch al.voiceReplyMode(msg) {
+	case voice.ReplyOn:
+		return true
+	case voice.ReplyAuto:
+		if al.mediaStore == nil {
+			return false
+		}
+		for _, ref := range msg.Media {
+			if _, meta, err := al.mediaStore.ResolveWithMeta(ref); err == nil &&
+				inferMediaType(meta.Filename, meta.ContentType) == "audio" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendVoiceReply synthesizes response and publishes it as an audio message
+// to the chat of msg. It returns false when the reply should be sent as text
+// instead: voice is off for the chat, the channel cannot send media, the
+// text is not suitable for speech, or synthesis fails.
+func (al *AgentLoop) sendVoiceReply(ctx context.Context, msg bus.InboundMessage, response string) bool {
+	if al.synthesizer == nil || al.mediaStore == nil || al.channelManager == nil {
+		return false
+	}
+	if strings.HasPrefix(strings.TrimSpace(msg.Content), "/") || !al.wantsVoiceReply(msg) {
+		return false
+	}
+	ch, ok := al.channelManager.GetChannel(msg.Channel)
+	if !ok {
+		return false
+	}
+	if _, ok := ch.(channels.MediaSender); !ok {
+		return false
+	}
+
+	text := voice.SpeakableText(response)
+	if text == "" || len(text) > voice.MaxSpeechChars {
+		return false
+	}
+
+	speech, err := al.synthesizer.Synthesize(ctx, text)
+	if err != nil {
+		logger.WarnCF("agent", "Speech synthesis failed, replying with text",
+			map[string]any{"channel": msg.Channel, "error": err.Error()})
+		return false
+	}
+
+	filename := "reply" + filepath.Ext(speech.Path)
+	scope := channels.BuildMediaScope(msg.Channel, msg.ChatID, "")
+	ref, err := al.mediaStore.Store(speech.Path, media.MediaMeta{
+		Filename:    filename,
+		ContentType: speech.ContentType,
+		Source:      "tts",
+	}, scope)
+	if err != nil {
+		os.Remove(speech.Path)
+		logger.WarnCF("agent", "Failed to store synthesized speech, replying with text",
+			map[string]any{"error": err.Error()})
+		return false
+	}
+
+	if err := al.bus.PublishOutboundMedia(ctx, bus.OutboundMediaMessage{
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		Parts: []bus.MediaPart{{
+			Type:        "audio",
+			Ref:         ref,
+			Filename:    filename,
+			ContentType: speech.ContentType,
+		}},
+	}); err != nil {
+		return false
+	}
+	logger.InfoCF("agent", "Published voice reply",
+		map[string]any{"channel": msg.Channel, "chat_id": msg.ChatID, "text_len": len(text)})
+	return true
+}
+
+// voiceCommand handles "/voice [on|off|auto|reset]" for the current chat.
+func (al *AgentLoop) voiceCommand(msg bus.InboundMessage, args []string) string {
+	if len(args) == 0 {
+		status := fmt.Sprintf("Voice replies: %s", al.voiceReplyMode(msg))
+		if al.synthesizer == nil {
+			status += " (speech synthesis is not configured)"
+		}
+		return status
+	}
+	if al.state == nil {
+		return "Voice settings are unavailable: no workspace state"
+	}
+
+	mode := strings.ToLower(args[0])
+	switch {
+	case mode == "reset":
+		mode = ""
+	case !voice.ValidReplyMode(mode):
+		return "Usage: /voice [on|off|auto|reset]"
+	}
+	if err := al.state.SetVoiceReply(voiceReplyKey(msg), mode); err != nil {
+		return fmt.Sprintf("Failed to save voice setting: %v", err)
+	}
+	if mode == "" {
+		return fmt.Sprintf("Voice replies reset to default: %s", al.voiceReplyMode(msg))
+	}
+	return fmt.Sprintf("Voice replies: %s", mode)
+}
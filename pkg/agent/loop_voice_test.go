@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/voice"
+)
+
+// stubTranscriber returns a canned transcript per file name.
+type stubTranscriber struct {
+	texts map[string]string
+}
+
+func (s *stubTranscriber) Transcribe(ctx context.Context, path string) (*voice.TranscriptionResponse, error) {
+	text, ok := s.texts[filepath.Base(path)]
+	if !ok {
+		return nil, errors.New("unsupported audio")
+	}
+	return &voice.TranscriptionResponse{Text: text}, nil
+}
+
+func (s *stubTranscriber) IsAvailable() bool { return true }
+
+// capturingProvider records the messages of the last call.
+type capturingProvider struct {
+	mu       sync.Mutex
+	messages []providers.Message
+}
+
+func (p *capturingProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	defs []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+) (*providers.LLMResponse, error) {
+	p.mu.Lock()
+	p.messages = messages
+	p.mu.Unlock()
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (p *capturingProvider) GetDefaultModel() string { return "mock-model" }
+
+func storeTestMedia(t *testing.T, store media.MediaStore, name, contentType string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := store.Store(path, media.MediaMeta{Filename: name, ContentType: contentType}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestTranscribeAudio(t *testing.T) {
+	al, _, _, _, cleanup := newTestAgentLoop(t)
+	defer cleanup()
+
+	store := media.NewFileMediaStore()
+	al.SetMediaStore(store)
+	al.SetTranscriber(&stubTranscriber{texts: map[string]string{
+		"voice.ogg": "turn on the lights",
+		"memo.mp3":  "buy milk",
+	}})
+
+	voiceRef := storeTestMedia(t, store, "voice.ogg", "audio/ogg")
+	memoRef := storeTestMedia(t, store, "memo.mp3", "")
+	brokenRef := storeTestMedia(t, store, "broken.wav", "audio/wav")
+	photoRef := storeTestMedia(t, store, "photo.jpg", "image/jpeg")
+
+	content, refs := al.transcribeAudio(context.Background(),
+		"look\n[image: photo]\n[voice]\n[audio: memo.mp3]",
+		[]string{photoRef, voiceRef, memoRef, brokenRef})
+
+	want := "look\n[image: photo]\n[voice transcript: turn on the lights]\n[voice transcript: buy milk]"
+	if content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	if len(refs) != 2 || refs[0] != photoRef || refs[1] != brokenRef {
+		t.Errorf("remaining refs = %v, want the photo and the untranscribed audio", refs)
+	}
+
+	// Without a placeholder the transcript is appended.
+	content, _ = al.transcribeAudio(context.Background(), "", []string{voiceRef})
+	if content != "[voice transcript: turn on the lights]" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestProcessMessage_TranscribesVoice(t *testing.T) {
+	al, cfg, msgBus, _, cleanup := newTestAgentLoop(t)
+	defer cleanup()
+
+	provider := &capturingProvider{}
+	al = NewAgentLoop(cfg, msgBus, provider)
+	store := media.NewFileMediaStore()
+	al.SetMediaStore(store)
+	al.SetTranscriber(&stubTranscriber{texts: map[string]string{"voice.ogg": "what time is it"}})
+
+	ref := storeTestMedia(t, store, "voice.ogg", "audio/ogg")
+	_, err := al.processMessage(context.Background(), bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "1",
+		ChatID:   "1",
+		Content:  "[voice]",
+		Media:    []string{ref},
+	})
+	if err != nil {
+		t.Fatalf("processMessage: %v", err)
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	last := provider.messages[len(provider.messages)-1]
+	if !strings.Contains(last.Content, "[voice transcript: what time is it]") {
+		t.Errorf("user turn = %q, want the transcript", last.Content)
+	}
+	if len(last.Media) != 0 {
+		t.Errorf("transcribed audio should not be sent as media, got %d items", len(last.Media))
+	}
+}
+
+// stubSynthesizer writes the text it was given to an .ogg file.
+type stubSynthesizer struct {
+	dir   string
+	texts []string
+}
+
+func (s *stubSynthesizer) Synthesize(ctx context.Context, text string) (*voice.SpeechResult, error) {
+	s.texts = append(s.texts, text)
+	path := filepath.Join(s.dir, "speech.ogg")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		return nil, err
+	}
+	return &voice.SpeechResult{Path: path, ContentType: "audio/ogg"}, nil
+}
+
+func (s *stubSynthesizer) IsAvailable() bool { return true }
+
+// fakeMediaChannel is a channel that can send media.
+type fakeMediaChannel struct{ fakeChannel }
+
+func (f *fakeMediaChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	return nil
+}
+
+func newVoiceReplyTestLoop(t *testing.T, reply string) (*AgentLoop, *bus.MessageBus, *stubSynthesizer) {
+	t.Helper()
+	al, cfg, msgBus, _, cleanup := newTestAgentLoop(t)
+	t.Cleanup(cleanup)
+	cfg.Voice.Speech = config.SpeechConfig{Enabled: true, Reply: reply}
+
+	chManager, err := channels.NewManager(&config.Config{}, bus.NewMessageBus(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create channel manager: %v", err)
+	}
+	chManager.RegisterChannel("telegram", &fakeMediaChannel{})
+	chManager.RegisterChannel("slack", &fakeChannel{})
+	al.SetChannelManager(chManager)
+	al.SetMediaStore(media.NewFileMediaStore())
+	synth := &stubSynthesizer{dir: t.TempDir()}
+	al.SetSynthesizer(synth)
+	return al, msgBus, synth
+}
+
+func TestHandleInbound_VoiceReply(t *testing.T) {
+	al, msgBus, synth := newVoiceReplyTestLoop(t, voice.ReplyOn)
+
+	al.handleInbound(context.Background(), bus.InboundMessage{
+		Channel: "telegram", SenderID: "1", ChatID: "42", Content: "hello",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutboundMedia(ctx)
+	if !ok {
+		t.Fatal("expected a voice reply")
+	}
+	if out.Channel != "telegram" || out.ChatID != "42" || len(out.Parts) != 1 {
+		t.Fatalf("unexpected media message: %+v", out)
+	}
+	part := out.Parts[0]
+	if part.Type != "audio" || part.ContentType != "audio/ogg" || !strings.HasPrefix(part.Ref, "media://") {
+		t.Errorf("unexpected media part: %+v", part)
+	}
+	if len(synth.texts) != 1 || synth.texts[0] != "Mock response" {
+		t.Errorf("synthesized texts = %q", synth.texts)
+	}
+}
+
+func TestHandleInbound_VoiceReplyFallsBackToText(t *testing.T) {
+	al, msgBus, synth := newVoiceReplyTestLoop(t, voice.ReplyOn)
+
+	// slack is registered without MediaSender.
+	al.handleInbound(context.Background(), bus.InboundMessage{
+		Channel: "slack", SenderID: "1", ChatID: "c1", Content: "hello",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok || out.Content != "Mock response" {
+		t.Fatalf("expected a text reply, got %+v", out)
+	}
+	if len(synth.texts) != 0 {
+		t.Errorf("nothing should be synthesized for a channel without media, got %q", synth.texts)
+	}
+}
+
+func TestWantsVoiceReply(t *testing.T) {
+	al, _, _ := newVoiceReplyTestLoop(t, voice.ReplyAuto)
+	voiceRef := storeTestMedia(t, al.mediaStore, "voice.ogg", "audio/ogg")
+	photoRef := storeTestMedia(t, al.mediaStore, "photo.jpg", "image/jpeg")
+
+	text := bus.InboundMessage{Channel: "telegram", ChatID: "42", Media: []string{photoRef}}
+	spoken := bus.InboundMessage{Channel: "telegram", ChatID: "42", Media: []string{voiceRef}}
+	if al.wantsVoiceReply(text) || !al.wantsVoiceReply(spoken) {
+		t.Error("auto mode should speak only replies to voice messages")
+	}
+
+	al.cfg.Voice.Speech.Channels = map[string]string{"telegram": voice.ReplyOff}
+	if al.wantsVoiceReply(spoken) {
+		t.Error("the channel setting should override the global mode")
+	}
+
+	if got := al.voiceCommand(text, []string{"on"}); got != "Voice replies: on" {
+		t.Errorf("/voice on = %q", got)
+	}
+	if !al.wantsVoiceReply(text) {
+		t.Error("the chat setting should override the channel setting")
+	}
+	if got := al.voiceCommand(text, []string{"loud"}); !strings.HasPrefix(got, "Usage:") {
+		t.Errorf("/voice loud = %q, want usage", got)
+	}
+	al.voiceCommand(text, []string{"reset"})
+	if al.wantsVoiceReply(spoken) {
+		t.Error("reset should restore the channel setting")
+	}
+}
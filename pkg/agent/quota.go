@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/quota"
+)
+
+// checkQuota applies the configured quotas to an inbound message. It
+// returns the subject to charge LLM tokens to and the tool call cap for the
+// turn, or a non-empty refusal to send back instead of processing it.
+func (al *AgentLoop) checkQuota(msg bus.InboundMessage) (quota.Subject, int, string) {
+	if al.quotas == nil || constants.IsInternalChannel(msg.Channel) {
+		return quota.Subject{}, 0, ""
+	}
+
+	subject := al.quotas.SubjectFor(msg)
+	if subject.IsZero() {
+		// Exempt sender.
+		return subject, 0, ""
+	}
+	if d := al.quotas.Allow(subject); !d.Allowed {
+		logger.InfoCF("agent", "Message refused by quota",
+			map[string]any{"user": subject.User, "chat": subject.Chat, "reason": d.Reason})
+		return subject, 0, d.Reason
+	}
+	return subject, al.quotas.MaxToolCallsPerTurn(), ""
+}
+
+type quotaSubjectKey struct{}
+
+// withQuotaSubject returns a copy of ctx carrying the subject that LLM calls
+// made on behalf of the turn, such as subagent turns, are charged to.
+func withQuotaSubject(ctx context.Context, subject quota.Subject) context.Context {
+	return context.WithValue(ctx, quotaSubjectKey{}, subject)
+}
+
+// quotaSubjectFrom returns the subject attached to ctx, or the zero subject.
+func quotaSubjectFrom(ctx context.Context) quota.Subject {
+	subject, _ := ctx.Value(quotaSubjectKey{}).(quota.Subject)
+	return subject
+}
+
+// chargeQuota charges the tokens of an LLM call to the subject's daily
+// budget. Providers that report no usage are charged an estimate so that
+// budgets still apply.
+func (al *AgentLoop) chargeQuota(subject quota.Subject, messages []providers.Message, resp *providers.LLMResponse) {
+	if al.quotas == nil || subject.IsZero() || resp == nil {
+		return
+	}
+	var tokens int
+	if resp.Usage != nil {
+		tokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+	} else {
+		tokens = al.estimateTokens(messages) + utf8.RuneCountInString(resp.Content)*2/5
+	}
+	al.quotas.AddTokens(subject, tokens)
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/quota"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// toolCallingProvider requests three tool calls on the first call and
+// answers directly afterwards.
+type toolCallingProvider struct {
+	calls atomic.Int32
+}
+
+func (p *toolCallingProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	defs []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+) (*providers.LLMResponse, error) {
+	if p.calls.Add(1) > 1 {
+		return &providers.LLMResponse{Content: "done"}, nil
+	}
+	var calls []providers.ToolCall
+	for _, id := range []string{"a", "b", "c"} {
+		calls = append(calls, providers.ToolCall{ID: id, Name: "counting_tool", Arguments: map[string]any{}})
+	}
+	return &providers.LLMResponse{ToolCalls: calls}, nil
+}
+
+func (p *toolCallingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+type countingTool struct {
+	executions atomic.Int32
+}
+
+func (t *countingTool) Name() string               { return "counting_tool" }
+func (t *countingTool) Description() string        { return "counts executions" }
+func (t *countingTool) Parameters() map[string]any { return map[string]any{"type": "object"} }
+
+func (t *countingTool) Execute(ctx context.Context, args map[string]any) *tools.ToolResult {
+	t.executions.Add(1)
+	return tools.SilentResult("ok")
+}
+
+func newQuotaTestConfig(t *testing.T, quotas config.QuotaConfig) *config.Config {
+	t.Helper()
+	quotas.Enabled = true
+	return &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Quotas: quotas,
+	}
+}
+
+func TestProcessMessage_QuotaRefusesBeforeProviderCall(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{
+		PerUser: config.QuotaLimits{MessagesPerWindow: 1},
+	})
+	provider := &countingMockProvider{response: "hello"}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "hi",
+		Sender: bus.SenderInfo{Platform: "telegram", PlatformID: "42", CanonicalID: "telegram:42"},
+	}
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "hello" {
+		t.Fatalf("first response = %q", got)
+	}
+
+	got := helper.executeAndGetResponse(t, context.Background(), msg)
+	if !strings.HasPrefix(got, "Sorry, you have reached the limit of 1 messages") {
+		t.Errorf("expected refusal, got %q", got)
+	}
+	if n := provider.calls.Load(); n != 1 {
+		t.Errorf("provider called %d times, want 1", n)
+	}
+
+	// Internal channels are never limited.
+	msg.Channel = "cli"
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "hello" {
+		t.Errorf("cli response = %q", got)
+	}
+}
+
+func TestProcessMessage_QuotaCapsToolCallsPerTurn(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{MaxToolCallsPerTurn: 2})
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &toolCallingProvider{})
+	tool := &countingTool{}
+	al.RegisterTool(tool)
+
+	helper := testHelper{al: al}
+	got := helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "do things",
+	})
+	if got != "done" {
+		t.Errorf("response = %q", got)
+	}
+	if n := tool.executions.Load(); n != 2 {
+		t.Errorf("tool executed %d times, want 2", n)
+	}
+}
+
+type countingMockProvider struct {
+	response string
+	calls    atomic.Int32
+}
+
+func (p *countingMockProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	defs []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+) (*providers.LLMResponse, error) {
+	p.calls.Add(1)
+	return &providers.LLMResponse{Content: p.response}, nil
+}
+
+func (p *countingMockProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func TestSummarizeSession_ChargesQuota(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{PerUser: config.QuotaLimits{TokensPerDay: 100}})
+	provider := &routedProvider{reply: func([]providers.Message) (*providers.LLMResponse, error) {
+		return &providers.LLMResponse{Content: "summary", Usage: &providers.UsageInfo{PromptTokens: 150}}, nil
+	}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	agent := al.registry.GetDefaultAgent()
+	for i := range 6 {
+		agent.Sessions.AddMessage("s1", []string{"user", "assistant"}[i%2], fmt.Sprintf("message %d", i))
+	}
+
+	subject := quota.Subject{User: "user:telegram:42"}
+	al.summarizeSession(agent, "s1", subject)
+
+	if d := al.quotas.Allow(subject); d.Allowed {
+		t.Error("summarization tokens should count towards the daily token quota")
+	}
+}
+
+func TestProcessMessage_SubagentTurnsChargeQuota(t *testing.T) {
+	cfg := newQuotaTestConfig(t, config.QuotaConfig{PerUser: config.QuotaLimits{TokensPerDay: 100}})
+	provider := &routedProvider{reply: func(messages []providers.Message) (*providers.LLMResponse, error) {
+		last := messages[len(messages)-1]
+		switch {
+		case strings.HasPrefix(messages[0].Content, "You are a subagent"):
+			return &providers.LLMResponse{Content: "found it", Usage: &providers.UsageInfo{PromptTokens: 150}}, nil
+		case last.Role == "tool":
+			return &providers.LLMResponse{Content: "spawned"}, nil
+		default:
+			return &providers.LLMResponse{ToolCalls: []providers.ToolCall{
+				{ID: "a", Name: "spawn", Arguments: map[string]any{"task": "research"}},
+			}}, nil
+		}
+	}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	msg := bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "look into it",
+		Sender: bus.SenderInfo{Platform: "telegram", PlatformID: "42", CanonicalID: "telegram:42"},
+	}
+	helper := testHelper{al: al}
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "spawned" {
+		t.Fatalf("response = %q", got)
+	}
+
+	// The subagent runs in the background; its tokens exhaust the budget.
+	subject := al.quotas.SubjectFor(msg)
+	deadline := time.Now().Add(5 * time.Second)
+	for al.quotas.Allow(subject).Allowed {
+		if time.Now().After(deadline) {
+			t.Fatal("subagent tokens should count towards the daily token quota")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
@@ -203,3 +204,47 @@ func TestAgentInstance_FallbackExplicitEmpty(t *testing.T) {
 		t.Errorf("expected 0 fallbacks (explicit empty), got %d: %v", len(agent.Fallbacks), agent.Fallbacks)
 	}
 }
+
+func TestRouteMessage_AgentScopedSessionKeySelectsAgent(t *testing.T) {
+	cfg := testCfg([]config.AgentConfig{
+		{ID: "sales", Default: true},
+		{ID: "support"},
+	})
+	al := &AgentLoop{registry: NewAgentRegistry(cfg, &mockRegistryProvider{})}
+
+	agent, sessionKey, _, err := al.routeMessage(bus.InboundMessage{
+		Channel:    "openai_api",
+		ChatID:     "req-1",
+		SessionKey: "agent:support:openai_api:direct:alice",
+	})
+	if err != nil {
+		t.Fatalf("routeMessage: %v", err)
+	}
+	if agent.ID != "support" || sessionKey != "agent:support:openai_api:direct:alice" {
+		t.Errorf("routed to %q with %q", agent.ID, sessionKey)
+	}
+
+	// Keys naming unknown agents keep the routed agent.
+	agent, _, _, err = al.routeMessage(bus.InboundMessage{
+		Channel:    "openai_api",
+		SessionKey: "agent:unknown:main",
+	})
+	if err != nil {
+		t.Fatalf("routeMessage: %v", err)
+	}
+	if agent.ID != "sales" {
+		t.Errorf("agent = %q, want default 'sales'", agent.ID)
+	}
+
+	// Only keys of the message's own channel select an agent.
+	agent, _, _, err = al.routeMessage(bus.InboundMessage{
+		Channel:    "telegram",
+		SessionKey: "agent:support:openai_api:direct:alice",
+	})
+	if err != nil {
+		t.Fatalf("routeMessage: %v", err)
+	}
+	if agent.ID != "sales" {
+		t.Errorf("agent = %q, want default 'sales'", agent.ID)
+	}
+}
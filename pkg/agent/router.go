@@ -0,0 +1,461 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	// routingToolWindow is how many recent history messages are scanned for
+	// tool results when matching a rule's min_tool_calls.
+	routingToolWindow = 20
+	// classifierTimeout bounds the classifier call; on timeout the turn is
+	// served by the agent's own model.
+	classifierTimeout = 15 * time.Second
+	// classifierMaxInput is how much of the message the classifier sees.
+	classifierMaxInput = 2000
+	// maxRouteDecisions bounds how many sessions' last decisions are kept
+	// for /show model; the oldest are forgotten first.
+	maxRouteDecisions = 1000
+)
+
+// turnModel is the provider and model serving (part of) a turn.
+type turnModel struct {
+	name       string // model_name, or the agent's model
+	provider   providers.LLMProvider
+	model      string
+	candidates []providers.FallbackCandidate
+}
+
+// agentTurnModel returns the agent's own model with its fallback chain.
+func agentTurnModel(agent *AgentInstance) *turnModel {
+	return &turnModel{
+		name:       agent.Model,
+		provider:   agent.Provider,
+		model:      agent.Model,
+		candidates: agent.Candidates,
+	}
+}
+
+// RouteDecision records which model serves a turn and why.
+type RouteDecision struct {
+	Model  string
+	Reason string
+
+	target *turnModel // nil: the agent's own model
+
+	// The classifier call that informed the decision, for the usage ledger.
+	classifier     *turnModel
+	classifierResp *providers.LLMResponse
+}
+
+// routeInput is what the router sees of a turn.
+type routeInput struct {
+	Hint    string // model named by an explicit "/model <name>" prefix
+	Message string
+	Media   []string
+	History []providers.Message
+}
+
+type routingRule struct {
+	config.RoutingRule
+	keywords []string // lower-cased
+	pattern  *regexp.Regexp
+}
+
+// ModelRouter picks the model_list entry that serves each turn, following
+// the agents.defaults.routing config, and remembers the last decision per
+// session for /show model.
+type ModelRouter struct {
+	cfg             *config.Config
+	rules           []routingRule
+	classifier      *config.RoutingClassifierConfig
+	escalationModel string
+	// choices are the models an explicit "/model <name>" hint may pick: those
+	// of the rules and the classifier's choices. Other model_list entries
+	// cannot be chosen, so hints do not get around cost routing.
+	choices []string
+
+	// newProvider creates providers for routed models; tests replace it.
+	newProvider func(*config.ModelConfig) (providers.LLMProvider, string, error)
+
+	mu     sync.Mutex
+	models map[string]*turnModel
+	last   map[string]recordedDecision
+}
+
+// recordedDecision is the last decision for a session and when it was made.
+type recordedDecision struct {
+	RouteDecision
+	at time.Time
+}
+
+// NewModelRouter creates a router from cfg. It returns nil when routing is
+// disabled. Rules with an invalid pattern or no model are logged and
+// skipped.
+func NewModelRouter(cfg *config.Config) *ModelRouter {
+	rc := cfg.Agents.Defaults.Routing
+	if !rc.Enabled {
+		return nil
+	}
+
+	r := &ModelRouter{
+		cfg:             cfg,
+		escalationModel: strings.TrimSpace(rc.EscalationModel),
+		newProvider:     providers.CreateProviderFromConfig,
+		models:          make(map[string]*turnModel),
+		last:            make(map[string]recordedDecision),
+	}
+	for i, rule := range rc.Rules {
+		if strings.TrimSpace(rule.Model) == "" {
+			logger.WarnCF("agent", "Routing rule has no model, skipping", map[string]any{"rule": i + 1})
+			continue
+		}
+		compiled := routingRule{RoutingRule: rule}
+		for _, kw := range rule.Keywords {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+				compiled.keywords = append(compiled.keywords, kw)
+			}
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				logger.ErrorCF("agent", "Invalid routing rule pattern, skipping rule",
+					map[string]any{"rule": i + 1, "pattern": rule.Pattern, "error": err.Error()})
+				continue
+			}
+			compiled.pattern = re
+		}
+		r.rules = append(r.rules, compiled)
+		r.addChoice(rule.Model)
+	}
+	if c := rc.Classifier; c != nil && strings.TrimSpace(c.Model) != "" && len(c.Choices) > 0 {
+		r.classifier = c
+		for _, choice := range c.Choices {
+			r.addChoice(choice.Model)
+		}
+	}
+	return r
+}
+
+func (r *ModelRouter) addChoice(name string) {
+	if name = strings.TrimSpace(name); name != "" && !slices.Contains(r.choices, name) {
+		r.choices = append(r.choices, name)
+	}
+}
+
+// Route picks the model for a turn: an explicit hint naming one of the
+// router's choices wins, then the first matching rule, then the classifier.
+// It never fails; when the chosen model cannot be set up, the agent's own
+// model is used and the reason says so.
+func (r *ModelRouter) Route(ctx context.Context, agent *AgentInstance, in routeInput) RouteDecision {
+	if in.Hint != "" {
+		if slices.Contains(r.choices, in.Hint) {
+			return r.decide(agent, in.Hint, "explicit /model hint")
+		}
+		logger.WarnCF("agent", "Ignoring /model hint for a model routing cannot choose",
+			map[string]any{"agent_id": agent.ID, "model": in.Hint})
+	}
+
+	for i, rule := range r.rules {
+		if rule.matches(in) {
+			return r.decide(agent, rule.Model, rule.reason(i))
+		}
+	}
+
+	d := RouteDecision{Model: agent.Model, Reason: "default model"}
+	if r.classifier != nil {
+		name, classifier, resp, err := r.classify(ctx, in)
+		if err != nil {
+			logger.WarnCF("agent", "Routing classifier failed, using the agent's model",
+				map[string]any{"agent_id": agent.ID, "classifier": r.classifier.Model, "error": err.Error()})
+		} else {
+			d = r.decide(agent, name, fmt.Sprintf("classifier %s", r.classifier.Model))
+		}
+		d.classifier, d.classifierResp = classifier, resp
+	}
+	return d
+}
+
+// decide resolves model name into a decision, falling back to the agent's
+// own model if name cannot be served.
+func (r *ModelRouter) decide(agent *AgentInstance, name, reason string) RouteDecision {
+	if name == agent.Model {
+		return RouteDecision{Model: agent.Model, Reason: reason}
+	}
+	target, err := r.resolve(name)
+	if err != nil {
+		logger.WarnCF("agent", "Routed model unavailable, using the agent's model",
+			map[string]any{"agent_id": agent.ID, "model": name, "error": err.Error()})
+		return RouteDecision{
+			Model:  agent.Model,
+			Reason: fmt.Sprintf("%s chose %s, which is unavailable", reason, name),
+		}
+	}
+	return RouteDecision{Model: name, Reason: reason, target: target}
+}
+
+// escalation returns the model that takes over a turn from current, or nil
+// if there is none.
+func (r *ModelRouter) escalation(agent *AgentInstance, current *turnModel) *turnModel {
+	if r == nil || r.escalationModel == "" || r.escalationModel == current.name {
+		return nil
+	}
+	if r.escalationModel == agent.Model {
+		return agentTurnModel(agent)
+	}
+	target, err := r.resolve(r.escalationModel)
+	if err != nil {
+		logger.WarnCF("agent", "Escalation model unavailable",
+			map[string]any{"agent_id": agent.ID, "model": r.escalationModel, "error": err.Error()})
+		return nil
+	}
+	return target
+}
+
+// resolve returns the provider serving model_list entry name, creating it
+// on first use.
+func (r *ModelRouter) resolve(name string) (*turnModel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tm, ok := r.models[name]; ok {
+		return tm, nil
+	}
+
+	mc, err := r.cfg.GetModelConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	provider, modelID, err := r.newProvider(mc)
+	if err != nil {
+		return nil, fmt.Errorf("creating provider for %s: %w", name, err)
+	}
+	protocol, _ := providers.ExtractProtocol(mc.Model)
+	tm := &turnModel{
+		name:       name,
+		provider:   provider,
+		model:      modelID,
+		candidates: []providers.FallbackCandidate{{Provider: protocol, Model: modelID}},
+	}
+	r.models[name] = tm
+	return tm, nil
+}
+
+// classify asks the classifier model to pick one of its choices for in.
+func (r *ModelRouter) classify(
+	ctx context.Context,
+	in routeInput,
+) (string, *turnModel, *providers.LLMResponse, error) {
+	classifier, err := r.resolve(r.classifier.Model)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("You route user requests to the most suitable model. ")
+	prompt.WriteString("Reply with only the name of one model from this list:\n")
+	for _, c := range r.classifier.Choices {
+		fmt.Fprintf(&prompt, "- %s: %s\n", c.Model, c.Description)
+	}
+
+	request := utils.Truncate(in.Message, classifierMaxInput)
+	if len(in.Media) > 0 {
+		request += fmt.Sprintf("\n[%d attachment(s)]", len(in.Media))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, classifierTimeout)
+	defer cancel()
+	resp, err := classifier.provider.Chat(ctx, []providers.Message{
+		{Role: "system", Content: prompt.String()},
+		{Role: "user", Content: request},
+	}, nil, classifier.model, map[string]any{"max_tokens": 32, "temperature": 0.0})
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(resp.Content))
+	best := ""
+	for _, c := range r.classifier.Choices {
+		if strings.Contains(answer, strings.ToLower(c.Model)) && len(c.Model) > len(best) {
+			best = c.Model
+		}
+	}
+	if best == "" {
+		return "", classifier, resp, fmt.Errorf("classifier answered %q, which is not one of the choices",
+			utils.Truncate(resp.Content, 80))
+	}
+	return best, classifier, resp, nil
+}
+
+// record remembers d as the latest decision for sessionKey. Once
+// maxRouteDecisions sessions are remembered, the least recently routed one
+// is forgotten.
+func (r *ModelRouter) record(sessionKey string, d RouteDecision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.last[sessionKey]; !ok && len(r.last) >= maxRouteDecisions {
+		oldest := ""
+		var oldestAt time.Time
+		for key, rec := range r.last {
+			if oldest == "" || rec.at.Before(oldestAt) {
+				oldest, oldestAt = key, rec.at
+			}
+		}
+		delete(r.last, oldest)
+	}
+	r.last[sessionKey] = recordedDecision{RouteDecision: d, at: time.Now()}
+}
+
+// LastDecision returns the latest routing decision for sessionKey.
+func (r *ModelRouter) LastDecision(sessionKey string) (RouteDecision, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.last[sessionKey]
+	return rec.RouteDecision, ok
+}
+
+// Choices returns the models an explicit "/model <name>" hint may pick.
+func (r *ModelRouter) Choices() []string {
+	return r.choices
+}
+
+// routeTurn asks the router which model serves a turn, logs the choice and
+// records the classifier's token usage, which is charged to the turn's quota.
+func (al *AgentLoop) routeTurn(
+	ctx context.Context,
+	agent *AgentInstance,
+	opts processOptions,
+	history []providers.Message,
+) RouteDecision {
+	d := al.router.Route(ctx, agent, routeInput{
+		Hint:    opts.ModelHint,
+		Message: opts.UserMessage,
+		Media:   opts.Media,
+		History: history,
+	})
+	if d.classifierResp != nil {
+		al.recordUsage(agent, opts.SessionKey, opts.Channel, usage.KindRouting,
+			d.classifier.candidates[0].Provider, d.classifier.model, d.classifierResp)
+		al.chargeQuota(opts.Quota, []providers.Message{{Role: "user", Content: opts.UserMessage}},
+			d.classifierResp)
+	}
+	al.router.record(opts.SessionKey, d)
+
+	logger.InfoCF("agent", "Routed model",
+		map[string]any{
+			"agent_id":    agent.ID,
+			"session_key": opts.SessionKey,
+			"model":       d.Model,
+			"reason":      d.Reason,
+		})
+	return d
+}
+
+// modelCommand checks an explicit "/model <name> <message>" hint. A valid
+// hint is left unhandled so the message reaches the agent, which strips it
+// and routes the turn to the named model.
+func (al *AgentLoop) modelCommand(args []string) (string, bool) {
+	if al.router == nil {
+		return "Model routing is disabled. Enable agents.defaults.routing to choose a model per message.", true
+	}
+	names := al.router.Choices()
+	if len(names) == 0 {
+		return "No models can be chosen with /model. Add routing rules or classifier choices to offer some.", true
+	}
+	available := fmt.Sprintf("Available models: %s", strings.Join(names, ", "))
+	if len(args) < 2 {
+		return "Usage: /model <name> <message>\n" + available, true
+	}
+	if !slices.Contains(names, args[0]) {
+		return fmt.Sprintf("Unknown model: %s\n%s", args[0], available), true
+	}
+	return "", false
+}
+
+func (rule routingRule) matches(in routeInput) bool {
+	length := utf8.RuneCountInString(in.Message)
+	if rule.MinLength > 0 && length < rule.MinLength {
+		return false
+	}
+	if rule.MaxLength > 0 && length > rule.MaxLength {
+		return false
+	}
+	if rule.HasMedia != nil && *rule.HasMedia != (len(in.Media) > 0) {
+		return false
+	}
+	if rule.MinToolCalls > 0 && recentToolResults(in.History) < rule.MinToolCalls {
+		return false
+	}
+	if len(rule.keywords) > 0 {
+		lower := strings.ToLower(in.Message)
+		found := false
+		for _, kw := range rule.keywords {
+			if strings.Contains(lower, kw) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.pattern != nil && !rule.pattern.MatchString(in.Message) {
+		return false
+	}
+	return true
+}
+
+func (rule routingRule) reason(i int) string {
+	if rule.Name != "" {
+		return fmt.Sprintf("rule %q", rule.Name)
+	}
+	return fmt.Sprintf("rule %d", i+1)
+}
+
+// recentToolResults counts tool results among the most recent history
+// messages.
+func recentToolResults(history []providers.Message) int {
+	if len(history) > routingToolWindow {
+		history = history[len(history)-routingToolWindow:]
+	}
+	n := 0
+	for _, m := range history {
+		if m.Role == "tool" {
+			n++
+		}
+	}
+	return n
+}
+
+// parseModelHint splits an explicit "/model <name> <message>" prefix off
+// content. ok is false when content carries no hint.
+func parseModelHint(content string) (model, rest string, ok bool) {
+	after, found := strings.CutPrefix(strings.TrimSpace(content), "/model")
+	if !found {
+		return "", content, false
+	}
+	if after != "" {
+		if r, _ := utf8.DecodeRuneInString(after); !unicode.IsSpace(r) {
+			return "", content, false
+		}
+	}
+	after = strings.TrimSpace(after)
+	i := strings.IndexFunc(after, unicode.IsSpace)
+	if i < 0 {
+		return after, "", true
+	}
+	return after[:i], strings.TrimSpace(after[i:]), true
+}
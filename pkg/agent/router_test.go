@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// routedProvider answers with reply and counts its calls.
+type routedProvider struct {
+	reply func(messages []providers.Message) (*providers.LLMResponse, error)
+	calls atomic.Int32
+}
+
+func (p *routedProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	defs []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+) (*providers.LLMResponse, error) {
+	p.calls.Add(1)
+	return p.reply(messages)
+}
+
+func (p *routedProvider) GetDefaultModel() string { return "routed" }
+
+func answer(content string) func([]providers.Message) (*providers.LLMResponse, error) {
+	return func([]providers.Message) (*providers.LLMResponse, error) {
+		return &providers.LLMResponse{Content: content}, nil
+	}
+}
+
+func newRoutingTestConfig(t *testing.T, routing config.RoutingConfig) *config.Config {
+	t.Helper()
+	routing.Enabled = true
+	return &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Routing:           routing,
+			},
+		},
+		ModelList: []config.ModelConfig{
+			{ModelName: "cheap", Model: "openai/cheap-1"},
+			{ModelName: "strong", Model: "anthropic/strong-1"},
+			{ModelName: "vision", Model: "openai/vision-1"},
+		},
+	}
+}
+
+// withProviders makes the router serve model_list entries from ps.
+func withProviders(r *ModelRouter, ps map[string]*routedProvider) {
+	r.newProvider = func(mc *config.ModelConfig) (providers.LLMProvider, string, error) {
+		p, ok := ps[mc.ModelName]
+		if !ok {
+			return nil, "", errors.New("no provider")
+		}
+		_, modelID := providers.ExtractProtocol(mc.Model)
+		return p, modelID, nil
+	}
+}
+
+func TestParseModelHint(t *testing.T) {
+	tests := []struct {
+		in, model, rest string
+		ok              bool
+	}{
+		{"/model strong explain monads", "strong", "explain monads", true},
+		{"  /model cheap\nwhat time is it?", "cheap", "what time is it?", true},
+		{"/model", "", "", true},
+		{"/models list", "", "/models list", false},
+		{"hello /model strong", "", "hello /model strong", false},
+	}
+	for _, tt := range tests {
+		model, rest, ok := parseModelHint(tt.in)
+		if model != tt.model || rest != tt.rest || ok != tt.ok {
+			t.Errorf("parseModelHint(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.in, model, rest, ok, tt.model, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestModelRouter_Rules(t *testing.T) {
+	hasMedia := true
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{
+		Rules: []config.RoutingRule{
+			{Model: "vision", HasMedia: &hasMedia},
+			{Name: "short", Model: "cheap", MaxLength: 20},
+			{Model: "strong", Keywords: []string{"REFACTOR"}},
+			{Model: "strong", Pattern: `(?i)\bprove\b`},
+			{Model: "strong", MinToolCalls: 3},
+			{Model: "cheap", Pattern: `(`}, // invalid, skipped
+			{Model: "missing", Keywords: []string{"never-matches-anything"}},
+		},
+	})
+	r := NewModelRouter(cfg)
+	withProviders(r, map[string]*routedProvider{
+		"cheap": {}, "strong": {}, "vision": {},
+	})
+	if got := strings.Join(r.Choices(), ","); got != "vision,cheap,strong,missing" {
+		t.Errorf("Choices() = %s", got)
+	}
+	agent := &AgentInstance{ID: "main", Model: "test-model"}
+
+	toolHeavy := []providers.Message{
+		{Role: "tool"}, {Role: "tool"}, {Role: "assistant"}, {Role: "tool"},
+	}
+	long := strings.Repeat("tell me more about this topic ", 3)
+	tests := []struct {
+		name   string
+		in     routeInput
+		model  string
+		reason string
+	}{
+		{"hint", routeInput{Hint: "strong", Message: "hi"}, "strong", "explicit /model hint"},
+		{"media", routeInput{Message: "what is this?", Media: []string{"media://1"}}, "vision", "rule 1"},
+		{"short", routeInput{Message: "thanks!"}, "cheap", `rule "short"`},
+		{"keyword", routeInput{Message: long + "please refactor"}, "strong", "rule 3"},
+		{"pattern", routeInput{Message: long + "Prove it"}, "strong", "rule 4"},
+		{"tool-heavy", routeInput{Message: long, History: toolHeavy}, "strong", "rule 5"},
+		{"default", routeInput{Message: long}, "test-model", "default model"},
+		{"unavailable", routeInput{Hint: "missing", Message: "hi"}, "test-model",
+			"explicit /model hint chose missing, which is unavailable"},
+		{"hint not a choice", routeInput{Hint: "test-model", Message: long}, "test-model", "default model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := r.Route(t.Context(), agent, tt.in)
+			if d.Model != tt.model || d.Reason != tt.reason {
+				t.Errorf("Route() = %s (%s), want %s (%s)", d.Model, d.Reason, tt.model, tt.reason)
+			}
+			if (d.target != nil) != (tt.model != agent.Model) {
+				t.Errorf("target = %+v", d.target)
+			}
+		})
+	}
+}
+
+func TestModelRouter_Classifier(t *testing.T) {
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{
+		Classifier: &config.RoutingClassifierConfig{
+			Model: "cheap",
+			Choices: []config.RoutingChoice{
+				{Model: "cheap", Description: "small talk"},
+				{Model: "strong", Description: "reasoning and code"},
+			},
+		},
+	})
+	r := NewModelRouter(cfg)
+	var prompt string
+	classifier := &routedProvider{reply: func(messages []providers.Message) (*providers.LLMResponse, error) {
+		prompt = messages[0].Content
+		return &providers.LLMResponse{Content: " Strong\n"}, nil
+	}}
+	withProviders(r, map[string]*routedProvider{"cheap": classifier, "strong": {}})
+	agent := &AgentInstance{ID: "main", Model: "test-model"}
+
+	d := r.Route(t.Context(), agent, routeInput{Message: "why is my goroutine leaking?"})
+	if d.Model != "strong" || d.Reason != "classifier cheap" || d.classifierResp == nil {
+		t.Fatalf("Route() = %+v", d)
+	}
+	if !strings.Contains(prompt, "- strong: reasoning and code") {
+		t.Errorf("classifier prompt = %q", prompt)
+	}
+
+	classifier.reply = answer("no idea")
+	if d := r.Route(t.Context(), agent, routeInput{Message: "?"}); d.Model != "test-model" {
+		t.Errorf("unrecognised answer should use the agent's model, got %+v", d)
+	}
+}
+
+func TestProcessMessage_EscalatesWhenRoutedModelFails(t *testing.T) {
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{
+		Rules:           []config.RoutingRule{{Model: "cheap", MaxLength: 100}},
+		EscalationModel: "strong",
+	})
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "default"})
+	cheap := &routedProvider{reply: func([]providers.Message) (*providers.LLMResponse, error) {
+		return nil, errors.New("API request failed:\n  Status: 400\n  Body:   bad request")
+	}}
+	strong := &routedProvider{reply: answer("escalated answer")}
+	withProviders(al.router, map[string]*routedProvider{"cheap": cheap, "strong": strong})
+
+	helper := testHelper{al: al}
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "hi"}
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "escalated answer" {
+		t.Fatalf("response = %q", got)
+	}
+	if cheap.calls.Load() != 1 || strong.calls.Load() != 1 {
+		t.Errorf("calls: cheap=%d strong=%d", cheap.calls.Load(), strong.calls.Load())
+	}
+
+	msg.Content = "/show model"
+	show := helper.executeAndGetResponse(t, context.Background(), msg)
+	if !strings.Contains(show, "Last turn: strong (escalated from cheap: cheap failed)") {
+		t.Errorf("/show model = %q", show)
+	}
+}
+
+func TestProcessMessage_EscalatesAtMaxIterations(t *testing.T) {
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{EscalationModel: "strong"})
+	cfg.Agents.Defaults.MaxToolIterations = 2
+	looping := &routedProvider{reply: func([]providers.Message) (*providers.LLMResponse, error) {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{
+			{ID: "a", Name: "counting_tool", Arguments: map[string]any{}},
+		}}, nil
+	}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), looping)
+	al.RegisterTool(&countingTool{})
+	strong := &routedProvider{reply: answer("finally")}
+	withProviders(al.router, map[string]*routedProvider{"strong": strong})
+
+	helper := testHelper{al: al}
+	got := helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "loop forever",
+	})
+	if got != "finally" {
+		t.Fatalf("response = %q", got)
+	}
+	if looping.calls.Load() != 2 || strong.calls.Load() != 1 {
+		t.Errorf("calls: looping=%d strong=%d", looping.calls.Load(), strong.calls.Load())
+	}
+}
+
+func TestModelRouter_RecordEvictsOldest(t *testing.T) {
+	r := NewModelRouter(newRoutingTestConfig(t, config.RoutingConfig{}))
+	for i := 0; i <= maxRouteDecisions; i++ {
+		r.record(fmt.Sprintf("session-%d", i), RouteDecision{Model: "cheap"})
+	}
+	if len(r.last) != maxRouteDecisions {
+		t.Errorf("%d decisions remembered, want %d", len(r.last), maxRouteDecisions)
+	}
+	if _, ok := r.LastDecision("session-0"); ok {
+		t.Error("the oldest decision should have been forgotten")
+	}
+	if _, ok := r.LastDecision(fmt.Sprintf("session-%d", maxRouteDecisions)); !ok {
+		t.Error("the newest decision should be remembered")
+	}
+}
+
+func TestProcessMessage_ClassifierTokensChargedToQuota(t *testing.T) {
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{
+		Classifier: &config.RoutingClassifierConfig{
+			Model:   "cheap",
+			Choices: []config.RoutingChoice{{Model: "strong", Description: "everything"}},
+		},
+	})
+	cfg.Quotas = config.QuotaConfig{Enabled: true, PerUser: config.QuotaLimits{TokensPerDay: 100}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "default"})
+	classifier := &routedProvider{reply: func([]providers.Message) (*providers.LLMResponse, error) {
+		usage := &providers.UsageInfo{PromptTokens: 70, CompletionTokens: 10}
+		return &providers.LLMResponse{Content: "strong", Usage: usage}, nil
+	}}
+	strong := &routedProvider{reply: func([]providers.Message) (*providers.LLMResponse, error) {
+		usage := &providers.UsageInfo{PromptTokens: 20, CompletionTokens: 10}
+		return &providers.LLMResponse{Content: "answer", Usage: usage}, nil
+	}}
+	withProviders(al.router, map[string]*routedProvider{"cheap": classifier, "strong": strong})
+
+	helper := testHelper{al: al}
+	msg := bus.InboundMessage{
+		Channel: "telegram", ChatID: "chat1", SenderID: "42", Content: "hi",
+		Sender: bus.SenderInfo{Platform: "telegram", PlatformID: "42", CanonicalID: "telegram:42"},
+	}
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "answer" {
+		t.Fatalf("first response = %q", got)
+	}
+	// 80 classifier tokens and 30 answer tokens exhaust the budget of 100.
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got == "answer" {
+		t.Error("classifier tokens should count towards the daily token quota")
+	}
+}
+
+func TestProcessMessage_ModelHint(t *testing.T) {
+	cfg := newRoutingTestConfig(t, config.RoutingConfig{
+		Rules: []config.RoutingRule{{Model: "strong", Keywords: []string{"prove"}}},
+	})
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "default"})
+	var seen string
+	strong := &routedProvider{reply: func(messages []providers.Message) (*providers.LLMResponse, error) {
+		seen = messages[len(messages)-1].Content
+		return &providers.LLMResponse{Content: "strong answer"}, nil
+	}}
+	withProviders(al.router, map[string]*routedProvider{"strong": strong})
+
+	helper := testHelper{al: al}
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "chat1", SenderID: "42"}
+
+	msg.Content = "/model strong explain monads"
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); got != "strong answer" {
+		t.Fatalf("response = %q", got)
+	}
+	if seen != "explain monads" {
+		t.Errorf("model saw %q, want the hint stripped", seen)
+	}
+
+	msg.Content = "/model nope hi"
+	if got := helper.executeAndGetResponse(t, context.Background(), msg); !strings.HasPrefix(got, "Unknown model: nope") {
+		t.Errorf("unknown model reply = %q", got)
+	}
+
+	// Models routing cannot choose are not offered, so hints cannot get
+	// around cost routing.
+	msg.Content = "/model vision hi"
+	got := helper.executeAndGetResponse(t, context.Background(), msg)
+	if !strings.HasPrefix(got, "Unknown model: vision") {
+		t.Errorf("non-choice model reply = %q", got)
+	}
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+const (
+	// semanticRecallTimeout bounds the embedding request made while building
+	// the prompt; on timeout the turn proceeds without recalled memories.
+	semanticRecallTimeout = 10 * time.Second
+	// semanticImportTimeout bounds (re-)importing MEMORY.md.
+	semanticImportTimeout = 60 * time.Second
+	// longTermMemorySource tags index entries imported from MEMORY.md.
+	longTermMemorySource = "MEMORY.md"
+)
+
+// initSemanticMemory opens the agent's semantic memory index at
+// workspace/memory/semantic.db, along with the embedder it uses, through
+// which the agent loop records embedding usage. It returns nils if semantic
+// memory is disabled or cannot be set up; failures are logged and the agent
+// falls back to injecting MEMORY.md verbatim.
+func initSemanticMemory(
+	cfg *config.Config,
+	smCfg config.SemanticMemoryConfig,
+	workspace string,
+) (*memory.SemanticMemory, *meteredEmbedder) {
+	if !smCfg.Enabled {
+		return nil, nil
+	}
+	if smCfg.EmbeddingModel == "" {
+		logger.WarnCF("agent", "Semantic memory enabled without embedding_model, disabling", nil)
+		return nil, nil
+	}
+
+	modelCfg, err := cfg.GetModelConfig(smCfg.EmbeddingModel)
+	if err != nil {
+		logger.ErrorCF("agent", "Semantic memory disabled: embedding model not found",
+			map[string]any{"embedding_model": smCfg.EmbeddingModel, "error": err.Error()})
+		return nil, nil
+	}
+	embedder, err := providers.CreateEmbedderFromConfig(modelCfg)
+	if err != nil {
+		logger.ErrorCF("agent", "Semantic memory disabled: cannot create embedder",
+			map[string]any{"embedding_model": smCfg.EmbeddingModel, "error": err.Error()})
+		return nil, nil
+	}
+	metered := &meteredEmbedder{embedder: embedder}
+	metered.provider, metered.model = providers.ExtractProtocol(modelCfg.Model)
+
+	sm, err := memory.NewSemanticMemory(filepath.Join(workspace, "memory", "semantic.db"), metered)
+	if err != nil {
+		logger.ErrorCF("agent", "Semantic memory disabled: cannot open index",
+			map[string]any{"workspace": workspace, "error": err.Error()})
+		return nil, nil
+	}
+	return sm, metered
+}
+
+// meteredEmbedder reports the token usage of each embedding call to the
+// recorder set with setRecorder, along with the session of the tool call
+// that made it (empty for recalls while building the prompt).
+type meteredEmbedder struct {
+	embedder        providers.Embedder
+	provider, model string
+
+	mu     sync.Mutex
+	record func(sessionKey, provider, model string, usage *providers.UsageInfo)
+}
+
+func (e *meteredEmbedder) setRecorder(record func(sessionKey, provider, model string, usage *providers.UsageInfo)) {
+	e.mu.Lock()
+	e.record = record
+	e.mu.Unlock()
+}
+
+func (e *meteredEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	reporting, ok := e.embedder.(providers.UsageReportingEmbedder)
+	if !ok {
+		return e.embedder.Embed(ctx, texts)
+	}
+	vectors, usage, err := reporting.EmbedWithUsage(ctx, texts)
+	if err != nil || usage == nil {
+		return vectors, err
+	}
+	e.mu.Lock()
+	record := e.record
+	e.mu.Unlock()
+	if record != nil {
+		record(tools.CallInfoFromContext(ctx).SessionKey, e.provider, e.model, usage)
+	}
+	return vectors, nil
+}
+
+// SetSemanticMemory switches the builder from injecting MEMORY.md in full
+// to injecting the topK memories most relevant to each message. MEMORY.md
+// is imported into the index and re-imported whenever it changes.
+func (cb *ContextBuilder) SetSemanticMemory(sm *memory.SemanticMemory, topK int, minScore float64) {
+	cb.semanticMu.Lock()
+	cb.semantic = sm
+	cb.semanticTopK = topK
+	cb.semanticMinScore = minScore
+	cb.memoryImportedAt = time.Time{}
+	cb.semanticMu.Unlock()
+
+	cb.InvalidateCache()
+}
+
+func (cb *ContextBuilder) semanticMemory() *memory.SemanticMemory {
+	cb.semanticMu.Lock()
+	defer cb.semanticMu.Unlock()
+	return cb.semantic
+}
+
+// syncLongTermMemory imports MEMORY.md into the semantic index if it was
+// modified since the last import. Entries are deduplicated by content, so
+// only new or edited paragraphs are embedded, and paragraphs that were
+// removed or edited away are deleted from the index.
+func (cb *ContextBuilder) syncLongTermMemory(sm *memory.SemanticMemory) {
+	info, err := os.Stat(cb.memory.memoryFile)
+	if err != nil {
+		return
+	}
+
+	cb.semanticMu.Lock()
+	upToDate := !info.ModTime().After(cb.memoryImportedAt)
+	cb.semanticMu.Unlock()
+	if upToDate {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), semanticImportTimeout)
+	defer cancel()
+	n, err := sm.ImportMarkdown(ctx, longTermMemorySource, cb.memory.ReadLongTerm())
+	if err != nil {
+		logger.WarnCF("agent", "Failed to import MEMORY.md into semantic memory",
+			map[string]any{"error": err.Error()})
+		return
+	}
+
+	cb.semanticMu.Lock()
+	cb.memoryImportedAt = info.ModTime()
+	cb.semanticMu.Unlock()
+	logger.DebugCF("agent", "Imported MEMORY.md into semantic memory", map[string]any{"entries": n})
+}
+
+// buildRecalledMemories returns a prompt section with the memories most
+// relevant to message, or "" when semantic memory is disabled or nothing
+// relevant was found.
+func (cb *ContextBuilder) buildRecalledMemories(message string) string {
+	sm := cb.semanticMemory()
+	if sm == nil || strings.TrimSpace(message) == "" {
+		return ""
+	}
+	cb.syncLongTermMemory(sm)
+
+	cb.semanticMu.Lock()
+	topK, minScore := cb.semanticTopK, cb.semanticMinScore
+	cb.semanticMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), semanticRecallTimeout)
+	defer cancel()
+	recalled, err := sm.Recall(ctx, message, topK, minScore)
+	if err != nil {
+		logger.WarnCF("agent", "Semantic memory recall failed", map[string]any{"error": err.Error()})
+		return ""
+	}
+	if len(recalled) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Relevant Memories\n\nLong-term memories related to the current message, most relevant first:\n")
+	for _, r := range recalled {
+		fmt.Fprintf(&sb, "\n- %s", strings.ReplaceAll(r.Content, "\n", " "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+// wordHashEmbedder hashes words into buckets so that texts sharing words
+// have similar vectors, standing in for a real embedding model.
+type wordHashEmbedder struct{}
+
+func (wordHashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, 128)
+		for _, w := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,:;!?")))
+			vec[h.Sum32()%128]++
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+func TestContextBuilder_SemanticMemoryRecallsRelevantEntries(t *testing.T) {
+	workspace := t.TempDir()
+	memoryMD := "# Long-term Memory\n\n- Favorite color is teal\n- Allergic to peanuts\n"
+	if err := os.MkdirAll(filepath.Join(workspace, "memory"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace, "memory", "MEMORY.md"), []byte(memoryMD), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := memory.NewSemanticMemory(filepath.Join(workspace, "memory", "semantic.db"), wordHashEmbedder{})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	defer sm.Close()
+
+	cb := NewContextBuilder(workspace)
+	cb.SetSemanticMemory(sm, 1, 0.1)
+
+	if prompt := cb.BuildSystemPrompt(); strings.Contains(prompt, "teal") || !strings.Contains(prompt, "memory_save") {
+		t.Errorf("static prompt should not inline MEMORY.md and should mention memory_save:\n%s", prompt)
+	}
+
+	msgs := cb.BuildMessages(nil, "", "does this dish contain peanuts?", nil, "cli", "direct")
+	system := msgs[0].Content
+	if !strings.Contains(system, "# Relevant Memories") || !strings.Contains(system, "Allergic to peanuts") {
+		t.Errorf("expected recalled memory in system prompt:\n%s", system)
+	}
+	if strings.Contains(system, "teal") {
+		t.Errorf("expected only top-1 memory, got:\n%s", system)
+	}
+	last := msgs[0].SystemParts[len(msgs[0].SystemParts)-1]
+	if !strings.Contains(last.Text, "Allergic to peanuts") || last.CacheControl != nil {
+		t.Errorf("recalled memories should be an uncached dynamic block, got %+v", last)
+	}
+
+	// Unrelated messages inject nothing.
+	msgs = cb.BuildMessages(nil, "", "hello there", nil, "cli", "direct")
+	if strings.Contains(msgs[0].Content, "# Relevant Memories") {
+		t.Errorf("unexpected memories for unrelated message:\n%s", msgs[0].Content)
+	}
+}
+
+func TestContextBuilder_WithoutSemanticMemoryInlinesMemoryFile(t *testing.T) {
+	workspace := t.TempDir()
+	os.MkdirAll(filepath.Join(workspace, "memory"), 0o755)
+	os.WriteFile(filepath.Join(workspace, "memory", "MEMORY.md"), []byte("Favorite color is teal"), 0o644)
+
+	cb := NewContextBuilder(workspace)
+	if prompt := cb.BuildSystemPrompt(); !strings.Contains(prompt, "Favorite color is teal") {
+		t.Errorf("expected MEMORY.md in static prompt:\n%s", prompt)
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// streamPublishTimeout bounds how long a partial update may wait for room on
+// the outbound bus. Partial updates are best-effort and superseded by the
+// next one, so they are dropped rather than allowed to stall the stream.
+const streamPublishTimeout = 2 * time.Second
+
+// streamPublisher forwards streamed LLM text to the outbound bus as partial
+// messages, throttled to at most one update per interval.
+type streamPublisher struct {
+	ctx      context.Context
+	bus      *bus.MessageBus
+	channel  string
+	chatID   string
+	interval time.Duration
+
+	mu       sync.Mutex
+	text     strings.Builder
+	lastSent time.Time
+}
+
+// newStreamPublisher returns a publisher for the target chat, or nil when
+// streaming is disabled or the target channel can neither edit its messages
+// nor consume partial replies directly.
+func (al *AgentLoop) newStreamPublisher(ctx context.Context, opts processOptions) *streamPublisher {
+	streamCfg := al.cfg.Agents.Defaults.Streaming
+	if !streamCfg.Enabled || opts.Channel == "" || opts.ChatID == "" {
+		return nil
+	}
+	if constants.IsInternalChannel(opts.Channel) || al.channelManager == nil {
+		return nil
+	}
+	ch, ok := al.channelManager.GetChannel(opts.Channel)
+	if !ok {
+		return nil
+	}
+	_, canEdit := ch.(channels.MessageEditor)
+	_, canStream := ch.(channels.StreamCapable)
+	if !canEdit && !canStream {
+		return nil
+	}
+	return &streamPublisher{
+		ctx:      ctx,
+		bus:      al.bus,
+		channel:  opts.Channel,
+		chatID:   opts.ChatID,
+		interval: streamCfg.GetUpdateInterval(),
+	}
+}
+
+// reset discards accumulated text. It is called before every LLM attempt so
+// that a retried or fallback request starts from an empty reply.
+func (s *streamPublisher) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text.Reset()
+	s.lastSent = time.Time{}
+}
+
+// onDelta implements providers.StreamCallback.
+func (s *streamPublisher) onDelta(delta string) {
+	s.mu.Lock()
+	s.text.WriteString(delta)
+	if time.Since(s.lastSent) < s.interval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSent = time.Now()
+	content := s.text.String()
+	s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	pubCtx, pubCancel := context.WithTimeout(s.ctx, streamPublishTimeout)
+	defer pubCancel()
+
+	if err := s.bus.PublishOutbound(pubCtx, bus.OutboundMessage{
+		Channel: s.channel,
+		ChatID:  s.chatID,
+		Content: content,
+		Partial: true,
+	}); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+			errors.Is(err, bus.ErrBusClosed) {
+			logger.DebugCF("agent", "Stream update skipped (timeout/cancel)", map[string]any{
+				"channel": s.channel,
+				"error":   err.Error(),
+			})
+		} else {
+			logger.WarnCF("agent", "Failed to publish stream update (best-effort)", map[string]any{
+				"channel": s.channel,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
+// chat calls provider.ChatStream when both the provider and the publisher
+// support streaming, and falls back to a plain Chat call otherwise.
+func (s *streamPublisher) chat(
+	ctx context.Context,
+	provider providers.LLMProvider,
+	messages []providers.Message,
+	tools []providers.ToolDefinition,
+	model string,
+	options map[string]any,
+) (*providers.LLMResponse, error) {
+	if s != nil {
+		if sp, ok := provider.(providers.StreamingProvider); ok {
+			s.reset()
+			return sp.ChatStream(ctx, messages, tools, model, options, s.onDelta)
+		}
+	}
+	return provider.Chat(ctx, messages, tools, model, options)
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+type fakeEditorChannel struct{ fakeChannel }
+
+func (f *fakeEditorChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
+	return nil
+}
+
+type streamingMockProvider struct {
+	mockProvider
+	deltas   []string
+	streamed bool
+}
+
+func (m *streamingMockProvider) ChatStream(
+	ctx context.Context,
+	messages []providers.Message,
+	tools []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+	onDelta providers.StreamCallback,
+) (*providers.LLMResponse, error) {
+	m.streamed = true
+	var content string
+	for _, d := range m.deltas {
+		content += d
+		onDelta(d)
+	}
+	return &providers.LLMResponse{Content: content}, nil
+}
+
+func newStreamTestLoop(t *testing.T, enabled bool) (*AgentLoop, *bus.MessageBus) {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "agent-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(tmpDir) })
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				Streaming:         config.StreamingConfig{Enabled: enabled, UpdateIntervalMS: 1},
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &mockProvider{})
+
+	chManager, err := channels.NewManager(&config.Config{}, bus.NewMessageBus(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create channel manager: %v", err)
+	}
+	chManager.RegisterChannel("telegram", &fakeEditorChannel{})
+	chManager.RegisterChannel("slack", &fakeChannel{})
+	al.SetChannelManager(chManager)
+	return al, msgBus
+}
+
+func TestNewStreamPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		channel string
+		want    bool
+	}{
+		{name: "editor channel", enabled: true, channel: "telegram", want: true},
+		{name: "disabled", enabled: false, channel: "telegram", want: false},
+		{name: "channel without editor", enabled: true, channel: "slack", want: false},
+		{name: "internal channel", enabled: true, channel: "cli", want: false},
+		{name: "unknown channel", enabled: true, channel: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al, _ := newStreamTestLoop(t, tt.enabled)
+			got := al.newStreamPublisher(context.Background(), processOptions{Channel: tt.channel, ChatID: "c1"})
+			if (got != nil) != tt.want {
+				t.Fatalf("newStreamPublisher() = %v, want non-nil %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamPublisher_PublishesPartialUpdates(t *testing.T) {
+	al, msgBus := newStreamTestLoop(t, true)
+	s := al.newStreamPublisher(context.Background(), processOptions{Channel: "telegram", ChatID: "c1"})
+	if s == nil {
+		t.Fatal("expected a stream publisher")
+	}
+
+	provider := &streamingMockProvider{deltas: []string{"Hel", "lo"}}
+	resp, err := s.chat(context.Background(), provider, nil, nil, "test-model", nil)
+	if err != nil {
+		t.Fatalf("chat() error = %v", err)
+	}
+	if !provider.streamed {
+		t.Fatal("expected ChatStream to be used")
+	}
+	if resp.Content != "Hello" {
+		t.Fatalf("Content = %q, want %q", resp.Content, "Hello")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	msg, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("expected a partial outbound message")
+	}
+	if !msg.Partial || msg.Channel != "telegram" || msg.ChatID != "c1" || msg.Content != "Hel" {
+		t.Fatalf("unexpected outbound message: %+v", msg)
+	}
+}
+
+func TestStreamPublisher_NilFallsBackToChat(t *testing.T) {
+	var s *streamPublisher
+	provider := &streamingMockProvider{deltas: []string{"ignored"}}
+	resp, err := s.chat(context.Background(), provider, nil, nil, "test-model", nil)
+	if err != nil {
+		t.Fatalf("chat() error = %v", err)
+	}
+	if provider.streamed {
+		t.Fatal("expected plain Chat when streaming is unavailable")
+	}
+	if resp.Content != "Mock response" {
+		t.Fatalf("Content = %q, want %q", resp.Content, "Mock response")
+	}
+}
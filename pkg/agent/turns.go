@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// maxQueuedTurns bounds the messages waiting for their turn to start.
+// Consuming from the bus blocks while the queue is full.
+const maxQueuedTurns = 16
+
+// turnScheduler runs one turn at a time, in arrival order within each chat.
+// A turn waiting for a tool approval gives up its slot until the decision,
+// so other chats are served in the meantime; later messages from its own
+// chat still wait for it to finish.
+type turnScheduler struct {
+	handle func(ctx context.Context, msg bus.InboundMessage)
+	slot   chan struct{}
+	queued chan struct{}
+
+	mu      sync.Mutex
+	pending map[string][]bus.InboundMessage // per chat; present while its worker runs
+	workers sync.WaitGroup
+}
+
+func newTurnScheduler(handle func(ctx context.Context, msg bus.InboundMessage)) *turnScheduler {
+	return &turnScheduler{
+		handle:  handle,
+		slot:    make(chan struct{}, 1),
+		queued:  make(chan struct{}, maxQueuedTurns),
+		pending: make(map[string][]bus.InboundMessage),
+	}
+}
+
+// submit queues msg behind earlier messages from the same chat. It returns
+// false if ctx is done before there is room in the queue.
+func (s *turnScheduler) submit(ctx context.Context, msg bus.InboundMessage) bool {
+	select {
+	case s.queued <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	key := msg.Channel + "\x00" + msg.ChatID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if queue, ok := s.pending[key]; ok {
+		s.pending[key] = append(queue, msg)
+		return true
+	}
+	s.pending[key] = nil
+	s.workers.Add(1)
+	go s.work(ctx, key, msg)
+	return true
+}
+
+// work runs the turns of one chat until its queue is empty.
+func (s *turnScheduler) work(ctx context.Context, key string, msg bus.InboundMessage) {
+	defer s.workers.Done()
+	for {
+		s.run(ctx, msg)
+
+		s.mu.Lock()
+		queue := s.pending[key]
+		if len(queue) == 0 {
+			delete(s.pending, key)
+			s.mu.Unlock()
+			return
+		}
+		msg, s.pending[key] = queue[0], queue[1:]
+		s.mu.Unlock()
+	}
+}
+
+func (s *turnScheduler) run(ctx context.Context, msg bus.InboundMessage) {
+	s.slot <- struct{}{}
+	<-s.queued
+	lease := &turnLease{slot: s.slot}
+	defer lease.end()
+	s.handle(tools.WithApprovalWait(ctx, lease.pause, lease.resume), msg)
+}
+
+// wait blocks until every submitted turn has finished.
+func (s *turnScheduler) wait() {
+	s.workers.Wait()
+}
+
+// turnLease is a turn's hold on the scheduler slot. Tool calls run in
+// parallel may wait for approval at the same time; the slot is released
+// while any of them waits and taken back once none does.
+type turnLease struct {
+	slot    chan struct{}
+	mu      sync.Mutex
+	waiting int
+}
+
+func (l *turnLease) pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting++
+	if l.waiting == 1 {
+		<-l.slot
+	}
+}
+
+func (l *turnLease) resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting--
+	if l.waiting == 0 {
+		l.slot <- struct{}{}
+	}
+}
+
+func (l *turnLease) end() {
+	<-l.slot
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
+)
+
+// recordUsage appends the token usage of an LLM call to the usage ledger.
+// Calls whose provider did not report usage are skipped. Recording uses its
+// own timeout so that usage is not lost when the turn's context is canceled.
+func (al *AgentLoop) recordUsage(
+	agent *AgentInstance,
+	sessionKey, channel, kind, provider, model string,
+	resp *providers.LLMResponse,
+) {
+	if al.ledger == nil || resp == nil || resp.Usage == nil {
+		return
+	}
+	if provider == "" && len(agent.Candidates) > 0 {
+		// Calls made with the agent's own model, recorded under the
+		// resolved model ID like chat calls.
+		provider, model = agent.Candidates[0].Provider, agent.Candidates[0].Model
+	}
+
+	u := resp.Usage
+	record := usage.Record{
+		AgentID:          agent.ID,
+		SessionKey:       sessionKey,
+		Channel:          channel,
+		Model:            model,
+		Provider:         provider,
+		Kind:             kind,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Cost:             al.prices.Cost(provider, model, u.PromptTokens, u.CompletionTokens),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := al.ledger.Add(ctx, record); err != nil {
+		logger.WarnCF("agent", "Failed to record token usage",
+			map[string]any{"agent_id": agent.ID, "error": err.Error()})
+	}
+}
+
+// usageCommand implements "/usage [today|week|month|all]": token and cost
+// totals for the current chat's session and per model across all sessions.
+func (al *AgentLoop) usageCommand(ctx context.Context, msg bus.InboundMessage, args []string) string {
+	if al.ledger == nil {
+		return "Usage tracking is not available"
+	}
+
+	period := "today"
+	if len(args) > 0 {
+		period = strings.ToLower(args[0])
+	}
+	since, err := usagePeriodStart(period, time.Now())
+	if err != nil {
+		return "Usage: /usage [today|week|month|all]"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Token usage (%s)", period)
+
+	if _, sessionKey, _, err := al.routeMessage(msg); err == nil {
+		totals, err := al.ledger.Totals(ctx, usage.Filter{Since: since, SessionKey: sessionKey}, usage.GroupNone)
+		if err != nil {
+			return fmt.Sprintf("Failed to read usage: %v", err)
+		}
+		sb.WriteString("\n\nThis chat: ")
+		if len(totals) == 0 {
+			sb.WriteString("no calls")
+		} else {
+			sb.WriteString(formatUsageTotal(totals[0]))
+		}
+	}
+
+	byModel, err := al.ledger.Totals(ctx, usage.Filter{Since: since}, usage.GroupModel)
+	if err != nil {
+		return fmt.Sprintf("Failed to read usage: %v", err)
+	}
+	sb.WriteString("\n\nAll chats by model:")
+	if len(byModel) == 0 {
+		sb.WriteString("\nno calls")
+	}
+	for _, t := range byModel {
+		fmt.Fprintf(&sb, "\n- %s: %s", t.Key, formatUsageTotal(t))
+	}
+	return sb.String()
+}
+
+// usagePeriodStart returns the start of a named reporting period ending now.
+// "all" returns the zero time.
+func usagePeriodStart(period string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "today":
+		return midnight, nil
+	case "week":
+		return midnight.AddDate(0, 0, -6), nil
+	case "month":
+		return midnight.AddDate(0, 0, -29), nil
+	case "all":
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown period %q", period)
+	}
+}
+
+func formatUsageTotal(t usage.Total) string {
+	s := fmt.Sprintf("%d calls, %d tokens (%d in / %d out)",
+		t.Calls, t.TotalTokens(), t.PromptTokens, t.CompletionTokens)
+	if t.Cost > 0 {
+		s += fmt.Sprintf(", cost %.4f", t.Cost)
+	}
+	return s
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
+)
+
+type usageReportingProvider struct{}
+
+func (p *usageReportingProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	tools []providers.ToolDefinition,
+	model string,
+	opts map[string]any,
+) (*providers.LLMResponse, error) {
+	return &providers.LLMResponse{
+		Content: "done",
+		Usage:   &providers.UsageInfo{PromptTokens: 1200, CompletionTokens: 300, TotalTokens: 1500},
+	}, nil
+}
+
+func (p *usageReportingProvider) GetDefaultModel() string {
+	return "priced-model"
+}
+
+func TestProcessMessage_RecordsUsage(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				ModelName:         "priced",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		ModelList: []config.ModelConfig{{
+			ModelName: "priced",
+			Model:     "openai/priced-model",
+			APIKey:    "test",
+			Pricing:   &config.ModelPricing{InputPerMillion: 1, OutputPerMillion: 10},
+		}},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &usageReportingProvider{})
+	if al.ledger == nil {
+		t.Fatal("expected usage ledger to be opened")
+	}
+	defer al.ledger.Close()
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "u1", ChatID: "c1", Content: "hi"}
+	helper := testHelper{al: al}
+	helper.executeAndGetResponse(t, context.Background(), msg)
+
+	totals, err := al.ledger.Totals(context.Background(), usage.Filter{Channel: "telegram"}, usage.GroupModel)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(totals) != 1 {
+		t.Fatalf("got %d groups, want 1: %+v", len(totals), totals)
+	}
+	got := totals[0]
+	if got.Key != "priced-model" || got.Calls != 1 || got.PromptTokens != 1200 || got.CompletionTokens != 300 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+	if want := 0.0042; got.Cost < want-1e-9 || got.Cost > want+1e-9 {
+		t.Errorf("Cost = %v, want %v", got.Cost, want)
+	}
+
+	msg.Content = "/usage"
+	reply := helper.executeAndGetResponse(t, context.Background(), msg)
+	for _, want := range []string{"This chat: 1 calls, 1500 tokens", "- priced-model: 1 calls", "cost 0.0042"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("/usage reply missing %q:\n%s", want, reply)
+		}
+	}
+
+	msg.Content = "/usage forever"
+	if reply := helper.executeAndGetResponse(t, context.Background(), msg); !strings.HasPrefix(reply, "Usage: /usage") {
+		t.Errorf("expected usage hint, got %q", reply)
+	}
+}
+
+func TestAgentLoop_RecordsEmbeddingUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[{"index":0,"embedding":[0.6,0.8]}],"usage":{"prompt_tokens":7,"total_tokens":7}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+				SemanticMemory:    config.SemanticMemoryConfig{Enabled: true, EmbeddingModel: "emb"},
+			},
+		},
+		ModelList: []config.ModelConfig{{
+			ModelName: "emb",
+			Model:     "openai/text-embedding-3-small",
+			APIBase:   server.URL,
+			APIKey:    "test",
+		}},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &usageReportingProvider{})
+	agent := al.registry.GetDefaultAgent()
+	if al.ledger == nil || agent.SemanticMemory == nil {
+		t.Fatal("expected usage ledger and semantic memory to be set up")
+	}
+
+	ctx := tools.WithCallInfo(context.Background(), tools.CallInfo{SessionKey: "s1"})
+	if _, err := agent.SemanticMemory.Save(ctx, "likes tea"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	totals, err := al.ledger.Totals(context.Background(), usage.Filter{SessionKey: "s1"}, usage.GroupModel)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(totals) != 1 || totals[0].Key != "text-embedding-3-small" || totals[0].PromptTokens != 7 {
+		t.Fatalf("totals = %+v, want the embedding call", totals)
+	}
+
+	al.Close()
+	if err := al.ledger.Add(context.Background(), usage.Record{Model: "x"}); err == nil {
+		t.Error("the ledger should be closed with the loop")
+	}
+}
+
+func TestUsagePeriodStart(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 4, 0, 0, time.Local)
+	midnight := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+
+	tests := map[string]time.Time{
+		"today": midnight,
+		"week":  midnight.AddDate(0, 0, -6),
+		"month": midnight.AddDate(0, 0, -29),
+		"all":   {},
+	}
+	for period, want := range tests {
+		got, err := usagePeriodStart(period, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("usagePeriodStart(%q) = %v, %v; want %v", period, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package audit records tool executions in an append-only JSONL log that is
+// rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/redact"
+)
+
+const (
+	defaultMaxSizeMB  = 10
+	defaultMaxBackups = 5
+
+	// maxArgLength is the longest string argument kept verbatim; longer
+	// values (file contents, scripts) are truncated.
+	maxArgLength = 256
+
+	redacted = "[REDACTED]"
+)
+
+// Entry is one tool execution.
+type Entry struct {
+	Time       time.Time      `json:"time"`
+	AgentID    string         `json:"agent_id,omitempty"`
+	SessionKey string         `json:"session_key,omitempty"`
+	Channel    string         `json:"channel,omitempty"`
+	ChatID     string         `json:"chat_id,omitempty"`
+	Sender     string         `json:"sender,omitempty"` // canonical sender ID ("telegram:123")
+	Tool       string         `json:"tool"`
+	Args       map[string]any `json:"args,omitempty"` // redacted, see RedactArgs
+	DurationMS int64          `json:"duration_ms"`
+	IsError    bool           `json:"is_error"`
+	ResultSize int            `json:"result_size"`
+}
+
+// Logger appends entries to a JSONL file. When the file grows beyond the
+// size limit it is renamed with a timestamp suffix and a new file is
+// started; only the newest backups are kept.
+type Logger struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+// Open opens (or creates) the audit log at path. Zero limits use the
+// defaults of 10 MB per file and 5 backups.
+func Open(path string, maxSizeMB, maxBackups int) (*Logger, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+	l := &Logger{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("audit: create log dir: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: open log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit: stat log: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends e to the log.
+func (l *Logger) Write(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit: encode entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return fmt.Errorf("audit: log is closed")
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("audit: write entry: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one.
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("audit: close log: %w", err)
+	}
+	l.f = nil
+	backup := backupName(l.path, time.Now())
+	if err := os.Rename(l.path, backup); err != nil {
+		// Keep appending to the oversized file rather than losing entries.
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("audit: rotate log: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	backups, err := Backups(l.path)
+	if err != nil {
+		return err
+	}
+	for len(backups) > l.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// backupName returns "<dir>/<name>-<timestamp><ext>" for path "<dir>/<name><ext>".
+// The timestamp format sorts chronologically.
+func backupName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + t.UTC().Format("20060102T150405.000") + ext
+}
+
+// Backups returns the rotated files of the log at path, oldest first.
+func Backups(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	matches, err := filepath.Glob(glob(strings.TrimSuffix(path, ext)) + "-*" + glob(ext))
+	if err != nil {
+		return nil, fmt.Errorf("audit: list backups: %w", err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// glob escapes the pattern metacharacters of a literal path.
+func glob(s string) string {
+	return strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`).Replace(s)
+}
+
+// sensitiveKey matches argument names whose values must never be logged.
+var sensitiveKey = regexp.MustCompile(
+	`(?i)(passw(or)?d|^pass$|secret|token|api[_-]?key|^auth$|authorization|credential|private[_-]?key|cookie)`)
+
+// RedactArgs returns a copy of tool arguments that is safe to log: values
+// of keys that look like credentials are replaced, secrets inside other
+// strings (such as a token in an exec command) are masked with
+// redact.String, and long strings (file contents, scripts) are truncated.
+func RedactArgs(args map[string]any) map[string]any {
+	if args == nil {
+		return nil
+	}
+	out := make(map[string]any, len(args))
+	for k, v := range args {
+		if sensitiveKey.MatchString(k) {
+			out[k] = redacted
+			continue
+		}
+		out[k] = redactValue(v)
+	}
+	return out
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		v = redact.String(v)
+		if utf8.RuneCountInString(v) <= maxArgLength {
+			return v
+		}
+		runes := []rune(v)
+		return fmt.Sprintf("%s... (%d chars)", string(runes[:maxArgLength]), len(runes))
+	case map[string]any:
+		return RedactArgs(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			out[i] = redactValue(x)
+		}
+		return out
+	default:
+		return v
+	}
+}
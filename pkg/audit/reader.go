@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Filter selects log entries. Zero fields match everything.
+type Filter struct {
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	AgentID    string
+	SessionKey string
+	Channel    string
+	ChatID     string
+	Sender     string
+	Tool       string
+	ErrorsOnly bool
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case !f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && !e.Time.Before(f.Until),
+		f.AgentID != "" && e.AgentID != f.AgentID,
+		f.SessionKey != "" && e.SessionKey != f.SessionKey,
+		f.Channel != "" && e.Channel != f.Channel,
+		f.ChatID != "" && e.ChatID != f.ChatID,
+		f.Sender != "" && e.Sender != f.Sender,
+		f.Tool != "" && e.Tool != f.Tool,
+		f.ErrorsOnly && !e.IsError:
+		return false
+	}
+	return true
+}
+
+// Read calls fn for every entry matching f, oldest first, across the
+// rotated backups and the current file at path. Malformed lines are skipped.
+func Read(path string, f Filter, fn func(Entry) error) error {
+	files, err := Backups(path)
+	if err != nil {
+		return err
+	}
+	files = append(files, path)
+	for _, name := range files {
+		if err := readFile(name, f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, f Filter, fn func(Entry) error) error {
+	file, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("audit: open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		e, ok := decodeLine(scanner.Bytes())
+		if !ok || !f.Match(e) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("audit: read %s: %w", name, err)
+	}
+	return nil
+}
+
+func decodeLine(line []byte) (Entry, bool) {
+	var e Entry
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || json.Unmarshal(line, &e) != nil {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Follow calls fn for every entry matching f that is appended to the log at
+// path after Follow starts, until ctx is canceled. It keeps following the
+// new file when the log is rotated.
+func Follow(ctx context.Context, path string, f Filter, interval time.Duration, fn func(Entry) error) error {
+	var (
+		file    *os.File
+		reader  *bufio.Reader
+		partial []byte
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// open (re)opens the log; fromEnd skips the entries already present.
+	open := func(fromEnd bool) error {
+		if file != nil {
+			file.Close()
+			file = nil
+		}
+		fh, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("audit: open %s: %w", path, err)
+		}
+		if fromEnd {
+			if _, err := fh.Seek(0, io.SeekEnd); err != nil {
+				fh.Close()
+				return fmt.Errorf("audit: seek %s: %w", path, err)
+			}
+		}
+		file, reader, partial = fh, bufio.NewReader(fh), nil
+		return nil
+	}
+	if err := open(true); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if file != nil {
+			for {
+				chunk, err := reader.ReadBytes('\n')
+				partial = append(partial, chunk...)
+				if err != nil {
+					break // incomplete line; wait for the rest
+				}
+				if e, ok := decodeLine(partial); ok && f.Match(e) {
+					if err := fn(e); err != nil {
+						return err
+					}
+				}
+				partial = nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Reopen from the start when the log was rotated or created.
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if file == nil {
+			if err := open(false); err != nil {
+				return err
+			}
+			continue
+		}
+		if current, err := file.Stat(); err == nil && !os.SameFile(current, info) {
+			if err := open(false); err != nil {
+				return err
+			}
+		}
+	}
+}
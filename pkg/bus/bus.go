@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/redact"
 )
 
 // ErrBusClosed is returned when publishing to a closed MessageBus.
@@ -58,10 +59,13 @@ func (mb *MessageBus) ConsumeInbound(ctx context.Context) (InboundMessage, bool)
 	}
 }
 
+// PublishOutbound queues a message for delivery to a channel. Secrets in
+// the content are redacted first.
 func (mb *MessageBus) PublishOutbound(ctx context.Context, msg OutboundMessage) error {
 	if mb.closed.Load() {
 		return ErrBusClosed
 	}
+	msg.Content = redact.String(msg.Content)
 	if err := ctx.Err(); err != nil {
 		return err
 	}
@@ -90,6 +94,14 @@ func (mb *MessageBus) PublishOutboundMedia(ctx context.Context, msg OutboundMedi
 	if mb.closed.Load() {
 		return ErrBusClosed
 	}
+	if len(msg.Parts) > 0 {
+		parts := make([]MediaPart, len(msg.Parts))
+		for i, p := range msg.Parts {
+			p.Caption = redact.String(p.Caption)
+			parts[i] = p
+		}
+		msg.Parts = parts
+	}
 	if err := ctx.Err(); err != nil {
 		return err
 	}
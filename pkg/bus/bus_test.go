@@ -2,6 +2,7 @@ package bus
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -227,3 +228,31 @@ func TestCloseIdempotent(t *testing.T) {
 		t.Fatalf("expected ErrBusClosed after multiple closes, got %v", err)
 	}
 }
+
+func TestPublishOutbound_RedactsSecrets(t *testing.T) {
+	mb := NewMessageBus()
+	defer mb.Close()
+
+	ctx := context.Background()
+	key := "sk-proj-FAKEfakeFAKEfake0123456789abcdef"
+
+	if err := mb.PublishOutbound(ctx, OutboundMessage{Channel: "telegram", ChatID: "1", Content: "key: " + key}); err != nil {
+		t.Fatalf("PublishOutbound failed: %v", err)
+	}
+	got, _ := mb.SubscribeOutbound(ctx)
+	if strings.Contains(got.Content, key) {
+		t.Errorf("secret leaked in outbound content: %q", got.Content)
+	}
+
+	parts := []MediaPart{{Type: "file", Ref: "media://1", Caption: "key: " + key}}
+	if err := mb.PublishOutboundMedia(ctx, OutboundMediaMessage{Channel: "telegram", ChatID: "1", Parts: parts}); err != nil {
+		t.Fatalf("PublishOutboundMedia failed: %v", err)
+	}
+	media, _ := mb.SubscribeOutboundMedia(ctx)
+	if strings.Contains(media.Parts[0].Caption, key) {
+		t.Errorf("secret leaked in media caption: %q", media.Parts[0].Caption)
+	}
+	if parts[0].Caption != "key: "+key {
+		t.Error("PublishOutboundMedia modified the caller's parts")
+	}
+}
@@ -29,10 +29,41 @@ type InboundMessage struct {
 	Metadata   map[string]string `json:"metadata,omitempty"`
 }
 
+// Inbound metadata keys describing threads and replies. Channels set them
+// when the platform provides the information.
+const (
+	// MetadataThreadID is the platform thread the message was posted in.
+	MetadataThreadID = "thread_id"
+	// MetadataReplyToMessageID is the message the user replied to.
+	MetadataReplyToMessageID = "reply_to_message_id"
+	// MetadataReplyToText is the text of that message.
+	MetadataReplyToText = "reply_to_text"
+	// MetadataReplyToSender is the display name of its author.
+	MetadataReplyToSender = "reply_to_sender"
+)
+
+// MetadataEphemeral, set to "true" on an inbound message, runs its turn in a
+// session that is discarded afterwards. Channels whose requests carry their
+// own history, such as the OpenAI-compatible API, use it for one-off requests.
+const MetadataEphemeral = "ephemeral"
+
 type OutboundMessage struct {
 	Channel string `json:"channel"`
 	ChatID  string `json:"chat_id"`
 	Content string `json:"content"`
+	// ReplyToMessageID is the platform message this one answers and ThreadID
+	// the thread to post it in. Channels implementing ThreadCapable honor
+	// them; others send a plain message.
+	ReplyToMessageID string `json:"reply_to_message_id,omitempty"`
+	ThreadID         string `json:"thread_id,omitempty"`
+	// Partial marks an in-progress streamed reply. Content carries the full text
+	// generated so far; channels only use it to edit a pending placeholder and
+	// drop it otherwise. The final reply is always sent as a non-partial message.
+	Partial bool `json:"partial,omitempty"`
+	// Final marks the reply that ends the agent's turn for an inbound message.
+	// When the message tool already answered, it is sent with empty Content
+	// and only reaches channels that complete requests on it (TurnCompleter).
+	Final bool `json:"final,omitempty"`
 }
 
 // MediaPart describes a single media attachment to send.
@@ -232,6 +232,21 @@ func (c *BaseChannel) HandleMessage(
 	media []string,
 	metadata map[string]string,
 	senderOpts ...bus.SenderInfo,
+) {
+	c.HandleMessageInSession(ctx, "", peer, messageID, senderID, chatID, content, media, metadata, senderOpts...)
+}
+
+// HandleMessageInSession is HandleMessage with a pre-set session key. An
+// agent-scoped key ("agent:<id>:...") bypasses session routing and selects
+// the agent it names.
+func (c *BaseChannel) HandleMessageInSession(
+	ctx context.Context,
+	sessionKey string,
+	peer bus.Peer,
+	messageID, senderID, chatID, content string,
+	media []string,
+	metadata map[string]string,
+	senderOpts ...bus.SenderInfo,
 ) {
 	// Use SenderInfo-based allow check when available, else fall back to string
 	var sender bus.SenderInfo
@@ -266,6 +281,7 @@ func (c *BaseChannel) HandleMessage(
 		Peer:       peer,
 		MessageID:  messageID,
 		MediaScope: scope,
+		SessionKey: sessionKey,
 		Metadata:   metadata,
 	}
 
@@ -284,8 +300,10 @@ func (c *BaseChannel) HandleMessage(
 				c.placeholderRecorder.RecordReactionUndo(c.name, chatID, undo)
 			}
 		}
-		// Placeholder — independent pipeline
-		if pc, ok := c.owner.(PlaceholderCapable); ok {
+		// Placeholder — independent pipeline. It is skipped when the reply will
+		// quote the message or go to its thread: the placeholder is posted as a
+		// plain chat message, and editing it could not move it there.
+		if pc, ok := c.owner.(PlaceholderCapable); ok && !c.repliesInThread(peer, messageID, metadata) {
 			if phID, err := pc.SendPlaceholder(ctx, chatID); err == nil && phID != "" {
 				c.placeholderRecorder.RecordPlaceholder(c.name, chatID, phID)
 			}
@@ -301,6 +319,19 @@ func (c *BaseChannel) HandleMessage(
 	}
 }
 
+// repliesInThread reports whether the agent's reply to a message will carry
+// a reply target or thread (see ThreadCapable): messages in groups are quoted
+// and threaded messages are answered in their thread.
+func (c *BaseChannel) repliesInThread(peer bus.Peer, messageID string, metadata map[string]string) bool {
+	if _, ok := c.owner.(ThreadCapable); !ok {
+		return false
+	}
+	if metadata[bus.MetadataThreadID] != "" {
+		return true
+	}
+	return messageID != "" && (peer.Kind == "group" || peer.Kind == "channel")
+}
+
 func (c *BaseChannel) SetRunning(running bool) {
 	c.running.Store(running)
 }
@@ -1,6 +1,7 @@
 package channels
 
 import (
+	"context"
 	"testing"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
@@ -263,3 +264,39 @@ func TestIsAllowedSender(t *testing.T) {
 		})
 	}
 }
+
+type placeholderChannel struct {
+	*BaseChannel
+	placeholders int
+}
+
+func (c *placeholderChannel) Start(context.Context) error                     { return nil }
+func (c *placeholderChannel) Stop(context.Context) error                      { return nil }
+func (c *placeholderChannel) Send(context.Context, bus.OutboundMessage) error { return nil }
+
+func (c *placeholderChannel) SendReply(context.Context, bus.OutboundMessage) error { return nil }
+
+func (c *placeholderChannel) SendPlaceholder(context.Context, string) (string, error) {
+	c.placeholders++
+	return "ph", nil
+}
+
+func TestHandleMessage_NoPlaceholderForThreadedReplies(t *testing.T) {
+	m := newTestManager()
+	ch := &placeholderChannel{BaseChannel: NewBaseChannel("test", nil, bus.NewMessageBus(), nil)}
+	ch.SetOwner(ch)
+	ch.SetPlaceholderRecorder(m)
+	ctx := context.Background()
+
+	ch.HandleMessage(ctx, bus.Peer{Kind: "direct", ID: "u1"}, "1", "u1", "dm", "hi", nil, nil)
+	ch.HandleMessage(ctx, bus.Peer{Kind: "group", ID: "g1"}, "2", "u1", "g1", "hi", nil, nil)
+	ch.HandleMessage(ctx, bus.Peer{Kind: "direct", ID: "u1"}, "3", "u1", "topic", "hi", nil,
+		map[string]string{bus.MetadataThreadID: "7"})
+
+	if ch.placeholders != 1 {
+		t.Fatalf("sent %d placeholders, want only the one for the direct message", ch.placeholders)
+	}
+	if _, ok := m.placeholders.Load("test:dm"); !ok {
+		t.Error("placeholder of the direct message was not recorded")
+	}
+}
@@ -127,7 +127,43 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 		return nil
 	}
 
-	return c.sendChunk(ctx, channelID, msg.Content)
+	return c.sendChunk(ctx, channelID, &discordgo.MessageSend{Content: msg.Content})
+}
+
+// SendReply implements channels.ThreadCapable. Replies go to the thread of
+// ThreadID when set and reference the message being answered.
+func (c *DiscordChannel) SendReply(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	channelID := msg.ChatID
+	if msg.ThreadID != "" {
+		channelID = msg.ThreadID
+	}
+	if channelID == "" {
+		return fmt.Errorf("channel ID is empty")
+	}
+
+	if len([]rune(msg.Content)) == 0 {
+		return nil
+	}
+
+	data := &discordgo.MessageSend{Content: msg.Content}
+	if msg.ReplyToMessageID != "" {
+		failIfNotExists := false
+		data.Reference = &discordgo.MessageReference{
+			MessageID:       msg.ReplyToMessageID,
+			ChannelID:       channelID,
+			FailIfNotExists: &failIfNotExists,
+		}
+		// Mentioning the author on every reply would be noisy.
+		data.AllowedMentions = &discordgo.MessageAllowedMentions{
+			Parse:       []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeUsers},
+			RepliedUser: false,
+		}
+	}
+	return c.sendChunk(ctx, channelID, data)
 }
 
 // SendMedia implements the channels.MediaSender interface.
@@ -252,14 +288,14 @@ func (c *DiscordChannel) SendPlaceholder(ctx context.Context, chatID string) (st
 	return msg.ID, nil
 }
 
-func (c *DiscordChannel) sendChunk(ctx context.Context, channelID, content string) error {
+func (c *DiscordChannel) sendChunk(ctx context.Context, channelID string, data *discordgo.MessageSend) error {
 	// Use the passed ctx for timeout control
 	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
 	defer cancel()
 
 	done := make(chan error, 1)
 	go func() {
-		_, err := c.session.ChannelMessageSend(channelID, content)
+		_, err := c.session.ChannelMessageSendComplex(channelID, data)
 		done <- err
 	}()
 
@@ -411,6 +447,20 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		"channel_id":   m.ChannelID,
 		"is_dm":        fmt.Sprintf("%t", m.GuildID == ""),
 	}
+	// Threads are channels of their own; the parent channel's bindings
+	// still apply to them.
+	if ch, err := s.State.Channel(m.ChannelID); err == nil && ch.IsThread() {
+		metadata[bus.MetadataThreadID] = m.ChannelID
+		metadata["parent_peer_kind"] = "channel"
+		metadata["parent_peer_id"] = ch.ParentID
+	}
+	if parent := m.ReferencedMessage; parent != nil {
+		metadata[bus.MetadataReplyToMessageID] = parent.ID
+		metadata[bus.MetadataReplyToText] = parent.Content
+		if parent.Author != nil {
+			metadata[bus.MetadataReplyToSender] = parent.Author.Username
+		}
+	}
 
 	c.HandleMessage(c.ctx, peer, m.ID, senderID, m.ChannelID, content, mediaPaths, metadata, sender)
 }
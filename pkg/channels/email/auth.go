@@ -0,0 +1,129 @@
+package email
+
+import (
+	"strings"
+)
+
+// authResult is one method result of an Authentication-Results header
+// (RFC 8601), such as "dkim=pass header.d=example.com".
+type authResult struct {
+	method string
+	result string
+	props  map[string]string // e.g. "header.d" -> "example.com"
+}
+
+// parseAuthResults splits an Authentication-Results header value into the
+// authserv-id of the server that added it and its method results.
+func parseAuthResults(value string) (string, []authResult) {
+	parts := strings.Split(stripComments(value), ";")
+	fields := strings.Fields(parts[0])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	servID := strings.ToLower(fields[0])
+
+	var results []authResult
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		method, _, _ = strings.Cut(method, "/")
+		r := authResult{
+			method: strings.ToLower(method),
+			result: strings.ToLower(result),
+			props:  make(map[string]string),
+		}
+		for _, prop := range fields[1:] {
+			if key, val, ok := strings.Cut(prop, "="); ok {
+				r.props[strings.ToLower(key)] = strings.ToLower(strings.Trim(val, `"`))
+			}
+		}
+		results = append(results, r)
+	}
+	return servID, results
+}
+
+// stripComments removes the parenthesized comments of a header value.
+func stripComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// authenticated reports whether the receiving mail server verified that m
+// comes from the domain of its From address: DMARC, or DKIM or SPF aligned
+// with that domain, passed. Only Authentication-Results headers added by
+// authServID are considered, or only the topmost one (the one added last,
+// by the receiving server) when authServID is empty. Headers further down
+// may have been written by the sender.
+func (m *inboundEmail) authenticated(authServID string) bool {
+	if m.From == nil {
+		return false
+	}
+	fromDomain := domainOf(m.From.Address)
+	for _, header := range m.AuthResults {
+		servID, results := parseAuthResults(header)
+		if authServID != "" && servID != strings.ToLower(authServID) {
+			continue
+		}
+		for _, r := range results {
+			if r.result != "pass" {
+				continue
+			}
+			var domain string
+			switch r.method {
+			case "dmarc":
+				if r.props["header.from"] == fromDomain {
+					return true
+				}
+				continue
+			case "dkim":
+				domain = r.props["header.d"]
+				if domain == "" {
+					domain = domainOf(r.props["header.i"])
+				}
+			case "spf":
+				domain = domainOf(r.props["smtp.mailfrom"])
+			default:
+				continue
+			}
+			if aligned(domain, fromDomain) {
+				return true
+			}
+		}
+		if authServID == "" {
+			break
+		}
+	}
+	return false
+}
+
+// domainOf returns the lower-cased domain of an address, or s itself if it
+// has no local part.
+func domainOf(s string) string {
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.ToLower(s)
+}
+
+// aligned reports whether an authenticated domain covers fromDomain: it is
+// the same domain or a parent of it (relaxed alignment).
+func aligned(domain, fromDomain string) bool {
+	return domain != "" && (fromDomain == domain || strings.HasSuffix(fromDomain, "."+domain))
+}
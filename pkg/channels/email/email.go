@@ -0,0 +1,564 @@
+// Package email implements a channel that reads mail from an IMAP mailbox
+// and replies over SMTP. Each email thread is a separate chat.
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Connection security modes.
+const (
+	securityTLS      = "tls"
+	securityStartTLS = "starttls"
+	securityNone     = "none"
+)
+
+const (
+	defaultPollInterval = 60 * time.Second
+	// idleTimeout restarts IDLE before servers drop idle connections
+	// (RFC 2177 recommends re-issuing it at least every 29 minutes).
+	idleTimeout    = 25 * time.Minute
+	reconnectDelay = 30 * time.Second
+	// maxThreads bounds the remembered threads; the oldest are forgotten.
+	maxThreads = 1000
+)
+
+// thread is what is needed to reply in an email thread.
+type thread struct {
+	replyTo    string // address of the last sender
+	subject    string
+	lastID     string // Message-ID of the last message
+	references []string
+}
+
+type EmailChannel struct {
+	*channels.BaseChannel
+	config    config.EmailConfig
+	from      mail.Address
+	tlsConfig *tls.Config
+	smtp      smtpSettings
+
+	mu          sync.Mutex
+	threads     map[string]*thread
+	threadOrder []string
+	sentIDs     map[string]string // Message-ID of a sent reply -> chat ID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewEmailChannel(cfg config.EmailConfig, messageBus *bus.MessageBus) (*EmailChannel, error) {
+	if cfg.IMAPHost == "" || cfg.SMTPHost == "" {
+		return nil, fmt.Errorf("email imap_host and smtp_host are required")
+	}
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("email username and password are required")
+	}
+	if cfg.IMAPSecurity == "" {
+		cfg.IMAPSecurity = securityTLS
+	}
+	if cfg.SMTPSecurity == "" {
+		cfg.SMTPSecurity = securityStartTLS
+	}
+	for _, s := range []string{cfg.IMAPSecurity, cfg.SMTPSecurity} {
+		if s != securityTLS && s != securityStartTLS && s != securityNone {
+			return nil, fmt.Errorf("email: invalid security mode %q (want tls, starttls or none)", s)
+		}
+	}
+	if cfg.IMAPPort == 0 {
+		cfg.IMAPPort = 993
+	}
+	if cfg.SMTPPort == 0 {
+		cfg.SMTPPort = 587
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+
+	fromAddress := cfg.FromAddress
+	if fromAddress == "" {
+		fromAddress = cfg.Username
+	}
+	from, err := mail.ParseAddress(fromAddress)
+	if err != nil {
+		return nil, fmt.Errorf("email: invalid from address %q: %w", fromAddress, err)
+	}
+	from.Name = cfg.FromName
+
+	// Addresses are compared case-insensitively.
+	allowFrom := make([]string, len(cfg.AllowFrom))
+	for i, a := range cfg.AllowFrom {
+		allowFrom[i] = strings.ToLower(strings.TrimSpace(a))
+	}
+
+	base := channels.NewBaseChannel("email", cfg, messageBus, allowFrom,
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &EmailChannel{
+		BaseChannel: base,
+		config:      cfg,
+		from:        *from,
+		tlsConfig:   &tls.Config{ServerName: cfg.IMAPHost},
+		smtp: smtpSettings{
+			addr:      net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+			host:      cfg.SMTPHost,
+			security:  cfg.SMTPSecurity,
+			username:  cfg.Username,
+			password:  cfg.Password,
+			tlsConfig: &tls.Config{ServerName: cfg.SMTPHost},
+		},
+		threads: make(map[string]*thread),
+		sentIDs: make(map[string]string),
+	}, nil
+}
+
+func (c *EmailChannel) Start(ctx context.Context) error {
+	logger.InfoC("email", "Starting Email channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	client, err := c.connect(c.ctx)
+	if err != nil {
+		c.cancel()
+		return err
+	}
+
+	c.done = make(chan struct{})
+	go c.run(client)
+
+	c.SetRunning(true)
+	logger.InfoCF("email", "Email channel started", map[string]any{
+		"mailbox": c.config.Mailbox,
+		"idle":    c.config.UseIDLE && client.hasCapability("IDLE"),
+	})
+	return nil
+}
+
+func (c *EmailChannel) Stop(ctx context.Context) error {
+	logger.InfoC("email", "Stopping Email channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.done != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("email", "Email channel stopped")
+	return nil
+}
+
+// connect opens an IMAP session with the mailbox selected.
+func (c *EmailChannel) connect(ctx context.Context) (*imapClient, error) {
+	addr := net.JoinHostPort(c.config.IMAPHost, strconv.Itoa(c.config.IMAPPort))
+	client, err := dialIMAP(ctx, addr, c.config.IMAPSecurity, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.login(c.config.Username, c.config.Password); err != nil {
+		client.close()
+		return nil, err
+	}
+	if err := client.selectMailbox(c.config.Mailbox); err != nil {
+		client.close()
+		return nil, fmt.Errorf("imap select %s: %w", c.config.Mailbox, err)
+	}
+	return client, nil
+}
+
+// run processes the mailbox until the channel is stopped, reconnecting
+// after errors.
+func (c *EmailChannel) run(client *imapClient) {
+	defer close(c.done)
+	for {
+		if client != nil {
+			err := c.serve(client)
+			if c.ctx.Err() != nil {
+				client.close()
+				return
+			}
+			client.logout()
+			logger.WarnCF("email", "IMAP session ended, reconnecting", map[string]any{"error": err.Error()})
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+
+		var err error
+		if client, err = c.connect(c.ctx); err != nil {
+			logger.ErrorCF("email", "IMAP reconnect failed", map[string]any{"error": err.Error()})
+			client = nil
+		}
+	}
+}
+
+// serve handles new mail and then waits for more, with IDLE when the server
+// supports it and by polling otherwise.
+func (c *EmailChannel) serve(client *imapClient) error {
+	useIDLE := c.config.UseIDLE && client.hasCapability("IDLE")
+	for {
+		if err := c.checkMailbox(client); err != nil {
+			return err
+		}
+		if useIDLE {
+			if err := client.idle(c.ctx, idleTimeout); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(c.pollInterval()):
+		}
+		if err := client.noop(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *EmailChannel) pollInterval() time.Duration {
+	if c.config.PollInterval > 0 {
+		return time.Duration(c.config.PollInterval) * time.Second
+	}
+	return defaultPollInterval
+}
+
+// checkMailbox handles every unseen message and marks it as seen.
+func (c *EmailChannel) checkMailbox(client *imapClient) error {
+	uids, err := client.searchUnseen()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		raw, err := client.fetchMessage(uid)
+		if err != nil {
+			return err
+		}
+		if m, err := parseMessage(raw); err != nil {
+			logger.WarnCF("email", "Failed to parse message", map[string]any{"uid": uid, "error": err.Error()})
+		} else {
+			c.handleEmail(m)
+		}
+		// Seen even when unparseable, so a broken message is not retried forever.
+		if err := client.markSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *EmailChannel) handleEmail(m *inboundEmail) {
+	if m.From == nil {
+		return
+	}
+	address := strings.ToLower(m.From.Address)
+	if address == strings.ToLower(c.from.Address) {
+		return
+	}
+	if m.AutoGenerated {
+		logger.DebugCF("email", "Ignoring automatic message", map[string]any{"from": address})
+		return
+	}
+
+	sender := bus.SenderInfo{
+		Platform:    "email",
+		PlatformID:  address,
+		CanonicalID: identity.BuildCanonicalID("email", address),
+		Username:    address,
+		DisplayName: m.From.Name,
+	}
+	// The From header is easy to forge; only trust it when the receiving
+	// server verified it.
+	if !m.authenticated(c.config.AuthServID) {
+		logger.DebugCF("email", "Ignoring message without a passing SPF/DKIM/DMARC check",
+			map[string]any{"from": address})
+		return
+	}
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("email", "Message rejected by allowlist", map[string]any{"from": address})
+		return
+	}
+
+	if m.MessageID == "" {
+		m.MessageID = c.newMessageID()
+	}
+	chatID := c.rememberThread(m, address)
+
+	content := stripQuotedReply(m.Text)
+	if len(m.References) == 0 && m.InReplyTo == "" && m.Subject != "" {
+		content = "Subject: " + m.Subject + "\n\n" + content
+	}
+
+	scope := channels.BuildMediaScope("email", chatID, m.MessageID)
+	var mediaRefs []string
+	for _, a := range m.Attachments {
+		ref := c.StoreMedia(a.Data, a.Filename, a.ContentType, scope)
+		if ref == "" {
+			continue
+		}
+		mediaRefs = append(mediaRefs, ref)
+		content = channels.AppendMediaLabel(content, a.ContentType, a.Filename)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "email",
+		"subject":    m.Subject,
+		"message_id": m.MessageID,
+	}
+
+	logger.DebugCF("email", "Received email", map[string]any{
+		"from":    address,
+		"thread":  chatID,
+		"subject": utils.Truncate(m.Subject, 50),
+	})
+
+	c.HandleMessage(c.ctx,
+		bus.Peer{Kind: "group", ID: chatID},
+		m.MessageID,
+		address,
+		chatID,
+		content,
+		mediaRefs,
+		metadata,
+		sender,
+	)
+}
+
+// rememberThread records where replies to m go and returns the chat of its
+// thread.
+func (c *EmailChannel) rememberThread(m *inboundEmail, address string) string {
+	refs := m.References
+	if len(refs) == 0 && m.InReplyTo != "" {
+		refs = []string{m.InReplyTo}
+	}
+	refs = append(append([]string(nil), refs...), m.MessageID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chatID := c.threadFor(m, address)
+	c.addThread(chatID, &thread{
+		replyTo:    address,
+		subject:    m.Subject,
+		lastID:     m.MessageID,
+		references: refs,
+	})
+	return chatID
+}
+
+// threadFor returns the chat that m continues: that of the newest
+// In-Reply-To or References entry that is a reply this channel sent to the
+// same address. Otherwise m starts a new chat keyed by the sender and its
+// own Message-ID, so quoting another conversation's Message-IDs does not
+// join it. c.mu must be held.
+func (c *EmailChannel) threadFor(m *inboundEmail, address string) string {
+	ids := append(append([]string(nil), m.References...), m.InReplyTo)
+	for i := len(ids) - 1; i >= 0; i-- {
+		chatID, ok := c.sentIDs[ids[i]]
+		if t := c.threads[chatID]; ok && t != nil && t.replyTo == address {
+			return chatID
+		}
+	}
+	return threadKey(address, m.MessageID)
+}
+
+// threadKey is the chat ID of the thread with address that starts with the
+// message rootID.
+func threadKey(address, rootID string) string {
+	return address + " " + rootID
+}
+
+// addThread stores t as the thread chatID, forgetting the oldest thread
+// when there are too many. c.mu must be held.
+func (c *EmailChannel) addThread(chatID string, t *thread) {
+	if _, ok := c.threads[chatID]; !ok {
+		c.threadOrder = append(c.threadOrder, chatID)
+		if len(c.threadOrder) > maxThreads {
+			c.forgetThread(c.threadOrder[0])
+			c.threadOrder = c.threadOrder[1:]
+		}
+	}
+	c.threads[chatID] = t
+}
+
+// forgetThread drops the thread chatID and its sent Message-IDs. c.mu must
+// be held.
+func (c *EmailChannel) forgetThread(chatID string) {
+	if t, ok := c.threads[chatID]; ok {
+		for _, id := range t.references {
+			if c.sentIDs[id] == chatID {
+				delete(c.sentIDs, id)
+			}
+		}
+	}
+	delete(c.threads, chatID)
+}
+
+// rememberReply records that the message id was sent in the thread chatID,
+// so that answers to it continue the thread.
+func (c *EmailChannel) rememberReply(chatID, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.threads[chatID]; ok {
+		t.lastID = id
+		t.references = append(t.references, id)
+		c.sentIDs[id] = chatID
+	}
+}
+
+// Send replies in the thread msg.ChatID. A chat ID that is an email address
+// instead of a thread starts a new thread with that address.
+func (c *EmailChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+	return c.send(ctx, msg.ChatID, msg.Content, nil)
+}
+
+// SendMedia implements the channels.MediaSender interface. The parts are
+// sent as attachments of one message, with the first caption as its text.
+func (c *EmailChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	store := c.GetMediaStore()
+	if store == nil {
+		return fmt.Errorf("no media store available: %w", channels.ErrSendFailed)
+	}
+
+	var caption string
+	attachments := make([]outgoingAttachment, 0, len(msg.Parts))
+	for _, part := range msg.Parts {
+		localPath, err := store.Resolve(part.Ref)
+		if err != nil {
+			logger.ErrorCF("email", "Failed to resolve media ref", map[string]any{
+				"ref":   part.Ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		attachments = append(attachments, outgoingAttachment{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Path:        localPath,
+		})
+		if caption == "" {
+			caption = part.Caption
+		}
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+	return c.send(ctx, msg.ChatID, caption, attachments)
+}
+
+func (c *EmailChannel) send(ctx context.Context, chatID, body string, attachments []outgoingAttachment) error {
+	out := outgoingEmail{
+		From:        c.from,
+		MessageID:   c.newMessageID(),
+		Body:        body,
+		Attachments: attachments,
+	}
+
+	c.mu.Lock()
+	t, ok := c.threads[chatID]
+	if ok {
+		out.To = []string{t.replyTo}
+		out.Subject = replySubject(t.subject)
+		out.InReplyTo = t.lastID
+		out.References = append([]string(nil), t.references...)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		if strings.Contains(chatID, "<") {
+			return fmt.Errorf("email: unknown thread %s: %w", chatID, channels.ErrSendFailed)
+		}
+		addr, err := mail.ParseAddress(chatID)
+		if err != nil {
+			return fmt.Errorf("email: invalid recipient %q: %w", chatID, channels.ErrSendFailed)
+		}
+		out.To = []string{addr.Address}
+		out.Subject = newSubject(body)
+	}
+
+	data, err := out.build(time.Now())
+	if err != nil {
+		return fmt.Errorf("email: build message: %w", channels.ErrSendFailed)
+	}
+	if err := sendSMTP(ctx, c.smtp, c.from.Address, out.To, data); err != nil {
+		logger.ErrorCF("email", "Failed to send email", map[string]any{
+			"to":    out.To,
+			"error": err.Error(),
+		})
+		return fmt.Errorf("email send: %w", channels.ErrTemporary)
+	}
+
+	if !ok {
+		// Answers to a new message start a thread with its recipient.
+		address := strings.ToLower(out.To[0])
+		chatID = threadKey(address, out.MessageID)
+		c.mu.Lock()
+		c.addThread(chatID, &thread{replyTo: address, subject: out.Subject})
+		c.mu.Unlock()
+	}
+	// Later replies continue from this message.
+	c.rememberReply(chatID, out.MessageID)
+	return nil
+}
+
+func (c *EmailChannel) newMessageID() string {
+	return "<" + uuid.New().String() + "@" + helloName(c.from.Address) + ">"
+}
+
+// replySubject prefixes subject with "Re: " unless it already is a reply.
+func replySubject(subject string) string {
+	if subject == "" {
+		return "Re: your message"
+	}
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// newSubject derives a subject for a new thread from the first line of body.
+func newSubject(body string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	line = strings.Trim(strings.TrimSpace(line), "#*_ ")
+	if line == "" {
+		return "Message from PicoClaw"
+	}
+	return utils.Truncate(line, 78)
+}
@@ -0,0 +1,307 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// imapCommandTimeout bounds a single IMAP command round trip.
+	imapCommandTimeout = 60 * time.Second
+	// maxLiteralSize is the largest literal (i.e. message) that is read.
+	maxLiteralSize = 50 << 20
+)
+
+// imapResponse is one untagged server response. Literals ("{n}\r\n" followed
+// by n bytes) are collected in order; the line keeps the "{n}" markers.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// imapClient is a minimal IMAP4rev1 client covering what the channel needs:
+// login, selecting a mailbox, searching and fetching unseen messages,
+// setting flags and IDLE.
+type imapClient struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	tag          int
+	capabilities map[string]bool
+}
+
+// dialIMAP connects to addr and reads the server greeting. security is
+// "tls", "starttls" or "none".
+func dialIMAP(ctx context.Context, addr, security string, tlsConfig *tls.Config) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if security == securityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("imap dial %s: %w", addr, err)
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetReadDeadline(time.Now().Add(imapCommandTimeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("imap greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("imap greeting: %s", greeting.line)
+	}
+
+	if security == securityStartTLS {
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("imap starttls: %w", err)
+		}
+		c.conn = tlsConn
+		c.r = bufio.NewReader(tlsConn)
+	}
+
+	if err := c.loadCapabilities(); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *imapClient) loadCapabilities() error {
+	responses, err := c.command("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	c.capabilities = make(map[string]bool)
+	for _, resp := range responses {
+		if fields := strings.Fields(resp.line); len(fields) > 1 && strings.EqualFold(fields[1], "CAPABILITY") {
+			for _, capability := range fields[2:] {
+				c.capabilities[strings.ToUpper(capability)] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (c *imapClient) hasCapability(name string) bool {
+	return c.capabilities[name]
+}
+
+func (c *imapClient) login(username, password string) error {
+	_, err := c.command("LOGIN " + quoteIMAP(username) + " " + quoteIMAP(password))
+	if err != nil {
+		return errors.New("imap login failed")
+	}
+	// Servers may advertise more capabilities (e.g. IDLE) once logged in.
+	return c.loadCapabilities()
+}
+
+func (c *imapClient) selectMailbox(name string) error {
+	_, err := c.command("SELECT " + quoteIMAP(name))
+	return err
+}
+
+func (c *imapClient) noop() error {
+	_, err := c.command("NOOP")
+	return err
+}
+
+// searchUnseen returns the UIDs of messages without the \Seen flag.
+func (c *imapClient) searchUnseen() ([]uint32, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.line)
+		if len(fields) < 2 || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, f := range fields[2:] {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// fetchMessage returns the raw RFC 5322 message with uid without marking it
+// as seen.
+func (c *imapClient) fetchMessage(uid uint32) ([]byte, error) {
+	responses, err := c.command(fmt.Sprintf("UID FETCH %d (BODY.PEEK[])", uid))
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range responses {
+		if strings.Contains(strings.ToUpper(resp.line), "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("imap fetch %d: message not returned", uid)
+}
+
+func (c *imapClient) markSeen(uid uint32) error {
+	_, err := c.command(fmt.Sprintf(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid))
+	return err
+}
+
+// idle waits until the server reports a mailbox change, timeout elapses or
+// ctx is cancelled. It returns nil in the first two cases.
+func (c *imapClient) idle(ctx context.Context, timeout time.Duration) error {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	if _, err := io.WriteString(c.conn, tag+" IDLE\r\n"); err != nil {
+		return err
+	}
+	resp, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp.line, "+") {
+		return fmt.Errorf("imap idle: %s", resp.line)
+	}
+
+	// Unblock the read below when ctx is cancelled.
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+			break
+		}
+		upper := strings.ToUpper(resp.line)
+		if strings.HasSuffix(upper, " EXISTS") || strings.HasSuffix(upper, " RECENT") {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	if _, err := io.WriteString(c.conn, "DONE\r\n"); err != nil {
+		return err
+	}
+	_, err = c.readUntilTagged(tag)
+	return err
+}
+
+func (c *imapClient) logout() {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+func (c *imapClient) close() error {
+	return c.conn.Close()
+}
+
+// command sends one tagged command and returns the untagged responses
+// received before its completion. A NO or BAD completion is an error.
+func (c *imapClient) command(cmd string) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, err
+	}
+	return c.readUntilTagged(tag)
+}
+
+func (c *imapClient) readUntilTagged(tag string) ([]imapResponse, error) {
+	var responses []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		rest, ok := strings.CutPrefix(resp.line, tag+" ")
+		if !ok {
+			responses = append(responses, resp)
+			continue
+		}
+		status, _, _ := strings.Cut(rest, " ")
+		if !strings.EqualFold(status, "OK") {
+			return nil, fmt.Errorf("imap: %s", rest)
+		}
+		return responses, nil
+	}
+}
+
+// readResponse reads one response line including any literals it contains.
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	var line strings.Builder
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+
+		n, ok := literalSize(part)
+		if !ok {
+			break
+		}
+		if n > maxLiteralSize {
+			return resp, fmt.Errorf("imap literal of %d bytes exceeds limit", n)
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+	resp.line = line.String()
+	return resp, nil
+}
+
+// literalSize parses a trailing "{n}" literal marker.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// quoteIMAP returns s as an IMAP quoted string.
+func quoteIMAP(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
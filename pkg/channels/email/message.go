@@ -0,0 +1,403 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAttachmentSize is the largest attachment that is kept.
+	maxAttachmentSize = 20 << 20
+	// maxMIMEDepth limits how deeply nested multiparts are walked.
+	maxMIMEDepth = 10
+)
+
+// inboundEmail is the part of a received message the channel uses.
+type inboundEmail struct {
+	From          *mail.Address
+	Subject       string
+	MessageID     string // including angle brackets
+	InReplyTo     string
+	References    []string
+	Text          string
+	Attachments   []attachment
+	AutoGenerated bool     // auto-replies, bounces and bulk mail
+	AuthResults   []string // Authentication-Results headers, topmost first
+
+	html string
+}
+
+type attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// parseMessage parses a raw RFC 5322 message.
+func parseMessage(raw []byte) (*inboundEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse message: %w", err)
+	}
+
+	m := &inboundEmail{
+		MessageID:   firstMessageID(msg.Header.Get("Message-ID")),
+		InReplyTo:   firstMessageID(msg.Header.Get("In-Reply-To")),
+		References:  messageIDs(msg.Header.Get("References")),
+		AuthResults: msg.Header["Authentication-Results"],
+	}
+	if subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		m.Subject = strings.TrimSpace(subject)
+	} else {
+		m.Subject = strings.TrimSpace(msg.Header.Get("Subject"))
+	}
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	if from, err := parser.Parse(msg.Header.Get("From")); err == nil {
+		m.From = from
+	}
+	m.AutoGenerated = isAutoGenerated(textproto.MIMEHeader(msg.Header))
+
+	if err := m.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, err
+	}
+	if m.Text == "" && m.html != "" {
+		m.Text = htmlToText(m.html)
+	}
+	m.Text = strings.TrimSpace(m.Text)
+	return m, nil
+}
+
+// walk collects the text body and attachments of a MIME entity.
+func (m *inboundEmail) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxMIMEDepth {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	body = transferDecoder(header.Get("Content-Transfer-Encoding"), body)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read multipart: %w", err)
+			}
+			err = m.walk(part.Header, part, depth+1)
+			part.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if disposition == "attachment" || filename != "" || !isText {
+		data, err := io.ReadAll(io.LimitReader(body, maxAttachmentSize+1))
+		if err != nil {
+			return fmt.Errorf("read attachment: %w", err)
+		}
+		if len(data) > maxAttachmentSize {
+			m.Text += fmt.Sprintf("\n[attachment too large: %s]", filename)
+			return nil
+		}
+		if filename == "" {
+			filename = "attachment" + extensionFor(mediaType)
+		}
+		m.Attachments = append(m.Attachments, attachment{Filename: filename, ContentType: mediaType, Data: data})
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxAttachmentSize))
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	text := strings.ReplaceAll(decodeCharset(data, params["charset"]), "\r\n", "\n")
+	switch {
+	case mediaType == "text/plain" && m.Text == "":
+		m.Text = text
+	case mediaType == "text/html" && m.html == "":
+		m.html = text
+	}
+	return nil
+}
+
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper drops line breaks and other whitespace so wrapped base64
+// does not upset the decoder.
+type newlineStripper struct{ r io.Reader }
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+// decodeCharset converts text in charset to UTF-8. UTF-8, ASCII and
+// ISO-8859-1 (and its Windows superset) are supported; other charsets are
+// passed through.
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		return string(data)
+	}
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "windows-1252", "cp1252", "latin1":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(decodeCharset(data, "iso-8859-1")), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+// isAutoGenerated reports whether a message was sent by software (vacation
+// replies, bounces, mailing list digests). Answering those risks mail loops.
+func isAutoGenerated(h textproto.MIMEHeader) bool {
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return h.Get("X-Autoreply") != "" || h.Get("X-Autorespond") != ""
+}
+
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+func messageIDs(s string) []string {
+	return messageIDPattern.FindAllString(s, -1)
+}
+
+func firstMessageID(s string) string {
+	return messageIDPattern.FindString(s)
+}
+
+var (
+	reHTMLDrop  = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	reHTMLBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	reHTMLTag   = regexp.MustCompile(`<[^>]*>`)
+	reBlankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText reduces an HTML body to plain text.
+func htmlToText(s string) string {
+	s = reHTMLDrop.ReplaceAllString(s, "")
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = reHTMLTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(reBlankRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+var reAttribution = regexp.MustCompile(`^(On .+ wrote:|-+ ?Original Message ?-+)$`)
+
+// stripQuotedReply removes the quoted previous message that mail clients
+// add below a reply, and the signature. The agent already has the earlier
+// messages in its session history.
+func stripQuotedReply(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if reAttribution.MatchString(trimmed) || strings.TrimRight(line, "\r") == "-- " {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func extensionFor(mediaType string) string {
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// outgoingEmail is a message to send over SMTP.
+type outgoingEmail struct {
+	From        mail.Address
+	To          []string
+	Subject     string
+	MessageID   string
+	InReplyTo   string
+	References  []string
+	Body        string
+	Attachments []outgoingAttachment
+}
+
+type outgoingAttachment struct {
+	Filename    string
+	ContentType string
+	Path        string
+}
+
+// build renders m as an RFC 5322 message with a quoted-printable UTF-8 text
+// body and base64 attachments.
+func (m *outgoingEmail) build(date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		to[i] = (&mail.Address{Address: addr}).String()
+	}
+	writeHeader("From", m.From.String())
+	writeHeader("To", strings.Join(to, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", m.MessageID)
+	if m.InReplyTo != "" {
+		writeHeader("In-Reply-To", m.InReplyTo)
+	}
+	if len(m.References) > 0 {
+		writeHeader("References", strings.Join(m.References, " "))
+	}
+	writeHeader("Auto-Submitted", "auto-replied")
+	writeHeader("MIME-Version", "1.0")
+
+	if len(m.Attachments) == 0 {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	textPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(textPart, m.Body); err != nil {
+		return nil, err
+	}
+
+	for _, a := range m.Attachments {
+		data, err := os.ReadFile(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("read attachment %s: %w", a.Filename, err)
+		}
+		filename := a.Filename
+		if filename == "" {
+			filename = filepath.Base(a.Path)
+		}
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if _, err := io.WriteString(qp, strings.ReplaceAll(text, "\n", "\r\n")); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64Lines writes data as base64 wrapped at 76 characters.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMessage(t *testing.T) {
+	raw := strings.ReplaceAll(`From: =?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>
+Subject: =?iso-8859-1?q?Gr=FC=DFe?=
+Message-ID: <a1@example.com>
+In-Reply-To: <root@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=outer
+
+--outer
+Content-Type: multipart/alternative; boundary=inner
+
+--inner
+Content-Type: text/html; charset=utf-8
+
+<html><head><style>p{}</style></head><body><p>Hallo &amp; willkommen</p></body></html>
+--inner
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+Sch=F6ne Gr=FC=DFe
+--inner--
+--outer
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0x
+LjQ=
+--outer--
+`, "\n", "\r\n")
+
+	m, err := parseMessage([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if m.From.Name != "Jürgen" || m.From.Address != "jurgen@example.com" {
+		t.Errorf("From = %+v", m.From)
+	}
+	if m.Subject != "Grüße" {
+		t.Errorf("Subject = %q", m.Subject)
+	}
+	if m.Text != "Schöne Grüße" {
+		t.Errorf("Text = %q, want the plain text alternative", m.Text)
+	}
+	if m.MessageID != "<a1@example.com>" || m.InReplyTo != "<root@example.com>" {
+		t.Errorf("Message-ID = %q, In-Reply-To = %q", m.MessageID, m.InReplyTo)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Filename != "invoice.pdf" ||
+		string(m.Attachments[0].Data) != "%PDF-1.4" {
+		t.Errorf("Attachments = %+v", m.Attachments)
+	}
+	if m.AutoGenerated {
+		t.Error("a personal message is not auto-generated")
+	}
+}
+
+func TestInboundEmailAuthenticated(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []string
+		servID  string
+		want    bool
+	}{
+		{"none", nil, "", false},
+		{"dkim", []string{"mx.example.com; dkim=pass (good signature) header.d=example.com"}, "", true},
+		{"dkim subdomain", []string{"mx.example.com; dkim=pass header.i=@mail.example.com"}, "", false},
+		{"spf", []string{"mx.example.com; spf=pass smtp.mailfrom=bounce@mail.sub.example.com"}, "", false},
+		{"spf aligned", []string{"mx.example.com; spf=pass smtp.mailfrom=bounce@example.com"}, "", true},
+		{"dmarc", []string{"mx.example.com 1; dmarc=pass header.from=example.com; dkim=none"}, "", true},
+		{"failed", []string{"mx.example.com; dkim=fail header.d=example.com; spf=softfail"}, "", false},
+		{"other domain", []string{"mx.example.com; dkim=pass header.d=evil.example"}, "", false},
+		{"forged below", []string{
+			"mx.example.com; dkim=none",
+			"mx.example.com; dkim=pass header.d=example.com",
+		}, "", false},
+		{"trusted server", []string{
+			"evil.example; dkim=pass header.d=example.com",
+			"MX.example.com; dkim=pass header.d=example.com",
+		}, "mx.example.com", true},
+		{"untrusted server", []string{"evil.example; dkim=pass header.d=example.com"}, "mx.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &inboundEmail{From: &mail.Address{Address: "Alice@Example.com"}, AuthResults: tt.results}
+			if got := m.authenticated(tt.servID); got != tt.want {
+				t.Errorf("authenticated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	sub := &inboundEmail{
+		From:        &mail.Address{Address: "alice@mail.example.com"},
+		AuthResults: []string{"mx.example.com; dkim=pass header.d=example.com"},
+	}
+	if !sub.authenticated("") {
+		t.Error("a signature of the parent domain should cover a subdomain sender")
+	}
+}
+
+func TestParseMessage_HTMLOnly(t *testing.T) {
+	raw := "From: a@example.com\r\nContent-Type: text/html\r\n\r\n<div>Line one<br>Line <b>two</b></div>"
+	m, err := parseMessage([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "Line one\nLine two" {
+		t.Errorf("Text = %q", m.Text)
+	}
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	in := "Sounds good.\n\nSee you then\n-- \nAlice\nOn Tue, 1 Oct 2026, Bot <bot@example.com> wrote:\n> earlier"
+	if got := stripQuotedReply(in); got != "Sounds good.\n\nSee you then" {
+		t.Errorf("stripQuotedReply = %q", got)
+	}
+
+	in = "Top reply\n> quoted line\nmore"
+	if got := stripQuotedReply(in); got != "Top reply\nmore" {
+		t.Errorf("stripQuotedReply = %q", got)
+	}
+}
+
+func TestOutgoingEmailBuild(t *testing.T) {
+	out := outgoingEmail{
+		To:         []string{"alice@example.com"},
+		Subject:    "Re: Grüße",
+		MessageID:  "<r1@example.com>",
+		InReplyTo:  "<a1@example.com>",
+		References: []string{"<root@example.com>", "<a1@example.com>"},
+		Body:       "Danke schön\nbis bald",
+	}
+	out.From.Address = "bot@example.com"
+	data, err := out.build(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := string(data)
+	for _, want := range []string{
+		"In-Reply-To: <a1@example.com>\r\n",
+		"References: <root@example.com> <a1@example.com>\r\n",
+		"Auto-Submitted: auto-replied\r\n",
+		"Date: Thu, 01 Oct 2026 12:00:00 +0000\r\n",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("message lacks %q:\n%s", want, raw)
+		}
+	}
+
+	m, err := parseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Re: Grüße" || m.Text != "Danke schön\nbis bald" {
+		t.Errorf("round trip = %q / %q", m.Subject, m.Text)
+	}
+	// Our own replies must not be answered by other bots.
+	if !m.AutoGenerated {
+		t.Error("outgoing mail should be marked as auto-submitted")
+	}
+}
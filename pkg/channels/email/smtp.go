@@ -0,0 +1,101 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+const smtpTimeout = 60 * time.Second
+
+// smtpSettings are the connection settings for sending mail.
+type smtpSettings struct {
+	addr      string
+	host      string
+	security  string
+	username  string
+	password  string
+	tlsConfig *tls.Config
+}
+
+// sendSMTP delivers msg from from to the recipients in to. Credentials are
+// only sent over TLS, or in plain text to a server on localhost.
+func sendSMTP(ctx context.Context, s smtpSettings, from string, to []string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if s.security == securityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial %s: %w", s.addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.Hello(helloName(from)); err != nil {
+		return fmt.Errorf("smtp hello: %w", err)
+	}
+	if s.security == securityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(s.tlsConfig); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if s.username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+				return fmt.Errorf("smtp auth: %w", err)
+			}
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return client.Quit()
+}
+
+// helloName is the name sent in EHLO: the domain of the sender address.
+func helloName(from string) string {
+	for i := len(from) - 1; i >= 0; i-- {
+		if from[i] == '@' && i < len(from)-1 {
+			return from[i+1:]
+		}
+	}
+	return "localhost"
+}
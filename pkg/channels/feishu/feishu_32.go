@@ -40,6 +40,11 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return errUnsupported
 }
 
+// SendReply is a stub method to satisfy ThreadCapable
+func (c *FeishuChannel) SendReply(ctx context.Context, msg bus.OutboundMessage) error {
+	return errUnsupported
+}
+
 // EditMessage is a stub method to satisfy MessageEditor
 func (c *FeishuChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
 	return errUnsupported
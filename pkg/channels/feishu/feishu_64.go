@@ -128,6 +128,40 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return c.sendCard(ctx, msg.ChatID, cardContent)
 }
 
+// SendReply implements channels.ThreadCapable. It answers ReplyToMessageID,
+// inside its topic thread when ThreadID is set. Without a message to answer
+// it falls back to Send, since Feishu threads are addressed by message.
+func (c *FeishuChannel) SendReply(ctx context.Context, msg bus.OutboundMessage) error {
+	if msg.ReplyToMessageID == "" {
+		return c.Send(ctx, msg)
+	}
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	cardContent, err := buildMarkdownCard(msg.Content)
+	if err != nil {
+		return fmt.Errorf("feishu reply: card build failed: %w", err)
+	}
+	req := larkim.NewReplyMessageReqBuilder().
+		MessageId(msg.ReplyToMessageID).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeInteractive).
+			Content(cardContent).
+			ReplyInThread(msg.ThreadID != "").
+			Build()).
+		Build()
+
+	resp, err := c.client.Im.V1.Message.Reply(ctx, req)
+	if err != nil {
+		return fmt.Errorf("feishu reply: %w", channels.ErrTemporary)
+	}
+	if !resp.Success() {
+		return fmt.Errorf("feishu api error (code=%d msg=%s): %w", resp.Code, resp.Msg, channels.ErrTemporary)
+	}
+	return nil
+}
+
 // EditMessage implements channels.MessageEditor.
 // Uses Message.Patch to update an interactive card message.
 func (c *FeishuChannel) EditMessage(ctx context.Context, chatID, messageID, content string) error {
@@ -403,6 +437,16 @@ func (c *FeishuChannel) handleMessageReceive(ctx context.Context, event *larkim.
 		content = cleaned
 	}
 
+	if threadID := stringValue(message.ThreadId); threadID != "" {
+		metadata[bus.MetadataThreadID] = threadID
+	}
+	if parentID := stringValue(message.ParentId); parentID != "" {
+		metadata[bus.MetadataReplyToMessageID] = parentID
+		if text := c.fetchMessageText(ctx, parentID); text != "" {
+			metadata[bus.MetadataReplyToText] = text
+		}
+	}
+
 	logger.InfoCF("feishu", "Feishu message received", map[string]any{
 		"sender_id":  senderID,
 		"chat_id":    chatID,
@@ -473,6 +517,23 @@ func (c *FeishuChannel) isBotMentioned(message *larkim.EventMessage) bool {
 	return false
 }
 
+// fetchMessageText returns the text of a message, used to quote the parent
+// of a reply. Failures only lose the quote and are not reported.
+func (c *FeishuChannel) fetchMessageText(ctx context.Context, messageID string) string {
+	resp, err := c.client.Im.V1.Message.Get(ctx, larkim.NewGetMessageReqBuilder().MessageId(messageID).Build())
+	if err != nil || !resp.Success() || resp.Data == nil || len(resp.Data.Items) == 0 {
+		logger.DebugCF("feishu", "Failed to fetch parent message", map[string]any{
+			"message_id": messageID,
+		})
+		return ""
+	}
+	item := resp.Data.Items[0]
+	if item.Body == nil {
+		return ""
+	}
+	return extractContent(stringValue(item.MsgType), stringValue(item.Body.Content))
+}
+
 // extractContent extracts text content from different message types.
 func extractContent(messageType, rawContent string) string {
 	if rawContent == "" {
@@ -1,6 +1,10 @@
 package channels
 
-import "context"
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
 
 // TypingCapable — channels that can show a typing/thinking indicator.
 // StartTyping begins the indicator and returns a stop function.
@@ -27,6 +31,8 @@ type ReactionCapable interface {
 // The channel MUST also implement MessageEditor for the placeholder to be useful.
 // SendPlaceholder returns the platform message ID of the placeholder so that
 // Manager.preSend can later edit it via MessageEditor.EditMessage.
+// Channels that also implement ThreadCapable get no placeholder for messages
+// whose reply is quoted or threaded, since the placeholder is a plain message.
 type PlaceholderCapable interface {
 	SendPlaceholder(ctx context.Context, chatID string) (messageID string, err error)
 }
@@ -39,3 +45,25 @@ type PlaceholderRecorder interface {
 	RecordTypingStop(channel, chatID string, stop func())
 	RecordReactionUndo(channel, chatID string, undo func())
 }
+
+// StreamCapable — channels that consume streamed replies directly rather than
+// through placeholder edits. StreamPartial receives the full text generated so
+// far; the final reply still arrives through Send.
+type StreamCapable interface {
+	StreamPartial(ctx context.Context, chatID, content string) error
+}
+
+// ThreadCapable — channels that can post a reply in a thread or quote the
+// message it answers. Manager hands outbound messages carrying
+// ReplyToMessageID or ThreadID to SendReply instead of Send.
+type ThreadCapable interface {
+	SendReply(ctx context.Context, msg bus.OutboundMessage) error
+}
+
+// TurnCompleter — channels that answer one request per agent turn, such as
+// the OpenAI-compatible API. Manager hands them the final reply of a turn
+// (OutboundMessage.Final) through CompleteTurn instead of Send; content is
+// empty when the message tool already sent the answer through Send.
+type TurnCompleter interface {
+	CompleteTurn(ctx context.Context, chatID, content string) error
+}
@@ -0,0 +1,477 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	dialTimeout         = 30 * time.Second
+	registrationTimeout = 60 * time.Second
+	// pingInterval is how often the connection is checked while quiet;
+	// readTimeout must be longer so the server has time to answer.
+	pingInterval = 2 * time.Minute
+	readTimeout  = 5 * time.Minute
+	writeTimeout = 30 * time.Second
+
+	// maxLineLength is the protocol limit for a line including CRLF.
+	maxLineLength = 512
+	// maxSASLChunk is the largest AUTHENTICATE payload per line.
+	maxSASLChunk = 400
+
+	defaultFloodBurst    = 5
+	defaultFloodInterval = 2 * time.Second
+)
+
+const (
+	saslPlain    = "PLAIN"
+	saslExternal = "EXTERNAL"
+)
+
+// errNotConnected is returned when sending to a network that is between
+// connections.
+var errNotConnected = errors.New("irc: not connected")
+
+// conn is a registered connection to a server.
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+
+	writeMu sync.Mutex
+}
+
+func (c *conn) readMessage() (*message, error) {
+	for {
+		c.netConn.SetReadDeadline(time.Now().Add(readTimeout))
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseMessage(line)
+		if errors.Is(err, errEmptyMessage) {
+			continue
+		}
+		return m, err
+	}
+}
+
+func (c *conn) write(line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.netConn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func (c *conn) send(command string, params ...string) error {
+	return c.write(formatMessage(command, params...))
+}
+
+func (c *conn) close() {
+	c.netConn.Close()
+}
+
+// network is the connection to one configured IRC network.
+type network struct {
+	name      string
+	config    config.IRCNetworkConfig
+	addr      string
+	tlsConfig *tls.Config
+	limiter   *rate.Limiter
+
+	mu   sync.Mutex
+	conn *conn
+	nick string
+	// selfPrefix is our "nick!user@host" as the server relays it, once
+	// known from our own JOIN. It determines how long a message can be.
+	selfPrefix string
+	// accounts maps folded nicks to their services accounts, as learned
+	// from extended-join and account-notify.
+	accounts map[string]string
+}
+
+func newNetwork(cfg config.IRCNetworkConfig) (*network, error) {
+	if cfg.Name == "" || strings.ContainsAny(cfg.Name, "/ ") {
+		return nil, fmt.Errorf("irc network name %q must be set and contain no '/' or spaces", cfg.Name)
+	}
+	if cfg.Server == "" || cfg.Nick == "" {
+		return nil, fmt.Errorf("irc network %s: server and nick are required", cfg.Name)
+	}
+	cfg.SASLMechanism = strings.ToUpper(cfg.SASLMechanism)
+	switch cfg.SASLMechanism {
+	case "":
+	case saslPlain:
+		if cfg.SASLPassword == "" {
+			return nil, fmt.Errorf("irc network %s: sasl_password is required for SASL PLAIN", cfg.Name)
+		}
+	case saslExternal:
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("irc network %s: tls_cert_file and tls_key_file are required for SASL EXTERNAL",
+				cfg.Name)
+		}
+	default:
+		return nil, fmt.Errorf("irc network %s: unsupported SASL mechanism %q (want PLAIN or EXTERNAL)",
+			cfg.Name, cfg.SASLMechanism)
+	}
+	if cfg.Username == "" {
+		cfg.Username = cfg.Nick
+	}
+	if cfg.RealName == "" {
+		cfg.RealName = "PicoClaw"
+	}
+
+	addr := cfg.Server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := "6667"
+		if cfg.TLS {
+			port = "6697"
+		}
+		addr = net.JoinHostPort(addr, port)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	n := &network{
+		name:   cfg.Name,
+		config: cfg,
+		addr:   addr,
+		nick:   cfg.Nick,
+	}
+	if cfg.TLS {
+		n.tlsConfig = &tls.Config{ServerName: host}
+		if cfg.TLSCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("irc network %s: load client certificate: %w", cfg.Name, err)
+			}
+			n.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	} else if cfg.SASLMechanism == saslExternal {
+		return nil, fmt.Errorf("irc network %s: SASL EXTERNAL requires tls", cfg.Name)
+	}
+
+	burst := cfg.FloodBurst
+	if burst <= 0 {
+		burst = defaultFloodBurst
+	}
+	interval := defaultFloodInterval
+	if cfg.FloodIntervalMs > 0 {
+		interval = time.Duration(cfg.FloodIntervalMs) * time.Millisecond
+	}
+	n.limiter = rate.NewLimiter(rate.Every(interval), burst)
+	return n, nil
+}
+
+func (n *network) currentNick() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.nick
+}
+
+func (n *network) setConn(c *conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conn = c
+	n.selfPrefix = ""
+	n.accounts = make(map[string]string)
+}
+
+// setAccount records the services account of nick; "*" or "" means the
+// nick is not logged in.
+func (n *network) setAccount(nick, account string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if account == "*" || account == "" {
+		delete(n.accounts, foldNick(nick))
+		return
+	}
+	n.accounts[foldNick(nick)] = account
+}
+
+// renameAccount moves the account of a nick that changed to newNick.
+func (n *network) renameAccount(nick, newNick string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if account, ok := n.accounts[foldNick(nick)]; ok {
+		delete(n.accounts, foldNick(nick))
+		n.accounts[foldNick(newNick)] = account
+	}
+}
+
+// accountOf returns the services account of a message's sender: its
+// account tag, else what extended-join and account-notify reported for
+// the nick. It returns "" for senders that are not logged in.
+func (n *network) accountOf(m *message) string {
+	if account := m.Tags["account"]; account != "" {
+		return account
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.accounts[foldNick(m.nick())]
+}
+
+// dial connects to the server and completes registration, including
+// capability negotiation and SASL.
+func (n *network) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var (
+		nc  net.Conn
+		err error
+	)
+	if n.tlsConfig != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: n.tlsConfig}).DialContext(ctx, "tcp", n.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", n.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("irc dial %s: %w", n.addr, err)
+	}
+
+	c := &conn{netConn: nc, reader: bufio.NewReaderSize(nc, 8192)}
+	// Abort registration when ctx ends or the server is too slow.
+	stop := context.AfterFunc(ctx, func() { nc.SetDeadline(time.Now()) })
+	defer stop()
+	nc.SetDeadline(time.Now().Add(registrationTimeout))
+
+	if err := n.register(c); err != nil {
+		c.close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("irc %s: %w", n.name, err)
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// register runs the registration handshake until the welcome numeric.
+func (n *network) register(c *conn) error {
+	cfg := n.config
+	nick := cfg.Nick
+
+	if cfg.Password != "" {
+		if err := c.send("PASS", cfg.Password); err != nil {
+			return err
+		}
+	}
+	if err := c.send("CAP", "LS", "302"); err != nil {
+		return err
+	}
+	if err := c.send("NICK", nick); err != nil {
+		return err
+	}
+	if err := c.send("USER", cfg.Username, "0", "*", cfg.RealName); err != nil {
+		return err
+	}
+
+	var offered []string
+	capDone := false
+	endCap := func() error {
+		if capDone {
+			return nil
+		}
+		capDone = true
+		return c.send("CAP", "END")
+	}
+
+	for {
+		m, err := c.readMessage()
+		if err != nil {
+			return fmt.Errorf("registration: %w", err)
+		}
+		switch m.Command {
+		case "PING":
+			err = c.send("PONG", m.Params...)
+		case "CAP":
+			switch strings.ToUpper(m.param(1)) {
+			case "LS":
+				// "CAP * LS * :caps" continues on the next line.
+				more := len(m.Params) > 3 && m.param(2) == "*"
+				offered = append(offered, strings.Fields(m.Params[len(m.Params)-1])...)
+				if !more {
+					err = n.requestCaps(c, offered, endCap)
+				}
+			case "ACK":
+				if hasCap(strings.Fields(m.param(2)), "sasl") && cfg.SASLMechanism != "" {
+					err = c.send("AUTHENTICATE", cfg.SASLMechanism)
+				} else {
+					err = endCap()
+				}
+			case "NAK":
+				if cfg.SASLMechanism != "" {
+					return errors.New("server refused the sasl capability")
+				}
+				err = endCap()
+			}
+		case "AUTHENTICATE":
+			if m.param(0) == "+" {
+				err = n.authenticate(c)
+			}
+		case "903": // RPL_SASLSUCCESS
+			err = endCap()
+		case "902", "904", "905", "906", "908":
+			return fmt.Errorf("sasl authentication failed: %s", m.Params[len(m.Params)-1])
+		case "432", "433", "436", "437": // nickname invalid or in use
+			nick += "_"
+			err = c.send("NICK", nick)
+		case "421": // a server without CAP support
+			if strings.EqualFold(m.param(1), "CAP") {
+				if cfg.SASLMechanism != "" {
+					return errors.New("server does not support SASL")
+				}
+				capDone = true
+			}
+		case "464":
+			return errors.New("server password rejected")
+		case "465":
+			return fmt.Errorf("banned: %s", m.Params[len(m.Params)-1])
+		case "ERROR":
+			return fmt.Errorf("server closed the connection: %s", m.param(0))
+		case "001": // RPL_WELCOME
+			n.mu.Lock()
+			n.nick = m.param(0)
+			n.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// requestCaps asks for the capabilities we use out of those offered.
+func (n *network) requestCaps(c *conn, offered []string, endCap func() error) error {
+	var want []string
+	if n.config.SASLMechanism != "" {
+		if !hasCap(offered, "sasl") {
+			return errors.New("server does not offer SASL")
+		}
+		want = append(want, "sasl")
+	}
+	for _, capName := range []string{"account-tag", "account-notify", "extended-join", "message-tags"} {
+		if hasCap(offered, capName) {
+			want = append(want, capName)
+		}
+	}
+	if len(want) == 0 {
+		return endCap()
+	}
+	return c.send("CAP", "REQ", strings.Join(want, " "))
+}
+
+// hasCap reports whether name is in caps, which may carry "=value" suffixes.
+func hasCap(caps []string, name string) bool {
+	for _, c := range caps {
+		if c, _, _ = strings.Cut(c, "="); strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate sends the SASL response, split into 400-byte chunks.
+func (n *network) authenticate(c *conn) error {
+	if n.config.SASLMechanism == saslExternal {
+		return c.send("AUTHENTICATE", "+")
+	}
+	user := n.config.SASLUsername
+	if user == "" {
+		user = n.config.Nick
+	}
+	payload := base64.StdEncoding.EncodeToString([]byte(user + "\x00" + user + "\x00" + n.config.SASLPassword))
+	for len(payload) >= maxSASLChunk {
+		if err := c.send("AUTHENTICATE", payload[:maxSASLChunk]); err != nil {
+			return err
+		}
+		payload = payload[maxSASLChunk:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	return c.send("AUTHENTICATE", payload)
+}
+
+// afterRegistration identifies with NickServ and joins the channels.
+func (n *network) afterRegistration(c *conn) error {
+	if n.config.NickServPassword != "" {
+		if err := c.send("PRIVMSG", "NickServ", "IDENTIFY "+n.config.Nick+" "+n.config.NickServPassword); err != nil {
+			return err
+		}
+	}
+	for _, ch := range n.config.Channels {
+		name, key, _ := strings.Cut(strings.TrimSpace(ch), " ")
+		if name == "" {
+			continue
+		}
+		params := []string{name}
+		if key = strings.TrimSpace(key); key != "" {
+			params = append(params, key)
+		}
+		if err := c.send("JOIN", params...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// privmsg sends one message line, waiting for the flood limiter first.
+func (n *network) privmsg(ctx context.Context, target, text string) error {
+	if err := n.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	c := n.conn
+	n.mu.Unlock()
+	if c == nil {
+		return errNotConnected
+	}
+	// A line break would end the command and start another.
+	text = strings.NewReplacer("\r", " ", "\n", " ", "\x00", "").Replace(text)
+	return c.send("PRIVMSG", target, text)
+}
+
+// maxTextBytes returns how many bytes of text fit in a PRIVMSG to target
+// once the server adds our prefix when relaying it.
+func (n *network) maxTextBytes(target string) int {
+	n.mu.Lock()
+	prefix := n.selfPrefix
+	if prefix == "" {
+		// Assume the longest user and host names servers commonly allow.
+		prefix = n.nick + "!" + strings.Repeat("u", 10) + "@" + strings.Repeat("h", 63)
+	}
+	n.mu.Unlock()
+	overhead := len(":" + prefix + " PRIVMSG " + target + " :\r\n")
+	return maxLineLength - overhead
+}
+
+// keepAlive pings the server while the connection is up, so a dead
+// connection is noticed by the read timeout.
+func (n *network) keepAlive(ctx context.Context, c *conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.send("PING", "picoclaw"); err != nil {
+				logger.DebugCF("irc", "Keepalive failed", map[string]any{"network": n.name, "error": err.Error()})
+				return
+			}
+		}
+	}
+}
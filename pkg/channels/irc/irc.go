@@ -0,0 +1,446 @@
+// Package irc implements a channel that connects to one or more IRC
+// networks. Chat IDs are "<network>/<channel>" for channels and
+// "<network>/<nick>" for private messages.
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+	// stableConnection is how long a connection must last for the
+	// reconnect delay to start over.
+	stableConnection = time.Minute
+)
+
+type IRCChannel struct {
+	*channels.BaseChannel
+	config   config.IRCConfig
+	networks map[string]*network
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewIRCChannel(cfg config.IRCConfig, messageBus *bus.MessageBus) (*IRCChannel, error) {
+	if len(cfg.Networks) == 0 {
+		return nil, fmt.Errorf("irc: at least one network is required")
+	}
+	networks := make(map[string]*network, len(cfg.Networks))
+	for _, nc := range cfg.Networks {
+		n, err := newNetwork(nc)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := networks[n.name]; dup {
+			return nil, fmt.Errorf("irc: duplicate network name %q", n.name)
+		}
+		networks[n.name] = n
+	}
+
+	base := channels.NewBaseChannel("irc", cfg, messageBus, cfg.AllowFrom,
+		channels.WithGroupTrigger(cfg.GroupTrigger),
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &IRCChannel{
+		BaseChannel: base,
+		config:      cfg,
+		networks:    networks,
+	}, nil
+}
+
+// Start connects to every network. It fails only if none can be reached;
+// the others keep retrying in the background.
+func (c *IRCChannel) Start(ctx context.Context) error {
+	logger.InfoC("irc", "Starting IRC channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	type result struct {
+		n    *network
+		conn *conn
+		err  error
+	}
+	results := make(chan result, len(c.networks))
+	for _, n := range c.networks {
+		go func() {
+			conn, err := n.dial(c.ctx)
+			results <- result{n, conn, err}
+		}()
+	}
+
+	var errs []error
+	for range c.networks {
+		r := <-results
+		if r.err != nil {
+			logger.ErrorCF("irc", "Connection failed", map[string]any{
+				"network": r.n.name,
+				"error":   r.err.Error(),
+			})
+			errs = append(errs, r.err)
+		} else {
+			// Usable for sending as soon as Start returns.
+			r.n.setConn(r.conn)
+		}
+		c.wg.Add(1)
+		go c.run(r.n, r.conn)
+	}
+	if len(errs) == len(c.networks) {
+		c.cancel()
+		c.wg.Wait()
+		return errors.Join(errs...)
+	}
+
+	c.SetRunning(true)
+	logger.InfoCF("irc", "IRC channel started", map[string]any{
+		"networks": len(c.networks),
+		"failed":   len(errs),
+	})
+	return nil
+}
+
+func (c *IRCChannel) Stop(ctx context.Context) error {
+	logger.InfoC("irc", "Stopping IRC channel")
+
+	for _, n := range c.networks {
+		n.mu.Lock()
+		if n.conn != nil {
+			n.conn.send("QUIT", "Shutting down")
+		}
+		n.mu.Unlock()
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("irc", "IRC channel stopped")
+	return nil
+}
+
+// run serves a network until the channel stops, reconnecting with
+// exponential backoff. conn is the first connection, or nil if it failed.
+func (c *IRCChannel) run(n *network, conn *conn) {
+	defer c.wg.Done()
+	delay := minReconnectDelay
+	for {
+		if conn != nil {
+			started := time.Now()
+			err := c.serve(n, conn)
+			if c.ctx.Err() != nil {
+				return
+			}
+			logger.WarnCF("irc", "Connection lost, reconnecting", map[string]any{
+				"network": n.name,
+				"error":   err.Error(),
+			})
+			if time.Since(started) > stableConnection {
+				delay = minReconnectDelay
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+
+		var err error
+		if conn, err = n.dial(c.ctx); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			logger.ErrorCF("irc", "Reconnect failed", map[string]any{"network": n.name, "error": err.Error()})
+			conn = nil
+		}
+	}
+}
+
+// serve handles a registered connection until it fails or the channel
+// stops.
+func (c *IRCChannel) serve(n *network, conn *conn) error {
+	defer conn.close()
+	n.setConn(conn)
+	defer n.setConn(nil)
+
+	logger.InfoCF("irc", "Connected", map[string]any{"network": n.name, "nick": n.currentNick()})
+	if err := n.afterRegistration(conn); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go n.keepAlive(c.ctx, conn, done)
+	stop := context.AfterFunc(c.ctx, conn.close)
+	defer stop()
+
+	for {
+		m, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+		switch m.Command {
+		case "PING":
+			err = conn.send("PONG", m.Params...)
+		case "PRIVMSG":
+			c.handlePrivmsg(n, m)
+		case "NICK":
+			n.mu.Lock()
+			if foldNick(m.nick()) == foldNick(n.nick) {
+				n.nick = m.param(0)
+				n.selfPrefix = ""
+			}
+			n.mu.Unlock()
+			n.renameAccount(m.nick(), m.param(0))
+		case "JOIN":
+			if foldNick(m.nick()) == foldNick(n.currentNick()) {
+				n.mu.Lock()
+				n.selfPrefix = m.Prefix
+				n.mu.Unlock()
+				logger.InfoCF("irc", "Joined channel", map[string]any{"network": n.name, "channel": m.param(0)})
+			} else if len(m.Params) >= 3 { // extended-join: channel, account, real name
+				n.setAccount(m.nick(), m.param(1))
+			}
+		case "ACCOUNT": // account-notify
+			n.setAccount(m.nick(), m.param(0))
+		case "PART", "QUIT":
+			// The nick may be taken by someone else once we stop seeing it.
+			n.setAccount(m.nick(), "")
+		case "KICK":
+			n.setAccount(m.param(1), "")
+			if foldNick(m.param(1)) == foldNick(n.currentNick()) {
+				logger.WarnCF("irc", "Kicked from channel", map[string]any{
+					"network": n.name,
+					"channel": m.param(0),
+					"by":      m.nick(),
+					"reason":  m.param(2),
+				})
+			}
+		case "900": // RPL_LOGGEDIN
+			logger.InfoCF("irc", "Logged in", map[string]any{"network": n.name, "account": m.param(2)})
+		case "ERROR":
+			return fmt.Errorf("server closed the connection: %s", m.param(0))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *IRCChannel) handlePrivmsg(n *network, m *message) {
+	from := m.nick()
+	target := m.param(0)
+	text := m.param(1)
+	if from == "" || text == "" || foldNick(from) == foldNick(n.currentNick()) {
+		return
+	}
+
+	// CTCP: only ACTION (/me) is a message; the rest are queries.
+	if strings.HasPrefix(text, "\x01") {
+		action, ok := strings.CutPrefix(strings.Trim(text, "\x01"), "ACTION ")
+		if !ok {
+			return
+		}
+		text = "* " + from + " " + action
+	}
+	text = strings.TrimSpace(stripFormatting(text))
+	if text == "" {
+		return
+	}
+
+	// Anyone can take a nick, so senders are identified by their services
+	// account. Without an allow list, unauthenticated senders go by nick.
+	platformID := n.name + "/" + from
+	account := n.accountOf(m)
+	if account == "" && len(c.config.AllowFrom) > 0 {
+		logger.DebugCF("irc", "Message from a sender without an account rejected",
+			map[string]any{"network": n.name, "nick": from})
+		return
+	}
+	senderID, username := platformID, from
+	if account != "" {
+		senderID, username = n.name+"/"+account, account
+	}
+	sender := bus.SenderInfo{
+		Platform:    "irc",
+		PlatformID:  senderID,
+		CanonicalID: identity.BuildCanonicalID("irc", senderID),
+		Username:    username,
+		DisplayName: from,
+	}
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("irc", "Message rejected by allowlist", map[string]any{
+			"network": n.name,
+			"nick":    from,
+			"account": account,
+		})
+		return
+	}
+
+	metadata := map[string]string{
+		"platform": "irc",
+		"network":  n.name,
+		"nick":     from,
+	}
+	if account != "" {
+		metadata["account"] = account
+	}
+
+	var peer bus.Peer
+	var chatID string
+	content := text
+	if isChannelName(target) {
+		chatID = n.name + "/" + target
+		mentioned, stripped := mentionOf(n.currentNick(), text)
+		respond, cleaned := c.ShouldRespondInGroup(mentioned, stripped)
+		if !respond {
+			return
+		}
+		content = cleaned
+		peer = bus.Peer{Kind: "group", ID: chatID}
+		metadata["channel"] = target
+	} else {
+		chatID = platformID
+		peer = bus.Peer{Kind: "direct", ID: platformID}
+	}
+
+	logger.DebugCF("irc", "Received message", map[string]any{
+		"network": n.name,
+		"from":    from,
+		"chat_id": chatID,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(c.ctx, peer, m.Tags["msgid"], senderID, chatID, content, nil, metadata, sender)
+}
+
+// mentionOf reports whether text addresses nick. A leading "nick:" or
+// "nick," is removed from the returned text.
+func mentionOf(nick, text string) (bool, string) {
+	folded := foldNick(text)
+	fnick := foldNick(nick)
+	if strings.HasPrefix(folded, fnick) && len(text) > len(nick) {
+		if rest := text[len(nick):]; rest[0] == ':' || rest[0] == ',' {
+			return true, strings.TrimSpace(rest[1:])
+		}
+	}
+	for i := 0; ; {
+		j := strings.Index(folded[i:], fnick)
+		if j < 0 {
+			return false, text
+		}
+		start, end := i+j, i+j+len(fnick)
+		if (start == 0 || !isNickChar(folded[start-1])) && (end == len(folded) || !isNickChar(folded[end])) {
+			return true, text
+		}
+		i = end
+	}
+}
+
+func isNickChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || strings.IndexByte("-_[]{}\\|^`", c) >= 0
+}
+
+// Send delivers msg.Content line by line, splitting lines longer than the
+// protocol allows.
+func (c *IRCChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+	n, target, err := c.route(msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range splitLines(msg.Content, n.maxTextBytes(target)) {
+		if err := n.privmsg(ctx, target, line); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.ErrorCF("irc", "Failed to send message", map[string]any{
+				"chat_id": msg.ChatID,
+				"error":   err.Error(),
+			})
+			return fmt.Errorf("irc send: %w", channels.ErrTemporary)
+		}
+	}
+	return nil
+}
+
+// route resolves a chat ID to its network and target.
+func (c *IRCChannel) route(chatID string) (*network, string, error) {
+	name, target, ok := strings.Cut(chatID, "/")
+	n := c.networks[name]
+	if !ok || n == nil || target == "" {
+		return nil, "", fmt.Errorf("irc: invalid chat ID %q: %w", chatID, channels.ErrSendFailed)
+	}
+	return n, target, nil
+}
+
+// splitLines turns content into IRC lines of at most maxBytes bytes each.
+// Blank lines are dropped since IRC cannot send them.
+func splitLines(content string, maxBytes int) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, chunk := range splitLine(line, maxBytes) {
+			// SplitMessage may add newlines when it closes a code fence.
+			for _, part := range strings.Split(chunk, "\n") {
+				if strings.TrimSpace(part) != "" {
+					lines = append(lines, part)
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// splitLine splits line with channels.SplitMessage. Its limit counts runes,
+// so it is lowered until every chunk fits in maxBytes.
+func splitLine(line string, maxBytes int) []string {
+	limit := maxBytes
+	for {
+		chunks := channels.SplitMessage(line, limit)
+		fits := true
+		for _, chunk := range chunks {
+			if len(chunk) > maxBytes {
+				fits = false
+				break
+			}
+		}
+		if fits || limit <= 1 {
+			return chunks
+		}
+		limit = max(limit*3/4, 1)
+	}
+}
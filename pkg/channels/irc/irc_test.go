@@ -0,0 +1,543 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+type receivedLine struct {
+	text string
+	at   time.Time
+}
+
+// fakeServer is a minimal IRC server: it negotiates capabilities and SASL,
+// registers clients, echoes JOINs and records the other commands it receives.
+type fakeServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	// saslPassword is the PLAIN password it accepts; with requireCert the
+	// server instead expects EXTERNAL with a client certificate.
+	saslUser     string
+	saslPassword string
+	requireCert  bool
+	takenNicks   map[string]bool
+
+	lines chan receivedLine
+
+	mu      sync.Mutex
+	clients []*fakeClient
+}
+
+type fakeClient struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	nick     string
+	user     bool
+	capEnd   bool
+	welcomed bool
+}
+
+func (c *fakeClient) send(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+func newFakeServer(t *testing.T, tlsConfig *tls.Config) *fakeServer {
+	t.Helper()
+	var (
+		ln  net.Listener
+		err error
+	)
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		t:          t,
+		ln:         ln,
+		takenNicks: make(map[string]bool),
+		lines:      make(chan receivedLine, 1000),
+	}
+	go s.accept()
+	t.Cleanup(func() {
+		ln.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, c := range s.clients {
+			c.conn.Close()
+		}
+	})
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) accept() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeClient{conn: nc}
+		s.mu.Lock()
+		s.clients = append(s.clients, c)
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *fakeServer) serve(c *fakeClient) {
+	defer c.conn.Close()
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		m, err := parseMessage(line)
+		if err != nil {
+			continue
+		}
+		switch m.Command {
+		case "CAP":
+			switch m.param(0) {
+			case "LS":
+				c.send(":srv CAP * LS * :multi-prefix away-notify")
+				c.send(":srv CAP * LS :sasl=PLAIN,EXTERNAL account-tag extended-join message-tags")
+			case "REQ":
+				c.send(":srv CAP * ACK :" + m.param(1))
+			case "END":
+				c.capEnd = true
+				s.welcome(c)
+			}
+		case "AUTHENTICATE":
+			s.authenticate(c, m.param(0))
+		case "NICK":
+			if s.takenNicks[m.param(0)] {
+				c.send(":srv 433 * " + m.param(0) + " :Nickname is already in use")
+				continue
+			}
+			c.nick = m.param(0)
+			s.welcome(c)
+		case "USER":
+			c.user = true
+			s.welcome(c)
+		case "JOIN":
+			c.send(":" + c.nick + "!~" + c.nick + "@picoclaw.example JOIN " + m.param(0))
+			s.lines <- receivedLine{text: line, at: time.Now()}
+		case "PING":
+			c.send(":srv PONG srv :" + m.param(0))
+		default:
+			s.lines <- receivedLine{text: line, at: time.Now()}
+		}
+	}
+}
+
+func (s *fakeServer) welcome(c *fakeClient) {
+	if c.welcomed || !c.capEnd || !c.user || c.nick == "" {
+		return
+	}
+	c.welcomed = true
+	c.send(":srv 001 " + c.nick + " :Welcome to the test network")
+}
+
+func (s *fakeServer) authenticate(c *fakeClient, arg string) {
+	switch arg {
+	case saslPlain, saslExternal:
+		c.send("AUTHENTICATE +")
+		return
+	}
+	ok := false
+	if s.requireCert {
+		tc, isTLS := c.conn.(*tls.Conn)
+		ok = arg == "+" && isTLS && len(tc.ConnectionState().PeerCertificates) > 0
+	} else {
+		payload, _ := base64.StdEncoding.DecodeString(arg)
+		ok = string(payload) == s.saslUser+"\x00"+s.saslUser+"\x00"+s.saslPassword
+	}
+	if ok {
+		c.send(":srv 900 * * " + s.saslUser + " :You are now logged in")
+		c.send(":srv 903 * :SASL authentication successful")
+	} else {
+		c.send(":srv 904 * :SASL authentication failed")
+	}
+}
+
+// say sends a line to every connected client.
+func (s *fakeServer) say(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		c.send(line)
+	}
+}
+
+// expect returns the next received line starting with prefix, skipping
+// others.
+func (s *fakeServer) expect(prefix string) receivedLine {
+	s.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l := <-s.lines:
+			if strings.HasPrefix(l.text, prefix) {
+				return l
+			}
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func startIRCChannel(t *testing.T, cfg config.IRCConfig) (*IRCChannel, *bus.MessageBus) {
+	t.Helper()
+	msgBus := bus.NewMessageBus()
+	ch, err := NewIRCChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewIRCChannel: %v", err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch.Stop(ctx)
+	})
+	return ch, msgBus
+}
+
+func nextInbound(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestIRCChannel_SASLPlainAndMessages(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	srv.saslUser, srv.saslPassword = "picoacct", "s3cret"
+
+	ch, msgBus := startIRCChannel(t, config.IRCConfig{
+		Networks: []config.IRCNetworkConfig{{
+			Name:          "test",
+			Server:        srv.addr(),
+			Nick:          "picoclaw",
+			SASLMechanism: "plain",
+			SASLUsername:  "picoacct",
+			SASLPassword:  "s3cret",
+			Channels:      []string{"#ops", "#secret hunter2"},
+		}},
+		AllowFrom:    config.FlexibleStringSlice{"alice", "irc:test/carol"},
+		GroupTrigger: config.GroupTriggerConfig{Prefixes: []string{"!ai "}},
+	})
+	srv.expect("JOIN #ops")
+	srv.expect("JOIN #secret hunter2")
+
+	// Ignored: no trigger, a disallowed sender, the nick alice without her
+	// account, a CTCP query and a notice.
+	srv.say("@account=alice :alice!a@h PRIVMSG #ops :just chatting")
+	srv.say("@account=mallory :mallory!m@h PRIVMSG #ops :picoclaw: hi")
+	srv.say("@account=mallory :alice!m@h PRIVMSG #ops :picoclaw: hi")
+	srv.say(":alice!m@h PRIVMSG #ops :picoclaw: hi")
+	srv.say("@account=alice :alice!a@h PRIVMSG picoclaw :\x01VERSION\x01")
+	srv.say("@account=alice :alice!a@h NOTICE picoclaw :picoclaw: ping")
+	srv.say("@account=alice :alice!a@h PRIVMSG #ops :\x02PicoClaw\x02: what's up?")
+
+	msg := nextInbound(t, msgBus)
+	if msg.Channel != "irc" || msg.ChatID != "test/#ops" || msg.Content != "what's up?" {
+		t.Errorf("inbound = %+v", msg)
+	}
+	if msg.Peer.Kind != "group" || msg.SenderID != "irc:test/alice" || msg.Metadata["account"] != "alice" {
+		t.Errorf("peer = %+v, sender = %q, metadata = %v", msg.Peer, msg.SenderID, msg.Metadata)
+	}
+
+	// Without account-tag, the account comes from extended-join and is
+	// forgotten when the nick leaves.
+	srv.say(":carol!c@h JOIN #ops carol :Carol")
+	srv.say(":carol!c@h PRIVMSG #ops :!ai status")
+	if msg = nextInbound(t, msgBus); msg.Content != "status" || msg.SenderID != "irc:test/carol" {
+		t.Errorf("prefixed message = %q from %q", msg.Content, msg.SenderID)
+	}
+	srv.say(":carol!c@h QUIT :bye")
+	srv.say(":carol!x@h PRIVMSG #ops :!ai take over")
+
+	srv.say("@account=alice :alice!a@h PRIVMSG picoclaw :hello there")
+	msg = nextInbound(t, msgBus)
+	if msg.ChatID != "test/alice" || msg.Peer.Kind != "direct" || msg.Content != "hello there" {
+		t.Errorf("direct message = %+v", msg)
+	}
+
+	srv.say("@account=alice :alice!a@h PRIVMSG picoclaw :\x01ACTION waves\x01")
+	if msg = nextInbound(t, msgBus); msg.Content != "* alice waves" {
+		t.Errorf("action = %q", msg.Content)
+	}
+
+	srv.say("PING :keepalive-check")
+	ctx := context.Background()
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "test/#ops", Content: "line one\n\nline two"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	srv.expect("PRIVMSG #ops :line one")
+	srv.expect("PRIVMSG #ops :line two")
+
+	long := strings.Repeat("päckage ", 150)
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "test/alice", Content: long}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	relayPrefix := ":picoclaw!~picoclaw@picoclaw.example "
+	var got strings.Builder
+	for got.Len() < len(strings.ReplaceAll(long, " ", "")) {
+		l := srv.expect("PRIVMSG alice ")
+		if n := len(relayPrefix + l.text + "\r\n"); n > maxLineLength {
+			t.Fatalf("relayed line would be %d bytes", n)
+		}
+		got.WriteString(strings.ReplaceAll(strings.TrimPrefix(l.text, "PRIVMSG alice :"), " ", ""))
+	}
+
+	err := ch.Send(ctx, bus.OutboundMessage{ChatID: "elsewhere/#ops", Content: "hi"})
+	if !errors.Is(err, channels.ErrSendFailed) {
+		t.Errorf("Send to an unknown network = %v, want ErrSendFailed", err)
+	}
+}
+
+func TestIRCChannel_SASLFailure(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	srv.saslUser, srv.saslPassword = "picoclaw", "right"
+
+	ch, err := NewIRCChannel(config.IRCConfig{Networks: []config.IRCNetworkConfig{{
+		Name: "test", Server: srv.addr(), Nick: "picoclaw", SASLMechanism: "PLAIN", SASLPassword: "wrong",
+	}}}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ch.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "sasl authentication failed") {
+		t.Errorf("Start = %v, want a SASL failure", err)
+	}
+}
+
+func TestIRCChannel_NickInUseAndNickServ(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	srv.takenNicks["picoclaw"] = true
+
+	_, msgBus := startIRCChannel(t, config.IRCConfig{
+		Networks: []config.IRCNetworkConfig{{
+			Name:             "test",
+			Server:           srv.addr(),
+			Nick:             "picoclaw",
+			NickServPassword: "ns-pass",
+			Channels:         []string{"#ops"},
+		}},
+		GroupTrigger: config.GroupTriggerConfig{MentionOnly: true},
+	})
+	srv.expect("PRIVMSG NickServ :IDENTIFY picoclaw ns-pass")
+	srv.expect("JOIN #ops")
+
+	srv.say(":bob!b@h PRIVMSG #ops :picoclaw: are you there?")
+	srv.say(":bob!b@h PRIVMSG #ops :picoclaw_: yes you")
+	if msg := nextInbound(t, msgBus); msg.Content != "yes you" {
+		t.Errorf("inbound = %q, want the message addressed to the current nick", msg.Content)
+	}
+
+	// The server renames us; mentions follow the new nick.
+	srv.say(":picoclaw_!~p@h NICK :pico")
+	srv.say(":bob!b@h PRIVMSG #ops :pico, renamed")
+	if msg := nextInbound(t, msgBus); msg.Content != "renamed" {
+		t.Errorf("inbound = %q after the nick change", msg.Content)
+	}
+}
+
+func TestIRCChannel_FloodPacing(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	ch, _ := startIRCChannel(t, config.IRCConfig{Networks: []config.IRCNetworkConfig{{
+		Name: "test", Server: srv.addr(), Nick: "picoclaw", FloodBurst: 2, FloodIntervalMs: 100,
+	}}})
+
+	start := time.Now()
+	if err := ch.Send(context.Background(), bus.OutboundMessage{
+		ChatID:  "test/#ops",
+		Content: "line 1\nline 2\nline 3\nline 4\nline 5",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Duration
+	for _, want := range []string{"1", "2", "3", "4", "5"} {
+		times = append(times, srv.expect("PRIVMSG #ops :line "+want).at.Sub(start))
+	}
+	if times[1] > 50*time.Millisecond {
+		t.Errorf("burst lines were delayed: %v", times)
+	}
+	if times[4] < 250*time.Millisecond {
+		t.Errorf("lines after the burst were not paced: %v", times)
+	}
+}
+
+func TestIRCChannel_MultipleNetworks(t *testing.T) {
+	srvA := newFakeServer(t, nil)
+	srvB := newFakeServer(t, nil)
+	ch, msgBus := startIRCChannel(t, config.IRCConfig{Networks: []config.IRCNetworkConfig{
+		{Name: "a", Server: srvA.addr(), Nick: "pico", Channels: []string{"#ops"}},
+		{Name: "b", Server: srvB.addr(), Nick: "pico", Channels: []string{"#ops"}},
+	}})
+	srvA.expect("JOIN #ops")
+	srvB.expect("JOIN #ops")
+
+	srvB.say(":alice!a@h PRIVMSG #ops :pico: from b")
+	msg := nextInbound(t, msgBus)
+	if msg.ChatID != "b/#ops" || msg.Metadata["network"] != "b" {
+		t.Errorf("inbound = %+v", msg)
+	}
+
+	if err := ch.Send(context.Background(), bus.OutboundMessage{ChatID: "a/#ops", Content: "to a"}); err != nil {
+		t.Fatal(err)
+	}
+	srvA.expect("PRIVMSG #ops :to a")
+	select {
+	case l := <-srvB.lines:
+		t.Errorf("network b received %q", l.text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIRCChannel_StartsWithOneNetworkDown(t *testing.T) {
+	srv := newFakeServer(t, nil)
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+
+	ch, _ := startIRCChannel(t, config.IRCConfig{Networks: []config.IRCNetworkConfig{
+		{Name: "up", Server: srv.addr(), Nick: "pico"},
+		{Name: "down", Server: downAddr, Nick: "pico"},
+	}})
+	if !ch.IsRunning() {
+		t.Fatal("channel should run while one network is reachable")
+	}
+	err = ch.Send(context.Background(), bus.OutboundMessage{ChatID: "down/#ops", Content: "hi"})
+	if !errors.Is(err, channels.ErrTemporary) {
+		t.Errorf("Send to a disconnected network = %v, want ErrTemporary", err)
+	}
+}
+
+func TestIRCChannel_SASLExternal(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := writeCert(t, dir, "server", "127.0.0.1")
+	writeCert(t, dir, "client", "picoclaw")
+
+	srv := newFakeServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	srv.saslUser, srv.requireCert = "picoclaw", true
+
+	ch, err := NewIRCChannel(config.IRCConfig{Networks: []config.IRCNetworkConfig{{
+		Name:          "secure",
+		Server:        srv.addr(),
+		TLS:           true,
+		Nick:          "picoclaw",
+		SASLMechanism: "EXTERNAL",
+		TLSCertFile:   filepath.Join(dir, "client.crt"),
+		TLSKeyFile:    filepath.Join(dir, "client.key"),
+		Channels:      []string{"#ops"},
+	}}}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+	ch.networks["secure"].tlsConfig.RootCAs = roots
+
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer ch.Stop(context.Background())
+	srv.expect("JOIN #ops")
+
+	if _, err := NewIRCChannel(config.IRCConfig{Networks: []config.IRCNetworkConfig{{
+		Name: "x", Server: srv.addr(), Nick: "p", SASLMechanism: "EXTERNAL",
+		TLSCertFile: filepath.Join(dir, "client.crt"), TLSKeyFile: filepath.Join(dir, "client.key"),
+	}}}, bus.NewMessageBus()); err == nil {
+		t.Error("SASL EXTERNAL without TLS should be rejected")
+	}
+}
+
+// writeCert creates a self-signed certificate for name and writes it to
+// dir/<file>.crt and dir/<file>.key.
+func writeCert(t *testing.T, dir, file, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, file+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, file+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
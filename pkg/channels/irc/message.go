@@ -0,0 +1,167 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// message is one IRC protocol line (RFC 1459 with IRCv3 message tags).
+type message struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+var errEmptyMessage = errors.New("irc: empty message")
+
+// parseMessage parses a line without its trailing CRLF.
+func parseMessage(line string) (*message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	m := &message{}
+
+	if strings.HasPrefix(line, "@") {
+		tags, rest, _ := strings.Cut(line[1:], " ")
+		m.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			key, value, _ := strings.Cut(tag, "=")
+			if key != "" {
+				m.Tags[key] = unescapeTagValue(value)
+			}
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		prefix, rest, _ := strings.Cut(line[1:], " ")
+		m.Prefix = prefix
+		line = strings.TrimLeft(rest, " ")
+	}
+
+	for line != "" {
+		if strings.HasPrefix(line, ":") && m.Command != "" {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		word, rest, _ := strings.Cut(line, " ")
+		if m.Command == "" {
+			m.Command = strings.ToUpper(word)
+		} else {
+			m.Params = append(m.Params, word)
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	if m.Command == "" {
+		return nil, errEmptyMessage
+	}
+	return m, nil
+}
+
+// param returns the i-th parameter, or "" if there are fewer.
+func (m *message) param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// nick returns the nickname part of the prefix.
+func (m *message) nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+var tagUnescaper = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func unescapeTagValue(v string) string {
+	return tagUnescaper.Replace(v)
+}
+
+// formatMessage builds a protocol line without CRLF. The last parameter is
+// sent as a trailing parameter when it needs to be.
+func formatMessage(command string, params ...string) string {
+	var b strings.Builder
+	b.WriteString(command)
+	for i, p := range params {
+		b.WriteByte(' ')
+		if i == len(params)-1 && (p == "" || strings.Contains(p, " ") || strings.HasPrefix(p, ":")) {
+			b.WriteByte(':')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// foldNick lowercases a nickname or channel name with the rfc1459 case
+// mapping most networks use, where []\~ are the uppercase of {}|^.
+func foldNick(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+// isChannelName reports whether target is a channel rather than a nickname.
+func isChannelName(target string) bool {
+	return target != "" && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// stripFormatting removes mIRC bold, color, italic, underline and similar
+// control codes from text.
+func stripFormatting(text string) string {
+	if !strings.ContainsAny(text, "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f") {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case 0x02, 0x0f, 0x11, 0x16, 0x1d, 0x1e, 0x1f:
+		case 0x03:
+			// \x03[fg[,bg]] with one or two digits each.
+			i += skipColor(text[i+1:], isDigit, 2)
+		case 0x04:
+			// \x04[RRGGBB[,RRGGBB]] hex colors.
+			i += skipColor(text[i+1:], isHexDigit, 6)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// skipColor returns the length of the color arguments at the start of s.
+func skipColor(s string, valid func(byte) bool, width int) int {
+	n := countPrefix(s, valid, width)
+	if n > 0 && n < len(s) && s[n] == ',' {
+		if bg := countPrefix(s[n+1:], valid, width); bg > 0 {
+			n += 1 + bg
+		}
+	}
+	return n
+}
+
+func countPrefix(s string, valid func(byte) bool, limit int) int {
+	n := 0
+	for n < len(s) && n < limit && valid(s[n]) {
+		n++
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want message
+	}{
+		{
+			line: "PING :irc.example.net\r\n",
+			want: message{Command: "PING", Params: []string{"irc.example.net"}},
+		},
+		{
+			line: ":alice!a@host PRIVMSG #ops :hello there",
+			want: message{Prefix: "alice!a@host", Command: "PRIVMSG", Params: []string{"#ops", "hello there"}},
+		},
+		{
+			line: `@account=alice;msgid=abc\s1;+typing :alice!a@h privmsg bot ::-)`,
+			want: message{
+				Tags:    map[string]string{"account": "alice", "msgid": "abc 1", "+typing": ""},
+				Prefix:  "alice!a@h",
+				Command: "PRIVMSG",
+				Params:  []string{"bot", ":-)"},
+			},
+		},
+		{
+			line: ":srv CAP *  LS * :sasl=PLAIN,EXTERNAL account-tag",
+			want: message{Prefix: "srv", Command: "CAP", Params: []string{"*", "LS", "*", "sasl=PLAIN,EXTERNAL account-tag"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseMessage(tt.line)
+		if err != nil {
+			t.Fatalf("parseMessage(%q): %v", tt.line, err)
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseMessage(%q) = %+v, want %+v", tt.line, *got, tt.want)
+		}
+	}
+
+	if _, err := parseMessage("\r\n"); err == nil {
+		t.Error("empty line should not parse")
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	tests := []struct {
+		command string
+		params  []string
+		want    string
+	}{
+		{"NICK", []string{"pico"}, "NICK pico"},
+		{"PRIVMSG", []string{"#ops", "hi all"}, "PRIVMSG #ops :hi all"},
+		{"PRIVMSG", []string{"#ops", ":)"}, "PRIVMSG #ops ::)"},
+		{"USER", []string{"pico", "0", "*", "PicoClaw"}, "USER pico 0 * PicoClaw"},
+		{"AUTHENTICATE", []string{"+"}, "AUTHENTICATE +"},
+	}
+	for _, tt := range tests {
+		if got := formatMessage(tt.command, tt.params...); got != tt.want {
+			t.Errorf("formatMessage(%s, %q) = %q, want %q", tt.command, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestStripFormatting(t *testing.T) {
+	tests := map[string]string{
+		"plain":                            "plain",
+		"\x02bold\x02 and \x1ditalic\x1d":  "bold and italic",
+		"\x0304red\x03 \x0312,01blue\x0f!": "red blue!",
+		"\x03,5 comma stays":               ",5 comma stays",
+		"\x04FF0000hex\x04":                "hex",
+	}
+	for in, want := range tests {
+		if got := stripFormatting(in); got != want {
+			t.Errorf("stripFormatting(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMentionOf(t *testing.T) {
+	tests := []struct {
+		text      string
+		mentioned bool
+		content   string
+	}{
+		{"pico: status?", true, "status?"},
+		{"PICO, status?", true, "status?"},
+		{"ask pico about it", true, "ask pico about it"},
+		{"pico", true, "pico"},
+		{"picoclaw: not me", false, "picoclaw: not me"},
+		{"hey (pico) there", true, "hey (pico) there"},
+		{"[pico] is another nick", false, "[pico] is another nick"},
+		{"nothing here", false, "nothing here"},
+	}
+	for _, tt := range tests {
+		mentioned, content := mentionOf("pico", tt.text)
+		if mentioned != tt.mentioned || content != tt.content {
+			t.Errorf("mentionOf(%q) = %v, %q; want %v, %q", tt.text, mentioned, content, tt.mentioned, tt.content)
+		}
+	}
+	if ok, _ := mentionOf("pico[m]", "PICO{M}: hi"); !ok {
+		t.Error("nick comparison should use rfc1459 case mapping")
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	content := "first\n\n  \nsecond " + strings.Repeat("wörd ", 60) + "\n" + strings.Repeat("日本語", 100)
+	lines := splitLines(content, 100)
+	if lines[0] != "first" {
+		t.Errorf("first line = %q", lines[0])
+	}
+	var joined strings.Builder
+	for _, line := range lines {
+		if len(line) > 100 {
+			t.Errorf("line of %d bytes exceeds the limit: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a character: %q", line)
+		}
+		if strings.ContainsAny(line, "\r\n") || strings.TrimSpace(line) == "" {
+			t.Errorf("invalid IRC line %q", line)
+		}
+		joined.WriteString(line)
+	}
+	want := strings.ReplaceAll(content, " ", "")
+	want = strings.ReplaceAll(want, "\n", "")
+	if got := strings.ReplaceAll(joined.String(), " ", ""); got != want {
+		t.Errorf("split lost text:\n got %q\nwant %q", got, want)
+	}
+}
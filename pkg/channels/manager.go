@@ -62,6 +62,12 @@ var channelRateConfig = map[string]float64{
 	"discord":  1,
 	"slack":    1,
 	"line":     10,
+	// Mattermost and Rocket.Chat rate-limit per user; stay well below
+	// their defaults.
+	"mattermost": 5,
+	"rocketchat": 5,
+	// The OpenAI-compatible API answers local HTTP requests.
+	"openai_api": 100,
 }
 
 type channelWorker struct {
@@ -267,6 +273,38 @@ func (m *Manager) initChannels() error {
 		m.initChannel("pico", "Pico")
 	}
 
+	if m.config.Channels.Email.Enabled && m.config.Channels.Email.IMAPHost != "" {
+		m.initChannel("email", "Email")
+	}
+
+	if m.config.Channels.Matrix.Enabled && m.config.Channels.Matrix.Homeserver != "" {
+		m.initChannel("matrix", "Matrix")
+	}
+
+	if m.config.Channels.IRC.Enabled && len(m.config.Channels.IRC.Networks) > 0 {
+		m.initChannel("irc", "IRC")
+	}
+
+	if m.config.Channels.Signal.Enabled && m.config.Channels.Signal.Account != "" {
+		m.initChannel("signal", "Signal")
+	}
+
+	if m.config.Channels.Mattermost.Enabled && m.config.Channels.Mattermost.ServerURL != "" {
+		m.initChannel("mattermost", "Mattermost")
+	}
+
+	if m.config.Channels.RocketChat.Enabled && m.config.Channels.RocketChat.ServerURL != "" {
+		m.initChannel("rocketchat", "Rocket.Chat")
+	}
+
+	if api := m.config.Channels.OpenAIAPI; api.Enabled && (api.Token != "" || len(api.Users) > 0) {
+		m.initChannel("openai_api", "OpenAI API")
+	}
+
+	if m.config.Channels.Webhook.Enabled && len(m.config.Channels.Webhook.Endpoints) > 0 {
+		m.initChannel("webhook", "Webhook")
+	}
+
 	logger.InfoCF("channels", "Channel initialization completed", map[string]any{
 		"enabled_channels": len(m.channels),
 	})
@@ -461,15 +499,29 @@ func (m *Manager) runWorker(ctx context.Context, name string, w *channelWorker)
 			if !ok {
 				return
 			}
+			if msg.Partial {
+				m.sendPartial(ctx, name, w, msg)
+				continue
+			}
+			if msg.Final && msg.Content == "" {
+				// An empty final reply only tells request channels that the turn is over.
+				if _, ok := w.ch.(TurnCompleter); !ok {
+					continue
+				}
+			}
 			maxLen := 0
 			if mlp, ok := w.ch.(MessageLengthProvider); ok {
 				maxLen = mlp.MaxMessageLength()
 			}
 			if maxLen > 0 && len([]rune(msg.Content)) > maxLen {
 				chunks := SplitMessage(msg.Content, maxLen)
-				for _, chunk := range chunks {
+				for i, chunk := range chunks {
 					chunkMsg := msg
 					chunkMsg.Content = chunk
+					if i > 0 {
+						// Only the first chunk quotes the message; the rest follow it.
+						chunkMsg.ReplyToMessageID = ""
+					}
 					m.sendWithRetry(ctx, name, w, chunkMsg)
 				}
 			} else {
@@ -481,6 +533,69 @@ func (m *Manager) runWorker(ctx context.Context, name string, w *channelWorker)
 	}
 }
 
+// sendPartial applies an in-progress streamed reply by editing the chat's
+// pending placeholder. The placeholder stays registered so later partial
+// updates and the final reply can edit it again. Partial updates are
+// best-effort: they are dropped when there is no placeholder, the channel
+// cannot edit messages, the text no longer fits in one message, or the
+// rate limiter has no token available. Channels implementing StreamCapable
+// receive every partial update directly instead.
+func (m *Manager) sendPartial(ctx context.Context, name string, w *channelWorker, msg bus.OutboundMessage) {
+	if sc, ok := w.ch.(StreamCapable); ok {
+		if err := sc.StreamPartial(ctx, msg.ChatID, msg.Content); err != nil {
+			logger.DebugCF("channels", "Partial update stream failed", map[string]any{
+				"channel": name,
+				"chat_id": msg.ChatID,
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+	editor, ok := w.ch.(MessageEditor)
+	if !ok {
+		return
+	}
+	v, ok := m.placeholders.Load(name + ":" + msg.ChatID)
+	if !ok {
+		return
+	}
+	entry, ok := v.(placeholderEntry)
+	if !ok || entry.id == "" {
+		return
+	}
+	if mlp, ok := w.ch.(MessageLengthProvider); ok {
+		if maxLen := mlp.MaxMessageLength(); maxLen > 0 && len([]rune(msg.Content)) > maxLen {
+			return
+		}
+	}
+	if !w.limiter.Allow() {
+		return
+	}
+	if err := editor.EditMessage(ctx, msg.ChatID, entry.id, msg.Content); err != nil {
+		logger.DebugCF("channels", "Partial update edit failed", map[string]any{
+			"channel": name,
+			"chat_id": msg.ChatID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// send delivers msg through ch, using ThreadCapable.SendReply when the
+// message targets a thread or another message and the channel supports it.
+func send(ctx context.Context, ch Channel, msg bus.OutboundMessage) error {
+	if msg.Final {
+		if tc, ok := ch.(TurnCompleter); ok {
+			return tc.CompleteTurn(ctx, msg.ChatID, msg.Content)
+		}
+	}
+	if msg.ReplyToMessageID != "" || msg.ThreadID != "" {
+		if tc, ok := ch.(ThreadCapable); ok {
+			return tc.SendReply(ctx, msg)
+		}
+	}
+	return ch.Send(ctx, msg)
+}
+
 // sendWithRetry sends a message through the channel with rate limiting and
 // retry logic. It classifies errors to determine the retry strategy:
 //   - ErrNotRunning / ErrSendFailed: permanent, no retry
@@ -500,7 +615,7 @@ func (m *Manager) sendWithRetry(ctx context.Context, name string, w *channelWork
 
 	var lastErr error
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		lastErr = w.ch.Send(ctx, msg)
+		lastErr = send(ctx, w.ch, msg)
 		if lastErr == nil {
 			return
 		}
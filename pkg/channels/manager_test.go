@@ -860,3 +860,200 @@ func TestBuildMediaScope_WithMessageID(t *testing.T) {
 		t.Fatalf("expected %s, got %s", expected, scope)
 	}
 }
+
+func TestSendPartial_EditsPlaceholderWithoutConsumingIt(t *testing.T) {
+	m := newTestManager()
+	var edits []string
+
+	ch := &mockMessageEditor{
+		mockChannel: mockChannel{
+			sendFn: func(_ context.Context, _ bus.OutboundMessage) error {
+				t.Fatal("Send should not be called for partial updates")
+				return nil
+			},
+		},
+		editFn: func(_ context.Context, chatID, messageID, content string) error {
+			if messageID != "456" {
+				t.Fatalf("expected messageID 456, got %s", messageID)
+			}
+			edits = append(edits, content)
+			return nil
+		},
+	}
+	w := &channelWorker{ch: ch, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+	m.RecordPlaceholder("test", "123", "456")
+
+	m.sendPartial(context.Background(), "test", w, bus.OutboundMessage{
+		Channel: "test", ChatID: "123", Content: "hel", Partial: true,
+	})
+	m.sendPartial(context.Background(), "test", w, bus.OutboundMessage{
+		Channel: "test", ChatID: "123", Content: "hello", Partial: true,
+	})
+
+	if len(edits) != 2 || edits[0] != "hel" || edits[1] != "hello" {
+		t.Fatalf("unexpected edits: %v", edits)
+	}
+
+	// The final reply must still find the placeholder.
+	if !m.preSend(context.Background(), "test", bus.OutboundMessage{ChatID: "123", Content: "hello world"}, ch) {
+		t.Fatal("expected final reply to edit the placeholder")
+	}
+	if edits[len(edits)-1] != "hello world" {
+		t.Fatalf("expected final edit 'hello world', got %q", edits[len(edits)-1])
+	}
+}
+
+func TestSendPartial_DroppedWithoutPlaceholder(t *testing.T) {
+	m := newTestManager()
+
+	ch := &mockMessageEditor{
+		mockChannel: mockChannel{
+			sendFn: func(_ context.Context, _ bus.OutboundMessage) error {
+				t.Fatal("Send should not be called for partial updates")
+				return nil
+			},
+		},
+		editFn: func(_ context.Context, _, _, _ string) error {
+			t.Fatal("EditMessage should not be called without a placeholder")
+			return nil
+		},
+	}
+	w := &channelWorker{ch: ch, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+	m.sendPartial(context.Background(), "test", w, bus.OutboundMessage{
+		Channel: "test", ChatID: "123", Content: "partial", Partial: true,
+	})
+}
+
+type mockStreamChannel struct {
+	mockChannel
+	partials []string
+}
+
+func (m *mockStreamChannel) StreamPartial(_ context.Context, _, content string) error {
+	m.partials = append(m.partials, content)
+	return nil
+}
+
+func TestSendPartial_StreamCapableReceivesUpdates(t *testing.T) {
+	m := newTestManager()
+	ch := &mockStreamChannel{}
+	// A stream-capable channel needs neither a placeholder nor a limiter token.
+	w := &channelWorker{ch: ch, limiter: rate.NewLimiter(0, 0)}
+
+	m.sendPartial(context.Background(), "test", w, bus.OutboundMessage{
+		Channel: "test", ChatID: "123", Content: "hel", Partial: true,
+	})
+	m.sendPartial(context.Background(), "test", w, bus.OutboundMessage{
+		Channel: "test", ChatID: "123", Content: "hello", Partial: true,
+	})
+
+	if len(ch.partials) != 2 || ch.partials[1] != "hello" {
+		t.Fatalf("unexpected partials: %v", ch.partials)
+	}
+}
+
+type mockThreadChannel struct {
+	mockChannel
+	replies []bus.OutboundMessage
+}
+
+func (m *mockThreadChannel) SendReply(_ context.Context, msg bus.OutboundMessage) error {
+	m.replies = append(m.replies, msg)
+	return nil
+}
+
+func TestSendWithRetry_ThreadedMessageUsesSendReply(t *testing.T) {
+	m := newTestManager()
+	var sent []bus.OutboundMessage
+	ch := &mockThreadChannel{mockChannel: mockChannel{
+		sendFn: func(_ context.Context, msg bus.OutboundMessage) error {
+			sent = append(sent, msg)
+			return nil
+		},
+	}}
+	w := &channelWorker{ch: ch, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+	m.sendWithRetry(context.Background(), "test", w, bus.OutboundMessage{ChatID: "1", Content: "plain"})
+	m.sendWithRetry(context.Background(), "test", w, bus.OutboundMessage{
+		ChatID: "1", Content: "reply", ReplyToMessageID: "42",
+	})
+
+	if len(sent) != 1 || sent[0].Content != "plain" {
+		t.Fatalf("expected only the plain message via Send, got %v", sent)
+	}
+	if len(ch.replies) != 1 || ch.replies[0].ReplyToMessageID != "42" {
+		t.Fatalf("expected the reply via SendReply, got %v", ch.replies)
+	}
+}
+
+func TestSendWithRetry_ThreadedMessageFallsBackToSend(t *testing.T) {
+	m := newTestManager()
+	var sent int
+	ch := &mockChannel{sendFn: func(_ context.Context, _ bus.OutboundMessage) error {
+		sent++
+		return nil
+	}}
+	w := &channelWorker{ch: ch, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+	m.sendWithRetry(context.Background(), "test", w, bus.OutboundMessage{
+		ChatID: "1", Content: "reply", ThreadID: "t1",
+	})
+
+	if sent != 1 {
+		t.Fatalf("expected Send for a channel without thread support, got %d calls", sent)
+	}
+}
+
+type mockTurnChannel struct {
+	mockChannel
+	completed []string
+}
+
+func (m *mockTurnChannel) CompleteTurn(_ context.Context, _, content string) error {
+	m.completed = append(m.completed, content)
+	return nil
+}
+
+func TestRunWorker_FinalReplies(t *testing.T) {
+	m := newTestManager()
+	var sent []string
+	sendFn := func(_ context.Context, msg bus.OutboundMessage) error {
+		sent = append(sent, msg.Content)
+		return nil
+	}
+	plain := &channelWorker{
+		ch:      &mockChannel{sendFn: sendFn},
+		queue:   make(chan bus.OutboundMessage, 10),
+		done:    make(chan struct{}),
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+	turnCh := &mockTurnChannel{mockChannel: mockChannel{sendFn: sendFn}}
+	turn := &channelWorker{
+		ch:      turnCh,
+		queue:   make(chan bus.OutboundMessage, 10),
+		done:    make(chan struct{}),
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	plain.queue <- bus.OutboundMessage{ChatID: "1", Content: "tool output"}
+	plain.queue <- bus.OutboundMessage{ChatID: "1", Final: true}
+	plain.queue <- bus.OutboundMessage{ChatID: "1", Content: "answer", Final: true}
+	close(plain.queue)
+	m.runWorker(ctx, "plain", plain)
+	if len(sent) != 2 || sent[0] != "tool output" || sent[1] != "answer" {
+		t.Fatalf("plain channel got %q, want the empty final reply dropped", sent)
+	}
+
+	sent = nil
+	turn.queue <- bus.OutboundMessage{ChatID: "1", Content: "tool output"}
+	turn.queue <- bus.OutboundMessage{ChatID: "1", Final: true}
+	close(turn.queue)
+	m.runWorker(ctx, "turn", turn)
+	cancel()
+	if len(sent) != 1 || len(turnCh.completed) != 1 || turnCh.completed[0] != "" {
+		t.Fatalf("sent %q, completed %q", sent, turnCh.completed)
+	}
+}
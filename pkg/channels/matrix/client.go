@@ -0,0 +1,299 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	clientPrefix = "/_matrix/client/v3"
+	// maxDownloadSize bounds media downloaded from the homeserver.
+	maxDownloadSize = 50 << 20
+)
+
+// apiError is an error response of the client-server API.
+type apiError struct {
+	Status  int
+	ErrCode string `json:"errcode"`
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("matrix: %d %s: %s", e.Status, e.ErrCode, e.Message)
+}
+
+// isAPIError reports whether err is an API error with the given errcode.
+func isAPIError(err error, errcode string) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.ErrCode == errcode
+}
+
+// event is a room, state or account data event.
+type event struct {
+	Type           string          `json:"type"`
+	EventID        string          `json:"event_id,omitempty"`
+	Sender         string          `json:"sender,omitempty"`
+	StateKey       *string         `json:"state_key,omitempty"`
+	OriginServerTS int64           `json:"origin_server_ts,omitempty"`
+	Content        json.RawMessage `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join   map[string]joinedRoom  `json:"join"`
+		Invite map[string]invitedRoom `json:"invite"`
+		Leave  map[string]struct{}    `json:"leave"`
+	} `json:"rooms"`
+	AccountData struct {
+		Events []event `json:"events"`
+	} `json:"account_data"`
+}
+
+type joinedRoom struct {
+	Summary struct {
+		JoinedMemberCount *int `json:"m.joined_member_count"`
+	} `json:"summary"`
+	Timeline struct {
+		Events []event `json:"events"`
+	} `json:"timeline"`
+}
+
+type invitedRoom struct {
+	InviteState struct {
+		Events []event `json:"events"`
+	} `json:"invite_state"`
+}
+
+// client is a minimal Matrix client-server API client.
+type client struct {
+	homeserver  string
+	accessToken string
+	userID      string
+	deviceID    string
+	http        *http.Client
+	txnPrefix   string
+	txnCounter  atomic.Uint64
+}
+
+func newClient(homeserver string) *client {
+	return &client{
+		homeserver: strings.TrimRight(homeserver, "/"),
+		http:       &http.Client{},
+		txnPrefix:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// txnID returns a transaction ID unique for this access token.
+func (c *client) txnID() string {
+	return c.txnPrefix + "." + strconv.FormatUint(c.txnCounter.Add(1), 10)
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	resp, err := c.request(ctx, method, path, query, reader, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// request sends an authenticated request and turns error statuses into
+// *apiError.
+func (c *client) request(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	body io.Reader,
+	contentType string,
+) (*http.Response, error) {
+	u := c.homeserver + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, apiErr) != nil || apiErr.ErrCode == "" {
+			apiErr.ErrCode = "M_UNKNOWN"
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+func (c *client) whoami(ctx context.Context) (userID, deviceID string, err error) {
+	var resp struct {
+		UserID   string `json:"user_id"`
+		DeviceID string `json:"device_id"`
+	}
+	err = c.do(ctx, http.MethodGet, clientPrefix+"/account/whoami", nil, nil, &resp)
+	return resp.UserID, resp.DeviceID, err
+}
+
+// login logs in with a password and adopts the returned access token.
+func (c *client) login(ctx context.Context, user, password, deviceID string) error {
+	req := map[string]any{
+		"type":                        "m.login.password",
+		"identifier":                  map[string]string{"type": "m.id.user", "user": user},
+		"password":                    password,
+		"initial_device_display_name": "PicoClaw",
+	}
+	if deviceID != "" {
+		req["device_id"] = deviceID
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		UserID      string `json:"user_id"`
+		DeviceID    string `json:"device_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, clientPrefix+"/login", nil, req, &resp); err != nil {
+		return err
+	}
+	c.accessToken, c.userID, c.deviceID = resp.AccessToken, resp.UserID, resp.DeviceID
+	return nil
+}
+
+func (c *client) sync(ctx context.Context, since string, timeout time.Duration, filter string) (*syncResponse, error) {
+	query := url.Values{"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+	var resp syncResponse
+	if err := c.do(ctx, http.MethodGet, clientPrefix+"/sync", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func roomPath(roomID string, parts ...string) string {
+	p := clientPrefix + "/rooms/" + url.PathEscape(roomID)
+	for _, part := range parts {
+		p += "/" + url.PathEscape(part)
+	}
+	return p
+}
+
+// sendEvent sends a room event and returns its event ID.
+func (c *client) sendEvent(ctx context.Context, roomID, eventType string, content any) (string, error) {
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	err := c.do(ctx, http.MethodPut, roomPath(roomID, "send", eventType, c.txnID()), nil, content, &resp)
+	return resp.EventID, err
+}
+
+func (c *client) redact(ctx context.Context, roomID, eventID string) error {
+	return c.do(ctx, http.MethodPut, roomPath(roomID, "redact", eventID, c.txnID()), nil, map[string]any{}, nil)
+}
+
+func (c *client) setTyping(ctx context.Context, roomID string, typing bool, timeout time.Duration) error {
+	body := map[string]any{"typing": typing}
+	if typing {
+		body["timeout"] = timeout.Milliseconds()
+	}
+	return c.do(ctx, http.MethodPut, roomPath(roomID, "typing", c.userID), nil, body, nil)
+}
+
+func (c *client) joinRoom(ctx context.Context, roomID string) error {
+	return c.do(ctx, http.MethodPost, roomPath(roomID, "join"), nil, map[string]any{}, nil)
+}
+
+func (c *client) displayName(ctx context.Context, userID string) (string, error) {
+	var resp struct {
+		DisplayName string `json:"displayname"`
+	}
+	err := c.do(ctx, http.MethodGet, clientPrefix+"/profile/"+url.PathEscape(userID)+"/displayname", nil, nil, &resp)
+	return resp.DisplayName, err
+}
+
+// upload stores data in the media repository and returns its mxc:// URI.
+func (c *client) upload(ctx context.Context, data []byte, contentType, filename string) (string, error) {
+	query := url.Values{}
+	if filename != "" {
+		query.Set("filename", filename)
+	}
+	resp, err := c.request(ctx, http.MethodPost, "/_matrix/media/v3/upload", query, bytes.NewReader(data), contentType)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.ContentURI, nil
+}
+
+// download fetches an mxc:// URI, using authenticated media and falling
+// back to the legacy endpoint on older homeservers.
+func (c *client) download(ctx context.Context, mxc string) ([]byte, error) {
+	server, mediaID, ok := strings.Cut(strings.TrimPrefix(mxc, "mxc://"), "/")
+	if !ok || !strings.HasPrefix(mxc, "mxc://") || server == "" || mediaID == "" {
+		return nil, fmt.Errorf("matrix: invalid media URI %q", mxc)
+	}
+	suffix := "/download/" + url.PathEscape(server) + "/" + url.PathEscape(mediaID)
+	resp, err := c.request(ctx, http.MethodGet, "/_matrix/client/v1/media"+suffix, nil, nil, "")
+	if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || (apiErr.Status != http.StatusNotFound && apiErr.ErrCode != "M_UNRECOGNIZED") {
+			return nil, err
+		}
+		if resp, err = c.request(ctx, http.MethodGet, "/_matrix/media/v3"+suffix, nil, nil, ""); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("matrix: media %s exceeds %d bytes", mxc, maxDownloadSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,20 @@
+package matrix
+
+import (
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func init() {
+	channels.RegisterFactory("matrix", func(cfg *config.Config, b *bus.MessageBus) (channels.Channel, error) {
+		mxCfg := cfg.Channels.Matrix
+		storePath := mxCfg.StorePath
+		if storePath == "" {
+			storePath = filepath.Join(cfg.WorkspacePath(), "matrix")
+		}
+		return NewMatrixChannel(mxCfg, b, storePath)
+	})
+}
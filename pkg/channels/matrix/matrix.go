@@ -0,0 +1,771 @@
+// Package matrix implements a channel for Matrix homeservers over the
+// client-server API. End-to-end encrypted rooms are not supported: there is
+// no Olm/Megolm implementation, and senders in such rooms are told so.
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/fileutil"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	syncTimeout = 30 * time.Second
+	// initialSyncFilter skips the history of joined rooms on the very first
+	// sync, while still returning their state.
+	initialSyncFilter = `{"room":{"timeline":{"limit":0}}}`
+	minRetryDelay     = 2 * time.Second
+	maxRetryDelay     = time.Minute
+
+	typingTimeout     = 30 * time.Second
+	maxTypingDuration = 5 * time.Minute
+	reactionKey       = "👀"
+
+	// Events are limited to 64 KiB; this leaves room for multi-byte text.
+	maxMessageLength = 12000
+)
+
+// session is persisted between restarts so that the device stays the same
+// and no event is handled twice.
+type session struct {
+	UserID   string `json:"user_id"`
+	DeviceID string `json:"device_id"`
+	// AccessToken is only stored for password logins.
+	AccessToken string `json:"access_token,omitempty"`
+	NextBatch   string `json:"next_batch"`
+}
+
+// messageContent is the content of an m.room.message event.
+type messageContent struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	FormattedBody string `json:"formatted_body"`
+	FileName      string `json:"filename"`
+	URL           string `json:"url"`
+	Info          struct {
+		MimeType string `json:"mimetype"`
+	} `json:"info"`
+	RelatesTo *struct {
+		RelType   string          `json:"rel_type"`
+		InReplyTo json.RawMessage `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+	Mentions *struct {
+		UserIDs []string `json:"user_ids"`
+	} `json:"m.mentions"`
+}
+
+type MatrixChannel struct {
+	*channels.BaseChannel
+	config    config.MatrixConfig
+	storePath string
+	client    *client
+	// displayName is our display name, used to detect plain-text mentions.
+	displayName string
+	session     session
+
+	mu           sync.Mutex
+	memberCounts map[string]int
+	directRooms  map[string]bool
+	// encryptedNoticed holds the rooms told that encrypted messages
+	// cannot be read, so that each is told once per run.
+	encryptedNoticed map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMatrixChannel creates the channel. storePath is the directory for the
+// session.
+func NewMatrixChannel(cfg config.MatrixConfig, messageBus *bus.MessageBus, storePath string) (*MatrixChannel, error) {
+	if cfg.Homeserver == "" {
+		return nil, fmt.Errorf("matrix homeserver is required")
+	}
+	if cfg.AccessToken == "" && (cfg.UserID == "" || cfg.Password == "") {
+		return nil, fmt.Errorf("matrix access_token, or user_id and password, are required")
+	}
+
+	// Matrix user IDs contain a colon, so they are only matched reliably in
+	// canonical form.
+	allowFrom := make([]string, len(cfg.AllowFrom))
+	for i, a := range cfg.AllowFrom {
+		a = strings.TrimSpace(a)
+		if strings.HasPrefix(a, "@") && strings.Contains(a, ":") {
+			a = identity.BuildCanonicalID("matrix", a)
+		}
+		allowFrom[i] = a
+	}
+
+	base := channels.NewBaseChannel("matrix", cfg, messageBus, allowFrom,
+		channels.WithMaxMessageLength(maxMessageLength),
+		channels.WithGroupTrigger(cfg.GroupTrigger),
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &MatrixChannel{
+		BaseChannel:      base,
+		config:           cfg,
+		storePath:        storePath,
+		client:           newClient(cfg.Homeserver),
+		memberCounts:     make(map[string]int),
+		directRooms:      make(map[string]bool),
+		encryptedNoticed: make(map[string]bool),
+	}, nil
+}
+
+func (c *MatrixChannel) Start(ctx context.Context) error {
+	logger.InfoC("matrix", "Starting Matrix channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	if err := c.connect(c.ctx); err != nil {
+		c.cancel()
+		return err
+	}
+
+	c.done = make(chan struct{})
+	go c.syncLoop()
+
+	c.SetRunning(true)
+	logger.InfoCF("matrix", "Matrix channel started", map[string]any{
+		"user_id":   c.client.userID,
+		"device_id": c.client.deviceID,
+	})
+	return nil
+}
+
+func (c *MatrixChannel) Stop(ctx context.Context) error {
+	logger.InfoC("matrix", "Stopping Matrix channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.done != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("matrix", "Matrix channel stopped")
+	return nil
+}
+
+// connect authenticates and restores the session.
+func (c *MatrixChannel) connect(ctx context.Context) error {
+	c.loadSession()
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+	if c.session.UserID != c.client.userID || c.session.DeviceID != c.client.deviceID {
+		// A new identity starts from the current state of its rooms.
+		c.session.NextBatch = ""
+	}
+	c.session.UserID, c.session.DeviceID = c.client.userID, c.client.deviceID
+	if err := c.saveSession(); err != nil {
+		return err
+	}
+
+	if name, err := c.client.displayName(ctx, c.client.userID); err == nil {
+		c.displayName = name
+	}
+	return nil
+}
+
+func (c *MatrixChannel) authenticate(ctx context.Context) error {
+	if c.config.AccessToken != "" {
+		c.client.accessToken = c.config.AccessToken
+		return c.whoami(ctx)
+	}
+	if c.session.AccessToken != "" && c.session.UserID == c.config.UserID {
+		c.client.accessToken = c.session.AccessToken
+		err := c.whoami(ctx)
+		if err == nil || !isAPIError(err, "M_UNKNOWN_TOKEN") {
+			return err
+		}
+		logger.WarnC("matrix", "Stored Matrix session expired, logging in again")
+	}
+
+	deviceID := c.config.DeviceID
+	if deviceID == "" && c.session.UserID == c.config.UserID {
+		deviceID = c.session.DeviceID
+	}
+	if err := c.client.login(ctx, c.config.UserID, c.config.Password, deviceID); err != nil {
+		return fmt.Errorf("matrix login: %w", err)
+	}
+	c.session.AccessToken = c.client.accessToken
+	return nil
+}
+
+func (c *MatrixChannel) whoami(ctx context.Context) error {
+	userID, deviceID, err := c.client.whoami(ctx)
+	if err != nil {
+		return fmt.Errorf("matrix whoami: %w", err)
+	}
+	if deviceID == "" {
+		deviceID = c.config.DeviceID
+	}
+	c.client.userID, c.client.deviceID = userID, deviceID
+	return nil
+}
+
+func (c *MatrixChannel) loadSession() {
+	data, err := os.ReadFile(filepath.Join(c.storePath, "session.json"))
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &c.session); err != nil {
+		logger.WarnCF("matrix", "Ignoring invalid session file", map[string]any{"error": err.Error()})
+		c.session = session{}
+	}
+}
+
+func (c *MatrixChannel) saveSession() error {
+	data, err := json.Marshal(c.session)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(filepath.Join(c.storePath, "session.json"), data, 0o600)
+}
+
+// syncLoop long-polls /sync until the channel is stopped.
+func (c *MatrixChannel) syncLoop() {
+	defer close(c.done)
+	delay := minRetryDelay
+	for c.ctx.Err() == nil {
+		since := c.session.NextBatch
+		timeout, filter := syncTimeout, ""
+		if since == "" {
+			timeout, filter = 0, initialSyncFilter
+		}
+
+		resp, err := c.client.sync(c.ctx, since, timeout, filter)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			logger.WarnCF("matrix", "Sync failed", map[string]any{
+				"error":       err.Error(),
+				"retry_after": delay.String(),
+			})
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRetryDelay)
+			continue
+		}
+		delay = minRetryDelay
+
+		c.processSync(resp, since == "")
+		c.session.NextBatch = resp.NextBatch
+		if err := c.saveSession(); err != nil {
+			logger.WarnCF("matrix", "Failed to save session", map[string]any{"error": err.Error()})
+		}
+	}
+}
+
+// processSync handles one sync response. Timeline events of the initial
+// sync are history and not answered.
+func (c *MatrixChannel) processSync(resp *syncResponse, initial bool) {
+	for _, ev := range resp.AccountData.Events {
+		if ev.Type == "m.direct" {
+			c.setDirectRooms(ev.Content)
+		}
+	}
+
+	for roomID, room := range resp.Rooms.Invite {
+		c.handleInvite(roomID, room)
+	}
+
+	for roomID, room := range resp.Rooms.Join {
+		if n := room.Summary.JoinedMemberCount; n != nil {
+			c.mu.Lock()
+			c.memberCounts[roomID] = *n
+			c.mu.Unlock()
+		}
+		for _, ev := range room.Timeline.Events {
+			if ev.StateKey == nil && !initial {
+				c.handleRoomEvent(roomID, ev)
+			}
+		}
+	}
+
+	for roomID := range resp.Rooms.Leave {
+		c.mu.Lock()
+		delete(c.memberCounts, roomID)
+		c.mu.Unlock()
+	}
+}
+
+// setDirectRooms reads the m.direct account data, which lists the DM rooms
+// per user.
+func (c *MatrixChannel) setDirectRooms(content json.RawMessage) {
+	var direct map[string][]string
+	if err := json.Unmarshal(content, &direct); err != nil {
+		return
+	}
+	rooms := make(map[string]bool)
+	for _, ids := range direct {
+		for _, id := range ids {
+			rooms[id] = true
+		}
+	}
+	c.mu.Lock()
+	c.directRooms = rooms
+	c.mu.Unlock()
+}
+
+// isDirect reports whether roomID is a DM: listed in m.direct or with just
+// us and one other member.
+func (c *MatrixChannel) isDirect(roomID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.directRooms[roomID] || c.memberCounts[roomID] == 2
+}
+
+// handleInvite joins rooms we are invited to by allowed users.
+func (c *MatrixChannel) handleInvite(roomID string, room invitedRoom) {
+	if !c.config.AutoJoin {
+		return
+	}
+	var inviter string
+	for _, ev := range room.InviteState.Events {
+		if ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == c.client.userID {
+			inviter = ev.Sender
+		}
+	}
+	if inviter == "" || !c.IsAllowedSender(c.senderInfo(inviter)) {
+		logger.DebugCF("matrix", "Ignoring invite", map[string]any{"room_id": roomID, "inviter": inviter})
+		return
+	}
+	if err := c.client.joinRoom(c.ctx, roomID); err != nil {
+		logger.WarnCF("matrix", "Failed to join room", map[string]any{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+		return
+	}
+	logger.InfoCF("matrix", "Joined room", map[string]any{"room_id": roomID, "inviter": inviter})
+}
+
+func (c *MatrixChannel) senderInfo(userID string) bus.SenderInfo {
+	return bus.SenderInfo{
+		Platform:    "matrix",
+		PlatformID:  userID,
+		CanonicalID: identity.BuildCanonicalID("matrix", userID),
+		Username:    userID,
+	}
+}
+
+// encryptedNotice is sent to rooms whose messages cannot be read.
+const encryptedNotice = "I can't read end-to-end encrypted messages. " +
+	"Please message me in a room without encryption."
+
+// handleEncrypted tells an allowed sender, once per room, that their
+// encrypted messages cannot be read, instead of ignoring them silently.
+func (c *MatrixChannel) handleEncrypted(roomID string, ev event) {
+	logger.WarnCF("matrix", "Cannot read encrypted message; end-to-end encryption is not supported",
+		map[string]any{"room_id": roomID, "sender": ev.Sender})
+	if !c.IsAllowedSender(c.senderInfo(ev.Sender)) {
+		return
+	}
+
+	c.mu.Lock()
+	noticed := c.encryptedNoticed[roomID]
+	c.encryptedNoticed[roomID] = true
+	c.mu.Unlock()
+	if noticed {
+		return
+	}
+	// sendEvent logs failures; the notice is not retried.
+	c.sendEvent(c.ctx, roomID, "m.room.message", map[string]any{
+		"msgtype": "m.notice",
+		"body":    encryptedNotice,
+	})
+}
+
+func (c *MatrixChannel) handleRoomEvent(roomID string, ev event) {
+	if ev.Sender == c.client.userID {
+		return
+	}
+
+	if ev.Type == "m.room.encrypted" {
+		c.handleEncrypted(roomID, ev)
+		return
+	}
+	if ev.Type != "m.room.message" {
+		return
+	}
+
+	var content messageContent
+	if err := json.Unmarshal(ev.Content, &content); err != nil {
+		return
+	}
+	// Edits of earlier messages are not new requests.
+	if content.RelatesTo != nil && content.RelatesTo.RelType == "m.replace" {
+		return
+	}
+
+	sender := c.senderInfo(ev.Sender)
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("matrix", "Message rejected by allowlist", map[string]any{"sender": ev.Sender})
+		return
+	}
+
+	text := content.Body
+	if content.RelatesTo != nil && len(content.RelatesTo.InReplyTo) > 0 {
+		text = stripReplyFallback(text)
+	}
+	kind := mediaKind(content.MsgType)
+	filename := content.FileName
+	if kind != "" {
+		// Without a separate filename the body is the filename.
+		if filename == "" {
+			filename, text = text, ""
+		} else if text == filename {
+			text = ""
+		}
+	} else if content.MsgType != "m.text" && content.MsgType != "m.emote" {
+		// Notices are sent by bots and must not be answered.
+		return
+	}
+
+	direct := c.isDirect(roomID)
+	peer := bus.Peer{Kind: "group", ID: roomID}
+	if direct {
+		peer = bus.Peer{Kind: "direct", ID: ev.Sender}
+	} else {
+		mentioned := c.isMentioned(&content)
+		respond, cleaned := c.ShouldRespondInGroup(mentioned, c.stripMention(text))
+		if !respond {
+			return
+		}
+		text = cleaned
+	}
+
+	var mediaRefs []string
+	if kind != "" {
+		scope := channels.BuildMediaScope("matrix", roomID, ev.EventID)
+		if ref := c.storeMedia(&content, filename, scope); ref != "" {
+			mediaRefs = append(mediaRefs, ref)
+		}
+		text = strings.TrimSpace(text + "\n" + fmt.Sprintf("[%s: %s]", kind, filename))
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform": "matrix",
+		"room_id":  roomID,
+		"event_id": ev.EventID,
+	}
+
+	logger.DebugCF("matrix", "Received message", map[string]any{
+		"sender":  ev.Sender,
+		"room_id": roomID,
+		"direct":  direct,
+		"preview": utils.Truncate(text, 50),
+	})
+
+	c.HandleMessage(c.ctx, peer, ev.EventID, ev.Sender, roomID, text, mediaRefs, metadata, sender)
+}
+
+func mediaKind(msgType string) string {
+	switch msgType {
+	case "m.image":
+		return "image"
+	case "m.audio":
+		return "audio"
+	case "m.video":
+		return "video"
+	case "m.file":
+		return "file"
+	}
+	return ""
+}
+
+// isMentioned reports whether a group message addresses us, by intentional
+// mention or, from clients without m.mentions, by name.
+func (c *MatrixChannel) isMentioned(content *messageContent) bool {
+	if content.Mentions != nil {
+		return slices.Contains(content.Mentions.UserIDs, c.client.userID)
+	}
+	if strings.Contains(content.Body, c.client.userID) ||
+		strings.Contains(content.FormattedBody, "https://matrix.to/#/"+c.client.userID) {
+		return true
+	}
+	return c.displayName != "" && strings.Contains(strings.ToLower(content.Body), strings.ToLower(c.displayName))
+}
+
+// stripMention removes a leading "Name: " or user ID addressing us.
+func (c *MatrixChannel) stripMention(text string) string {
+	for _, name := range []string{c.client.userID, c.displayName} {
+		if name == "" || len(text) < len(name) || !strings.EqualFold(text[:len(name)], name) {
+			continue
+		}
+		return strings.TrimSpace(strings.TrimLeft(text[len(name):], ":,"))
+	}
+	return text
+}
+
+// stripReplyFallback removes the quoted "> <@user> ..." lines that clients
+// prepend to replies.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+}
+
+// storeMedia downloads an attachment and registers it with
+// the media store. It returns "" if that is not possible.
+func (c *MatrixChannel) storeMedia(content *messageContent, filename, scope string) string {
+	if c.GetMediaStore() == nil {
+		return ""
+	}
+
+	data, err := c.client.download(c.ctx, content.URL)
+	if err != nil {
+		logger.WarnCF("matrix", "Failed to download attachment", map[string]any{"error": err.Error()})
+		return ""
+	}
+	return c.StoreMedia(data, filename, content.Info.MimeType, scope)
+}
+
+func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+	if msg.Content == "" {
+		return nil
+	}
+	_, err := c.sendEvent(ctx, msg.ChatID, "m.room.message", map[string]any{
+		"msgtype": "m.text",
+		"body":    msg.Content,
+	})
+	return err
+}
+
+// sendEvent sends an event to roomID.
+func (c *MatrixChannel) sendEvent(ctx context.Context, roomID, eventType string, content map[string]any) (string, error) {
+	eventID, err := c.client.sendEvent(ctx, roomID, eventType, content)
+	if err != nil {
+		logger.ErrorCF("matrix", "Failed to send event", map[string]any{
+			"room_id": roomID,
+			"type":    eventType,
+			"error":   err.Error(),
+		})
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			return "", channels.ClassifySendError(apiErr.Status, err)
+		}
+		return "", channels.ClassifyNetError(err)
+	}
+	return eventID, nil
+}
+
+// EditMessage implements channels.MessageEditor.
+func (c *MatrixChannel) EditMessage(ctx context.Context, chatID string, messageID string, content string) error {
+	_, err := c.sendEvent(ctx, chatID, "m.room.message", map[string]any{
+		"msgtype": "m.text",
+		"body":    "* " + content,
+		"m.new_content": map[string]any{
+			"msgtype": "m.text",
+			"body":    content,
+		},
+		"m.relates_to": map[string]any{
+			"rel_type": "m.replace",
+			"event_id": messageID,
+		},
+	})
+	return err
+}
+
+// SendPlaceholder implements channels.PlaceholderCapable.
+func (c *MatrixChannel) SendPlaceholder(ctx context.Context, chatID string) (string, error) {
+	if !c.config.Placeholder.Enabled {
+		return "", nil
+	}
+
+	text := c.config.Placeholder.Text
+	if text == "" {
+		text = "Thinking... 💭"
+	}
+
+	return c.sendEvent(ctx, chatID, "m.room.message", map[string]any{
+		"msgtype": "m.notice",
+		"body":    text,
+	})
+}
+
+// ReactToMessage implements channels.ReactionCapable. The undo function
+// redacts the reaction.
+func (c *MatrixChannel) ReactToMessage(ctx context.Context, chatID, messageID string) (func(), error) {
+	eventID, err := c.sendEvent(ctx, chatID, "m.reaction", map[string]any{
+		"m.relates_to": map[string]any{
+			"rel_type": "m.annotation",
+			"event_id": messageID,
+			"key":      reactionKey,
+		},
+	})
+	if err != nil {
+		return func() {}, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := c.client.redact(ctx, chatID, eventID); err != nil {
+				logger.DebugCF("matrix", "Failed to remove reaction", map[string]any{"error": err.Error()})
+			}
+		})
+	}, nil
+}
+
+// StartTyping implements channels.TypingCapable. The notification is
+// renewed until stop is called, for at most maxTypingDuration.
+func (c *MatrixChannel) StartTyping(ctx context.Context, chatID string) (func(), error) {
+	if err := c.client.setTyping(ctx, chatID, true, typingTimeout); err != nil {
+		return func() {}, err
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingTimeout / 2)
+		defer ticker.Stop()
+		timeout := time.After(maxTypingDuration)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-timeout:
+				return
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.client.setTyping(c.ctx, chatID, true, typingTimeout); err != nil {
+					logger.DebugCF("matrix", "Typing error", map[string]any{"room_id": chatID, "error": err.Error()})
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopCh)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			c.client.setTyping(ctx, chatID, false, 0)
+		})
+	}, nil
+}
+
+// SendMedia implements the channels.MediaSender interface.
+func (c *MatrixChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	store := c.GetMediaStore()
+	if store == nil {
+		return fmt.Errorf("no media store available: %w", channels.ErrSendFailed)
+	}
+
+	for _, part := range msg.Parts {
+		localPath, err := store.Resolve(part.Ref)
+		if err != nil {
+			logger.ErrorCF("matrix", "Failed to resolve media ref", map[string]any{
+				"ref":   part.Ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			logger.ErrorCF("matrix", "Failed to read media file", map[string]any{"error": err.Error()})
+			continue
+		}
+
+		filename := part.Filename
+		if filename == "" {
+			filename = filepath.Base(localPath)
+		}
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		content := map[string]any{
+			"msgtype":  mediaMsgType(part.Type),
+			"body":     filename,
+			"filename": filename,
+			"info":     map[string]any{"mimetype": contentType, "size": len(data)},
+		}
+		if part.Caption != "" {
+			content["body"] = part.Caption
+		}
+
+		uri, err := c.client.upload(ctx, data, contentType, filename)
+		if err != nil {
+			return c.uploadError(err)
+		}
+		content["url"] = uri
+
+		if _, err := c.sendEvent(ctx, msg.ChatID, "m.room.message", content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MatrixChannel) uploadError(err error) error {
+	logger.ErrorCF("matrix", "Failed to upload media", map[string]any{"error": err.Error()})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return channels.ClassifySendError(apiErr.Status, err)
+	}
+	return channels.ClassifyNetError(err)
+}
+
+func mediaMsgType(partType string) string {
+	switch partType {
+	case "image":
+		return "m.image"
+	case "audio":
+		return "m.audio"
+	case "video":
+		return "m.video"
+	}
+	return "m.file"
+}
@@ -0,0 +1,595 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+const (
+	botID   = "@pico:hs.test"
+	aliceID = "@alice:hs.test"
+	bobID   = "@bob:hs.test"
+	dmRoom  = "!dm:hs.test"
+	grpRoom = "!group:hs.test"
+)
+
+type fakeDevice struct {
+	userID   string
+	deviceID string
+}
+
+type fakeRoom struct {
+	members []string
+}
+
+type loggedEvent struct {
+	roomID string
+	ev     event
+}
+
+// fakeHomeserver implements the parts of the client-server API the
+// channel uses, for any number of users identified by access token.
+type fakeHomeserver struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	devices  map[string]*fakeDevice // by access token
+	rooms    map[string]*fakeRoom
+	log      []loggedEvent
+	invites  map[string]string // room ID -> inviter, for the bot
+	joined   []string
+	typing   []bool
+	redacted []string
+	media    map[string][]byte
+	logins   int
+	synced   map[string]bool // device IDs that completed an initial sync
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	hs := &fakeHomeserver{
+		devices: map[string]*fakeDevice{
+			"bot-token":   {userID: botID, deviceID: "BOTDEVICE"},
+			"alice-token": {userID: aliceID, deviceID: "ALICEPHONE"},
+		},
+		rooms: map[string]*fakeRoom{
+			dmRoom:  {members: []string{aliceID, botID}},
+			grpRoom: {members: []string{aliceID, bobID, botID}},
+		},
+		invites: make(map[string]string),
+		media:   make(map[string][]byte),
+		synced:  make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/login", hs.handleLogin)
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", hs.auth(func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+		writeJSON(w, map[string]string{"user_id": d.userID, "device_id": d.deviceID})
+	}))
+	mux.HandleFunc("GET /_matrix/client/v3/profile/{user}/displayname", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"displayname": "PicoClaw"})
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", hs.auth(hs.handleSync))
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/{type}/{txn}", hs.auth(hs.handleSend))
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/typing/{user}", hs.auth(
+		func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+			var body struct {
+				Typing bool `json:"typing"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			hs.mu.Lock()
+			hs.typing = append(hs.typing, body.Typing)
+			hs.mu.Unlock()
+			writeJSON(w, map[string]any{})
+		}))
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/redact/{event}/{txn}", hs.auth(
+		func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+			hs.mu.Lock()
+			hs.redacted = append(hs.redacted, r.PathValue("event"))
+			hs.mu.Unlock()
+			writeJSON(w, map[string]string{"event_id": "$redaction"})
+		}))
+	mux.HandleFunc("POST /_matrix/client/v3/rooms/{room}/join", hs.auth(
+		func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+			hs.mu.Lock()
+			hs.joined = append(hs.joined, r.PathValue("room"))
+			hs.mu.Unlock()
+			writeJSON(w, map[string]string{"room_id": r.PathValue("room")})
+		}))
+	mux.HandleFunc("POST /_matrix/media/v3/upload", hs.auth(func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+		data, _ := io.ReadAll(r.Body)
+		hs.mu.Lock()
+		id := "m" + strconv.Itoa(len(hs.media))
+		hs.media[id] = data
+		hs.mu.Unlock()
+		writeJSON(w, map[string]string{"content_uri": "mxc://hs.test/" + id})
+	}))
+	mux.HandleFunc("GET /_matrix/client/v1/media/download/{server}/{id}", hs.auth(
+		func(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+			hs.mu.Lock()
+			data, ok := hs.media[r.PathValue("id")]
+			hs.mu.Unlock()
+			if !ok {
+				http.Error(w, `{"errcode":"M_NOT_FOUND","error":"not found"}`, http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}))
+
+	hs.srv = httptest.NewServer(mux)
+	t.Cleanup(hs.srv.Close)
+	return hs
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (hs *fakeHomeserver) auth(
+	h func(http.ResponseWriter, *http.Request, *fakeDevice),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hs.mu.Lock()
+		d := hs.devices[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		hs.mu.Unlock()
+		if d == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "unknown token"})
+			return
+		}
+		h(w, r, d)
+	}
+}
+
+func (hs *fakeHomeserver) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifier struct {
+			User string `json:"user"`
+		} `json:"identifier"`
+		Password string `json:"password"`
+		DeviceID string `json:"device_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Identifier.User != botID || req.Password != "hunter2" {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"errcode": "M_FORBIDDEN", "error": "bad password"})
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.logins++
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = "LOGIN" + strconv.Itoa(hs.logins)
+	}
+	token := "login-token-" + strconv.Itoa(hs.logins)
+	hs.devices[token] = &fakeDevice{userID: botID, deviceID: deviceID}
+	writeJSON(w, map[string]string{"access_token": token, "user_id": botID, "device_id": deviceID})
+}
+
+func (hs *fakeHomeserver) handleSync(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+	since := r.URL.Query().Get("since")
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	deadline := time.Now().Add(min(time.Duration(timeout)*time.Millisecond, 200*time.Millisecond))
+
+	type roomResp struct {
+		Summary  map[string]any     `json:"summary"`
+		State    map[string][]event `json:"state"`
+		Timeline map[string][]event `json:"timeline"`
+	}
+	resp := map[string]any{}
+	joined := map[string]*roomResp{}
+	room := func(id string) *roomResp {
+		if joined[id] == nil {
+			joined[id] = &roomResp{
+				Summary:  map[string]any{"m.joined_member_count": len(hs.rooms[id].members)},
+				State:    map[string][]event{"events": {}},
+				Timeline: map[string][]event{"events": {}},
+			}
+		}
+		return joined[id]
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if since == "" {
+		for id, rm := range hs.rooms {
+			if !contains(rm.members, d.userID) {
+				continue
+			}
+			r := room(id)
+			for _, m := range rm.members {
+				key := m
+				r.State["events"] = append(r.State["events"], event{
+					Type: "m.room.member", Sender: m, StateKey: &key,
+					Content: json.RawMessage(`{"membership":"join"}`),
+				})
+			}
+		}
+		since = strconv.Itoa(len(hs.log))
+		hs.synced[d.deviceID] = true
+	} else {
+		pos, _ := strconv.Atoi(since)
+		for len(hs.log) <= pos && len(hs.invites) == 0 && time.Now().Before(deadline) {
+			hs.mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			hs.mu.Lock()
+		}
+		for _, le := range hs.log[pos:] {
+			if contains(hs.rooms[le.roomID].members, d.userID) {
+				r := room(le.roomID)
+				r.Timeline["events"] = append(r.Timeline["events"], le.ev)
+			}
+		}
+		since = strconv.Itoa(len(hs.log))
+	}
+
+	rooms := map[string]any{"join": joined}
+	if d.userID == botID && len(hs.invites) > 0 {
+		invite := map[string]any{}
+		for id, inviter := range hs.invites {
+			key := botID
+			invite[id] = map[string]any{"invite_state": map[string]any{"events": []event{{
+				Type: "m.room.member", Sender: inviter, StateKey: &key,
+				Content: json.RawMessage(`{"membership":"invite"}`),
+			}}}}
+		}
+		hs.invites = make(map[string]string)
+		rooms["invite"] = invite
+	}
+	resp["rooms"] = rooms
+	resp["next_batch"] = since
+	writeJSON(w, resp)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (hs *fakeHomeserver) handleSend(w http.ResponseWriter, r *http.Request, d *fakeDevice) {
+	content, _ := io.ReadAll(r.Body)
+	eventID := hs.post(r.PathValue("room"), d.userID, r.PathValue("type"), string(content))
+	writeJSON(w, map[string]string{"event_id": eventID})
+}
+
+// post appends an event to a room's timeline and returns its ID.
+func (hs *fakeHomeserver) post(roomID, sender, eventType, content string) string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	eventID := fmt.Sprintf("$ev%d", len(hs.log))
+	hs.log = append(hs.log, loggedEvent{roomID: roomID, ev: event{
+		Type:           eventType,
+		EventID:        eventID,
+		Sender:         sender,
+		OriginServerTS: time.Now().UnixMilli(),
+		Content:        json.RawMessage(content),
+	}})
+	return eventID
+}
+
+// sentBy returns the events sender posted to roomID.
+func (hs *fakeHomeserver) sentBy(sender, roomID string) []event {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	var events []event
+	for _, le := range hs.log {
+		if le.ev.Sender == sender && le.roomID == roomID {
+			events = append(events, le.ev)
+		}
+	}
+	return events
+}
+
+func startMatrixChannel(t *testing.T, hs *fakeHomeserver, cfg config.MatrixConfig) (*MatrixChannel, *bus.MessageBus) {
+	t.Helper()
+	cfg.Homeserver = hs.srv.URL
+	if cfg.AccessToken == "" && cfg.Password == "" {
+		cfg.AccessToken = "bot-token"
+	}
+	msgBus := bus.NewMessageBus()
+	ch, err := NewMatrixChannel(cfg, msgBus, filepath.Join(t.TempDir(), "matrix"))
+	if err != nil {
+		t.Fatalf("NewMatrixChannel: %v", err)
+	}
+	ch.SetMediaStore(media.NewFileMediaStore())
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { stopChannel(ch) })
+	hs.waitSynced(t, "BOTDEVICE")
+	return ch, msgBus
+}
+
+func (hs *fakeHomeserver) waitSynced(t *testing.T, deviceID string) {
+	t.Helper()
+	waitFor(t, "initial sync", func() bool {
+		hs.mu.Lock()
+		defer hs.mu.Unlock()
+		return hs.synced[deviceID]
+	})
+}
+
+func stopChannel(ch *MatrixChannel) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch.Stop(ctx)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func nextInbound(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestMatrixChannel_ReceiveDirectAndGroup(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	_, msgBus := startMatrixChannel(t, hs, config.MatrixConfig{
+		AllowFrom:    config.FlexibleStringSlice{aliceID},
+		GroupTrigger: config.GroupTriggerConfig{MentionOnly: true},
+	})
+
+	hs.post(dmRoom, aliceID, "m.room.message", `{"msgtype":"m.text","body":"hello"}`)
+	msg := nextInbound(t, msgBus)
+	if msg.Channel != "matrix" || msg.ChatID != dmRoom || msg.Content != "hello" {
+		t.Errorf("inbound = %+v", msg)
+	}
+	if msg.Peer.Kind != "direct" || msg.Peer.ID != aliceID {
+		t.Errorf("peer = %+v, want direct peer %s", msg.Peer, aliceID)
+	}
+	if msg.SenderID != "matrix:"+aliceID {
+		t.Errorf("SenderID = %q", msg.SenderID)
+	}
+
+	// Ignored: not mentioned, a notice, not allowed, our own message and an edit.
+	hs.post(grpRoom, aliceID, "m.room.message", `{"msgtype":"m.text","body":"just chatting"}`)
+	hs.post(grpRoom, aliceID, "m.room.message", `{"msgtype":"m.notice","body":"PicoClaw: build passed"}`)
+	hs.post(grpRoom, bobID, "m.room.message",
+		`{"msgtype":"m.text","body":"PicoClaw: hi","m.mentions":{"user_ids":["`+botID+`"]}}`)
+	hs.post(grpRoom, botID, "m.room.message", `{"msgtype":"m.text","body":"PicoClaw: echo"}`)
+	hs.post(grpRoom, aliceID, "m.room.message",
+		`{"msgtype":"m.text","body":"* PicoClaw: fixed","m.relates_to":{"rel_type":"m.replace","event_id":"$x"}}`)
+	hs.post(grpRoom, aliceID, "m.room.message",
+		`{"msgtype":"m.text","body":"PicoClaw: what's up?","m.mentions":{"user_ids":["`+botID+`"]}}`)
+
+	msg = nextInbound(t, msgBus)
+	if msg.ChatID != grpRoom || msg.Content != "what's up?" {
+		t.Errorf("group inbound = %q in %s, want the mention without the name", msg.Content, msg.ChatID)
+	}
+	if msg.Peer.Kind != "group" || msg.Peer.ID != grpRoom {
+		t.Errorf("peer = %+v", msg.Peer)
+	}
+	if msg.MessageID == "" || msg.Metadata["event_id"] != msg.MessageID {
+		t.Errorf("MessageID = %q, metadata = %v", msg.MessageID, msg.Metadata)
+	}
+
+	// Replies lose the quoted fallback.
+	hs.post(dmRoom, aliceID, "m.room.message", `{"msgtype":"m.text","body":"> <@pico:hs.test> earlier\n\nthanks",`+
+		`"m.relates_to":{"m.in_reply_to":{"event_id":"$ev1"}}}`)
+	if msg = nextInbound(t, msgBus); msg.Content != "thanks" {
+		t.Errorf("reply content = %q", msg.Content)
+	}
+}
+
+func TestMatrixChannel_SendEditReactTyping(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	ch, _ := startMatrixChannel(t, hs, config.MatrixConfig{})
+	ctx := context.Background()
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: dmRoom, Content: "Hi Alice"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	sent := hs.sentBy(botID, dmRoom)
+	if len(sent) != 1 || sent[0].Type != "m.room.message" {
+		t.Fatalf("sent = %+v", sent)
+	}
+	var content map[string]any
+	json.Unmarshal(sent[0].Content, &content)
+	if content["msgtype"] != "m.text" || content["body"] != "Hi Alice" {
+		t.Errorf("content = %v", content)
+	}
+
+	if err := ch.EditMessage(ctx, dmRoom, sent[0].EventID, "Hi again"); err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	sent = hs.sentBy(botID, dmRoom)
+	var edit struct {
+		NewContent struct {
+			Body string `json:"body"`
+		} `json:"m.new_content"`
+		RelatesTo struct {
+			RelType string `json:"rel_type"`
+			EventID string `json:"event_id"`
+		} `json:"m.relates_to"`
+	}
+	json.Unmarshal(sent[1].Content, &edit)
+	if edit.NewContent.Body != "Hi again" || edit.RelatesTo.RelType != "m.replace" ||
+		edit.RelatesTo.EventID != sent[0].EventID {
+		t.Errorf("edit = %s", sent[1].Content)
+	}
+
+	undo, err := ch.ReactToMessage(ctx, dmRoom, "$target")
+	if err != nil {
+		t.Fatalf("ReactToMessage: %v", err)
+	}
+	sent = hs.sentBy(botID, dmRoom)
+	reaction := sent[len(sent)-1]
+	if reaction.Type != "m.reaction" || !strings.Contains(string(reaction.Content), `"key":"👀"`) {
+		t.Errorf("reaction = %s %s", reaction.Type, reaction.Content)
+	}
+	undo()
+	undo()
+	if len(hs.redacted) != 1 || hs.redacted[0] != reaction.EventID {
+		t.Errorf("redacted = %v, want [%s]", hs.redacted, reaction.EventID)
+	}
+
+	stop, err := ch.StartTyping(ctx, dmRoom)
+	if err != nil {
+		t.Fatalf("StartTyping: %v", err)
+	}
+	stop()
+	stop()
+	hs.mu.Lock()
+	typing := hs.typing
+	hs.mu.Unlock()
+	if len(typing) != 2 || !typing[0] || typing[1] {
+		t.Errorf("typing notifications = %v, want [true false]", typing)
+	}
+}
+
+func TestMatrixChannel_Media(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	ch, msgBus := startMatrixChannel(t, hs, config.MatrixConfig{})
+
+	hs.mu.Lock()
+	hs.media["cat"] = []byte("PNG DATA")
+	hs.mu.Unlock()
+	hs.post(dmRoom, aliceID, "m.room.message",
+		`{"msgtype":"m.image","body":"cat.png","url":"mxc://hs.test/cat","info":{"mimetype":"image/png"}}`)
+
+	msg := nextInbound(t, msgBus)
+	if msg.Content != "[image: cat.png]" || len(msg.Media) != 1 {
+		t.Fatalf("inbound = %q with media %v", msg.Content, msg.Media)
+	}
+	path, meta, err := ch.GetMediaStore().ResolveWithMeta(msg.Media[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "PNG DATA" || meta.ContentType != "image/png" {
+		t.Errorf("stored media = %q (%s)", data, meta.ContentType)
+	}
+
+	if err := ch.SendMedia(context.Background(), bus.OutboundMediaMessage{
+		ChatID: dmRoom,
+		Parts:  []bus.MediaPart{{Type: "image", Ref: msg.Media[0], Caption: "Your cat"}},
+	}); err != nil {
+		t.Fatalf("SendMedia: %v", err)
+	}
+	sent := hs.sentBy(botID, dmRoom)
+	var content messageContent
+	json.Unmarshal(sent[len(sent)-1].Content, &content)
+	if content.MsgType != "m.image" || content.Body != "Your cat" || content.Info.MimeType != "image/png" {
+		t.Errorf("media event = %s", sent[len(sent)-1].Content)
+	}
+	id := strings.TrimPrefix(content.URL, "mxc://hs.test/")
+	hs.mu.Lock()
+	uploaded := hs.media[id]
+	hs.mu.Unlock()
+	if string(uploaded) != "PNG DATA" {
+		t.Errorf("uploaded %q to %s", uploaded, content.URL)
+	}
+}
+
+func TestMatrixChannel_AutoJoin(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	hs.rooms["!new:hs.test"] = &fakeRoom{}
+	startMatrixChannel(t, hs, config.MatrixConfig{
+		AutoJoin:  true,
+		AllowFrom: config.FlexibleStringSlice{aliceID},
+	})
+
+	hs.mu.Lock()
+	hs.invites["!spam:hs.test"] = "@mallory:hs.test"
+	hs.invites["!new:hs.test"] = aliceID
+	hs.mu.Unlock()
+
+	waitFor(t, "join", func() bool {
+		hs.mu.Lock()
+		defer hs.mu.Unlock()
+		return len(hs.joined) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.joined) != 1 || hs.joined[0] != "!new:hs.test" {
+		t.Errorf("joined = %v, want only the room alice invited to", hs.joined)
+	}
+}
+
+func TestMatrixChannel_PasswordLoginPersistsSession(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	storePath := t.TempDir()
+	cfg := config.MatrixConfig{Homeserver: hs.srv.URL, UserID: botID, Password: "hunter2"}
+
+	for range 2 {
+		ch, err := NewMatrixChannel(cfg, bus.NewMessageBus(), storePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Start(context.Background()); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		hs.waitSynced(t, "LOGIN1")
+		stopChannel(ch)
+		if ch.client.deviceID != "LOGIN1" {
+			t.Errorf("device = %q, want the device of the first login", ch.client.deviceID)
+		}
+	}
+	if hs.logins != 1 {
+		t.Errorf("logins = %d, want the stored session to be reused", hs.logins)
+	}
+
+	if _, err := NewMatrixChannel(config.MatrixConfig{Homeserver: hs.srv.URL}, bus.NewMessageBus(), storePath); err == nil {
+		t.Error("missing credentials should be rejected")
+	}
+}
+
+func TestMatrixChannel_EncryptedMessages(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	_, msgBus := startMatrixChannel(t, hs, config.MatrixConfig{})
+
+	hs.post(dmRoom, aliceID, "m.room.encrypted",
+		`{"algorithm":"m.megolm.v1.aes-sha2","ciphertext":"AwgAEn...","session_id":"s1"}`)
+	hs.post(dmRoom, aliceID, "m.room.encrypted",
+		`{"algorithm":"m.megolm.v1.aes-sha2","ciphertext":"AwgAEo...","session_id":"s1"}`)
+	hs.post(dmRoom, aliceID, "m.room.message", `{"msgtype":"m.text","body":"plain"}`)
+	if msg := nextInbound(t, msgBus); msg.Content != "plain" {
+		t.Errorf("inbound = %q, want the unencrypted message", msg.Content)
+	}
+
+	// The sender is told once that encrypted messages cannot be read.
+	sent := hs.sentBy(botID, dmRoom)
+	if len(sent) != 1 {
+		t.Fatalf("sent %d events, want one notice: %+v", len(sent), sent)
+	}
+	var content messageContent
+	json.Unmarshal(sent[0].Content, &content)
+	if content.MsgType != "m.notice" || content.Body != encryptedNotice {
+		t.Errorf("notice = %s", sent[0].Content)
+	}
+}
@@ -0,0 +1,237 @@
+package mattermost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v4"
+	// maxDownloadSize bounds files downloaded from the server.
+	maxDownloadSize = 50 << 20
+)
+
+// apiError is an error response of the REST API.
+type apiError struct {
+	Status  int
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("mattermost: %d %s: %s", e.Status, e.ID, e.Message)
+}
+
+type user struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type post struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	ChannelID string         `json:"channel_id"`
+	RootID    string         `json:"root_id"`
+	Message   string         `json:"message"`
+	Type      string         `json:"type"`
+	FileIDs   []string       `json:"file_ids"`
+	Props     map[string]any `json:"props"`
+	Metadata  struct {
+		Files []fileInfo `json:"files"`
+	} `json:"metadata"`
+}
+
+type fileInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+}
+
+// client is a minimal Mattermost REST API client.
+type client struct {
+	serverURL string
+	token     string
+	http      *http.Client
+}
+
+func newClient(serverURL, token string) *client {
+	return &client{
+		serverURL: strings.TrimRight(serverURL, "/"),
+		token:     token,
+		http:      &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// websocketURL returns the URL of the WebSocket event stream.
+func (c *client) websocketURL() string {
+	u := c.serverURL + apiPrefix + "/websocket"
+	if rest, ok := strings.CutPrefix(u, "https://"); ok {
+		return "wss://" + rest
+	}
+	return "ws://" + strings.TrimPrefix(u, "http://")
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	resp, err := c.request(ctx, method, path, reader, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// request sends an authenticated request and turns error statuses into
+// *apiError.
+func (c *client) request(
+	ctx context.Context,
+	method, path string,
+	body io.Reader,
+	contentType string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.serverURL+apiPrefix+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+func (c *client) me(ctx context.Context) (*user, error) {
+	var u user
+	if err := c.do(ctx, http.MethodGet, "/users/me", nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// createPost creates a post, as a reply in the thread of rootID if set, and
+// returns its ID.
+func (c *client) createPost(ctx context.Context, channelID, rootID, message string, fileIDs []string) (string, error) {
+	body := map[string]any{
+		"channel_id": channelID,
+		"root_id":    rootID,
+		"message":    message,
+	}
+	if len(fileIDs) > 0 {
+		body["file_ids"] = fileIDs
+	}
+	var p post
+	err := c.do(ctx, http.MethodPost, "/posts", body, &p)
+	return p.ID, err
+}
+
+func (c *client) patchPost(ctx context.Context, postID, message string) error {
+	return c.do(ctx, http.MethodPut, "/posts/"+url.PathEscape(postID)+"/patch",
+		map[string]any{"message": message}, nil)
+}
+
+func (c *client) addReaction(ctx context.Context, userID, postID, emoji string) error {
+	return c.do(ctx, http.MethodPost, "/reactions", map[string]any{
+		"user_id":    userID,
+		"post_id":    postID,
+		"emoji_name": emoji,
+	}, nil)
+}
+
+func (c *client) removeReaction(ctx context.Context, userID, postID, emoji string) error {
+	path := "/users/" + url.PathEscape(userID) + "/posts/" + url.PathEscape(postID) +
+		"/reactions/" + url.PathEscape(emoji)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func (c *client) fileInfo(ctx context.Context, fileID string) (*fileInfo, error) {
+	var info fileInfo
+	if err := c.do(ctx, http.MethodGet, "/files/"+url.PathEscape(fileID)+"/info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *client) download(ctx context.Context, fileID string) ([]byte, error) {
+	resp, err := c.request(ctx, http.MethodGet, "/files/"+url.PathEscape(fileID), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("mattermost: file %s exceeds %d bytes", fileID, maxDownloadSize)
+	}
+	return data, nil
+}
+
+// uploadFile uploads a file to channelID and returns its file ID, to be
+// attached to a post.
+func (c *client) uploadFile(ctx context.Context, channelID, filename string, data io.Reader) (string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("channel_id", channelID); err != nil {
+		return "", err
+	}
+	part, err := w.CreateFormFile("files", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.request(ctx, http.MethodPost, "/files", &buf, w.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		FileInfos []fileInfo `json:"file_infos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.FileInfos) == 0 {
+		return "", fmt.Errorf("mattermost: upload of %s returned no file", filename)
+	}
+	return result.FileInfos[0].ID, nil
+}
@@ -0,0 +1,471 @@
+// Package mattermost implements a channel for Mattermost servers, receiving
+// posts over the WebSocket event stream and replying through the REST API.
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	// maxMessageLength is the default server limit for a post, in runes.
+	maxMessageLength = 16383
+
+	pingInterval  = 30 * time.Second
+	readTimeout   = 90 * time.Second
+	minRetryDelay = 2 * time.Second
+	maxRetryDelay = time.Minute
+
+	reactionEmoji = "eyes"
+)
+
+// wsEvent is an event of the WebSocket stream.
+type wsEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		ChannelType string `json:"channel_type"`
+		SenderName  string `json:"sender_name"`
+		// Post and Mentions are JSON documents encoded as strings.
+		Post     string `json:"post"`
+		Mentions string `json:"mentions"`
+	} `json:"data"`
+}
+
+type MattermostChannel struct {
+	*channels.BaseChannel
+	config      config.MattermostConfig
+	client      *client
+	botUserID   string
+	botUsername string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewMattermostChannel(cfg config.MattermostConfig, messageBus *bus.MessageBus) (*MattermostChannel, error) {
+	if cfg.ServerURL == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("mattermost server_url and token are required")
+	}
+
+	base := channels.NewBaseChannel("mattermost", cfg, messageBus, cfg.AllowFrom,
+		channels.WithMaxMessageLength(maxMessageLength),
+		channels.WithGroupTrigger(cfg.GroupTrigger),
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &MattermostChannel{
+		BaseChannel: base,
+		config:      cfg,
+		client:      newClient(cfg.ServerURL, cfg.Token),
+	}, nil
+}
+
+func (c *MattermostChannel) Start(ctx context.Context) error {
+	logger.InfoC("mattermost", "Starting Mattermost channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	me, err := c.client.me(c.ctx)
+	if err != nil {
+		c.cancel()
+		return fmt.Errorf("mattermost auth failed: %w", err)
+	}
+	c.botUserID = me.ID
+	c.botUsername = me.Username
+
+	c.done = make(chan struct{})
+	go c.eventLoop()
+
+	c.SetRunning(true)
+	logger.InfoCF("mattermost", "Mattermost channel started", map[string]any{
+		"bot_user_id": c.botUserID,
+		"username":    c.botUsername,
+	})
+	return nil
+}
+
+func (c *MattermostChannel) Stop(ctx context.Context) error {
+	logger.InfoC("mattermost", "Stopping Mattermost channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.done != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("mattermost", "Mattermost channel stopped")
+	return nil
+}
+
+// eventLoop keeps the WebSocket connection open, reconnecting with backoff.
+func (c *MattermostChannel) eventLoop() {
+	defer close(c.done)
+	delay := minRetryDelay
+	for c.ctx.Err() == nil {
+		connected, err := c.listen()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = minRetryDelay
+		}
+		logger.WarnCF("mattermost", "WebSocket disconnected", map[string]any{
+			"error":       err.Error(),
+			"retry_after": delay.String(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// listen connects to the event stream and handles events until the
+// connection fails. connected reports whether the server accepted it.
+func (c *MattermostChannel) listen() (connected bool, err error) {
+	header := http.Header{"Authorization": {"Bearer " + c.config.Token}}
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.client.websocketURL(), header)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(c.ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := conn.WriteJSON(map[string]any{
+		"seq":    1,
+		"action": "authentication_challenge",
+		"data":   map[string]string{"token": c.config.Token},
+	}); err != nil {
+		return false, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go pinger(conn, pingDone)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return connected, err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var ev wsEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			continue
+		}
+		switch ev.Event {
+		case "hello":
+			connected = true
+			logger.DebugC("mattermost", "WebSocket connected")
+		case "posted":
+			c.handlePosted(&ev)
+		}
+	}
+}
+
+func pinger(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *MattermostChannel) handlePosted(ev *wsEvent) {
+	var p post
+	if err := json.Unmarshal([]byte(ev.Data.Post), &p); err != nil {
+		return
+	}
+	if p.UserID == c.botUserID || p.UserID == "" {
+		return
+	}
+	// System messages and posts of other bots and webhooks are not requests.
+	if p.Type != "" || p.Props["from_bot"] == "true" || p.Props["from_webhook"] == "true" {
+		return
+	}
+
+	// check allowlist to avoid downloading attachments for rejected users
+	sender := bus.SenderInfo{
+		Platform:    "mattermost",
+		PlatformID:  p.UserID,
+		CanonicalID: identity.BuildCanonicalID("mattermost", p.UserID),
+		Username:    strings.TrimPrefix(ev.Data.SenderName, "@"),
+	}
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("mattermost", "Message rejected by allowlist", map[string]any{
+			"user_id": p.UserID,
+		})
+		return
+	}
+
+	direct := ev.Data.ChannelType == "D"
+	content, named := channels.StripUsernameMention(p.Message, c.botUsername)
+
+	// In channels every conversation is a thread: a new post starts one
+	// and the session follows its root.
+	rootID := p.RootID
+	var peer bus.Peer
+	if direct {
+		peer = bus.Peer{Kind: "direct", ID: p.UserID}
+	} else {
+		var mentions []string
+		json.Unmarshal([]byte(ev.Data.Mentions), &mentions)
+		mentioned := named || slices.Contains(mentions, c.botUserID)
+		respond, cleaned := c.ShouldRespondInGroup(mentioned, content)
+		if !respond {
+			return
+		}
+		content = cleaned
+		if rootID == "" {
+			rootID = p.ID
+		}
+		peer = bus.Peer{Kind: "channel", ID: p.ChannelID + "/" + rootID}
+	}
+
+	chatID := p.ChannelID
+	if rootID != "" {
+		chatID = p.ChannelID + "/" + rootID
+	}
+
+	var mediaRefs []string
+	scope := channels.BuildMediaScope("mattermost", chatID, p.ID)
+	for _, fileID := range p.FileIDs {
+		info := c.lookupFile(&p, fileID)
+		if info == nil {
+			continue
+		}
+		if ref := c.storeFile(info, scope); ref != "" {
+			mediaRefs = append(mediaRefs, ref)
+		}
+		content = channels.AppendMediaLabel(content, info.MimeType, info.Name)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "mattermost",
+		"channel_id": p.ChannelID,
+		"post_id":    p.ID,
+		"root_id":    rootID,
+	}
+	if !direct {
+		// Bindings to the channel still apply to its threads.
+		metadata["parent_peer_kind"] = "channel"
+		metadata["parent_peer_id"] = p.ChannelID
+	}
+
+	logger.DebugCF("mattermost", "Received message", map[string]any{
+		"sender_id": p.UserID,
+		"chat_id":   chatID,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(c.ctx, peer, p.ID, p.UserID, chatID, content, mediaRefs, metadata, sender)
+}
+
+// lookupFile returns the metadata of an attached file, from the post if
+// the server included it.
+func (c *MattermostChannel) lookupFile(p *post, fileID string) *fileInfo {
+	for i := range p.Metadata.Files {
+		if p.Metadata.Files[i].ID == fileID {
+			return &p.Metadata.Files[i]
+		}
+	}
+	info, err := c.client.fileInfo(c.ctx, fileID)
+	if err != nil {
+		logger.WarnCF("mattermost", "Failed to get file info", map[string]any{
+			"file_id": fileID,
+			"error":   err.Error(),
+		})
+		return nil
+	}
+	return info
+}
+
+// storeFile downloads an attachment and registers it with the media store.
+// It returns "" if that is not possible.
+func (c *MattermostChannel) storeFile(info *fileInfo, scope string) string {
+	if c.GetMediaStore() == nil {
+		return ""
+	}
+	data, err := c.client.download(c.ctx, info.ID)
+	if err != nil {
+		logger.WarnCF("mattermost", "Failed to download file", map[string]any{
+			"file_id": info.ID,
+			"error":   err.Error(),
+		})
+		return ""
+	}
+	return c.StoreMedia(data, info.Name, info.MimeType, scope)
+}
+
+func (c *MattermostChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	channelID, rootID := parseChatID(msg.ChatID)
+	if channelID == "" {
+		return fmt.Errorf("invalid mattermost chat ID %q: %w", msg.ChatID, channels.ErrSendFailed)
+	}
+	if msg.Content == "" {
+		return nil
+	}
+
+	if _, err := c.client.createPost(ctx, channelID, rootID, msg.Content, nil); err != nil {
+		return sendError(err)
+	}
+
+	logger.DebugCF("mattermost", "Message sent", map[string]any{
+		"channel_id": channelID,
+		"root_id":    rootID,
+	})
+	return nil
+}
+
+// SendMedia implements the channels.MediaSender interface.
+func (c *MattermostChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	channelID, rootID := parseChatID(msg.ChatID)
+	if channelID == "" {
+		return fmt.Errorf("invalid mattermost chat ID %q: %w", msg.ChatID, channels.ErrSendFailed)
+	}
+
+	store := c.GetMediaStore()
+	if store == nil {
+		return fmt.Errorf("no media store available: %w", channels.ErrSendFailed)
+	}
+
+	for _, part := range msg.Parts {
+		localPath, err := store.Resolve(part.Ref)
+		if err != nil {
+			logger.ErrorCF("mattermost", "Failed to resolve media ref", map[string]any{
+				"ref":   part.Ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		file, err := os.Open(localPath)
+		if err != nil {
+			logger.ErrorCF("mattermost", "Failed to open media file", map[string]any{"error": err.Error()})
+			continue
+		}
+
+		filename := part.Filename
+		if filename == "" {
+			filename = filepath.Base(localPath)
+		}
+		fileID, err := c.client.uploadFile(ctx, channelID, filename, file)
+		file.Close()
+		if err != nil {
+			logger.ErrorCF("mattermost", "Failed to upload media", map[string]any{
+				"filename": filename,
+				"error":    err.Error(),
+			})
+			return sendError(err)
+		}
+
+		if _, err := c.client.createPost(ctx, channelID, rootID, part.Caption, []string{fileID}); err != nil {
+			return sendError(err)
+		}
+	}
+	return nil
+}
+
+// EditMessage implements channels.MessageEditor.
+func (c *MattermostChannel) EditMessage(ctx context.Context, chatID string, messageID string, content string) error {
+	if err := c.client.patchPost(ctx, messageID, content); err != nil {
+		return sendError(err)
+	}
+	return nil
+}
+
+// SendPlaceholder implements channels.PlaceholderCapable. The placeholder
+// is posted in the thread the reply will go to and later edited into it.
+func (c *MattermostChannel) SendPlaceholder(ctx context.Context, chatID string) (string, error) {
+	if !c.config.Placeholder.Enabled {
+		return "", nil
+	}
+
+	channelID, rootID := parseChatID(chatID)
+	if channelID == "" {
+		return "", fmt.Errorf("invalid mattermost chat ID %q", chatID)
+	}
+
+	text := c.config.Placeholder.Text
+	if text == "" {
+		text = "Thinking... 💭"
+	}
+	return c.client.createPost(ctx, channelID, rootID, text, nil)
+}
+
+// ReactToMessage implements channels.ReactionCapable.
+// It adds an "eyes" (👀) reaction to the inbound post and returns an undo
+// function that removes the reaction.
+func (c *MattermostChannel) ReactToMessage(ctx context.Context, chatID, messageID string) (func(), error) {
+	if err := c.client.addReaction(ctx, c.botUserID, messageID, reactionEmoji); err != nil {
+		return func() {}, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		c.client.removeReaction(ctx, c.botUserID, messageID, reactionEmoji)
+	}, nil
+}
+
+func sendError(err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return channels.ClassifySendError(apiErr.Status, err)
+	}
+	return channels.ClassifyNetError(err)
+}
+
+// parseChatID splits a chat ID of the form "channelID" or
+// "channelID/rootID".
+func parseChatID(chatID string) (channelID, rootID string) {
+	channelID, rootID, _ = strings.Cut(chatID, "/")
+	return channelID, rootID
+}
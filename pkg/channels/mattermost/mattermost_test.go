@@ -0,0 +1,379 @@
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+const testToken = "bot-token"
+
+type request struct {
+	method string
+	path   string
+	body   map[string]any
+	form   map[string]string
+}
+
+// fakeServer implements the parts of the Mattermost API the channel uses.
+type fakeServer struct {
+	t        *testing.T
+	srv      *httptest.Server
+	requests chan request
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	authed chan struct{}
+	nextID int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	s := &fakeServer{t: t, requests: make(chan request, 100), authed: make(chan struct{}, 10)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id": "botid", "username": "pico"})
+	})
+	mux.HandleFunc("GET /api/v4/websocket", s.websocket)
+	mux.HandleFunc("POST /api/v4/posts", func(w http.ResponseWriter, r *http.Request) {
+		body := s.record(r)
+		if body["channel_id"] == "forbidden" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"id": "api.context.permissions", "message": "no"})
+			return
+		}
+		s.mu.Lock()
+		s.nextID++
+		id := "post" + string(rune('0'+s.nextID))
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	})
+	mux.HandleFunc("PUT /api/v4/posts/{id}/patch", func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("POST /api/v4/reactions", func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("DELETE /api/v4/users/{user}/posts/{post}/reactions/{emoji}",
+		func(w http.ResponseWriter, r *http.Request) {
+			s.record(r)
+			w.Write([]byte("{}"))
+		})
+	mux.HandleFunc("GET /api/v4/files/{id}/info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id": r.PathValue("id"), "name": "notes.txt", "mime_type": "text/plain"})
+	})
+	mux.HandleFunc("GET /api/v4/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("contents of " + r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /api/v4/files", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("files")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		s.requests <- request{method: r.Method, path: r.URL.Path, form: map[string]string{
+			"channel_id": r.FormValue("channel_id"),
+			"filename":   header.Filename,
+			"data":       string(data),
+		}}
+		json.NewEncoder(w).Encode(map[string]any{"file_infos": []map[string]string{{"id": "upload1"}}})
+	})
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+		s.srv.Close()
+	})
+	return s
+}
+
+func (s *fakeServer) record(r *http.Request) map[string]any {
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+	s.requests <- request{method: r.Method, path: r.URL.Path, body: body}
+	return body
+}
+
+func (s *fakeServer) websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	var challenge struct {
+		Action string            `json:"action"`
+		Data   map[string]string `json:"data"`
+	}
+	if err := conn.ReadJSON(&challenge); err != nil ||
+		challenge.Action != "authentication_challenge" || challenge.Data["token"] != testToken {
+		conn.Close()
+		return
+	}
+	conn.WriteJSON(map[string]any{"event": "hello", "data": map[string]any{}})
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	s.authed <- struct{}{}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// post sends a "posted" event over the WebSocket.
+func (s *fakeServer) post(channelType string, p map[string]any, mentions ...string) {
+	s.t.Helper()
+	data, _ := json.Marshal(p)
+	mentionData, _ := json.Marshal(mentions)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.conn.WriteJSON(map[string]any{
+		"event": "posted",
+		"data": map[string]any{
+			"channel_type": channelType,
+			"sender_name":  "@alice",
+			"post":         string(data),
+			"mentions":     string(mentionData),
+		},
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *fakeServer) expect(method, path string) request {
+	s.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-s.requests:
+			if r.method == method && r.path == path {
+				return r
+			}
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %s %s", method, path)
+		}
+	}
+}
+
+func startChannel(t *testing.T, s *fakeServer, cfg config.MattermostConfig) (*MattermostChannel, *bus.MessageBus) {
+	t.Helper()
+	cfg.ServerURL = s.srv.URL
+	cfg.Token = testToken
+	msgBus := bus.NewMessageBus()
+	ch, err := NewMattermostChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.SetMediaStore(media.NewFileMediaStore())
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch.Stop(ctx)
+	})
+	select {
+	case <-s.authed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WebSocket was not authenticated")
+	}
+	return ch, msgBus
+}
+
+func nextInbound(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestMattermostChannel_ThreadsAndMentions(t *testing.T) {
+	s := newFakeServer(t)
+	_, msgBus := startChannel(t, s, config.MattermostConfig{
+		AllowFrom:    config.FlexibleStringSlice{"alice"},
+		GroupTrigger: config.GroupTriggerConfig{MentionOnly: true},
+	})
+
+	// Ignored: no mention, our own post, a system message and another bot.
+	s.post("O", map[string]any{"id": "p1", "user_id": "u1", "channel_id": "town", "message": "hello all"})
+	s.post("O", map[string]any{"id": "p2", "user_id": "botid", "channel_id": "town", "message": "@pico echo"})
+	s.post("O", map[string]any{"id": "p3", "user_id": "u1", "channel_id": "town", "type": "system_join_channel"})
+	s.post("O", map[string]any{
+		"id": "p4", "user_id": "u2", "channel_id": "town", "message": "@pico hi",
+		"props": map[string]any{"from_bot": "true"},
+	})
+
+	s.post("O", map[string]any{"id": "p5", "user_id": "u1", "channel_id": "town", "message": "@pico: status?"},
+		"botid")
+	msg := nextInbound(t, msgBus)
+	if msg.ChatID != "town/p5" || msg.Content != "status?" || msg.MessageID != "p5" {
+		t.Errorf("new thread = %+v", msg)
+	}
+	if msg.Peer.Kind != "channel" || msg.Peer.ID != "town/p5" || msg.Metadata["parent_peer_id"] != "town" {
+		t.Errorf("peer = %+v, metadata = %v", msg.Peer, msg.Metadata)
+	}
+	if msg.SenderID != "mattermost:u1" {
+		t.Errorf("sender = %q", msg.SenderID)
+	}
+
+	s.post("O", map[string]any{
+		"id": "p6", "user_id": "u1", "channel_id": "town", "root_id": "p5", "message": "and @PICO, more",
+	})
+	msg = nextInbound(t, msgBus)
+	if msg.ChatID != "town/p5" || msg.Peer.ID != "town/p5" || msg.Content != "and , more" {
+		t.Errorf("thread reply = %+v", msg)
+	}
+
+	s.post("D", map[string]any{"id": "p7", "user_id": "u1", "channel_id": "dm1", "message": "no mention needed"})
+	msg = nextInbound(t, msgBus)
+	if msg.ChatID != "dm1" || msg.Peer.Kind != "direct" || msg.Peer.ID != "u1" {
+		t.Errorf("direct message = %+v", msg)
+	}
+	if _, ok := msg.Metadata["parent_peer_id"]; ok {
+		t.Error("direct messages have no parent peer")
+	}
+}
+
+func TestMattermostChannel_AllowList(t *testing.T) {
+	s := newFakeServer(t)
+	_, msgBus := startChannel(t, s, config.MattermostConfig{AllowFrom: config.FlexibleStringSlice{"carol"}})
+
+	s.post("D", map[string]any{
+		"id": "p1", "user_id": "u1", "channel_id": "dm1", "message": "hi", "file_ids": []string{"f1"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if msg, ok := msgBus.ConsumeInbound(ctx); ok {
+		t.Errorf("disallowed sender got through: %+v", msg)
+	}
+	select {
+	case r := <-s.requests:
+		t.Errorf("unexpected request %s %s", r.method, r.path)
+	default:
+	}
+}
+
+func TestMattermostChannel_SendEditReact(t *testing.T) {
+	s := newFakeServer(t)
+	ch, _ := startChannel(t, s, config.MattermostConfig{
+		Placeholder: config.PlaceholderConfig{Enabled: true, Text: "working"},
+	})
+	ctx := context.Background()
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "town/p5", Content: "reply"}); err != nil {
+		t.Fatal(err)
+	}
+	r := s.expect("POST", "/api/v4/posts")
+	if r.body["channel_id"] != "town" || r.body["root_id"] != "p5" || r.body["message"] != "reply" {
+		t.Errorf("post = %v", r.body)
+	}
+
+	id, err := ch.SendPlaceholder(ctx, "town/p5")
+	if err != nil || id == "" {
+		t.Fatalf("SendPlaceholder = %q, %v", id, err)
+	}
+	if r = s.expect("POST", "/api/v4/posts"); r.body["message"] != "working" || r.body["root_id"] != "p5" {
+		t.Errorf("placeholder = %v", r.body)
+	}
+	if err := ch.EditMessage(ctx, "town/p5", id, "final"); err != nil {
+		t.Fatal(err)
+	}
+	if r = s.expect("PUT", "/api/v4/posts/"+id+"/patch"); r.body["message"] != "final" {
+		t.Errorf("patch = %v", r.body)
+	}
+
+	undo, err := ch.ReactToMessage(ctx, "town/p5", "p5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r = s.expect("POST", "/api/v4/reactions"); r.body["post_id"] != "p5" || r.body["emoji_name"] != "eyes" {
+		t.Errorf("reaction = %v", r.body)
+	}
+	undo()
+	s.expect("DELETE", "/api/v4/users/botid/posts/p5/reactions/eyes")
+
+	err = ch.Send(ctx, bus.OutboundMessage{ChatID: "forbidden", Content: "x"})
+	if !errors.Is(err, channels.ErrSendFailed) {
+		t.Errorf("Send to a forbidden channel = %v, want ErrSendFailed", err)
+	}
+}
+
+func TestMattermostChannel_Files(t *testing.T) {
+	s := newFakeServer(t)
+	ch, msgBus := startChannel(t, s, config.MattermostConfig{})
+
+	s.post("D", map[string]any{
+		"id": "p1", "user_id": "u1", "channel_id": "dm1", "message": "see attached",
+		"file_ids": []string{"f1", "f2"},
+		"metadata": map[string]any{"files": []map[string]string{{"id": "f1", "name": "cat.png", "mime_type": "image/png"}}},
+	})
+	msg := nextInbound(t, msgBus)
+	if msg.Content != "see attached\n[image: cat.png]\n[file: notes.txt]" || len(msg.Media) != 2 {
+		t.Fatalf("inbound = %+v", msg)
+	}
+	path, meta, err := ch.GetMediaStore().ResolveWithMeta(msg.Media[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "contents of f1" || meta.ContentType != "image/png" {
+		t.Errorf("stored file = %q, %+v", data, meta)
+	}
+
+	local := filepath.Join(t.TempDir(), "report.pdf")
+	os.WriteFile(local, []byte("%PDF"), 0o600)
+	ref, err := ch.GetMediaStore().Store(local, media.MediaMeta{Filename: "report.pdf"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ch.SendMedia(context.Background(), bus.OutboundMediaMessage{
+		ChatID: "town/p5",
+		Parts:  []bus.MediaPart{{Ref: ref, Filename: "report.pdf", Caption: "the report"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.expect("POST", "/api/v4/files")
+	if r.form["channel_id"] != "town" || r.form["filename"] != "report.pdf" || r.form["data"] != "%PDF" {
+		t.Errorf("upload = %v", r.form)
+	}
+	r = s.expect("POST", "/api/v4/posts")
+	files, _ := r.body["file_ids"].([]any)
+	if r.body["root_id"] != "p5" || r.body["message"] != "the report" || len(files) != 1 || files[0] != "upload1" {
+		t.Errorf("post = %v", r.body)
+	}
+}
@@ -2,8 +2,17 @@ package channels
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 // MediaSender is an optional interface for channels that can send
@@ -13,3 +22,56 @@ import (
 type MediaSender interface {
 	SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error
 }
+
+// MediaKind maps a MIME type to the label an attachment gets in message
+// content: "image", "audio", "video" or "file".
+func MediaKind(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(contentType, "video/"):
+		return "video"
+	}
+	return "file"
+}
+
+// AppendMediaLabel adds the "[kind: name]" label of an attachment to
+// content, on a line of its own.
+func AppendMediaLabel(content, contentType, name string) string {
+	label := fmt.Sprintf("[%s: %s]", MediaKind(contentType), name)
+	if content == "" {
+		return label
+	}
+	return content + "\n" + label
+}
+
+// StoreMedia saves a received attachment to the shared picoclaw_media
+// directory and registers it with the media store under scope. It returns
+// "" if there is no media store or the file cannot be saved.
+func (c *BaseChannel) StoreMedia(data []byte, filename, contentType, scope string) string {
+	store := c.GetMediaStore()
+	if store == nil {
+		return ""
+	}
+	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(mediaDir, 0o700); err != nil {
+		return ""
+	}
+	localPath := filepath.Join(mediaDir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(filename))
+	if err := os.WriteFile(localPath, data, 0o600); err != nil {
+		logger.WarnCF(c.name, "Failed to save attachment", map[string]any{"error": err.Error()})
+		return ""
+	}
+	ref, err := store.Store(localPath, media.MediaMeta{
+		Filename:    filename,
+		ContentType: contentType,
+		Source:      c.name,
+	}, scope)
+	if err != nil {
+		os.Remove(localPath)
+		return ""
+	}
+	return ref
+}
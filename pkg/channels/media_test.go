@@ -0,0 +1,48 @@
+package channels
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+func TestAppendMediaLabel(t *testing.T) {
+	if got := AppendMediaLabel("", "image/png", "cat.png"); got != "[image: cat.png]" {
+		t.Errorf("empty content: %q", got)
+	}
+	if got := AppendMediaLabel("look", "application/pdf", "a.pdf"); got != "look\n[file: a.pdf]" {
+		t.Errorf("with content: %q", got)
+	}
+	for contentType, want := range map[string]string{
+		"audio/ogg": "audio",
+		"video/mp4": "video",
+		"":          "file",
+	} {
+		if got := MediaKind(contentType); got != want {
+			t.Errorf("MediaKind(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestBaseChannelStoreMedia(t *testing.T) {
+	ch := NewBaseChannel("test", nil, bus.NewMessageBus(), nil)
+	if ref := ch.StoreMedia([]byte("x"), "a.txt", "text/plain", "scope"); ref != "" {
+		t.Errorf("without a media store, ref = %q", ref)
+	}
+
+	ch.SetMediaStore(media.NewFileMediaStore())
+	ref := ch.StoreMedia([]byte("hello"), "../notes.txt", "text/plain", "scope")
+	path, meta, err := ch.GetMediaStore().ResolveWithMeta(ref)
+	if err != nil {
+		t.Fatalf("ResolveWithMeta(%q): %v", ref, err)
+	}
+	defer os.Remove(path)
+	if meta.Source != "test" || meta.Filename != "../notes.txt" || meta.ContentType != "text/plain" {
+		t.Errorf("meta = %+v", meta)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "hello" {
+		t.Errorf("stored data = %q", data)
+	}
+}
@@ -0,0 +1,65 @@
+package channels
+
+import "strings"
+
+// StripUsernameMention removes "@username" mentions, as used by Mattermost
+// and Rocket.Chat, from text and reports whether there were any. Matching is
+// case-insensitive and "@username" must not continue into a longer name.
+func StripUsernameMention(text, username string) (string, bool) {
+	if username == "" {
+		return strings.TrimSpace(text), false
+	}
+	mention := "@" + username
+	var b strings.Builder
+	found := false
+	rest := text
+	for {
+		i := indexFold(rest, mention)
+		if i < 0 {
+			break
+		}
+		end := i + len(mention)
+		if continuesUsername(rest[end:]) {
+			b.WriteString(rest[:end])
+			rest = rest[end:]
+			continue
+		}
+		found = true
+		b.WriteString(rest[:i])
+		rest = rest[end:]
+		if b.Len() == 0 || strings.HasSuffix(b.String(), " ") {
+			rest = strings.TrimPrefix(rest, " ")
+		}
+	}
+	b.WriteString(rest)
+	if !found {
+		return strings.TrimSpace(text), false
+	}
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(b.String()), ":,")), true
+}
+
+// indexFold returns the byte offset in s of the first case-insensitive
+// match of substr, or -1. Unlike searching strings.ToLower(s), the offset
+// is valid in s even where lowercasing changes a character's length.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// continuesUsername reports whether s, the text after a mention, continues
+// the username. A trailing period ends a sentence rather than the name.
+func continuesUsername(s string) bool {
+	if s == "" || !isUsernameChar(s[0]) {
+		return false
+	}
+	return s[0] != '.' || len(s) > 1 && isUsernameChar(s[1])
+}
+
+func isUsernameChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		ch == '.' || ch == '-' || ch == '_'
+}
@@ -0,0 +1,29 @@
+package channels
+
+import "testing"
+
+func TestStripUsernameMention(t *testing.T) {
+	tests := []struct {
+		text    string
+		content string
+		found   bool
+	}{
+		{"@pico hi", "hi", true},
+		{"@Pico: status", "status", true},
+		{"ask @pico about it", "ask about it", true},
+		{"thanks @pico.", "thanks .", true},
+		{"@picoclaw hi", "@picoclaw hi", false},
+		{"@pico.bot hi", "@pico.bot hi", false},
+		{"nothing", "nothing", false},
+		// Lowercasing these changes their byte length.
+		{"ȺȺȺȺ @pico", "ȺȺȺȺ", true},
+		{"İİİİ@pico hi", "İİİİ hi", true},
+		{"İİİİ @PICO hi", "İİİİ hi", true},
+	}
+	for _, tt := range tests {
+		content, found := StripUsernameMention(tt.text, "pico")
+		if content != tt.content || found != tt.found {
+			t.Errorf("StripUsernameMention(%q) = %q, %v; want %q, %v", tt.text, content, found, tt.content, tt.found)
+		}
+	}
+}
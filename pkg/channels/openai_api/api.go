@@ -0,0 +1,167 @@
+package openaiapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// chatRequest is the subset of a Chat Completions request the channel
+// understands. Sampling parameters are ignored: they belong to the agent's
+// model configuration.
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	User     string        `json:"user"`
+}
+
+type chatMessage struct {
+	Role string `json:"role"`
+	// Content is either a string or an array of content parts.
+	Content json.RawMessage `json:"content"`
+}
+
+type contentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+type chatCompletion struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+}
+
+type completionChoice struct {
+	Index        int              `json:"index"`
+	Message      *completionDelta `json:"message,omitempty"`
+	Delta        *completionDelta `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type completionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// image is an inline image taken from a data URL content part.
+type image struct {
+	mimeType string
+	data     []byte
+}
+
+// parseContent returns the text and inline images of a message's content.
+// Remote image URLs are kept as text since the agent cannot fetch them as
+// attachments.
+func parseContent(raw json.RawMessage) (string, []image, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil, nil
+	}
+	var parts []contentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, fmt.Errorf("content must be a string or an array of content parts")
+	}
+	var texts []string
+	var images []image
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			texts = append(texts, p.Text)
+		case "image_url":
+			if p.ImageURL == nil || p.ImageURL.URL == "" {
+				continue
+			}
+			if img, ok := decodeDataURL(p.ImageURL.URL); ok {
+				images = append(images, img)
+			} else {
+				texts = append(texts, "[image: "+p.ImageURL.URL+"]")
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+// decodeDataURL decodes a base64 "data:<mime>;base64,<data>" URL.
+func decodeDataURL(u string) (image, bool) {
+	rest, ok := strings.CutPrefix(u, "data:")
+	if !ok {
+		return image{}, false
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return image{}, false
+	}
+	mimeType, ok := strings.CutSuffix(header, ";base64")
+	if !ok {
+		return image{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return image{}, false
+	}
+	return image{mimeType: mimeType, data: data}, true
+}
+
+// buildPrompt turns the request messages into the content of one inbound
+// message. Stateful requests (with a user) only forward the last user
+// message because the agent keeps the conversation history itself;
+// stateless requests fold the earlier turns into the prompt.
+func buildPrompt(messages []chatMessage, stateful bool) (string, []image, error) {
+	last := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return "", nil, fmt.Errorf("messages must contain a user message")
+	}
+	content, images, err := parseContent(messages[last].Content)
+	if err != nil {
+		return "", nil, err
+	}
+	if stateful || last == 0 {
+		return content, images, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Conversation so far:\n")
+	for _, m := range messages[:last] {
+		text, _, err := parseContent(m.Content)
+		if err != nil {
+			return "", nil, err
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", m.Role, text)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(content)
+	return sb.String(), images, nil
+}
@@ -0,0 +1,459 @@
+package openaiapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/routing"
+)
+
+const (
+	channelName = "openai_api"
+	// maxRequestSize bounds request bodies, which may carry inline images.
+	maxRequestSize = 20 << 20
+	// keepAliveInterval is how often an idle stream sends an SSE comment so
+	// that proxies keep the connection open while tools run.
+	keepAliveInterval = 15 * time.Second
+)
+
+// pendingRequest is an HTTP request waiting for the agent's reply.
+type pendingRequest struct {
+	// partial receives the reply text streamed so far. Updates are dropped
+	// when the buffer is full; the next one carries the full text anyway.
+	partial chan string
+	// reply receives the final reply of the turn.
+	reply chan string
+
+	mu sync.Mutex
+	// sent collects the other messages of the turn, such as those of the
+	// message tool. They answer the request if the final reply is empty.
+	sent []string
+}
+
+// OpenAIAPIChannel serves an OpenAI-compatible Chat Completions API. Each
+// request is published on the bus like a message from any other channel and
+// answered with the agent's reply.
+type OpenAIAPIChannel struct {
+	*channels.BaseChannel
+	config         config.OpenAIAPIConfig
+	agentIDs       []string
+	defaultAgentID string
+	created        int64
+	pending        sync.Map // chatID → *pendingRequest
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+// NewOpenAIAPIChannel creates the channel. Configured agent IDs become the
+// API's model names.
+func NewOpenAIAPIChannel(cfg *config.Config, messageBus *bus.MessageBus) (*OpenAIAPIChannel, error) {
+	apiCfg := cfg.Channels.OpenAIAPI
+	if apiCfg.Token == "" && len(apiCfg.Users) == 0 {
+		return nil, fmt.Errorf("openai_api token or users are required")
+	}
+	for _, u := range apiCfg.Users {
+		if strings.TrimSpace(u.ID) == "" || u.Token == "" {
+			return nil, fmt.Errorf("openai_api users need an id and a token")
+		}
+		if u.Token == apiCfg.Token {
+			return nil, fmt.Errorf("openai_api user %q must not use the shared token", u.ID)
+		}
+	}
+
+	agentIDs := make([]string, 0, len(cfg.Agents.List))
+	for _, a := range cfg.Agents.List {
+		agentIDs = append(agentIDs, routing.NormalizeAgentID(a.ID))
+	}
+	if len(agentIDs) == 0 {
+		agentIDs = append(agentIDs, routing.DefaultAgentID)
+	}
+	defaultRoute := routing.NewRouteResolver(cfg).ResolveRoute(routing.RouteInput{Channel: channelName})
+
+	return &OpenAIAPIChannel{
+		BaseChannel:    channels.NewBaseChannel(channelName, apiCfg, messageBus, nil),
+		config:         apiCfg,
+		agentIDs:       agentIDs,
+		defaultAgentID: defaultRoute.AgentID,
+		created:        time.Now().Unix(),
+	}, nil
+}
+
+// Start implements Channel.
+func (c *OpenAIAPIChannel) Start(ctx context.Context) error {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.SetRunning(true)
+	logger.InfoCF("openai_api", "OpenAI-compatible API started", map[string]any{
+		"models": c.agentIDs,
+	})
+	return nil
+}
+
+// Stop implements Channel. Waiting requests are answered with an error.
+func (c *OpenAIAPIChannel) Stop(ctx context.Context) error {
+	c.SetRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+	}
+	logger.InfoC("openai_api", "OpenAI-compatible API stopped")
+	return nil
+}
+
+// WebhookPath implements channels.WebhookHandler.
+func (c *OpenAIAPIChannel) WebhookPath() string { return "/v1/" }
+
+// ServeHTTP implements http.Handler for the shared HTTP server.
+func (c *OpenAIAPIChannel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := c.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid API key")
+		return
+	}
+	if !c.IsRunning() {
+		writeError(w, http.StatusServiceUnavailable, "server_error", "", "Channel is not running")
+		return
+	}
+
+	switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1"), "/") {
+	case "/models":
+		c.handleModels(w, r)
+	case "/chat/completions":
+		c.handleChatCompletions(w, r, userID)
+	default:
+		writeError(w, http.StatusNotFound, "invalid_request_error", "", "Unknown endpoint "+r.URL.Path)
+	}
+}
+
+// authenticate checks the bearer token in constant time. It returns the ID
+// of the user the token belongs to, or "" for the shared token.
+func (c *OpenAIAPIChannel) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for _, u := range c.config.Users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(u.Token)) == 1 {
+			return strings.TrimSpace(u.ID), true
+		}
+	}
+	if c.config.Token == "" {
+		return "", false
+	}
+	return "", subtle.ConstantTimeCompare([]byte(token), []byte(c.config.Token)) == 1
+}
+
+// checkUser validates the request's user field against the caller. A user
+// token may only name its own user; the shared token may not name any
+// configured user, whose sessions are reserved for them.
+func (c *OpenAIAPIChannel) checkUser(userID, user string) error {
+	if userID != "" {
+		if user != "" && !strings.EqualFold(user, userID) {
+			return fmt.Errorf("user %q does not match the API token", user)
+		}
+		return nil
+	}
+	for _, u := range c.config.Users {
+		if strings.EqualFold(user, strings.TrimSpace(u.ID)) {
+			return fmt.Errorf("user %q requires their own API token", user)
+		}
+	}
+	return nil
+}
+
+func (c *OpenAIAPIChannel) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+	models := make([]model, 0, len(c.agentIDs))
+	for _, id := range c.agentIDs {
+		models = append(models, model{ID: id, Object: "model", Created: c.created, OwnedBy: "picoclaw"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+// handleChatCompletions answers a completion request. userID is the user
+// the API token belongs to, or "" for the shared token.
+func (c *OpenAIAPIChannel) handleChatCompletions(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+
+	var req chatRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", "Invalid JSON body: "+err.Error())
+		return
+	}
+	agentID, ok := c.resolveModel(req.Model)
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+			fmt.Sprintf("The model %q does not exist", req.Model))
+		return
+	}
+	user := strings.TrimSpace(req.User)
+	if err := c.checkUser(userID, user); err != nil {
+		writeError(w, http.StatusForbidden, "permission_error", "", err.Error())
+		return
+	}
+	content, images, err := buildPrompt(req.Messages, user != "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+
+	// With a user token the sender is its user; with the shared token the
+	// user field is only a label.
+	name := userID
+	if name == "" {
+		name = user
+	}
+	senderID := name
+	if senderID == "" {
+		senderID = "anonymous"
+	}
+	sender := bus.SenderInfo{
+		Platform:    channelName,
+		PlatformID:  senderID,
+		CanonicalID: identity.BuildCanonicalID(channelName, senderID),
+		Username:    name,
+	}
+
+	requestID := "chatcmpl-" + uuid.New().String()
+	// Requests without a user run in a throwaway session; their history
+	// travels in the messages instead.
+	sessionKey := fmt.Sprintf("agent:%s:%s:request:%s", agentID, channelName, requestID)
+	metadata := map[string]string{bus.MetadataEphemeral: "true"}
+	if user != "" {
+		sessionKey = fmt.Sprintf("agent:%s:%s:direct:%s", agentID, channelName, strings.ToLower(name))
+		metadata = nil
+	}
+
+	timeout := time.Duration(c.config.RequestTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 300 * time.Second
+	}
+	// The shared server's write timeout is shorter than an agent turn.
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 30*time.Second))
+
+	pending := &pendingRequest{partial: make(chan string, 16), reply: make(chan string, 1)}
+	c.pending.Store(requestID, pending)
+	defer c.pending.Delete(requestID)
+
+	var mediaRefs []string
+	scope := channels.BuildMediaScope(channelName, requestID, requestID)
+	for _, img := range images {
+		if ref := c.storeImage(img, scope); ref != "" {
+			mediaRefs = append(mediaRefs, ref)
+		}
+	}
+
+	c.HandleMessageInSession(r.Context(), sessionKey, bus.Peer{Kind: "direct", ID: senderID},
+		requestID, senderID, requestID, content, mediaRefs, metadata, sender)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if req.Stream {
+		c.streamReply(ctx, w, requestID, agentID, pending)
+		return
+	}
+
+	select {
+	case reply := <-pending.reply:
+		stop := "stop"
+		writeJSON(w, http.StatusOK, chatCompletion{
+			ID:      requestID,
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   agentID,
+			Choices: []completionChoice{{
+				Message:      &completionDelta{Role: "assistant", Content: reply},
+				FinishReason: &stop,
+			}},
+		})
+	case <-ctx.Done():
+		writeError(w, http.StatusGatewayTimeout, "server_error", "timeout", "The agent did not reply in time")
+	case <-c.ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, "server_error", "", "Channel is shutting down")
+	}
+}
+
+// streamReply writes the reply as server-sent events. Partial updates carry
+// the full text so far; only the new suffix is sent. If a retried LLM call
+// rewrites earlier text, the rewritten reply is sent again in full.
+func (c *OpenAIAPIChannel) streamReply(
+	ctx context.Context,
+	w http.ResponseWriter,
+	requestID, agentID string,
+	pending *pendingRequest,
+) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	created := time.Now().Unix()
+	writeChunk := func(delta completionDelta, finishReason *string) {
+		data, _ := json.Marshal(chatCompletion{
+			ID:      requestID,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   agentID,
+			Choices: []completionChoice{{Delta: &delta, FinishReason: finishReason}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		rc.Flush()
+	}
+
+	sent := ""
+	emit := func(text string) {
+		delta, ok := strings.CutPrefix(text, sent)
+		if !ok {
+			delta = text
+		}
+		if delta != "" {
+			writeChunk(completionDelta{Content: delta}, nil)
+		}
+		sent = text
+	}
+
+	writeChunk(completionDelta{Role: "assistant"}, nil)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case text := <-pending.partial:
+			emit(text)
+		case reply := <-pending.reply:
+			emit(reply)
+			stop := "stop"
+			writeChunk(completionDelta{}, &stop)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			rc.Flush()
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			rc.Flush()
+		case <-ctx.Done():
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveModel maps a model name to an agent ID. An empty model selects the
+// agent that routing picks for this channel.
+func (c *OpenAIAPIChannel) resolveModel(name string) (string, bool) {
+	if strings.TrimSpace(name) == "" {
+		return c.defaultAgentID, true
+	}
+	id := routing.NormalizeAgentID(name)
+	for _, agentID := range c.agentIDs {
+		if agentID == id {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Send implements Channel. Messages sent during a turn are kept until it
+// ends and only answer the request if the turn has no final reply.
+func (c *OpenAIAPIChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	p, err := c.lookup(msg.ChatID)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.sent = append(p.sent, msg.Content)
+	p.mu.Unlock()
+	return nil
+}
+
+// CompleteTurn implements channels.TurnCompleter by answering the waiting
+// HTTP request with the turn's final reply.
+func (c *OpenAIAPIChannel) CompleteTurn(ctx context.Context, chatID, content string) error {
+	p, err := c.lookup(chatID)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		p.mu.Lock()
+		content = strings.Join(p.sent, "\n\n")
+		p.mu.Unlock()
+	}
+	select {
+	case p.reply <- content:
+	default:
+		logger.DebugCF("openai_api", "Dropping extra reply", map[string]any{"chat_id": chatID})
+	}
+	return nil
+}
+
+// lookup returns the HTTP request waiting for chatID.
+func (c *OpenAIAPIChannel) lookup(chatID string) (*pendingRequest, error) {
+	if !c.IsRunning() {
+		return nil, channels.ErrNotRunning
+	}
+	v, ok := c.pending.Load(chatID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no pending request %s", channels.ErrSendFailed, chatID)
+	}
+	return v.(*pendingRequest), nil
+}
+
+// StreamPartial implements channels.StreamCapable.
+func (c *OpenAIAPIChannel) StreamPartial(ctx context.Context, chatID, content string) error {
+	v, ok := c.pending.Load(chatID)
+	if !ok {
+		return nil
+	}
+	select {
+	case v.(*pendingRequest).partial <- content:
+	default:
+	}
+	return nil
+}
+
+func (c *OpenAIAPIChannel) storeImage(img image, scope string) string {
+	return c.StoreMedia(img.data, "image"+extensionFor(img.mimeType), img.mimeType, scope)
+}
+
+func extensionFor(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, code, message string) {
+	writeJSON(w, status, map[string]apiError{"error": {Message: message, Type: errType, Code: code}})
+}
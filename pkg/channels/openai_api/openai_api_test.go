@@ -0,0 +1,262 @@
+package openaiapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestChannel(t *testing.T) (*OpenAIAPIChannel, *bus.MessageBus, *httptest.Server) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Channels.OpenAIAPI.Token = "secret"
+	cfg.Agents.List = []config.AgentConfig{{ID: "main", Default: true}, {ID: "coder"}}
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewOpenAIAPIChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewOpenAIAPIChannel: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := ch.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	srv := httptest.NewServer(ch)
+	t.Cleanup(func() {
+		srv.Close()
+		ch.Stop(context.Background())
+		cancel()
+	})
+	return ch, msgBus, srv
+}
+
+func post(t *testing.T, srv *httptest.Server, token, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	return resp
+}
+
+// consume returns the next inbound message published by the channel.
+func consume(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestModelsListsAgents(t *testing.T) {
+	_, _, srv := newTestChannel(t)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Data []model `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list.Data) != 2 || list.Data[0].ID != "main" || list.Data[1].ID != "coder" {
+		t.Fatalf("unexpected models: %+v", list.Data)
+	}
+}
+
+func TestRejectsInvalidToken(t *testing.T) {
+	_, _, srv := newTestChannel(t)
+
+	resp := post(t, srv, "wrong", `{"model":"main","messages":[{"role":"user","content":"hi"}]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRejectsUnknownModel(t *testing.T) {
+	_, _, srv := newTestChannel(t)
+
+	resp := post(t, srv, "secret", `{"model":"nope","messages":[{"role":"user","content":"hi"}]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestChatCompletion(t *testing.T) {
+	ch, msgBus, srv := newTestChannel(t)
+
+	go func() {
+		msg := consume(t, msgBus)
+		if msg.SessionKey != "agent:coder:openai_api:direct:alice" {
+			t.Errorf("SessionKey = %q", msg.SessionKey)
+		}
+		if msg.Content != "second" {
+			t.Errorf("Content = %q, want only the last user message", msg.Content)
+		}
+		ctx := context.Background()
+		ch.Send(ctx, bus.OutboundMessage{Channel: "openai_api", ChatID: msg.ChatID, Content: "Working on it"})
+		ch.CompleteTurn(ctx, msg.ChatID, "hello")
+	}()
+
+	resp := post(t, srv, "secret", `{"model":"coder","user":"Alice","messages":[
+		{"role":"user","content":"first"},
+		{"role":"assistant","content":"ok"},
+		{"role":"user","content":[{"type":"text","text":"second"}]}]}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var completion chatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if completion.Model != "coder" || completion.Choices[0].Message.Content != "hello" {
+		t.Fatalf("unexpected completion: %+v", completion)
+	}
+}
+
+func TestStatelessRequestFoldsHistory(t *testing.T) {
+	ch, msgBus, srv := newTestChannel(t)
+
+	go func() {
+		msg := consume(t, msgBus)
+		if !strings.HasPrefix(msg.SessionKey, "agent:main:openai_api:request:") {
+			t.Errorf("SessionKey = %q", msg.SessionKey)
+		}
+		if msg.Metadata[bus.MetadataEphemeral] != "true" {
+			t.Errorf("Metadata = %v, want an ephemeral session", msg.Metadata)
+		}
+		if !strings.Contains(msg.Content, "user: first\nassistant: ok\n") || !strings.HasSuffix(msg.Content, "second") {
+			t.Errorf("Content = %q", msg.Content)
+		}
+		// The message tool answered; the final reply is empty.
+		ctx := context.Background()
+		ch.Send(ctx, bus.OutboundMessage{Channel: "openai_api", ChatID: msg.ChatID, Content: "done"})
+		ch.CompleteTurn(ctx, msg.ChatID, "")
+	}()
+
+	resp := post(t, srv, "secret", `{"messages":[
+		{"role":"user","content":"first"},
+		{"role":"assistant","content":"ok"},
+		{"role":"user","content":"second"}]}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var completion chatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if completion.Choices[0].Message.Content != "done" {
+		t.Fatalf("unexpected completion: %+v", completion)
+	}
+}
+
+func TestStreamingChatCompletion(t *testing.T) {
+	ch, msgBus, srv := newTestChannel(t)
+
+	go func() {
+		msg := consume(t, msgBus)
+		ctx := context.Background()
+		ch.StreamPartial(ctx, msg.ChatID, "Hel")
+		ch.StreamPartial(ctx, msg.ChatID, "Hello")
+		ch.CompleteTurn(ctx, msg.ChatID, "Hello world")
+	}()
+
+	resp := post(t, srv, "secret", `{"model":"main","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var text strings.Builder
+	var done bool
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk: %v", err)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if !done {
+		t.Fatal("stream ended without [DONE]")
+	}
+	if text.String() != "Hello world" {
+		t.Fatalf("streamed text = %q, want %q", text.String(), "Hello world")
+	}
+}
+
+func TestUserTokensIdentifyTheCaller(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Channels.OpenAIAPI.Token = "shared"
+	cfg.Channels.OpenAIAPI.Users = []config.OpenAIAPIUser{{ID: "alice", Token: "alice-token"}}
+	msgBus := bus.NewMessageBus()
+	ch, err := NewOpenAIAPIChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewOpenAIAPIChannel: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch.Start(ctx)
+	srv := httptest.NewServer(ch)
+	defer srv.Close()
+
+	// Nobody else can claim alice's session, with the shared token or their own.
+	for _, tt := range []struct{ token, user string }{
+		{"shared", "alice"},
+		{"shared", "ALICE"},
+		{"alice-token", "bob"},
+	} {
+		resp := post(t, srv, tt.token, `{"user":"`+tt.user+`","messages":[{"role":"user","content":"hi"}]}`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("token %s as %q: status = %d, want 403", tt.token, tt.user, resp.StatusCode)
+		}
+	}
+
+	go func() {
+		msg := consume(t, msgBus)
+		if msg.Sender.CanonicalID != "openai_api:alice" {
+			t.Errorf("sender = %q, want alice", msg.Sender.CanonicalID)
+		}
+		if msg.SessionKey != "agent:main:openai_api:direct:alice" {
+			t.Errorf("SessionKey = %q", msg.SessionKey)
+		}
+		ch.CompleteTurn(context.Background(), msg.ChatID, "hello")
+	}()
+	resp := post(t, srv, "alice-token", `{"user":"Alice","messages":[{"role":"user","content":"hi"}]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+}
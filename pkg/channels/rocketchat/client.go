@@ -0,0 +1,307 @@
+package rocketchat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1"
+	// maxDownloadSize bounds files downloaded from the server.
+	maxDownloadSize = 50 << 20
+)
+
+// apiError is an error response of the REST API.
+type apiError struct {
+	Status    int
+	ErrorType string `json:"errorType"`
+	Message   string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("rocketchat: %d %s: %s", e.Status, e.ErrorType, e.Message)
+}
+
+type user struct {
+	ID       string `json:"_id"`
+	Username string `json:"username"`
+}
+
+// message is a chat message as delivered by the realtime API.
+type message struct {
+	ID       string `json:"_id"`
+	RoomID   string `json:"rid"`
+	Text     string `json:"msg"`
+	ThreadID string `json:"tmid"`
+	// Timestamp is an EJSON date.
+	Timestamp struct {
+		Date int64 `json:"$date"`
+	} `json:"ts"`
+	// Type is set for system messages.
+	Type     string          `json:"t"`
+	User     user            `json:"u"`
+	Bot      json.RawMessage `json:"bot"`
+	EditedAt json.RawMessage `json:"editedAt"`
+	Mentions []user          `json:"mentions"`
+	Files    []file          `json:"files"`
+	File     *file           `json:"file"`
+}
+
+type file struct {
+	ID   string `json:"_id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// client is a minimal Rocket.Chat REST API client.
+type client struct {
+	serverURL string
+	userID    string
+	authToken string
+	http      *http.Client
+}
+
+func newClient(serverURL, userID, authToken string) *client {
+	return &client{
+		serverURL: strings.TrimRight(serverURL, "/"),
+		userID:    userID,
+		authToken: authToken,
+		http:      &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// websocketURL returns the URL of the realtime (DDP) API.
+func (c *client) websocketURL() string {
+	u := c.serverURL + "/websocket"
+	if rest, ok := strings.CutPrefix(u, "https://"); ok {
+		return "wss://" + rest
+	}
+	return "ws://" + strings.TrimPrefix(u, "http://")
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	resp, err := c.request(ctx, method, c.serverURL+apiPrefix+path, reader, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// request sends an authenticated request and turns error statuses into
+// *apiError.
+func (c *client) request(
+	ctx context.Context,
+	method, u string,
+	body io.Reader,
+	contentType string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.authToken != "" {
+		req.Header.Set("X-User-Id", c.userID)
+		req.Header.Set("X-Auth-Token", c.authToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// login logs in with a password and adopts the returned auth token.
+func (c *client) login(ctx context.Context, username, password string) error {
+	var resp struct {
+		Data struct {
+			UserID    string `json:"userId"`
+			AuthToken string `json:"authToken"`
+		} `json:"data"`
+	}
+	err := c.do(ctx, http.MethodPost, "/login", map[string]string{"user": username, "password": password}, &resp)
+	if err != nil {
+		return err
+	}
+	c.userID, c.authToken = resp.Data.UserID, resp.Data.AuthToken
+	return nil
+}
+
+func (c *client) me(ctx context.Context) (*user, error) {
+	var u user
+	if err := c.do(ctx, http.MethodGet, "/me", nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// roomType returns the type of a room: "d" for direct messages, "c" and "p"
+// for public and private channels.
+func (c *client) roomType(ctx context.Context, roomID string) (string, error) {
+	var resp struct {
+		Room struct {
+			Type string `json:"t"`
+		} `json:"room"`
+	}
+	err := c.do(ctx, http.MethodGet, "/rooms.info?roomId="+url.QueryEscape(roomID), nil, &resp)
+	return resp.Room.Type, err
+}
+
+// sendMessage posts a message, in the thread of threadID if set, and
+// returns its ID.
+func (c *client) sendMessage(ctx context.Context, roomID, threadID, text string) (string, error) {
+	msg := map[string]string{"rid": roomID, "msg": text}
+	if threadID != "" {
+		msg["tmid"] = threadID
+	}
+	var resp struct {
+		Message message `json:"message"`
+	}
+	err := c.do(ctx, http.MethodPost, "/chat.sendMessage", map[string]any{"message": msg}, &resp)
+	return resp.Message.ID, err
+}
+
+func (c *client) updateMessage(ctx context.Context, roomID, messageID, text string) error {
+	return c.do(ctx, http.MethodPost, "/chat.update", map[string]string{
+		"roomId": roomID,
+		"msgId":  messageID,
+		"text":   text,
+	}, nil)
+}
+
+func (c *client) react(ctx context.Context, messageID, emoji string, shouldReact bool) error {
+	return c.do(ctx, http.MethodPost, "/chat.react", map[string]any{
+		"messageId":   messageID,
+		"emoji":       emoji,
+		"shouldReact": shouldReact,
+	}, nil)
+}
+
+func (c *client) download(ctx context.Context, f file) ([]byte, error) {
+	u := c.serverURL + "/file-upload/" + url.PathEscape(f.ID) + "/" + url.PathEscape(f.Name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// File downloads are authenticated by cookie on older servers.
+	req.AddCookie(&http.Cookie{Name: "rc_uid", Value: c.userID})
+	req.AddCookie(&http.Cookie{Name: "rc_token", Value: c.authToken})
+	req.Header.Set("X-User-Id", c.userID)
+	req.Header.Set("X-Auth-Token", c.authToken)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &apiError{Status: resp.StatusCode, Message: "file download failed"}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("rocketchat: file %s exceeds %d bytes", f.ID, maxDownloadSize)
+	}
+	return data, nil
+}
+
+// upload sends a file with an optional caption to a room, in the thread of
+// threadID if set. It uses rooms.media with rooms.mediaConfirm and falls
+// back to rooms.upload on servers that predate them.
+func (c *client) upload(ctx context.Context, roomID, threadID, filename, caption string, data []byte) error {
+	fields := map[string]string{}
+	resp, err := c.postFile(ctx, "/rooms.media/"+url.PathEscape(roomID), filename, data, fields)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		fields["msg"] = caption
+		if threadID != "" {
+			fields["tmid"] = threadID
+		}
+		resp, err = c.postFile(ctx, "/rooms.upload/"+url.PathEscape(roomID), filename, data, fields)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		File struct {
+			ID string `json:"_id"`
+		} `json:"file"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	confirm := map[string]string{"msg": caption}
+	if threadID != "" {
+		confirm["tmid"] = threadID
+	}
+	return c.do(ctx, http.MethodPost,
+		"/rooms.mediaConfirm/"+url.PathEscape(roomID)+"/"+url.PathEscape(result.File.ID), confirm, nil)
+}
+
+func (c *client) postFile(
+	ctx context.Context,
+	path, filename string,
+	data []byte,
+	fields map[string]string,
+) (*http.Response, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return c.request(ctx, http.MethodPost, c.serverURL+apiPrefix+path, &buf, w.FormDataContentType())
+}
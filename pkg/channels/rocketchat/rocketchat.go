@@ -0,0 +1,560 @@
+// Package rocketchat implements a channel for Rocket.Chat servers,
+// receiving messages over the realtime (DDP) WebSocket API and replying
+// through the REST API.
+package rocketchat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	// maxMessageLength is the default Message_MaxAllowedSize.
+	maxMessageLength = 5000
+
+	pingInterval  = 30 * time.Second
+	readTimeout   = 90 * time.Second
+	minRetryDelay = 2 * time.Second
+	maxRetryDelay = time.Minute
+
+	reactionEmoji = ":eyes:"
+
+	// The stream re-delivers messages when they change (reactions, thread
+	// replies), so recently handled IDs are remembered.
+	maxSeenMessages = 1000
+)
+
+// ddpFrame is a message of the realtime API.
+type ddpFrame struct {
+	Msg        string `json:"msg"`
+	ID         string `json:"id"`
+	Collection string `json:"collection"`
+	Error      *struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Fields struct {
+		Args []json.RawMessage `json:"args"`
+	} `json:"fields"`
+}
+
+// roomInfo accompanies messages of the __my_messages__ stream.
+type roomInfo struct {
+	RoomType string `json:"roomType"`
+}
+
+type RocketChatChannel struct {
+	*channels.BaseChannel
+	config      config.RocketChatConfig
+	client      *client
+	botUserID   string
+	botUsername string
+	startedAt   time.Time
+
+	mu        sync.Mutex
+	roomTypes map[string]string
+	seen      map[string]bool
+	seenOrder []string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewRocketChatChannel(cfg config.RocketChatConfig, messageBus *bus.MessageBus) (*RocketChatChannel, error) {
+	if cfg.ServerURL == "" {
+		return nil, fmt.Errorf("rocketchat server_url is required")
+	}
+	if (cfg.UserID == "" || cfg.AuthToken == "") && (cfg.Username == "" || cfg.Password == "") {
+		return nil, fmt.Errorf("rocketchat user_id and auth_token, or username and password, are required")
+	}
+
+	base := channels.NewBaseChannel("rocketchat", cfg, messageBus, cfg.AllowFrom,
+		channels.WithMaxMessageLength(maxMessageLength),
+		channels.WithGroupTrigger(cfg.GroupTrigger),
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &RocketChatChannel{
+		BaseChannel: base,
+		config:      cfg,
+		client:      newClient(cfg.ServerURL, cfg.UserID, cfg.AuthToken),
+		roomTypes:   make(map[string]string),
+		seen:        make(map[string]bool),
+	}, nil
+}
+
+func (c *RocketChatChannel) Start(ctx context.Context) error {
+	logger.InfoC("rocketchat", "Starting Rocket.Chat channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	if c.client.authToken == "" {
+		if err := c.client.login(c.ctx, c.config.Username, c.config.Password); err != nil {
+			c.cancel()
+			return fmt.Errorf("rocketchat login failed: %w", err)
+		}
+	}
+	me, err := c.client.me(c.ctx)
+	if err != nil {
+		c.cancel()
+		return fmt.Errorf("rocketchat auth failed: %w", err)
+	}
+	c.botUserID = me.ID
+	c.botUsername = me.Username
+	c.startedAt = time.Now()
+
+	c.done = make(chan struct{})
+	go c.eventLoop()
+
+	c.SetRunning(true)
+	logger.InfoCF("rocketchat", "Rocket.Chat channel started", map[string]any{
+		"bot_user_id": c.botUserID,
+		"username":    c.botUsername,
+	})
+	return nil
+}
+
+func (c *RocketChatChannel) Stop(ctx context.Context) error {
+	logger.InfoC("rocketchat", "Stopping Rocket.Chat channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.done != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("rocketchat", "Rocket.Chat channel stopped")
+	return nil
+}
+
+// eventLoop keeps the realtime connection open, reconnecting with backoff.
+func (c *RocketChatChannel) eventLoop() {
+	defer close(c.done)
+	delay := minRetryDelay
+	for c.ctx.Err() == nil {
+		subscribed, err := c.listen()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			delay = minRetryDelay
+		}
+		logger.WarnCF("rocketchat", "Realtime connection lost", map[string]any{
+			"error":       err.Error(),
+			"retry_after": delay.String(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// listen connects to the realtime API, logs in, subscribes to the messages
+// of all our rooms and handles them until the connection fails.
+func (c *RocketChatChannel) listen() (subscribed bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.client.websocketURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(c.ctx, func() { conn.Close() })
+	defer stop()
+
+	for _, frame := range []map[string]any{
+		{"msg": "connect", "version": "1", "support": []string{"1"}},
+		{"msg": "method", "method": "login", "id": "login", "params": []any{
+			map[string]string{"resume": c.client.authToken},
+		}},
+		{"msg": "sub", "id": "messages", "name": "stream-room-messages", "params": []any{"__my_messages__", false}},
+	} {
+		if err := conn.WriteJSON(frame); err != nil {
+			return false, err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go pinger(conn, pingDone)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return subscribed, err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var frame ddpFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			continue
+		}
+		switch frame.Msg {
+		case "ping":
+			pong := map[string]string{"msg": "pong"}
+			if frame.ID != "" {
+				pong["id"] = frame.ID
+			}
+			if err := conn.WriteJSON(pong); err != nil {
+				return subscribed, err
+			}
+		case "result":
+			if frame.ID == "login" && frame.Error != nil {
+				return false, fmt.Errorf("rocketchat realtime login failed: %s", frame.Error.Reason)
+			}
+		case "nosub":
+			if frame.ID == "messages" {
+				return subscribed, errors.New("rocketchat message subscription was refused")
+			}
+		case "ready":
+			subscribed = true
+			logger.DebugC("rocketchat", "Subscribed to room messages")
+		case "changed":
+			if frame.Collection == "stream-room-messages" {
+				c.handleChanged(frame.Fields.Args)
+			}
+		}
+	}
+}
+
+func pinger(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *RocketChatChannel) handleChanged(args []json.RawMessage) {
+	if len(args) == 0 {
+		return
+	}
+	var msg message
+	if err := json.Unmarshal(args[0], &msg); err != nil {
+		return
+	}
+	var room roomInfo
+	if len(args) > 1 {
+		json.Unmarshal(args[1], &room)
+	}
+	c.handleMessage(&msg, room.RoomType)
+}
+
+// markSeen records a message ID and reports whether it is new.
+func (c *RocketChatChannel) markSeen(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[id] {
+		return false
+	}
+	c.seen[id] = true
+	c.seenOrder = append(c.seenOrder, id)
+	if len(c.seenOrder) > maxSeenMessages {
+		delete(c.seen, c.seenOrder[0])
+		c.seenOrder = c.seenOrder[1:]
+	}
+	return true
+}
+
+// lookupRoomType returns the type of a room, asking the server if the
+// stream did not say.
+func (c *RocketChatChannel) lookupRoomType(roomID, roomType string) string {
+	c.mu.Lock()
+	if roomType == "" {
+		roomType = c.roomTypes[roomID]
+	}
+	c.mu.Unlock()
+	if roomType == "" {
+		var err error
+		if roomType, err = c.client.roomType(c.ctx, roomID); err != nil {
+			logger.WarnCF("rocketchat", "Failed to get room info", map[string]any{
+				"room_id": roomID,
+				"error":   err.Error(),
+			})
+			return ""
+		}
+	}
+	c.mu.Lock()
+	c.roomTypes[roomID] = roomType
+	c.mu.Unlock()
+	return roomType
+}
+
+func (c *RocketChatChannel) handleMessage(msg *message, roomType string) {
+	if msg.User.ID == c.botUserID || msg.User.ID == "" {
+		return
+	}
+	// System messages, edits and messages of integrations are not requests.
+	if msg.Type != "" || !isNull(msg.EditedAt) || !isNull(msg.Bot) {
+		return
+	}
+	// Changes to messages from before we started are not new either.
+	if msg.Timestamp.Date != 0 && time.UnixMilli(msg.Timestamp.Date).Before(c.startedAt.Add(-time.Minute)) {
+		return
+	}
+	if !c.markSeen(msg.ID) {
+		return
+	}
+
+	// check allowlist to avoid downloading attachments for rejected users
+	sender := bus.SenderInfo{
+		Platform:    "rocketchat",
+		PlatformID:  msg.User.ID,
+		CanonicalID: identity.BuildCanonicalID("rocketchat", msg.User.ID),
+		Username:    msg.User.Username,
+	}
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("rocketchat", "Message rejected by allowlist", map[string]any{
+			"user_id": msg.User.ID,
+		})
+		return
+	}
+
+	direct := c.lookupRoomType(msg.RoomID, roomType) == "d"
+	content, named := channels.StripUsernameMention(msg.Text, c.botUsername)
+
+	// In channels every conversation is a thread: a new message starts one
+	// and the session follows it.
+	threadID := msg.ThreadID
+	var peer bus.Peer
+	if direct {
+		peer = bus.Peer{Kind: "direct", ID: msg.User.ID}
+	} else {
+		mentioned := named
+		for _, m := range msg.Mentions {
+			mentioned = mentioned || m.ID == c.botUserID
+		}
+		respond, cleaned := c.ShouldRespondInGroup(mentioned, content)
+		if !respond {
+			return
+		}
+		content = cleaned
+		if threadID == "" {
+			threadID = msg.ID
+		}
+		peer = bus.Peer{Kind: "channel", ID: msg.RoomID + "/" + threadID}
+	}
+
+	chatID := msg.RoomID
+	if threadID != "" {
+		chatID = msg.RoomID + "/" + threadID
+	}
+
+	files := msg.Files
+	if len(files) == 0 && msg.File != nil {
+		files = []file{*msg.File}
+	}
+	var mediaRefs []string
+	scope := channels.BuildMediaScope("rocketchat", chatID, msg.ID)
+	for _, f := range files {
+		if ref := c.storeFile(f, scope); ref != "" {
+			mediaRefs = append(mediaRefs, ref)
+		}
+		content = channels.AppendMediaLabel(content, f.Type, f.Name)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "rocketchat",
+		"room_id":    msg.RoomID,
+		"message_id": msg.ID,
+		"thread_id":  threadID,
+	}
+	if !direct {
+		// Bindings to the room still apply to its threads.
+		metadata["parent_peer_kind"] = "channel"
+		metadata["parent_peer_id"] = msg.RoomID
+	}
+
+	logger.DebugCF("rocketchat", "Received message", map[string]any{
+		"sender_id": msg.User.ID,
+		"chat_id":   chatID,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(c.ctx, peer, msg.ID, msg.User.ID, chatID, content, mediaRefs, metadata, sender)
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null" || string(raw) == "false"
+}
+
+// storeFile downloads an attachment and registers it with the media store.
+// It returns "" if that is not possible.
+func (c *RocketChatChannel) storeFile(f file, scope string) string {
+	if c.GetMediaStore() == nil {
+		return ""
+	}
+	data, err := c.client.download(c.ctx, f)
+	if err != nil {
+		logger.WarnCF("rocketchat", "Failed to download file", map[string]any{
+			"file_id": f.ID,
+			"error":   err.Error(),
+		})
+		return ""
+	}
+	return c.StoreMedia(data, f.Name, f.Type, scope)
+}
+
+func (c *RocketChatChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	roomID, threadID := parseChatID(msg.ChatID)
+	if roomID == "" {
+		return fmt.Errorf("invalid rocketchat chat ID %q: %w", msg.ChatID, channels.ErrSendFailed)
+	}
+	if msg.Content == "" {
+		return nil
+	}
+
+	if _, err := c.client.sendMessage(ctx, roomID, threadID, msg.Content); err != nil {
+		return sendError(err)
+	}
+
+	logger.DebugCF("rocketchat", "Message sent", map[string]any{
+		"room_id":   roomID,
+		"thread_id": threadID,
+	})
+	return nil
+}
+
+// SendMedia implements the channels.MediaSender interface.
+func (c *RocketChatChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	roomID, threadID := parseChatID(msg.ChatID)
+	if roomID == "" {
+		return fmt.Errorf("invalid rocketchat chat ID %q: %w", msg.ChatID, channels.ErrSendFailed)
+	}
+
+	store := c.GetMediaStore()
+	if store == nil {
+		return fmt.Errorf("no media store available: %w", channels.ErrSendFailed)
+	}
+
+	for _, part := range msg.Parts {
+		localPath, err := store.Resolve(part.Ref)
+		if err != nil {
+			logger.ErrorCF("rocketchat", "Failed to resolve media ref", map[string]any{
+				"ref":   part.Ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			logger.ErrorCF("rocketchat", "Failed to read media file", map[string]any{"error": err.Error()})
+			continue
+		}
+
+		filename := part.Filename
+		if filename == "" {
+			filename = filepath.Base(localPath)
+		}
+		if err := c.client.upload(ctx, roomID, threadID, filename, part.Caption, data); err != nil {
+			logger.ErrorCF("rocketchat", "Failed to upload media", map[string]any{
+				"filename": filename,
+				"error":    err.Error(),
+			})
+			return sendError(err)
+		}
+	}
+	return nil
+}
+
+// EditMessage implements channels.MessageEditor.
+func (c *RocketChatChannel) EditMessage(ctx context.Context, chatID string, messageID string, content string) error {
+	roomID, _ := parseChatID(chatID)
+	if err := c.client.updateMessage(ctx, roomID, messageID, content); err != nil {
+		return sendError(err)
+	}
+	return nil
+}
+
+// SendPlaceholder implements channels.PlaceholderCapable. The placeholder
+// is posted in the thread the reply will go to and later edited into it.
+func (c *RocketChatChannel) SendPlaceholder(ctx context.Context, chatID string) (string, error) {
+	if !c.config.Placeholder.Enabled {
+		return "", nil
+	}
+
+	roomID, threadID := parseChatID(chatID)
+	if roomID == "" {
+		return "", fmt.Errorf("invalid rocketchat chat ID %q", chatID)
+	}
+
+	text := c.config.Placeholder.Text
+	if text == "" {
+		text = "Thinking... 💭"
+	}
+	return c.client.sendMessage(ctx, roomID, threadID, text)
+}
+
+// ReactToMessage implements channels.ReactionCapable.
+// It adds an "eyes" (👀) reaction to the inbound message and returns an undo
+// function that removes the reaction.
+func (c *RocketChatChannel) ReactToMessage(ctx context.Context, chatID, messageID string) (func(), error) {
+	if err := c.client.react(ctx, messageID, reactionEmoji, true); err != nil {
+		return func() {}, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		c.client.react(ctx, messageID, reactionEmoji, false)
+	}, nil
+}
+
+func sendError(err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return channels.ClassifySendError(apiErr.Status, err)
+	}
+	return channels.ClassifyNetError(err)
+}
+
+// parseChatID splits a chat ID of the form "roomID" or "roomID/threadID".
+func parseChatID(chatID string) (roomID, threadID string) {
+	roomID, threadID, _ = strings.Cut(chatID, "/")
+	return roomID, threadID
+}
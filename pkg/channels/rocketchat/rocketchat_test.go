@@ -0,0 +1,460 @@
+package rocketchat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+type request struct {
+	method string
+	path   string
+	body   map[string]any
+	form   map[string]string
+}
+
+// fakeServer implements the parts of the Rocket.Chat REST and realtime
+// APIs the channel uses.
+type fakeServer struct {
+	t        *testing.T
+	srv      *httptest.Server
+	requests chan request
+	// legacyUpload makes rooms.media unavailable, as on older servers.
+	legacyUpload bool
+
+	mu         sync.Mutex
+	conn       *websocket.Conn
+	subscribed chan struct{}
+	pongs      chan string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	s := &fakeServer{
+		t:          t,
+		requests:   make(chan request, 100),
+		subscribed: make(chan struct{}, 10),
+		pongs:      make(chan string, 10),
+	}
+	authed := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-User-Id") != "botid" || r.Header.Get("X-Auth-Token") != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"status":"error","message":"You must be logged in to do this."}`))
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/login", func(w http.ResponseWriter, r *http.Request) {
+		body := s.record(r)
+		if body["user"] != "pico" || body["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"userId":"botid","authToken":"token"}}`))
+	})
+	mux.HandleFunc("GET /api/v1/me", authed(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"_id":"botid","username":"pico","success":true}`))
+	}))
+	mux.HandleFunc("GET /api/v1/rooms.info", authed(func(w http.ResponseWriter, r *http.Request) {
+		s.requests <- request{method: r.Method, path: r.URL.Path + "?" + r.URL.RawQuery}
+		w.Write([]byte(`{"room":{"_id":"` + r.URL.Query().Get("roomId") + `","t":"d"},"success":true}`))
+	}))
+	mux.HandleFunc("POST /api/v1/chat.sendMessage", authed(func(w http.ResponseWriter, r *http.Request) {
+		body := s.record(r)
+		msg, _ := body["message"].(map[string]any)
+		if msg["rid"] == "forbidden" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"error":"error-not-allowed","errorType":"error-not-allowed"}`))
+			return
+		}
+		w.Write([]byte(`{"message":{"_id":"sent1"},"success":true}`))
+	}))
+	for _, path := range []string{"chat.update", "chat.react", "rooms.mediaConfirm/{rid}/{id}"} {
+		mux.HandleFunc("POST /api/v1/"+path, authed(func(w http.ResponseWriter, r *http.Request) {
+			s.record(r)
+			w.Write([]byte(`{"success":true}`))
+		}))
+	}
+	upload := func(w http.ResponseWriter, r *http.Request) {
+		if s.legacyUpload && r.URL.Path == "/api/v1/rooms.media/"+r.PathValue("rid") {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		s.requests <- request{method: r.Method, path: r.URL.Path, form: map[string]string{
+			"filename": header.Filename,
+			"data":     string(data),
+			"msg":      r.FormValue("msg"),
+			"tmid":     r.FormValue("tmid"),
+		}}
+		w.Write([]byte(`{"file":{"_id":"file9"},"success":true}`))
+	}
+	mux.HandleFunc("POST /api/v1/rooms.media/{rid}", authed(upload))
+	mux.HandleFunc("POST /api/v1/rooms.upload/{rid}", authed(upload))
+	mux.HandleFunc("GET /file-upload/{id}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("rc_token"); err != nil || cookie.Value != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("contents of " + r.PathValue("name")))
+	})
+	mux.HandleFunc("GET /websocket", s.websocket)
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+		s.srv.Close()
+	})
+	return s
+}
+
+func (s *fakeServer) record(r *http.Request) map[string]any {
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+	s.requests <- request{method: r.Method, path: r.URL.Path, body: body}
+	return body
+}
+
+// websocket speaks just enough DDP: connect, resume login and the
+// subscription to the messages of all rooms.
+func (s *fakeServer) websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	for {
+		var frame map[string]any
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		var reply map[string]any
+		switch frame["msg"] {
+		case "connect":
+			reply = map[string]any{"msg": "connected", "session": "s1"}
+		case "method":
+			params, _ := frame["params"].([]any)
+			login, _ := params[0].(map[string]any)
+			reply = map[string]any{"msg": "result", "id": frame["id"], "result": map[string]any{"id": "botid"}}
+			if login["resume"] != "token" {
+				reply = map[string]any{"msg": "result", "id": frame["id"], "error": map[string]any{"reason": "bad token"}}
+			}
+		case "sub":
+			params, _ := frame["params"].([]any)
+			if frame["name"] != "stream-room-messages" || params[0] != "__my_messages__" {
+				reply = map[string]any{"msg": "nosub", "id": frame["id"]}
+				break
+			}
+			reply = map[string]any{"msg": "ready", "subs": []any{frame["id"]}}
+		case "pong":
+			id, _ := frame["id"].(string)
+			s.pongs <- id
+			continue
+		default:
+			continue
+		}
+		s.send(reply)
+		if reply["msg"] == "ready" {
+			s.subscribed <- struct{}{}
+		}
+	}
+}
+
+func (s *fakeServer) send(frame map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.conn.WriteJSON(frame); err != nil {
+		s.t.Error(err)
+	}
+}
+
+// message delivers a message on the __my_messages__ stream.
+func (s *fakeServer) message(roomType string, msg map[string]any) {
+	if _, ok := msg["u"]; !ok {
+		msg["u"] = map[string]any{"_id": "u1", "username": "alice"}
+	}
+	if _, ok := msg["ts"]; !ok {
+		msg["ts"] = map[string]any{"$date": time.Now().UnixMilli()}
+	}
+	args := []any{msg}
+	if roomType != "" {
+		args = append(args, map[string]any{"roomType": roomType, "roomParticipant": true})
+	}
+	s.send(map[string]any{
+		"msg":        "changed",
+		"collection": "stream-room-messages",
+		"id":         "id",
+		"fields":     map[string]any{"eventName": "__my_messages__", "args": args},
+	})
+}
+
+func (s *fakeServer) expect(method, path string) request {
+	s.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-s.requests:
+			if r.method == method && r.path == path {
+				return r
+			}
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %s %s", method, path)
+		}
+	}
+}
+
+func startChannel(t *testing.T, s *fakeServer, cfg config.RocketChatConfig) (*RocketChatChannel, *bus.MessageBus) {
+	t.Helper()
+	cfg.ServerURL = s.srv.URL
+	if cfg.Username == "" {
+		cfg.UserID, cfg.AuthToken = "botid", "token"
+	}
+	msgBus := bus.NewMessageBus()
+	ch, err := NewRocketChatChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.SetMediaStore(media.NewFileMediaStore())
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch.Stop(ctx)
+	})
+	select {
+	case <-s.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("realtime subscription was not set up")
+	}
+	return ch, msgBus
+}
+
+func nextInbound(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestRocketChatChannel_ThreadsAndMentions(t *testing.T) {
+	s := newFakeServer(t)
+	_, msgBus := startChannel(t, s, config.RocketChatConfig{
+		AllowFrom:    config.FlexibleStringSlice{"alice"},
+		GroupTrigger: config.GroupTriggerConfig{MentionOnly: true},
+	})
+
+	// Ignored: no mention, our own message, a system message, an
+	// integration, an edit and a message from before we started.
+	s.message("c", map[string]any{"_id": "m1", "rid": "general", "msg": "hello all"})
+	s.message("c", map[string]any{"_id": "m2", "rid": "general", "msg": "@pico echo", "u": map[string]any{"_id": "botid"}})
+	s.message("c", map[string]any{"_id": "m3", "rid": "general", "msg": "alice", "t": "uj"})
+	s.message("c", map[string]any{"_id": "m4", "rid": "general", "msg": "@pico hi", "bot": map[string]any{"i": "x"}})
+	s.message("c", map[string]any{
+		"_id": "m5", "rid": "general", "msg": "@pico hi", "editedAt": map[string]any{"$date": 1},
+	})
+	s.message("c", map[string]any{
+		"_id": "m6", "rid": "general", "msg": "@pico old", "ts": map[string]any{"$date": 1},
+	})
+
+	s.message("c", map[string]any{
+		"_id": "m7", "rid": "general", "msg": "@pico status?",
+		"mentions": []map[string]any{{"_id": "botid", "username": "pico"}},
+	})
+	msg := nextInbound(t, msgBus)
+	if msg.ChatID != "general/m7" || msg.Content != "status?" || msg.MessageID != "m7" {
+		t.Errorf("new thread = %+v", msg)
+	}
+	if msg.Peer.Kind != "channel" || msg.Peer.ID != "general/m7" || msg.Metadata["parent_peer_id"] != "general" {
+		t.Errorf("peer = %+v, metadata = %v", msg.Peer, msg.Metadata)
+	}
+	if msg.SenderID != "rocketchat:u1" {
+		t.Errorf("sender = %q", msg.SenderID)
+	}
+
+	// The thread root is re-delivered when it gets a reply; only the reply
+	// is new.
+	s.message("c", map[string]any{"_id": "m7", "rid": "general", "msg": "@pico status?", "tcount": 1})
+	s.message("c", map[string]any{"_id": "m8", "rid": "general", "tmid": "m7", "msg": "more @pico"})
+	msg = nextInbound(t, msgBus)
+	if msg.MessageID != "m8" || msg.ChatID != "general/m7" || msg.Peer.ID != "general/m7" || msg.Content != "more" {
+		t.Errorf("thread reply = %+v", msg)
+	}
+
+	// Without the room type in the stream, it is looked up once.
+	s.message("", map[string]any{"_id": "m9", "rid": "botidu1", "msg": "no mention needed"})
+	msg = nextInbound(t, msgBus)
+	if msg.ChatID != "botidu1" || msg.Peer.Kind != "direct" || msg.Peer.ID != "u1" {
+		t.Errorf("direct message = %+v", msg)
+	}
+	s.expect("GET", "/api/v1/rooms.info?roomId=botidu1")
+	s.message("", map[string]any{"_id": "m10", "rid": "botidu1", "msg": "again"})
+	nextInbound(t, msgBus)
+	select {
+	case r := <-s.requests:
+		t.Errorf("unexpected request %s %s", r.method, r.path)
+	default:
+	}
+}
+
+func TestRocketChatChannel_PingAndPasswordLogin(t *testing.T) {
+	s := newFakeServer(t)
+	_, msgBus := startChannel(t, s, config.RocketChatConfig{Username: "pico", Password: "secret"})
+	s.expect("POST", "/api/v1/login")
+
+	s.send(map[string]any{"msg": "ping", "id": "p1"})
+	select {
+	case id := <-s.pongs:
+		if id != "p1" {
+			t.Errorf("pong id = %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong")
+	}
+
+	s.message("d", map[string]any{"_id": "m1", "rid": "dm", "msg": "hi"})
+	if msg := nextInbound(t, msgBus); msg.Content != "hi" {
+		t.Errorf("inbound = %+v", msg)
+	}
+}
+
+func TestRocketChatChannel_SendEditReact(t *testing.T) {
+	s := newFakeServer(t)
+	ch, _ := startChannel(t, s, config.RocketChatConfig{
+		Placeholder: config.PlaceholderConfig{Enabled: true},
+	})
+	ctx := context.Background()
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "general/m7", Content: "reply"}); err != nil {
+		t.Fatal(err)
+	}
+	r := s.expect("POST", "/api/v1/chat.sendMessage")
+	if msg, _ := r.body["message"].(map[string]any); msg["rid"] != "general" || msg["tmid"] != "m7" ||
+		msg["msg"] != "reply" {
+		t.Errorf("message = %v", r.body)
+	}
+
+	id, err := ch.SendPlaceholder(ctx, "dm")
+	if err != nil || id != "sent1" {
+		t.Fatalf("SendPlaceholder = %q, %v", id, err)
+	}
+	r = s.expect("POST", "/api/v1/chat.sendMessage")
+	if msg, _ := r.body["message"].(map[string]any); msg["msg"] != "Thinking... 💭" || msg["tmid"] != nil {
+		t.Errorf("placeholder = %v", r.body)
+	}
+	if err := ch.EditMessage(ctx, "dm", id, "final"); err != nil {
+		t.Fatal(err)
+	}
+	r = s.expect("POST", "/api/v1/chat.update")
+	if r.body["roomId"] != "dm" || r.body["msgId"] != "sent1" || r.body["text"] != "final" {
+		t.Errorf("update = %v", r.body)
+	}
+
+	undo, err := ch.ReactToMessage(ctx, "general/m7", "m7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = s.expect("POST", "/api/v1/chat.react")
+	if r.body["messageId"] != "m7" || r.body["emoji"] != ":eyes:" || r.body["shouldReact"] != true {
+		t.Errorf("react = %v", r.body)
+	}
+	undo()
+	if r = s.expect("POST", "/api/v1/chat.react"); r.body["shouldReact"] != false {
+		t.Errorf("unreact = %v", r.body)
+	}
+
+	err = ch.Send(ctx, bus.OutboundMessage{ChatID: "forbidden", Content: "x"})
+	if !errors.Is(err, channels.ErrSendFailed) {
+		t.Errorf("Send to a forbidden room = %v, want ErrSendFailed", err)
+	}
+}
+
+func TestRocketChatChannel_Files(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		s := newFakeServer(t)
+		s.legacyUpload = legacy
+		ch, msgBus := startChannel(t, s, config.RocketChatConfig{})
+
+		s.message("d", map[string]any{
+			"_id": "m1", "rid": "dm", "msg": "",
+			"file":  map[string]any{"_id": "f1", "name": "cat.png", "type": "image/png"},
+			"files": []map[string]any{{"_id": "f1", "name": "cat.png", "type": "image/png"}},
+		})
+		msg := nextInbound(t, msgBus)
+		if msg.Content != "[image: cat.png]" || len(msg.Media) != 1 {
+			t.Fatalf("inbound = %+v", msg)
+		}
+		path, meta, err := ch.GetMediaStore().ResolveWithMeta(msg.Media[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "contents of cat.png" || meta.ContentType != "image/png" {
+			t.Errorf("stored file = %q, %+v", data, meta)
+		}
+
+		local := filepath.Join(t.TempDir(), "report.pdf")
+		os.WriteFile(local, []byte("%PDF"), 0o600)
+		ref, err := ch.GetMediaStore().Store(local, media.MediaMeta{Filename: "report.pdf"}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ch.SendMedia(context.Background(), bus.OutboundMediaMessage{
+			ChatID: "general/m7",
+			Parts:  []bus.MediaPart{{Ref: ref, Filename: "report.pdf", Caption: "the report"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if legacy {
+			r := s.expect("POST", "/api/v1/rooms.upload/general")
+			if r.form["filename"] != "report.pdf" || r.form["msg"] != "the report" || r.form["tmid"] != "m7" {
+				t.Errorf("legacy upload = %v", r.form)
+			}
+			continue
+		}
+		if r := s.expect("POST", "/api/v1/rooms.media/general"); r.form["data"] != "%PDF" {
+			t.Errorf("upload = %v", r.form)
+		}
+		r := s.expect("POST", "/api/v1/rooms.mediaConfirm/general/file9")
+		if r.body["msg"] != "the report" || r.body["tmid"] != "m7" {
+			t.Errorf("confirm = %v", r.body)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package signal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rpcTimeout = 60 * time.Second
+	// maxEventSize bounds one received event; attachments are fetched
+	// separately, so events are small.
+	maxEventSize = 1 << 20
+	// maxResponseSize bounds one response. getAttachment returns the
+	// attachment base64-encoded, and Signal allows up to 100 MiB.
+	maxResponseSize = 140 << 20
+)
+
+// rpcError is an error returned by signal-cli for a request.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("signal-cli error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string         `json:"jsonrpc"`
+	Method  string         `json:"method"`
+	Params  map[string]any `json:"params,omitempty"`
+	ID      int64          `json:"id"`
+}
+
+// rpcFrame is a response or, with Method set, a notification.
+type rpcFrame struct {
+	ID     *int64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// transport is a connection to the signal-cli daemon.
+type transport interface {
+	// call invokes method and decodes its result into result, if not nil.
+	call(ctx context.Context, method string, params map[string]any, result any) error
+	// receive passes the params of every "receive" notification to handle
+	// until ctx ends or the connection fails.
+	receive(ctx context.Context, handle func(json.RawMessage)) error
+	close()
+}
+
+// newTransport returns the transport for a daemon URL: http(s)://host:port
+// for "signal-cli daemon --http", tcp://host:port for "--tcp" and
+// unix:///path for "--socket".
+func newTransport(daemonURL string) (transport, error) {
+	u, err := url.Parse(daemonURL)
+	if err != nil {
+		return nil, fmt.Errorf("signal: invalid daemon_url %q: %w", daemonURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpTransport{
+			base:   strings.TrimRight(daemonURL, "/"),
+			client: &http.Client{Timeout: rpcTimeout},
+			// The event stream stays open indefinitely.
+			stream: &http.Client{},
+		}, nil
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("signal: daemon_url %q has no host", daemonURL)
+		}
+		return newSocketTransport("tcp", u.Host), nil
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("signal: daemon_url %q has no path", daemonURL)
+		}
+		return newSocketTransport("unix", u.Path), nil
+	}
+	return nil, fmt.Errorf("signal: unsupported daemon_url scheme %q (want http, tcp or unix)", u.Scheme)
+}
+
+// httpTransport uses the daemon's HTTP interface: requests are posted to
+// /api/v1/rpc and messages arrive as server-sent events on /api/v1/events.
+type httpTransport struct {
+	base   string
+	client *http.Client
+	stream *http.Client
+	nextID atomic.Int64
+}
+
+func (t *httpTransport) call(ctx context.Context, method string, params map[string]any, result any) error {
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: t.nextID.Add(1)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.base+"/api/v1/rpc", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxResponseSize {
+		return fmt.Errorf("signal-cli %s: response exceeds %d bytes", method, maxResponseSize)
+	}
+	if len(bytes.TrimSpace(data)) == 0 && resp.StatusCode/100 == 2 {
+		return nil
+	}
+	var frame rpcFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return fmt.Errorf("signal-cli %s: HTTP %d: %w", method, resp.StatusCode, err)
+	}
+	return frame.decode(result)
+}
+
+func (t *httpTransport) receive(ctx context.Context, handle func(json.RawMessage)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+"/api/v1/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := t.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signal-cli events: HTTP %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				handle(data)
+				data = nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+func (t *httpTransport) close() {}
+
+// socketTransport speaks newline-delimited JSON-RPC over a TCP or UNIX
+// socket, where received messages arrive as notifications. It connects on
+// first use and again after the connection drops.
+type socketTransport struct {
+	network string
+	addr    string
+	nextID  atomic.Int64
+
+	mu   sync.Mutex
+	conn *socketConn
+}
+
+type socketConn struct {
+	nc            net.Conn
+	notifications chan json.RawMessage
+	done          chan struct{}
+	err           error
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[int64]chan *rpcFrame
+}
+
+func newSocketTransport(network, addr string) *socketTransport {
+	return &socketTransport{network: network, addr: addr}
+}
+
+// connection returns the live connection, dialing if there is none.
+func (t *socketTransport) connection(ctx context.Context) (*socketConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		select {
+		case <-t.conn.done:
+		default:
+			return t.conn, nil
+		}
+	}
+	nc, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, t.network, t.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &socketConn{
+		nc:            nc,
+		notifications: make(chan json.RawMessage, 100),
+		done:          make(chan struct{}),
+		pending:       make(map[int64]chan *rpcFrame),
+	}
+	go c.read()
+	t.conn = c
+	return c, nil
+}
+
+func (c *socketConn) read() {
+	scanner := bufio.NewScanner(c.nc)
+	// Responses share the connection with events.
+	scanner.Buffer(make([]byte, 0, 64*1024), maxResponseSize)
+	for scanner.Scan() {
+		var frame rpcFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			continue
+		}
+		if frame.Method != "" {
+			if frame.Method != "receive" {
+				continue
+			}
+			select {
+			case c.notifications <- frame.Params:
+			default:
+				// Nobody is receiving; dropping beats stalling responses.
+			}
+			continue
+		}
+		if frame.ID == nil {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*frame.ID]
+		delete(c.pending, *frame.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- &frame
+		}
+	}
+	c.err = scanner.Err()
+	if c.err == nil {
+		c.err = io.EOF
+	}
+	c.nc.Close()
+	close(c.done)
+}
+
+func (t *socketTransport) call(ctx context.Context, method string, params map[string]any, result any) error {
+	c, err := t.connection(ctx)
+	if err != nil {
+		return err
+	}
+	id := t.nextID.Add(1)
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *rpcFrame, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	c.nc.SetWriteDeadline(time.Now().Add(rpcTimeout))
+	_, err = c.nc.Write(append(data, '\n'))
+	c.writeMu.Unlock()
+	if err != nil {
+		c.nc.Close()
+		return err
+	}
+
+	timer := time.NewTimer(rpcTimeout)
+	defer timer.Stop()
+	select {
+	case frame := <-ch:
+		return frame.decode(result)
+	case <-c.done:
+		return fmt.Errorf("signal-cli connection closed: %w", c.err)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return fmt.Errorf("signal-cli %s: timed out", method)
+	}
+}
+
+func (t *socketTransport) receive(ctx context.Context, handle func(json.RawMessage)) error {
+	c, err := t.connection(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case params := <-c.notifications:
+			handle(params)
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *socketTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		t.conn.nc.Close()
+	}
+}
+
+func (f *rpcFrame) decode(result any) error {
+	if f.Error != nil {
+		return f.Error
+	}
+	if result == nil || len(f.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(f.Result, result)
+}
+
+// isRPCError reports whether err is an error reported by signal-cli, as
+// opposed to a failure to reach it.
+func isRPCError(err error) bool {
+	var re *rpcError
+	return errors.As(err, &re)
+}
@@ -0,0 +1,564 @@
+// Package signal implements a channel backed by a signal-cli daemon
+// (https://github.com/AsamK/signal-cli) through its JSON-RPC interface.
+// Direct chats use the sender's number as chat ID and groups use
+// "group:<groupId>".
+package signal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	groupPrefix      = "group:"
+	reactionEmoji    = "👀"
+	maxMessageLength = 2000
+
+	minReconnectDelay = 2 * time.Second
+	maxReconnectDelay = time.Minute
+	// Signal clients hide the typing indicator after 15 seconds.
+	typingRefresh     = 10 * time.Second
+	maxTypingDuration = 5 * time.Minute
+)
+
+// envelope is a message received by signal-cli.
+type envelope struct {
+	Source       string       `json:"source"`
+	SourceNumber string       `json:"sourceNumber"`
+	SourceUUID   string       `json:"sourceUuid"`
+	SourceName   string       `json:"sourceName"`
+	Timestamp    int64        `json:"timestamp"`
+	DataMessage  *dataMessage `json:"dataMessage"`
+}
+
+type dataMessage struct {
+	Timestamp    int64        `json:"timestamp"`
+	Message      string       `json:"message"`
+	GroupInfo    *groupInfo   `json:"groupInfo"`
+	Mentions     []mention    `json:"mentions"`
+	Attachments  []attachment `json:"attachments"`
+	Reaction     *struct{}    `json:"reaction"`
+	RemoteDelete *struct{}    `json:"remoteDelete"`
+}
+
+type groupInfo struct {
+	GroupID string `json:"groupId"`
+}
+
+// mention replaces Length UTF-16 code units at Start in the message text.
+type mention struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+	UUID   string `json:"uuid"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+type attachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+}
+
+type SignalChannel struct {
+	*channels.BaseChannel
+	config config.SignalConfig
+	rpc    transport
+
+	mu      sync.Mutex
+	selfIDs map[string]bool // our number and UUID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSignalChannel(cfg config.SignalConfig, messageBus *bus.MessageBus) (*SignalChannel, error) {
+	if cfg.Account == "" || cfg.DaemonURL == "" {
+		return nil, fmt.Errorf("signal account and daemon_url are required")
+	}
+	rpc, err := newTransport(cfg.DaemonURL)
+	if err != nil {
+		return nil, err
+	}
+
+	base := channels.NewBaseChannel("signal", cfg, messageBus, cfg.AllowFrom,
+		channels.WithMaxMessageLength(maxMessageLength),
+		channels.WithGroupTrigger(cfg.GroupTrigger),
+		channels.WithReasoningChannelID(cfg.ReasoningChannelID),
+	)
+
+	return &SignalChannel{
+		BaseChannel: base,
+		config:      cfg,
+		rpc:         rpc,
+		selfIDs:     map[string]bool{cfg.Account: true},
+	}, nil
+}
+
+func (c *SignalChannel) Start(ctx context.Context) error {
+	logger.InfoC("signal", "Starting Signal channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := c.rpc.call(c.ctx, "version", nil, &version); err != nil {
+		c.cancel()
+		return fmt.Errorf("signal-cli daemon at %s: %w", c.config.DaemonURL, err)
+	}
+	c.lookupSelf()
+
+	c.done = make(chan struct{})
+	go c.receiveLoop()
+
+	c.SetRunning(true)
+	logger.InfoCF("signal", "Signal channel started", map[string]any{
+		"account":    c.config.Account,
+		"signal_cli": version.Version,
+	})
+	return nil
+}
+
+func (c *SignalChannel) Stop(ctx context.Context) error {
+	logger.InfoC("signal", "Stopping Signal channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.rpc.close()
+	if c.done != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.SetRunning(false)
+	logger.InfoC("signal", "Signal channel stopped")
+	return nil
+}
+
+// lookupSelf learns our account's UUID, which mentions may use instead
+// of the number.
+func (c *SignalChannel) lookupSelf() {
+	var statuses []struct {
+		Number string `json:"number"`
+		UUID   string `json:"uuid"`
+	}
+	err := c.rpc.call(c.ctx, "getUserStatus", c.params(map[string]any{
+		"recipient": []string{c.config.Account},
+	}), &statuses)
+	if err != nil {
+		logger.DebugCF("signal", "Could not look up own UUID", map[string]any{"error": err.Error()})
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range statuses {
+		if s.UUID != "" {
+			c.selfIDs[s.UUID] = true
+		}
+	}
+}
+
+func (c *SignalChannel) isSelf(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return id != "" && c.selfIDs[id]
+}
+
+// params adds the account, which a daemon serving several accounts needs.
+func (c *SignalChannel) params(p map[string]any) map[string]any {
+	p["account"] = c.config.Account
+	return p
+}
+
+// receiveLoop handles incoming messages, reconnecting with backoff.
+func (c *SignalChannel) receiveLoop() {
+	defer close(c.done)
+	delay := minReconnectDelay
+	for {
+		started := time.Now()
+		err := c.rpc.receive(c.ctx, c.handleReceive)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		logger.WarnCF("signal", "Event stream ended, reconnecting", map[string]any{
+			"error": fmt.Sprint(err),
+			"retry": delay.String(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *SignalChannel) handleReceive(data json.RawMessage) {
+	var event struct {
+		Envelope *envelope `json:"envelope"`
+		Account  string    `json:"account"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Envelope == nil {
+		return
+	}
+	if event.Account != "" && event.Account != c.config.Account {
+		return
+	}
+	c.handleEnvelope(event.Envelope)
+}
+
+func (c *SignalChannel) handleEnvelope(env *envelope) {
+	dm := env.DataMessage
+	if dm == nil || dm.Reaction != nil || dm.RemoteDelete != nil {
+		return
+	}
+	source := env.SourceNumber
+	if source == "" {
+		source = env.Source
+	}
+	if source == "" {
+		source = env.SourceUUID
+	}
+	if source == "" || c.isSelf(source) || c.isSelf(env.SourceUUID) {
+		return
+	}
+
+	sender := bus.SenderInfo{
+		Platform:    "signal",
+		PlatformID:  source,
+		CanonicalID: identity.BuildCanonicalID("signal", source),
+		Username:    env.SourceUUID,
+		DisplayName: env.SourceName,
+	}
+	if !c.IsAllowedSender(sender) {
+		logger.DebugCF("signal", "Message rejected by allowlist", map[string]any{"from": source})
+		return
+	}
+
+	timestamp := dm.Timestamp
+	if timestamp == 0 {
+		timestamp = env.Timestamp
+	}
+	messageID := formatMessageID(source, timestamp)
+
+	mentioned := false
+	content := resolveMentions(dm.Message, dm.Mentions, func(m mention) bool {
+		if c.isSelf(m.Number) || c.isSelf(m.UUID) {
+			mentioned = true
+			return true
+		}
+		return false
+	})
+
+	var chatID string
+	var peer bus.Peer
+	if dm.GroupInfo != nil && dm.GroupInfo.GroupID != "" {
+		chatID = groupPrefix + dm.GroupInfo.GroupID
+		respond, cleaned := c.ShouldRespondInGroup(mentioned, content)
+		if !respond {
+			return
+		}
+		content = cleaned
+		peer = bus.Peer{Kind: "group", ID: chatID}
+	} else {
+		chatID = source
+		peer = bus.Peer{Kind: "direct", ID: source}
+	}
+
+	scope := channels.BuildMediaScope("signal", chatID, messageID)
+	var mediaRefs []string
+	for _, a := range dm.Attachments {
+		ref := c.storeAttachment(a, source, dm.GroupInfo, scope)
+		if ref == "" {
+			continue
+		}
+		mediaRefs = append(mediaRefs, ref)
+		content = channels.AppendMediaLabel(content, a.ContentType, attachmentName(a))
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":  "signal",
+		"timestamp": strconv.FormatInt(timestamp, 10),
+	}
+	if env.SourceName != "" {
+		metadata["sender_name"] = env.SourceName
+	}
+	if dm.GroupInfo != nil {
+		metadata["group_id"] = dm.GroupInfo.GroupID
+	}
+
+	logger.DebugCF("signal", "Received message", map[string]any{
+		"from":    source,
+		"chat_id": chatID,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(c.ctx, peer, messageID, source, chatID, content, mediaRefs, metadata, sender)
+}
+
+// resolveMentions replaces the mention placeholders in text: mentions of
+// the bot (as reported by isSelf) are removed and others become "@name".
+func resolveMentions(text string, mentions []mention, isSelf func(mention) bool) string {
+	if len(mentions) == 0 {
+		return text
+	}
+	sorted := append([]mention(nil), mentions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start > sorted[j].Start })
+
+	units := utf16.Encode([]rune(text))
+	for _, m := range sorted {
+		if m.Start < 0 || m.Length < 0 || m.Start+m.Length > len(units) {
+			continue
+		}
+		var replacement []uint16
+		if !isSelf(m) {
+			name := m.Name
+			if name == "" {
+				name = m.Number
+			}
+			replacement = utf16.Encode([]rune("@" + name))
+		}
+		units = append(units[:m.Start], append(replacement, units[m.Start+m.Length:]...)...)
+	}
+	// Removing a mention can leave a double space behind.
+	return strings.TrimSpace(strings.ReplaceAll(string(utf16.Decode(units)), "  ", " "))
+}
+
+// formatMessageID identifies a message by author and timestamp, which is
+// what Signal needs to react to it.
+func formatMessageID(author string, timestamp int64) string {
+	return strconv.FormatInt(timestamp, 10) + "/" + author
+}
+
+func parseMessageID(id string) (author string, timestamp int64, err error) {
+	ts, author, ok := strings.Cut(id, "/")
+	if !ok || author == "" {
+		return "", 0, fmt.Errorf("signal: invalid message ID %q", id)
+	}
+	timestamp, err = strconv.ParseInt(ts, 10, 64)
+	return author, timestamp, err
+}
+
+// storeAttachment fetches an attachment from signal-cli into the media
+// store. It returns "" if that is not possible.
+func (c *SignalChannel) storeAttachment(a attachment, source string, group *groupInfo, scope string) string {
+	if c.GetMediaStore() == nil || a.ID == "" {
+		return ""
+	}
+	params := map[string]any{"id": a.ID}
+	if group != nil && group.GroupID != "" {
+		params["groupId"] = group.GroupID
+	} else {
+		params["recipient"] = source
+	}
+	var result struct {
+		Data string `json:"data"`
+	}
+	if err := c.rpc.call(c.ctx, "getAttachment", c.params(params), &result); err != nil {
+		logger.WarnCF("signal", "Failed to fetch attachment", map[string]any{"id": a.ID, "error": err.Error()})
+		return ""
+	}
+	data, err := base64.StdEncoding.DecodeString(result.Data)
+	if err != nil {
+		logger.WarnCF("signal", "Invalid attachment data", map[string]any{"id": a.ID})
+		return ""
+	}
+
+	return c.StoreMedia(data, attachmentName(a), a.ContentType, scope)
+}
+
+func attachmentName(a attachment) string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return a.ID
+}
+
+// target returns the recipient parameters for a chat ID.
+func (c *SignalChannel) target(chatID string) map[string]any {
+	if groupID, ok := strings.CutPrefix(chatID, groupPrefix); ok {
+		return c.params(map[string]any{"groupId": groupID})
+	}
+	return c.params(map[string]any{"recipient": []string{chatID}})
+}
+
+func (c *SignalChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+	if strings.TrimSpace(msg.Content) == "" {
+		return nil
+	}
+	params := c.target(msg.ChatID)
+	params["message"] = msg.Content
+	return c.send(ctx, msg.ChatID, params)
+}
+
+// SendMedia implements the channels.MediaSender interface. The parts are
+// sent as attachments of one message, with the captions as its text.
+func (c *SignalChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+
+	store := c.GetMediaStore()
+	if store == nil {
+		return fmt.Errorf("no media store available: %w", channels.ErrSendFailed)
+	}
+
+	var captions []string
+	var attachments []string
+	for _, part := range msg.Parts {
+		localPath, meta, err := store.ResolveWithMeta(part.Ref)
+		if err != nil {
+			logger.ErrorCF("signal", "Failed to resolve media ref", map[string]any{
+				"ref":   part.Ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			logger.ErrorCF("signal", "Failed to read media file", map[string]any{"error": err.Error()})
+			continue
+		}
+		filename := part.Filename
+		if filename == "" {
+			filename = meta.Filename
+		}
+		if filename == "" {
+			filename = filepath.Base(localPath)
+		}
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = meta.ContentType
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		// Data URIs work with a daemon on another host, unlike paths.
+		attachments = append(attachments, fmt.Sprintf("data:%s;filename=%s;base64,%s",
+			contentType, filename, base64.StdEncoding.EncodeToString(data)))
+		if part.Caption != "" {
+			captions = append(captions, part.Caption)
+		}
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	params := c.target(msg.ChatID)
+	params["attachments"] = attachments
+	if len(captions) > 0 {
+		params["message"] = strings.Join(captions, "\n")
+	}
+	return c.send(ctx, msg.ChatID, params)
+}
+
+func (c *SignalChannel) send(ctx context.Context, chatID string, params map[string]any) error {
+	if err := c.rpc.call(ctx, "send", params, nil); err != nil {
+		logger.ErrorCF("signal", "Failed to send message", map[string]any{
+			"chat_id": chatID,
+			"error":   err.Error(),
+		})
+		if isRPCError(err) {
+			return fmt.Errorf("signal send: %w: %v", channels.ErrSendFailed, err)
+		}
+		return fmt.Errorf("signal send: %w", channels.ErrTemporary)
+	}
+	return nil
+}
+
+// StartTyping implements channels.TypingCapable. The indicator is
+// refreshed until stop is called or maxTypingDuration passes.
+func (c *SignalChannel) StartTyping(ctx context.Context, chatID string) (func(), error) {
+	if err := c.rpc.call(ctx, "sendTyping", c.target(chatID), nil); err != nil {
+		return func() {}, err
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingRefresh)
+		defer ticker.Stop()
+		timeout := time.After(maxTypingDuration)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-timeout:
+				return
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				c.rpc.call(c.ctx, "sendTyping", c.target(chatID), nil)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopCh)
+			params := c.target(chatID)
+			params["stop"] = true
+			c.rpc.call(c.ctx, "sendTyping", params, nil)
+		})
+	}, nil
+}
+
+// ReactToMessage implements channels.ReactionCapable.
+func (c *SignalChannel) ReactToMessage(ctx context.Context, chatID, messageID string) (func(), error) {
+	author, timestamp, err := parseMessageID(messageID)
+	if err != nil {
+		return func() {}, err
+	}
+	params := c.target(chatID)
+	params["emoji"] = reactionEmoji
+	params["targetAuthor"] = author
+	params["targetTimestamp"] = timestamp
+	if err := c.rpc.call(ctx, "sendReaction", params, nil); err != nil {
+		return func() {}, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			undo := c.target(chatID)
+			undo["emoji"] = reactionEmoji
+			undo["targetAuthor"] = author
+			undo["targetTimestamp"] = timestamp
+			undo["remove"] = true
+			c.rpc.call(c.ctx, "sendReaction", undo, nil)
+		})
+	}, nil
+}
@@ -0,0 +1,478 @@
+package signal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+const (
+	botNumber  = "+15550000001"
+	botUUID    = "b0b0b0b0-0000-4000-8000-000000000001"
+	alice      = "+15550000002"
+	aliceUUID  = "a11ce000-0000-4000-8000-000000000002"
+	mallory    = "+15550000666"
+	testGroup  = "Z3JvdXAtaWQ="
+	groupChat  = groupPrefix + testGroup
+	objReplace = "￼"
+)
+
+type rpcCall struct {
+	Method string
+	Params map[string]any
+}
+
+// fakeDaemon stands in for "signal-cli daemon": it answers JSON-RPC over
+// HTTP (with an SSE event stream) and over a TCP socket.
+type fakeDaemon struct {
+	t      *testing.T
+	http   *httptest.Server
+	socket net.Listener
+
+	mu          sync.Mutex
+	calls       []rpcCall
+	attachments map[string][]byte
+	subscribers []chan []byte
+	failSend    *rpcError
+}
+
+func newFakeDaemon(t *testing.T) *fakeDaemon {
+	d := &fakeDaemon{t: t, attachments: make(map[string][]byte)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/rpc", func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(d.handle(req))
+	})
+	mux.HandleFunc("GET /api/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		events := d.subscribe()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case data := <-events:
+				fmt.Fprintf(w, "event:receive\ndata:%s\n\n", data)
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+	d.http = httptest.NewServer(mux)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.socket = ln
+	go d.acceptSocket()
+
+	t.Cleanup(func() {
+		ln.Close()
+		d.http.CloseClientConnections()
+		d.http.Close()
+	})
+	return d
+}
+
+func (d *fakeDaemon) acceptSocket() {
+	for {
+		nc, err := d.socket.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer nc.Close()
+			var writeMu sync.Mutex
+			write := func(data []byte) {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				nc.Write(append(data, '\n'))
+			}
+			events := d.subscribe()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case data := <-events:
+						write([]byte(`{"jsonrpc":"2.0","method":"receive","params":` + string(data) + `}`))
+					}
+				}
+			}()
+			scanner := bufio.NewScanner(nc)
+			for scanner.Scan() {
+				var req rpcRequest
+				if json.Unmarshal(scanner.Bytes(), &req) == nil {
+					write(d.handle(req))
+				}
+			}
+		}()
+	}
+}
+
+func (d *fakeDaemon) subscribe() chan []byte {
+	ch := make(chan []byte, 10)
+	d.mu.Lock()
+	d.subscribers = append(d.subscribers, ch)
+	d.mu.Unlock()
+	return ch
+}
+
+func (d *fakeDaemon) handle(req rpcRequest) []byte {
+	d.mu.Lock()
+	d.calls = append(d.calls, rpcCall{Method: req.Method, Params: req.Params})
+	failSend := d.failSend
+	d.mu.Unlock()
+
+	var result any = map[string]any{}
+	var rpcErr *rpcError
+	switch req.Method {
+	case "version":
+		result = map[string]string{"version": "0.13.4"}
+	case "getUserStatus":
+		result = []map[string]any{{"number": botNumber, "uuid": botUUID, "isRegistered": true}}
+	case "getAttachment":
+		d.mu.Lock()
+		data, ok := d.attachments[fmt.Sprint(req.Params["id"])]
+		d.mu.Unlock()
+		if ok {
+			result = map[string]string{"data": base64.StdEncoding.EncodeToString(data)}
+		} else {
+			rpcErr = &rpcError{Code: -1, Message: "attachment not found"}
+		}
+	case "send":
+		if failSend != nil {
+			rpcErr = failSend
+		} else {
+			result = map[string]any{"timestamp": time.Now().UnixMilli()}
+		}
+	}
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	data, _ := json.Marshal(resp)
+	return data
+}
+
+// deliver sends a received message to every subscriber.
+func (d *fakeDaemon) deliver(env map[string]any) {
+	data, _ := json.Marshal(map[string]any{"envelope": env, "account": botNumber})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ch := range d.subscribers {
+		ch <- data
+	}
+}
+
+func (d *fakeDaemon) waitSubscribed() {
+	d.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		n := len(d.subscribers)
+		d.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			d.t.Fatal("the channel never subscribed to events")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (d *fakeDaemon) callsTo(method string) []rpcCall {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var calls []rpcCall
+	for _, c := range d.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func dataEnvelope(source, uuid string, ts int64, dm map[string]any) map[string]any {
+	dm["timestamp"] = ts
+	return map[string]any{
+		"source":       source,
+		"sourceNumber": source,
+		"sourceUuid":   uuid,
+		"sourceName":   "Alice",
+		"timestamp":    ts,
+		"dataMessage":  dm,
+	}
+}
+
+func startSignalChannel(t *testing.T, d *fakeDaemon, daemonURL string, cfg config.SignalConfig) (
+	*SignalChannel, *bus.MessageBus,
+) {
+	t.Helper()
+	cfg.Account = botNumber
+	cfg.DaemonURL = daemonURL
+	msgBus := bus.NewMessageBus()
+	ch, err := NewSignalChannel(cfg, msgBus)
+	if err != nil {
+		t.Fatalf("NewSignalChannel: %v", err)
+	}
+	ch.SetMediaStore(media.NewFileMediaStore())
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch.Stop(ctx)
+	})
+	d.waitSubscribed()
+	return ch, msgBus
+}
+
+func nextInbound(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestSignalChannel_ReceiveOverHTTPAndSocket(t *testing.T) {
+	for _, transport := range []string{"http", "tcp"} {
+		t.Run(transport, func(t *testing.T) {
+			d := newFakeDaemon(t)
+			url := d.http.URL
+			if transport == "tcp" {
+				url = "tcp://" + d.socket.Addr().String()
+			}
+			_, msgBus := startSignalChannel(t, d, url, config.SignalConfig{
+				AllowFrom:    config.FlexibleStringSlice{alice},
+				GroupTrigger: config.GroupTriggerConfig{MentionOnly: true},
+			})
+
+			// Ignored: not allowed, our own message, a reaction, and a group
+			// message without a mention.
+			d.deliver(dataEnvelope(mallory, "", 1, map[string]any{"message": "hi"}))
+			d.deliver(dataEnvelope(botNumber, botUUID, 2, map[string]any{"message": "echo"}))
+			d.deliver(dataEnvelope(alice, aliceUUID, 3, map[string]any{"reaction": map[string]any{"emoji": "👍"}}))
+			d.deliver(dataEnvelope(alice, aliceUUID, 4, map[string]any{
+				"message":   "chatting",
+				"groupInfo": map[string]any{"groupId": testGroup, "type": "DELIVER"},
+			}))
+			d.deliver(dataEnvelope(alice, aliceUUID, 5, map[string]any{"message": "hello bot\nsecond line"}))
+
+			msg := nextInbound(t, msgBus)
+			if msg.Channel != "signal" || msg.ChatID != alice || msg.Content != "hello bot\nsecond line" {
+				t.Errorf("inbound = %+v", msg)
+			}
+			if msg.Peer.Kind != "direct" || msg.SenderID != "signal:"+alice || msg.MessageID != "5/"+alice {
+				t.Errorf("peer = %+v, sender = %q, message ID = %q", msg.Peer, msg.SenderID, msg.MessageID)
+			}
+
+			// A mention by UUID in a group; the placeholder is removed and
+			// other mentions are spelled out.
+			d.deliver(dataEnvelope(alice, aliceUUID, 6, map[string]any{
+				"message": objReplace + " please ping " + objReplace + " about 🔥 it",
+				"mentions": []map[string]any{
+					{"uuid": botUUID, "start": 0, "length": 1},
+					{"name": "Bob", "number": "+15550000003", "start": 14, "length": 1},
+				},
+				"groupInfo": map[string]any{"groupId": testGroup, "type": "DELIVER"},
+			}))
+			msg = nextInbound(t, msgBus)
+			if msg.ChatID != groupChat || msg.Peer.Kind != "group" || msg.Content != "please ping @Bob about 🔥 it" {
+				t.Errorf("group inbound = %q in %s (%+v)", msg.Content, msg.ChatID, msg.Peer)
+			}
+			if msg.Metadata["group_id"] != testGroup {
+				t.Errorf("metadata = %v", msg.Metadata)
+			}
+		})
+	}
+}
+
+func TestSignalChannel_SendTypingAndReactions(t *testing.T) {
+	d := newFakeDaemon(t)
+	ch, _ := startSignalChannel(t, d, d.http.URL, config.SignalConfig{})
+	ctx := context.Background()
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: alice, Content: "hi Alice"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: groupChat, Content: "hi group"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	sends := d.callsTo("send")
+	if len(sends) != 2 {
+		t.Fatalf("send calls = %+v", sends)
+	}
+	if r := sends[0].Params["recipient"]; fmt.Sprint(r) != "["+alice+"]" || sends[0].Params["message"] != "hi Alice" {
+		t.Errorf("direct send params = %v", sends[0].Params)
+	}
+	if sends[1].Params["groupId"] != testGroup || sends[1].Params["account"] != botNumber {
+		t.Errorf("group send params = %v", sends[1].Params)
+	}
+
+	d.mu.Lock()
+	d.failSend = &rpcError{Code: -1, Message: "Failed to send message"}
+	d.mu.Unlock()
+	err := ch.Send(ctx, bus.OutboundMessage{ChatID: alice, Content: "lost"})
+	if !errors.Is(err, channels.ErrSendFailed) {
+		t.Errorf("Send with a signal-cli error = %v, want ErrSendFailed", err)
+	}
+
+	stop, err := ch.StartTyping(ctx, groupChat)
+	if err != nil {
+		t.Fatalf("StartTyping: %v", err)
+	}
+	stop()
+	stop()
+	typing := d.callsTo("sendTyping")
+	if len(typing) != 2 || typing[0].Params["stop"] != nil || typing[1].Params["stop"] != true {
+		t.Errorf("sendTyping calls = %+v", typing)
+	}
+
+	undo, err := ch.ReactToMessage(ctx, groupChat, "1700000000123/"+alice)
+	if err != nil {
+		t.Fatalf("ReactToMessage: %v", err)
+	}
+	undo()
+	undo()
+	reactions := d.callsTo("sendReaction")
+	if len(reactions) != 2 {
+		t.Fatalf("sendReaction calls = %+v", reactions)
+	}
+	p := reactions[0].Params
+	if p["targetAuthor"] != alice || p["targetTimestamp"] != float64(1700000000123) || p["emoji"] != reactionEmoji ||
+		p["groupId"] != testGroup {
+		t.Errorf("reaction params = %v", p)
+	}
+	if reactions[1].Params["remove"] != true {
+		t.Errorf("undo params = %v", reactions[1].Params)
+	}
+
+	if _, err := ch.ReactToMessage(ctx, alice, "garbage"); err == nil {
+		t.Error("invalid message ID should be rejected")
+	}
+}
+
+func TestSignalChannel_Attachments(t *testing.T) {
+	d := newFakeDaemon(t)
+	d.attachments["att1.png"] = []byte("PNG DATA")
+	ch, msgBus := startSignalChannel(t, d, "tcp://"+d.socket.Addr().String(), config.SignalConfig{})
+
+	d.deliver(dataEnvelope(alice, aliceUUID, 7, map[string]any{
+		"message": "look",
+		"attachments": []map[string]any{
+			{"id": "att1.png", "contentType": "image/png", "filename": "cat.png", "size": 8},
+			{"id": "missing.bin", "contentType": "application/pdf"},
+		},
+	}))
+	msg := nextInbound(t, msgBus)
+	if msg.Content != "look\n[image: cat.png]" || len(msg.Media) != 1 {
+		t.Fatalf("inbound = %q with media %v", msg.Content, msg.Media)
+	}
+	path, meta, err := ch.GetMediaStore().ResolveWithMeta(msg.Media[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "PNG DATA" || meta.ContentType != "image/png" {
+		t.Errorf("stored attachment = %q (%s)", data, meta.ContentType)
+	}
+	if calls := d.callsTo("getAttachment"); len(calls) != 2 || calls[0].Params["recipient"] != alice {
+		t.Errorf("getAttachment calls = %+v", calls)
+	}
+
+	file := filepath.Join(t.TempDir(), "report.txt")
+	os.WriteFile(file, []byte("report"), 0o600)
+	ref, err := ch.GetMediaStore().Store(file, media.MediaMeta{Filename: "report.txt", ContentType: "text/plain"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.SendMedia(context.Background(), bus.OutboundMediaMessage{
+		ChatID: alice,
+		Parts:  []bus.MediaPart{{Type: "file", Ref: ref, Caption: "Your report"}},
+	}); err != nil {
+		t.Fatalf("SendMedia: %v", err)
+	}
+	sends := d.callsTo("send")
+	if len(sends) != 1 || sends[0].Params["message"] != "Your report" {
+		t.Fatalf("send calls = %+v", sends)
+	}
+	attachments, _ := sends[0].Params["attachments"].([]any)
+	want := "data:text/plain;filename=report.txt;base64," + base64.StdEncoding.EncodeToString([]byte("report"))
+	if len(attachments) != 1 || attachments[0] != want {
+		t.Errorf("attachments = %v, want [%s]", attachments, want)
+	}
+}
+
+func TestSignalChannel_Reconnects(t *testing.T) {
+	d := newFakeDaemon(t)
+	_, msgBus := startSignalChannel(t, d, d.http.URL, config.SignalConfig{})
+
+	// Drop the event stream; the channel subscribes again.
+	d.mu.Lock()
+	d.subscribers = nil
+	d.mu.Unlock()
+	d.http.CloseClientConnections()
+	d.waitSubscribed()
+
+	d.deliver(dataEnvelope(alice, aliceUUID, 8, map[string]any{"message": "still there?"}))
+	if msg := nextInbound(t, msgBus); msg.Content != "still there?" {
+		t.Errorf("inbound after reconnect = %q", msg.Content)
+	}
+}
+
+func TestNewSignalChannel_Validation(t *testing.T) {
+	for _, cfg := range []config.SignalConfig{
+		{DaemonURL: "http://127.0.0.1:8080"},
+		{Account: botNumber},
+		{Account: botNumber, DaemonURL: "ftp://example.com"},
+		{Account: botNumber, DaemonURL: "unix://"},
+	} {
+		if _, err := NewSignalChannel(cfg, bus.NewMessageBus()); err == nil {
+			t.Errorf("NewSignalChannel(%+v) should fail", cfg)
+		}
+	}
+	if _, err := NewSignalChannel(config.SignalConfig{
+		Account:   botNumber,
+		DaemonURL: "unix:///run/signal-cli/socket",
+	}, bus.NewMessageBus()); err != nil {
+		t.Errorf("unix socket URL: %v", err)
+	}
+	if !strings.HasPrefix(formatMessageID(alice, 42), "42/") {
+		t.Error("message IDs start with the timestamp")
+	}
+}
@@ -109,11 +109,29 @@ func (c *SlackChannel) Stop(ctx context.Context) error {
 }
 
 func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	_, threadTS := parseSlackChatID(msg.ChatID)
+	return c.send(ctx, msg, threadTS)
+}
+
+// SendReply implements channels.ThreadCapable. Slack has no inline replies,
+// so answering a message opens (or continues) the thread under it.
+func (c *SlackChannel) SendReply(ctx context.Context, msg bus.OutboundMessage) error {
+	_, threadTS := parseSlackChatID(msg.ChatID)
+	switch {
+	case msg.ThreadID != "":
+		threadTS = msg.ThreadID
+	case threadTS == "":
+		threadTS = msg.ReplyToMessageID
+	}
+	return c.send(ctx, msg, threadTS)
+}
+
+func (c *SlackChannel) send(ctx context.Context, msg bus.OutboundMessage, threadTS string) error {
 	if !c.IsRunning() {
 		return channels.ErrNotRunning
 	}
 
-	channelID, threadTS := parseSlackChatID(msg.ChatID)
+	channelID, _ := parseSlackChatID(msg.ChatID)
 	if channelID == "" {
 		return fmt.Errorf("invalid slack chat ID: %s", msg.ChatID)
 	}
@@ -358,11 +376,12 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 	peer := bus.Peer{Kind: peerKind, ID: peerID}
 
 	metadata := map[string]string{
-		"message_ts": messageTS,
-		"channel_id": channelID,
-		"thread_ts":  threadTS,
-		"platform":   "slack",
-		"team_id":    c.teamID,
+		"message_ts":         messageTS,
+		"channel_id":         channelID,
+		"thread_ts":          threadTS,
+		bus.MetadataThreadID: threadTS,
+		"platform":           "slack",
+		"team_id":            c.teamID,
 	}
 
 	logger.DebugCF("slack", "Received message", map[string]any{
@@ -429,12 +448,13 @@ func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
 	mentionPeer := bus.Peer{Kind: mentionPeerKind, ID: mentionPeerID}
 
 	metadata := map[string]string{
-		"message_ts": messageTS,
-		"channel_id": channelID,
-		"thread_ts":  threadTS,
-		"platform":   "slack",
-		"is_mention": "true",
-		"team_id":    c.teamID,
+		"message_ts":         messageTS,
+		"channel_id":         channelID,
+		"thread_ts":          threadTS,
+		bus.MetadataThreadID: threadTS,
+		"platform":           "slack",
+		"is_mention":         "true",
+		"team_id":            c.teamID,
 	}
 
 	c.HandleMessage(c.ctx, mentionPeer, messageTS, senderID, chatID, content, nil, metadata, mentionSender)
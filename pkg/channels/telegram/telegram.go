@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
@@ -224,6 +225,31 @@ func (c *TelegramChannel) initBotCommands(ctx context.Context) error {
 }
 
 func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	return c.send(ctx, msg, 0, 0)
+}
+
+// SendReply implements channels.ThreadCapable. It quotes the message being
+// answered and posts into the forum topic of ThreadID.
+func (c *TelegramChannel) SendReply(ctx context.Context, msg bus.OutboundMessage) error {
+	var replyTo, threadID int
+	if msg.ReplyToMessageID != "" {
+		id, err := strconv.Atoi(msg.ReplyToMessageID)
+		if err != nil {
+			return fmt.Errorf("invalid reply-to message ID %s: %w", msg.ReplyToMessageID, channels.ErrSendFailed)
+		}
+		replyTo = id
+	}
+	if msg.ThreadID != "" {
+		id, err := strconv.Atoi(msg.ThreadID)
+		if err != nil {
+			return fmt.Errorf("invalid thread ID %s: %w", msg.ThreadID, channels.ErrSendFailed)
+		}
+		threadID = id
+	}
+	return c.send(ctx, msg, replyTo, threadID)
+}
+
+func (c *TelegramChannel) send(ctx context.Context, msg bus.OutboundMessage, replyTo, threadID int) error {
 	if !c.IsRunning() {
 		return channels.ErrNotRunning
 	}
@@ -238,6 +264,11 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	// Typing/placeholder handled by Manager.preSend — just send the message
 	tgMsg := tu.Message(tu.ID(chatID), htmlContent)
 	tgMsg.ParseMode = telego.ModeHTML
+	tgMsg.MessageThreadID = threadID
+	if replyTo != 0 {
+		// The reply still goes out if the original was deleted meanwhile.
+		tgMsg.ReplyParameters = &telego.ReplyParameters{MessageID: replyTo, AllowSendingWithoutReply: true}
+	}
 
 	if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
 		logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]any{
@@ -326,6 +357,17 @@ func (c *TelegramChannel) SendPlaceholder(ctx context.Context, chatID string) (s
 	return fmt.Sprintf("%d", pMsg.MessageID), nil
 }
 
+// isVoiceNote reports whether an audio part is Ogg/Opus, which Telegram
+// shows as a playable voice message rather than a music file.
+func isVoiceNote(part bus.MediaPart) bool {
+	ct := strings.ToLower(part.ContentType)
+	if ct == "audio/ogg" || ct == "audio/opus" {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(part.Filename))
+	return ext == ".ogg" || ext == ".opus" || ext == ".oga"
+}
+
 // SendMedia implements the channels.MediaSender interface.
 func (c *TelegramChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMessage) error {
 	if !c.IsRunning() {
@@ -370,6 +412,14 @@ func (c *TelegramChannel) SendMedia(ctx context.Context, msg bus.OutboundMediaMe
 			}
 			_, err = c.bot.SendPhoto(ctx, params)
 		case "audio":
+			if isVoiceNote(part) {
+				_, err = c.bot.SendVoice(ctx, &telego.SendVoiceParams{
+					ChatID:  tu.ID(chatID),
+					Voice:   telego.InputFile{File: file},
+					Caption: part.Caption,
+				})
+				break
+			}
 			params := &telego.SendAudioParams{
 				ChatID:  tu.ID(chatID),
 				Audio:   telego.InputFile{File: file},
@@ -555,6 +605,22 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 		"first_name": user.FirstName,
 		"is_group":   fmt.Sprintf("%t", message.Chat.Type != "private"),
 	}
+	if message.IsTopicMessage {
+		metadata[bus.MetadataThreadID] = fmt.Sprintf("%d", message.MessageThreadID)
+	}
+	// In forum topics every message replies to the topic's service message;
+	// only real replies are quoted.
+	if parent := message.ReplyToMessage; parent != nil && parent.ForumTopicCreated == nil {
+		metadata[bus.MetadataReplyToMessageID] = fmt.Sprintf("%d", parent.MessageID)
+		if parent.Text != "" {
+			metadata[bus.MetadataReplyToText] = parent.Text
+		} else if parent.Caption != "" {
+			metadata[bus.MetadataReplyToText] = parent.Caption
+		}
+		if parent.From != nil {
+			metadata[bus.MetadataReplyToSender] = parent.From.FirstName
+		}
+	}
 
 	c.HandleMessage(c.ctx,
 		peer,
@@ -0,0 +1,331 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	pathPrefix = "/hooks/"
+	// maxBodySize bounds webhook payloads.
+	maxBodySize = 1 << 20
+	// defaultContent forwards the payload unchanged.
+	defaultContent = "{{raw}}"
+)
+
+// endpoint is a configured webhook endpoint with its templates parsed.
+type endpoint struct {
+	config   config.WebhookEndpointConfig
+	content  *template.Template
+	chatID   *template.Template
+	senderID *template.Template
+	metadata map[string]*template.Template
+}
+
+// WebhookChannel turns HTTP requests on configured endpoints into inbound
+// messages.
+type WebhookChannel struct {
+	*channels.BaseChannel
+	bus       *bus.MessageBus
+	endpoints map[string]*endpoint
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewWebhookChannel creates the channel, parsing every endpoint's templates.
+func NewWebhookChannel(cfg config.WebhookConfig, messageBus *bus.MessageBus) (*WebhookChannel, error) {
+	endpoints := make(map[string]*endpoint, len(cfg.Endpoints))
+	for _, epCfg := range cfg.Endpoints {
+		if epCfg.Name == "" || strings.Contains(epCfg.Name, "/") {
+			return nil, fmt.Errorf("webhook endpoint name %q is invalid", epCfg.Name)
+		}
+		if _, dup := endpoints[epCfg.Name]; dup {
+			return nil, fmt.Errorf("webhook endpoint %q is defined twice", epCfg.Name)
+		}
+		if epCfg.SignatureHeader != "" && epCfg.Secret == "" {
+			return nil, fmt.Errorf("webhook endpoint %q: signature_header requires a secret", epCfg.Name)
+		}
+		if epCfg.Secret == "" && !epCfg.AllowUnauthenticated {
+			return nil, fmt.Errorf("webhook endpoint %q requires a secret (or allow_unauthenticated: true)",
+				epCfg.Name)
+		}
+		ep, err := newEndpoint(epCfg)
+		if err != nil {
+			return nil, fmt.Errorf("webhook endpoint %q: %w", epCfg.Name, err)
+		}
+		endpoints[epCfg.Name] = ep
+	}
+
+	return &WebhookChannel{
+		BaseChannel: channels.NewBaseChannel("webhook", cfg, messageBus, nil),
+		bus:         messageBus,
+		endpoints:   endpoints,
+	}, nil
+}
+
+func newEndpoint(cfg config.WebhookEndpointConfig) (*endpoint, error) {
+	ep := &endpoint{config: cfg, metadata: make(map[string]*template.Template, len(cfg.Metadata))}
+	parse := func(name, text, fallback string) (*template.Template, error) {
+		if text == "" {
+			text = fallback
+		}
+		t, err := template.New(name).Funcs(requestFuncs(nil, nil)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s template: %w", name, err)
+		}
+		return t, nil
+	}
+	var err error
+	if ep.content, err = parse("content", cfg.Content, defaultContent); err != nil {
+		return nil, err
+	}
+	if ep.chatID, err = parse("chat_id", cfg.ChatID, cfg.Name); err != nil {
+		return nil, err
+	}
+	if ep.senderID, err = parse("sender_id", cfg.SenderID, cfg.Name); err != nil {
+		return nil, err
+	}
+	for key, text := range cfg.Metadata {
+		if ep.metadata[key], err = parse("metadata."+key, text, ""); err != nil {
+			return nil, err
+		}
+	}
+	return ep, nil
+}
+
+// requestFuncs returns the template functions. header and query read the
+// request being handled; r is nil while templates are parsed.
+func requestFuncs(r *http.Request, body []byte) template.FuncMap {
+	return template.FuncMap{
+		"header": func(name string) string {
+			if r == nil {
+				return ""
+			}
+			return r.Header.Get(name)
+		},
+		"query": func(name string) string {
+			if r == nil {
+				return ""
+			}
+			return r.URL.Query().Get(name)
+		},
+		"raw": func() string { return string(body) },
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"default": func(fallback string, v any) string {
+			if v == nil {
+				return fallback
+			}
+			if s := fmt.Sprint(v); s != "" {
+				return s
+			}
+			return fallback
+		},
+	}
+}
+
+// Start implements Channel.
+func (c *WebhookChannel) Start(ctx context.Context) error {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	for name, ep := range c.endpoints {
+		if ep.config.AllowUnauthenticated && ep.config.Secret == "" {
+			logger.WarnCF("webhook", "Webhook endpoint accepts unauthenticated requests", map[string]any{
+				"endpoint": name,
+			})
+		}
+	}
+	c.SetRunning(true)
+	logger.InfoCF("webhook", "Webhook channel started", map[string]any{
+		"endpoints": len(c.endpoints),
+	})
+	return nil
+}
+
+// Stop implements Channel.
+func (c *WebhookChannel) Stop(ctx context.Context) error {
+	c.SetRunning(false)
+	if c.cancel != nil {
+		c.cancel()
+	}
+	logger.InfoC("webhook", "Webhook channel stopped")
+	return nil
+}
+
+// WebhookPath implements channels.WebhookHandler.
+func (c *WebhookChannel) WebhookPath() string { return pathPrefix }
+
+// ServeHTTP implements http.Handler for the shared HTTP server.
+func (c *WebhookChannel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ep, ok := c.endpoints[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.IsRunning() {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !ep.verify(r, body) {
+		logger.WarnCF("webhook", "Rejected webhook request", map[string]any{
+			"endpoint": ep.config.Name,
+			"remote":   r.RemoteAddr,
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	msg, err := ep.render(r, body)
+	if err != nil {
+		logger.WarnCF("webhook", "Failed to map webhook payload", map[string]any{
+			"endpoint": ep.config.Name,
+			"error":    err.Error(),
+		})
+		http.Error(w, "Unprocessable payload: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if strings.TrimSpace(msg.content) == "" {
+		// Templates may filter out events by rendering nothing.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	messageID := uuid.New().String()
+	chatID := ep.config.Name + "/" + msg.chatID
+	msg.metadata["webhook"] = ep.config.Name
+	c.HandleMessage(c.ctx, bus.Peer{Kind: "channel", ID: chatID}, messageID, msg.senderID, chatID,
+		msg.content, nil, msg.metadata)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify checks the request's signature or shared secret. Endpoints without
+// a secret accept every request only if allow_unauthenticated is set.
+func (ep *endpoint) verify(r *http.Request, body []byte) bool {
+	secret := ep.config.Secret
+	if secret == "" {
+		return ep.config.AllowUnauthenticated
+	}
+	if ep.config.SignatureHeader != "" {
+		signature := r.Header.Get(ep.config.SignatureHeader)
+		signature = strings.TrimPrefix(signature, "sha256=")
+		if signature == "" {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		sum := mac.Sum(nil)
+		return hmac.Equal([]byte(strings.ToLower(signature)), []byte(hex.EncodeToString(sum))) ||
+			hmac.Equal([]byte(signature), []byte(base64.StdEncoding.EncodeToString(sum)))
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.Header.Get("X-Webhook-Secret")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+type rendered struct {
+	content  string
+	chatID   string
+	senderID string
+	metadata map[string]string
+}
+
+// render executes the endpoint's templates against the payload. JSON bodies
+// are decoded; other bodies are passed as a string.
+func (ep *endpoint) render(r *http.Request, body []byte) (*rendered, error) {
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		payload = string(body)
+	}
+	funcs := requestFuncs(r, body)
+	exec := func(t *template.Template) (string, error) {
+		clone, err := t.Clone()
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := clone.Funcs(funcs).Option("missingkey=zero").Execute(&buf, payload); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	out := &rendered{metadata: make(map[string]string, len(ep.metadata)+1)}
+	var err error
+	if out.content, err = exec(ep.content); err != nil {
+		return nil, err
+	}
+	if out.chatID, err = exec(ep.chatID); err != nil {
+		return nil, err
+	}
+	if out.chatID == "" || out.chatID == "<no value>" {
+		out.chatID = ep.config.Name
+	}
+	if out.senderID, err = exec(ep.senderID); err != nil {
+		return nil, err
+	}
+	if out.senderID == "" || out.senderID == "<no value>" {
+		out.senderID = ep.config.Name
+	}
+	for key, t := range ep.metadata {
+		if out.metadata[key], err = exec(t); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Send implements Channel by forwarding the agent's reply to the endpoint's
+// reply target. Replies for endpoints without one are dropped.
+func (c *WebhookChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return channels.ErrNotRunning
+	}
+	name, _, _ := strings.Cut(msg.ChatID, "/")
+	ep, ok := c.endpoints[name]
+	if !ok || ep.config.ReplyChannel == "" || ep.config.ReplyChatID == "" {
+		logger.DebugCF("webhook", "Dropping reply without a reply target", map[string]any{
+			"chat_id": msg.ChatID,
+		})
+		return nil
+	}
+	if ep.config.ReplyChannel == c.Name() {
+		return fmt.Errorf("%w: webhook replies cannot target the webhook channel", channels.ErrSendFailed)
+	}
+	return c.bus.PublishOutbound(ctx, bus.OutboundMessage{
+		Channel: ep.config.ReplyChannel,
+		ChatID:  ep.config.ReplyChatID,
+		Content: msg.Content,
+	})
+}
@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestChannel(t *testing.T, endpoints ...config.WebhookEndpointConfig) (*WebhookChannel, *bus.MessageBus) {
+	t.Helper()
+	msgBus := bus.NewMessageBus()
+	ch, err := NewWebhookChannel(config.WebhookConfig{Enabled: true, Endpoints: endpoints}, msgBus)
+	if err != nil {
+		t.Fatalf("NewWebhookChannel: %v", err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+	return ch, msgBus
+}
+
+func serve(ch *WebhookChannel, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	ch.ServeHTTP(rec, req)
+	return rec
+}
+
+func consume(t *testing.T, msgBus *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestTemplatedMapping(t *testing.T) {
+	ch, msgBus := newTestChannel(t, config.WebhookEndpointConfig{
+		Name:                 "grafana",
+		AllowUnauthenticated: true,
+		Content:              `Alert {{.title}} is {{.state}} ({{header "X-Source"}})`,
+		ChatID:               "{{.ruleId}}",
+		Metadata:             map[string]string{"state": "{{.state}}"},
+	})
+
+	rec := serve(ch, "/hooks/grafana", `{"title":"CPU","state":"alerting","ruleId":7}`,
+		map[string]string{"X-Source": "prod"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	msg := consume(t, msgBus)
+	if msg.Content != "Alert CPU is alerting (prod)" {
+		t.Errorf("Content = %q", msg.Content)
+	}
+	if msg.ChatID != "grafana/7" || msg.Peer.Kind != "channel" {
+		t.Errorf("ChatID = %q, Peer = %+v", msg.ChatID, msg.Peer)
+	}
+	if msg.Metadata["state"] != "alerting" || msg.Metadata["webhook"] != "grafana" {
+		t.Errorf("Metadata = %v", msg.Metadata)
+	}
+}
+
+func TestDefaultContentForwardsPayload(t *testing.T) {
+	ch, msgBus := newTestChannel(t, config.WebhookEndpointConfig{Name: "raw", AllowUnauthenticated: true})
+
+	serve(ch, "/hooks/raw", `{"a":1}`, nil)
+	if msg := consume(t, msgBus); msg.Content != `{"a":1}` || msg.ChatID != "raw/raw" {
+		t.Errorf("got %q in %q", msg.Content, msg.ChatID)
+	}
+}
+
+func TestHMACSignature(t *testing.T) {
+	ch, msgBus := newTestChannel(t, config.WebhookEndpointConfig{
+		Name:            "github",
+		Secret:          "s3cret",
+		SignatureHeader: "X-Hub-Signature-256",
+		Content:         "{{.action}}",
+	})
+	body := `{"action":"opened"}`
+
+	if rec := serve(ch, "/hooks/github", body, map[string]string{
+		"X-Hub-Signature-256": sign("wrong", body),
+	}); rec.Code != http.StatusForbidden {
+		t.Fatalf("bad signature: status = %d, want 403", rec.Code)
+	}
+
+	if rec := serve(ch, "/hooks/github", body, map[string]string{
+		"X-Hub-Signature-256": sign("s3cret", body),
+	}); rec.Code != http.StatusAccepted {
+		t.Fatalf("good signature: status = %d", rec.Code)
+	}
+	if msg := consume(t, msgBus); msg.Content != "opened" {
+		t.Errorf("Content = %q", msg.Content)
+	}
+}
+
+func TestSharedSecret(t *testing.T) {
+	ch, _ := newTestChannel(t, config.WebhookEndpointConfig{Name: "ha", Secret: "token"})
+
+	if rec := serve(ch, "/hooks/ha", `{}`, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("missing secret: status = %d, want 403", rec.Code)
+	}
+	if rec := serve(ch, "/hooks/ha", `{}`, map[string]string{"Authorization": "Bearer token"}); rec.Code !=
+		http.StatusAccepted {
+		t.Fatalf("bearer: status = %d", rec.Code)
+	}
+	if rec := serve(ch, "/hooks/ha", `{}`, map[string]string{"X-Webhook-Secret": "token"}); rec.Code !=
+		http.StatusAccepted {
+		t.Fatalf("header: status = %d", rec.Code)
+	}
+}
+
+func TestEmptyContentIsSkipped(t *testing.T) {
+	ch, _ := newTestChannel(t, config.WebhookEndpointConfig{
+		Name:                 "filter",
+		AllowUnauthenticated: true,
+		Content:              `{{if eq .state "ok"}}{{else}}{{.state}}{{end}}`,
+	})
+
+	if rec := serve(ch, "/hooks/filter", `{"state":"ok"}`, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rec.Code)
+	}
+}
+
+func TestUnknownEndpoint(t *testing.T) {
+	ch, _ := newTestChannel(t, config.WebhookEndpointConfig{Name: "a", Secret: "token"})
+
+	if rec := serve(ch, "/hooks/b", `{}`, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSendForwardsToReplyTarget(t *testing.T) {
+	ch, msgBus := newTestChannel(t,
+		config.WebhookEndpointConfig{Name: "alerts", Secret: "token", ReplyChannel: "telegram", ReplyChatID: "42"},
+		config.WebhookEndpointConfig{Name: "silent", Secret: "token"},
+	)
+	ctx := context.Background()
+
+	if err := ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: "silent/x", Content: "dropped"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: "alerts/7", Content: "ack"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	subCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(subCtx)
+	if !ok {
+		t.Fatal("no outbound message")
+	}
+	if out.Channel != "telegram" || out.ChatID != "42" || out.Content != "ack" {
+		t.Errorf("forwarded %+v", out)
+	}
+}
+
+func TestInvalidTemplateIsRejected(t *testing.T) {
+	_, err := NewWebhookChannel(config.WebhookConfig{Endpoints: []config.WebhookEndpointConfig{
+		{Name: "bad", Secret: "token", Content: "{{.x"},
+	}}, bus.NewMessageBus())
+	if err == nil {
+		t.Fatal("expected template parse error")
+	}
+}
+
+func TestEndpointWithoutSecretIsRejected(t *testing.T) {
+	_, err := NewWebhookChannel(config.WebhookConfig{Endpoints: []config.WebhookEndpointConfig{
+		{Name: "open"},
+	}}, bus.NewMessageBus())
+	if err == nil || !strings.Contains(err.Error(), "requires a secret") {
+		t.Fatalf("err = %v, want a missing secret error", err)
+	}
+}
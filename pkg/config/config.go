@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync/atomic"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 
@@ -58,6 +60,9 @@ type Config struct {
 	Tools     ToolsConfig     `json:"tools"`
 	Heartbeat HeartbeatConfig `json:"heartbeat"`
 	Devices   DevicesConfig   `json:"devices"`
+	Quotas    QuotaConfig     `json:"quotas,omitempty"`
+	Redaction RedactionConfig `json:"redaction"`
+	Voice     VoiceConfig     `json:"voice"`
 }
 
 // MarshalJSON implements custom JSON marshaling for Config
@@ -78,7 +83,7 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	}
 
 	// Only include session if not empty
-	if c.Session.DMScope != "" || len(c.Session.IdentityLinks) > 0 {
+	if c.Session.DMScope != "" || len(c.Session.IdentityLinks) > 0 || c.Session.Backend != "" {
 		aux.Session = &c.Session
 	}
 
@@ -165,24 +170,123 @@ type AgentBinding struct {
 type SessionConfig struct {
 	DMScope       string              `json:"dm_scope,omitempty"`
 	IdentityLinks map[string][]string `json:"identity_links,omitempty"`
-}
+	// Backend selects where session history is persisted: "json" (default,
+	// one JSON file per session), "jsonl" (append-only JSONL files) or
+	// "sqlite" (a single sessions.db). Existing sessions are migrated
+	// automatically when switching to "jsonl" or "sqlite".
+	Backend string `json:"backend,omitempty" env:"PICOCLAW_SESSION_BACKEND"`
+}
+
+// Session storage backends accepted in SessionConfig.Backend.
+const (
+	SessionBackendJSON   = "json"
+	SessionBackendJSONL  = "jsonl"
+	SessionBackendSQLite = "sqlite"
+)
 
 type AgentDefaults struct {
-	Workspace                 string   `json:"workspace"                       env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
-	RestrictToWorkspace       bool     `json:"restrict_to_workspace"           env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
-	AllowReadOutsideWorkspace bool     `json:"allow_read_outside_workspace"    env:"PICOCLAW_AGENTS_DEFAULTS_ALLOW_READ_OUTSIDE_WORKSPACE"`
-	Provider                  string   `json:"provider"                        env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
-	ModelName                 string   `json:"model_name,omitempty"            env:"PICOCLAW_AGENTS_DEFAULTS_MODEL_NAME"`
-	Model                     string   `json:"model"                           env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"` // Deprecated: use model_name instead
-	ModelFallbacks            []string `json:"model_fallbacks,omitempty"`
-	ImageModel                string   `json:"image_model,omitempty"           env:"PICOCLAW_AGENTS_DEFAULTS_IMAGE_MODEL"`
-	ImageModelFallbacks       []string `json:"image_model_fallbacks,omitempty"`
-	MaxTokens                 int      `json:"max_tokens"                      env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
-	Temperature               *float64 `json:"temperature,omitempty"           env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
-	MaxToolIterations         int      `json:"max_tool_iterations"             env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
-	SummarizeMessageThreshold int      `json:"summarize_message_threshold"     env:"PICOCLAW_AGENTS_DEFAULTS_SUMMARIZE_MESSAGE_THRESHOLD"`
-	SummarizeTokenPercent     int      `json:"summarize_token_percent"         env:"PICOCLAW_AGENTS_DEFAULTS_SUMMARIZE_TOKEN_PERCENT"`
-	MaxMediaSize              int      `json:"max_media_size,omitempty"        env:"PICOCLAW_AGENTS_DEFAULTS_MAX_MEDIA_SIZE"`
+	Workspace                 string               `json:"workspace"                       env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
+	RestrictToWorkspace       bool                 `json:"restrict_to_workspace"           env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
+	AllowReadOutsideWorkspace bool                 `json:"allow_read_outside_workspace"    env:"PICOCLAW_AGENTS_DEFAULTS_ALLOW_READ_OUTSIDE_WORKSPACE"`
+	Provider                  string               `json:"provider"                        env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
+	ModelName                 string               `json:"model_name,omitempty"            env:"PICOCLAW_AGENTS_DEFAULTS_MODEL_NAME"`
+	Model                     string               `json:"model"                           env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"` // Deprecated: use model_name instead
+	ModelFallbacks            []string             `json:"model_fallbacks,omitempty"`
+	ImageModel                string               `json:"image_model,omitempty"           env:"PICOCLAW_AGENTS_DEFAULTS_IMAGE_MODEL"`
+	ImageModelFallbacks       []string             `json:"image_model_fallbacks,omitempty"`
+	MaxTokens                 int                  `json:"max_tokens"                      env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
+	Temperature               *float64             `json:"temperature,omitempty"           env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
+	MaxToolIterations         int                  `json:"max_tool_iterations"             env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	MaxParallelTools          int                  `json:"max_parallel_tools,omitempty"    env:"PICOCLAW_AGENTS_DEFAULTS_MAX_PARALLEL_TOOLS"`
+	SummarizeMessageThreshold int                  `json:"summarize_message_threshold"     env:"PICOCLAW_AGENTS_DEFAULTS_SUMMARIZE_MESSAGE_THRESHOLD"`
+	SummarizeTokenPercent     int                  `json:"summarize_token_percent"         env:"PICOCLAW_AGENTS_DEFAULTS_SUMMARIZE_TOKEN_PERCENT"`
+	MaxMediaSize              int                  `json:"max_media_size,omitempty"        env:"PICOCLAW_AGENTS_DEFAULTS_MAX_MEDIA_SIZE"`
+	Streaming                 StreamingConfig      `json:"streaming,omitempty"`
+	SemanticMemory            SemanticMemoryConfig `json:"semantic_memory,omitempty"`
+	Routing                   RoutingConfig        `json:"routing,omitempty"`
+}
+
+// StreamingConfig controls progressive delivery of LLM output. When enabled,
+// replies are streamed from providers that support it and the channel's
+// placeholder message is edited as text arrives.
+type StreamingConfig struct {
+	Enabled bool `json:"enabled"                      env:"PICOCLAW_AGENTS_DEFAULTS_STREAMING_ENABLED"`
+	// UpdateIntervalMS is the minimum delay between placeholder edits.
+	UpdateIntervalMS int `json:"update_interval_ms,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_STREAMING_UPDATE_INTERVAL_MS"`
+}
+
+const defaultStreamingUpdateInterval = 1000 // ms
+
+// GetUpdateInterval returns the minimum delay between streamed placeholder edits.
+func (s StreamingConfig) GetUpdateInterval() time.Duration {
+	if s.UpdateIntervalMS > 0 {
+		return time.Duration(s.UpdateIntervalMS) * time.Millisecond
+	}
+	return defaultStreamingUpdateInterval * time.Millisecond
+}
+
+// SemanticMemoryConfig enables embedding-based long-term memory. Instead of
+// injecting all of MEMORY.md into every prompt, the memories most relevant
+// to the current message are retrieved from a local vector index.
+type SemanticMemoryConfig struct {
+	Enabled bool `json:"enabled"                   env:"PICOCLAW_AGENTS_DEFAULTS_SEMANTIC_MEMORY_ENABLED"`
+	// EmbeddingModel is the model_name of a model_list entry serving an
+	// OpenAI-compatible /embeddings endpoint.
+	EmbeddingModel string  `json:"embedding_model"           env:"PICOCLAW_AGENTS_DEFAULTS_SEMANTIC_MEMORY_EMBEDDING_MODEL"`
+	TopK           int     `json:"top_k,omitempty"           env:"PICOCLAW_AGENTS_DEFAULTS_SEMANTIC_MEMORY_TOP_K"`
+	MinScore       float64 `json:"min_score,omitempty"       env:"PICOCLAW_AGENTS_DEFAULTS_SEMANTIC_MEMORY_MIN_SCORE"`
+}
+
+const defaultSemanticMemoryTopK = 5
+
+// GetTopK returns the number of memories injected into each prompt.
+func (s SemanticMemoryConfig) GetTopK() int {
+	if s.TopK > 0 {
+		return s.TopK
+	}
+	return defaultSemanticMemoryTopK
+}
+
+// RoutingConfig enables per-turn model routing between model_list entries.
+// Each turn, an explicit "/model <name>" hint wins; otherwise the rules are
+// checked in order and the first match picks the model. When no rule
+// matches, the optional classifier model chooses, and failing that the
+// agent's own model answers.
+type RoutingConfig struct {
+	Enabled bool          `json:"enabled"                    env:"PICOCLAW_AGENTS_DEFAULTS_ROUTING_ENABLED"`
+	Rules   []RoutingRule `json:"rules,omitempty"`
+	// Classifier asks a cheap model to choose when no rule matches.
+	Classifier *RoutingClassifierConfig `json:"classifier,omitempty"`
+	// EscalationModel takes over a turn when the routed model fails or uses
+	// up max_tool_iterations without answering.
+	EscalationModel string `json:"escalation_model,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_ROUTING_ESCALATION_MODEL"`
+}
+
+// RoutingRule routes a turn to Model when all of its set conditions hold.
+type RoutingRule struct {
+	Name  string `json:"name,omitempty"` // reported as the routing reason
+	Model string `json:"model"`
+	// MinLength and MaxLength bound the message length in characters.
+	MinLength int   `json:"min_length,omitempty"`
+	MaxLength int   `json:"max_length,omitempty"`
+	HasMedia  *bool `json:"has_media,omitempty"`
+	// MinToolCalls matches tool-heavy conversations: the number of tool
+	// results among the most recent history messages.
+	MinToolCalls int      `json:"min_tool_calls,omitempty"`
+	Keywords     []string `json:"keywords,omitempty"` // any of, case-insensitive
+	Pattern      string   `json:"pattern,omitempty"`  // regular expression
+}
+
+// RoutingClassifierConfig configures the model that picks among Choices.
+type RoutingClassifierConfig struct {
+	Model   string          `json:"model"`
+	Choices []RoutingChoice `json:"choices"`
+}
+
+// RoutingChoice is a model the classifier may pick, and when to pick it.
+type RoutingChoice struct {
+	Model       string `json:"model"`
+	Description string `json:"description"`
 }
 
 const DefaultMaxMediaSize = 20 * 1024 * 1024 // 20 MB
@@ -218,6 +322,14 @@ type ChannelsConfig struct {
 	WeComApp   WeComAppConfig   `json:"wecom_app"`
 	WeComAIBot WeComAIBotConfig `json:"wecom_aibot"`
 	Pico       PicoConfig       `json:"pico"`
+	Email      EmailConfig      `json:"email"`
+	Matrix     MatrixConfig     `json:"matrix"`
+	IRC        IRCConfig        `json:"irc"`
+	Signal     SignalConfig     `json:"signal"`
+	Mattermost MattermostConfig `json:"mattermost"`
+	RocketChat RocketChatConfig `json:"rocketchat"`
+	OpenAIAPI  OpenAIAPIConfig  `json:"openai_api"`
+	Webhook    WebhookConfig    `json:"webhook"`
 }
 
 // GroupTriggerConfig controls when the bot responds in group chats.
@@ -401,6 +513,188 @@ type PicoConfig struct {
 	Placeholder     PlaceholderConfig   `json:"placeholder,omitempty"`
 }
 
+// EmailConfig configures the email channel: incoming mail is read over IMAP
+// and replies are sent over SMTP with the same account.
+type EmailConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_CHANNELS_EMAIL_ENABLED"`
+	// IMAPSecurity and SMTPSecurity are "tls" (implicit TLS), "starttls" or
+	// "none". They default to "tls" for IMAP and "starttls" for SMTP.
+	IMAPHost     string `json:"imap_host"               env:"PICOCLAW_CHANNELS_EMAIL_IMAP_HOST"`
+	IMAPPort     int    `json:"imap_port"               env:"PICOCLAW_CHANNELS_EMAIL_IMAP_PORT"`
+	IMAPSecurity string `json:"imap_security,omitempty" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_SECURITY"`
+	SMTPHost     string `json:"smtp_host"               env:"PICOCLAW_CHANNELS_EMAIL_SMTP_HOST"`
+	SMTPPort     int    `json:"smtp_port"               env:"PICOCLAW_CHANNELS_EMAIL_SMTP_PORT"`
+	SMTPSecurity string `json:"smtp_security,omitempty" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_SECURITY"`
+	Username     string `json:"username"                env:"PICOCLAW_CHANNELS_EMAIL_USERNAME"`
+	Password     string `json:"password"                env:"PICOCLAW_CHANNELS_EMAIL_PASSWORD"`
+	// FromAddress defaults to Username.
+	FromAddress string `json:"from_address,omitempty" env:"PICOCLAW_CHANNELS_EMAIL_FROM_ADDRESS"`
+	FromName    string `json:"from_name,omitempty"    env:"PICOCLAW_CHANNELS_EMAIL_FROM_NAME"`
+	Mailbox     string `json:"mailbox,omitempty"      env:"PICOCLAW_CHANNELS_EMAIL_MAILBOX"`
+	// PollInterval is the seconds between mailbox checks when the server
+	// does not support IDLE or UseIDLE is off.
+	PollInterval       int                 `json:"poll_interval,omitempty"  env:"PICOCLAW_CHANNELS_EMAIL_POLL_INTERVAL"`
+	UseIDLE            bool                `json:"use_idle"                 env:"PICOCLAW_CHANNELS_EMAIL_USE_IDLE"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"               env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_FROM"`
+	ReasoningChannelID string              `json:"reasoning_channel_id"     env:"PICOCLAW_CHANNELS_EMAIL_REASONING_CHANNEL_ID"`
+	// AuthServID is the authserv-id of the receiving mail server. Only mail
+	// whose SPF, DKIM or DMARC check passed in its Authentication-Results
+	// header is accepted; when empty, the topmost such header is used.
+	AuthServID string `json:"auth_serv_id,omitempty" env:"PICOCLAW_CHANNELS_EMAIL_AUTH_SERV_ID"`
+}
+
+// MatrixConfig configures the Matrix channel. It authenticates with
+// AccessToken, or logs in once with UserID and Password and keeps the
+// session in StorePath.
+type MatrixConfig struct {
+	Enabled     bool   `json:"enabled"             env:"PICOCLAW_CHANNELS_MATRIX_ENABLED"`
+	Homeserver  string `json:"homeserver"          env:"PICOCLAW_CHANNELS_MATRIX_HOMESERVER"`
+	UserID      string `json:"user_id"             env:"PICOCLAW_CHANNELS_MATRIX_USER_ID"`
+	AccessToken string `json:"access_token"        env:"PICOCLAW_CHANNELS_MATRIX_ACCESS_TOKEN"`
+	Password    string `json:"password,omitempty"  env:"PICOCLAW_CHANNELS_MATRIX_PASSWORD"`
+	DeviceID    string `json:"device_id,omitempty" env:"PICOCLAW_CHANNELS_MATRIX_DEVICE_ID"`
+	// StorePath defaults to <workspace>/matrix.
+	StorePath string `json:"store_path,omitempty" env:"PICOCLAW_CHANNELS_MATRIX_STORE_PATH"`
+	// AutoJoin accepts room invites from allowed users.
+	AutoJoin           bool                `json:"auto_join"               env:"PICOCLAW_CHANNELS_MATRIX_AUTO_JOIN"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"              env:"PICOCLAW_CHANNELS_MATRIX_ALLOW_FROM"`
+	GroupTrigger       GroupTriggerConfig  `json:"group_trigger,omitempty"`
+	Placeholder        PlaceholderConfig   `json:"placeholder,omitempty"`
+	ReasoningChannelID string              `json:"reasoning_channel_id"    env:"PICOCLAW_CHANNELS_MATRIX_REASONING_CHANNEL_ID"`
+}
+
+// IRCConfig configures the IRC channel, which can be on several networks
+// at once. AllowFrom entries are services accounts, "<network>/<account>"
+// or "irc:<network>/<account>"; senders that are not logged in are
+// rejected when it is set.
+type IRCConfig struct {
+	Enabled            bool                `json:"enabled"              env:"PICOCLAW_CHANNELS_IRC_ENABLED"`
+	Networks           []IRCNetworkConfig  `json:"networks"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"           env:"PICOCLAW_CHANNELS_IRC_ALLOW_FROM"`
+	GroupTrigger       GroupTriggerConfig  `json:"group_trigger,omitempty"`
+	ReasoningChannelID string              `json:"reasoning_channel_id" env:"PICOCLAW_CHANNELS_IRC_REASONING_CHANNEL_ID"`
+}
+
+// IRCNetworkConfig is one IRC network. Server is "host" or "host:port";
+// the port defaults to 6697 with TLS and 6667 without.
+type IRCNetworkConfig struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	TLS      bool   `json:"tls"`
+	Nick     string `json:"nick"`
+	Username string `json:"username,omitempty"`
+	RealName string `json:"real_name,omitempty"`
+	// Password is the server password (PASS).
+	Password string `json:"password,omitempty"`
+	// SASLMechanism is "PLAIN" or "EXTERNAL". EXTERNAL authenticates with
+	// the TLS client certificate in TLSCertFile and TLSKeyFile.
+	SASLMechanism    string `json:"sasl_mechanism,omitempty"`
+	SASLUsername     string `json:"sasl_username,omitempty"`
+	SASLPassword     string `json:"sasl_password,omitempty"`
+	TLSCertFile      string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile       string `json:"tls_key_file,omitempty"`
+	NickServPassword string `json:"nickserv_password,omitempty"`
+	// Channels to join, as "#name" or "#name key".
+	Channels []string `json:"channels"`
+	// FloodBurst lines are sent at once, then one every FloodIntervalMs.
+	FloodBurst      int `json:"flood_burst,omitempty"`
+	FloodIntervalMs int `json:"flood_interval_ms,omitempty"`
+}
+
+// SignalConfig configures the Signal channel, which talks to a signal-cli
+// daemon registered as Account. DaemonURL is http://host:port for
+// "signal-cli daemon --http", tcp://host:port for "--tcp" or unix:///path
+// for "--socket".
+type SignalConfig struct {
+	Enabled            bool                `json:"enabled"              env:"PICOCLAW_CHANNELS_SIGNAL_ENABLED"`
+	Account            string              `json:"account"              env:"PICOCLAW_CHANNELS_SIGNAL_ACCOUNT"`
+	DaemonURL          string              `json:"daemon_url"           env:"PICOCLAW_CHANNELS_SIGNAL_DAEMON_URL"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"           env:"PICOCLAW_CHANNELS_SIGNAL_ALLOW_FROM"`
+	GroupTrigger       GroupTriggerConfig  `json:"group_trigger,omitempty"`
+	ReasoningChannelID string              `json:"reasoning_channel_id" env:"PICOCLAW_CHANNELS_SIGNAL_REASONING_CHANNEL_ID"`
+}
+
+// MattermostConfig configures the Mattermost channel. Token is a bot or
+// personal access token. AllowFrom entries are user IDs or usernames.
+type MattermostConfig struct {
+	Enabled            bool                `json:"enabled"              env:"PICOCLAW_CHANNELS_MATTERMOST_ENABLED"`
+	ServerURL          string              `json:"server_url"           env:"PICOCLAW_CHANNELS_MATTERMOST_SERVER_URL"`
+	Token              string              `json:"token"                env:"PICOCLAW_CHANNELS_MATTERMOST_TOKEN"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"           env:"PICOCLAW_CHANNELS_MATTERMOST_ALLOW_FROM"`
+	GroupTrigger       GroupTriggerConfig  `json:"group_trigger,omitempty"`
+	Placeholder        PlaceholderConfig   `json:"placeholder,omitempty"`
+	ReasoningChannelID string              `json:"reasoning_channel_id" env:"PICOCLAW_CHANNELS_MATTERMOST_REASONING_CHANNEL_ID"`
+}
+
+// RocketChatConfig configures the Rocket.Chat channel. It authenticates
+// with a personal access token (UserID and AuthToken) or, without one, with
+// Username and Password. AllowFrom entries are user IDs or usernames.
+type RocketChatConfig struct {
+	Enabled            bool                `json:"enabled"              env:"PICOCLAW_CHANNELS_ROCKETCHAT_ENABLED"`
+	ServerURL          string              `json:"server_url"           env:"PICOCLAW_CHANNELS_ROCKETCHAT_SERVER_URL"`
+	UserID             string              `json:"user_id"              env:"PICOCLAW_CHANNELS_ROCKETCHAT_USER_ID"`
+	AuthToken          string              `json:"auth_token"           env:"PICOCLAW_CHANNELS_ROCKETCHAT_AUTH_TOKEN"`
+	Username           string              `json:"username,omitempty"   env:"PICOCLAW_CHANNELS_ROCKETCHAT_USERNAME"`
+	Password           string              `json:"password,omitempty"   env:"PICOCLAW_CHANNELS_ROCKETCHAT_PASSWORD"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from"           env:"PICOCLAW_CHANNELS_ROCKETCHAT_ALLOW_FROM"`
+	GroupTrigger       GroupTriggerConfig  `json:"group_trigger,omitempty"`
+	Placeholder        PlaceholderConfig   `json:"placeholder,omitempty"`
+	ReasoningChannelID string              `json:"reasoning_channel_id" env:"PICOCLAW_CHANNELS_ROCKETCHAT_REASONING_CHANNEL_ID"`
+}
+
+// OpenAIAPIConfig configures the OpenAI-compatible Chat Completions API served
+// by the gateway under /v1/. Agent IDs are exposed as model names and the
+// request's user field selects the conversation. RequestTimeout is in seconds.
+//
+// Callers holding the shared Token are not identified: their user field is
+// only a session label. A token in Users identifies its user, and only that
+// user's requests can reach their session.
+type OpenAIAPIConfig struct {
+	Enabled        bool            `json:"enabled"                   env:"PICOCLAW_CHANNELS_OPENAI_API_ENABLED"`
+	Token          string          `json:"token"                     env:"PICOCLAW_CHANNELS_OPENAI_API_TOKEN"`
+	Users          []OpenAIAPIUser `json:"users,omitempty"`
+	RequestTimeout int             `json:"request_timeout,omitempty" env:"PICOCLAW_CHANNELS_OPENAI_API_REQUEST_TIMEOUT"`
+}
+
+// OpenAIAPIUser is an API user with their own bearer token.
+type OpenAIAPIUser struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// WebhookConfig configures the generic inbound webhook channel. Each endpoint
+// is served at /hooks/<name> on the gateway.
+type WebhookConfig struct {
+	Enabled   bool                    `json:"enabled"   env:"PICOCLAW_CHANNELS_WEBHOOK_ENABLED"`
+	Endpoints []WebhookEndpointConfig `json:"endpoints"`
+}
+
+// WebhookEndpointConfig maps the payload of one webhook endpoint to an inbound
+// message. Content, ChatID, SenderID and Metadata values are Go templates
+// executed against the decoded JSON body.
+//
+// With SignatureHeader set, requests must carry an HMAC-SHA256 of the body
+// keyed with Secret in that header (hex or base64, optionally prefixed with
+// "sha256="). Otherwise Secret must be sent as a bearer token or in the
+// X-Webhook-Secret header. Endpoints without a Secret are rejected unless
+// AllowUnauthenticated is set, which lets anyone who can reach the gateway
+// start agent turns.
+//
+// The agent's reply is forwarded to ReplyChannel/ReplyChatID when set and
+// dropped otherwise.
+type WebhookEndpointConfig struct {
+	Name                 string            `json:"name"`
+	Secret               string            `json:"secret,omitempty"`
+	SignatureHeader      string            `json:"signature_header,omitempty"`
+	AllowUnauthenticated bool              `json:"allow_unauthenticated,omitempty"`
+	Content              string            `json:"content,omitempty"`
+	ChatID               string            `json:"chat_id,omitempty"`
+	SenderID             string            `json:"sender_id,omitempty"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	ReplyChannel         string            `json:"reply_channel,omitempty"`
+	ReplyChatID          string            `json:"reply_chat_id,omitempty"`
+}
+
 type HeartbeatConfig struct {
 	Enabled  bool `json:"enabled"  env:"PICOCLAW_HEARTBEAT_ENABLED"`
 	Interval int  `json:"interval" env:"PICOCLAW_HEARTBEAT_INTERVAL"` // minutes, min 5
@@ -505,6 +799,40 @@ type ModelConfig struct {
 	RPM            int    `json:"rpm,omitempty"`              // Requests per minute limit
 	MaxTokensField string `json:"max_tokens_field,omitempty"` // Field name for max tokens (e.g., "max_completion_tokens")
 	RequestTimeout int    `json:"request_timeout,omitempty"`
+
+	// Ollama
+	KeepAlive string `json:"keep_alive,omitempty"` // How long the model stays loaded after a request (e.g. "30m", "-1")
+	NumCtx    int    `json:"num_ctx,omitempty"`    // Context window size requested from the server
+
+	// Gemini
+	SafetySettings []GeminiSafetySetting `json:"safety_settings,omitempty"`
+	Thinking       *GeminiThinkingConfig `json:"thinking,omitempty"`
+
+	// Pricing is used to compute the cost of calls in the usage ledger.
+	Pricing *ModelPricing `json:"pricing,omitempty"`
+}
+
+// GeminiSafetySetting overrides the blocking threshold of one harm category,
+// e.g. {"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_ONLY_HIGH"}.
+type GeminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+// GeminiThinkingConfig controls the reasoning of Gemini thinking models.
+// Budget is a token budget (0 disables thinking, -1 lets the model decide);
+// Level ("low", "high") is used by models that take a thinking level instead.
+type GeminiThinkingConfig struct {
+	Budget          *int   `json:"budget,omitempty"`
+	Level           string `json:"level,omitempty"`
+	IncludeThoughts bool   `json:"include_thoughts,omitempty"`
+}
+
+// ModelPricing is the price of a model per million tokens, in whatever
+// currency the operator bills in.
+type ModelPricing struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
 }
 
 // Validate checks if the ModelConfig has all required fields.
@@ -518,6 +846,113 @@ func (c *ModelConfig) Validate() error {
 	return nil
 }
 
+// RedactionConfig controls the removal of secrets from logs, tool results
+// and outbound messages. API keys and tokens from this config and from the
+// auth store are always redacted when enabled; common credential formats
+// (private keys, JWTs, provider API keys) are detected by pattern.
+// read_file results are exempt, so that edits do not write placeholders
+// back into files; their contents are still redacted from logs and
+// outbound messages.
+type RedactionConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_REDACTION_ENABLED"`
+	// Emails and PhoneNumbers also redact personal contact data.
+	Emails       bool `json:"emails"        env:"PICOCLAW_REDACTION_EMAILS"`
+	PhoneNumbers bool `json:"phone_numbers" env:"PICOCLAW_REDACTION_PHONE_NUMBERS"`
+	// Patterns are extra regular expressions to redact.
+	Patterns []string `json:"patterns,omitempty"`
+	// Secrets are extra literal values to redact.
+	Secrets FlexibleStringSlice `json:"secrets,omitempty"`
+}
+
+// VoiceConfig configures speech processing for voice messages.
+type VoiceConfig struct {
+	Transcription TranscriptionConfig `json:"transcription"`
+	Speech        SpeechConfig        `json:"speech"`
+}
+
+// TranscriptionConfig selects the speech-to-text backend that turns inbound
+// voice and audio messages into text for the agent.
+type TranscriptionConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_VOICE_TRANSCRIPTION_ENABLED"`
+	// Provider is "groq", "openai" (any OpenAI-compatible
+	// /audio/transcriptions endpoint) or "command" (a local recognizer).
+	Provider string `json:"provider" env:"PICOCLAW_VOICE_TRANSCRIPTION_PROVIDER"`
+	// APIBase and APIKey default to the Groq provider settings for "groq".
+	APIBase  string `json:"api_base,omitempty" env:"PICOCLAW_VOICE_TRANSCRIPTION_API_BASE"`
+	APIKey   string `json:"api_key,omitempty"  env:"PICOCLAW_VOICE_TRANSCRIPTION_API_KEY"`
+	Model    string `json:"model,omitempty"    env:"PICOCLAW_VOICE_TRANSCRIPTION_MODEL"`
+	Language string `json:"language,omitempty" env:"PICOCLAW_VOICE_TRANSCRIPTION_LANGUAGE"`
+	// Command and Args run a local recognizer such as whisper.cpp; "{file}"
+	// in Args is replaced by the audio file path.
+	Command        string   `json:"command,omitempty"         env:"PICOCLAW_VOICE_TRANSCRIPTION_COMMAND"`
+	Args           []string `json:"args,omitempty"            env:"PICOCLAW_VOICE_TRANSCRIPTION_ARGS"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty" env:"PICOCLAW_VOICE_TRANSCRIPTION_TIMEOUT_SECONDS"`
+}
+
+// SpeechConfig configures text-to-speech for replies delivered as voice
+// messages on channels that can send media.
+type SpeechConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_VOICE_SPEECH_ENABLED"`
+	// Provider is "openai" (any OpenAI-compatible /audio/speech endpoint)
+	// or "command" (a local engine such as piper or espeak-ng).
+	Provider string `json:"provider" env:"PICOCLAW_VOICE_SPEECH_PROVIDER"`
+	// APIBase and APIKey default to the OpenAI provider settings.
+	APIBase string `json:"api_base,omitempty" env:"PICOCLAW_VOICE_SPEECH_API_BASE"`
+	APIKey  string `json:"api_key,omitempty"  env:"PICOCLAW_VOICE_SPEECH_API_KEY"`
+	Model   string `json:"model,omitempty"    env:"PICOCLAW_VOICE_SPEECH_MODEL"`
+	Voice   string `json:"voice,omitempty"    env:"PICOCLAW_VOICE_SPEECH_VOICE"`
+	// Format is the audio format: "opus" (default for openai), "mp3",
+	// "aac", "flac" or "wav" (default for command).
+	Format string `json:"format,omitempty" env:"PICOCLAW_VOICE_SPEECH_FORMAT"`
+	// Command and Args run a local engine; "{text}" and "{output}" in Args
+	// are replaced by the text and the output file. Without them, the text
+	// is written to stdin and the audio read from stdout.
+	Command        string   `json:"command,omitempty"         env:"PICOCLAW_VOICE_SPEECH_COMMAND"`
+	Args           []string `json:"args,omitempty"            env:"PICOCLAW_VOICE_SPEECH_ARGS"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty" env:"PICOCLAW_VOICE_SPEECH_TIMEOUT_SECONDS"`
+	// Reply is when replies are spoken: "off", "on" (always) or "auto"
+	// (when the user sent a voice message). Chats can override it with the
+	// /voice command.
+	Reply string `json:"reply" env:"PICOCLAW_VOICE_SPEECH_REPLY"`
+	// Channels overrides Reply per channel, e.g. {"telegram": "on"}.
+	Channels map[string]string `json:"channels,omitempty"`
+}
+
+// QuotaConfig limits how much each sender and each chat may use the agent.
+// Limits of zero are not enforced. Internal channels (cli, system,
+// subagent) are never limited.
+type QuotaConfig struct {
+	Enabled bool        `json:"enabled"                           env:"PICOCLAW_QUOTAS_ENABLED"`
+	PerUser QuotaLimits `json:"per_user"`
+	PerChat QuotaLimits `json:"per_chat"`
+	// MaxToolCallsPerTurn caps tool executions while answering one message.
+	MaxToolCallsPerTurn int `json:"max_tool_calls_per_turn,omitempty" env:"PICOCLAW_QUOTAS_MAX_TOOL_CALLS_PER_TURN"`
+	// Exempt lists senders not subject to quotas, in allow_from format
+	// ("123456", "@alice", "telegram:123456").
+	Exempt FlexibleStringSlice `json:"exempt,omitempty"`
+}
+
+// QuotaLimits are the limits applied to a single sender or chat.
+type QuotaLimits struct {
+	// MessagesPerWindow is the number of messages allowed per WindowSeconds
+	// (default window: one hour).
+	MessagesPerWindow int `json:"messages_per_window,omitempty"`
+	WindowSeconds     int `json:"window_seconds,omitempty"`
+	// TokensPerDay is the number of LLM tokens (prompt + completion)
+	// allowed per calendar day.
+	TokensPerDay int `json:"tokens_per_day,omitempty"`
+}
+
+const defaultQuotaWindow = time.Hour
+
+// GetWindow returns the message rate window.
+func (l QuotaLimits) GetWindow() time.Duration {
+	if l.WindowSeconds > 0 {
+		return time.Duration(l.WindowSeconds) * time.Second
+	}
+	return defaultQuotaWindow
+}
+
 type GatewayConfig struct {
 	Host string `json:"host" env:"PICOCLAW_GATEWAY_HOST"`
 	Port int    `json:"port" env:"PICOCLAW_GATEWAY_PORT"`
@@ -586,14 +1021,70 @@ type MediaCleanupConfig struct {
 }
 
 type ToolsConfig struct {
-	AllowReadPaths  []string           `json:"allow_read_paths"  env:"PICOCLAW_TOOLS_ALLOW_READ_PATHS"`
-	AllowWritePaths []string           `json:"allow_write_paths" env:"PICOCLAW_TOOLS_ALLOW_WRITE_PATHS"`
-	Web             WebToolsConfig     `json:"web"`
-	Cron            CronToolsConfig    `json:"cron"`
-	Exec            ExecConfig         `json:"exec"`
-	Skills          SkillsToolsConfig  `json:"skills"`
-	MediaCleanup    MediaCleanupConfig `json:"media_cleanup"`
-	MCP             MCPConfig          `json:"mcp"`
+	AllowReadPaths  []string            `json:"allow_read_paths"  env:"PICOCLAW_TOOLS_ALLOW_READ_PATHS"`
+	AllowWritePaths []string            `json:"allow_write_paths" env:"PICOCLAW_TOOLS_ALLOW_WRITE_PATHS"`
+	Web             WebToolsConfig      `json:"web"`
+	Cron            CronToolsConfig     `json:"cron"`
+	Exec            ExecConfig          `json:"exec"`
+	Skills          SkillsToolsConfig   `json:"skills"`
+	MediaCleanup    MediaCleanupConfig  `json:"media_cleanup"`
+	MCP             MCPConfig           `json:"mcp"`
+	HistorySearch   HistorySearchConfig `json:"history_search"`
+	Approval        ApprovalConfig      `json:"approval"`
+	Audit           AuditConfig         `json:"audit"`
+	Egress          EgressConfig        `json:"egress"`
+}
+
+// EgressConfig restricts the hosts web_fetch, skill registries and MCP HTTP
+// servers may connect to.
+type EgressConfig struct {
+	// BlockPrivate blocks loopback, private, link-local and cloud metadata addresses.
+	BlockPrivate bool `json:"block_private" env:"PICOCLAW_TOOLS_EGRESS_BLOCK_PRIVATE"`
+	// AllowCIDRs are address ranges that stay reachable when BlockPrivate is
+	// set, e.g. "192.168.1.20/32" for a home server.
+	AllowCIDRs []string `json:"allow_cidrs" env:"PICOCLAW_TOOLS_EGRESS_ALLOW_CIDRS"`
+	// AllowDomains, if not empty, are the only domains (and their
+	// subdomains) that may be reached.
+	AllowDomains []string `json:"allow_domains" env:"PICOCLAW_TOOLS_EGRESS_ALLOW_DOMAINS"`
+	// DenyDomains are never reached, subdomains included.
+	DenyDomains []string `json:"deny_domains" env:"PICOCLAW_TOOLS_EGRESS_DENY_DOMAINS"`
+}
+
+// AuditConfig controls the audit log of tool executions. The log is a JSONL
+// file rotated when it reaches MaxSizeMB; MaxBackups rotated files are kept.
+type AuditConfig struct {
+	Enabled    bool   `json:"enabled"     env:"PICOCLAW_TOOLS_AUDIT_ENABLED"`
+	Path       string `json:"path"        env:"PICOCLAW_TOOLS_AUDIT_PATH"`
+	MaxSizeMB  int    `json:"max_size_mb" env:"PICOCLAW_TOOLS_AUDIT_MAX_SIZE_MB"`
+	MaxBackups int    `json:"max_backups" env:"PICOCLAW_TOOLS_AUDIT_MAX_BACKUPS"`
+}
+
+// ApprovalConfig makes selected tools wait for a human to reply
+// "/approve <id>" or "/deny <id>" before they run.
+type ApprovalConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_TOOLS_APPROVAL_ENABLED"`
+	// Tools lists the tools that need approval: a tool name ("exec"), a
+	// name prefix ("mcp_*") or a tool action ("i2c:write").
+	Tools          []string `json:"tools"           env:"PICOCLAW_TOOLS_APPROVAL_TOOLS"`
+	TimeoutSeconds int      `json:"timeout_seconds" env:"PICOCLAW_TOOLS_APPROVAL_TIMEOUT_SECONDS"`
+	// AdminChannel and AdminChatID send every prompt to one chat instead of
+	// the chat the tool call came from.
+	AdminChannel string `json:"admin_channel,omitempty" env:"PICOCLAW_TOOLS_APPROVAL_ADMIN_CHANNEL"`
+	AdminChatID  string `json:"admin_chat_id,omitempty" env:"PICOCLAW_TOOLS_APPROVAL_ADMIN_CHAT_ID"`
+	// Approvers limits who may decide, in allow_from format. Empty: anyone
+	// in the prompt chat.
+	Approvers FlexibleStringSlice `json:"approvers,omitempty"`
+}
+
+// HistorySearchConfig controls the per-agent conversation history index and
+// the history_search tool that queries it. The tool only searches the
+// current conversation unless AllowCrossChat is set, which lets anyone who
+// talks to the agent search (and have the model quote) every other chat's
+// history.
+type HistorySearchConfig struct {
+	Enabled        bool `json:"enabled"                    env:"PICOCLAW_TOOLS_HISTORY_SEARCH_ENABLED"`
+	MaxResults     int  `json:"max_results"                env:"PICOCLAW_TOOLS_HISTORY_SEARCH_MAX_RESULTS"`
+	AllowCrossChat bool `json:"allow_cross_chat,omitempty" env:"PICOCLAW_TOOLS_HISTORY_SEARCH_ALLOW_CROSS_CHAT"`
 }
 
 type SkillsToolsConfig struct {
@@ -727,6 +1218,15 @@ func (c *Config) WorkspacePath() string {
 	return expandHome(c.Agents.Defaults.Workspace)
 }
 
+// AuditLogPath returns the tool audit log path, defaulting to
+// state/audit.jsonl in the workspace.
+func (c *Config) AuditLogPath() string {
+	if c.Tools.Audit.Path != "" {
+		return expandHome(c.Tools.Audit.Path)
+	}
+	return filepath.Join(c.WorkspacePath(), "state", "audit.jsonl")
+}
+
 func (c *Config) GetAPIKey() string {
 	if c.Providers.OpenRouter.APIKey != "" {
 		return c.Providers.OpenRouter.APIKey
@@ -33,8 +33,18 @@ func DefaultConfig() *Config {
 				MaxTokens:                 32768,
 				Temperature:               nil, // nil means use provider default
 				MaxToolIterations:         50,
+				MaxParallelTools:          4,
 				SummarizeMessageThreshold: 20,
 				SummarizeTokenPercent:     75,
+				Streaming: StreamingConfig{
+					Enabled:          false,
+					UpdateIntervalMS: 1000,
+				},
+				SemanticMemory: SemanticMemoryConfig{
+					Enabled:  false,
+					TopK:     5,
+					MinScore: 0.3,
+				},
 			},
 		},
 		Bindings: []AgentBinding{},
@@ -158,6 +168,49 @@ func DefaultConfig() *Config {
 				MaxConnections: 100,
 				AllowFrom:      FlexibleStringSlice{},
 			},
+			Email: EmailConfig{
+				Enabled:      false,
+				IMAPPort:     993,
+				IMAPSecurity: "tls",
+				SMTPPort:     587,
+				SMTPSecurity: "starttls",
+				Mailbox:      "INBOX",
+				PollInterval: 60,
+				UseIDLE:      true,
+				AllowFrom:    FlexibleStringSlice{},
+			},
+			Matrix: MatrixConfig{
+				Enabled:    false,
+				Homeserver: "https://matrix.org",
+				AutoJoin:   true,
+				AllowFrom:  FlexibleStringSlice{},
+			},
+			IRC: IRCConfig{
+				Enabled:   false,
+				AllowFrom: FlexibleStringSlice{},
+			},
+			Signal: SignalConfig{
+				Enabled:   false,
+				DaemonURL: "http://127.0.0.1:8080",
+				AllowFrom: FlexibleStringSlice{},
+			},
+			Mattermost: MattermostConfig{
+				Enabled:      false,
+				AllowFrom:    FlexibleStringSlice{},
+				GroupTrigger: GroupTriggerConfig{MentionOnly: true},
+			},
+			RocketChat: RocketChatConfig{
+				Enabled:      false,
+				AllowFrom:    FlexibleStringSlice{},
+				GroupTrigger: GroupTriggerConfig{MentionOnly: true},
+			},
+			OpenAIAPI: OpenAIAPIConfig{
+				Enabled:        false,
+				RequestTimeout: 300,
+			},
+			Webhook: WebhookConfig{
+				Enabled: false,
+			},
 		},
 		Providers: ProvidersConfig{
 			OpenAI: OpenAIProviderConfig{WebSearch: true},
@@ -296,7 +349,7 @@ func DefaultConfig() *Config {
 			{
 				ModelName: "llama3",
 				Model:     "ollama/llama3",
-				APIBase:   "http://localhost:11434/v1",
+				APIBase:   "http://localhost:11434",
 				APIKey:    "ollama",
 			},
 
@@ -374,6 +427,26 @@ func DefaultConfig() *Config {
 				Enabled: false,
 				Servers: map[string]MCPServerConfig{},
 			},
+			HistorySearch: HistorySearchConfig{
+				Enabled:    true,
+				MaxResults: 10,
+			},
+			Approval: ApprovalConfig{
+				Enabled:        false,
+				Tools:          []string{"exec", "write_file", "edit_file", "mcp_*", "i2c:write", "spi:transfer"},
+				TimeoutSeconds: 300,
+			},
+			Audit: AuditConfig{
+				Enabled: true,
+				// Outside the workspace, so file tools restricted to the
+				// workspace cannot alter it.
+				Path:       filepath.Join(homePath, "audit", "tools.jsonl"),
+				MaxSizeMB:  10,
+				MaxBackups: 5,
+			},
+			Egress: EgressConfig{
+				BlockPrivate: true,
+			},
 		},
 		Heartbeat: HeartbeatConfig{
 			Enabled:  true,
@@ -383,5 +456,19 @@ func DefaultConfig() *Config {
 			Enabled:    false,
 			MonitorUSB: true,
 		},
+		Redaction: RedactionConfig{
+			Enabled: true,
+		},
+		Voice: VoiceConfig{
+			Transcription: TranscriptionConfig{
+				Enabled:  true,
+				Provider: "groq",
+			},
+			Speech: SpeechConfig{
+				Enabled:  false,
+				Provider: "openai",
+				Reply:    "auto",
+			},
+		},
 	}
 }
@@ -14,3 +14,15 @@ func IsInternalChannel(channel string) bool {
 	_, found := internalChannels[channel]
 	return found
 }
+
+// requestChannels answer each request with the agent's final reply. Users of
+// these channels cannot reply while a turn runs, e.g. to an approval prompt.
+var requestChannels = map[string]struct{}{
+	"openai_api": {},
+}
+
+// IsRequestChannel returns true if the channel answers one request per turn.
+func IsRequestChannel(channel string) bool {
+	_, found := requestChannels[channel]
+	return found
+}
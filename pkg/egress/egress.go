@@ -0,0 +1,323 @@
+// Package egress restricts the hosts outbound HTTP requests may reach.
+//
+// A Policy blocks loopback, private, link-local and cloud metadata addresses
+// (so a model cannot make web_fetch read http://169.254.169.254/ or a router's
+// admin page) and applies allow/deny domain lists. Addresses are checked when
+// the connection is dialed, after DNS resolution, so a hostname that resolves
+// to a private address is rejected as well. Redirects are checked again.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// ErrBlocked is returned (wrapped) for requests the policy does not allow.
+var ErrBlocked = errors.New("egress: destination blocked by policy")
+
+// blockedPrefixes are the non-public ranges blocked by BlockPrivate in
+// addition to what netip.Addr reports as loopback, private (RFC 1918, ULA),
+// link-local (incl. the 169.254.169.254 metadata service), multicast or
+// unspecified.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, incl. Alibaba Cloud metadata
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, incl. broadcast
+	netip.MustParsePrefix("100::/64"),      // discard-only
+	netip.MustParsePrefix("2001::/32"),     // Teredo
+	netip.MustParsePrefix("fec0::/10"),     // deprecated site-local
+}
+
+// Translated IPv6 ranges that embed the IPv4 address the packet ends up at.
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// Policy decides which destinations outbound requests may reach. A nil
+// *Policy allows everything. Policies are immutable and safe for concurrent use.
+type Policy struct {
+	blockPrivate bool
+	allowNets    []netip.Prefix
+	allowDomains []string
+	denyDomains  []string
+	// trusted hosts were configured by the operator (an MCP server URL, a
+	// skill registry) and skip all checks.
+	trusted map[string]bool
+	// resolver looks up hosts reached through a proxy; see RoundTrip.
+	resolver *net.Resolver
+}
+
+// NewPolicy builds a Policy from cfg. It fails on malformed CIDRs.
+func NewPolicy(cfg config.EgressConfig) (*Policy, error) {
+	p := &Policy{
+		blockPrivate: cfg.BlockPrivate,
+		allowDomains: normalizeDomains(cfg.AllowDomains),
+		denyDomains:  normalizeDomains(cfg.DenyDomains),
+		resolver:     net.DefaultResolver,
+	}
+	for _, s := range cfg.AllowCIDRs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("egress: invalid allow_cidrs entry %q: %w", s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.allowNets = append(p.allowNets, prefix.Masked())
+	}
+	return p, nil
+}
+
+// DefaultPolicy blocks private addresses and has no domain lists.
+func DefaultPolicy() *Policy {
+	return &Policy{blockPrivate: true, resolver: net.DefaultResolver}
+}
+
+func normalizeDomains(domains []string) []string {
+	out := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		d = strings.TrimPrefix(d, "*.")
+		if d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// matchDomain reports whether host is one of domains or a subdomain of one.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trust returns a copy of p that lets requests to hosts through without
+// checks. Use it for endpoints the operator configured explicitly, such as
+// an MCP server on localhost; redirects away from them are still checked.
+func (p *Policy) Trust(hosts ...string) *Policy {
+	if p == nil {
+		return nil
+	}
+	cp := *p
+	cp.trusted = make(map[string]bool, len(p.trusted)+len(hosts))
+	for h := range p.trusted {
+		cp.trusted[h] = true
+	}
+	for _, h := range hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			cp.trusted[h] = true
+		}
+	}
+	return &cp
+}
+
+func (p *Policy) isTrusted(host string) bool {
+	return p != nil && p.trusted[strings.ToLower(host)]
+}
+
+// CheckURL checks the scheme and host of u against the domain lists, and a
+// literal IP host against the address rules. Hostnames are resolved and
+// checked when the connection is dialed.
+func (p *Policy) CheckURL(u *url.URL) error {
+	if p == nil {
+		return nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrBlocked, u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrBlocked)
+	}
+	if p.isTrusted(host) {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.CheckAddr(addr)
+	}
+	if matchDomain(host, p.denyDomains) {
+		return fmt.Errorf("%w: %s is on the deny list", ErrBlocked, host)
+	}
+	if len(p.allowDomains) > 0 && !matchDomain(host, p.allowDomains) {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrBlocked, host)
+	}
+	if p.blockPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return fmt.Errorf("%w: %s is a local address", ErrBlocked, host)
+	}
+	return nil
+}
+
+// CheckAddr reports whether connecting to addr is allowed.
+func (p *Policy) CheckAddr(addr netip.Addr) error {
+	if p == nil || !p.blockPrivate {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, n := range p.allowNets {
+		if n.Contains(addr) {
+			return nil
+		}
+	}
+	if isBlocked(addr) {
+		return fmt.Errorf("%w: %s is a private or reserved address", ErrBlocked, addr)
+	}
+	return nil
+}
+
+func isBlocked(addr netip.Addr) bool {
+	if addr.Is6() {
+		b := addr.As16()
+		switch {
+		case nat64Prefix.Contains(addr):
+			return isBlocked(netip.AddrFrom4([4]byte(b[12:16])))
+		case sixToFourPrefix.Contains(addr):
+			return isBlocked(netip.AddrFrom4([4]byte(b[2:6])))
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, n := range blockedPrefixes {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResolved resolves host and checks every address it resolves to.
+func (p *Policy) checkResolved(ctx context.Context, host string) error {
+	if !p.blockPrivate {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.CheckAddr(addr)
+	}
+	addrs, err := p.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("egress: resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.CheckAddr(addr); err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// control runs for every connection attempt with the resolved address, so
+// DNS answers that change between a check and the dial do not matter.
+func (p *Policy) control(ctx context.Context, _, address string, _ syscall.RawConn) error {
+	if ctx.Value(uncheckedKey{}) != nil {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %q", ErrBlocked, address)
+	}
+	return p.CheckAddr(addrPort.Addr())
+}
+
+// uncheckedKey marks dials that must not be checked: connections to a
+// proxy, and to trusted hosts.
+type uncheckedKey struct{}
+
+// Transport returns a RoundTripper that enforces p on top of base. base is
+// cloned; its DialContext is replaced by a dialer that checks every address
+// it connects to. A nil policy returns base unchanged.
+func (p *Policy) Transport(base *http.Transport) http.RoundTripper {
+	if p == nil {
+		return base
+	}
+	t := base.Clone()
+	dialer := &net.Dialer{
+		Timeout:        30 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: p.control,
+	}
+	t.DialContext = dialer.DialContext
+	return &guardedTransport{policy: p, base: t}
+}
+
+type guardedTransport struct {
+	policy *Policy
+	base   *http.Transport
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if t.policy.isTrusted(req.URL.Hostname()) {
+		return t.base.RoundTrip(req.WithContext(context.WithValue(ctx, uncheckedKey{}, true)))
+	}
+	if err := t.policy.CheckURL(req.URL); err != nil {
+		return nil, err
+	}
+	if t.base.Proxy != nil {
+		proxyURL, err := t.base.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			// The proxy connects to the target on our behalf, so the dial
+			// only sees the proxy. Check what the target resolves to now.
+			if err := t.policy.checkResolved(ctx, req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			req = req.WithContext(context.WithValue(ctx, uncheckedKey{}, true))
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// CheckRedirect returns a redirect policy that checks every redirect target
+// against p and stops after maxRedirects hops.
+func (p *Policy) CheckRedirect(maxRedirects int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if p.isTrusted(req.URL.Hostname()) {
+			return nil
+		}
+		return p.CheckURL(req.URL)
+	}
+}
+
+// Client returns a copy of c that enforces p. c.Transport must be nil (the
+// default transport is used) or an *http.Transport. A nil policy returns c.
+func (p *Policy) Client(c *http.Client, maxRedirects int) *http.Client {
+	if p == nil {
+		return c
+	}
+	cp := *c
+	base, ok := c.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	cp.Transport = p.Transport(base)
+	cp.CheckRedirect = p.CheckRedirect(maxRedirects)
+	return &cp
+}
@@ -0,0 +1,175 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func mustPolicy(t *testing.T, cfg config.EgressConfig) *Policy {
+	t.Helper()
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return p
+}
+
+func TestCheckAddr(t *testing.T) {
+	p := DefaultPolicy()
+	blocked := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.100.100.200",
+		"0.0.0.0", "255.255.255.255", "224.0.0.1", "::1", "fe80::1", "fd00:ec2::254", "::ffff:127.0.0.1",
+		"64:ff9b::a9fe:a9fe", "2002:c0a8:0101::1",
+	}
+	for _, s := range blocked {
+		if err := p.CheckAddr(netip.MustParseAddr(s)); !errors.Is(err, ErrBlocked) {
+			t.Errorf("%s should be blocked, got %v", s, err)
+		}
+	}
+	for _, s := range []string{"1.1.1.1", "93.184.216.34", "2606:4700:4700::1111", "64:ff9b::101:101"} {
+		if err := p.CheckAddr(netip.MustParseAddr(s)); err != nil {
+			t.Errorf("%s should be allowed, got %v", s, err)
+		}
+	}
+
+	lan := mustPolicy(t, config.EgressConfig{BlockPrivate: true, AllowCIDRs: []string{"192.168.1.0/24", "10.0.0.5"}})
+	for _, s := range []string{"192.168.1.20", "10.0.0.5"} {
+		if err := lan.CheckAddr(netip.MustParseAddr(s)); err != nil {
+			t.Errorf("%s is in allow_cidrs, got %v", s, err)
+		}
+	}
+	if err := lan.CheckAddr(netip.MustParseAddr("10.0.0.6")); err == nil {
+		t.Error("10.0.0.6 is not in allow_cidrs and should be blocked")
+	}
+
+	if err := mustPolicy(t, config.EgressConfig{}).CheckAddr(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Errorf("block_private=false should allow loopback, got %v", err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	p := mustPolicy(t, config.EgressConfig{
+		BlockPrivate: true,
+		AllowDomains: []string{"example.com", "*.wikipedia.org"},
+		DenyDomains:  []string{"private.example.com"},
+	})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/page", true},
+		{"https://www.example.com/", true},
+		{"https://en.wikipedia.org/wiki/Go", true},
+		{"https://private.example.com/", false},
+		{"https://api.private.example.com/", false},
+		{"https://notexample.com/", false},
+		{"https://evil.com/", false},
+		{"http://localhost:8080/", false},
+		{"http://10.0.0.1/", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		err := p.CheckURL(u)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.url, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrBlocked) {
+			t.Errorf("%s: expected ErrBlocked, got %v", tt.url, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	u, _ := url.Parse("http://127.0.0.1/")
+	if err := nilPolicy.CheckURL(u); err != nil {
+		t.Errorf("nil policy should allow everything, got %v", err)
+	}
+}
+
+func TestControl_ChecksDialedAddress(t *testing.T) {
+	p := DefaultPolicy()
+	if err := p.control(context.Background(), "tcp", "127.0.0.1:80", nil); !errors.Is(err, ErrBlocked) {
+		t.Errorf("dial to loopback should be blocked, got %v", err)
+	}
+	if err := p.control(context.Background(), "tcp", "[2606:4700:4700::1111]:443", nil); err != nil {
+		t.Errorf("dial to a public address should be allowed, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), uncheckedKey{}, true)
+	if err := p.control(ctx, "tcp", "127.0.0.1:7890", nil); err != nil {
+		t.Errorf("unchecked dials (proxy, trusted host) should pass, got %v", err)
+	}
+}
+
+func get(t *testing.T, c *http.Client, target string) (string, error) {
+	t.Helper()
+	resp, err := c.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestClient_Loopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	if _, err := get(t, DefaultPolicy().Client(&http.Client{}, 5), server.URL); !errors.Is(err, ErrBlocked) {
+		t.Errorf("loopback server should be blocked by default, got %v", err)
+	}
+
+	allowed := mustPolicy(t, config.EgressConfig{BlockPrivate: true, AllowCIDRs: []string{"127.0.0.1/32"}})
+	c := allowed.Client(&http.Client{}, 5)
+	if body, err := get(t, c, server.URL); err != nil || body != "ok" {
+		t.Errorf("explicitly allowed loopback: body=%q err=%v", body, err)
+	}
+	if _, err := get(t, c, server.URL+"/redirect"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("redirect to the metadata service should be blocked, got %v", err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	trusted := DefaultPolicy().Trust(u.Hostname()).Client(&http.Client{}, 5)
+	if body, err := get(t, trusted, server.URL); err != nil || body != "ok" {
+		t.Errorf("trusted host: body=%q err=%v", body, err)
+	}
+	if _, err := get(t, trusted, server.URL+"/redirect"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("redirect away from a trusted host should be checked, got %v", err)
+	}
+}
+
+func TestClient_ChecksTargetThroughProxy(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// The proxy itself is on loopback; only the target is checked.
+	c := DefaultPolicy().Client(&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}, 5)
+	if _, err := get(t, c, "http://10.0.0.1/admin"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("private target behind a proxy should be blocked, got %v", err)
+	}
+	if proxied {
+		t.Error("blocked request reached the proxy")
+	}
+	if body, err := get(t, c, "http://93.184.216.34/"); err != nil || body != "via proxy" {
+		t.Errorf("public target through a loopback proxy: body=%q err=%v", body, err)
+	}
+}
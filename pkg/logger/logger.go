@@ -34,6 +34,7 @@ var (
 	logger       *Logger
 	once         sync.Once
 	mu           sync.RWMutex
+	redactor     func(string) string
 )
 
 type Logger struct {
@@ -67,6 +68,14 @@ func GetLevel() LogLevel {
 	return currentLevel
 }
 
+// SetRedactor installs a function applied to every message and field value
+// before it is written, used to remove secrets from logs. nil disables it.
+func SetRedactor(fn func(string) string) {
+	mu.Lock()
+	defer mu.Unlock()
+	redactor = fn
+}
+
 func EnableFileLogging(filePath string) error {
 	mu.Lock()
 	defer mu.Unlock()
@@ -101,6 +110,13 @@ func logMessage(level LogLevel, component string, message string, fields map[str
 		return
 	}
 
+	mu.RLock()
+	redact := redactor
+	mu.RUnlock()
+	if redact != nil {
+		message, fields = redactEntry(redact, message, fields)
+	}
+
 	entry := LogEntry{
 		Level:     logLevelNames[level],
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -145,6 +161,34 @@ func logMessage(level LogLevel, component string, message string, fields map[str
 	}
 }
 
+// redactEntry applies redact to the message and to the formatted value of
+// each field. Fields are copied so the caller's map is left untouched.
+func redactEntry(redact func(string) string, message string, fields map[string]any) (string, map[string]any) {
+	message = redact(message)
+	if len(fields) == 0 {
+		return message, fields
+	}
+	out := make(map[string]any, len(fields))
+	for k, v := range fields {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case nil, bool, int, int64, float64:
+			out[k] = v
+			continue
+		default:
+			s = fmt.Sprintf("%v", v)
+		}
+		if r := redact(s); r != s {
+			out[k] = r
+		} else {
+			out[k] = v
+		}
+	}
+	return message, out
+}
+
 func formatComponent(component string) string {
 	if component == "" {
 		return ""
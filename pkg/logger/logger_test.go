@@ -1,6 +1,10 @@
 package logger
 
 import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -137,3 +141,28 @@ func TestLoggerHelperFunctions(t *testing.T) {
 	DebugC("test", "Debug with component")
 	WarnF("Warning with fields", map[string]any{"key": "value"})
 }
+
+func TestSetRedactor(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetRedactor(nil)
+	initialLevel := GetLevel()
+	defer SetLevel(initialLevel)
+	SetLevel(INFO)
+
+	SetRedactor(func(s string) string { return strings.ReplaceAll(s, "hunter2", "[REDACTED]") })
+	fields := map[string]any{"password": "hunter2", "count": 3, "args": map[string]any{"p": "hunter2"}}
+	InfoCF("test", "login with hunter2", fields)
+
+	out := buf.String()
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("secret leaked into log: %s", out)
+	}
+	if !strings.Contains(out, "count=3") {
+		t.Errorf("non-secret field changed: %s", out)
+	}
+	if fields["password"] != "hunter2" {
+		t.Error("redaction modified the caller's fields")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,9 +17,14 @@ import (
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/egress"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/redact"
 )
 
+// maxRedirects limits redirects followed by SSE/HTTP transports under an egress policy.
+const maxRedirects = 10
+
 // headerTransport is an http.RoundTripper that adds custom headers to requests
 type headerTransport struct {
 	base    http.RoundTripper
@@ -111,6 +117,7 @@ type Manager struct {
 	mu      sync.RWMutex
 	closed  atomic.Bool    // changed from bool to atomic.Bool to avoid TOCTOU race
 	wg      sync.WaitGroup // tracks in-flight CallTool calls
+	egress  *egress.Policy // restricts HTTP transports; nil allows everything
 }
 
 // NewManager creates a new MCP manager
@@ -120,6 +127,12 @@ func NewManager() *Manager {
 	}
 }
 
+// SetEgressPolicy restricts the hosts SSE/HTTP servers may be reached at.
+// Each server's configured URL is always allowed; redirects are checked.
+func (m *Manager) SetEgressPolicy(p *egress.Policy) {
+	m.egress = p
+}
+
 // LoadFromConfig loads MCP servers from configuration
 func (m *Manager) LoadFromConfig(ctx context.Context, cfg *config.Config) error {
 	return m.LoadFromMCPConfig(ctx, cfg.Tools.MCP, cfg.WorkspacePath())
@@ -286,14 +299,27 @@ func (m *Manager) ConnectServer(
 			Endpoint: cfg.URL,
 		}
 
+		var base http.RoundTripper = http.DefaultTransport
+		var checkRedirect func(*http.Request, []*http.Request) error
+		if m.egress != nil {
+			policy := m.egress
+			if u, err := url.Parse(cfg.URL); err == nil {
+				policy = policy.Trust(u.Hostname())
+			}
+			base = policy.Transport(http.DefaultTransport.(*http.Transport))
+			checkRedirect = policy.CheckRedirect(maxRedirects)
+			sseTransport.HTTPClient = &http.Client{Transport: base, CheckRedirect: checkRedirect}
+		}
+
 		// Add custom headers if provided
 		if len(cfg.Headers) > 0 {
 			// Create a custom HTTP client with header-injecting transport
 			sseTransport.HTTPClient = &http.Client{
 				Transport: &headerTransport{
-					base:    http.DefaultTransport,
+					base:    base,
 					headers: cfg.Headers,
 				},
+				CheckRedirect: checkRedirect,
 			}
 			logger.DebugCF("mcp", "Added custom HTTP headers",
 				map[string]any{
@@ -334,6 +360,12 @@ func (m *Manager) ConnectServer(
 			}
 			for k, v := range envVars {
 				envMap[k] = v
+				// Keep credentials from env files out of logs and of tool
+				// output shown to the model. Other values (URLs, paths) are
+				// left alone so that ordinary output is not mangled.
+				if isSecretEnvName(k) {
+					redact.AddSecrets(v)
+				}
 			}
 			logger.DebugCF("mcp", "Loaded environment variables from file",
 				map[string]any{
@@ -530,3 +562,9 @@ func (m *Manager) GetAllTools() map[string][]*mcp.Tool {
 	}
 	return result
 }
+
+// isSecretEnvName reports whether an environment variable holds a
+// credential: *_KEY, *_TOKEN, *_SECRET, *PASSWORD* and the like.
+func isSecretEnvName(name string) bool {
+	return redact.IsSensitiveName(name) || strings.Contains(strings.ToUpper(name), "PASSWORD")
+}
@@ -296,3 +296,20 @@ func TestClose_IdempotentOnEmptyManager(t *testing.T) {
 		t.Fatalf("second close should be idempotent, got: %v", err)
 	}
 }
+
+func TestIsSecretEnvName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"OPENAI_API_KEY":    true,
+		"GITHUB_TOKEN":      true,
+		"CLIENT_SECRET":     true,
+		"DB_PASSWORD_FILE":  true,
+		"SERVER_URL":        false,
+		"DATA_DIR":          false,
+		"KEYBOARD_LAYOUT":   false,
+		"TOKENIZERS_THREAD": false,
+	} {
+		if got := isSecretEnvName(name); got != want {
+			t.Errorf("isSecretEnvName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// historySchema creates the append-only message log searched by
+// HistoryIndex. history_fts is an external-content FTS5 table kept in
+// sync with history by the insert trigger.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_key TEXT NOT NULL,
+	channel     TEXT NOT NULL,
+	chat_id     TEXT NOT NULL,
+	role        TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	created_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_history_created ON history (created_at);
+CREATE VIRTUAL TABLE IF NOT EXISTS history_fts USING fts5(
+	content,
+	content = 'history',
+	content_rowid = 'id'
+);
+CREATE TRIGGER IF NOT EXISTS history_ai AFTER INSERT ON history BEGIN
+	INSERT INTO history_fts (rowid, content) VALUES (new.id, new.content);
+END;
+`
+
+const (
+	defaultHistoryLimit = 10
+	maxHistoryLimit     = 50
+	// historySnippetTokens is the approximate number of tokens
+	// returned around each match.
+	historySnippetTokens = 24
+)
+
+// HistoryEntry is one message recorded in a HistoryIndex.
+type HistoryEntry struct {
+	SessionKey string
+	Channel    string
+	ChatID     string
+	Role       string
+	Content    string
+	Time       time.Time
+}
+
+// HistoryQuery selects messages from a HistoryIndex. Zero-valued filters
+// are ignored.
+type HistoryQuery struct {
+	// Text is matched against message content. Every word must occur;
+	// FTS5 query syntax is not interpreted.
+	Text       string
+	SessionKey string
+	Channel    string
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	Limit      int
+}
+
+// HistoryHit is a single search result.
+type HistoryHit struct {
+	SessionKey string
+	Channel    string
+	Role       string
+	Snippet    string
+	Time       time.Time
+}
+
+// HistoryIndex is a full-text index over conversation messages.
+//
+// It is independent of the session Store: entries are never truncated or
+// summarized away, so messages stay searchable after the session history
+// that contained them has been compacted.
+type HistoryIndex struct {
+	db *sql.DB
+}
+
+// NewHistoryIndex opens (or creates) the history index database at path.
+func NewHistoryIndex(path string) (*HistoryIndex, error) {
+	db, err := openSQLite(path, historySchema)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryIndex{db: db}, nil
+}
+
+// Add records a message. Entries with empty content are ignored.
+func (h *HistoryIndex) Add(ctx context.Context, entry HistoryEntry) error {
+	if strings.TrimSpace(entry.Content) == "" {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	_, err := h.db.ExecContext(ctx,
+		`INSERT INTO history (session_key, channel, chat_id, role, content, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.SessionKey, entry.Channel, entry.ChatID, entry.Role, entry.Content,
+		entry.Time.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("memory: index message: %w", err)
+	}
+	return nil
+}
+
+// Search returns the best matching messages, most relevant first.
+func (h *HistoryIndex) Search(ctx context.Context, q HistoryQuery) ([]HistoryHit, error) {
+	match := ftsMatchExpr(q.Text)
+	if match == "" {
+		return nil, fmt.Errorf("memory: empty search query")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`SELECT h.session_key, h.channel, h.role, h.created_at,
+		snippet(history_fts, 0, '**', '**', '…', ?)
+		FROM history_fts JOIN history h ON h.id = history_fts.rowid
+		WHERE history_fts MATCH ?`)
+	args := []any{historySnippetTokens, match}
+	if q.SessionKey != "" {
+		sb.WriteString(" AND h.session_key = ?")
+		args = append(args, q.SessionKey)
+	}
+	if q.Channel != "" {
+		sb.WriteString(" AND h.channel = ?")
+		args = append(args, q.Channel)
+	}
+	if !q.Since.IsZero() {
+		sb.WriteString(" AND h.created_at >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		sb.WriteString(" AND h.created_at < ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	sb.WriteString(" ORDER BY rank LIMIT ?")
+	args = append(args, limit)
+
+	rows, err := h.db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("memory: search history: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []HistoryHit
+	for rows.Next() {
+		var (
+			hit       HistoryHit
+			createdAt int64
+		)
+		if err := rows.Scan(&hit.SessionKey, &hit.Channel, &hit.Role, &createdAt, &hit.Snippet); err != nil {
+			return nil, fmt.Errorf("memory: scan history hit: %w", err)
+		}
+		hit.Time = time.UnixMilli(createdAt)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("memory: read history hits: %w", err)
+	}
+	return hits, nil
+}
+
+// Close releases the underlying database.
+func (h *HistoryIndex) Close() error {
+	return h.db.Close()
+}
+
+// ftsMatchExpr turns free text into an FTS5 expression that requires every
+// word. Each word is quoted so that characters meaningful to FTS5 (such as
+// '-', ':' or '*') are searched literally instead of causing syntax errors.
+func ftsMatchExpr(text string) string {
+	words := strings.Fields(text)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHistoryIndex(t *testing.T) *HistoryIndex {
+	t.Helper()
+	idx, err := NewHistoryIndex(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewHistoryIndex: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func TestHistoryIndex_SearchFilters(t *testing.T) {
+	idx := newTestHistoryIndex(t)
+	ctx := context.Background()
+
+	jan := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC)
+	entries := []HistoryEntry{
+		{SessionKey: "s1", Channel: "telegram", Role: "user", Content: "We decided to use Postgres for billing", Time: jan},
+		{SessionKey: "s1", Channel: "telegram", Role: "assistant", Content: "Noted: Postgres for billing.", Time: feb},
+		{SessionKey: "s2", Channel: "slack", Role: "user", Content: "Postgres or MySQL for analytics?", Time: feb},
+		{SessionKey: "s2", Channel: "slack", Role: "user", Content: "lunch plans", Time: feb},
+	}
+	for _, e := range entries {
+		if err := idx.Add(ctx, e); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    HistoryQuery
+		want int
+	}{
+		{name: "all", q: HistoryQuery{Text: "postgres"}, want: 3},
+		{name: "all words required", q: HistoryQuery{Text: "postgres billing"}, want: 2},
+		{name: "session", q: HistoryQuery{Text: "postgres", SessionKey: "s2"}, want: 1},
+		{name: "channel", q: HistoryQuery{Text: "postgres", Channel: "telegram"}, want: 2},
+		{name: "since", q: HistoryQuery{Text: "postgres", Since: feb}, want: 2},
+		{name: "until", q: HistoryQuery{Text: "postgres", Until: feb}, want: 1},
+		{name: "limit", q: HistoryQuery{Text: "postgres", Limit: 1}, want: 1},
+		{name: "no match", q: HistoryQuery{Text: "kubernetes"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := idx.Search(ctx, tt.q)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(hits) != tt.want {
+				t.Fatalf("got %d hits, want %d: %+v", len(hits), tt.want, hits)
+			}
+		})
+	}
+}
+
+func TestHistoryIndex_SnippetAndMetadata(t *testing.T) {
+	idx := newTestHistoryIndex(t)
+	ctx := context.Background()
+
+	at := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	idx.Add(ctx, HistoryEntry{
+		SessionKey: "agent:main:telegram:direct:42",
+		Channel:    "telegram",
+		ChatID:     "42",
+		Role:       "user",
+		Content:    "remember the deploy window is Friday",
+		Time:       at,
+	})
+
+	hits, err := idx.Search(ctx, HistoryQuery{Text: "deploy"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(hits))
+	}
+	hit := hits[0]
+	if hit.SessionKey != "agent:main:telegram:direct:42" || hit.Channel != "telegram" || hit.Role != "user" {
+		t.Errorf("unexpected hit metadata: %+v", hit)
+	}
+	if !hit.Time.Equal(at) {
+		t.Errorf("Time = %v, want %v", hit.Time, at)
+	}
+	if !strings.Contains(hit.Snippet, "**deploy**") {
+		t.Errorf("Snippet = %q, want highlighted match", hit.Snippet)
+	}
+}
+
+func TestHistoryIndex_QueryIsLiteral(t *testing.T) {
+	idx := newTestHistoryIndex(t)
+	ctx := context.Background()
+
+	idx.Add(ctx, HistoryEntry{SessionKey: "s", Role: "user", Content: `ticket OPS-123 "urgent"`})
+
+	// Characters with special meaning in FTS5 must not cause syntax errors.
+	for _, q := range []string{"OPS-123", `"urgent`, "ticket*", "NEAR(x)", "a:b"} {
+		if _, err := idx.Search(ctx, HistoryQuery{Text: q}); err != nil {
+			t.Errorf("Search(%q): %v", q, err)
+		}
+	}
+
+	if _, err := idx.Search(ctx, HistoryQuery{Text: "   "}); err == nil {
+		t.Error("expected error for empty query")
+	}
+}
+
+func TestHistoryIndex_IgnoresEmptyContent(t *testing.T) {
+	idx := newTestHistoryIndex(t)
+	ctx := context.Background()
+
+	if err := idx.Add(ctx, HistoryEntry{SessionKey: "s", Role: "assistant", Content: "  "}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	var n int
+	if err := idx.db.QueryRow(`SELECT COUNT(*) FROM history`).Scan(&n); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no rows, got %d", n)
+	}
+}
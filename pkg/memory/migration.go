@@ -52,6 +52,10 @@ func MigrateFromJSON(
 		if strings.HasSuffix(name, ".migrated") {
 			continue
 		}
+		// Skip JSONLStore metadata, which may share the directory.
+		if strings.HasSuffix(name, ".meta.json") {
+			continue
+		}
 
 		srcPath := filepath.Join(sessionsDir, name)
 
@@ -106,3 +110,83 @@ func MigrateFromJSON(
 
 	return migrated, nil
 }
+
+// MigrateFromJSONL copies every session stored by a JSONLStore in dir
+// into store, then renames the session's .jsonl and .meta.json files to
+// .migrated as a backup. Returns the number of sessions migrated.
+//
+// Only active messages are copied; lines already dropped by
+// TruncateHistory are left behind. Like MigrateFromJSON, the function
+// uses SetHistory so that re-running it after a crash is idempotent.
+func MigrateFromJSONL(
+	ctx context.Context, dir string, store Store,
+) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("memory: read jsonl dir: %w", err)
+	}
+
+	src := &JSONLStore{dir: dir}
+
+	migrated := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".jsonl")
+
+		// The metadata file preserves the original (unsanitized) key.
+		key := base
+		meta, metaErr := src.readMeta(base)
+		if metaErr != nil {
+			log.Printf("memory: migrate: skip %s: %v", name, metaErr)
+			continue
+		}
+		if meta.Key != "" && sanitizeKey(meta.Key) == base {
+			key = meta.Key
+		}
+
+		history, histErr := readMessages(filepath.Join(dir, name), meta.Skip)
+		if histErr != nil {
+			log.Printf("memory: migrate: skip %s: %v", name, histErr)
+			continue
+		}
+
+		if setErr := store.SetHistory(ctx, key, history); setErr != nil {
+			return migrated, fmt.Errorf(
+				"memory: migrate %s: set history: %w",
+				name, setErr,
+			)
+		}
+
+		if meta.Summary != "" {
+			if sumErr := store.SetSummary(ctx, key, meta.Summary); sumErr != nil {
+				return migrated, fmt.Errorf(
+					"memory: migrate %s: set summary: %w",
+					name, sumErr,
+				)
+			}
+		}
+
+		// Rename the .jsonl file first: it is what marks the session as
+		// pending. If we crash before the metadata is renamed, the
+		// leftover .meta.json is simply ignored on retry.
+		for _, p := range []string{filepath.Join(dir, name), src.metaPath(base)} {
+			renameErr := os.Rename(p, p+".migrated")
+			if renameErr != nil && !os.IsNotExist(renameErr) {
+				log.Printf("memory: migrate: rename %s: %v", filepath.Base(p), renameErr)
+			}
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
@@ -382,3 +382,28 @@ func TestMigrateFromJSON_NonexistentDir(t *testing.T) {
 		t.Errorf("expected 0, got %d", count)
 	}
 }
+
+func TestMigrateFromJSON_SkipsJSONLMetadata(t *testing.T) {
+	// A JSONLStore sharing the sessions directory must not have its
+	// .meta.json files mistaken for legacy sessions.
+	sessionsDir := t.TempDir()
+	ctx := context.Background()
+
+	store, err := NewJSONLStore(sessionsDir)
+	if err != nil {
+		t.Fatalf("NewJSONLStore: %v", err)
+	}
+	store.AddMessage(ctx, "live", "user", "keep me")
+
+	n, err := MigrateFromJSON(ctx, sessionsDir, store)
+	if err != nil {
+		t.Fatalf("MigrateFromJSON: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("migrated %d sessions, want 0", n)
+	}
+	history, _ := store.GetHistory(ctx, "live")
+	if len(history) != 1 {
+		t.Errorf("expected history to be untouched, got %d messages", len(history))
+	}
+}
@@ -0,0 +1,470 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// semanticSchema stores one row per remembered fact. Embeddings are kept as
+// little-endian float32 blobs, normalized to unit length so that cosine
+// similarity reduces to a dot product. The content hash (of contentKey)
+// makes saving the same text twice a no-op, whatever its source and however
+// it is formatted. Source names the document an entry was imported
+// from ("" for memories saved directly), so that entries removed from the
+// document can be deleted on the next import.
+const semanticSchema = `
+CREATE TABLE IF NOT EXISTS memories (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	content    TEXT NOT NULL,
+	hash       TEXT NOT NULL UNIQUE,
+	dim        INTEGER NOT NULL,
+	embedding  BLOB NOT NULL,
+	created_at INTEGER NOT NULL,
+	source     TEXT NOT NULL DEFAULT ''
+);
+`
+
+// Recollection is a memory returned by SemanticMemory.Recall.
+type Recollection struct {
+	ID        int64
+	Content   string
+	Score     float64 // cosine similarity to the query, in [-1, 1]
+	CreatedAt time.Time
+}
+
+// SemanticMemory is a long-term memory searchable by meaning rather than
+// by keywords. Texts are embedded with an Embedder and stored in a local
+// SQLite database; Recall ranks all stored memories by cosine similarity.
+//
+// The brute-force scan is intentional: a personal agent accumulates at most
+// a few thousand memories, which scan in well under a millisecond, and it
+// avoids an approximate index that would need rebuilding.
+type SemanticMemory struct {
+	db       *sql.DB
+	embedder providers.Embedder
+}
+
+// NewSemanticMemory opens (or creates) the memory database at path.
+func NewSemanticMemory(path string, embedder providers.Embedder) (*SemanticMemory, error) {
+	if embedder == nil {
+		return nil, fmt.Errorf("memory: embedder is required")
+	}
+	db, err := openSQLite(path, semanticSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := addSourceColumn(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := rehashMemories(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SemanticMemory{db: db, embedder: embedder}, nil
+}
+
+// addSourceColumn upgrades databases created before memories had a source.
+// Entries imported before the upgrade keep an empty source and are no
+// longer pruned automatically.
+func addSourceColumn(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('memories')`)
+	if err != nil {
+		return fmt.Errorf("memory: read schema: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("memory: read schema: %w", err)
+		}
+		if name == "source" {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("memory: read schema: %w", err)
+	}
+	if _, err := db.Exec(`ALTER TABLE memories ADD COLUMN source TEXT NOT NULL DEFAULT ''`); err != nil {
+		return fmt.Errorf("memory: add source column: %w", err)
+	}
+	return nil
+}
+
+// rehashMemories upgrades databases whose hashes were taken of the raw
+// content rather than of contentKey. Entries that turn out to be duplicates
+// are deleted, keeping memories saved directly over imported ones.
+func rehashMemories(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("memory: read schema version: %w", err)
+	}
+	if version >= 1 {
+		return nil
+	}
+
+	rows, err := db.Query(`SELECT id, content, hash FROM memories ORDER BY source != '', id`)
+	if err != nil {
+		return fmt.Errorf("memory: list memories: %w", err)
+	}
+	seen := make(map[string]bool)
+	var duplicates []int64
+	rehashed := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id            int64
+			content, hash string
+		)
+		if err := rows.Scan(&id, &content, &hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("memory: list memories: %w", err)
+		}
+		switch key := contentHash(content); {
+		case seen[key]:
+			duplicates = append(duplicates, id)
+		case key != hash:
+			seen[key] = true
+			rehashed[id] = key
+		default:
+			seen[key] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("memory: list memories: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("memory: rehash memories: %w", err)
+	}
+	defer tx.Rollback()
+	// Duplicates go first so that no remaining entry holds a new hash.
+	for _, id := range duplicates {
+		if _, err := tx.Exec(`DELETE FROM memories WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("memory: delete duplicate memory: %w", err)
+		}
+	}
+	for id, hash := range rehashed {
+		if _, err := tx.Exec(`UPDATE memories SET hash = ? WHERE id = ?`, hash, id); err != nil {
+			return fmt.Errorf("memory: rehash memory: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`PRAGMA user_version = 1`); err != nil {
+		return fmt.Errorf("memory: write schema version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("memory: rehash memories: %w", err)
+	}
+	return nil
+}
+
+// Save embeds and stores content. Saving text that is already stored
+// (ignoring case, whitespace and Markdown emphasis) returns the existing ID.
+// A memory imported from a document and then saved directly is kept even
+// once it is removed from the document.
+func (m *SemanticMemory) Save(ctx context.Context, content string) (int64, error) {
+	ids, err := m.SaveAll(ctx, []string{content})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// SaveAll embeds and stores several texts with a single embedding request.
+// It returns the ID of each text in input order.
+func (m *SemanticMemory) SaveAll(ctx context.Context, contents []string) ([]int64, error) {
+	return m.saveAll(ctx, contents, "")
+}
+
+// saveAll is SaveAll for texts imported from source.
+func (m *SemanticMemory) saveAll(ctx context.Context, contents []string, source string) ([]int64, error) {
+	ids := make([]int64, len(contents))
+	hashes := make([]string, len(contents))
+	trimmed := make([]string, len(contents))
+	var pending []int
+	for i, c := range contents {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			return nil, fmt.Errorf("memory: content %d is empty", i)
+		}
+		trimmed[i] = c
+		hashes[i] = contentHash(c)
+		id, err := m.lookup(ctx, hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		if id != 0 {
+			if source == "" {
+				if _, err := m.db.ExecContext(ctx, `UPDATE memories SET source = '' WHERE id = ?`, id); err != nil {
+					return nil, fmt.Errorf("memory: update memory: %w", err)
+				}
+			}
+			ids[i] = id
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return ids, nil
+	}
+
+	texts := make([]string, len(pending))
+	for j, i := range pending {
+		texts[j] = trimmed[i]
+	}
+	vectors, err := m.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("memory: embed: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("memory: embedder returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+
+	now := time.Now().UnixMilli()
+	for j, i := range pending {
+		vec := normalize(vectors[j])
+		if vec == nil {
+			return nil, fmt.Errorf("memory: embedder returned an empty vector")
+		}
+		// ON CONFLICT covers duplicates within the same batch.
+		_, err := m.db.ExecContext(ctx,
+			`INSERT INTO memories (content, hash, dim, embedding, created_at, source) VALUES (?, ?, ?, ?, ?, ?)
+			 ON CONFLICT(hash) DO NOTHING`,
+			trimmed[i], hashes[i], len(vec), encodeVector(vec), now, source,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("memory: insert memory: %w", err)
+		}
+		if ids[i], err = m.lookup(ctx, hashes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// lookup returns the ID of the memory with the given content hash, or 0.
+func (m *SemanticMemory) lookup(ctx context.Context, hash string) (int64, error) {
+	var id int64
+	err := m.db.QueryRowContext(ctx, `SELECT id FROM memories WHERE hash = ?`, hash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("memory: lookup memory: %w", err)
+	}
+	return id, nil
+}
+
+// Recall returns up to k memories most similar to query with a score of at
+// least minScore, best match first.
+func (m *SemanticMemory) Recall(ctx context.Context, query string, k int, minScore float64) ([]Recollection, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || k <= 0 {
+		return nil, nil
+	}
+
+	vectors, err := m.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("memory: embed query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("memory: embedder returned %d vectors for 1 text", len(vectors))
+	}
+	q := normalize(vectors[0])
+	if q == nil {
+		return nil, fmt.Errorf("memory: embedder returned an empty vector")
+	}
+
+	// Memories embedded with a different model (other dimension) cannot be
+	// compared and are skipped.
+	rows, err := m.db.QueryContext(ctx,
+		`SELECT id, content, embedding, created_at FROM memories WHERE dim = ?`, len(q))
+	if err != nil {
+		return nil, fmt.Errorf("memory: query memories: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Recollection
+	for rows.Next() {
+		var (
+			r         Recollection
+			blob      []byte
+			createdAt int64
+		)
+		if err := rows.Scan(&r.ID, &r.Content, &blob, &createdAt); err != nil {
+			return nil, fmt.Errorf("memory: scan memory: %w", err)
+		}
+		r.Score = dot(q, decodeVector(blob))
+		if r.Score < minScore {
+			continue
+		}
+		r.CreatedAt = time.UnixMilli(createdAt)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("memory: read memories: %w", err)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// Forget deletes the memory with the given ID. Deleting a missing ID is not an error.
+func (m *SemanticMemory) Forget(ctx context.Context, id int64) error {
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM memories WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("memory: delete memory: %w", err)
+	}
+	return nil
+}
+
+// Close releases the underlying database.
+func (m *SemanticMemory) Close() error {
+	return m.db.Close()
+}
+
+// ImportMarkdown saves each paragraph and list item of a Markdown document
+// (such as MEMORY.md) as a separate memory. Headings are dropped. Because
+// Save deduplicates by content, importing the same document again only
+// embeds new or changed entries. Entries previously imported from source
+// that are no longer in the document are deleted. Returns the number of
+// entries in the document.
+func (m *SemanticMemory) ImportMarkdown(ctx context.Context, source, markdown string) (int, error) {
+	chunks := splitMarkdown(markdown)
+	if len(chunks) > 0 {
+		if _, err := m.saveAll(ctx, chunks, source); err != nil {
+			return 0, err
+		}
+	}
+
+	keep := make(map[string]bool, len(chunks))
+	for _, c := range chunks {
+		keep[contentHash(c)] = true
+	}
+	rows, err := m.db.QueryContext(ctx, `SELECT id, hash FROM memories WHERE source = ?`, source)
+	if err != nil {
+		return 0, fmt.Errorf("memory: list imported memories: %w", err)
+	}
+	var stale []int64
+	for rows.Next() {
+		var (
+			id   int64
+			hash string
+		)
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("memory: list imported memories: %w", err)
+		}
+		if !keep[hash] {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("memory: list imported memories: %w", err)
+	}
+	for _, id := range stale {
+		if err := m.Forget(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(chunks), nil
+}
+
+// splitMarkdown breaks a Markdown document into paragraphs and list items.
+func splitMarkdown(markdown string) []string {
+	var (
+		chunks []string
+		cur    []string
+	)
+	flush := func() {
+		if text := strings.TrimSpace(strings.Join(cur, "\n")); text != "" {
+			chunks = append(chunks, text)
+		}
+		cur = cur[:0]
+	}
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "#"), trimmed == "---":
+			flush()
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			flush()
+			cur = append(cur, strings.TrimSpace(trimmed[2:]))
+		default:
+			cur = append(cur, trimmed)
+		}
+	}
+	flush()
+	return chunks
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(contentKey(content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// emphasisMarkers are the Markdown markers ignored when comparing memories.
+var emphasisMarkers = strings.NewReplacer("**", "", "__", "", "`", "")
+
+// contentKey reduces content to what identifies it as a fact: lower-cased,
+// without Markdown emphasis, with whitespace collapsed and without a final
+// period. "User likes **tea**." and "user likes tea" are the same memory.
+func contentKey(content string) string {
+	key := strings.Join(strings.Fields(emphasisMarkers.Replace(strings.ToLower(content))), " ")
+	return strings.TrimRight(key, ". ")
+}
+
+// normalize returns v scaled to unit length, or nil for empty/zero vectors.
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return nil
+	}
+	norm := math.Sqrt(sum)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range min(len(a), len(b)) {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
@@ -0,0 +1,349 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bagOfWordsEmbedder is a deterministic stand-in for a real embedding model:
+// each lower-cased word is hashed into one of dim buckets, so texts sharing
+// words get similar vectors.
+type bagOfWordsEmbedder struct {
+	dim   int
+	calls int
+	err   error
+}
+
+func (e *bagOfWordsEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, e.dim)
+		for _, w := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,:;!?")))
+			vec[h.Sum32()%uint32(e.dim)]++
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+func newTestSemanticMemory(t *testing.T, embedder *bagOfWordsEmbedder) *SemanticMemory {
+	t.Helper()
+	sm, err := NewSemanticMemory(filepath.Join(t.TempDir(), "semantic.db"), embedder)
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	return sm
+}
+
+func TestSemanticMemory_RecallRanksBySimilarity(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 256})
+	ctx := context.Background()
+
+	for _, fact := range []string{
+		"User prefers dark roast coffee in the morning",
+		"The production database is Postgres 16",
+		"User's sister lives in Lisbon",
+	} {
+		if _, err := sm.Save(ctx, fact); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	got, err := sm.Recall(ctx, "which database runs in production?", 2, 0.1)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) == 0 || got[0].Content != "The production database is Postgres 16" {
+		t.Fatalf("unexpected recall result: %+v", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Score > got[i-1].Score {
+			t.Errorf("results not sorted by score: %+v", got)
+		}
+	}
+}
+
+func TestSemanticMemory_RecallLimits(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 256})
+	ctx := context.Background()
+
+	for _, fact := range []string{"alpha beta", "alpha gamma", "alpha delta", "unrelated words"} {
+		sm.Save(ctx, fact)
+	}
+
+	got, err := sm.Recall(ctx, "alpha", 2, 0)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("k=2: got %d results", len(got))
+	}
+
+	got, err = sm.Recall(ctx, "alpha", 10, 0.5)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("minScore=0.5: got %d results, want 3: %+v", len(got), got)
+	}
+}
+
+func TestSemanticMemory_SaveDeduplicates(t *testing.T) {
+	embedder := &bagOfWordsEmbedder{dim: 64}
+	sm := newTestSemanticMemory(t, embedder)
+	ctx := context.Background()
+
+	id1, err := sm.Save(ctx, "likes tea")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	id2, err := sm.Save(ctx, "  likes tea\n")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if id1 != id2 {
+		t.Errorf("duplicate save returned new id: %d != %d", id1, id2)
+	}
+	if embedder.calls != 1 {
+		t.Errorf("embedder called %d times, want 1", embedder.calls)
+	}
+
+	if _, err := sm.Save(ctx, "   "); err == nil {
+		t.Error("expected error for empty content")
+	}
+}
+
+func TestSemanticMemory_SkipsOtherDimensions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "semantic.db")
+	ctx := context.Background()
+
+	old, err := NewSemanticMemory(path, &bagOfWordsEmbedder{dim: 32})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	old.Save(ctx, "embedded with the old model")
+	old.Close()
+
+	sm, err := NewSemanticMemory(path, &bagOfWordsEmbedder{dim: 64})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	defer sm.Close()
+
+	got, err := sm.Recall(ctx, "embedded with the old model", 5, -1)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected memories from another model to be skipped, got %+v", got)
+	}
+}
+
+func TestSemanticMemory_Forget(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 64})
+	ctx := context.Background()
+
+	id, _ := sm.Save(ctx, "temporary fact")
+	if err := sm.Forget(ctx, id); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	got, _ := sm.Recall(ctx, "temporary fact", 5, 0)
+	if len(got) != 0 {
+		t.Errorf("forgotten memory still recalled: %+v", got)
+	}
+}
+
+func TestSemanticMemory_EmbedderError(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 64, err: errors.New("rate limited")})
+	ctx := context.Background()
+
+	if _, err := sm.Save(ctx, "fact"); err == nil {
+		t.Error("expected Save error")
+	}
+	if _, err := sm.Recall(ctx, "fact", 5, 0); err == nil {
+		t.Error("expected Recall error")
+	}
+}
+
+func TestSemanticMemory_ImportMarkdown(t *testing.T) {
+	embedder := &bagOfWordsEmbedder{dim: 128}
+	sm := newTestSemanticMemory(t, embedder)
+	ctx := context.Background()
+
+	doc := `# Long-term Memory
+
+## User
+
+- Name is Sam
+- Works night shifts
+
+## Notes
+
+Prefers short answers
+without emoji.
+`
+	n, err := sm.ImportMarkdown(ctx, "MEMORY.md", doc)
+	if err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("imported %d entries, want 3", n)
+	}
+
+	got, err := sm.Recall(ctx, "prefers short answers", 1, 0)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) != 1 || got[0].Content != "Prefers short answers\nwithout emoji." {
+		t.Errorf("unexpected recall: %+v", got)
+	}
+
+	// Re-importing an unchanged document must not call the embedder again.
+	calls := embedder.calls
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", doc); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	if embedder.calls != calls {
+		t.Errorf("re-import called embedder %d more times", embedder.calls-calls)
+	}
+}
+
+func TestSemanticMemory_ImportMarkdownPrunesRemovedEntries(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 128})
+	ctx := context.Background()
+
+	saved, err := sm.Save(ctx, "Likes green tea")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", "- Lives in Oslo\n- Has a cat"); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", "- Lives in Bergen\n- Has a cat"); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+
+	got, err := sm.Recall(ctx, "lives in oslo bergen has a cat likes green tea", 10, -1)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	var contents []string
+	for _, r := range got {
+		contents = append(contents, r.Content)
+	}
+	if len(got) != 3 || strings.Contains(strings.Join(contents, "|"), "Oslo") {
+		t.Errorf("memories = %q, want the edited entry replaced", contents)
+	}
+
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", ""); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	got, _ = sm.Recall(ctx, "likes green tea", 10, -1)
+	if len(got) != 1 || got[0].ID != saved {
+		t.Errorf("emptying MEMORY.md should keep saved memories only, got %+v", got)
+	}
+}
+
+func TestSemanticMemory_DeduplicatesAcrossSources(t *testing.T) {
+	sm := newTestSemanticMemory(t, &bagOfWordsEmbedder{dim: 128})
+	ctx := context.Background()
+
+	saved, err := sm.Save(ctx, "User likes green tea")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", "- User likes **green tea**.\n- Imported first"); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	imported, err := sm.Save(ctx, "imported  first")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := sm.Recall(ctx, "user likes green tea imported first", 10, -1)
+	if err != nil {
+		t.Fatalf("Recall: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("recall = %+v, want each fact once", got)
+	}
+
+	// Both facts were saved directly, so they outlive their MEMORY.md entries.
+	if _, err := sm.ImportMarkdown(ctx, "MEMORY.md", ""); err != nil {
+		t.Fatalf("ImportMarkdown: %v", err)
+	}
+	got, _ = sm.Recall(ctx, "user likes green tea imported first", 10, -1)
+	if len(got) != 2 || got[0].ID+got[1].ID != saved+imported {
+		t.Errorf("saved memories should be kept, got %+v", got)
+	}
+}
+
+func TestNewSemanticMemory_RehashesDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "semantic.db")
+	sm, err := NewSemanticMemory(path, &bagOfWordsEmbedder{dim: 16})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	// Rows as written before hashes were taken of contentKey.
+	for _, row := range []struct{ content, source string }{
+		{"Has a cat.", "MEMORY.md"},
+		{"has a cat", ""},
+	} {
+		sum := sha256.Sum256([]byte(row.content))
+		if _, err := sm.db.Exec(
+			`INSERT INTO memories (content, hash, dim, embedding, created_at, source) VALUES (?, ?, 1, ?, 0, ?)`,
+			row.content, hex.EncodeToString(sum[:]), encodeVector([]float32{1}), row.source); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	sm.db.Exec(`PRAGMA user_version = 0`)
+	sm.Close()
+
+	sm, err = NewSemanticMemory(path, &bagOfWordsEmbedder{dim: 16})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	defer sm.Close()
+	var content, source string
+	var n int
+	row := sm.db.QueryRow(`SELECT count(*), max(content), max(source) FROM memories`)
+	if err := row.Scan(&n, &content, &source); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if n != 1 || content != "has a cat" || source != "" {
+		t.Errorf("memories = %d, kept %q from %q; want the saved entry only", n, content, source)
+	}
+}
+
+func TestNewSemanticMemory_UpgradesSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "semantic.db")
+	old, err := openSQLite(path, `CREATE TABLE memories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, content TEXT NOT NULL, hash TEXT NOT NULL UNIQUE,
+		dim INTEGER NOT NULL, embedding BLOB NOT NULL, created_at INTEGER NOT NULL)`)
+	if err != nil {
+		t.Fatalf("openSQLite: %v", err)
+	}
+	old.Close()
+
+	sm, err := NewSemanticMemory(path, &bagOfWordsEmbedder{dim: 16})
+	if err != nil {
+		t.Fatalf("NewSemanticMemory: %v", err)
+	}
+	defer sm.Close()
+	if _, err := sm.ImportMarkdown(context.Background(), "MEMORY.md", "- Has a cat"); err != nil {
+		t.Fatalf("ImportMarkdown after upgrade: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// sqliteDriver is the database/sql driver name registered by
+// modernc.org/sqlite, a pure-Go (cgo-free) SQLite implementation.
+const sqliteDriver = "sqlite"
+
+// sqliteSchema creates the tables used by SQLiteStore. Each message row
+// keeps the role and text content in dedicated columns so that they can
+// be queried across sessions, while the data column holds the complete
+// JSON-encoded providers.Message used to reconstruct history exactly.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	key        TEXT PRIMARY KEY,
+	summary    TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_key TEXT NOT NULL,
+	role        TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	data        TEXT NOT NULL,
+	created_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_session ON messages (session_key, id);
+`
+
+// SQLiteStore implements Store on top of a single SQLite database file.
+//
+// Every Store method runs in its own transaction, so each call is atomic
+// just like the JSONLStore methods. Unlike JSONLStore, truncation deletes
+// rows directly: there is no dead data to reclaim and Compact is a no-op.
+//
+// The database uses a single connection; SQLite serializes writers anyway,
+// and this avoids SQLITE_BUSY errors between connections of the same process.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := openSQLite(path, sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// openSQLite opens the database at path with the settings shared by all
+// SQLite-backed types in this package and applies schema.
+func openSQLite(path, schema string) (*sql.DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("memory: create directory: %w", err)
+	}
+
+	db, err := sql.Open(sqliteDriver, "file:"+path)
+	if err != nil {
+		return nil, fmt.Errorf("memory: open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	// WAL keeps readers from blocking the writer; synchronous=FULL gives
+	// the same durability as the fsync-per-append of JSONLStore.
+	pragmas := []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = FULL",
+		"PRAGMA busy_timeout = 5000",
+	}
+	for _, p := range pragmas {
+		if _, err = db.Exec(p); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("memory: %s: %w", p, err)
+		}
+	}
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("memory: create schema: %w", err)
+	}
+	return db, nil
+}
+
+// withTx runs fn inside a transaction, committing on success and
+// rolling back on error.
+func (s *SQLiteStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("memory: begin transaction: %w", err)
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("memory: commit: %w", err)
+	}
+	return nil
+}
+
+// touchSession creates the session row if needed and bumps updated_at.
+func touchSession(ctx context.Context, tx *sql.Tx, key string, now time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO sessions (key, created_at, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET updated_at = excluded.updated_at`,
+		key, now.UnixMilli(), now.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("memory: upsert session: %w", err)
+	}
+	return nil
+}
+
+// insertMessage appends one message row to a session.
+func insertMessage(ctx context.Context, tx *sql.Tx, key string, msg providers.Message, now time.Time) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("memory: marshal message: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO messages (session_key, role, content, data, created_at) VALUES (?, ?, ?, ?, ?)`,
+		key, msg.Role, msg.Content, string(data), now.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("memory: insert message: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AddMessage(
+	ctx context.Context, sessionKey, role, content string,
+) error {
+	return s.AddFullMessage(ctx, sessionKey, providers.Message{
+		Role:    role,
+		Content: content,
+	})
+}
+
+func (s *SQLiteStore) AddFullMessage(
+	ctx context.Context, sessionKey string, msg providers.Message,
+) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		if err := touchSession(ctx, tx, sessionKey, now); err != nil {
+			return err
+		}
+		return insertMessage(ctx, tx, sessionKey, msg, now)
+	})
+}
+
+func (s *SQLiteStore) GetHistory(
+	ctx context.Context, sessionKey string,
+) ([]providers.Message, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, data FROM messages WHERE session_key = ? ORDER BY id`,
+		sessionKey,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("memory: query history: %w", err)
+	}
+	defer rows.Close()
+
+	msgs := []providers.Message{}
+	for rows.Next() {
+		var (
+			id   int64
+			data string
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("memory: scan message: %w", err)
+		}
+		var msg providers.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			log.Printf("memory: skipping corrupt message %d in session %s: %v",
+				id, sessionKey, err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("memory: read history: %w", err)
+	}
+	return msgs, nil
+}
+
+func (s *SQLiteStore) GetSummary(
+	ctx context.Context, sessionKey string,
+) (string, error) {
+	var summary string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT summary FROM sessions WHERE key = ?`, sessionKey,
+	).Scan(&summary)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("memory: query summary: %w", err)
+	}
+	return summary, nil
+}
+
+func (s *SQLiteStore) SetSummary(
+	ctx context.Context, sessionKey, summary string,
+) error {
+	now := time.Now().UnixMilli()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO sessions (key, summary, created_at, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET summary = excluded.summary, updated_at = excluded.updated_at`,
+		sessionKey, summary, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("memory: set summary: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) TruncateHistory(
+	ctx context.Context, sessionKey string, keepLast int,
+) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if keepLast <= 0 {
+			_, err = tx.ExecContext(ctx,
+				`DELETE FROM messages WHERE session_key = ?`, sessionKey)
+		} else {
+			_, err = tx.ExecContext(ctx,
+				`DELETE FROM messages WHERE session_key = ? AND id NOT IN (
+					SELECT id FROM messages WHERE session_key = ? ORDER BY id DESC LIMIT ?
+				)`,
+				sessionKey, sessionKey, keepLast)
+		}
+		if err != nil {
+			return fmt.Errorf("memory: truncate history: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE sessions SET updated_at = ? WHERE key = ?`,
+			time.Now().UnixMilli(), sessionKey)
+		if err != nil {
+			return fmt.Errorf("memory: update session: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) SetHistory(
+	ctx context.Context,
+	sessionKey string,
+	history []providers.Message,
+) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		if err := touchSession(ctx, tx, sessionKey, now); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM messages WHERE session_key = ?`, sessionKey); err != nil {
+			return fmt.Errorf("memory: clear history: %w", err)
+		}
+		for _, msg := range history {
+			if err := insertMessage(ctx, tx, sessionKey, msg, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact is a no-op: TruncateHistory and SetHistory delete rows directly,
+// and SQLite reuses the freed pages for subsequent inserts.
+func (s *SQLiteStore) Compact(_ context.Context, _ string) error {
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
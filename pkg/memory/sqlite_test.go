@@ -0,0 +1,277 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteStore_AddAndGetHistory(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	err := store.AddMessage(ctx, "telegram:123", "user", "hello")
+	if err != nil {
+		t.Fatalf("AddMessage: %v", err)
+	}
+	err = store.AddFullMessage(ctx, "telegram:123", providers.Message{
+		Role:    "assistant",
+		Content: "",
+		ToolCalls: []providers.ToolCall{{
+			ID:   "call_1",
+			Type: "function",
+			Function: &providers.FunctionCall{
+				Name:      "read_file",
+				Arguments: `{"path":"a.txt"}`,
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("AddFullMessage: %v", err)
+	}
+	err = store.AddFullMessage(ctx, "telegram:123", providers.Message{
+		Role:       "tool",
+		Content:    "file contents",
+		ToolCallID: "call_1",
+	})
+	if err != nil {
+		t.Fatalf("AddFullMessage: %v", err)
+	}
+
+	history, err := store.GetHistory(ctx, "telegram:123")
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(history))
+	}
+	if history[0].Content != "hello" {
+		t.Errorf("history[0].Content = %q, want %q", history[0].Content, "hello")
+	}
+	if len(history[1].ToolCalls) != 1 || history[1].ToolCalls[0].Function.Name != "read_file" {
+		t.Errorf("tool calls not preserved: %+v", history[1].ToolCalls)
+	}
+	if history[2].ToolCallID != "call_1" {
+		t.Errorf("ToolCallID = %q, want %q", history[2].ToolCallID, "call_1")
+	}
+}
+
+func TestSQLiteStore_GetHistory_EmptySession(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	history, err := store.GetHistory(context.Background(), "missing")
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if history == nil || len(history) != 0 {
+		t.Errorf("expected empty non-nil slice, got %#v", history)
+	}
+}
+
+func TestSQLiteStore_Summary(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	summary, err := store.GetSummary(ctx, "s1")
+	if err != nil {
+		t.Fatalf("GetSummary: %v", err)
+	}
+	if summary != "" {
+		t.Errorf("expected empty summary, got %q", summary)
+	}
+
+	if err = store.SetSummary(ctx, "s1", "first"); err != nil {
+		t.Fatalf("SetSummary: %v", err)
+	}
+	if err = store.SetSummary(ctx, "s1", "second"); err != nil {
+		t.Fatalf("SetSummary: %v", err)
+	}
+	summary, err = store.GetSummary(ctx, "s1")
+	if err != nil {
+		t.Fatalf("GetSummary: %v", err)
+	}
+	if summary != "second" {
+		t.Errorf("summary = %q, want %q", summary, "second")
+	}
+}
+
+func TestSQLiteStore_TruncateHistory(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	for i := range 10 {
+		store.AddMessage(ctx, "s1", "user", fmt.Sprintf("msg %d", i))
+	}
+	store.AddMessage(ctx, "s2", "user", "other")
+
+	if err := store.TruncateHistory(ctx, "s1", 3); err != nil {
+		t.Fatalf("TruncateHistory: %v", err)
+	}
+	history, _ := store.GetHistory(ctx, "s1")
+	if len(history) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(history))
+	}
+	if history[0].Content != "msg 7" || history[2].Content != "msg 9" {
+		t.Errorf("unexpected messages kept: %+v", history)
+	}
+
+	if err := store.TruncateHistory(ctx, "s1", 0); err != nil {
+		t.Fatalf("TruncateHistory: %v", err)
+	}
+	history, _ = store.GetHistory(ctx, "s1")
+	if len(history) != 0 {
+		t.Errorf("expected 0 messages, got %d", len(history))
+	}
+
+	other, _ := store.GetHistory(ctx, "s2")
+	if len(other) != 1 {
+		t.Errorf("truncation leaked into other session: %d messages", len(other))
+	}
+}
+
+func TestSQLiteStore_SetHistory_ReplacesAll(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	store.AddMessage(ctx, "s1", "user", "old 1")
+	store.AddMessage(ctx, "s1", "assistant", "old 2")
+
+	err := store.SetHistory(ctx, "s1", []providers.Message{
+		{Role: "user", Content: "new"},
+	})
+	if err != nil {
+		t.Fatalf("SetHistory: %v", err)
+	}
+	history, _ := store.GetHistory(ctx, "s1")
+	if len(history) != 1 || history[0].Content != "new" {
+		t.Errorf("unexpected history after SetHistory: %+v", history)
+	}
+
+	store.AddMessage(ctx, "s1", "assistant", "appended")
+	history, _ = store.GetHistory(ctx, "s1")
+	if len(history) != 2 || history[1].Content != "appended" {
+		t.Errorf("unexpected history after append: %+v", history)
+	}
+}
+
+func TestSQLiteStore_PersistenceAcrossInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "sessions.db")
+	ctx := context.Background()
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	store.AddMessage(ctx, "s1", "user", "persisted")
+	store.SetSummary(ctx, "s1", "a summary")
+	store.Close()
+
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore (reopen): %v", err)
+	}
+	defer reopened.Close()
+
+	history, _ := reopened.GetHistory(ctx, "s1")
+	if len(history) != 1 || history[0].Content != "persisted" {
+		t.Errorf("unexpected history after reopen: %+v", history)
+	}
+	summary, _ := reopened.GetSummary(ctx, "s1")
+	if summary != "a summary" {
+		t.Errorf("summary = %q, want %q", summary, "a summary")
+	}
+}
+
+func TestSQLiteStore_ConcurrentAdd(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.AddMessage(ctx, "s1", "user", fmt.Sprintf("msg %d", i)); err != nil {
+				t.Errorf("AddMessage: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	history, _ := store.GetHistory(ctx, "s1")
+	if len(history) != 20 {
+		t.Errorf("expected 20 messages, got %d", len(history))
+	}
+}
+
+func TestMigrateFromJSONL(t *testing.T) {
+	jsonlDir := t.TempDir()
+	ctx := context.Background()
+
+	src, err := NewJSONLStore(jsonlDir)
+	if err != nil {
+		t.Fatalf("NewJSONLStore: %v", err)
+	}
+	for i := range 5 {
+		src.AddMessage(ctx, "telegram:123", "user", fmt.Sprintf("msg %d", i))
+	}
+	src.TruncateHistory(ctx, "telegram:123", 2)
+	src.SetSummary(ctx, "telegram:123", "earlier chat")
+	src.AddMessage(ctx, "cli", "user", "hi")
+
+	dst := newTestSQLiteStore(t)
+	n, err := MigrateFromJSONL(ctx, jsonlDir, dst)
+	if err != nil {
+		t.Fatalf("MigrateFromJSONL: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("migrated %d sessions, want 2", n)
+	}
+
+	history, _ := dst.GetHistory(ctx, "telegram:123")
+	if len(history) != 2 || history[0].Content != "msg 3" {
+		t.Errorf("unexpected migrated history: %+v", history)
+	}
+	summary, _ := dst.GetSummary(ctx, "telegram:123")
+	if summary != "earlier chat" {
+		t.Errorf("summary = %q, want %q", summary, "earlier chat")
+	}
+
+	for _, name := range []string{"telegram_123.jsonl.migrated", "telegram_123.meta.json.migrated"} {
+		if _, err := os.Stat(filepath.Join(jsonlDir, name)); err != nil {
+			t.Errorf("expected backup %s: %v", name, err)
+		}
+	}
+
+	// A second run finds nothing left to migrate.
+	n, err = MigrateFromJSONL(ctx, jsonlDir, dst)
+	if err != nil {
+		t.Fatalf("MigrateFromJSONL (second run): %v", err)
+	}
+	if n != 0 {
+		t.Errorf("second run migrated %d sessions, want 0", n)
+	}
+}
+
+func TestMigrateFromJSONL_NonexistentDir(t *testing.T) {
+	dst := newTestSQLiteStore(t)
+	n, err := MigrateFromJSONL(context.Background(), filepath.Join(t.TempDir(), "missing"), dst)
+	if err != nil || n != 0 {
+		t.Errorf("MigrateFromJSONL = (%d, %v), want (0, nil)", n, err)
+	}
+}
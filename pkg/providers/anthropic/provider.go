@@ -90,7 +90,54 @@ func (p *Provider) Chat(
 		return nil, fmt.Errorf("claude API call: %w", err)
 	}
 
-	return parseResponse(resp), nil
+	return structuredReply(parseResponse(resp), protocoltypes.ResponseFormatFromOptions(options)), nil
+}
+
+// ChatStream behaves like Chat but uses the streaming Messages API, reporting
+// text deltas through onDelta. Tool input JSON fragments are accumulated by
+// the SDK and decoded once the stream completes.
+func (p *Provider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta protocoltypes.StreamCallback,
+) (*LLMResponse, error) {
+	var opts []option.RequestOption
+	if p.tokenSource != nil {
+		tok, err := p.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		opts = append(opts, option.WithAuthToken(tok))
+	}
+
+	params, err := buildParams(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := p.client.Messages.NewStreaming(ctx, params, opts...)
+	defer stream.Close()
+
+	var message anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("claude API stream: %w", err)
+		}
+		if ev, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+			if delta, ok := ev.Delta.AsAny().(anthropic.TextDelta); ok && delta.Text != "" && onDelta != nil {
+				onDelta(delta.Text)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("claude API call: %w", err)
+	}
+
+	return structuredReply(parseResponse(&message), protocoltypes.ResponseFormatFromOptions(options)), nil
 }
 
 func (p *Provider) GetDefaultModel() string {
@@ -182,6 +229,13 @@ func buildParams(
 		params.Tools = translateTools(tools)
 	}
 
+	// Claude has no JSON mode: a requested response format is enforced by
+	// forcing a call to a tool whose input schema is the format.
+	if format := protocoltypes.ResponseFormatFromOptions(options); format != nil {
+		params.Tools = append(params.Tools, responseFormatTool(format))
+		params.ToolChoice = anthropic.ToolChoiceParamOfTool(format.SchemaName())
+	}
+
 	return params, nil
 }
 
@@ -197,20 +251,75 @@ func translateTools(tools []ToolDefinition) []anthropic.ToolUnionParam {
 		if desc := t.Function.Description; desc != "" {
 			tool.Description = anthropic.String(desc)
 		}
-		if req, ok := t.Function.Parameters["required"].([]any); ok {
-			required := make([]string, 0, len(req))
-			for _, r := range req {
-				if s, ok := r.(string); ok {
-					required = append(required, s)
-				}
-			}
-			tool.InputSchema.Required = required
-		}
+		tool.InputSchema.Required = stringSlice(t.Function.Parameters["required"])
 		result = append(result, anthropic.ToolUnionParam{OfTool: &tool})
 	}
 	return result
 }
 
+// responseFormatTool builds the tool Claude is forced to call when a
+// response format is requested. The format's schema must describe an
+// object.
+func responseFormatTool(format *protocoltypes.ResponseFormat) anthropic.ToolUnionParam {
+	schema := anthropic.ToolInputSchemaParam{ExtraFields: map[string]any{}}
+	for key, value := range format.Schema {
+		switch key {
+		case "type":
+		case "properties":
+			schema.Properties = value
+		case "required":
+			schema.Required = stringSlice(value)
+		default:
+			schema.ExtraFields[key] = value
+		}
+	}
+	tool := anthropic.ToolParam{
+		Name:        format.SchemaName(),
+		Description: anthropic.String("Return your final answer through this tool, in the required structure."),
+		InputSchema: schema,
+	}
+	return anthropic.ToolUnionParam{OfTool: &tool}
+}
+
+// structuredReply turns the forced response tool call back into a JSON
+// reply in Content, as other providers return it.
+func structuredReply(resp *LLMResponse, format *protocoltypes.ResponseFormat) *LLMResponse {
+	if format == nil {
+		return resp
+	}
+	for i, tc := range resp.ToolCalls {
+		if tc.Name != format.SchemaName() {
+			continue
+		}
+		raw, _ := json.Marshal(tc.Arguments)
+		resp.Content = string(raw)
+		resp.ToolCalls = append(resp.ToolCalls[:i:i], resp.ToolCalls[i+1:]...)
+		if len(resp.ToolCalls) == 0 {
+			resp.FinishReason = "stop"
+		}
+		break
+	}
+	return resp
+}
+
+// stringSlice converts a schema's "required" list, decoded from JSON or
+// built in Go, to strings.
+func stringSlice(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
 func parseResponse(resp *anthropic.Message) *LLMResponse {
 	var content strings.Builder
 	var toolCalls []ToolCall
@@ -2,6 +2,7 @@ package anthropicprovider
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sync/atomic"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
 )
 
 func TestBuildParams_BasicMessage(t *testing.T) {
@@ -193,6 +196,60 @@ func TestProvider_ChatRoundTrip(t *testing.T) {
 	}
 }
 
+func TestProvider_ChatResponseFormatForcesTool(t *testing.T) {
+	var reqBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		resp := map[string]any{
+			"id":          "msg_test",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       reqBody["model"],
+			"stop_reason": "tool_use",
+			"content": []map[string]any{
+				{"type": "tool_use", "id": "toolu_1", "name": "weather", "input": map[string]any{"temp_c": 21}},
+			},
+			"usage": map[string]any{"input_tokens": 30, "output_tokens": 12},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	provider := NewProviderWithClient(createAnthropicTestClient(server.URL, "test-token"))
+	format := &protocoltypes.ResponseFormat{
+		Name: "weather",
+		Schema: map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"temp_c": map[string]any{"type": "number"}},
+			"required":             []string{"temp_c"},
+			"additionalProperties": false,
+		},
+	}
+	resp, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Weather?"}}, nil,
+		"claude-sonnet-4.6", map[string]any{"response_format": format})
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	choice, _ := reqBody["tool_choice"].(map[string]any)
+	if choice["type"] != "tool" || choice["name"] != "weather" {
+		t.Errorf("tool_choice = %v", reqBody["tool_choice"])
+	}
+	tools, _ := reqBody["tools"].([]any)
+	if len(tools) != 1 {
+		t.Fatalf("tools = %v", reqBody["tools"])
+	}
+	schema := tools[0].(map[string]any)["input_schema"].(map[string]any)
+	if schema["additionalProperties"] != false || len(schema["required"].([]any)) != 1 {
+		t.Errorf("input_schema = %v", schema)
+	}
+
+	if resp.Content != `{"temp_c":21}` || len(resp.ToolCalls) != 0 || resp.FinishReason != "stop" {
+		t.Errorf("resp = %+v", resp)
+	}
+}
+
 func TestProvider_GetDefaultModel(t *testing.T) {
 	p := NewProvider("test-token")
 	if got := p.GetDefaultModel(); got != "claude-sonnet-4.6" {
@@ -269,3 +326,54 @@ func createAnthropicTestClient(baseURL, token string) *anthropic.Client {
 	)
 	return &c
 }
+
+func TestProvider_ChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []struct{ name, data string }{
+			{"message_start", `{"type":"message_start","message":{"id":"msg_test","type":"message",` +
+				`"role":"assistant","model":"claude-sonnet-4.6","content":[],"stop_reason":null,` +
+				`"usage":{"input_tokens":15,"output_tokens":1}}}`},
+			{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" there"}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+			{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":8}}`},
+			{"message_stop", `{"type":"message_stop"}`},
+		}
+		for _, ev := range events {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+		}
+	}))
+	defer server.Close()
+
+	var deltas []string
+	provider := NewProviderWithClient(createAnthropicTestClient(server.URL, "test-token"))
+	resp, err := provider.ChatStream(
+		t.Context(),
+		[]Message{{Role: "user", Content: "Hello"}},
+		nil,
+		"claude-sonnet-4.6",
+		map[string]any{"max_tokens": 1024},
+		func(delta string) { deltas = append(deltas, delta) },
+	)
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if len(deltas) != 2 || deltas[0] != "Hello" || deltas[1] != " there" {
+		t.Errorf("deltas = %v, want [Hello  there]", deltas)
+	}
+	if resp.Content != "Hello there" {
+		t.Errorf("Content = %q, want %q", resp.Content, "Hello there")
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, "stop")
+	}
+	if resp.Usage.CompletionTokens != 8 {
+		t.Errorf("CompletionTokens = %d, want 8", resp.Usage.CompletionTokens)
+	}
+}
@@ -152,6 +152,11 @@ func (p *AntigravityProvider) GetDefaultModel() string {
 	return antigravityDefaultModel
 }
 
+// SupportsResponseFormat implements ResponseFormatProvider.
+func (p *AntigravityProvider) SupportsResponseFormat() bool {
+	return true
+}
+
 // --- Request building ---
 
 type antigravityRequest struct {
@@ -199,8 +204,10 @@ type antigravitySystemPrompt struct {
 }
 
 type antigravityGenConfig struct {
-	MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
-	Temperature     float64 `json:"temperature,omitempty"`
+	MaxOutputTokens  int            `json:"maxOutputTokens,omitempty"`
+	Temperature      float64        `json:"temperature,omitempty"`
+	ResponseMimeType string         `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]any `json:"responseSchema,omitempty"`
 }
 
 func (p *AntigravityProvider) buildRequest(
@@ -321,7 +328,11 @@ func (p *AntigravityProvider) buildRequest(
 	if temp, ok := options["temperature"].(float64); ok {
 		config.Temperature = temp
 	}
-	if config.MaxOutputTokens > 0 || config.Temperature > 0 {
+	if format := ResponseFormatFromOptions(options); format != nil {
+		config.ResponseMimeType = "application/json"
+		config.ResponseSchema = sanitizeSchemaForGemini(format.Schema)
+	}
+	if config.MaxOutputTokens > 0 || config.Temperature > 0 || config.ResponseMimeType != "" {
 		req.Config = config
 	}
 
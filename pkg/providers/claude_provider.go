@@ -51,10 +51,26 @@ func (p *ClaudeProvider) Chat(
 	return resp, nil
 }
 
+func (p *ClaudeProvider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta StreamCallback,
+) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
 func (p *ClaudeProvider) GetDefaultModel() string {
 	return p.delegate.GetDefaultModel()
 }
 
+// SupportsResponseFormat implements ResponseFormatProvider.
+func (p *ClaudeProvider) SupportsResponseFormat() bool {
+	return true
+}
+
 func createClaudeTokenSource() func() (string, error) {
 	return func() (string, error) {
 		cred, err := getCredential("anthropic")
@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	ollamaprovider "github.com/sipeed/picoclaw/pkg/providers/ollama"
+	"github.com/sipeed/picoclaw/pkg/providers/openai_compat"
+)
+
+// Embedder turns text into embedding vectors for semantic search.
+type Embedder interface {
+	// Embed returns one vector per input text, in input order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// UsageReportingEmbedder is an Embedder that also reports the tokens each
+// call used, for usage accounting.
+type UsageReportingEmbedder interface {
+	Embedder
+	// EmbedWithUsage is Embed that also returns the reported token usage,
+	// or nil if the backend reported none.
+	EmbedWithUsage(ctx context.Context, texts []string) ([][]float32, *UsageInfo, error)
+}
+
+// HTTPEmbedder is an Embedder backed by an OpenAI-compatible /embeddings API.
+type HTTPEmbedder struct {
+	delegate *openai_compat.Provider
+	model    string
+}
+
+// NewHTTPEmbedder creates an embedder that calls apiBase+"/embeddings" with model.
+func NewHTTPEmbedder(apiKey, apiBase, proxy, model string, requestTimeoutSeconds int) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		delegate: openai_compat.NewProvider(
+			apiKey,
+			apiBase,
+			proxy,
+			openai_compat.WithRequestTimeout(time.Duration(requestTimeoutSeconds)*time.Second),
+		),
+		model: model,
+	}
+}
+
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return e.delegate.Embed(ctx, texts, e.model)
+}
+
+func (e *HTTPEmbedder) EmbedWithUsage(ctx context.Context, texts []string) ([][]float32, *UsageInfo, error) {
+	return e.delegate.EmbedWithUsage(ctx, texts, e.model)
+}
+
+// CreateEmbedderFromConfig creates an Embedder from a model_list entry.
+// Only protocols that speak the OpenAI-compatible embeddings API with an
+// API key are supported; OAuth and CLI-based providers are rejected.
+func CreateEmbedderFromConfig(cfg *config.ModelConfig) (Embedder, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+
+	protocol, modelID := ExtractProtocol(cfg.Model)
+
+	switch protocol {
+	case "openai", "litellm", "openrouter", "zhipu", "gemini", "nvidia",
+		"ollama", "shengsuanyun", "volcengine", "vllm", "qwen", "mistral":
+		if cfg.AuthMethod == "oauth" || cfg.AuthMethod == "token" {
+			return nil, fmt.Errorf("embeddings require an api_key; auth_method %q is not supported", cfg.AuthMethod)
+		}
+		if cfg.APIKey == "" && cfg.APIBase == "" {
+			return nil, fmt.Errorf("api_key or api_base is required for HTTP-based protocol %q", protocol)
+		}
+		apiBase := cfg.APIBase
+		if apiBase == "" {
+			apiBase = getDefaultAPIBase(protocol)
+		}
+		if protocol == "ollama" {
+			// Embeddings use Ollama's OpenAI-compatible endpoint, whatever
+			// form of the server URL the entry was written with.
+			apiBase = ollamaprovider.NormalizeBaseURL(apiBase) + "/v1"
+		}
+		if protocol == "gemini" {
+			// Likewise for Gemini, whose OpenAI-compatible API lives under /openai.
+			apiBase = normalizeGeminiBaseURL(apiBase) + "/openai"
+		}
+		return NewHTTPEmbedder(cfg.APIKey, apiBase, cfg.Proxy, modelID, cfg.RequestTimeout), nil
+
+	default:
+		return nil, fmt.Errorf("protocol %q does not support embeddings (model: %s)", protocol, cfg.Model)
+	}
+}
@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestCreateEmbedderFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.ModelConfig
+		wantErr bool
+	}{
+		{
+			name: "openai",
+			cfg:  &config.ModelConfig{ModelName: "emb", Model: "openai/text-embedding-3-small", APIKey: "sk-test"},
+		},
+		{
+			name: "ollama with api_base only",
+			cfg:  &config.ModelConfig{ModelName: "emb", Model: "ollama/nomic-embed-text", APIBase: "http://localhost:11434/v1"},
+		},
+		{
+			name:    "missing credentials",
+			cfg:     &config.ModelConfig{ModelName: "emb", Model: "openai/text-embedding-3-small"},
+			wantErr: true,
+		},
+		{
+			name: "oauth",
+			cfg: &config.ModelConfig{
+				ModelName: "emb", Model: "openai/text-embedding-3-small", AuthMethod: "oauth", APIKey: "x",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported protocol",
+			cfg:     &config.ModelConfig{ModelName: "emb", Model: "anthropic/claude-sonnet-4.6", APIKey: "sk-ant"},
+			wantErr: true,
+		},
+		{name: "nil config", cfg: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder, err := CreateEmbedderFromConfig(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got embedder %T", embedder)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateEmbedderFromConfig() error = %v", err)
+			}
+			httpEmbedder, ok := embedder.(*HTTPEmbedder)
+			if !ok {
+				t.Fatalf("embedder type = %T, want *HTTPEmbedder", embedder)
+			}
+			if httpEmbedder.model == "" || httpEmbedder.model == tt.cfg.Model {
+				t.Errorf("model = %q, want protocol prefix stripped", httpEmbedder.model)
+			}
+		})
+	}
+}
@@ -111,7 +111,6 @@ func TestCreateProviderFromConfig_DefaultAPIBase(t *testing.T) {
 		{"qwen", "qwen"},
 		{"vllm", "vllm"},
 		{"deepseek", "deepseek"},
-		{"ollama", "ollama"},
 	}
 
 	for _, tt := range tests {
@@ -135,6 +134,57 @@ func TestCreateProviderFromConfig_DefaultAPIBase(t *testing.T) {
 	}
 }
 
+func TestCreateProviderFromConfig_Ollama(t *testing.T) {
+	cfg := &config.ModelConfig{
+		ModelName: "llama3",
+		Model:     "ollama/llama3.2:3b",
+		APIBase:   "http://gpu-box:11434/v1",
+		KeepAlive: "1h",
+	}
+
+	provider, modelID, err := CreateProviderFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("CreateProviderFromConfig() error = %v", err)
+	}
+	if modelID != "llama3.2:3b" {
+		t.Errorf("modelID = %q, want %q", modelID, "llama3.2:3b")
+	}
+	ollama, ok := provider.(*OllamaProvider)
+	if !ok {
+		t.Fatalf("expected *OllamaProvider, got %T", provider)
+	}
+	if got := ollama.Client().BaseURL(); got != "http://gpu-box:11434" {
+		t.Errorf("BaseURL() = %q, want %q", got, "http://gpu-box:11434")
+	}
+	if _, ok := provider.(ContextWindowProvider); !ok {
+		t.Error("OllamaProvider should implement ContextWindowProvider")
+	}
+}
+
+func TestCreateProviderFromConfig_Gemini(t *testing.T) {
+	cfg := &config.ModelConfig{
+		ModelName: "gemini-flash",
+		Model:     "gemini/gemini-2.5-flash",
+		APIKey:    "test-key",
+	}
+
+	provider, modelID, err := CreateProviderFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("CreateProviderFromConfig() error = %v", err)
+	}
+	if modelID != "gemini-2.5-flash" {
+		t.Errorf("modelID = %q, want %q", modelID, "gemini-2.5-flash")
+	}
+	if _, ok := provider.(*GeminiProvider); !ok {
+		t.Fatalf("expected *GeminiProvider, got %T", provider)
+	}
+
+	cfg.APIKey = ""
+	if _, _, err := CreateProviderFromConfig(cfg); err == nil {
+		t.Fatal("expected an error without api_key")
+	}
+}
+
 func TestGetDefaultAPIBase_LiteLLM(t *testing.T) {
 	if got := getDefaultAPIBase("litellm"); got != "http://localhost:4000/v1" {
 		t.Fatalf("getDefaultAPIBase(%q) = %q, want %q", "litellm", got, "http://localhost:4000/v1")
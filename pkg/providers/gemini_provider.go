@@ -0,0 +1,487 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	geminiBaseURL        = "https://generativelanguage.googleapis.com/v1beta"
+	geminiRequestTimeout = 120 * time.Second
+)
+
+// GeminiProvider implements LLMProvider using the Gemini generateContent
+// REST API with an API key.
+type GeminiProvider struct {
+	apiKey         string
+	apiBase        string
+	safetySettings []config.GeminiSafetySetting
+	thinking       *config.GeminiThinkingConfig
+	httpClient     *http.Client
+}
+
+// NewGeminiProvider creates a provider for the Gemini API at apiBase (the
+// public endpoint when empty).
+func NewGeminiProvider(apiKey, apiBase, proxy string, requestTimeoutSeconds int) *GeminiProvider {
+	client := &http.Client{Timeout: geminiRequestTimeout}
+	if requestTimeoutSeconds > 0 {
+		client.Timeout = time.Duration(requestTimeoutSeconds) * time.Second
+	}
+	if proxy != "" {
+		if parsed, err := url.Parse(proxy); err == nil {
+			client.Transport = &http.Transport{Proxy: http.ProxyURL(parsed)}
+		} else {
+			logger.WarnCF("provider.gemini", "Invalid proxy URL", map[string]any{"proxy": proxy, "error": err.Error()})
+		}
+	}
+	return &GeminiProvider{
+		apiKey:     apiKey,
+		apiBase:    normalizeGeminiBaseURL(apiBase),
+		httpClient: client,
+	}
+}
+
+// NewGeminiProviderFromConfig creates a provider for a model_list entry,
+// including its safety settings and thinking configuration.
+func NewGeminiProviderFromConfig(cfg *config.ModelConfig) *GeminiProvider {
+	p := NewGeminiProvider(cfg.APIKey, cfg.APIBase, cfg.Proxy, cfg.RequestTimeout)
+	p.safetySettings = cfg.SafetySettings
+	p.thinking = cfg.Thinking
+	return p
+}
+
+// normalizeGeminiBaseURL returns the native API root for apiBase. Bases
+// written for the OpenAI-compatible endpoint (".../v1beta/openai") are
+// accepted.
+func normalizeGeminiBaseURL(apiBase string) string {
+	base := strings.TrimRight(strings.TrimSpace(apiBase), "/")
+	if base == "" {
+		return geminiBaseURL
+	}
+	return strings.TrimSuffix(base, "/openai")
+}
+
+func (p *GeminiProvider) Chat(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+) (*LLMResponse, error) {
+	resp, err := p.doRequest(ctx, model, "generateContent", p.buildRequest(messages, tools, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chunk geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	var acc geminiResponseBuilder
+	if err := acc.add(&chunk, nil); err != nil {
+		return nil, err
+	}
+	return acc.response(), nil
+}
+
+// ChatStream behaves like Chat but uses streamGenerateContent, reporting
+// text deltas through onDelta as they arrive.
+func (p *GeminiProvider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta StreamCallback,
+) (*LLMResponse, error) {
+	resp, err := p.doRequest(ctx, model, "streamGenerateContent?alt=sse", p.buildRequest(messages, tools, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseGeminiStream(resp.Body, onDelta)
+}
+
+func (p *GeminiProvider) GetDefaultModel() string {
+	return ""
+}
+
+// SupportsResponseFormat implements ResponseFormatProvider.
+func (p *GeminiProvider) SupportsResponseFormat() bool {
+	return true
+}
+
+// --- Request building ---
+
+type geminiRequest struct {
+	Contents          []geminiContent              `json:"contents"`
+	SystemInstruction *geminiContent               `json:"systemInstruction,omitempty"`
+	Tools             []antigravityTool            `json:"tools,omitempty"`
+	SafetySettings    []config.GeminiSafetySetting `json:"safetySettings,omitempty"`
+	GenerationConfig  *geminiGenerationConfig      `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                       `json:"text,omitempty"`
+	Thought          bool                         `json:"thought,omitempty"`
+	ThoughtSignature string                       `json:"thoughtSignature,omitempty"`
+	InlineData       *geminiInlineData            `json:"inlineData,omitempty"`
+	FunctionCall     *antigravityFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *antigravityFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens  int                   `json:"maxOutputTokens,omitempty"`
+	Temperature      *float64              `json:"temperature,omitempty"`
+	ThinkingConfig   *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+	ResponseMimeType string                `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]any        `json:"responseSchema,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  *int   `json:"thinkingBudget,omitempty"`
+	ThinkingLevel   string `json:"thinkingLevel,omitempty"`
+	IncludeThoughts bool   `json:"includeThoughts,omitempty"`
+}
+
+func (p *GeminiProvider) buildRequest(
+	messages []Message,
+	tools []ToolDefinition,
+	options map[string]any,
+) geminiRequest {
+	req := geminiRequest{SafetySettings: p.safetySettings}
+	toolCallNames := make(map[string]string)
+
+	// appendContent merges consecutive turns of the same role, so that the
+	// results of parallel tool calls are sent together.
+	appendContent := func(role string, parts ...geminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(req.Contents); n > 0 && req.Contents[n-1].Role == role {
+			req.Contents[n-1].Parts = append(req.Contents[n-1].Parts, parts...)
+			return
+		}
+		req.Contents = append(req.Contents, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			if req.SystemInstruction == nil {
+				req.SystemInstruction = &geminiContent{}
+			}
+			req.SystemInstruction.Parts = append(req.SystemInstruction.Parts, geminiPart{Text: msg.Content})
+		case "assistant":
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				name, args, thoughtSignature := normalizeStoredToolCall(tc)
+				if name == "" {
+					continue
+				}
+				if thoughtSignature == "" {
+					thoughtSignature = storedThoughtSignature(tc)
+				}
+				if tc.ID != "" {
+					toolCallNames[tc.ID] = name
+				}
+				parts = append(parts, geminiPart{
+					ThoughtSignature: thoughtSignature,
+					FunctionCall:     &antigravityFunctionCall{Name: name, Args: args},
+				})
+			}
+			appendContent("model", parts...)
+		case "tool":
+			appendContent("user", geminiPart{
+				FunctionResponse: &antigravityFunctionResponse{
+					Name:     resolveToolResponseName(msg.ToolCallID, toolCallNames),
+					Response: map[string]any{"result": msg.Content},
+				},
+			})
+		default:
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, media := range msg.Media {
+				if inline, ok := geminiInline(media); ok {
+					parts = append(parts, geminiPart{InlineData: inline})
+				} else {
+					logger.WarnCF("provider.gemini", "Skipping media that is not a base64 data URL", nil)
+				}
+			}
+			appendContent("user", parts...)
+		}
+	}
+
+	if len(tools) > 0 {
+		var funcDecls []antigravityFuncDecl
+		for _, t := range tools {
+			if t.Type != "function" {
+				continue
+			}
+			funcDecls = append(funcDecls, antigravityFuncDecl{
+				Name:        t.Function.Name,
+				Description: t.Function.Description,
+				Parameters:  sanitizeSchemaForGemini(t.Function.Parameters),
+			})
+		}
+		if len(funcDecls) > 0 {
+			req.Tools = []antigravityTool{{FunctionDeclarations: funcDecls}}
+		}
+	}
+
+	genConfig := &geminiGenerationConfig{}
+	switch v := options["max_tokens"].(type) {
+	case int:
+		genConfig.MaxOutputTokens = v
+	case float64:
+		genConfig.MaxOutputTokens = int(v)
+	}
+	if temp, ok := options["temperature"].(float64); ok {
+		genConfig.Temperature = &temp
+	}
+	if p.thinking != nil {
+		genConfig.ThinkingConfig = &geminiThinkingConfig{
+			ThinkingBudget:  p.thinking.Budget,
+			ThinkingLevel:   p.thinking.Level,
+			IncludeThoughts: p.thinking.IncludeThoughts,
+		}
+	}
+	if format := ResponseFormatFromOptions(options); format != nil {
+		genConfig.ResponseMimeType = "application/json"
+		genConfig.ResponseSchema = sanitizeSchemaForGemini(format.Schema)
+	}
+	if genConfig.MaxOutputTokens > 0 || genConfig.Temperature != nil || genConfig.ThinkingConfig != nil ||
+		genConfig.ResponseMimeType != "" {
+		req.GenerationConfig = genConfig
+	}
+
+	return req
+}
+
+// storedThoughtSignature returns the signature kept on a tool call by
+// providers that store it outside the Function field.
+func storedThoughtSignature(tc ToolCall) string {
+	if tc.ExtraContent != nil && tc.ExtraContent.Google != nil && tc.ExtraContent.Google.ThoughtSignature != "" {
+		return tc.ExtraContent.Google.ThoughtSignature
+	}
+	return tc.ThoughtSignature
+}
+
+// geminiInline converts a "data:<mime>;base64,<data>" URL to inline data.
+func geminiInline(mediaURL string) (*geminiInlineData, bool) {
+	rest, ok := strings.CutPrefix(mediaURL, "data:")
+	if !ok {
+		return nil, false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, false
+	}
+	mimeType, ok := strings.CutSuffix(header, ";base64")
+	if !ok {
+		return nil, false
+	}
+	return &geminiInlineData{MimeType: mimeType, Data: data}, true
+}
+
+// doRequest posts body to the model's method and returns the response for
+// the caller to consume. Non-200 responses are turned into errors.
+func (p *GeminiProvider) doRequest(
+	ctx context.Context, model, method string, body geminiRequest,
+) (*http.Response, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("gemini: api_key is not configured")
+	}
+	model = strings.TrimPrefix(strings.TrimPrefix(model, "gemini/"), "models/")
+
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:%s", p.apiBase, url.PathEscape(model), method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", p.apiKey)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(respBody))
+	}
+
+	return resp, nil
+}
+
+// --- Response parsing ---
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []geminiPart `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// geminiResponseBuilder assembles an LLMResponse from one response or the
+// chunks of a stream.
+type geminiResponseBuilder struct {
+	content      strings.Builder
+	thoughts     strings.Builder
+	toolCalls    []ToolCall
+	finishReason string
+	usage        *UsageInfo
+}
+
+func (b *geminiResponseBuilder) add(chunk *geminiResponse, onDelta StreamCallback) error {
+	if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" && len(chunk.Candidates) == 0 {
+		return fmt.Errorf("gemini: prompt blocked (%s)", chunk.PromptFeedback.BlockReason)
+	}
+	if len(chunk.Candidates) > 0 {
+		candidate := chunk.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				b.toolCalls = append(b.toolCalls, geminiToolCall(part, len(b.toolCalls)))
+			case part.Thought:
+				b.thoughts.WriteString(part.Text)
+			case part.Text != "":
+				b.content.WriteString(part.Text)
+				if onDelta != nil {
+					onDelta(part.Text)
+				}
+			}
+		}
+		if candidate.FinishReason != "" {
+			b.finishReason = candidate.FinishReason
+		}
+	}
+	if u := chunk.UsageMetadata; u != nil && u.TotalTokenCount > 0 {
+		b.usage = &UsageInfo{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			TotalTokens:      u.TotalTokenCount,
+		}
+	}
+	return nil
+}
+
+func (b *geminiResponseBuilder) response() *LLMResponse {
+	finishReason := "stop"
+	switch {
+	case len(b.toolCalls) > 0:
+		finishReason = "tool_calls"
+	case b.finishReason == "MAX_TOKENS":
+		finishReason = "length"
+	case b.finishReason == "SAFETY" || b.finishReason == "PROHIBITED_CONTENT" || b.finishReason == "BLOCKLIST":
+		finishReason = "content_filter"
+	}
+	return &LLMResponse{
+		Content:          b.content.String(),
+		ReasoningContent: b.thoughts.String(),
+		ToolCalls:        b.toolCalls,
+		FinishReason:     finishReason,
+		Usage:            b.usage,
+	}
+}
+
+// geminiToolCall converts a functionCall part, keeping its thought signature
+// so it can be echoed back on the next turn.
+func geminiToolCall(part geminiPart, index int) ToolCall {
+	args := part.FunctionCall.Args
+	if args == nil {
+		args = map[string]any{}
+	}
+	argumentsJSON, _ := json.Marshal(args)
+	toolCall := ToolCall{
+		ID:        fmt.Sprintf("call_%s_%d%d", part.FunctionCall.Name, time.Now().UnixNano(), index),
+		Type:      "function",
+		Name:      part.FunctionCall.Name,
+		Arguments: args,
+		Function: &FunctionCall{
+			Name:             part.FunctionCall.Name,
+			Arguments:        string(argumentsJSON),
+			ThoughtSignature: part.ThoughtSignature,
+		},
+		ThoughtSignature: part.ThoughtSignature,
+	}
+	if part.ThoughtSignature != "" {
+		toolCall.ExtraContent = &ExtraContent{Google: &GoogleExtra{ThoughtSignature: part.ThoughtSignature}}
+	}
+	return toolCall
+}
+
+// parseGeminiStream reads a streamGenerateContent SSE stream.
+func parseGeminiStream(body io.Reader, onDelta StreamCallback) (*LLMResponse, error) {
+	var acc geminiResponseBuilder
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok && strings.TrimSpace(data) != "" {
+			var chunk geminiResponse
+			if jsonErr := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); jsonErr != nil {
+				return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", jsonErr)
+			}
+			if addErr := acc.add(&chunk, onDelta); addErr != nil {
+				return nil, addErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return acc.response(), nil
+}
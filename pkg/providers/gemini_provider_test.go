@@ -0,0 +1,250 @@
+package providers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// newGeminiFixtureServer replays a recorded Gemini API response and
+// captures the request that was sent.
+func newGeminiFixtureServer(
+	t *testing.T, fixture string, status int,
+) (*httptest.Server, *map[string]any, *http.Request) {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "gemini", fixture))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	captured := map[string]any{}
+	var lastReq http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastReq = *r.Clone(r.Context())
+		raw, _ := io.ReadAll(r.Body)
+		json.Unmarshal(raw, &captured)
+		if strings.HasSuffix(fixture, ".sse") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &captured, &lastReq
+}
+
+func TestGeminiProvider_ChatText(t *testing.T) {
+	srv, _, lastReq := newGeminiFixtureServer(t, "text_response.json", http.StatusOK)
+	p := NewGeminiProvider("test-key", srv.URL+"/v1beta/openai", "", 0)
+
+	resp, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "How many r's in strawberry?"}},
+		nil, "gemini-2.5-flash", nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if lastReq.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+		t.Errorf("path = %q", lastReq.URL.Path)
+	}
+	if got := lastReq.Header.Get("x-goog-api-key"); got != "test-key" {
+		t.Errorf("x-goog-api-key = %q", got)
+	}
+	if resp.Content != `There are three r's in "strawberry".` {
+		t.Errorf("Content = %q", resp.Content)
+	}
+	if !strings.HasPrefix(resp.ReasoningContent, "**Counting the letters**") {
+		t.Errorf("ReasoningContent = %q", resp.ReasoningContent)
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("FinishReason = %q", resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 75 ||
+		resp.Usage.TotalTokens != 87 {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+}
+
+func TestGeminiProvider_ToolCallsKeepThoughtSignature(t *testing.T) {
+	srv, _, _ := newGeminiFixtureServer(t, "tool_call_response.json", http.StatusOK)
+	p := NewGeminiProvider("test-key", srv.URL, "", 0)
+
+	resp, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "Weather in Paris and London?"}},
+		nil, "gemini-2.5-flash", nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if resp.FinishReason != "tool_calls" || len(resp.ToolCalls) != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	first := resp.ToolCalls[0]
+	if first.Name != "web_fetch" || first.Arguments["url"] != "https://example.com/weather/paris" {
+		t.Errorf("first tool call = %+v", first)
+	}
+	const sig = "CiQBVKhc7kxqJ3H0oVgo9ZpJxv1m0Bx7Q1zM0s8TFl8h"
+	if first.ExtraContent == nil || first.ExtraContent.Google.ThoughtSignature != sig ||
+		first.Function.ThoughtSignature != sig {
+		t.Errorf("thought signature not kept: %+v", first)
+	}
+	if resp.ToolCalls[1].ExtraContent != nil {
+		t.Errorf("second call has no signature, got %+v", resp.ToolCalls[1].ExtraContent)
+	}
+	if first.ID == resp.ToolCalls[1].ID {
+		t.Errorf("tool call IDs should be unique, both are %q", first.ID)
+	}
+}
+
+func TestGeminiProvider_BuildRequest(t *testing.T) {
+	srv, captured, _ := newGeminiFixtureServer(t, "text_response.json", http.StatusOK)
+	budget := 1024
+	p := NewGeminiProviderFromConfig(&config.ModelConfig{
+		Model:   "gemini/gemini-2.5-flash",
+		APIKey:  "test-key",
+		APIBase: srv.URL,
+		SafetySettings: []config.GeminiSafetySetting{
+			{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+		},
+		Thinking: &config.GeminiThinkingConfig{Budget: &budget, IncludeThoughts: true},
+	})
+
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: "What is in this picture?", Media: []string{"data:image/jpeg;base64,/9j/4AAQ"}},
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{
+				ID:           "call_1",
+				Name:         "web_fetch",
+				Arguments:    map[string]any{"url": "https://a"},
+				ExtraContent: &ExtraContent{Google: &GoogleExtra{ThoughtSignature: "sig-a"}},
+			},
+			{ID: "call_2", Name: "web_fetch", Arguments: map[string]any{"url": "https://b"}},
+		}},
+		{Role: "tool", Content: "page a", ToolCallID: "call_1"},
+		{Role: "tool", Content: "page b", ToolCallID: "call_2"},
+	}
+	tools := []ToolDefinition{{
+		Type: "function",
+		Function: ToolFunctionDefinition{
+			Name: "web_fetch",
+			Parameters: map[string]any{
+				"properties": map[string]any{
+					"url": map[string]any{"type": "string", "format": "uri"},
+				},
+				"additionalProperties": false,
+			},
+		},
+	}}
+
+	if _, err := p.Chat(t.Context(), messages, tools, "gemini-2.5-flash",
+		map[string]any{"max_tokens": 2048, "temperature": 0.0}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var req struct {
+		Contents          []geminiContent              `json:"contents"`
+		SystemInstruction geminiContent                `json:"systemInstruction"`
+		Tools             []antigravityTool            `json:"tools"`
+		SafetySettings    []config.GeminiSafetySetting `json:"safetySettings"`
+		GenerationConfig  struct {
+			MaxOutputTokens int      `json:"maxOutputTokens"`
+			Temperature     *float64 `json:"temperature"`
+			ThinkingConfig  struct {
+				ThinkingBudget  int  `json:"thinkingBudget"`
+				IncludeThoughts bool `json:"includeThoughts"`
+			} `json:"thinkingConfig"`
+		} `json:"generationConfig"`
+	}
+	raw, _ := json.Marshal(*captured)
+	if err := json.Unmarshal(raw, &req); err != nil {
+		t.Fatalf("decoding captured request: %v", err)
+	}
+
+	if len(req.SystemInstruction.Parts) != 1 || req.SystemInstruction.Parts[0].Text != "You are helpful." {
+		t.Errorf("systemInstruction = %+v", req.SystemInstruction)
+	}
+	if len(req.Contents) != 3 {
+		t.Fatalf("contents = %+v", req.Contents)
+	}
+	user := req.Contents[0]
+	if user.Role != "user" || len(user.Parts) != 2 || user.Parts[1].InlineData == nil ||
+		user.Parts[1].InlineData.MimeType != "image/jpeg" || user.Parts[1].InlineData.Data != "/9j/4AAQ" {
+		t.Errorf("user content = %+v", user)
+	}
+	model := req.Contents[1]
+	if model.Role != "model" || len(model.Parts) != 2 || model.Parts[0].ThoughtSignature != "sig-a" {
+		t.Errorf("model content = %+v", model)
+	}
+	results := req.Contents[2]
+	if results.Role != "user" || len(results.Parts) != 2 || results.Parts[1].FunctionResponse.Name != "web_fetch" {
+		t.Errorf("tool results should be merged into one turn: %+v", results)
+	}
+
+	params := req.Tools[0].FunctionDeclarations[0].Parameters.(map[string]any)
+	if _, ok := params["additionalProperties"]; ok || params["type"] != "object" {
+		t.Errorf("parameters not sanitized: %v", params)
+	}
+	if url := params["properties"].(map[string]any)["url"].(map[string]any); url["format"] != nil {
+		t.Errorf("nested schema not sanitized: %v", url)
+	}
+
+	if len(req.SafetySettings) != 1 || req.SafetySettings[0].Threshold != "BLOCK_ONLY_HIGH" {
+		t.Errorf("safetySettings = %+v", req.SafetySettings)
+	}
+	gen := req.GenerationConfig
+	if gen.MaxOutputTokens != 2048 || gen.Temperature == nil || *gen.Temperature != 0 {
+		t.Errorf("generationConfig = %+v", gen)
+	}
+	if gen.ThinkingConfig.ThinkingBudget != 1024 || !gen.ThinkingConfig.IncludeThoughts {
+		t.Errorf("thinkingConfig = %+v", gen.ThinkingConfig)
+	}
+}
+
+func TestGeminiProvider_ChatStream(t *testing.T) {
+	srv, _, lastReq := newGeminiFixtureServer(t, "stream_response.sse", http.StatusOK)
+	p := NewGeminiProvider("test-key", srv.URL, "", 0)
+
+	var deltas []string
+	resp, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-flash", nil,
+		func(delta string) { deltas = append(deltas, delta) })
+	if err != nil {
+		t.Fatalf("ChatStream() error = %v", err)
+	}
+
+	if lastReq.URL.Path != "/models/gemini-2.5-flash:streamGenerateContent" || lastReq.URL.Query().Get("alt") != "sse" {
+		t.Errorf("URL = %s", lastReq.URL)
+	}
+	if strings.Join(deltas, "|") != "Hello| from Gemini|!" || resp.Content != "Hello from Gemini!" {
+		t.Errorf("deltas = %q, content = %q", deltas, resp.Content)
+	}
+	if resp.Usage == nil || resp.Usage.CompletionTokens != 24 || resp.Usage.TotalTokens != 29 {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+}
+
+func TestGeminiProvider_BlockedPrompt(t *testing.T) {
+	srv, _, _ := newGeminiFixtureServer(t, "blocked_response.json", http.StatusOK)
+	p := NewGeminiProvider("test-key", srv.URL, "", 0)
+
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "..."}}, nil, "gemini-2.5-flash", nil)
+	if err == nil || !strings.Contains(err.Error(), "prompt blocked (SAFETY)") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestGeminiProvider_APIError(t *testing.T) {
+	srv, _, _ := newGeminiFixtureServer(t, "error_response.json", http.StatusBadRequest)
+	p := NewGeminiProvider("bad-key", srv.URL, "", 0)
+
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-flash", nil)
+	if err == nil || !strings.Contains(err.Error(), "Status: 400") || !strings.Contains(err.Error(), "API_KEY_INVALID") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -52,6 +52,22 @@ func (p *HTTPProvider) Chat(
 	return p.delegate.Chat(ctx, messages, tools, model, options)
 }
 
+func (p *HTTPProvider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta StreamCallback,
+) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
 func (p *HTTPProvider) GetDefaultModel() string {
 	return ""
 }
+
+// SupportsResponseFormat implements ResponseFormatProvider.
+func (p *HTTPProvider) SupportsResponseFormat() bool {
+	return true
+}
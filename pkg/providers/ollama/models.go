@@ -0,0 +1,217 @@
+package ollamaprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelInfo describes a model installed on the Ollama server.
+type ModelInfo struct {
+	Name          string
+	Size          int64
+	ModifiedAt    time.Time
+	Family        string
+	ParameterSize string
+	Quantization  string
+	// Loaded reports whether the model is currently in memory, and
+	// ExpiresAt when the server will unload it.
+	Loaded    bool
+	SizeVRAM  int64
+	ExpiresAt time.Time
+}
+
+// PullProgress is one status update of a model download.
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+}
+
+// ListModels returns the installed models sorted by name, with their
+// load state.
+func (p *Provider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var tags struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			Size       int64     `json:"size"`
+			ModifiedAt time.Time `json:"modified_at"`
+			Details    struct {
+				Family            string `json:"family"`
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	if err := p.getJSON(ctx, "/api/tags", &tags); err != nil {
+		return nil, err
+	}
+
+	var running struct {
+		Models []struct {
+			Name      string    `json:"name"`
+			SizeVRAM  int64     `json:"size_vram"`
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"models"`
+	}
+	if err := p.getJSON(ctx, "/api/ps", &running); err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]int, len(running.Models))
+	for i, m := range running.Models {
+		loaded[m.Name] = i
+	}
+
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		info := ModelInfo{
+			Name:          m.Name,
+			Size:          m.Size,
+			ModifiedAt:    m.ModifiedAt,
+			Family:        m.Details.Family,
+			ParameterSize: m.Details.ParameterSize,
+			Quantization:  m.Details.QuantizationLevel,
+		}
+		if i, ok := loaded[m.Name]; ok {
+			info.Loaded = true
+			info.SizeVRAM = running.Models[i].SizeVRAM
+			info.ExpiresAt = running.Models[i].ExpiresAt
+		}
+		models = append(models, info)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models, nil
+}
+
+// Pull downloads model from the registry, reporting progress through
+// onProgress (which may be nil).
+func (p *Provider) Pull(ctx context.Context, model string, onProgress func(PullProgress)) error {
+	resp, err := p.doRequest(ctx, http.MethodPost, "/api/pull", map[string]any{
+		"model":  model,
+		"stream": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var success bool
+	err = readLines(resp.Body, func(line []byte) error {
+		var progress struct {
+			PullProgress
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &progress); err != nil {
+			return fmt.Errorf("failed to unmarshal pull progress: %w", err)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("pulling %s: %s", model, progress.Error)
+		}
+		success = progress.Status == "success"
+		if onProgress != nil {
+			onProgress(progress.PullProgress)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("pulling %s: stream ended before the download completed", model)
+	}
+	return nil
+}
+
+// ContextWindow returns the number of tokens model can attend to on this
+// server: the configured num_ctx, else the num_ctx parameter of the
+// model's Modelfile, else the context length the model was trained with.
+// Chats request this window as num_ctx, since the server would otherwise
+// run the model with its own, much smaller default. Discovered windows are
+// cached per model.
+func (p *Provider) ContextWindow(ctx context.Context, model string) (int, error) {
+	if p.numCtx > 0 {
+		return p.numCtx, nil
+	}
+
+	name := strings.TrimPrefix(model, "ollama/")
+	p.mu.Lock()
+	n, ok := p.windows[name]
+	p.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+
+	n, err := p.showContextWindow(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	p.windows[name] = n
+	p.mu.Unlock()
+	return n, nil
+}
+
+// numCtxFor returns the num_ctx to request for model, or 0 to leave it to
+// the server when the context window cannot be discovered.
+func (p *Provider) numCtxFor(ctx context.Context, model string) int {
+	n, err := p.ContextWindow(ctx, model)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// showContextWindow reads the context window of model from /api/show.
+func (p *Provider) showContextWindow(ctx context.Context, model string) (int, error) {
+	resp, err := p.doRequest(ctx, http.MethodPost, "/api/show", map[string]any{
+		"model": model,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var show struct {
+		Parameters string         `json:"parameters"`
+		ModelInfo  map[string]any `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				return n, nil
+			}
+		}
+	}
+	for key, value := range show.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if n, ok := asInt(value); ok && n > 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("ollama: no context length reported for %s", model)
+}
+
+func (p *Provider) getJSON(ctx context.Context, path string, out any) error {
+	resp, err := p.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package ollamaprovider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+type (
+	ToolCall       = protocoltypes.ToolCall
+	FunctionCall   = protocoltypes.FunctionCall
+	LLMResponse    = protocoltypes.LLMResponse
+	UsageInfo      = protocoltypes.UsageInfo
+	Message        = protocoltypes.Message
+	ToolDefinition = protocoltypes.ToolDefinition
+)
+
+// DefaultBaseURL is the address of a local Ollama server.
+const DefaultBaseURL = "http://localhost:11434"
+
+const defaultRequestTimeout = 300 * time.Second
+
+// Provider talks to the native Ollama API (/api/chat, /api/tags, ...)
+// instead of its OpenAI-compatible endpoint, so that Ollama-specific
+// settings such as keep_alive and num_ctx can be used.
+type Provider struct {
+	apiKey     string
+	apiBase    string
+	keepAlive  string
+	numCtx     int
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// windows caches the context window discovered per model.
+	windows map[string]int
+}
+
+type Option func(*Provider)
+
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(p *Provider) {
+		if timeout > 0 {
+			p.httpClient.Timeout = timeout
+		}
+	}
+}
+
+// WithKeepAlive sets how long the server keeps the model loaded after a
+// request, as an Ollama duration ("5m", "1h", "-1" for forever).
+func WithKeepAlive(keepAlive string) Option {
+	return func(p *Provider) {
+		p.keepAlive = keepAlive
+	}
+}
+
+// WithNumCtx sets the context window requested for every chat. Without it,
+// the window reported by ContextWindow is requested.
+func WithNumCtx(numCtx int) Option {
+	return func(p *Provider) {
+		p.numCtx = numCtx
+	}
+}
+
+// NewProvider creates a provider for the Ollama server at apiBase. The API
+// key is optional and only needed when the server sits behind an
+// authenticating proxy.
+func NewProvider(apiKey, apiBase, proxy string, opts ...Option) *Provider {
+	client := &http.Client{
+		Timeout: defaultRequestTimeout,
+	}
+
+	if proxy != "" {
+		parsed, err := url.Parse(proxy)
+		if err == nil {
+			client.Transport = &http.Transport{
+				Proxy: http.ProxyURL(parsed),
+			}
+		} else {
+			log.Printf("ollama: invalid proxy URL %q: %v", proxy, err)
+		}
+	}
+
+	p := &Provider{
+		apiKey:     apiKey,
+		apiBase:    NormalizeBaseURL(apiBase),
+		httpClient: client,
+		windows:    make(map[string]int),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(p)
+		}
+	}
+
+	return p
+}
+
+// NormalizeBaseURL returns the server root for apiBase. Bases written for
+// the OpenAI-compatible endpoint ("http://host:11434/v1") are accepted.
+func NormalizeBaseURL(apiBase string) string {
+	base := strings.TrimRight(strings.TrimSpace(apiBase), "/")
+	if base == "" {
+		return DefaultBaseURL
+	}
+	base = strings.TrimSuffix(base, "/v1")
+	return strings.TrimSuffix(base, "/api")
+}
+
+// BaseURL returns the server root the provider talks to.
+func (p *Provider) BaseURL() string {
+	return p.apiBase
+}
+
+func (p *Provider) GetDefaultModel() string {
+	return ""
+}
+
+// chatResponse is a /api/chat response, or one line of a streamed one.
+type chatResponse struct {
+	Message struct {
+		Role      string `json:"role"`
+		Content   string `json:"content"`
+		Thinking  string `json:"thinking"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func (p *Provider) Chat(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+) (*LLMResponse, error) {
+	requestBody := p.buildRequestBody(messages, tools, model, p.numCtxFor(ctx, model), options)
+	requestBody["stream"] = false
+
+	resp, err := p.doRequest(ctx, http.MethodPost, "/api/chat", requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chunk chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if chunk.Error != "" {
+		return nil, fmt.Errorf("ollama: %s", chunk.Error)
+	}
+
+	var acc responseBuilder
+	acc.add(&chunk, nil)
+	return acc.response(), nil
+}
+
+// ChatStream behaves like Chat but reads Ollama's newline-delimited JSON
+// stream, reporting content deltas through onDelta as they arrive.
+func (p *Provider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta protocoltypes.StreamCallback,
+) (*LLMResponse, error) {
+	requestBody := p.buildRequestBody(messages, tools, model, p.numCtxFor(ctx, model), options)
+	requestBody["stream"] = true
+
+	resp, err := p.doRequest(ctx, http.MethodPost, "/api/chat", requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var acc responseBuilder
+	err = readLines(resp.Body, func(line []byte) error {
+		var chunk chatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		acc.add(&chunk, onDelta)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc.response(), nil
+}
+
+// responseBuilder assembles an LLMResponse from one or more chat chunks.
+type responseBuilder struct {
+	content   strings.Builder
+	thinking  strings.Builder
+	toolCalls []ToolCall
+	reason    string
+	usage     *UsageInfo
+}
+
+func (b *responseBuilder) add(chunk *chatResponse, onDelta protocoltypes.StreamCallback) {
+	if chunk.Message.Content != "" {
+		b.content.WriteString(chunk.Message.Content)
+		if onDelta != nil {
+			onDelta(chunk.Message.Content)
+		}
+	}
+	b.thinking.WriteString(chunk.Message.Thinking)
+	for _, tc := range chunk.Message.ToolCalls {
+		arguments := tc.Function.Arguments
+		if arguments == nil {
+			arguments = make(map[string]any)
+		}
+		argumentsJSON, _ := json.Marshal(arguments)
+		// Ollama does not assign tool call IDs; make one up so the
+		// results can be matched to their calls.
+		id := "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+		b.toolCalls = append(b.toolCalls, ToolCall{
+			ID:        id,
+			Type:      "function",
+			Name:      tc.Function.Name,
+			Arguments: arguments,
+			Function: &FunctionCall{
+				Name:      tc.Function.Name,
+				Arguments: string(argumentsJSON),
+			},
+		})
+	}
+	if chunk.Done {
+		b.reason = chunk.DoneReason
+		b.usage = &UsageInfo{
+			PromptTokens:     chunk.PromptEvalCount,
+			CompletionTokens: chunk.EvalCount,
+			TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+		}
+	}
+}
+
+func (b *responseBuilder) response() *LLMResponse {
+	reason := b.reason
+	switch {
+	case len(b.toolCalls) > 0:
+		reason = "tool_calls"
+	case reason == "":
+		reason = "stop"
+	}
+	return &LLMResponse{
+		Content:          b.content.String(),
+		ReasoningContent: b.thinking.String(),
+		ToolCalls:        b.toolCalls,
+		FinishReason:     reason,
+		Usage:            b.usage,
+	}
+}
+
+func (p *Provider) buildRequestBody(
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	numCtx int,
+	options map[string]any,
+) map[string]any {
+	requestBody := map[string]any{
+		"model":    strings.TrimPrefix(model, "ollama/"),
+		"messages": serializeMessages(messages),
+	}
+
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+
+	if p.keepAlive != "" {
+		requestBody["keep_alive"] = p.keepAlive
+	}
+
+	modelOptions := make(map[string]any)
+	if numCtx > 0 {
+		modelOptions["num_ctx"] = numCtx
+	}
+	if maxTokens, ok := asInt(options["max_tokens"]); ok {
+		modelOptions["num_predict"] = maxTokens
+	}
+	if temperature, ok := asFloat(options["temperature"]); ok {
+		modelOptions["temperature"] = temperature
+	}
+	if len(modelOptions) > 0 {
+		requestBody["options"] = modelOptions
+	}
+
+	return requestBody
+}
+
+// ollamaMessage is the wire format of a /api/chat message.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+// serializeMessages converts messages to the /api/chat format:
+//   - media data URLs become base64 "images"
+//   - tool call arguments are sent as JSON objects
+//   - tool results carry the name of the tool they answer
+func serializeMessages(messages []Message) []ollamaMessage {
+	toolNames := make(map[string]string)
+	out := make([]ollamaMessage, 0, len(messages))
+	for _, m := range messages {
+		msg := ollamaMessage{
+			Role:     m.Role,
+			Content:  m.Content,
+			Thinking: m.ReasoningContent,
+		}
+		for _, media := range m.Media {
+			if data, ok := imageData(media); ok {
+				msg.Images = append(msg.Images, data)
+			} else {
+				log.Printf("ollama: skipping media that is not a base64 data URL")
+			}
+		}
+		for _, tc := range m.ToolCalls {
+			name, arguments := toolCallParts(tc)
+			toolNames[tc.ID] = name
+			var call ollamaToolCall
+			call.Function.Name = name
+			call.Function.Arguments = arguments
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		if m.Role == "tool" {
+			msg.ToolName = toolNames[m.ToolCallID]
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// toolCallParts returns the name and decoded arguments of a tool call,
+// which may carry them either directly or in its Function field.
+func toolCallParts(tc ToolCall) (string, map[string]any) {
+	name, arguments := tc.Name, tc.Arguments
+	if tc.Function != nil {
+		if name == "" {
+			name = tc.Function.Name
+		}
+		if arguments == nil && tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &arguments); err != nil {
+				log.Printf("ollama: failed to decode tool call arguments for %q: %v", name, err)
+			}
+		}
+	}
+	if arguments == nil {
+		arguments = make(map[string]any)
+	}
+	return name, arguments
+}
+
+// imageData extracts the base64 payload of a "data:<mime>;base64,<data>" URL.
+func imageData(mediaURL string) (string, bool) {
+	rest, ok := strings.CutPrefix(mediaURL, "data:")
+	if !ok {
+		return "", false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", false
+	}
+	return data, true
+}
+
+// doRequest sends requestBody (if any) to path and returns the response for
+// the caller to consume. Non-200 responses are turned into errors.
+func (p *Provider) doRequest(ctx context.Context, method, path string, requestBody any) (*http.Response, error) {
+	var body io.Reader
+	if requestBody != nil {
+		jsonData, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiBase+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(respBody))
+	}
+
+	return resp, nil
+}
+
+// readLines calls fn for every non-empty line of a newline-delimited JSON
+// stream.
+func readLines(body io.Reader, fn func(line []byte) error) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if fnErr := fn(line); fnErr != nil {
+				return fnErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+func asInt(v any) (int, bool) {
+	switch val := v.(type) {
+	case int:
+		return val, true
+	case int64:
+		return int(val), true
+	case float64:
+		return int(val), true
+	case float32:
+		return int(val), true
+	default:
+		return 0, false
+	}
+}
+
+func asFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,277 @@
+package ollamaprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+// fakeOllama is an httptest server implementing the parts of the Ollama
+// API the provider uses.
+type fakeOllama struct {
+	*httptest.Server
+	lastChat map[string]any
+	pulled   []string
+}
+
+func newFakeOllama(t *testing.T) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&f.lastChat); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.lastChat["model"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model \"missing\" not found, try pulling it first"}`)
+			return
+		}
+		if stream, _ := f.lastChat["stream"].(bool); stream {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"lo"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[`+
+				`{"function":{"name":"read_file","arguments":{"path":"a.txt"}}}]},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,`+
+				`"done_reason":"stop","prompt_eval_count":10,"eval_count":3}`)
+			return
+		}
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"hi there","thinking":"greet"},`+
+			`"done":true,"done_reason":"stop","prompt_eval_count":7,"eval_count":2}`)
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"models":[
+			{"name":"qwen3:8b","size":5200000000,"modified_at":"2026-09-01T10:00:00Z",
+			 "details":{"family":"qwen3","parameter_size":"8.2B","quantization_level":"Q4_K_M"}},
+			{"name":"llama3.2:latest","size":2000000000,"modified_at":"2026-08-01T10:00:00Z"}]}`)
+	})
+	mux.HandleFunc("GET /api/ps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"models":[{"name":"qwen3:8b","size_vram":6000000000,"expires_at":"2026-10-16T12:00:00Z"}]}`)
+	})
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "nope" {
+			fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+			fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+			return
+		}
+		f.pulled = append(f.pulled, req.Model)
+		fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+		fmt.Fprintln(w, `{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":50}`)
+		fmt.Fprintln(w, `{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":100}`)
+		fmt.Fprintln(w, `{"status":"success"}`)
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.Model {
+		case "tuned":
+			fmt.Fprint(w, `{"parameters":"stop \"<|im_end|>\"\nnum_ctx 16384","model_info":{"qwen3.context_length":40960}}`)
+		case "qwen3:8b":
+			fmt.Fprint(w, `{"model_info":{"general.architecture":"qwen3","qwen3.context_length":40960}}`)
+		default:
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"":                           DefaultBaseURL,
+		"http://localhost:11434/v1":  "http://localhost:11434",
+		"http://gpu:11434/":          "http://gpu:11434",
+		"https://ollama.example/api": "https://ollama.example",
+	}
+	for in, want := range tests {
+		if got := NormalizeBaseURL(in); got != want {
+			t.Errorf("NormalizeBaseURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChat_BuildsNativeRequest(t *testing.T) {
+	f := newFakeOllama(t)
+	p := NewProvider("", f.URL+"/v1", "", WithKeepAlive("30m"), WithNumCtx(8192))
+
+	resp, err := p.Chat(t.Context(), []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "what is this?", Media: []string{"data:image/png;base64,iVBORw0KGgo="}},
+		{Role: "assistant", ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: &FunctionCall{Name: "read_file", Arguments: `{"path":"a.txt"}`},
+		}}},
+		{Role: "tool", Content: "contents", ToolCallID: "call_1"},
+	}, []ToolDefinition{{
+		Type: "function",
+		Function: protocoltypes.ToolFunctionDefinition{
+			Name:       "read_file",
+			Parameters: map[string]any{"type": "object"},
+		},
+	}}, "ollama/qwen3:8b", map[string]any{"max_tokens": 512, "temperature": 0.2})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if resp.Content != "hi there" || resp.ReasoningContent != "greet" || resp.FinishReason != "stop" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 7 || resp.Usage.TotalTokens != 9 {
+		t.Fatalf("Usage = %+v", resp.Usage)
+	}
+
+	req := f.lastChat
+	if req["model"] != "qwen3:8b" || req["keep_alive"] != "30m" || req["stream"] != false {
+		t.Fatalf("unexpected request: %v", req)
+	}
+	opts, _ := req["options"].(map[string]any)
+	if opts["num_ctx"] != float64(8192) || opts["num_predict"] != float64(512) || opts["temperature"] != 0.2 {
+		t.Fatalf("options = %v", opts)
+	}
+	if tools, _ := req["tools"].([]any); len(tools) != 1 {
+		t.Fatalf("tools = %v", req["tools"])
+	}
+
+	messages := req["messages"].([]any)
+	user := messages[1].(map[string]any)
+	if images, _ := user["images"].([]any); len(images) != 1 || images[0] != "iVBORw0KGgo=" {
+		t.Fatalf("images = %v", user["images"])
+	}
+	assistant := messages[2].(map[string]any)
+	call := assistant["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)
+	if args, _ := call["arguments"].(map[string]any); args["path"] != "a.txt" {
+		t.Fatalf("tool call arguments should be an object, got %v", call["arguments"])
+	}
+	if tool := messages[3].(map[string]any); tool["tool_name"] != "read_file" {
+		t.Fatalf("tool_name = %v", tool["tool_name"])
+	}
+}
+
+func TestChatStream_AssemblesDeltasAndToolCalls(t *testing.T) {
+	f := newFakeOllama(t)
+	p := NewProvider("", f.URL, "")
+
+	var deltas []string
+	resp, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "qwen3:8b", nil,
+		func(delta string) { deltas = append(deltas, delta) })
+	if err != nil {
+		t.Fatalf("ChatStream() error = %v", err)
+	}
+
+	if strings.Join(deltas, "|") != "Hel|lo" || resp.Content != "Hello" {
+		t.Fatalf("deltas = %v, content = %q", deltas, resp.Content)
+	}
+	if len(resp.ToolCalls) != 1 || resp.FinishReason != "tool_calls" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	tc := resp.ToolCalls[0]
+	if tc.ID == "" || tc.Name != "read_file" || tc.Arguments["path"] != "a.txt" ||
+		tc.Function == nil || tc.Function.Arguments != `{"path":"a.txt"}` {
+		t.Fatalf("tool call = %+v", tc)
+	}
+	if resp.Usage == nil || resp.Usage.CompletionTokens != 3 {
+		t.Fatalf("Usage = %+v", resp.Usage)
+	}
+}
+
+func TestChat_ReportsServerErrors(t *testing.T) {
+	f := newFakeOllama(t)
+	p := NewProvider("", f.URL, "")
+
+	_, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "missing", nil)
+	if err == nil || !strings.Contains(err.Error(), "Status: 404") || !strings.Contains(err.Error(), "try pulling") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestListModels_MergesLoadState(t *testing.T) {
+	f := newFakeOllama(t)
+	p := NewProvider("", f.URL, "")
+
+	models, err := p.ListModels(t.Context())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "llama3.2:latest" || models[1].Name != "qwen3:8b" {
+		t.Fatalf("models = %+v", models)
+	}
+	if models[0].Loaded {
+		t.Fatal("llama3.2 should not be loaded")
+	}
+	qwen := models[1]
+	if !qwen.Loaded || qwen.SizeVRAM != 6000000000 || qwen.ParameterSize != "8.2B" ||
+		!qwen.ExpiresAt.Equal(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("qwen = %+v", qwen)
+	}
+}
+
+func TestPull(t *testing.T) {
+	f := newFakeOllama(t)
+	p := NewProvider("", f.URL, "")
+
+	var progress []PullProgress
+	if err := p.Pull(t.Context(), "llama3.2", func(pp PullProgress) { progress = append(progress, pp) }); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+	if len(f.pulled) != 1 || f.pulled[0] != "llama3.2" {
+		t.Fatalf("pulled = %v", f.pulled)
+	}
+	if len(progress) != 4 || progress[2].Completed != 100 || progress[3].Status != "success" {
+		t.Fatalf("progress = %+v", progress)
+	}
+
+	if err := p.Pull(t.Context(), "nope", nil); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	f := newFakeOllama(t)
+
+	p := NewProvider("", f.URL, "")
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"qwen3:8b", 40960},
+		{"ollama/qwen3:8b", 40960},
+		{"tuned", 16384},
+	}
+	for _, tt := range tests {
+		got, err := p.ContextWindow(t.Context(), tt.model)
+		if err != nil || got != tt.want {
+			t.Errorf("ContextWindow(%q) = %d, %v; want %d", tt.model, got, err, tt.want)
+		}
+	}
+	if _, err := p.ContextWindow(t.Context(), "unknown"); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+
+	// Chats request the discovered window, not the server's default.
+	if _, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "tuned", nil); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if opts, _ := f.lastChat["options"].(map[string]any); opts["num_ctx"] != float64(16384) {
+		t.Errorf("options = %v, want the discovered num_ctx", opts)
+	}
+
+	configured := NewProvider("", f.URL, "", WithNumCtx(4096))
+	if got, _ := configured.ContextWindow(t.Context(), "qwen3:8b"); got != 4096 {
+		t.Errorf("configured num_ctx should win, got %d", got)
+	}
+}
@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	ollamaprovider "github.com/sipeed/picoclaw/pkg/providers/ollama"
+)
+
+// OllamaProvider speaks the native Ollama API.
+type OllamaProvider struct {
+	delegate *ollamaprovider.Provider
+}
+
+func NewOllamaProvider(apiKey, apiBase, proxy string, opts ...ollamaprovider.Option) *OllamaProvider {
+	return &OllamaProvider{
+		delegate: ollamaprovider.NewProvider(apiKey, apiBase, proxy, opts...),
+	}
+}
+
+// NewOllamaProviderFromConfig creates a provider for a model_list entry.
+func NewOllamaProviderFromConfig(cfg *config.ModelConfig) *OllamaProvider {
+	return NewOllamaProvider(
+		cfg.APIKey,
+		cfg.APIBase,
+		cfg.Proxy,
+		ollamaprovider.WithRequestTimeout(time.Duration(cfg.RequestTimeout)*time.Second),
+		ollamaprovider.WithKeepAlive(cfg.KeepAlive),
+		ollamaprovider.WithNumCtx(cfg.NumCtx),
+	)
+}
+
+func (p *OllamaProvider) Chat(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+) (*LLMResponse, error) {
+	return p.delegate.Chat(ctx, messages, tools, model, options)
+}
+
+func (p *OllamaProvider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta StreamCallback,
+) (*LLMResponse, error) {
+	return p.delegate.ChatStream(ctx, messages, tools, model, options, onDelta)
+}
+
+func (p *OllamaProvider) GetDefaultModel() string {
+	return p.delegate.GetDefaultModel()
+}
+
+// ContextWindow implements ContextWindowProvider.
+func (p *OllamaProvider) ContextWindow(ctx context.Context, model string) (int, error) {
+	return p.delegate.ContextWindow(ctx, model)
+}
+
+// Client returns the underlying Ollama client for model management.
+func (p *OllamaProvider) Client() *ollamaprovider.Provider {
+	return p.delegate
+}
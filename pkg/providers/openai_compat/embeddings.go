@@ -0,0 +1,59 @@
+package openai_compat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Embed returns one embedding vector per input text using the
+// OpenAI-compatible /embeddings endpoint. Vectors are returned in the same
+// order as texts.
+func (p *Provider) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	vectors, _, err := p.EmbedWithUsage(ctx, texts, model)
+	return vectors, err
+}
+
+// EmbedWithUsage is Embed that also returns the token usage reported by the
+// server, or nil if it reported none.
+func (p *Provider) EmbedWithUsage(ctx context.Context, texts []string, model string) ([][]float32, *UsageInfo, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil, nil
+	}
+
+	requestBody := map[string]any{
+		"model": normalizeModel(model, p.apiBase),
+		"input": texts,
+	}
+
+	resp, err := p.doRequest(ctx, "/embeddings", requestBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResponse struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage *UsageInfo `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal embeddings response: %w", err)
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range apiResponse.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, nil, fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+	return vectors, apiResponse.Usage, nil
+}
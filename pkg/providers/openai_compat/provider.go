@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
@@ -33,12 +35,19 @@ type Provider struct {
 	apiBase        string
 	maxTokensField string // Field name for max tokens (e.g., "max_completion_tokens" for o1/glm models)
 	httpClient     *http.Client
+	// streamRejections counts streaming requests in a row that the server
+	// rejected as such and then answered without streaming.
+	streamRejections atomic.Int32
 }
 
 type Option func(*Provider)
 
 const defaultRequestTimeout = 120 * time.Second
 
+// maxStreamRejections is how many streaming requests in a row the server
+// may reject before ChatStream stops trying to stream.
+const maxStreamRejections = 3
+
 func WithMaxTokensField(maxTokensField string) Option {
 	return func(p *Provider) {
 		p.maxTokensField = maxTokensField
@@ -108,10 +117,72 @@ func (p *Provider) Chat(
 	model string,
 	options map[string]any,
 ) (*LLMResponse, error) {
-	if p.apiBase == "" {
-		return nil, fmt.Errorf("API base not configured")
+	resp, err := p.doRequest(ctx, "/chat/completions", p.buildRequestBody(messages, tools, model, options))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	return parseResponse(body)
+}
+
+// ChatStream behaves like Chat but requests a server-sent event stream and
+// reports content deltas through onDelta as they arrive. Tool call fragments
+// are accumulated by index and only returned once the stream completes.
+//
+// Servers whose error says they do not support the streaming request (for
+// example because they do not know stream_options) are asked again without
+// streaming. Once that has happened maxStreamRejections times in a row,
+// later calls skip streaming altogether.
+func (p *Provider) ChatStream(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+	onDelta protocoltypes.StreamCallback,
+) (*LLMResponse, error) {
+	if p.streamRejections.Load() >= maxStreamRejections {
+		return p.Chat(ctx, messages, tools, model, options)
+	}
+
+	requestBody := p.buildRequestBody(messages, tools, model, options)
+	requestBody["stream"] = true
+	requestBody["stream_options"] = map[string]any{"include_usage": true}
+
+	resp, err := p.doRequest(ctx, "/chat/completions", requestBody)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.rejectsStreaming() {
+		out, chatErr := p.Chat(ctx, messages, tools, model, options)
+		if chatErr != nil {
+			return nil, err
+		}
+		if p.streamRejections.Add(1) == maxStreamRejections {
+			log.Printf("openai_compat: streaming request rejected (status %d), using non-streaming requests",
+				apiErr.Status)
+		}
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	p.streamRejections.Store(0)
+
+	return parseStream(resp.Body, onDelta)
+}
+
+func (p *Provider) buildRequestBody(
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+) map[string]any {
 	model = normalizeModel(model, p.apiBase)
 
 	requestBody := map[string]any{
@@ -162,12 +233,42 @@ func (p *Provider) Chat(
 		}
 	}
 
+	if format := protocoltypes.ResponseFormatFromOptions(options); format != nil {
+		requestBody["response_format"] = buildResponseFormat(format)
+	}
+
+	return requestBody
+}
+
+// buildResponseFormat translates format into the response_format field:
+// a JSON schema when one is given, JSON mode otherwise.
+func buildResponseFormat(format *protocoltypes.ResponseFormat) map[string]any {
+	if format.Schema == nil {
+		return map[string]any{"type": "json_object"}
+	}
+	return map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   format.SchemaName(),
+			"schema": format.Schema,
+			"strict": format.Strict,
+		},
+	}
+}
+
+// doRequest posts requestBody to the API endpoint at path (relative to the API
+// base) and returns the response for the caller to consume. Non-200 responses are turned into errors.
+func (p *Provider) doRequest(ctx context.Context, path string, requestBody any) (*http.Response, error) {
+	if p.apiBase == "" {
+		return nil, fmt.Errorf("API base not configured")
+	}
+
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+path, bytes.NewReader(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -181,18 +282,51 @@ func (p *Provider) Chat(
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil, &apiError{Status: resp.StatusCode, Body: string(body)}
 	}
 
-	return parseResponse(body)
+	return resp, nil
+}
+
+// apiError is a non-200 response from the API.
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API request failed:\n  Status: %d\n  Body:   %s", e.Status, e.Body)
+}
+
+// rejectsStreaming reports whether the server refused the request because
+// it does not support streaming, as opposed to any other problem with the
+// request (such as an overlong context), auth, rate limits or an outage.
+func (e *apiError) rejectsStreaming() bool {
+	switch e.Status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+	default:
+		return false
+	}
+	body := strings.ToLower(e.Body)
+	if !strings.Contains(body, "stream") {
+		return false
+	}
+	for _, phrase := range []string{
+		"not supported", "unsupported", "not support", "unknown", "unrecognized",
+		"not allowed", "not permitted", "extra", "invalid",
+	} {
+		if strings.Contains(body, phrase) {
+			return true
+		}
+	}
+	return false
 }
 
 func parseResponse(body []byte) (*LLMResponse, error) {
@@ -236,8 +370,11 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 	choice := apiResponse.Choices[0]
 	toolCalls := make([]ToolCall, 0, len(choice.Message.ToolCalls))
 	for _, tc := range choice.Message.ToolCalls {
-		arguments := make(map[string]any)
-		name := ""
+		name, arguments := "", ""
+		if tc.Function != nil {
+			name = tc.Function.Name
+			arguments = tc.Function.Arguments
+		}
 
 		// Extract thought_signature from Gemini/Google-specific extra content
 		thoughtSignature := ""
@@ -245,33 +382,7 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 			thoughtSignature = tc.ExtraContent.Google.ThoughtSignature
 		}
 
-		if tc.Function != nil {
-			name = tc.Function.Name
-			if tc.Function.Arguments != "" {
-				if err := json.Unmarshal([]byte(tc.Function.Arguments), &arguments); err != nil {
-					log.Printf("openai_compat: failed to decode tool call arguments for %q: %v", name, err)
-					arguments["raw"] = tc.Function.Arguments
-				}
-			}
-		}
-
-		// Build ToolCall with ExtraContent for Gemini 3 thought_signature persistence
-		toolCall := ToolCall{
-			ID:               tc.ID,
-			Name:             name,
-			Arguments:        arguments,
-			ThoughtSignature: thoughtSignature,
-		}
-
-		if thoughtSignature != "" {
-			toolCall.ExtraContent = &ExtraContent{
-				Google: &GoogleExtra{
-					ThoughtSignature: thoughtSignature,
-				},
-			}
-		}
-
-		toolCalls = append(toolCalls, toolCall)
+		toolCalls = append(toolCalls, buildToolCall(tc.ID, name, arguments, thoughtSignature))
 	}
 
 	return &LLMResponse{
@@ -285,6 +396,36 @@ func parseResponse(body []byte) (*LLMResponse, error) {
 	}, nil
 }
 
+// buildToolCall decodes the raw JSON arguments of a tool call and attaches the
+// Gemini thought signature, if any, so it is echoed back on the next turn.
+func buildToolCall(id, name, rawArguments, thoughtSignature string) ToolCall {
+	arguments := make(map[string]any)
+	if rawArguments != "" {
+		if err := json.Unmarshal([]byte(rawArguments), &arguments); err != nil {
+			log.Printf("openai_compat: failed to decode tool call arguments for %q: %v", name, err)
+			arguments["raw"] = rawArguments
+		}
+	}
+
+	// Build ToolCall with ExtraContent for Gemini 3 thought_signature persistence
+	toolCall := ToolCall{
+		ID:               id,
+		Name:             name,
+		Arguments:        arguments,
+		ThoughtSignature: thoughtSignature,
+	}
+
+	if thoughtSignature != "" {
+		toolCall.ExtraContent = &ExtraContent{
+			Google: &GoogleExtra{
+				ThoughtSignature: thoughtSignature,
+			},
+		}
+	}
+
+	return toolCall
+}
+
 // openaiMessage is the wire-format message for OpenAI-compatible APIs.
 // It mirrors protocoltypes.Message but omits SystemParts, which is an
 // internal field that would be unknown to third-party endpoints.
@@ -376,6 +376,39 @@ func TestProviderChat_AcceptsNumericOptionTypes(t *testing.T) {
 	}
 }
 
+func TestProviderChat_SendsResponseFormat(t *testing.T) {
+	var requestBody map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&requestBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"ok\":true}"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	schema := map[string]any{"type": "object", "properties": map[string]any{"ok": map[string]any{"type": "boolean"}}}
+	format := &protocoltypes.ResponseFormat{Name: "status", Schema: schema, Strict: true}
+	if _, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o",
+		map[string]any{"response_format": format}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	rf, _ := requestBody["response_format"].(map[string]any)
+	js, _ := rf["json_schema"].(map[string]any)
+	if rf["type"] != "json_schema" || js["name"] != "status" || js["strict"] != true || js["schema"] == nil {
+		t.Fatalf("response_format = %v", requestBody["response_format"])
+	}
+
+	if _, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o",
+		map[string]any{"response_format": &protocoltypes.ResponseFormat{}}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if rf, _ := requestBody["response_format"].(map[string]any); rf["type"] != "json_object" {
+		t.Fatalf("response_format without schema = %v", requestBody["response_format"])
+	}
+}
+
 func TestNormalizeModel_UsesAPIBase(t *testing.T) {
 	if got := normalizeModel("deepseek/deepseek-chat", "https://api.deepseek.com/v1"); got != "deepseek-chat" {
 		t.Fatalf("normalizeModel(deepseek) = %q, want %q", got, "deepseek-chat")
@@ -513,3 +546,221 @@ func TestSerializeMessages_StripsSystemParts(t *testing.T) {
 		t.Fatal("system_parts should not appear in serialized output")
 	}
 }
+
+func TestProviderChatStream_AssemblesContentAndToolCalls(t *testing.T) {
+	var requestBody map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function",` +
+				`"function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}`,
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"SF\"}"}}]}}]}`,
+			`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+		}
+		for _, c := range chunks {
+			w.Write([]byte("data: " + c + "\n\n"))
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	var deltas []string
+	p := NewProvider("key", server.URL, "")
+	out, err := p.ChatStream(
+		t.Context(),
+		[]Message{{Role: "user", Content: "hi"}},
+		nil,
+		"gpt-4o",
+		nil,
+		func(delta string) { deltas = append(deltas, delta) },
+	)
+	if err != nil {
+		t.Fatalf("ChatStream() error = %v", err)
+	}
+
+	if requestBody["stream"] != true {
+		t.Fatalf("expected stream=true in request body, got %v", requestBody["stream"])
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Fatalf("deltas = %v, want [Hel lo]", deltas)
+	}
+	if out.Content != "Hello" {
+		t.Fatalf("Content = %q, want %q", out.Content, "Hello")
+	}
+	if out.FinishReason != "tool_calls" {
+		t.Fatalf("FinishReason = %q, want %q", out.FinishReason, "tool_calls")
+	}
+	if len(out.ToolCalls) != 1 {
+		t.Fatalf("len(ToolCalls) = %d, want 1", len(out.ToolCalls))
+	}
+	if out.ToolCalls[0].ID != "call_1" || out.ToolCalls[0].Name != "get_weather" {
+		t.Fatalf("ToolCalls[0] = %+v", out.ToolCalls[0])
+	}
+	if out.ToolCalls[0].Arguments["city"] != "SF" {
+		t.Fatalf("ToolCalls[0].Arguments[city] = %v, want SF", out.ToolCalls[0].Arguments["city"])
+	}
+	if out.Usage == nil || out.Usage.TotalTokens != 15 {
+		t.Fatalf("Usage = %+v, want total 15", out.Usage)
+	}
+}
+
+func TestProviderChatStream_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestProviderChatStream_FallsBackWhenStreamingRejected(t *testing.T) {
+	var streamed, plain int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["stream_options"]; ok {
+			streamed++
+			http.Error(w, `{"error":"unknown field stream_options"}`, http.StatusBadRequest)
+			return
+		}
+		plain++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi there"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	for range maxStreamRejections + 1 {
+		out, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "local", nil, nil)
+		if err != nil {
+			t.Fatalf("ChatStream() error = %v", err)
+		}
+		if out.Content != "hi there" {
+			t.Fatalf("Content = %q", out.Content)
+		}
+	}
+	if streamed != maxStreamRejections || plain != maxStreamRejections+1 {
+		t.Errorf("streamed=%d plain=%d, want streaming tried %d times", streamed, plain, maxStreamRejections)
+	}
+}
+
+func TestProviderChatStream_OtherBadRequestsDoNotFallBack(t *testing.T) {
+	var streamed, plain int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stream"] == true {
+			streamed++
+		} else {
+			plain++
+		}
+		http.Error(w, `{"error":{"code":"context_length_exceeded"}}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	for range maxStreamRejections + 1 {
+		_, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if streamed != maxStreamRejections+1 || plain != 0 {
+		t.Errorf("streamed=%d plain=%d, want no non-streaming retries", streamed, plain)
+	}
+}
+
+func TestProviderChatStream_ErrorEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n"))
+		w.Write([]byte("data: {\"error\":{\"message\":\"context length exceeded\",\"type\":\"invalid_request\"}}\n\n"))
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	_, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "context length exceeded") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestProviderEmbed_OrdersByIndex(t *testing.T) {
+	var requestBody map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Return data out of order to check that Embed sorts by index.
+		resp := map[string]any{
+			"data": []map[string]any{
+				{"index": 1, "embedding": []float32{0, 1}},
+				{"index": 0, "embedding": []float32{1, 0}},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	p := NewProvider("key", server.URL, "")
+	vectors, err := p.Embed(t.Context(), []string{"first", "second"}, "text-embedding-3-small")
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+
+	if requestBody["model"] != "text-embedding-3-small" {
+		t.Errorf("model = %v, want text-embedding-3-small", requestBody["model"])
+	}
+	if input, _ := requestBody["input"].([]any); len(input) != 2 || input[0] != "first" {
+		t.Errorf("input = %v, want [first second]", requestBody["input"])
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("vectors = %v, want [[1 0] [0 1]]", vectors)
+	}
+}
+
+func TestProviderEmbed_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		payload string
+	}{
+		{name: "http error", status: http.StatusBadRequest, payload: `{"error":"bad model"}`},
+		{name: "missing vector", status: http.StatusOK, payload: `{"data":[{"index":0,"embedding":[1]}]}`},
+		{name: "index out of range", status: http.StatusOK, payload: `{"data":[{"index":5,"embedding":[1]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.payload))
+			}))
+			defer server.Close()
+
+			p := NewProvider("key", server.URL, "")
+			if _, err := p.Embed(t.Context(), []string{"a", "b"}, "m"); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
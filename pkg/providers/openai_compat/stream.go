@@ -0,0 +1,159 @@
+package openai_compat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+// streamChunk is a single "data:" payload of a chat completions SSE stream.
+type streamChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+			ToolCalls        []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function *struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+				ExtraContent *struct {
+					Google *struct {
+						ThoughtSignature string `json:"thought_signature"`
+					} `json:"google"`
+				} `json:"extra_content"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *UsageInfo `json:"usage"`
+	// Error is set by servers that report a failure inside the stream.
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// partialToolCall accumulates the fragments of one streamed tool call.
+type partialToolCall struct {
+	id               string
+	name             string
+	arguments        strings.Builder
+	thoughtSignature string
+}
+
+// parseStream reads an SSE chat completions stream until "[DONE]" or EOF,
+// forwarding content deltas to onDelta and assembling the final response.
+func parseStream(body io.Reader, onDelta protocoltypes.StreamCallback) (*LLMResponse, error) {
+	var (
+		content          strings.Builder
+		reasoningContent strings.Builder
+		reasoning        strings.Builder
+		finishReason     string
+		usage            *UsageInfo
+		toolCalls        = make(map[int]*partialToolCall)
+	)
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		data, isData := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		data = strings.TrimSpace(data)
+		if isData && data == "[DONE]" {
+			break
+		}
+
+		if isData && data != "" {
+			var chunk streamChunk
+			if jsonErr := json.Unmarshal([]byte(data), &chunk); jsonErr != nil {
+				return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", jsonErr)
+			}
+			if chunk.Error != nil {
+				return nil, fmt.Errorf("stream error: %s (%s)", chunk.Error.Message, chunk.Error.Type)
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			for _, choice := range chunk.Choices {
+				if choice.Index != 0 {
+					continue
+				}
+				if choice.Delta.Content != "" {
+					content.WriteString(choice.Delta.Content)
+					if onDelta != nil {
+						onDelta(choice.Delta.Content)
+					}
+				}
+				reasoningContent.WriteString(choice.Delta.ReasoningContent)
+				reasoning.WriteString(choice.Delta.Reasoning)
+				for _, tc := range choice.Delta.ToolCalls {
+					ptc, ok := toolCalls[tc.Index]
+					if !ok {
+						ptc = &partialToolCall{}
+						toolCalls[tc.Index] = ptc
+					}
+					if tc.ID != "" {
+						ptc.id = tc.ID
+					}
+					if tc.Function != nil {
+						if tc.Function.Name != "" {
+							ptc.name = tc.Function.Name
+						}
+						ptc.arguments.WriteString(tc.Function.Arguments)
+					}
+					if tc.ExtraContent != nil && tc.ExtraContent.Google != nil &&
+						tc.ExtraContent.Google.ThoughtSignature != "" {
+						ptc.thoughtSignature = tc.ExtraContent.Google.ThoughtSignature
+					}
+				}
+				if choice.FinishReason != nil && *choice.FinishReason != "" {
+					finishReason = *choice.FinishReason
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	indexes := make([]int, 0, len(toolCalls))
+	for idx := range toolCalls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	assembled := make([]ToolCall, 0, len(indexes))
+	for _, idx := range indexes {
+		ptc := toolCalls[idx]
+		assembled = append(assembled, buildToolCall(ptc.id, ptc.name, ptc.arguments.String(), ptc.thoughtSignature))
+	}
+
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+
+	return &LLMResponse{
+		Content:          content.String(),
+		ReasoningContent: reasoningContent.String(),
+		Reasoning:        reasoning.String(),
+		ToolCalls:        assembled,
+		FinishReason:     finishReason,
+		Usage:            usage,
+	}, nil
+}
@@ -82,3 +82,43 @@ type ToolFunctionDefinition struct {
 	Description string         `json:"description"`
 	Parameters  map[string]any `json:"parameters"`
 }
+
+// StreamCallback receives incremental assistant text while a response is
+// being generated. It is invoked from the goroutine reading the stream, so
+// implementations should return quickly.
+type StreamCallback func(delta string)
+
+// ResponseFormat asks for a JSON reply. It is passed in the
+// "response_format" chat option; providers that support it constrain the
+// reply natively, others ignore it.
+type ResponseFormat struct {
+	// Name identifies the schema to the model, e.g. "weather_report".
+	Name string `json:"name,omitempty"`
+	// Schema is the JSON Schema the reply must satisfy. Without one, any
+	// JSON object is accepted.
+	Schema map[string]any `json:"schema,omitempty"`
+	// Strict asks OpenAI-compatible APIs to enforce the schema exactly; it
+	// requires every property to be listed in "required" and
+	// "additionalProperties": false on every object.
+	Strict bool `json:"strict,omitempty"`
+}
+
+// SchemaName returns Name, or "response" if it is unset.
+func (f *ResponseFormat) SchemaName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return "response"
+}
+
+// ResponseFormatFromOptions returns the "response_format" chat option, or
+// nil if none is set.
+func ResponseFormatFromOptions(options map[string]any) *ResponseFormat {
+	switch f := options["response_format"].(type) {
+	case *ResponseFormat:
+		return f
+	case ResponseFormat:
+		return &f
+	}
+	return nil
+}
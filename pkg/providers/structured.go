@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers/protocoltypes"
+)
+
+// structuredRepairs is how many times ChatStructured asks the model to fix
+// a reply that is not valid JSON or does not match the schema.
+const structuredRepairs = 2
+
+// ResponseFormatFromOptions returns the "response_format" chat option, or
+// nil if none is set.
+func ResponseFormatFromOptions(options map[string]any) *ResponseFormat {
+	return protocoltypes.ResponseFormatFromOptions(options)
+}
+
+// JSONValidator checks replies against a ResponseFormat.
+type JSONValidator struct {
+	schema *jsonschema.Resolved // nil: any JSON object
+}
+
+// NewJSONValidator compiles format's schema.
+func NewJSONValidator(format *ResponseFormat) (*JSONValidator, error) {
+	if format == nil || format.Schema == nil {
+		return &JSONValidator{}, nil
+	}
+	raw, err := json.Marshal(format.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema %s: %w", format.SchemaName(), err)
+	}
+	var schema jsonschema.Schema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("parsing schema %s: %w", format.SchemaName(), err)
+	}
+	resolved, err := schema.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema %s: %w", format.SchemaName(), err)
+	}
+	return &JSONValidator{schema: resolved}, nil
+}
+
+// Validate extracts the JSON value from a model reply and checks it
+// against the schema. It returns the value in compact form.
+func (v *JSONValidator) Validate(reply string) (json.RawMessage, error) {
+	raw, err := ExtractJSON(reply)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if v.schema == nil {
+		if _, ok := value.(map[string]any); !ok {
+			return nil, errors.New("reply is not a JSON object")
+		}
+	} else if err := v.schema.Validate(value); err != nil {
+		return nil, err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return compact.Bytes(), nil
+}
+
+// ExtractJSON returns the JSON value in a model reply: the whole reply, the
+// contents of a fenced code block, or the first object or array embedded
+// in surrounding prose. Unlike brace counting, it is not confused by braces
+// inside strings.
+func ExtractJSON(reply string) (json.RawMessage, error) {
+	text := strings.TrimSpace(reply)
+	if json.Valid([]byte(text)) {
+		return json.RawMessage(text), nil
+	}
+
+	if _, fenced, ok := strings.Cut(text, "```"); ok {
+		fenced = strings.TrimPrefix(fenced, "json")
+		if body, _, ok := strings.Cut(fenced, "```"); ok {
+			if body = strings.TrimSpace(body); json.Valid([]byte(body)) {
+				return json.RawMessage(body), nil
+			}
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(text[i:])).Decode(&raw); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("reply contains no JSON value")
+}
+
+// StructuredResult is a reply that matched a ResponseFormat.
+type StructuredResult struct {
+	JSON json.RawMessage
+	// Response is the final response; its Usage covers all attempts.
+	Response *LLMResponse
+	Attempts int
+}
+
+// ChatStructured asks provider for a reply in format and validates it. A
+// reply that does not validate is sent back to the model with the error,
+// up to structuredRepairs times. Providers that do not implement
+// ResponseFormatProvider are told the schema in the system prompt.
+func ChatStructured(
+	ctx context.Context,
+	provider LLMProvider,
+	messages []Message,
+	model string,
+	options map[string]any,
+	format *ResponseFormat,
+) (*StructuredResult, error) {
+	validator, err := NewJSONValidator(format)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := maps.Clone(options)
+	if opts == nil {
+		opts = map[string]any{}
+	}
+	opts["response_format"] = format
+
+	messages = slices.Clone(messages)
+	if rfp, ok := provider.(ResponseFormatProvider); !ok || !rfp.SupportsResponseFormat() {
+		messages = withFormatInstructions(messages, format)
+	}
+
+	usage := &UsageInfo{}
+	for attempt := 1; ; attempt++ {
+		resp, err := provider.Chat(ctx, messages, nil, model, opts)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Usage != nil {
+			usage.PromptTokens += resp.Usage.PromptTokens
+			usage.CompletionTokens += resp.Usage.CompletionTokens
+			usage.TotalTokens += resp.Usage.TotalTokens
+		}
+
+		raw, verr := validator.Validate(resp.Content)
+		if verr == nil {
+			resp.Usage = usage
+			return &StructuredResult{JSON: raw, Response: resp, Attempts: attempt}, nil
+		}
+		if attempt > structuredRepairs {
+			return nil, fmt.Errorf("reply does not match %s after %d attempts: %w",
+				format.SchemaName(), attempt, verr)
+		}
+
+		logger.WarnCF("providers", "Structured reply invalid, asking the model to repair it",
+			map[string]any{"schema": format.SchemaName(), "attempt": attempt, "error": verr.Error()})
+		messages = append(messages,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: fmt.Sprintf(
+				"Your reply was rejected: %v\nReply again with only the corrected JSON, and nothing else.", verr)},
+		)
+	}
+}
+
+// withFormatInstructions appends a description of format to the system
+// prompt, adding one if there is none.
+func withFormatInstructions(messages []Message, format *ResponseFormat) []Message {
+	instructions := "Reply with only a JSON object, without any other text or code fences."
+	if format.Schema != nil {
+		schema, _ := json.Marshal(format.Schema)
+		instructions = fmt.Sprintf(
+			"Reply with only a JSON value matching this JSON Schema, without any other text or code fences:\n%s",
+			schema)
+	}
+
+	if len(messages) > 0 && messages[0].Role == "system" {
+		system := messages[0]
+		system.Content = strings.TrimSpace(system.Content + "\n\n" + instructions)
+		system.SystemParts = nil
+		messages[0] = system
+		return messages
+	}
+	return append([]Message{{Role: "system", Content: instructions}}, messages...)
+}
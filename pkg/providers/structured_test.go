@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// scriptedProvider replies with its replies in turn and records the
+// messages and options of each call.
+type scriptedProvider struct {
+	replies  []string
+	native   bool
+	messages [][]Message
+	options  []map[string]any
+}
+
+func (p *scriptedProvider) Chat(
+	ctx context.Context,
+	messages []Message,
+	tools []ToolDefinition,
+	model string,
+	options map[string]any,
+) (*LLMResponse, error) {
+	p.messages = append(p.messages, messages)
+	p.options = append(p.options, options)
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return &LLMResponse{Content: reply, Usage: &UsageInfo{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "scripted" }
+
+func (p *scriptedProvider) SupportsResponseFormat() bool { return p.native }
+
+var weatherFormat = &ResponseFormat{
+	Name: "weather",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"city":    map[string]any{"type": "string"},
+			"celsius": map[string]any{"type": "number"},
+		},
+		"required": []any{"city", "celsius"},
+	},
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name, reply, want string
+	}{
+		{"bare", ` {"a": 1} `, `{"a": 1}`},
+		{"fenced", "Here you go:\n```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"prose", `The answer is {"a": "}{"} as requested.`, `{"a": "}{"}`},
+		{"array", `Result: [1, 2] done`, `[1, 2]`},
+		{"skips invalid", `{oops} then {"a": 1}`, `{"a": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.reply)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractJSON() = %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+	if _, err := ExtractJSON("no json here"); err == nil {
+		t.Error("expected an error for a reply without JSON")
+	}
+}
+
+func TestJSONValidator(t *testing.T) {
+	v, err := NewJSONValidator(weatherFormat)
+	if err != nil {
+		t.Fatalf("NewJSONValidator() error = %v", err)
+	}
+	got, err := v.Validate("```json\n{\"city\": \"Oslo\",\n \"celsius\": -3}\n```")
+	if err != nil || string(got) != `{"city":"Oslo","celsius":-3}` {
+		t.Errorf("Validate() = %s, %v", got, err)
+	}
+	if _, err := v.Validate(`{"city": "Oslo", "celsius": "cold"}`); err == nil {
+		t.Error("expected a schema violation")
+	}
+
+	anyObject, _ := NewJSONValidator(&ResponseFormat{})
+	if _, err := anyObject.Validate(`[1, 2]`); err == nil {
+		t.Error("without a schema, only objects should be accepted")
+	}
+
+	if _, err := NewJSONValidator(&ResponseFormat{Schema: map[string]any{"type": 42}}); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
+
+func TestChatStructured_RepairsInvalidReply(t *testing.T) {
+	p := &scriptedProvider{native: true, replies: []string{
+		`{"city": "Oslo"}`,
+		`{"city": "Oslo", "celsius": -3}`,
+	}}
+	res, err := ChatStructured(t.Context(), p, []Message{{Role: "user", Content: "weather in Oslo?"}},
+		"m", map[string]any{"max_tokens": 100}, weatherFormat)
+	if err != nil {
+		t.Fatalf("ChatStructured() error = %v", err)
+	}
+	if string(res.JSON) != `{"city":"Oslo","celsius":-3}` || res.Attempts != 2 {
+		t.Errorf("result = %s after %d attempts", res.JSON, res.Attempts)
+	}
+	if res.Response.Usage.TotalTokens != 30 {
+		t.Errorf("usage should cover both attempts, got %+v", res.Response.Usage)
+	}
+	if p.options[0]["response_format"] != weatherFormat || p.options[0]["max_tokens"] != 100 {
+		t.Errorf("options = %v", p.options[0])
+	}
+	repair := p.messages[1]
+	if len(repair) != 3 || repair[1].Role != "assistant" ||
+		!strings.HasPrefix(repair[2].Content, "Your reply was rejected:") {
+		t.Errorf("repair messages = %+v", repair)
+	}
+	if p.messages[0][0].Role != "user" {
+		t.Error("native providers should not get schema instructions")
+	}
+}
+
+func TestChatStructured_GivesUp(t *testing.T) {
+	p := &scriptedProvider{replies: []string{"sunny", "still sunny", "very sunny"}}
+	_, err := ChatStructured(t.Context(), p, []Message{
+		{Role: "system", Content: "You are a weather bot."},
+		{Role: "user", Content: "weather in Oslo?"},
+	}, "m", nil, weatherFormat)
+	if err == nil || !strings.Contains(err.Error(), "does not match weather after 3 attempts") {
+		t.Fatalf("err = %v", err)
+	}
+	system := p.messages[0][0].Content
+	if !strings.HasPrefix(system, "You are a weather bot.\n\n") || !strings.Contains(system, `"celsius"`) {
+		t.Errorf("system prompt should describe the schema, got %q", system)
+	}
+}
+
+func TestGeminiProvider_ResponseFormat(t *testing.T) {
+	srv, captured, _ := newGeminiFixtureServer(t, "text_response.json", http.StatusOK)
+	p := NewGeminiProvider("test-key", srv.URL, "", 0)
+
+	if _, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gemini-2.5-flash",
+		map[string]any{"response_format": weatherFormat}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var req struct {
+		GenerationConfig struct {
+			ResponseMimeType string         `json:"responseMimeType"`
+			ResponseSchema   map[string]any `json:"responseSchema"`
+		} `json:"generationConfig"`
+	}
+	raw, _ := json.Marshal(*captured)
+	if err := json.Unmarshal(raw, &req); err != nil {
+		t.Fatalf("decoding captured request: %v", err)
+	}
+	gen := req.GenerationConfig
+	if gen.ResponseMimeType != "application/json" || gen.ResponseSchema["type"] != "object" {
+		t.Errorf("generationConfig = %+v", gen)
+	}
+}
@@ -19,6 +19,8 @@ type (
 	GoogleExtra            = protocoltypes.GoogleExtra
 	ContentBlock           = protocoltypes.ContentBlock
 	CacheControl           = protocoltypes.CacheControl
+	StreamCallback         = protocoltypes.StreamCallback
+	ResponseFormat         = protocoltypes.ResponseFormat
 )
 
 type LLMProvider interface {
@@ -32,11 +34,40 @@ type LLMProvider interface {
 	GetDefaultModel() string
 }
 
+// StreamingProvider is an optional interface for providers that can deliver
+// assistant text incrementally. onDelta is called for each content fragment as
+// it arrives; the returned LLMResponse is the fully assembled result, with tool
+// call fragments already merged into complete ToolCalls.
+type StreamingProvider interface {
+	LLMProvider
+	ChatStream(
+		ctx context.Context,
+		messages []Message,
+		tools []ToolDefinition,
+		model string,
+		options map[string]any,
+		onDelta StreamCallback,
+	) (*LLMResponse, error)
+}
+
 type StatefulProvider interface {
 	LLMProvider
 	Close()
 }
 
+// ContextWindowProvider is an optional interface for providers that can
+// report how many tokens a model accepts, such as a local Ollama server.
+type ContextWindowProvider interface {
+	ContextWindow(ctx context.Context, model string) (int, error)
+}
+
+// ResponseFormatProvider is an optional interface for providers that
+// enforce the "response_format" chat option natively. For other providers,
+// ChatStructured describes the expected JSON in the prompt instead.
+type ResponseFormatProvider interface {
+	SupportsResponseFormat() bool
+}
+
 // FailoverReason classifies why an LLM request failed for fallback decisions.
 type FailoverReason string
 
@@ -0,0 +1,244 @@
+// Package quota enforces per-sender and per-chat usage limits: messages per
+// time window and LLM tokens per day. Counters are persisted so that limits
+// survive restarts.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/fileutil"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// Subject identifies who a message is charged to. Either key may be empty.
+type Subject struct {
+	User string // "user:" + canonical sender ID
+	Chat string // "chat:" + channel + ":" + chat ID
+}
+
+// IsZero reports whether the subject is not subject to quotas.
+func (s Subject) IsZero() bool {
+	return s.User == "" && s.Chat == ""
+}
+
+// Decision is the outcome of Manager.Allow.
+type Decision struct {
+	Allowed bool
+	// Reason is a user-facing explanation when the message is refused.
+	Reason string
+}
+
+// counter is the persisted consumption of one subject key.
+type counter struct {
+	WindowStart time.Time `json:"window_start"`
+	Messages    int       `json:"messages"`
+	Day         string    `json:"day"` // local date, YYYY-MM-DD
+	Tokens      int       `json:"tokens"`
+}
+
+// Manager tracks consumption and decides whether a message may be processed.
+type Manager struct {
+	mu       sync.Mutex
+	path     string
+	cfg      config.QuotaConfig
+	counters map[string]*counter
+	now      func() time.Time
+}
+
+// DefaultPath returns the quota state file inside a workspace.
+func DefaultPath(workspace string) string {
+	return filepath.Join(workspace, "state", "quotas.json")
+}
+
+// NewManager creates a manager persisting its counters at path. Existing
+// counters are loaded; an unreadable file is logged and starts empty.
+func NewManager(path string, cfg config.QuotaConfig) *Manager {
+	m := &Manager{
+		path:     path,
+		cfg:      cfg,
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &m.counters); err != nil {
+			logger.WarnCF("quota", "Ignoring corrupt state file",
+				map[string]any{"path": path, "error": err.Error()})
+			m.counters = make(map[string]*counter)
+		}
+	} else if !os.IsNotExist(err) {
+		logger.WarnCF("quota", "Failed to read state file",
+			map[string]any{"path": path, "error": err.Error()})
+	}
+	return m
+}
+
+// SubjectFor returns the quota subject for an inbound message, or the zero
+// Subject if the sender is exempt.
+func (m *Manager) SubjectFor(msg bus.InboundMessage) Subject {
+	for _, allowed := range m.cfg.Exempt {
+		if identity.MatchAllowed(msg.Sender, allowed) {
+			return Subject{}
+		}
+	}
+
+	var s Subject
+	switch {
+	case msg.Sender.CanonicalID != "":
+		s.User = "user:" + msg.Sender.CanonicalID
+	case msg.SenderID != "":
+		s.User = "user:" + identity.BuildCanonicalID(msg.Channel, msg.SenderID)
+	}
+	if msg.ChatID != "" {
+		s.Chat = "chat:" + msg.Channel + ":" + msg.ChatID
+	}
+	return s
+}
+
+// MaxToolCallsPerTurn returns the tool call cap per turn, or 0 for no limit.
+func (m *Manager) MaxToolCallsPerTurn() int {
+	return m.cfg.MaxToolCallsPerTurn
+}
+
+// Allow checks the subject's limits and, if the message is allowed,
+// counts it against the per-window message limits.
+func (m *Manager) Allow(s Subject) Decision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	checks := []struct {
+		key    string
+		limits config.QuotaLimits
+		scope  string
+	}{
+		{s.User, m.cfg.PerUser, "you"},
+		{s.Chat, m.cfg.PerChat, "this chat"},
+	}
+
+	for _, c := range checks {
+		if c.key == "" {
+			continue
+		}
+		ctr := m.counterLocked(c.key, c.limits, now)
+		if reason := exceeded(ctr, c.limits, c.scope, now); reason != "" {
+			return Decision{Allowed: false, Reason: reason}
+		}
+	}
+
+	for _, c := range checks {
+		if c.key == "" {
+			continue
+		}
+		m.counterLocked(c.key, c.limits, now).Messages++
+	}
+	m.saveLocked()
+	return Decision{Allowed: true}
+}
+
+// AddTokens charges LLM tokens to the subject's daily budgets.
+func (m *Manager) AddTokens(s Subject, tokens int) {
+	if tokens <= 0 || s.IsZero() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if s.User != "" {
+		m.counterLocked(s.User, m.cfg.PerUser, now).Tokens += tokens
+	}
+	if s.Chat != "" {
+		m.counterLocked(s.Chat, m.cfg.PerChat, now).Tokens += tokens
+	}
+	m.saveLocked()
+}
+
+// counterLocked returns the counter for key, resetting the message window
+// and the daily token budget when they have elapsed.
+func (m *Manager) counterLocked(key string, limits config.QuotaLimits, now time.Time) *counter {
+	ctr, ok := m.counters[key]
+	if !ok {
+		ctr = &counter{WindowStart: now, Day: now.Format(time.DateOnly)}
+		m.counters[key] = ctr
+	}
+	if now.Sub(ctr.WindowStart) >= limits.GetWindow() {
+		ctr.WindowStart = now
+		ctr.Messages = 0
+	}
+	if today := now.Format(time.DateOnly); ctr.Day != today {
+		ctr.Day = today
+		ctr.Tokens = 0
+	}
+	return ctr
+}
+
+// exceeded returns a polite explanation addressed to scope ("you" or
+// "this chat") if ctr has reached one of its limits, or "".
+func exceeded(ctr *counter, limits config.QuotaLimits, scope string, now time.Time) string {
+	if limits.MessagesPerWindow > 0 && ctr.Messages >= limits.MessagesPerWindow {
+		retry := ctr.WindowStart.Add(limits.GetWindow()).Sub(now).Round(time.Minute)
+		if retry < time.Minute {
+			retry = time.Minute
+		}
+		return fmt.Sprintf(
+			"Sorry, %s reached the limit of %d messages per %s. Please try again in %s.",
+			hasOrHave(scope), limits.MessagesPerWindow, formatDuration(limits.GetWindow()), formatDuration(retry))
+	}
+	if limits.TokensPerDay > 0 && ctr.Tokens >= limits.TokensPerDay {
+		return fmt.Sprintf(
+			"Sorry, %s used up today's usage budget. It resets at midnight.", hasOrHave(scope))
+	}
+	return ""
+}
+
+func hasOrHave(scope string) string {
+	if scope == "you" {
+		return "you have"
+	}
+	return scope + " has"
+}
+
+// formatDuration renders durations like "1h", "30m" or "1h30m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h, mins := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case h > 0 && mins > 0:
+		return fmt.Sprintf("%dh%dm", h, mins)
+	case h > 0:
+		return fmt.Sprintf("%dh", h)
+	default:
+		return fmt.Sprintf("%dm", mins)
+	}
+}
+
+// saveLocked persists the counters, first dropping those that no longer
+// carry state: their message window has elapsed and their day is over.
+func (m *Manager) saveLocked() {
+	now := m.now()
+	today := now.Format(time.DateOnly)
+	maxWindow := max(m.cfg.PerUser.GetWindow(), m.cfg.PerChat.GetWindow())
+	for key, ctr := range m.counters {
+		if ctr.Day != today && now.Sub(ctr.WindowStart) >= maxWindow {
+			delete(m.counters, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(m.counters, "", "  ")
+	if err != nil {
+		logger.WarnCF("quota", "Failed to encode state", map[string]any{"error": err.Error()})
+		return
+	}
+	if err := fileutil.WriteFileAtomic(m.path, data, 0o600); err != nil {
+		logger.WarnCF("quota", "Failed to save state", map[string]any{"path": m.path, "error": err.Error()})
+	}
+}
@@ -0,0 +1,146 @@
+package quota
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestManager(t *testing.T, cfg config.QuotaConfig) (*Manager, *fakeClock, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state", "quotas.json")
+	clock := &fakeClock{t: time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)}
+	m := NewManager(path, cfg)
+	m.now = clock.now
+	return m, clock, path
+}
+
+func TestSubjectFor(t *testing.T) {
+	m, _, _ := newTestManager(t, config.QuotaConfig{Exempt: config.FlexibleStringSlice{"telegram:1"}})
+
+	s := m.SubjectFor(bus.InboundMessage{
+		Channel: "telegram", ChatID: "-100", SenderID: "2",
+		Sender: bus.SenderInfo{Platform: "telegram", PlatformID: "2", CanonicalID: "telegram:2"},
+	})
+	if s.User != "user:telegram:2" || s.Chat != "chat:telegram:-100" {
+		t.Errorf("unexpected subject: %+v", s)
+	}
+
+	s = m.SubjectFor(bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "U1"})
+	if s.User != "user:slack:U1" {
+		t.Errorf("fallback user key = %q", s.User)
+	}
+
+	s = m.SubjectFor(bus.InboundMessage{
+		Channel: "telegram", ChatID: "-100",
+		Sender: bus.SenderInfo{Platform: "telegram", PlatformID: "1", CanonicalID: "telegram:1"},
+	})
+	if !s.IsZero() {
+		t.Errorf("exempt sender got subject %+v", s)
+	}
+}
+
+func TestAllow_MessagesPerWindow(t *testing.T) {
+	m, clock, _ := newTestManager(t, config.QuotaConfig{
+		PerUser: config.QuotaLimits{MessagesPerWindow: 2, WindowSeconds: 600},
+	})
+	s := Subject{User: "user:telegram:1"}
+
+	for i := range 2 {
+		if d := m.Allow(s); !d.Allowed {
+			t.Fatalf("message %d refused: %s", i+1, d.Reason)
+		}
+	}
+	d := m.Allow(s)
+	if d.Allowed {
+		t.Fatal("third message should be refused")
+	}
+	if !strings.Contains(d.Reason, "2 messages per 10m") || !strings.Contains(d.Reason, "try again in 10m") {
+		t.Errorf("unexpected reason: %q", d.Reason)
+	}
+
+	clock.t = clock.t.Add(10 * time.Minute)
+	if d := m.Allow(s); !d.Allowed {
+		t.Errorf("message after window refused: %s", d.Reason)
+	}
+}
+
+func TestAllow_ChatLimitIndependentOfUser(t *testing.T) {
+	m, _, _ := newTestManager(t, config.QuotaConfig{
+		PerChat: config.QuotaLimits{MessagesPerWindow: 1},
+	})
+
+	if d := m.Allow(Subject{User: "user:a", Chat: "chat:g"}); !d.Allowed {
+		t.Fatalf("first message refused: %s", d.Reason)
+	}
+	d := m.Allow(Subject{User: "user:b", Chat: "chat:g"})
+	if d.Allowed || !strings.Contains(d.Reason, "this chat has reached") {
+		t.Errorf("expected chat limit refusal, got %+v", d)
+	}
+	if d := m.Allow(Subject{User: "user:b", Chat: "chat:other"}); !d.Allowed {
+		t.Errorf("other chat refused: %s", d.Reason)
+	}
+}
+
+func TestTokensPerDay(t *testing.T) {
+	m, clock, _ := newTestManager(t, config.QuotaConfig{
+		PerUser: config.QuotaLimits{TokensPerDay: 1000},
+	})
+	s := Subject{User: "user:telegram:1"}
+
+	m.AddTokens(s, 999)
+	if d := m.Allow(s); !d.Allowed {
+		t.Fatalf("refused below budget: %s", d.Reason)
+	}
+	m.AddTokens(s, 1)
+	d := m.Allow(s)
+	if d.Allowed || !strings.Contains(d.Reason, "today's usage budget") {
+		t.Errorf("expected budget refusal, got %+v", d)
+	}
+
+	clock.t = clock.t.AddDate(0, 0, 1)
+	if d := m.Allow(s); !d.Allowed {
+		t.Errorf("refused on the next day: %s", d.Reason)
+	}
+}
+
+func TestCountersSurviveRestart(t *testing.T) {
+	cfg := config.QuotaConfig{PerUser: config.QuotaLimits{MessagesPerWindow: 1, TokensPerDay: 100}}
+	m, clock, path := newTestManager(t, cfg)
+	s := Subject{User: "user:telegram:1"}
+
+	m.Allow(s)
+	m.AddTokens(s, 40)
+
+	restarted := NewManager(path, cfg)
+	restarted.now = clock.now
+	if d := restarted.Allow(s); d.Allowed {
+		t.Error("message limit not persisted across restart")
+	}
+	if got := restarted.counters[s.User].Tokens; got != 40 {
+		t.Errorf("tokens after restart = %d, want 40", got)
+	}
+}
+
+func TestStaleCountersArePruned(t *testing.T) {
+	m, clock, _ := newTestManager(t, config.QuotaConfig{PerUser: config.QuotaLimits{MessagesPerWindow: 5}})
+
+	m.Allow(Subject{User: "user:old"})
+	clock.t = clock.t.AddDate(0, 0, 2)
+	m.Allow(Subject{User: "user:new"})
+
+	if _, ok := m.counters["user:old"]; ok {
+		t.Error("expected stale counter to be pruned")
+	}
+	if _, ok := m.counters["user:new"]; !ok {
+		t.Error("current counter was pruned")
+	}
+}
@@ -0,0 +1,40 @@
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Configure installs the default redactor for cfg. The secrets of cfg (API
+// keys, channel tokens, ...) and extra (e.g. OAuth credentials) are
+// registered as known secrets. Invalid patterns are reported but do not
+// prevent the others from being used.
+func Configure(cfg *config.Config, extra ...string) error {
+	if !cfg.Redaction.Enabled {
+		SetDefault(nil)
+		return nil
+	}
+
+	opts := Options{
+		Emails:       cfg.Redaction.Emails,
+		PhoneNumbers: cfg.Redaction.PhoneNumbers,
+	}
+	var errs []error
+	for _, p := range cfg.Redaction.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("redact: invalid pattern %q: %w", p, err))
+			continue
+		}
+		opts.Patterns = append(opts.Patterns, re)
+	}
+	opts.Secrets = append(opts.Secrets, cfg.Redaction.Secrets...)
+	opts.Secrets = append(opts.Secrets, CollectSecrets(cfg)...)
+	opts.Secrets = append(opts.Secrets, extra...)
+
+	SetDefault(New(opts))
+	return errors.Join(errs...)
+}
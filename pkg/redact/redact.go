@@ -0,0 +1,232 @@
+// Package redact removes secrets and personal data from text before it is
+// logged, shown to the model or sent to a chat.
+//
+// A Redactor combines a registry of known secret values (API keys and tokens
+// from the config, OAuth credentials, MCP .env files) with pattern detectors
+// for common credential formats. The process-wide default redactor is used
+// by the logger, tool results and outbound messages.
+package redact
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Placeholder replaces every redacted value.
+const Placeholder = "[REDACTED]"
+
+// minSecretLength is the shortest known secret that is redacted. Shorter
+// values ("true", "admin") would mangle ordinary text.
+const minSecretLength = 8
+
+// Options configures a Redactor.
+type Options struct {
+	// Secrets are literal values to redact wherever they appear.
+	Secrets []string
+	// Patterns are additional regular expressions to redact.
+	Patterns []*regexp.Regexp
+	// Emails and PhoneNumbers enable the personal data detectors.
+	Emails       bool
+	PhoneNumbers bool
+}
+
+// Detectors for well-known credential formats. Each match is replaced as a whole.
+var secretPatterns = []*regexp.Regexp{
+	// PEM private keys, including the armor lines.
+	regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`),
+	// JSON Web Tokens: base64url header and payload starting with '{"'.
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+	// OpenAI, Anthropic, OpenRouter and DeepSeek style keys.
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`),
+	// Groq, xAI and Perplexity keys.
+	regexp.MustCompile(`\b(?:gsk|xai|pplx)-?_?[A-Za-z0-9]{20,}`),
+	// GitHub tokens.
+	regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`),
+	// Slack tokens.
+	regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+	// AWS access key IDs.
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// Google API keys.
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+	// Telegram bot tokens.
+	regexp.MustCompile(`\b\d{8,10}:AA[A-Za-z0-9_-]{33}\b`),
+	// HTTP bearer credentials.
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{16,}=*`),
+}
+
+var (
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)
+	// Phone numbers in international (+CC ...) or North American
+	// ((555) 123-4567, 555-123-4567) notation. Plain digit runs are left
+	// alone: they are usually IDs, dates or amounts.
+	phonePattern = regexp.MustCompile(
+		`\+\d{1,3}[\s.-]?\(?\d{1,4}\)?(?:[\s.-]?\d{2,4}){2,4}\b|\(\d{3}\)\s?\d{3}-\d{4}\b|\b\d{3}-\d{3}-\d{4}\b`)
+)
+
+// Redactor replaces secrets in text. It is safe for concurrent use.
+type Redactor struct {
+	patterns []*regexp.Regexp
+
+	mu       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer // nil when there are no known secrets
+}
+
+// New creates a Redactor with the built-in credential detectors plus opts.
+func New(opts Options) *Redactor {
+	r := &Redactor{secrets: make(map[string]struct{})}
+	r.patterns = append(r.patterns, secretPatterns...)
+	r.patterns = append(r.patterns, opts.Patterns...)
+	if opts.Emails {
+		r.patterns = append(r.patterns, emailPattern)
+	}
+	if opts.PhoneNumbers {
+		r.patterns = append(r.patterns, phonePattern)
+	}
+	r.AddSecrets(opts.Secrets...)
+	return r
+}
+
+// AddSecrets registers literal secret values. Values shorter than eight
+// characters are ignored.
+func (r *Redactor) AddSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := false
+	for _, s := range secrets {
+		s = strings.TrimSpace(s)
+		if len(s) < minSecretLength {
+			continue
+		}
+		if _, ok := r.secrets[s]; !ok {
+			r.secrets[s] = struct{}{}
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Longest first, so a secret that contains another is replaced whole.
+	all := make([]string, 0, len(r.secrets))
+	for s := range r.secrets {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if len(all[i]) != len(all[j]) {
+			return len(all[i]) > len(all[j])
+		}
+		return all[i] < all[j]
+	})
+	pairs := make([]string, 0, 2*len(all))
+	for _, s := range all {
+		pairs = append(pairs, s, Placeholder)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// String returns s with all known secrets and detected credentials replaced.
+func (r *Redactor) String(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+	if replacer != nil {
+		s = replacer.Replace(s)
+	}
+	for _, p := range r.patterns {
+		s = p.ReplaceAllString(s, Placeholder)
+	}
+	return s
+}
+
+var defaultRedactor atomic.Pointer[Redactor]
+
+func init() {
+	defaultRedactor.Store(New(Options{}))
+}
+
+// Default returns the process-wide redactor, or nil if redaction is disabled.
+func Default() *Redactor {
+	return defaultRedactor.Load()
+}
+
+// SetDefault replaces the process-wide redactor. A nil redactor disables redaction.
+func SetDefault(r *Redactor) {
+	defaultRedactor.Store(r)
+}
+
+// String redacts s with the default redactor.
+func String(s string) string {
+	return Default().String(s)
+}
+
+// AddSecrets registers secrets with the default redactor.
+func AddSecrets(secrets ...string) {
+	if r := Default(); r != nil {
+		r.AddSecrets(secrets...)
+	}
+}
+
+// sensitiveName matches JSON field and environment variable names that hold secrets.
+var sensitiveName = regexp.MustCompile(
+	`(?i)(^|_)(api_?key|key|token|secret|password|passwd|credentials?|aes_key|encrypt_key)$`)
+
+// IsSensitiveName reports whether a field or variable name (api_key,
+// bot_token, GITHUB_TOKEN, ...) usually holds a secret.
+func IsSensitiveName(name string) bool {
+	return sensitiveName.MatchString(name)
+}
+
+// CollectSecrets walks v (a struct, pointer, map or slice, typically a
+// decoded config file) and returns the non-empty string values of fields
+// whose JSON name looks sensitive, and of map entries whose key does.
+func CollectSecrets(v any) []string {
+	var out []string
+	collect(reflect.ValueOf(v), false, &out)
+	return out
+}
+
+func collect(v reflect.Value, sensitive bool, out *[]string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collect(v.Elem(), sensitive, out)
+		}
+	case reflect.String:
+		if sensitive && v.String() != "" {
+			*out = append(*out, v.String())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" {
+				name = f.Name
+			}
+			collect(v.Field(i), IsSensitiveName(name), out)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			keySensitive := sensitive || (k.Kind() == reflect.String && IsSensitiveName(k.String()))
+			collect(iter.Value(), keySensitive, out)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			collect(v.Index(i), sensitive, out)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package redact
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestRedactor_Fixture(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "leaky.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "leaky.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(Options{
+		Secrets:      []string{"my-very-own-custom-secret"},
+		Emails:       true,
+		PhoneNumbers: true,
+	})
+	got := r.String(string(input))
+	if got != string(want) {
+		gotLines := strings.Split(got, "\n")
+		wantLines := strings.Split(string(want), "\n")
+		for i := range max(len(gotLines), len(wantLines)) {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			if g != w {
+				t.Errorf("line %d:\n got: %q\nwant: %q", i+1, g, w)
+			}
+		}
+	}
+}
+
+func TestRedactor_PersonalDataOptIn(t *testing.T) {
+	text := "mail alice@example.com or call +44 20 7946 0958"
+	if got := New(Options{}).String(text); got != text {
+		t.Errorf("personal data redacted without opting in: %q", got)
+	}
+}
+
+func TestRedactor_KnownSecrets(t *testing.T) {
+	r := New(Options{})
+	r.AddSecrets("short", "  hunter2hunter2  ", "hunter2hunter2-extended")
+
+	got := r.String("a=hunter2hunter2 b=hunter2hunter2-extended c=short")
+	if want := "a=[REDACTED] b=[REDACTED] c=short"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRedactor_ExtraPatterns(t *testing.T) {
+	r := New(Options{Patterns: []*regexp.Regexp{regexp.MustCompile(`EMP-\d{6}`)}})
+	if got := r.String("employee EMP-123456"); got != "employee [REDACTED]" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRedactor_NilIsNoop(t *testing.T) {
+	var r *Redactor
+	if got := r.String("sk-proj-FAKEfakeFAKEfake0123456789abcdef"); got == Placeholder {
+		t.Error("nil redactor should not redact")
+	}
+}
+
+func TestIsSensitiveName(t *testing.T) {
+	for _, name := range []string{"api_key", "apikey", "bot_token", "app_secret", "GITHUB_TOKEN", "encoding_aes_key", "password"} {
+		if !IsSensitiveName(name) {
+			t.Errorf("%q should be sensitive", name)
+		}
+	}
+	for _, name := range []string{"max_tokens", "max_tokens_field", "model", "allow_from", "tokens_per_day", "api_base"} {
+		if IsSensitiveName(name) {
+			t.Errorf("%q should not be sensitive", name)
+		}
+	}
+}
+
+func TestCollectSecrets(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ModelList = []config.ModelConfig{
+		{ModelName: "gpt", Model: "openai/gpt-5", APIKey: "sk-model-list-key-0001"},
+	}
+	cfg.Channels.Telegram.Token = "123456:telegram-token"
+	cfg.Tools.MCP.Servers = map[string]config.MCPServerConfig{
+		"github": {Env: map[string]string{"GITHUB_TOKEN": "ghp_from_mcp_env", "LOG_LEVEL": "debug"}},
+	}
+
+	got := CollectSecrets(cfg)
+	for _, want := range []string{"sk-model-list-key-0001", "123456:telegram-token", "ghp_from_mcp_env"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("CollectSecrets missing %q (got %q)", want, got)
+		}
+	}
+	for _, notWant := range []string{"debug", "openai/gpt-5", "gpt"} {
+		if slices.Contains(got, notWant) {
+			t.Errorf("CollectSecrets should not return %q", notWant)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() { SetDefault(New(Options{})) })
+
+	cfg := config.DefaultConfig()
+	cfg.ModelList = []config.ModelConfig{{ModelName: "m", Model: "openai/m", APIKey: "plain-config-api-key"}}
+	cfg.Redaction.Patterns = []string{"(unclosed", `INTERNAL-\d+`}
+
+	if err := Configure(cfg, "oauth-access-token-value"); err == nil {
+		t.Error("expected an error for the invalid pattern")
+	}
+	got := String("k=plain-config-api-key t=oauth-access-token-value id=INTERNAL-42")
+	if want := "k=[REDACTED] t=[REDACTED] id=[REDACTED]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	cfg.Redaction.Enabled = false
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if Default() != nil || String("plain-config-api-key") != "plain-config-api-key" {
+		t.Error("disabling redaction should remove the default redactor")
+	}
+}
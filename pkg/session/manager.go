@@ -280,3 +280,9 @@ func (sm *SessionManager) SetHistory(key string, history []providers.Message) {
 		session.Updated = time.Now()
 	}
 }
+
+// Close implements SessionStore. Sessions are flushed explicitly through
+// Save, so there is nothing left to release.
+func (sm *SessionManager) Close() error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// SessionStore is the session persistence interface used by the agent loop.
+// SessionManager implements it with in-memory sessions flushed to JSON files
+// on Save; MemoryStore implements it on top of a memory.Store backend.
+type SessionStore interface {
+	AddMessage(sessionKey, role, content string)
+	AddFullMessage(sessionKey string, msg providers.Message)
+	GetHistory(key string) []providers.Message
+	GetSummary(key string) string
+	SetSummary(key string, summary string)
+	SetHistory(key string, history []providers.Message)
+	TruncateHistory(key string, keepLast int)
+	Save(key string) error
+	Close() error
+}
+
+// MemoryStore adapts a memory.Store to SessionStore. Every call is
+// persisted immediately by the backend, so Save only compacts the session.
+//
+// Backend errors are logged rather than returned to keep the SessionStore
+// signatures identical to SessionManager's.
+type MemoryStore struct {
+	store memory.Store
+}
+
+// NewMemoryStore wraps store as a SessionStore.
+func NewMemoryStore(store memory.Store) *MemoryStore {
+	return &MemoryStore{store: store}
+}
+
+// Backend returns the underlying memory.Store.
+func (m *MemoryStore) Backend() memory.Store {
+	return m.store
+}
+
+func (m *MemoryStore) AddMessage(sessionKey, role, content string) {
+	if err := m.store.AddMessage(context.Background(), sessionKey, role, content); err != nil {
+		logSessionError("add message", sessionKey, err)
+	}
+}
+
+func (m *MemoryStore) AddFullMessage(sessionKey string, msg providers.Message) {
+	if err := m.store.AddFullMessage(context.Background(), sessionKey, msg); err != nil {
+		logSessionError("add message", sessionKey, err)
+	}
+}
+
+func (m *MemoryStore) GetHistory(key string) []providers.Message {
+	history, err := m.store.GetHistory(context.Background(), key)
+	if err != nil {
+		logSessionError("get history", key, err)
+		return []providers.Message{}
+	}
+	return history
+}
+
+func (m *MemoryStore) GetSummary(key string) string {
+	summary, err := m.store.GetSummary(context.Background(), key)
+	if err != nil {
+		logSessionError("get summary", key, err)
+		return ""
+	}
+	return summary
+}
+
+func (m *MemoryStore) SetSummary(key string, summary string) {
+	if err := m.store.SetSummary(context.Background(), key, summary); err != nil {
+		logSessionError("set summary", key, err)
+	}
+}
+
+func (m *MemoryStore) SetHistory(key string, history []providers.Message) {
+	if err := m.store.SetHistory(context.Background(), key, history); err != nil {
+		logSessionError("set history", key, err)
+	}
+}
+
+func (m *MemoryStore) TruncateHistory(key string, keepLast int) {
+	if err := m.store.TruncateHistory(context.Background(), key, keepLast); err != nil {
+		logSessionError("truncate history", key, err)
+	}
+}
+
+// Save reclaims space left behind by TruncateHistory. Messages themselves
+// were already persisted when they were added.
+func (m *MemoryStore) Save(key string) error {
+	return m.store.Compact(context.Background(), key)
+}
+
+func (m *MemoryStore) Close() error {
+	return m.store.Close()
+}
+
+// logSessionError reports a failed backend operation.
+func logSessionError(op, sessionKey string, err error) {
+	logger.WarnCF("session", "Session store "+op+" failed", map[string]any{
+		"session_key": sessionKey,
+		"error":       err.Error(),
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/egress"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
@@ -17,6 +18,7 @@ const (
 	defaultClawHubTimeout  = 30 * time.Second
 	defaultMaxZipSize      = 50 * 1024 * 1024 // 50 MB
 	defaultMaxResponseSize = 2 * 1024 * 1024  // 2 MB
+	maxRedirects           = 10
 )
 
 // ClawHubRegistry implements SkillRegistry for the ClawHub platform.
@@ -84,6 +86,18 @@ func NewClawHubRegistry(cfg ClawHubConfig) *ClawHubRegistry {
 	}
 }
 
+// setEgressPolicy restricts the hosts the client may reach. The registry
+// itself is trusted, so a self-hosted registry on the LAN keeps working.
+func (c *ClawHubRegistry) setEgressPolicy(p *egress.Policy) {
+	if p == nil {
+		return
+	}
+	if u, err := url.Parse(c.baseURL); err == nil {
+		p = p.Trust(u.Hostname())
+	}
+	c.client = p.Client(c.client, maxRedirects)
+}
+
 func (c *ClawHubRegistry) Name() string {
 	return "clawhub"
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/sipeed/picoclaw/pkg/egress"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
@@ -255,3 +256,24 @@ func createTestZip(t *testing.T, files map[string]string) []byte {
 	require.NoError(t, zw.Close())
 	return buf.Bytes()
 }
+
+func TestClawHubRegistryEgressPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		json.NewEncoder(w).Encode(clawhubSearchResponse{})
+	}))
+	defer srv.Close()
+
+	// The configured registry is trusted even though it runs on loopback.
+	reg := newTestRegistry(srv.URL, "")
+	reg.setEgressPolicy(egress.DefaultPolicy())
+
+	_, err := reg.Search(context.Background(), "github", 5)
+	require.NoError(t, err)
+
+	_, err = reg.Search(context.Background(), "redirect", 5)
+	require.ErrorIs(t, err, egress.ErrBlocked)
+}
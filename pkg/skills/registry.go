@@ -6,6 +6,8 @@ import (
 	"log/slog"
 	"sync"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/egress"
 )
 
 const (
@@ -62,6 +64,9 @@ type SkillRegistry interface {
 type RegistryConfig struct {
 	ClawHub               ClawHubConfig
 	MaxConcurrentSearches int
+	// Egress restricts the hosts registry clients may reach. The configured
+	// registry base URLs are always allowed; redirects elsewhere are checked.
+	Egress *egress.Policy
 }
 
 // ClawHubConfig configures the ClawHub registry.
@@ -101,7 +106,9 @@ func NewRegistryManagerFromConfig(cfg RegistryConfig) *RegistryManager {
 		rm.maxConcurrent = cfg.MaxConcurrentSearches
 	}
 	if cfg.ClawHub.Enabled {
-		rm.AddRegistry(NewClawHubRegistry(cfg.ClawHub))
+		clawhub := NewClawHubRegistry(cfg.ClawHub)
+		clawhub.setEgressPolicy(cfg.Egress)
+		rm.AddRegistry(clawhub)
 	}
 	return rm
 }
@@ -21,6 +21,9 @@ type State struct {
 	// LastChatID is the last chat ID used for communication
 	LastChatID string `json:"last_chat_id,omitempty"`
 
+	// VoiceReplies holds per-chat voice reply modes keyed by "channel:chatID"
+	VoiceReplies map[string]string `json:"voice_replies,omitempty"`
+
 	// Timestamp is the last time this state was updated
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -122,6 +125,36 @@ func (sm *Manager) GetLastChatID() string {
 	return sm.state.LastChatID
 }
 
+// SetVoiceReply sets the voice reply mode for a chat key and saves the state.
+// An empty mode removes the override.
+func (sm *Manager) SetVoiceReply(key, mode string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if mode == "" {
+		delete(sm.state.VoiceReplies, key)
+	} else {
+		if sm.state.VoiceReplies == nil {
+			sm.state.VoiceReplies = make(map[string]string)
+		}
+		sm.state.VoiceReplies[key] = mode
+	}
+	sm.state.Timestamp = time.Now()
+
+	if err := sm.saveAtomic(); err != nil {
+		return fmt.Errorf("failed to save state atomically: %w", err)
+	}
+
+	return nil
+}
+
+// GetVoiceReply returns the voice reply mode for a chat key, or "" if unset.
+func (sm *Manager) GetVoiceReply(key string) string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.state.VoiceReplies[key]
+}
+
 // GetTimestamp returns the timestamp of the last state update.
 func (sm *Manager) GetTimestamp() time.Time {
 	sm.mu.RLock()
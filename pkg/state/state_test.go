@@ -83,6 +83,31 @@ func TestSetLastChatID(t *testing.T) {
 	}
 }
 
+func TestSetVoiceReply(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewManager(tmpDir)
+
+	if err := sm.SetVoiceReply("telegram:42", "on"); err != nil {
+		t.Fatalf("SetVoiceReply failed: %v", err)
+	}
+	if got := sm.GetVoiceReply("telegram:42"); got != "on" {
+		t.Errorf("Expected voice reply 'on', got '%s'", got)
+	}
+
+	// Create a new manager to verify persistence
+	sm2 := NewManager(tmpDir)
+	if got := sm2.GetVoiceReply("telegram:42"); got != "on" {
+		t.Errorf("Expected persistent voice reply 'on', got '%s'", got)
+	}
+
+	if err := sm2.SetVoiceReply("telegram:42", ""); err != nil {
+		t.Fatalf("SetVoiceReply failed: %v", err)
+	}
+	if got := NewManager(tmpDir).GetVoiceReply("telegram:42"); got != "" {
+		t.Errorf("Expected voice reply to be cleared, got '%s'", got)
+	}
+}
+
 func TestAtomicity_NoCorruptionOnInterrupt(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "state-test-*")
 	if err != nil {
@@ -0,0 +1,410 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/audit"
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// ApprovalDecision is the outcome of an approval request.
+type ApprovalDecision string
+
+const (
+	ApprovalApproved ApprovalDecision = "approved"
+	ApprovalDenied   ApprovalDecision = "denied"
+	ApprovalTimedOut ApprovalDecision = "timeout"
+	ApprovalCanceled ApprovalDecision = "canceled"
+	// ApprovalUnavailable means there was no chat to ask: no admin chat is
+	// configured and the call did not come from a user chat, or came from a
+	// channel whose users cannot answer during a turn (the OpenAI-compatible API).
+	ApprovalUnavailable ApprovalDecision = "unavailable"
+)
+
+const defaultApprovalTimeout = 5 * time.Minute
+
+// ErrApprovalNotFound is returned by Resolve for unknown or already decided requests.
+var ErrApprovalNotFound = errors.New("no pending approval with that id")
+
+// ApprovalRequest is a tool call waiting for a human decision.
+type ApprovalRequest struct {
+	ID      string
+	Tool    string
+	Args    map[string]any
+	Channel string // chat the tool call originated from
+	ChatID  string
+	// PromptChannel and PromptChatID identify the chat the prompt was sent
+	// to; only replies from that chat can decide the request.
+	PromptChannel string
+	PromptChatID  string
+	Expires       time.Time
+}
+
+// ApprovalPromptFunc delivers an approval prompt to a chat.
+type ApprovalPromptFunc func(ctx context.Context, channel, chatID, text string) error
+
+// ApprovalManager pauses calls to dangerous tools until a human approves
+// them with "/approve <id>" or rejects them with "/deny <id>". Requests
+// that are not decided within the timeout are rejected. Every request and
+// decision is appended to a JSONL audit log.
+type ApprovalManager struct {
+	rules        []approvalRule
+	timeout      time.Duration
+	adminChannel string
+	adminChatID  string
+	approvers    []string
+	auditPath    string
+	prompt       ApprovalPromptFunc
+
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+	auditMu sync.Mutex
+}
+
+type pendingApproval struct {
+	req  ApprovalRequest
+	done chan approvalOutcome
+}
+
+type approvalOutcome struct {
+	decision ApprovalDecision
+	by       string
+}
+
+// approvalRule matches a tool name, a name prefix ("mcp_*"), or a tool
+// action ("i2c:write" matches the i2c tool when its action argument is "write").
+type approvalRule struct {
+	name   string
+	prefix bool
+	action string
+}
+
+func parseApprovalRule(s string) (approvalRule, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return approvalRule{}, false
+	}
+	var r approvalRule
+	if name, action, ok := strings.Cut(s, ":"); ok {
+		s, r.action = name, action
+	}
+	if strings.HasSuffix(s, "*") {
+		s, r.prefix = strings.TrimSuffix(s, "*"), true
+	}
+	r.name = s
+	return r, true
+}
+
+func (r approvalRule) matches(tool string, args map[string]any) bool {
+	if r.prefix {
+		if !strings.HasPrefix(tool, r.name) {
+			return false
+		}
+	} else if tool != r.name {
+		return false
+	}
+	if r.action == "" {
+		return true
+	}
+	action, _ := args["action"].(string)
+	return action == r.action
+}
+
+// NewApprovalManager creates an approval manager from config. Decisions are
+// logged to auditPath; an empty path disables the audit file.
+func NewApprovalManager(cfg config.ApprovalConfig, auditPath string) *ApprovalManager {
+	m := &ApprovalManager{
+		timeout:      defaultApprovalTimeout,
+		adminChannel: cfg.AdminChannel,
+		adminChatID:  cfg.AdminChatID,
+		approvers:    cfg.Approvers,
+		auditPath:    auditPath,
+		pending:      make(map[string]*pendingApproval),
+	}
+	if cfg.TimeoutSeconds > 0 {
+		m.timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	for _, s := range cfg.Tools {
+		if r, ok := parseApprovalRule(s); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+	return m
+}
+
+// SetPromptFunc sets how approval prompts are delivered. Without one, every
+// request is rejected as unavailable.
+func (m *ApprovalManager) SetPromptFunc(fn ApprovalPromptFunc) {
+	m.prompt = fn
+}
+
+type approvalWaitKey struct{}
+
+type approvalWait struct {
+	pause, resume func()
+}
+
+// WithApprovalWait returns a copy of ctx in which Request calls pause before
+// it waits for a decision and resume once it has one. The agent loop uses it
+// to serve other chats while a turn waits. Nil functions remove the hooks of
+// a parent context.
+func WithApprovalWait(ctx context.Context, pause, resume func()) context.Context {
+	return context.WithValue(ctx, approvalWaitKey{}, approvalWait{pause: pause, resume: resume})
+}
+
+// Requires reports whether calling tool with args needs approval.
+func (m *ApprovalManager) Requires(tool string, args map[string]any) bool {
+	for _, r := range m.rules {
+		if r.matches(tool, args) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request asks for approval of a tool call from the chat it came from (or
+// the admin chat, if configured) and blocks until it is decided, the
+// timeout expires or ctx is canceled.
+func (m *ApprovalManager) Request(
+	ctx context.Context,
+	tool string,
+	args map[string]any,
+	channel, chatID string,
+) ApprovalDecision {
+	req := ApprovalRequest{
+		Tool:          tool,
+		Args:          args,
+		Channel:       channel,
+		ChatID:        chatID,
+		PromptChannel: channel,
+		PromptChatID:  chatID,
+		Expires:       time.Now().Add(m.timeout),
+	}
+	if m.adminChannel != "" && m.adminChatID != "" {
+		req.PromptChannel, req.PromptChatID = m.adminChannel, m.adminChatID
+	}
+
+	if m.prompt == nil || req.PromptChatID == "" || constants.IsInternalChannel(req.PromptChannel) ||
+		constants.IsRequestChannel(req.PromptChannel) {
+		m.audit(req, ApprovalUnavailable, "")
+		return ApprovalUnavailable
+	}
+
+	m.mu.Lock()
+	for req.ID == "" || m.pending[req.ID] != nil {
+		req.ID = newApprovalID()
+	}
+	p := &pendingApproval{req: req, done: make(chan approvalOutcome, 1)}
+	m.pending[req.ID] = p
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, req.ID)
+		m.mu.Unlock()
+	}()
+
+	m.audit(req, "requested", "")
+	if err := m.prompt(ctx, req.PromptChannel, req.PromptChatID, m.promptText(req)); err != nil {
+		logger.ErrorCF("tool", "Failed to send approval prompt",
+			map[string]any{"id": req.ID, "tool": tool, "error": err.Error()})
+		m.audit(req, ApprovalUnavailable, "")
+		return ApprovalUnavailable
+	}
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	wait, _ := ctx.Value(approvalWaitKey{}).(approvalWait)
+	if wait.pause != nil {
+		wait.pause()
+	}
+	var out approvalOutcome
+	select {
+	case out = <-p.done:
+	case <-timer.C:
+		out.decision = ApprovalTimedOut
+	case <-ctx.Done():
+		out.decision = ApprovalCanceled
+	}
+	if wait.resume != nil {
+		wait.resume()
+	}
+	m.audit(req, out.decision, out.by)
+	return out.decision
+}
+
+// Resolve decides a pending request. The reply must come from the chat the
+// prompt was sent to and, if approvers are configured, from one of them.
+func (m *ApprovalManager) Resolve(
+	id string,
+	approve bool,
+	channel, chatID string,
+	sender bus.SenderInfo,
+) (ApprovalRequest, error) {
+	m.mu.Lock()
+	p, ok := m.pending[id]
+	if !ok || p.req.PromptChannel != channel || p.req.PromptChatID != chatID {
+		m.mu.Unlock()
+		return ApprovalRequest{}, ErrApprovalNotFound
+	}
+	if !m.isApprover(sender) {
+		m.mu.Unlock()
+		return ApprovalRequest{}, fmt.Errorf("you are not allowed to decide approvals")
+	}
+	delete(m.pending, id)
+	m.mu.Unlock()
+
+	out := approvalOutcome{decision: ApprovalDenied, by: approverName(sender)}
+	if approve {
+		out.decision = ApprovalApproved
+	}
+	p.done <- out
+	return p.req, nil
+}
+
+// Pending returns the undecided requests whose prompt was sent to the given
+// chat, oldest first.
+func (m *ApprovalManager) Pending(channel, chatID string) []ApprovalRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reqs []ApprovalRequest
+	for _, p := range m.pending {
+		if p.req.PromptChannel == channel && p.req.PromptChatID == chatID {
+			reqs = append(reqs, p.req)
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Expires.Before(reqs[j].Expires) })
+	return reqs
+}
+
+func (m *ApprovalManager) isApprover(sender bus.SenderInfo) bool {
+	if len(m.approvers) == 0 {
+		return true
+	}
+	for _, a := range m.approvers {
+		if identity.MatchAllowed(sender, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func approverName(sender bus.SenderInfo) string {
+	if sender.CanonicalID != "" {
+		return sender.CanonicalID
+	}
+	if sender.Username != "" {
+		return sender.Username
+	}
+	return sender.PlatformID
+}
+
+func (m *ApprovalManager) promptText(req ApprovalRequest) string {
+	argsJSON, _ := json.Marshal(req.Args)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Approval required [%s]\n", req.ID)
+	fmt.Fprintf(&sb, "Tool: %s\n", req.Tool)
+	fmt.Fprintf(&sb, "Arguments: %s\n", utils.Truncate(string(argsJSON), 500))
+	if req.PromptChannel != req.Channel || req.PromptChatID != req.ChatID {
+		fmt.Fprintf(&sb, "Requested from: %s:%s\n", req.Channel, req.ChatID)
+	}
+	fmt.Fprintf(&sb, "Reply /approve %s or /deny %s within %s.", req.ID, req.ID, m.timeout)
+	return sb.String()
+}
+
+// approvalAuditEntry is one line of the approval audit log.
+type approvalAuditEntry struct {
+	Time    time.Time      `json:"time"`
+	ID      string         `json:"id"`
+	Event   string         `json:"event"`
+	Tool    string         `json:"tool"`
+	Args    map[string]any `json:"args,omitempty"`
+	Channel string         `json:"channel,omitempty"`
+	ChatID  string         `json:"chat_id,omitempty"`
+	By      string         `json:"by,omitempty"`
+}
+
+func (m *ApprovalManager) audit(req ApprovalRequest, event ApprovalDecision, by string) {
+	logger.InfoCF("tool", "Tool approval "+string(event),
+		map[string]any{
+			"id":      req.ID,
+			"tool":    req.Tool,
+			"channel": req.Channel,
+			"chat_id": req.ChatID,
+			"by":      by,
+		})
+	if m.auditPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(approvalAuditEntry{
+		Time:    time.Now(),
+		ID:      req.ID,
+		Event:   string(event),
+		Tool:    req.Tool,
+		Args:    audit.RedactArgs(req.Args),
+		Channel: req.Channel,
+		ChatID:  req.ChatID,
+		By:      by,
+	})
+	if err != nil {
+		logger.WarnCF("tool", "Failed to encode approval audit entry", map[string]any{"error": err.Error()})
+		return
+	}
+
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(m.auditPath), 0o755); err != nil {
+		logger.WarnCF("tool", "Failed to write approval audit log", map[string]any{"error": err.Error()})
+		return
+	}
+	f, err := os.OpenFile(m.auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		logger.WarnCF("tool", "Failed to write approval audit log", map[string]any{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger.WarnCF("tool", "Failed to write approval audit log", map[string]any{"error": err.Error()})
+	}
+}
+
+// approvalRejectedResult tells the LLM why a tool call did not run.
+func approvalRejectedResult(tool string, decision ApprovalDecision) *ToolResult {
+	var msg string
+	switch decision {
+	case ApprovalDenied:
+		msg = fmt.Sprintf("The user denied the %s call. Do not retry it; ask the user how to proceed instead.", tool)
+	case ApprovalTimedOut:
+		msg = fmt.Sprintf("The %s call was not approved in time and did not run.", tool)
+	case ApprovalUnavailable:
+		msg = fmt.Sprintf("The %s call requires approval, but no chat is available to ask for it. It did not run.", tool)
+	default:
+		msg = fmt.Sprintf("The %s call was canceled before it was approved.", tool)
+	}
+	return ErrorResult(msg).WithError(fmt.Errorf("tool %s not approved: %s", tool, decision))
+}
+
+// newApprovalID returns a short random ID that is easy to type in a chat.
+// The leading hex digits of a version 4 UUID are random; uuid.New panics
+// rather than returning a predictable ID if the random source fails.
+func newApprovalID() string {
+	return uuid.New().String()[:6]
+}
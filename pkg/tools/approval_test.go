@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+var approvalIDPattern = regexp.MustCompile(`Approval required \[([0-9a-f]+)\]`)
+
+type sentPrompt struct {
+	channel, chatID, text string
+}
+
+// newTestApprovalManager returns a manager whose prompts are delivered to
+// the returned channel.
+func newTestApprovalManager(t *testing.T, cfg config.ApprovalConfig) (*ApprovalManager, chan sentPrompt, string) {
+	t.Helper()
+	auditPath := filepath.Join(t.TempDir(), "approvals.jsonl")
+	m := NewApprovalManager(cfg, auditPath)
+	prompts := make(chan sentPrompt, 4)
+	m.SetPromptFunc(func(_ context.Context, channel, chatID, text string) error {
+		prompts <- sentPrompt{channel, chatID, text}
+		return nil
+	})
+	return m, prompts, auditPath
+}
+
+func promptID(t *testing.T, p sentPrompt) string {
+	t.Helper()
+	m := approvalIDPattern.FindStringSubmatch(p.text)
+	if m == nil {
+		t.Fatalf("prompt has no approval id: %q", p.text)
+	}
+	return m[1]
+}
+
+func TestApprovalManager_Requires(t *testing.T) {
+	m := NewApprovalManager(config.ApprovalConfig{
+		Tools: []string{"exec", "mcp_*", "i2c:write", " "},
+	}, "")
+
+	tests := []struct {
+		tool string
+		args map[string]any
+		want bool
+	}{
+		{"exec", nil, true},
+		{"read_file", nil, false},
+		{"mcp_github_create_issue", nil, true},
+		{"i2c", map[string]any{"action": "write"}, true},
+		{"i2c", map[string]any{"action": "read"}, false},
+		{"i2c", nil, false},
+	}
+	for _, tt := range tests {
+		if got := m.Requires(tt.tool, tt.args); got != tt.want {
+			t.Errorf("Requires(%q, %v) = %v, want %v", tt.tool, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestApprovalManager_ApproveAndDeny(t *testing.T) {
+	m, prompts, auditPath := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"exec"}})
+
+	for _, approve := range []bool{true, false} {
+		result := make(chan ApprovalDecision, 1)
+		go func() {
+			result <- m.Request(context.Background(), "exec", map[string]any{"command": "ls"}, "telegram", "chat1")
+		}()
+
+		p := <-prompts
+		if p.channel != "telegram" || p.chatID != "chat1" {
+			t.Fatalf("prompt sent to %s:%s, want telegram:chat1", p.channel, p.chatID)
+		}
+		if !strings.Contains(p.text, "Tool: exec") || !strings.Contains(p.text, `"command":"ls"`) {
+			t.Errorf("prompt missing tool details: %q", p.text)
+		}
+		id := promptID(t, p)
+
+		if pending := m.Pending("telegram", "chat1"); len(pending) != 1 || pending[0].ID != id {
+			t.Errorf("Pending = %+v, want request %s", pending, id)
+		}
+
+		sender := bus.SenderInfo{Platform: "telegram", PlatformID: "42", CanonicalID: "telegram:42"}
+		req, err := m.Resolve(id, approve, "telegram", "chat1", sender)
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		if req.Tool != "exec" {
+			t.Errorf("resolved tool = %q", req.Tool)
+		}
+
+		want := ApprovalDenied
+		if approve {
+			want = ApprovalApproved
+		}
+		if got := <-result; got != want {
+			t.Errorf("decision = %q, want %q", got, want)
+		}
+		if _, err := m.Resolve(id, approve, "telegram", "chat1", sender); !errors.Is(err, ErrApprovalNotFound) {
+			t.Errorf("second Resolve error = %v, want ErrApprovalNotFound", err)
+		}
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e approvalAuditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("bad audit line %q: %v", line, err)
+		}
+		events = append(events, e.Event+":"+e.By)
+	}
+	want := "requested: approved:telegram:42 requested: denied:telegram:42"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("audit events = %q, want %q", got, want)
+	}
+}
+
+func TestApprovalManager_AuditRedactsArgs(t *testing.T) {
+	m, prompts, auditPath := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"exec"}})
+	m.timeout = 20 * time.Millisecond
+
+	args := map[string]any{"command": "deploy", "api_key": "sk-123"}
+	m.Request(context.Background(), "exec", args, "telegram", "chat1")
+	<-prompts
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if strings.Contains(string(data), "sk-123") {
+		t.Errorf("audit log contains a secret: %s", data)
+	}
+	if !strings.Contains(string(data), `"command":"deploy"`) {
+		t.Errorf("audit log is missing the arguments: %s", data)
+	}
+}
+
+func TestApprovalManager_WaitHooks(t *testing.T) {
+	m, prompts, _ := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"exec"}})
+	m.timeout = 20 * time.Millisecond
+
+	var calls []string
+	ctx := WithApprovalWait(context.Background(),
+		func() { calls = append(calls, "pause") },
+		func() { calls = append(calls, "resume") })
+	m.Request(ctx, "exec", nil, "telegram", "chat1")
+	<-prompts
+	if got := strings.Join(calls, ","); got != "pause,resume" {
+		t.Errorf("hooks called %q, want pause,resume", got)
+	}
+
+	calls = nil
+	m.Request(WithApprovalWait(ctx, nil, nil), "exec", nil, "telegram", "chat1")
+	<-prompts
+	if len(calls) != 0 {
+		t.Errorf("cleared hooks were called: %q", calls)
+	}
+}
+
+func TestApprovalManager_AdminChatAndApprovers(t *testing.T) {
+	m, prompts, _ := newTestApprovalManager(t, config.ApprovalConfig{
+		Tools:        []string{"exec"},
+		AdminChannel: "slack",
+		AdminChatID:  "ops",
+		Approvers:    config.FlexibleStringSlice{"@admin"},
+	})
+
+	result := make(chan ApprovalDecision, 1)
+	go func() {
+		result <- m.Request(context.Background(), "exec", nil, "telegram", "chat1")
+	}()
+
+	p := <-prompts
+	if p.channel != "slack" || p.chatID != "ops" {
+		t.Fatalf("prompt sent to %s:%s, want slack:ops", p.channel, p.chatID)
+	}
+	if !strings.Contains(p.text, "Requested from: telegram:chat1") {
+		t.Errorf("prompt does not name the originating chat: %q", p.text)
+	}
+	id := promptID(t, p)
+
+	// Replies from the originating chat or from non-approvers do not count.
+	if _, err := m.Resolve(id, true, "telegram", "chat1", bus.SenderInfo{Username: "admin"}); err == nil {
+		t.Error("expected Resolve from the originating chat to fail")
+	}
+	if _, err := m.Resolve(id, true, "slack", "ops", bus.SenderInfo{Username: "bob"}); err == nil {
+		t.Error("expected Resolve from a non-approver to fail")
+	}
+	if _, err := m.Resolve(id, true, "slack", "ops", bus.SenderInfo{Username: "admin"}); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got := <-result; got != ApprovalApproved {
+		t.Errorf("decision = %q, want approved", got)
+	}
+}
+
+func TestApprovalManager_TimeoutAndCancel(t *testing.T) {
+	m, prompts, _ := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"exec"}})
+	m.timeout = 20 * time.Millisecond
+
+	if got := m.Request(context.Background(), "exec", nil, "telegram", "chat1"); got != ApprovalTimedOut {
+		t.Errorf("decision = %q, want timeout", got)
+	}
+	<-prompts
+	if pending := m.Pending("telegram", "chat1"); len(pending) != 0 {
+		t.Errorf("expired request still pending: %+v", pending)
+	}
+
+	m.timeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-prompts
+		cancel()
+	}()
+	if got := m.Request(ctx, "exec", nil, "telegram", "chat1"); got != ApprovalCanceled {
+		t.Errorf("decision = %q, want canceled", got)
+	}
+}
+
+func TestApprovalManager_Unavailable(t *testing.T) {
+	m, prompts, _ := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"exec"}})
+
+	for _, channel := range []string{"cli", ""} {
+		if got := m.Request(context.Background(), "exec", nil, channel, ""); got != ApprovalUnavailable {
+			t.Errorf("channel %q: decision = %q, want unavailable", channel, got)
+		}
+	}
+	// API clients cannot answer a prompt while their request is waiting.
+	if got := m.Request(context.Background(), "exec", nil, "openai_api", "chatcmpl-1"); got != ApprovalUnavailable {
+		t.Errorf("openai_api: decision = %q, want unavailable", got)
+	}
+	if len(prompts) != 0 {
+		t.Error("no prompt should be sent when there is no chat to ask")
+	}
+
+	m.SetPromptFunc(func(context.Context, string, string, string) error {
+		return errors.New("channel down")
+	})
+	if got := m.Request(context.Background(), "exec", nil, "telegram", "chat1"); got != ApprovalUnavailable {
+		t.Errorf("decision = %q, want unavailable when the prompt cannot be sent", got)
+	}
+}
+
+func TestToolRegistry_ApprovalGate(t *testing.T) {
+	m, prompts, _ := newTestApprovalManager(t, config.ApprovalConfig{Tools: []string{"mock_tool"}})
+	r := NewToolRegistry()
+	r.Register(&mockRegistryTool{name: "mock_tool", result: SilentResult("ran")})
+	r.Register(&mockRegistryTool{name: "other_tool", result: SilentResult("ran")})
+	r.SetApprovalManager(m)
+
+	go func() {
+		p := <-prompts
+		id := approvalIDPattern.FindStringSubmatch(p.text)[1]
+		m.Resolve(id, false, p.channel, p.chatID, bus.SenderInfo{})
+	}()
+	result := r.ExecuteWithContext(context.Background(), "mock_tool", nil, "telegram", "chat1", nil)
+	if !result.IsError || !strings.Contains(result.ForLLM, "denied") {
+		t.Errorf("expected denial result, got %+v", result)
+	}
+
+	if result := r.ExecuteWithContext(context.Background(), "other_tool", nil, "telegram", "chat1", nil); result.IsError {
+		t.Errorf("tool without approval rule failed: %s", result.ForLLM)
+	}
+}
@@ -69,6 +69,15 @@ type AsyncTool interface {
 	SetCallback(cb AsyncCallback)
 }
 
+// SerialTool is an optional interface for tools that must not run at the
+// same time as other tool calls of a turn, for example because they modify
+// files or talk to a hardware bus. Tools that do not implement it are
+// treated as parallel-safe.
+type SerialTool interface {
+	Tool
+	ParallelSafe() bool
+}
+
 func ToolToSchema(tool Tool) map[string]any {
 	return map[string]any{
 		"type": "function",
@@ -79,3 +88,12 @@ func ToolToSchema(tool Tool) map[string]any {
 		},
 	}
 }
+
+// VerbatimTool is an optional interface for tools whose results must reach
+// the LLM unredacted. A file's contents with its secrets masked would be
+// written back with the placeholders by a later edit_file or write_file.
+// Tools that do not implement it have their results redacted.
+type VerbatimTool interface {
+	Tool
+	VerbatimResult() bool
+}
@@ -100,6 +100,11 @@ func (t *ReadFileTool) Name() string {
 	return "read_file"
 }
 
+// VerbatimResult implements VerbatimTool.
+func (t *ReadFileTool) VerbatimResult() bool {
+	return true
+}
+
 func (t *ReadFileTool) Description() string {
 	return "Read the contents of a file"
 }
@@ -146,6 +151,11 @@ func (t *WriteFileTool) Name() string {
 	return "write_file"
 }
 
+// ParallelSafe implements SerialTool.
+func (t *WriteFileTool) ParallelSafe() bool {
+	return false
+}
+
 func (t *WriteFileTool) Description() string {
 	return "Write content to a file"
 }
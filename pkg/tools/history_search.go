@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+// HistorySearcher is the search backend used by HistorySearchTool.
+// It is implemented by *memory.HistoryIndex.
+type HistorySearcher interface {
+	Search(ctx context.Context, q memory.HistoryQuery) ([]memory.HistoryHit, error)
+}
+
+// HistorySearchTool searches past conversation messages, including those
+// that were already summarized away from the active session history.
+//
+// Unless crossChat is set, only the messages of the calling session (taken
+// from the CallInfo of the context) are searched, so that participants of
+// one chat cannot read other chats' history through the model.
+type HistorySearchTool struct {
+	searcher   HistorySearcher
+	maxResults int
+	crossChat  bool
+}
+
+func NewHistorySearchTool(searcher HistorySearcher, maxResults int, crossChat bool) *HistorySearchTool {
+	return &HistorySearchTool{searcher: searcher, maxResults: maxResults, crossChat: crossChat}
+}
+
+func (t *HistorySearchTool) Name() string {
+	return "history_search"
+}
+
+func (t *HistorySearchTool) Description() string {
+	scope := "this conversation's past messages"
+	if t.crossChat {
+		scope = "past conversation messages"
+	}
+	return "Full-text search over " + scope + ", including ones no longer in the current context. " +
+		"Use it to recall earlier discussions and decisions. Returns matching snippets with session key and timestamp."
+}
+
+func (t *HistorySearchTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query": map[string]any{
+				"type":        "string",
+				"description": "Words to search for; every word must appear in a matching message",
+			},
+			"session_key": map[string]any{
+				"type": "string",
+				"description": "Optional: only search this session " +
+					"(only the current one may be searched unless cross-chat search is enabled)",
+			},
+			"channel": map[string]any{
+				"type":        "string",
+				"description": "Optional: only search messages from this channel (telegram, slack, etc.)",
+			},
+			"since": map[string]any{
+				"type":        "string",
+				"description": "Optional: earliest date, YYYY-MM-DD or RFC 3339 (inclusive)",
+			},
+			"until": map[string]any{
+				"type":        "string",
+				"description": "Optional: latest date, YYYY-MM-DD or RFC 3339 (a bare date includes that whole day)",
+			},
+			"limit": map[string]any{
+				"type":        "integer",
+				"description": "Optional: maximum number of results",
+				"minimum":     1.0,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+func (t *HistorySearchTool) Execute(ctx context.Context, args map[string]any) *ToolResult {
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return ErrorResult("query is required")
+	}
+
+	q := memory.HistoryQuery{Text: query, Limit: t.maxResults}
+	q.SessionKey, _ = args["session_key"].(string)
+	q.Channel, _ = args["channel"].(string)
+	if !t.crossChat {
+		current := CallInfoFromContext(ctx).SessionKey
+		if current == "" {
+			return ErrorResult("history search is only available within a conversation")
+		}
+		if q.SessionKey != "" && q.SessionKey != current {
+			return ErrorResult("only the current conversation's history can be searched")
+		}
+		q.SessionKey = current
+	}
+
+	if s, _ := args["since"].(string); s != "" {
+		since, _, err := parseHistoryDate(s)
+		if err != nil {
+			return ErrorResult(fmt.Sprintf("invalid since: %v", err))
+		}
+		q.Since = since
+	}
+	if s, _ := args["until"].(string); s != "" {
+		until, dateOnly, err := parseHistoryDate(s)
+		if err != nil {
+			return ErrorResult(fmt.Sprintf("invalid until: %v", err))
+		}
+		if dateOnly {
+			until = until.AddDate(0, 0, 1)
+		}
+		q.Until = until
+	}
+	if limit, ok := args["limit"].(float64); ok && int(limit) > 0 {
+		if t.maxResults <= 0 || int(limit) < t.maxResults {
+			q.Limit = int(limit)
+		}
+	}
+
+	hits, err := t.searcher.Search(ctx, q)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("history search failed: %v", err)).WithError(err)
+	}
+	if len(hits) == 0 {
+		return NewToolResult(fmt.Sprintf("No messages found matching %q", query))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Found %d matching messages:\n", len(hits))
+	for i, hit := range hits {
+		fmt.Fprintf(&sb, "\n%d. [%s] session=%s", i+1, hit.Time.Format("2006-01-02 15:04"), hit.SessionKey)
+		if hit.Channel != "" {
+			fmt.Fprintf(&sb, " channel=%s", hit.Channel)
+		}
+		fmt.Fprintf(&sb, " %s: %s", hit.Role, hit.Snippet)
+	}
+	return NewToolResult(sb.String())
+}
+
+// parseHistoryDate accepts RFC 3339 timestamps or bare YYYY-MM-DD dates,
+// the latter interpreted in local time. dateOnly reports which form was used.
+func parseHistoryDate(s string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	if t, err = time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("expected YYYY-MM-DD or RFC 3339, got %q", s)
+}
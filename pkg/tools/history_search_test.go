@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+type fakeHistorySearcher struct {
+	lastQuery memory.HistoryQuery
+	hits      []memory.HistoryHit
+	err       error
+}
+
+func (f *fakeHistorySearcher) Search(_ context.Context, q memory.HistoryQuery) ([]memory.HistoryHit, error) {
+	f.lastQuery = q
+	return f.hits, f.err
+}
+
+func TestHistorySearchTool_FormatsHits(t *testing.T) {
+	idx, err := memory.NewHistoryIndex(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewHistoryIndex: %v", err)
+	}
+	defer idx.Close()
+
+	at := time.Date(2026, 1, 15, 10, 30, 0, 0, time.Local)
+	idx.Add(context.Background(), memory.HistoryEntry{
+		SessionKey: "agent:main:telegram:direct:1",
+		Channel:    "telegram",
+		Role:       "user",
+		Content:    "we agreed to ship the beta on Monday",
+		Time:       at,
+	})
+
+	tool := NewHistorySearchTool(idx, 10, true)
+	result := tool.Execute(context.Background(), map[string]any{"query": "beta"})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	for _, want := range []string{"2026-01-15 10:30", "session=agent:main:telegram:direct:1", "channel=telegram", "**beta**"} {
+		if !strings.Contains(result.ForLLM, want) {
+			t.Errorf("result missing %q:\n%s", want, result.ForLLM)
+		}
+	}
+}
+
+func TestHistorySearchTool_OnlySearchesCurrentChat(t *testing.T) {
+	idx, err := memory.NewHistoryIndex(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewHistoryIndex: %v", err)
+	}
+	defer idx.Close()
+
+	ctx := context.Background()
+	idx.Add(ctx, memory.HistoryEntry{SessionKey: "agent:main:telegram:direct:1", Role: "user", Content: "my beta plan"})
+	idx.Add(ctx, memory.HistoryEntry{SessionKey: "agent:main:slack:group:2", Role: "user", Content: "their beta secret"})
+
+	tool := NewHistorySearchTool(idx, 10, false)
+	ctx = WithCallInfo(ctx, CallInfo{SessionKey: "agent:main:telegram:direct:1"})
+	result := tool.Execute(ctx, map[string]any{"query": "beta"})
+	if result.IsError || !strings.Contains(result.ForLLM, "plan") {
+		t.Fatalf("expected the current chat's message, got %q", result.ForLLM)
+	}
+	if strings.Contains(result.ForLLM, "secret") {
+		t.Errorf("another chat's message was returned:\n%s", result.ForLLM)
+	}
+
+	result = tool.Execute(ctx, map[string]any{"query": "beta", "session_key": "agent:main:slack:group:2"})
+	if !result.IsError {
+		t.Errorf("searching another session should fail, got %q", result.ForLLM)
+	}
+	if result := tool.Execute(context.Background(), map[string]any{"query": "beta"}); !result.IsError {
+		t.Errorf("searching without a session should fail, got %q", result.ForLLM)
+	}
+}
+
+func TestHistorySearchTool_BuildsQuery(t *testing.T) {
+	searcher := &fakeHistorySearcher{}
+	tool := NewHistorySearchTool(searcher, 5, true)
+
+	result := tool.Execute(context.Background(), map[string]any{
+		"query":       "deploy",
+		"session_key": "s1",
+		"channel":     "slack",
+		"since":       "2026-01-01",
+		"until":       "2026-01-31",
+		"limit":       3.0,
+	})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+
+	q := searcher.lastQuery
+	if q.Text != "deploy" || q.SessionKey != "s1" || q.Channel != "slack" || q.Limit != 3 {
+		t.Errorf("unexpected query: %+v", q)
+	}
+	wantSince := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	wantUntil := time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)
+	if !q.Since.Equal(wantSince) || !q.Until.Equal(wantUntil) {
+		t.Errorf("range = [%v, %v), want [%v, %v)", q.Since, q.Until, wantSince, wantUntil)
+	}
+	if !strings.Contains(result.ForLLM, "No messages found") {
+		t.Errorf("expected no-results message, got %q", result.ForLLM)
+	}
+}
+
+func TestHistorySearchTool_LimitCappedByMaxResults(t *testing.T) {
+	searcher := &fakeHistorySearcher{}
+	tool := NewHistorySearchTool(searcher, 5, true)
+
+	tool.Execute(context.Background(), map[string]any{"query": "x", "limit": 100.0})
+	if searcher.lastQuery.Limit != 5 {
+		t.Errorf("Limit = %d, want 5", searcher.lastQuery.Limit)
+	}
+}
+
+func TestHistorySearchTool_Errors(t *testing.T) {
+	searcher := &fakeHistorySearcher{err: errors.New("boom")}
+	tool := NewHistorySearchTool(searcher, 5, true)
+
+	tests := []struct {
+		name string
+		args map[string]any
+	}{
+		{name: "missing query", args: map[string]any{}},
+		{name: "bad since", args: map[string]any{"query": "x", "since": "last week"}},
+		{name: "bad until", args: map[string]any{"query": "x", "until": "01/02/2026"}},
+		{name: "backend error", args: map[string]any{"query": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tool.Execute(context.Background(), tt.args); !result.IsError {
+				t.Errorf("expected error result, got %q", result.ForLLM)
+			}
+		})
+	}
+}
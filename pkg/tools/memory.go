@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+// SemanticMemoryStore is the backend used by MemorySaveTool and
+// MemoryRecallTool. It is implemented by *memory.SemanticMemory.
+type SemanticMemoryStore interface {
+	Save(ctx context.Context, content string) (int64, error)
+	Recall(ctx context.Context, query string, k int, minScore float64) ([]memory.Recollection, error)
+}
+
+// MemorySaveTool stores a fact in long-term semantic memory.
+type MemorySaveTool struct {
+	store SemanticMemoryStore
+}
+
+func NewMemorySaveTool(store SemanticMemoryStore) *MemorySaveTool {
+	return &MemorySaveTool{store: store}
+}
+
+func (t *MemorySaveTool) Name() string {
+	return "memory_save"
+}
+
+func (t *MemorySaveTool) Description() string {
+	return "Save a fact to long-term memory so it can be recalled in future conversations. " +
+		"Store one self-contained fact per call (preferences, personal details, decisions, ongoing projects)."
+}
+
+func (t *MemorySaveTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"content": map[string]any{
+				"type":        "string",
+				"description": "The fact to remember, written so it makes sense without the current conversation",
+			},
+		},
+		"required": []string{"content"},
+	}
+}
+
+func (t *MemorySaveTool) Execute(ctx context.Context, args map[string]any) *ToolResult {
+	content, _ := args["content"].(string)
+	if strings.TrimSpace(content) == "" {
+		return ErrorResult("content is required")
+	}
+
+	id, err := t.store.Save(ctx, content)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to save memory: %v", err)).WithError(err)
+	}
+	return SilentResult(fmt.Sprintf("Saved memory #%d", id))
+}
+
+// MemoryRecallTool searches long-term semantic memory by meaning.
+type MemoryRecallTool struct {
+	store    SemanticMemoryStore
+	topK     int
+	minScore float64
+}
+
+func NewMemoryRecallTool(store SemanticMemoryStore, topK int, minScore float64) *MemoryRecallTool {
+	return &MemoryRecallTool{store: store, topK: topK, minScore: minScore}
+}
+
+func (t *MemoryRecallTool) Name() string {
+	return "memory_recall"
+}
+
+func (t *MemoryRecallTool) Description() string {
+	return "Search long-term memory for facts related to a topic. Matches by meaning, not exact words. " +
+		"Relevant memories are already included in the context automatically; use this to look up something more specific."
+}
+
+func (t *MemoryRecallTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query": map[string]any{
+				"type":        "string",
+				"description": "What to look for, e.g. \"user's dietary restrictions\"",
+			},
+			"limit": map[string]any{
+				"type":        "integer",
+				"description": "Optional: maximum number of memories to return",
+				"minimum":     1.0,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+func (t *MemoryRecallTool) Execute(ctx context.Context, args map[string]any) *ToolResult {
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return ErrorResult("query is required")
+	}
+
+	k := t.topK
+	if limit, ok := args["limit"].(float64); ok && int(limit) > 0 {
+		k = int(limit)
+	}
+
+	memories, err := t.store.Recall(ctx, query, k, t.minScore)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("memory recall failed: %v", err)).WithError(err)
+	}
+	if len(memories) == 0 {
+		return NewToolResult(fmt.Sprintf("No memories found related to %q", query))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Found %d related memories:\n", len(memories))
+	for i, m := range memories {
+		fmt.Fprintf(&sb, "\n%d. [score %.2f, saved %s] %s", i+1, m.Score, m.CreatedAt.Format("2006-01-02"), m.Content)
+	}
+	return NewToolResult(sb.String())
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+type fakeSemanticMemory struct {
+	saved     []string
+	lastQuery string
+	lastK     int
+	results   []memory.Recollection
+	err       error
+}
+
+func (f *fakeSemanticMemory) Save(_ context.Context, content string) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.saved = append(f.saved, content)
+	return int64(len(f.saved)), nil
+}
+
+func (f *fakeSemanticMemory) Recall(_ context.Context, query string, k int, _ float64) ([]memory.Recollection, error) {
+	f.lastQuery, f.lastK = query, k
+	return f.results, f.err
+}
+
+func TestMemorySaveTool(t *testing.T) {
+	store := &fakeSemanticMemory{}
+	tool := NewMemorySaveTool(store)
+
+	result := tool.Execute(context.Background(), map[string]any{"content": "User is vegetarian"})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	if !result.Silent {
+		t.Error("expected silent result")
+	}
+	if len(store.saved) != 1 || store.saved[0] != "User is vegetarian" {
+		t.Errorf("saved = %v", store.saved)
+	}
+
+	if result := tool.Execute(context.Background(), map[string]any{}); !result.IsError {
+		t.Error("expected error for missing content")
+	}
+
+	store.err = errors.New("embedding endpoint down")
+	if result := tool.Execute(context.Background(), map[string]any{"content": "x"}); !result.IsError {
+		t.Error("expected error when store fails")
+	}
+}
+
+func TestMemoryRecallTool(t *testing.T) {
+	store := &fakeSemanticMemory{results: []memory.Recollection{
+		{ID: 1, Content: "User is vegetarian", Score: 0.82, CreatedAt: time.Date(2026, 2, 3, 0, 0, 0, 0, time.Local)},
+	}}
+	tool := NewMemoryRecallTool(store, 5, 0.3)
+
+	result := tool.Execute(context.Background(), map[string]any{"query": "diet"})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	if store.lastQuery != "diet" || store.lastK != 5 {
+		t.Errorf("Recall(%q, %d), want (diet, 5)", store.lastQuery, store.lastK)
+	}
+	for _, want := range []string{"User is vegetarian", "0.82", "2026-02-03"} {
+		if !strings.Contains(result.ForLLM, want) {
+			t.Errorf("result missing %q:\n%s", want, result.ForLLM)
+		}
+	}
+
+	tool.Execute(context.Background(), map[string]any{"query": "diet", "limit": 2.0})
+	if store.lastK != 2 {
+		t.Errorf("limit: k = %d, want 2", store.lastK)
+	}
+
+	store.results = nil
+	result = tool.Execute(context.Background(), map[string]any{"query": "pets"})
+	if !strings.Contains(result.ForLLM, "No memories found") {
+		t.Errorf("expected no-results message, got %q", result.ForLLM)
+	}
+
+	if result := tool.Execute(context.Background(), map[string]any{"query": " "}); !result.IsError {
+		t.Error("expected error for empty query")
+	}
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// ExecuteToolCalls runs the tool calls of one turn with execute and returns
+// their results in the order of calls. Up to limit calls run at a time
+// (no limit if limit <= 0). A call to a tool that is not parallel-safe
+// (see SerialTool) runs alone: it waits for the calls before it and the
+// calls after it wait for it.
+//
+// Once ctx is done, calls that have not started are skipped and get an
+// error result; calls already running see the cancellation through ctx.
+func ExecuteToolCalls(
+	ctx context.Context,
+	registry *ToolRegistry,
+	calls []providers.ToolCall,
+	limit int,
+	execute func(ctx context.Context, tc providers.ToolCall) *ToolResult,
+) []*ToolResult {
+	results := make([]*ToolResult, len(calls))
+	if limit <= 0 || limit > len(calls) {
+		limit = len(calls)
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i, tc := range calls {
+		serial := !registry.parallelSafe(tc.Name)
+		if serial {
+			wg.Wait()
+		}
+		if !acquireSlot(ctx, slots) {
+			results[i] = ErrorResult("Tool call canceled: the turn was aborted").WithError(ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int, tc providers.ToolCall) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[idx] = execute(ctx, tc)
+		}(i, tc)
+
+		if serial {
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// acquireSlot takes a slot from slots, or reports false if ctx is done.
+func acquireSlot(ctx context.Context, slots chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parallelSafe reports whether the named tool may run concurrently with
+// other calls. Unknown tools are, since they only produce an error.
+func (r *ToolRegistry) parallelSafe(name string) bool {
+	if r == nil {
+		return true
+	}
+	tool, ok := r.Get(name)
+	if !ok {
+		return true
+	}
+	st, ok := tool.(SerialTool)
+	return !ok || st.ParallelSafe()
+}
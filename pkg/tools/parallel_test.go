@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// concurrencyProbe tracks how many calls are running at once.
+type concurrencyProbe struct {
+	running atomic.Int32
+	peak    atomic.Int32
+	mu      sync.Mutex
+	log     []string
+}
+
+func (p *concurrencyProbe) run(name string, d time.Duration) {
+	n := p.running.Add(1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	p.mu.Lock()
+	p.log = append(p.log, fmt.Sprintf("start %s running=%d", name, n))
+	p.mu.Unlock()
+	time.Sleep(d)
+	p.running.Add(-1)
+}
+
+type serialTool struct {
+	mockRegistryTool
+}
+
+func (t *serialTool) ParallelSafe() bool { return false }
+
+func toolCalls(names ...string) []providers.ToolCall {
+	calls := make([]providers.ToolCall, len(names))
+	for i, name := range names {
+		calls[i] = providers.ToolCall{ID: fmt.Sprintf("call_%d", i), Name: name}
+	}
+	return calls
+}
+
+func TestExecuteToolCalls_LimitAndOrder(t *testing.T) {
+	probe := &concurrencyProbe{}
+	calls := toolCalls("a", "b", "c", "d", "e")
+
+	results := ExecuteToolCalls(context.Background(), nil, calls, 2,
+		func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+			probe.run(tc.Name, 30*time.Millisecond)
+			return NewToolResult(tc.Name)
+		})
+
+	for i, r := range results {
+		if r.ForLLM != calls[i].Name {
+			t.Errorf("results[%d] = %q, want %q", i, r.ForLLM, calls[i].Name)
+		}
+	}
+	if peak := probe.peak.Load(); peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+}
+
+func TestExecuteToolCalls_SerialToolRunsAlone(t *testing.T) {
+	registry := NewToolRegistry()
+	registry.Register(&serialTool{mockRegistryTool{name: "edit_file"}})
+	probe := &concurrencyProbe{}
+
+	ExecuteToolCalls(context.Background(), registry, toolCalls("a", "b", "edit_file", "c", "d"), 0,
+		func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+			probe.run(tc.Name, 20*time.Millisecond)
+			return NewToolResult(tc.Name)
+		})
+
+	// a and b finish before edit_file starts; c and d wait for it.
+	if len(probe.log) != 5 || probe.log[2] != "start edit_file running=1" {
+		t.Errorf("edit_file should run alone, log: %v", probe.log)
+	}
+}
+
+func TestExecuteToolCalls_CanceledTurnSkipsPendingCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran atomic.Int32
+
+	results := ExecuteToolCalls(ctx, nil, toolCalls("a", "b", "c"), 1,
+		func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+			ran.Add(1)
+			cancel()
+			<-ctx.Done()
+			return ErrorResult("interrupted").WithError(ctx.Err())
+		})
+
+	if ran.Load() != 1 {
+		t.Errorf("%d calls ran, want 1", ran.Load())
+	}
+	for i, r := range results {
+		if r == nil || !r.IsError {
+			t.Errorf("results[%d] = %+v, want an error", i, r)
+		}
+	}
+	if results[2].ForLLM != "Tool call canceled: the turn was aborted" {
+		t.Errorf("pending call result = %q", results[2].ForLLM)
+	}
+}
@@ -7,13 +7,38 @@ import (
 	"sync"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/audit"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
 type ToolRegistry struct {
-	tools map[string]Tool
-	mu    sync.RWMutex
+	tools     map[string]Tool
+	approvals *ApprovalManager
+	auditLog  *audit.Logger
+	mu        sync.RWMutex
+}
+
+// CallInfo identifies on whose behalf a tool is executed. The agent loop
+// attaches it to the context passed to ExecuteWithContext so that the audit
+// log can record it.
+type CallInfo struct {
+	AgentID    string
+	SessionKey string
+	Sender     string // canonical sender ID, e.g. "telegram:123456"
+}
+
+type callInfoKey struct{}
+
+// WithCallInfo returns a copy of ctx carrying info.
+func WithCallInfo(ctx context.Context, info CallInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, info)
+}
+
+// CallInfoFromContext returns the CallInfo attached to ctx, if any.
+func CallInfoFromContext(ctx context.Context) CallInfo {
+	info, _ := ctx.Value(callInfoKey{}).(CallInfo)
+	return info
 }
 
 func NewToolRegistry() *ToolRegistry {
@@ -33,6 +58,21 @@ func (r *ToolRegistry) Register(tool Tool) {
 	r.tools[name] = tool
 }
 
+// SetApprovalManager makes calls to tools that require approval wait for a
+// human decision before they run.
+func (r *ToolRegistry) SetApprovalManager(m *ApprovalManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.approvals = m
+}
+
+// SetAuditLog records every tool execution in l.
+func (r *ToolRegistry) SetAuditLog(l *audit.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auditLog = l
+}
+
 func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -66,7 +106,20 @@ func (r *ToolRegistry) ExecuteWithContext(
 			map[string]any{
 				"tool": name,
 			})
-		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
+		result := ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
+		r.audit(ctx, name, args, channel, chatID, 0, result)
+		return result
+	}
+
+	r.mu.RLock()
+	approvals := r.approvals
+	r.mu.RUnlock()
+	if approvals != nil && approvals.Requires(name, args) {
+		if decision := approvals.Request(ctx, name, args, channel, chatID); decision != ApprovalApproved {
+			result := approvalRejectedResult(name, decision)
+			r.audit(ctx, name, args, channel, chatID, 0, result)
+			return result
+		}
 	}
 
 	// If tool implements ContextualTool, set context
@@ -85,7 +138,11 @@ func (r *ToolRegistry) ExecuteWithContext(
 
 	start := time.Now()
 	result := tool.Execute(ctx, args)
+	if vt, ok := tool.(VerbatimTool); !ok || !vt.VerbatimResult() {
+		result = result.Redacted()
+	}
 	duration := time.Since(start)
+	r.audit(ctx, name, args, channel, chatID, duration, result)
 
 	// Log based on result type
 	if result.IsError {
@@ -113,6 +170,44 @@ func (r *ToolRegistry) ExecuteWithContext(
 	return result
 }
 
+// audit appends a tool call to the audit log, if one is set.
+func (r *ToolRegistry) audit(
+	ctx context.Context,
+	name string,
+	args map[string]any,
+	channel, chatID string,
+	duration time.Duration,
+	result *ToolResult,
+) {
+	r.mu.RLock()
+	l := r.auditLog
+	r.mu.RUnlock()
+	if l == nil {
+		return
+	}
+
+	info := CallInfoFromContext(ctx)
+	entry := audit.Entry{
+		Time:       time.Now(),
+		AgentID:    info.AgentID,
+		SessionKey: info.SessionKey,
+		Channel:    channel,
+		ChatID:     chatID,
+		Sender:     info.Sender,
+		Tool:       name,
+		Args:       audit.RedactArgs(args),
+		DurationMS: duration.Milliseconds(),
+	}
+	if result != nil {
+		entry.IsError = result.IsError
+		entry.ResultSize = len(result.ForLLM)
+	}
+	if err := l.Write(entry); err != nil {
+		logger.ErrorCF("tool", "Failed to write audit log",
+			map[string]any{"tool": name, "error": err.Error()})
+	}
+}
+
 // sortedToolNames returns tool names in sorted order for deterministic iteration.
 // This is critical for KV cache stability: non-deterministic map iteration would
 // produce different system prompts and tool definitions on each call, invalidating
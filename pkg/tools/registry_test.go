@@ -2,10 +2,13 @@ package tools
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 
+	"github.com/sipeed/picoclaw/pkg/audit"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
@@ -348,3 +351,70 @@ func TestToolRegistry_ConcurrentAccess(t *testing.T) {
 		t.Error("expected tools to be registered after concurrent access")
 	}
 }
+
+func TestToolRegistry_AuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("audit.Open: %v", err)
+	}
+	defer l.Close()
+
+	r := NewToolRegistry()
+	r.Register(&mockRegistryTool{name: "ok_tool", result: NewToolResult("12345")})
+	r.Register(&mockRegistryTool{name: "bad_tool", result: ErrorResult("boom")})
+	r.SetAuditLog(l)
+
+	ctx := WithCallInfo(context.Background(), CallInfo{AgentID: "main", SessionKey: "s1", Sender: "telegram:7"})
+	r.ExecuteWithContext(ctx, "ok_tool", map[string]any{"token": "secret"}, "telegram", "42", nil)
+	r.ExecuteWithContext(ctx, "bad_tool", nil, "telegram", "42", nil)
+	r.ExecuteWithContext(ctx, "missing_tool", nil, "telegram", "42", nil)
+
+	var entries []audit.Entry
+	audit.Read(path, audit.Filter{}, func(e audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if len(entries) != 3 {
+		t.Fatalf("got %d audit entries, want 3", len(entries))
+	}
+
+	first := entries[0]
+	if first.AgentID != "main" || first.SessionKey != "s1" || first.Sender != "telegram:7" ||
+		first.Channel != "telegram" || first.ChatID != "42" || first.Tool != "ok_tool" {
+		t.Errorf("unexpected entry: %+v", first)
+	}
+	if first.IsError || first.ResultSize != 5 {
+		t.Errorf("IsError = %v, ResultSize = %d; want false, 5", first.IsError, first.ResultSize)
+	}
+	if first.Args["token"] != "[REDACTED]" {
+		t.Errorf("args not redacted: %v", first.Args)
+	}
+	if !entries[1].IsError || !entries[2].IsError {
+		t.Error("failed and unknown tool calls should be recorded as errors")
+	}
+}
+
+func TestToolRegistry_ExecuteWithContext_RedactsExceptFileContents(t *testing.T) {
+	key := "sk-proj-FAKEfakeFAKEfake0123456789abcdef"
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("OPENAI_API_KEY="+key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewToolRegistry()
+	r.Register(NewReadFileTool(dir, true))
+	r.Register(&mockRegistryTool{name: "exec", result: NewToolResult("OPENAI_API_KEY=" + key)})
+
+	// read_file results are passed on verbatim so that the model can edit
+	// the file without writing placeholders into it.
+	got := r.ExecuteWithContext(context.Background(), "read_file", map[string]any{"path": ".env"}, "", "", nil)
+	if got.ForLLM != "OPENAI_API_KEY="+key {
+		t.Errorf("read_file ForLLM = %q, want the file contents", got.ForLLM)
+	}
+
+	got = r.ExecuteWithContext(context.Background(), "exec", nil, "", "", nil)
+	if strings.Contains(got.ForLLM, key) {
+		t.Errorf("exec result not redacted: %q", got.ForLLM)
+	}
+}
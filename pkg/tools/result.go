@@ -1,6 +1,10 @@
 package tools
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/sipeed/picoclaw/pkg/redact"
+)
 
 // ToolResult represents the structured return value from tool execution.
 // It provides clear semantics for different types of results and supports
@@ -158,3 +162,12 @@ func (tr *ToolResult) WithError(err error) *ToolResult {
 	tr.Err = err
 	return tr
 }
+
+// Redacted removes secrets from ForLLM and ForUser using the default
+// redactor, so that command output or file contents containing credentials
+// are not passed to the LLM or the user. It returns the result for chaining.
+func (tr *ToolResult) Redacted() *ToolResult {
+	tr.ForLLM = redact.String(tr.ForLLM)
+	tr.ForUser = redact.String(tr.ForUser)
+	return tr
+}
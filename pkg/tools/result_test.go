@@ -3,6 +3,7 @@ package tools
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -227,3 +228,15 @@ func TestToolResultJSONStructure(t *testing.T) {
 		t.Errorf("Expected silent false, got %v", parsed["silent"])
 	}
 }
+
+func TestToolResultRedacted(t *testing.T) {
+	key := "sk-proj-FAKEfakeFAKEfake0123456789abcdef"
+	result := UserResult("OPENAI_API_KEY=" + key).Redacted()
+
+	if strings.Contains(result.ForLLM, key) || strings.Contains(result.ForUser, key) {
+		t.Errorf("API key not redacted: %+v", result)
+	}
+	if result.ForLLM != "OPENAI_API_KEY=[REDACTED]" {
+		t.Errorf("ForLLM = %q", result.ForLLM)
+	}
+}
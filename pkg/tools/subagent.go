@@ -2,6 +2,8 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -20,6 +22,10 @@ type SubagentTask struct {
 	Status        string
 	Result        string
 	Created       int64
+	// Format, if set, makes the subagent finish with JSON matching it;
+	// Output then holds the validated value.
+	Format *providers.ResponseFormat
+	Output json.RawMessage
 }
 
 type SubagentManager struct {
@@ -31,11 +37,13 @@ type SubagentManager struct {
 	workspace      string
 	tools          *ToolRegistry
 	maxIterations  int
+	maxParallel    int
 	maxTokens      int
 	temperature    float64
 	hasMaxTokens   bool
 	hasTemperature bool
 	nextID         int
+	usageHook      func(ctx context.Context, messages []providers.Message, resp *providers.LLMResponse)
 }
 
 func NewSubagentManager(
@@ -65,6 +73,25 @@ func (sm *SubagentManager) SetLLMOptions(maxTokens int, temperature float64) {
 	sm.hasTemperature = true
 }
 
+// SetMaxParallelTools limits how many tool calls a subagent runs at a time
+// (0: no limit).
+func (sm *SubagentManager) SetMaxParallelTools(n int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maxParallel = n
+}
+
+// SetUsageHook sets a function called with the messages and response of
+// each LLM call a subagent makes, along with the context of the tool call
+// that started it, for usage accounting.
+func (sm *SubagentManager) SetUsageHook(
+	hook func(ctx context.Context, messages []providers.Message, resp *providers.LLMResponse),
+) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.usageHook = hook
+}
+
 // SetTools sets the tool registry for subagent execution.
 // If not set, subagent will have access to the provided tools.
 func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
@@ -83,6 +110,7 @@ func (sm *SubagentManager) RegisterTool(tool Tool) {
 func (sm *SubagentManager) Spawn(
 	ctx context.Context,
 	task, label, agentID, originChannel, originChatID string,
+	format *providers.ResponseFormat,
 	callback AsyncCallback,
 ) (string, error) {
 	sm.mu.Lock()
@@ -100,11 +128,13 @@ func (sm *SubagentManager) Spawn(
 		OriginChatID:  originChatID,
 		Status:        "running",
 		Created:       time.Now().UnixMilli(),
+		Format:        format,
 	}
 	sm.tasks[taskID] = subagentTask
 
-	// Start task in background with context cancellation support
-	go sm.runTask(ctx, subagentTask, callback)
+	// Start task in background with context cancellation support. It runs
+	// alongside the caller's turn, so its approval waits must not pause it.
+	go sm.runTask(WithApprovalWait(ctx, nil, nil), subagentTask, callback)
 
 	if label != "" {
 		return fmt.Sprintf("Spawned subagent '%s' for task: %s", label, task), nil
@@ -147,10 +177,12 @@ After completing the task, provide a clear summary of what was done.`
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	maxParallel := sm.maxParallel
 	maxTokens := sm.maxTokens
 	temperature := sm.temperature
 	hasMaxTokens := sm.hasMaxTokens
 	hasTemperature := sm.hasTemperature
+	usageHook := sm.usageHook
 	sm.mu.RUnlock()
 
 	var llmOptions map[string]any
@@ -165,11 +197,14 @@ After completing the task, provide a clear summary of what was done.`
 	}
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions:    llmOptions,
+		Provider:         sm.provider,
+		Model:            sm.defaultModel,
+		Tools:            tools,
+		MaxIterations:    maxIter,
+		MaxParallelTools: maxParallel,
+		LLMOptions:       llmOptions,
+		ResponseFormat:   task.Format,
+		OnResponse:       responseHook(ctx, usageHook),
 	}, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
@@ -201,6 +236,7 @@ After completing the task, provide a clear summary of what was done.`
 	} else {
 		task.Status = "completed"
 		task.Result = loopResult.Content
+		task.Output = loopResult.JSON
 		result = &ToolResult{
 			ForLLM: fmt.Sprintf(
 				"Subagent '%s' completed (iterations: %d): %s",
@@ -285,6 +321,7 @@ func (t *SubagentTool) Parameters() map[string]any {
 				"type":        "string",
 				"description": "Optional short label for the task (for display)",
 			},
+			"result_schema": resultSchemaParameter,
 		},
 		"required": []string{"task"},
 	}
@@ -302,6 +339,10 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]any) *ToolRe
 	}
 
 	label, _ := args["label"].(string)
+	format, err := resultFormat(args)
+	if err != nil {
+		return ErrorResult(err.Error()).WithError(err)
+	}
 
 	if t.manager == nil {
 		return ErrorResult("Subagent manager not configured").WithError(fmt.Errorf("manager is nil"))
@@ -324,10 +365,12 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]any) *ToolRe
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	maxParallel := sm.maxParallel
 	maxTokens := sm.maxTokens
 	temperature := sm.temperature
 	hasMaxTokens := sm.hasMaxTokens
 	hasTemperature := sm.hasTemperature
+	usageHook := sm.usageHook
 	sm.mu.RUnlock()
 
 	var llmOptions map[string]any
@@ -342,11 +385,14 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]any) *ToolRe
 	}
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions:    llmOptions,
+		Provider:         sm.provider,
+		Model:            sm.defaultModel,
+		Tools:            tools,
+		MaxIterations:    maxIter,
+		MaxParallelTools: maxParallel,
+		LLMOptions:       llmOptions,
+		ResponseFormat:   format,
+		OnResponse:       responseHook(ctx, usageHook),
 	}, messages, t.originChannel, t.originChatID)
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
@@ -375,3 +421,43 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]any) *ToolRe
 		Async:   false,
 	}
 }
+
+// responseHook binds a usage hook to the context of the tool call that
+// started a subagent, or returns nil if there is no hook.
+func responseHook(
+	ctx context.Context,
+	hook func(ctx context.Context, messages []providers.Message, resp *providers.LLMResponse),
+) func(messages []providers.Message, resp *providers.LLMResponse) {
+	if hook == nil {
+		return nil
+	}
+	return func(messages []providers.Message, resp *providers.LLMResponse) {
+		hook(ctx, messages, resp)
+	}
+}
+
+// resultSchemaParameter describes the optional "result_schema" argument of
+// the spawn and subagent tools.
+var resultSchemaParameter = map[string]any{
+	"type": "object",
+	"description": "Optional JSON Schema for the result. When set, the subagent " +
+		"replies with JSON that is validated against it.",
+}
+
+// resultFormat returns the response format requested by the "result_schema"
+// argument, or nil if there is none.
+func resultFormat(args map[string]any) (*providers.ResponseFormat, error) {
+	raw, ok := args["result_schema"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	schema, ok := raw.(map[string]any)
+	if !ok {
+		return nil, errors.New("result_schema must be a JSON Schema object")
+	}
+	format := &providers.ResponseFormat{Name: "subagent_result", Schema: schema}
+	if _, err := providers.NewJSONValidator(format); err != nil {
+		return nil, fmt.Errorf("invalid result_schema: %w", err)
+	}
+	return format, nil
+}
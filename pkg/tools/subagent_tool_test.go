@@ -348,3 +348,81 @@ func TestSubagentTool_ForUserTruncation(t *testing.T) {
 		t.Error("ForLLM should contain reference to original task")
 	}
 }
+
+// structuredMockProvider answers the task in prose and, when asked for a
+// response format, with JSON.
+type structuredMockProvider struct {
+	formats []*providers.ResponseFormat
+}
+
+func (m *structuredMockProvider) Chat(
+	ctx context.Context,
+	messages []providers.Message,
+	tools []providers.ToolDefinition,
+	model string,
+	options map[string]any,
+) (*providers.LLMResponse, error) {
+	format := providers.ResponseFormatFromOptions(options)
+	m.formats = append(m.formats, format)
+	if format == nil {
+		return &providers.LLMResponse{Content: "Found 3 open issues."}, nil
+	}
+	return &providers.LLMResponse{Content: `{"open_issues": 3}`}, nil
+}
+
+func (m *structuredMockProvider) GetDefaultModel() string {
+	return "test-model"
+}
+
+var openIssuesSchema = map[string]any{
+	"type":       "object",
+	"properties": map[string]any{"open_issues": map[string]any{"type": "integer"}},
+	"required":   []any{"open_issues"},
+}
+
+func TestSubagentTool_Execute_ResultSchema(t *testing.T) {
+	provider := &structuredMockProvider{}
+	tool := NewSubagentTool(NewSubagentManager(provider, "test-model", "/tmp/test", nil))
+
+	result := tool.Execute(context.Background(), map[string]any{
+		"task":          "Count the open issues",
+		"result_schema": openIssuesSchema,
+	})
+	if result.IsError {
+		t.Fatalf("Expected success, got: %s", result.ForLLM)
+	}
+	if result.ForUser != `{"open_issues":3}` {
+		t.Errorf("ForUser = %q, want the validated JSON", result.ForUser)
+	}
+	if len(provider.formats) != 2 || provider.formats[0] != nil ||
+		provider.formats[1].Name != "subagent_result" {
+		t.Errorf("formats = %+v, want a tool loop call then a structured call", provider.formats)
+	}
+
+	result = tool.Execute(context.Background(), map[string]any{
+		"task":          "Count the open issues",
+		"result_schema": map[string]any{"type": 7},
+	})
+	if !result.IsError || !strings.Contains(result.ForLLM, "invalid result_schema") {
+		t.Errorf("invalid schema result = %+v", result)
+	}
+}
+
+func TestSubagentManager_Spawn_StoresTypedOutput(t *testing.T) {
+	manager := NewSubagentManager(&structuredMockProvider{}, "test-model", "/tmp/test", nil)
+	format := &providers.ResponseFormat{Name: "subagent_result", Schema: openIssuesSchema}
+
+	done := make(chan *ToolResult, 1)
+	if _, err := manager.Spawn(context.Background(), "Count the open issues", "issues", "", "cli", "direct",
+		format, func(ctx context.Context, result *ToolResult) { done <- result }); err != nil {
+		t.Fatalf("Spawn() error = %v", err)
+	}
+	if result := <-done; result.IsError {
+		t.Fatalf("Expected success, got: %s", result.ForLLM)
+	}
+
+	task, ok := manager.GetTask("subagent-1")
+	if !ok || task.Status != "completed" || string(task.Output) != `{"open_issues":3}` {
+		t.Errorf("task = %+v", task)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
@@ -24,12 +23,22 @@ type ToolLoopConfig struct {
 	Tools         *ToolRegistry
 	MaxIterations int
 	LLMOptions    map[string]any
+	// MaxParallelTools limits how many tool calls of one LLM response run
+	// at a time (0: no limit).
+	MaxParallelTools int
+	// ResponseFormat, if set, makes the loop end with a JSON answer
+	// validated against it, returned in ToolLoopResult.JSON.
+	ResponseFormat *providers.ResponseFormat
+	// OnResponse, if set, is called with the messages and response of each
+	// LLM call, for usage accounting.
+	OnResponse func(messages []providers.Message, resp *providers.LLMResponse)
 }
 
 // ToolLoopResult contains the result of running the tool loop.
 type ToolLoopResult struct {
 	Content    string
 	Iterations int
+	JSON       json.RawMessage // validated answer when a ResponseFormat was requested
 }
 
 // RunToolLoop executes the LLM + tool call iteration loop.
@@ -73,6 +82,9 @@ func RunToolLoop(
 				})
 			return nil, fmt.Errorf("LLM call failed: %w", err)
 		}
+		if config.OnResponse != nil {
+			config.OnResponse(messages, response)
+		}
 
 		// 4. If no tool calls, we're done
 		if len(response.ToolCalls) == 0 {
@@ -123,21 +135,8 @@ func RunToolLoop(
 		messages = append(messages, assistantMsg)
 
 		// 7. Execute tool calls in parallel
-		type indexedResult struct {
-			result *ToolResult
-			tc     providers.ToolCall
-		}
-
-		results := make([]indexedResult, len(normalizedToolCalls))
-		var wg sync.WaitGroup
-
-		for i, tc := range normalizedToolCalls {
-			results[i].tc = tc
-
-			wg.Add(1)
-			go func(idx int, tc providers.ToolCall) {
-				defer wg.Done()
-
+		results := ExecuteToolCalls(ctx, config.Tools, normalizedToolCalls, config.MaxParallelTools,
+			func(ctx context.Context, tc providers.ToolCall) *ToolResult {
 				argsJSON, _ := json.Marshal(tc.Arguments)
 				argsPreview := utils.Truncate(string(argsJSON), 200)
 				logger.InfoCF("toolloop", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
@@ -146,34 +145,76 @@ func RunToolLoop(
 						"iteration": iteration,
 					})
 
-				var toolResult *ToolResult
-				if config.Tools != nil {
-					toolResult = config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
-				} else {
-					toolResult = ErrorResult("No tools available")
+				if config.Tools == nil {
+					return ErrorResult("No tools available")
 				}
-				results[idx].result = toolResult
-			}(i, tc)
-		}
-		wg.Wait()
+				return config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
+			})
 
 		// Append results in original order
-		for _, r := range results {
-			contentForLLM := r.result.ForLLM
-			if contentForLLM == "" && r.result.Err != nil {
-				contentForLLM = r.result.Err.Error()
+		for i, result := range results {
+			contentForLLM := result.ForLLM
+			if contentForLLM == "" && result.Err != nil {
+				contentForLLM = result.Err.Error()
 			}
 
 			messages = append(messages, providers.Message{
 				Role:       "tool",
 				Content:    contentForLLM,
-				ToolCallID: r.tc.ID,
+				ToolCallID: normalizedToolCalls[i].ID,
 			})
 		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("tool loop aborted: %w", err)
+		}
 	}
 
-	return &ToolLoopResult{
+	result := &ToolLoopResult{
 		Content:    finalContent,
 		Iterations: iteration,
-	}, nil
+	}
+	if config.ResponseFormat != nil {
+		raw, err := structuredAnswer(ctx, config, messages, finalContent)
+		if err != nil {
+			return nil, err
+		}
+		result.Content, result.JSON = string(raw), raw
+	}
+	return result, nil
+}
+
+// structuredAnswer returns the loop's answer as JSON matching the
+// requested format. An answer that already matches is used as is;
+// otherwise the model is asked to restate it, without tools.
+func structuredAnswer(
+	ctx context.Context,
+	config ToolLoopConfig,
+	messages []providers.Message,
+	finalContent string,
+) (json.RawMessage, error) {
+	validator, err := providers.NewJSONValidator(config.ResponseFormat)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err := validator.Validate(finalContent); err == nil {
+		return raw, nil
+	}
+
+	if finalContent != "" {
+		messages = append(messages, providers.Message{Role: "assistant", Content: finalContent})
+	}
+	messages = append(messages, providers.Message{
+		Role:    "user",
+		Content: "Return the result of the task as JSON in the required format.",
+	})
+	structured, err := providers.ChatStructured(
+		ctx, config.Provider, messages, config.Model, config.LLMOptions, config.ResponseFormat)
+	if err != nil {
+		return nil, fmt.Errorf("structured result: %w", err)
+	}
+	if config.OnResponse != nil {
+		config.OnResponse(messages, structured.Response)
+	}
+	return structured.JSON, nil
 }
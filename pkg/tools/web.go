@@ -12,6 +12,8 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/egress"
 )
 
 const (
@@ -39,8 +41,9 @@ var (
 	reDDGSnippet = regexp.MustCompile(`<a class="result__snippet[^"]*".*?>([\s\S]*?)</a>`)
 )
 
-// createHTTPClient creates an HTTP client with optional proxy support
-func createHTTPClient(proxyURL string, timeout time.Duration) (*http.Client, error) {
+// createHTTPClient creates an HTTP client with optional proxy support.
+// A non-nil policy restricts the hosts the client may reach.
+func createHTTPClient(proxyURL string, timeout time.Duration, policy *egress.Policy) (*http.Client, error) {
 	client := &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
@@ -73,6 +76,10 @@ func createHTTPClient(proxyURL string, timeout time.Duration) (*http.Client, err
 		client.Transport.(*http.Transport).Proxy = http.ProxyFromEnvironment
 	}
 
+	if policy != nil {
+		client = policy.Client(client, maxRedirects)
+	}
+
 	return client, nil
 }
 
@@ -509,7 +516,7 @@ func NewWebSearchTool(opts WebSearchToolOptions) (*WebSearchTool, error) {
 
 	// Priority: Perplexity > Brave > Tavily > DuckDuckGo > GLM Search
 	if opts.PerplexityEnabled && opts.PerplexityAPIKey != "" {
-		client, err := createHTTPClient(opts.Proxy, perplexityTimeout)
+		client, err := createHTTPClient(opts.Proxy, perplexityTimeout, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP client for Perplexity: %w", err)
 		}
@@ -518,7 +525,7 @@ func NewWebSearchTool(opts WebSearchToolOptions) (*WebSearchTool, error) {
 			maxResults = opts.PerplexityMaxResults
 		}
 	} else if opts.BraveEnabled && opts.BraveAPIKey != "" {
-		client, err := createHTTPClient(opts.Proxy, searchTimeout)
+		client, err := createHTTPClient(opts.Proxy, searchTimeout, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP client for Brave: %w", err)
 		}
@@ -527,7 +534,7 @@ func NewWebSearchTool(opts WebSearchToolOptions) (*WebSearchTool, error) {
 			maxResults = opts.BraveMaxResults
 		}
 	} else if opts.TavilyEnabled && opts.TavilyAPIKey != "" {
-		client, err := createHTTPClient(opts.Proxy, searchTimeout)
+		client, err := createHTTPClient(opts.Proxy, searchTimeout, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP client for Tavily: %w", err)
 		}
@@ -541,7 +548,7 @@ func NewWebSearchTool(opts WebSearchToolOptions) (*WebSearchTool, error) {
 			maxResults = opts.TavilyMaxResults
 		}
 	} else if opts.DuckDuckGoEnabled {
-		client, err := createHTTPClient(opts.Proxy, searchTimeout)
+		client, err := createHTTPClient(opts.Proxy, searchTimeout, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP client for DuckDuckGo: %w", err)
 		}
@@ -550,7 +557,7 @@ func NewWebSearchTool(opts WebSearchToolOptions) (*WebSearchTool, error) {
 			maxResults = opts.DuckDuckGoMaxResults
 		}
 	} else if opts.GLMSearchEnabled && opts.GLMSearchAPIKey != "" {
-		client, err := createHTTPClient(opts.Proxy, searchTimeout)
+		client, err := createHTTPClient(opts.Proxy, searchTimeout, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP client for GLM Search: %w", err)
 		}
@@ -636,31 +643,49 @@ type WebFetchTool struct {
 	fetchLimitBytes int64
 }
 
+// WebFetchToolOptions configures NewWebFetchToolWithOptions.
+type WebFetchToolOptions struct {
+	MaxChars        int
+	Proxy           string
+	FetchLimitBytes int64
+	// Egress restricts the URLs that can be fetched, redirects included.
+	// Nil means egress.DefaultPolicy: no private or metadata addresses.
+	Egress *egress.Policy
+}
+
 func NewWebFetchTool(maxChars int, fetchLimitBytes int64) (*WebFetchTool, error) {
 	// createHTTPClient cannot fail with an empty proxy string.
 	return NewWebFetchToolWithProxy(maxChars, "", fetchLimitBytes)
 }
 
 func NewWebFetchToolWithProxy(maxChars int, proxy string, fetchLimitBytes int64) (*WebFetchTool, error) {
+	return NewWebFetchToolWithOptions(WebFetchToolOptions{
+		MaxChars:        maxChars,
+		Proxy:           proxy,
+		FetchLimitBytes: fetchLimitBytes,
+	})
+}
+
+func NewWebFetchToolWithOptions(opts WebFetchToolOptions) (*WebFetchTool, error) {
+	maxChars := opts.MaxChars
 	if maxChars <= 0 {
 		maxChars = defaultMaxChars
 	}
-	client, err := createHTTPClient(proxy, fetchTimeout)
+	policy := opts.Egress
+	if policy == nil {
+		policy = egress.DefaultPolicy()
+	}
+	client, err := createHTTPClient(opts.Proxy, fetchTimeout, policy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP client for web fetch: %w", err)
 	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) >= maxRedirects {
-			return fmt.Errorf("stopped after %d redirects", maxRedirects)
-		}
-		return nil
-	}
+	fetchLimitBytes := opts.FetchLimitBytes
 	if fetchLimitBytes <= 0 {
 		fetchLimitBytes = 10 * 1024 * 1024 // Security Fallback
 	}
 	return &WebFetchTool{
 		maxChars:        maxChars,
-		proxy:           proxy,
+		proxy:           opts.Proxy,
 		client:          client,
 		fetchLimitBytes: fetchLimitBytes,
 	}, nil
@@ -11,11 +11,31 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/egress"
 	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
 const testFetchLimit = int64(10 * 1024 * 1024)
 
+// newLoopbackWebFetchTool creates a web fetch tool that may reach the
+// httptest servers the tests run on loopback.
+func newLoopbackWebFetchTool(t *testing.T, maxChars int) (*WebFetchTool, error) {
+	t.Helper()
+	policy, err := egress.NewPolicy(config.EgressConfig{
+		BlockPrivate: true,
+		AllowCIDRs:   []string{"127.0.0.0/8", "::1/128"},
+	})
+	if err != nil {
+		t.Fatalf("egress.NewPolicy() error: %v", err)
+	}
+	return NewWebFetchToolWithOptions(WebFetchToolOptions{
+		MaxChars:        maxChars,
+		FetchLimitBytes: testFetchLimit,
+		Egress:          policy,
+	})
+}
+
 // TestWebTool_WebFetch_Success verifies successful URL fetching
 func TestWebTool_WebFetch_Success(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +45,7 @@ func TestWebTool_WebFetch_Success(t *testing.T) {
 	}))
 	defer server.Close()
 
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		t.Fatalf("Failed to create web fetch tool: %v", err)
 	}
@@ -65,7 +85,7 @@ func TestWebTool_WebFetch_JSON(t *testing.T) {
 	}))
 	defer server.Close()
 
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -90,7 +110,7 @@ func TestWebTool_WebFetch_JSON(t *testing.T) {
 
 // TestWebTool_WebFetch_InvalidURL verifies error handling for invalid URL
 func TestWebTool_WebFetch_InvalidURL(t *testing.T) {
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -115,7 +135,7 @@ func TestWebTool_WebFetch_InvalidURL(t *testing.T) {
 
 // TestWebTool_WebFetch_UnsupportedScheme verifies error handling for non-http URLs
 func TestWebTool_WebFetch_UnsupportedScheme(t *testing.T) {
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -140,7 +160,7 @@ func TestWebTool_WebFetch_UnsupportedScheme(t *testing.T) {
 
 // TestWebTool_WebFetch_MissingURL verifies error handling for missing URL
 func TestWebTool_WebFetch_MissingURL(t *testing.T) {
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -172,7 +192,7 @@ func TestWebTool_WebFetch_Truncation(t *testing.T) {
 	}))
 	defer server.Close()
 
-	tool, err := NewWebFetchTool(1000, testFetchLimit) // Limit to 1000 chars
+	tool, err := newLoopbackWebFetchTool(t, 1000) // Limit to 1000 chars
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -220,7 +240,7 @@ func TestWebFetchTool_PayloadTooLarge(t *testing.T) {
 	defer ts.Close()
 
 	// Initialize the tool
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -297,7 +317,7 @@ func TestWebTool_WebFetch_HTMLExtraction(t *testing.T) {
 	}))
 	defer server.Close()
 
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -402,7 +422,7 @@ func TestWebFetchTool_extractText(t *testing.T) {
 
 // TestWebTool_WebFetch_MissingDomain verifies error handling for URL without domain
 func TestWebTool_WebFetch_MissingDomain(t *testing.T) {
-	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	tool, err := newLoopbackWebFetchTool(t, 50000)
 	if err != nil {
 		logger.ErrorCF("agent", "Failed to create web fetch tool", map[string]any{"error": err.Error()})
 	}
@@ -426,7 +446,7 @@ func TestWebTool_WebFetch_MissingDomain(t *testing.T) {
 }
 
 func TestCreateHTTPClient_ProxyConfigured(t *testing.T) {
-	client, err := createHTTPClient("http://127.0.0.1:7890", 12*time.Second)
+	client, err := createHTTPClient("http://127.0.0.1:7890", 12*time.Second, nil)
 	if err != nil {
 		t.Fatalf("createHTTPClient() error: %v", err)
 	}
@@ -456,14 +476,14 @@ func TestCreateHTTPClient_ProxyConfigured(t *testing.T) {
 }
 
 func TestCreateHTTPClient_InvalidProxy(t *testing.T) {
-	_, err := createHTTPClient("://bad-proxy", 10*time.Second)
+	_, err := createHTTPClient("://bad-proxy", 10*time.Second, nil)
 	if err == nil {
 		t.Fatal("createHTTPClient() expected error for invalid proxy URL, got nil")
 	}
 }
 
 func TestCreateHTTPClient_Socks5ProxyConfigured(t *testing.T) {
-	client, err := createHTTPClient("socks5://127.0.0.1:1080", 8*time.Second)
+	client, err := createHTTPClient("socks5://127.0.0.1:1080", 8*time.Second, nil)
 	if err != nil {
 		t.Fatalf("createHTTPClient() error: %v", err)
 	}
@@ -486,7 +506,7 @@ func TestCreateHTTPClient_Socks5ProxyConfigured(t *testing.T) {
 }
 
 func TestCreateHTTPClient_UnsupportedProxyScheme(t *testing.T) {
-	_, err := createHTTPClient("ftp://127.0.0.1:21", 10*time.Second)
+	_, err := createHTTPClient("ftp://127.0.0.1:21", 10*time.Second, nil)
 	if err == nil {
 		t.Fatal("createHTTPClient() expected error for unsupported scheme, got nil")
 	}
@@ -505,7 +525,7 @@ func TestCreateHTTPClient_ProxyFromEnvironmentWhenConfigEmpty(t *testing.T) {
 	t.Setenv("NO_PROXY", "")
 	t.Setenv("no_proxy", "")
 
-	client, err := createHTTPClient("", 10*time.Second)
+	client, err := createHTTPClient("", 10*time.Second, nil)
 	if err != nil {
 		t.Fatalf("createHTTPClient() error: %v", err)
 	}
@@ -813,3 +833,43 @@ func TestWebTool_GLMSearch_Priority(t *testing.T) {
 		t.Errorf("Expected GLMSearchProvider when only GLM enabled, got %T", tool2.provider)
 	}
 }
+
+func TestWebTool_WebFetch_BlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal admin panel"))
+	}))
+	defer server.Close()
+
+	tool, err := NewWebFetchTool(50000, testFetchLimit)
+	if err != nil {
+		t.Fatalf("Failed to create web fetch tool: %v", err)
+	}
+
+	for _, target := range []string{server.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:8080/"} {
+		result := tool.Execute(context.Background(), map[string]any{"url": target})
+		if !result.IsError {
+			t.Errorf("fetch of %s should be blocked, got: %s", target, result.ForLLM)
+			continue
+		}
+		if !strings.Contains(result.ForLLM, "blocked by policy") {
+			t.Errorf("fetch of %s: expected a policy error, got: %s", target, result.ForLLM)
+		}
+	}
+}
+
+func TestWebTool_WebFetch_BlocksRedirectToPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	tool, err := newLoopbackWebFetchTool(t, 50000)
+	if err != nil {
+		t.Fatalf("Failed to create web fetch tool: %v", err)
+	}
+
+	result := tool.Execute(context.Background(), map[string]any{"url": server.URL})
+	if !result.IsError || !strings.Contains(result.ForLLM, "blocked by policy") {
+		t.Errorf("redirect to the metadata service should be blocked, got: %s", result.ForLLM)
+	}
+}
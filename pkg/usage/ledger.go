@@ -0,0 +1,245 @@
+// Package usage records LLM token consumption and cost per call so that
+// spending can be attributed to agents, sessions, channels and models.
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Call kinds recorded in the ledger.
+const (
+	KindChat      = "chat"      // a turn of the agent loop
+	KindSummary   = "summary"   // session summarization
+	KindRouting   = "routing"   // model routing classifier
+	KindEmbedding = "embedding" // semantic memory embeddings
+)
+
+const ledgerSchema = `
+CREATE TABLE IF NOT EXISTS usage (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at        INTEGER NOT NULL,
+	agent_id          TEXT NOT NULL,
+	session_key       TEXT NOT NULL,
+	channel           TEXT NOT NULL,
+	model             TEXT NOT NULL,
+	provider          TEXT NOT NULL,
+	kind              TEXT NOT NULL,
+	prompt_tokens     INTEGER NOT NULL,
+	completion_tokens INTEGER NOT NULL,
+	cost              REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_usage_created ON usage (created_at);
+`
+
+// Record is one LLM call.
+type Record struct {
+	Time             time.Time
+	AgentID          string
+	SessionKey       string
+	Channel          string
+	Model            string
+	Provider         string
+	Kind             string
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is computed from the price table when the call is recorded, so
+	// later price changes do not rewrite past bills. Zero if unpriced.
+	Cost float64
+}
+
+// Filter restricts which records are aggregated. Zero values match everything.
+type Filter struct {
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	AgentID    string
+	SessionKey string
+	Channel    string
+	Model      string
+}
+
+// GroupBy selects the dimension totals are broken down by.
+type GroupBy string
+
+const (
+	GroupNone     GroupBy = ""
+	GroupAgent    GroupBy = "agent"
+	GroupSession  GroupBy = "session"
+	GroupChannel  GroupBy = "channel"
+	GroupModel    GroupBy = "model"
+	GroupProvider GroupBy = "provider"
+	GroupKind     GroupBy = "kind"
+	GroupDay      GroupBy = "day"
+)
+
+// groupColumns maps each GroupBy to its SQL expression. Days are bucketed
+// in local time to match what users see in their calendar.
+var groupColumns = map[GroupBy]string{
+	GroupNone:     "'total'",
+	GroupAgent:    "agent_id",
+	GroupSession:  "session_key",
+	GroupChannel:  "channel",
+	GroupModel:    "model",
+	GroupProvider: "provider",
+	GroupKind:     "kind",
+	GroupDay:      "strftime('%Y-%m-%d', created_at / 1000, 'unixepoch', 'localtime')",
+}
+
+// ParseGroupBy validates a group-by name from user input.
+func ParseGroupBy(s string) (GroupBy, error) {
+	g := GroupBy(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := groupColumns[g]; !ok {
+		return "", fmt.Errorf("unknown group %q (want agent, session, channel, model, provider, kind or day)", s)
+	}
+	return g, nil
+}
+
+// Total aggregates the records sharing a group key.
+type Total struct {
+	Key              string
+	Calls            int
+	PromptTokens     int64
+	CompletionTokens int64
+	Cost             float64
+}
+
+// TotalTokens returns prompt plus completion tokens.
+func (t Total) TotalTokens() int64 {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+// Ledger is an append-only store of LLM usage records backed by SQLite.
+type Ledger struct {
+	db *sql.DB
+}
+
+// DefaultPath returns the ledger location inside a workspace.
+func DefaultPath(workspace string) string {
+	return filepath.Join(workspace, "state", "usage.db")
+}
+
+// Open opens (or creates) the ledger database at path.
+func Open(path string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("usage: create directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return nil, fmt.Errorf("usage: open sqlite: %w", err)
+	}
+	// One connection: the gateway and the CLI may open the file at the same
+	// time, and busy_timeout makes them wait for each other instead of failing.
+	db.SetMaxOpenConns(1)
+	for _, p := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA busy_timeout = 5000",
+	} {
+		if _, err := db.Exec(p); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("usage: %s: %w", p, err)
+		}
+	}
+	if _, err := db.Exec(ledgerSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("usage: create schema: %w", err)
+	}
+	return &Ledger{db: db}, nil
+}
+
+// Add appends a record. A zero Time is replaced by the current time.
+func (l *Ledger) Add(ctx context.Context, r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.Kind == "" {
+		r.Kind = KindChat
+	}
+	_, err := l.db.ExecContext(ctx,
+		`INSERT INTO usage (created_at, agent_id, session_key, channel, model, provider, kind,
+		                    prompt_tokens, completion_tokens, cost)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Time.UnixMilli(), r.AgentID, r.SessionKey, r.Channel, r.Model, r.Provider, r.Kind,
+		r.PromptTokens, r.CompletionTokens, r.Cost,
+	)
+	if err != nil {
+		return fmt.Errorf("usage: record call: %w", err)
+	}
+	return nil
+}
+
+// Totals aggregates the records matching f, broken down by group.
+// Day groups are returned in chronological order; all others by
+// descending cost, then descending token count.
+func (l *Ledger) Totals(ctx context.Context, f Filter, group GroupBy) ([]Total, error) {
+	column, ok := groupColumns[group]
+	if !ok {
+		return nil, fmt.Errorf("usage: unknown group %q", group)
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+	for _, eq := range []struct{ column, value string }{
+		{"agent_id", f.AgentID},
+		{"session_key", f.SessionKey},
+		{"channel", f.Channel},
+		{"model", f.Model},
+	} {
+		if eq.value != "" {
+			where = append(where, eq.column+" = ?")
+			args = append(args, eq.value)
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT %s AS k, COUNT(*), SUM(prompt_tokens) AS prompt,
+		SUM(completion_tokens) AS completion, SUM(cost) AS total_cost FROM usage`, column)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY k"
+	if group == GroupDay {
+		query += " ORDER BY k"
+	} else {
+		query += " ORDER BY total_cost DESC, prompt + completion DESC, k"
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("usage: query totals: %w", err)
+	}
+	defer rows.Close()
+
+	var totals []Total
+	for rows.Next() {
+		var t Total
+		if err := rows.Scan(&t.Key, &t.Calls, &t.PromptTokens, &t.CompletionTokens, &t.Cost); err != nil {
+			return nil, fmt.Errorf("usage: scan totals: %w", err)
+		}
+		totals = append(totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("usage: read totals: %w", err)
+	}
+	return totals, nil
+}
+
+// Close releases the underlying database.
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,157 @@
+package usage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "state", "usage.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLedger_Totals(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := context.Background()
+
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	records := []Record{
+		{
+			Time: day1, AgentID: "main", SessionKey: "s1", Channel: "telegram", Model: "gpt4",
+			PromptTokens: 100, CompletionTokens: 10, Cost: 0.5,
+		},
+		{
+			Time: day1, AgentID: "main", SessionKey: "s1", Channel: "telegram", Model: "gpt4",
+			PromptTokens: 200, CompletionTokens: 20, Cost: 1,
+		},
+		{
+			Time: day2, AgentID: "ops", SessionKey: "cron", Channel: "cron", Model: "mini",
+			PromptTokens: 50, CompletionTokens: 5, Kind: KindSummary,
+		},
+	}
+	for _, r := range records {
+		if err := l.Add(ctx, r); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	total, err := l.Totals(ctx, Filter{}, GroupNone)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(total) != 1 || total[0].Calls != 3 || total[0].TotalTokens() != 385 || total[0].Cost != 1.5 {
+		t.Errorf("unexpected grand total: %+v", total)
+	}
+
+	byModel, err := l.Totals(ctx, Filter{}, GroupModel)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(byModel) != 2 || byModel[0].Key != "gpt4" || byModel[0].Calls != 2 || byModel[0].PromptTokens != 300 {
+		t.Errorf("unexpected per-model totals: %+v", byModel)
+	}
+
+	byDay, err := l.Totals(ctx, Filter{}, GroupDay)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(byDay) != 2 || byDay[0].Key != "2026-03-01" || byDay[1].Key != "2026-03-02" {
+		t.Errorf("unexpected per-day totals: %+v", byDay)
+	}
+
+	byKind, err := l.Totals(ctx, Filter{}, GroupKind)
+	if err != nil {
+		t.Fatalf("Totals: %v", err)
+	}
+	if len(byKind) != 2 {
+		t.Errorf("unexpected per-kind totals: %+v", byKind)
+	}
+}
+
+func TestLedger_Filters(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := context.Background()
+
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	l.Add(ctx, Record{Time: day1, AgentID: "main", SessionKey: "s1", Channel: "telegram", Model: "gpt4", PromptTokens: 1})
+	l.Add(ctx, Record{Time: day2, AgentID: "main", SessionKey: "s2", Channel: "slack", Model: "gpt4", PromptTokens: 1})
+	l.Add(ctx, Record{Time: day2, AgentID: "ops", SessionKey: "s3", Channel: "cron", Model: "mini", PromptTokens: 1})
+
+	tests := []struct {
+		name string
+		f    Filter
+		want int
+	}{
+		{name: "agent", f: Filter{AgentID: "main"}, want: 2},
+		{name: "session", f: Filter{SessionKey: "s2"}, want: 1},
+		{name: "channel", f: Filter{Channel: "cron"}, want: 1},
+		{name: "model", f: Filter{Model: "gpt4"}, want: 2},
+		{name: "since", f: Filter{Since: day2}, want: 2},
+		{name: "until", f: Filter{Until: day2}, want: 1},
+		{name: "combined", f: Filter{AgentID: "main", Since: day2}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals, err := l.Totals(ctx, tt.f, GroupNone)
+			if err != nil {
+				t.Fatalf("Totals: %v", err)
+			}
+			if len(totals) != 1 || totals[0].Calls != tt.want {
+				t.Errorf("got %+v, want %d calls", totals, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	if g, err := ParseGroupBy(" Model "); err != nil || g != GroupModel {
+		t.Errorf("ParseGroupBy(Model) = %q, %v", g, err)
+	}
+	if _, err := ParseGroupBy("user"); err == nil {
+		t.Error("expected error for unknown group")
+	}
+}
+
+func TestPriceTable_Cost(t *testing.T) {
+	pt := NewPriceTable([]config.ModelConfig{
+		{ModelName: "gpt4", Model: "openai/gpt-5.2", Pricing: &config.ModelPricing{InputPerMillion: 2, OutputPerMillion: 8}},
+		{ModelName: "free", Model: "ollama/llama3"},
+	})
+
+	tests := []struct {
+		name            string
+		provider, model string
+		want            float64
+	}{
+		{name: "model_name", model: "gpt4", want: 0.0036},
+		{name: "full reference", model: "openai/gpt-5.2", want: 0.0036},
+		{name: "bare id", model: "gpt-5.2", want: 0.0036},
+		{name: "provider and id", provider: "openai", model: "gpt-5.2", want: 0.0036},
+		{name: "unpriced", model: "free", want: 0},
+		{name: "unknown", model: "other", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pt.Cost(tt.provider, tt.model, 1000, 200)
+			if diff := got - tt.want; diff > 1e-12 || diff < -1e-12 {
+				t.Errorf("Cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var nilTable *PriceTable
+	if nilTable.Cost("", "gpt4", 1, 1) != 0 {
+		t.Error("nil table should price everything at 0")
+	}
+}
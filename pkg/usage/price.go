@@ -0,0 +1,53 @@
+package usage
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// PriceTable looks up per-token prices configured on model_list entries.
+type PriceTable struct {
+	prices map[string]config.ModelPricing
+}
+
+// NewPriceTable indexes the pricing of every model_list entry by its
+// model_name, its full model reference ("openai/gpt-4o") and its bare model
+// ID ("gpt-4o"), so that a call can be priced whichever form the agent
+// loop reports. model_name and full references take precedence over bare IDs.
+func NewPriceTable(models []config.ModelConfig) *PriceTable {
+	pt := &PriceTable{prices: make(map[string]config.ModelPricing)}
+	for _, mc := range models {
+		if mc.Pricing == nil {
+			continue
+		}
+		pt.prices[mc.ModelName] = *mc.Pricing
+		pt.prices[mc.Model] = *mc.Pricing
+	}
+	for _, mc := range models {
+		if mc.Pricing == nil {
+			continue
+		}
+		if _, id, ok := strings.Cut(mc.Model, "/"); ok {
+			if _, exists := pt.prices[id]; !exists {
+				pt.prices[id] = *mc.Pricing
+			}
+		}
+	}
+	return pt
+}
+
+// Cost returns the price of a call, or 0 if the model has no pricing.
+func (pt *PriceTable) Cost(provider, model string, promptTokens, completionTokens int) float64 {
+	if pt == nil {
+		return 0
+	}
+	p, ok := pt.prices[model]
+	if !ok && provider != "" {
+		p, ok = pt.prices[provider+"/"+model]
+	}
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*p.InputPerMillion + float64(completionTokens)*p.OutputPerMillion) / 1e6
+}
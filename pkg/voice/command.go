@@ -0,0 +1,93 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// FilePlaceholder in command arguments is replaced by the audio file path.
+const FilePlaceholder = "{file}"
+
+const defaultCommandTimeout = 120 * time.Second
+
+// CommandTranscriber runs a local speech recognizer such as whisper.cpp and
+// reads the transcript from its standard output. The command is executed
+// directly, without a shell.
+type CommandTranscriber struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+// NewCommandTranscriber creates a transcriber that runs command with args.
+// Arguments containing "{file}" get the audio path substituted; if none
+// does, the path is appended as the last argument.
+func NewCommandTranscriber(command string, args []string, timeout time.Duration) *CommandTranscriber {
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	return &CommandTranscriber{
+		command: command,
+		args:    args,
+		timeout: timeout,
+	}
+}
+
+func (t *CommandTranscriber) Transcribe(ctx context.Context, audioFilePath string) (*TranscriptionResponse, error) {
+	logger.InfoCF("voice", "Starting command transcription",
+		map[string]any{"command": t.command, "audio_file": audioFilePath})
+
+	args := make([]string, 0, len(t.args)+1)
+	substituted := false
+	for _, a := range t.args {
+		if strings.Contains(a, FilePlaceholder) {
+			a = strings.ReplaceAll(a, FilePlaceholder, audioFilePath)
+			substituted = true
+		}
+		args = append(args, a)
+	}
+	if !substituted {
+		args = append(args, audioFilePath)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logger.ErrorCF("voice", "Transcription command failed", map[string]any{
+			"command": t.command,
+			"error":   err.Error(),
+			"stderr":  utils.Truncate(stderr.String(), 500),
+		})
+		return nil, fmt.Errorf("transcription command failed: %w: %s",
+			err, utils.Truncate(strings.TrimSpace(stderr.String()), 200))
+	}
+
+	// Recognizers print one line per segment; join them into one paragraph.
+	text := strings.Join(strings.Fields(stdout.String()), " ")
+	logger.InfoCF("voice", "Transcription completed successfully", map[string]any{
+		"text_length":           len(text),
+		"transcription_preview": utils.Truncate(text, 50),
+	})
+	return &TranscriptionResponse{Text: text}, nil
+}
+
+// IsAvailable reports whether the command can be found.
+func (t *CommandTranscriber) IsAvailable() bool {
+	if t.command == "" {
+		return false
+	}
+	_, err := exec.LookPath(t.command)
+	return err == nil
+}
@@ -0,0 +1,126 @@
+package voice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// NewTranscriber creates the transcriber configured in cfg.Voice. It returns
+// nil when transcription is disabled or the backend is not usable (for
+// example "groq" without an API key).
+func NewTranscriber(cfg *config.Config) (Transcriber, error) {
+	tc := cfg.Voice.Transcription
+	if !tc.Enabled {
+		return nil, nil
+	}
+
+	var t Transcriber
+	switch strings.ToLower(tc.Provider) {
+	case "", "groq":
+		apiKey := tc.APIKey
+		if apiKey == "" {
+			apiKey = providerAPIKey(cfg, cfg.Providers.Groq.APIKey, "groq/")
+		}
+		groq := NewGroqTranscriber(apiKey)
+		if tc.APIBase != "" {
+			groq.apiBase = strings.TrimRight(tc.APIBase, "/")
+		}
+		if tc.Model != "" {
+			groq.model = tc.Model
+		}
+		groq.language = tc.Language
+		if tc.TimeoutSeconds > 0 {
+			groq.httpClient.Timeout = time.Duration(tc.TimeoutSeconds) * time.Second
+		}
+		t = groq
+	case "openai":
+		openai := NewOpenAITranscriber(tc.APIBase, tc.APIKey, tc.Model)
+		openai.language = tc.Language
+		if tc.TimeoutSeconds > 0 {
+			openai.httpClient.Timeout = time.Duration(tc.TimeoutSeconds) * time.Second
+		}
+		t = openai
+	case "command":
+		if tc.Command == "" {
+			return nil, fmt.Errorf("voice transcription: provider \"command\" requires a command")
+		}
+		t = NewCommandTranscriber(tc.Command, tc.Args, time.Duration(tc.TimeoutSeconds)*time.Second)
+	default:
+		return nil, fmt.Errorf("voice transcription: unknown provider %q", tc.Provider)
+	}
+
+	if !t.IsAvailable() {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// NewSynthesizer creates the speech synthesizer configured in
+// cfg.Voice.Speech. It returns nil when speech is disabled or the backend is
+// not usable.
+func NewSynthesizer(cfg *config.Config) (Synthesizer, error) {
+	sc := cfg.Voice.Speech
+	if !sc.Enabled {
+		return nil, nil
+	}
+	if sc.Format != "" {
+		if _, ok := audioFormats[sc.Format]; !ok {
+			return nil, fmt.Errorf("voice speech: unsupported format %q", sc.Format)
+		}
+	}
+	if sc.Reply != "" && !ValidReplyMode(sc.Reply) {
+		return nil, fmt.Errorf("voice speech: invalid reply mode %q", sc.Reply)
+	}
+	for channel, mode := range sc.Channels {
+		if !ValidReplyMode(mode) {
+			return nil, fmt.Errorf("voice speech: invalid reply mode %q for channel %q", mode, channel)
+		}
+	}
+
+	var s Synthesizer
+	switch strings.ToLower(sc.Provider) {
+	case "", "openai":
+		apiBase, apiKey := sc.APIBase, sc.APIKey
+		if apiBase == "" && apiKey == "" {
+			apiBase = cfg.Providers.OpenAI.APIBase
+			apiKey = providerAPIKey(cfg, cfg.Providers.OpenAI.APIKey, "openai/")
+			if apiKey == "" {
+				return nil, nil
+			}
+		}
+		openai := NewOpenAISynthesizer(apiBase, apiKey, sc.Model, sc.Voice, sc.Format)
+		if sc.TimeoutSeconds > 0 {
+			openai.httpClient.Timeout = time.Duration(sc.TimeoutSeconds) * time.Second
+		}
+		s = openai
+	case "command":
+		if sc.Command == "" {
+			return nil, fmt.Errorf("voice speech: provider \"command\" requires a command")
+		}
+		s = NewCommandSynthesizer(sc.Command, sc.Args, sc.Format, time.Duration(sc.TimeoutSeconds)*time.Second)
+	default:
+		return nil, fmt.Errorf("voice speech: unknown provider %q", sc.Provider)
+	}
+
+	if !s.IsAvailable() {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// providerAPIKey returns key, or else the first key of a model list entry
+// whose model starts with prefix.
+func providerAPIKey(cfg *config.Config, key, prefix string) string {
+	if key != "" {
+		return key
+	}
+	for _, m := range cfg.ModelList {
+		if strings.HasPrefix(m.Model, prefix) && m.APIKey != "" {
+			return m.APIKey
+		}
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// Voice reply modes for a channel or chat.
+const (
+	ReplyOff  = "off"  // always reply with text
+	ReplyOn   = "on"   // always reply with voice
+	ReplyAuto = "auto" // reply with voice when the user sent a voice message
+)
+
+// ValidReplyMode reports whether mode is one of ReplyOff, ReplyOn and ReplyAuto.
+func ValidReplyMode(mode string) bool {
+	return mode == ReplyOff || mode == ReplyOn || mode == ReplyAuto
+}
+
+// MaxSpeechChars is the longest reply that is spoken. Longer replies are
+// better read than listened to, and most TTS APIs reject them anyway.
+const MaxSpeechChars = 4096
+
+// Synthesizer converts text to speech.
+type Synthesizer interface {
+	// Synthesize writes speech for text to a new file in the media
+	// directory. The caller owns the file.
+	Synthesize(ctx context.Context, text string) (*SpeechResult, error)
+	IsAvailable() bool
+}
+
+// SpeechResult is a synthesized audio file.
+type SpeechResult struct {
+	Path        string
+	ContentType string
+}
+
+// audioFormats maps output formats to file extensions and MIME types.
+var audioFormats = map[string]struct{ ext, contentType string }{
+	"mp3":  {".mp3", "audio/mpeg"},
+	"opus": {".ogg", "audio/ogg"},
+	"ogg":  {".ogg", "audio/ogg"},
+	"aac":  {".aac", "audio/aac"},
+	"flac": {".flac", "audio/flac"},
+	"wav":  {".wav", "audio/wav"},
+}
+
+// createSpeechFile creates an empty file for audio in format in the shared
+// media directory.
+func createSpeechFile(format string) (*os.File, string, error) {
+	f, ok := audioFormats[format]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported audio format %q", format)
+	}
+	dir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, "", fmt.Errorf("failed to create media directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "tts-*"+f.ext)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create audio file: %w", err)
+	}
+	return file, f.contentType, nil
+}
+
+const (
+	openAISpeechModel  = "tts-1"
+	openAISpeechVoice  = "alloy"
+	openAISpeechFormat = "opus" // Ogg/Opus, sent as a voice note by Telegram and WhatsApp
+)
+
+// OpenAISynthesizer uses an OpenAI-compatible /audio/speech endpoint.
+type OpenAISynthesizer struct {
+	apiKey     string
+	apiBase    string
+	model      string
+	voice      string
+	format     string
+	httpClient *http.Client
+}
+
+func NewOpenAISynthesizer(apiBase, apiKey, model, voice, format string) *OpenAISynthesizer {
+	logger.DebugCF("voice", "Creating OpenAI-compatible synthesizer",
+		map[string]any{"api_base": apiBase, "model": model, "has_api_key": apiKey != ""})
+
+	if apiBase == "" {
+		apiBase = openAIAPIBase
+	}
+	if model == "" {
+		model = openAISpeechModel
+	}
+	if voice == "" {
+		voice = openAISpeechVoice
+	}
+	if format == "" {
+		format = openAISpeechFormat
+	}
+	return &OpenAISynthesizer{
+		apiKey:  apiKey,
+		apiBase: strings.TrimRight(apiBase, "/"),
+		model:   model,
+		voice:   voice,
+		format:  format,
+		httpClient: &http.Client{
+			Timeout: defaultHTTPTimeout,
+		},
+	}
+}
+
+func (s *OpenAISynthesizer) Synthesize(ctx context.Context, text string) (*SpeechResult, error) {
+	logger.InfoCF("voice", "Starting speech synthesis", map[string]any{"text_length": len(text), "voice": s.voice})
+
+	payload, err := json.Marshal(map[string]string{
+		"model":           s.model,
+		"input":           text,
+		"voice":           s.voice,
+		"response_format": s.format,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.apiBase+"/audio/speech", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		logger.ErrorCF("voice", "Speech API error", map[string]any{
+			"status_code": resp.StatusCode,
+			"response":    string(body),
+		})
+		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	file, contentType, err := createSpeechFile(s.format)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write audio: %w", err)
+	}
+
+	logger.InfoCF("voice", "Speech synthesis completed", map[string]any{"bytes": n, "path": file.Name()})
+	return &SpeechResult{Path: file.Name(), ContentType: contentType}, nil
+}
+
+// IsAvailable reports whether an endpoint is configured.
+func (s *OpenAISynthesizer) IsAvailable() bool {
+	return s.apiBase != ""
+}
+
+var (
+	reMarkdownLink  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	reCodeBlock     = regexp.MustCompile("(?s)```.*?```")
+	reMarkdownChars = regexp.MustCompile("[*`#>~]+")
+)
+
+// SpeakableText strips Markdown from text so it is not read out: links keep
+// their label, code blocks are dropped and emphasis characters removed.
+func SpeakableText(text string) string {
+	text = reCodeBlock.ReplaceAllString(text, "")
+	text = reMarkdownLink.ReplaceAllString(text, "$1")
+	text = reMarkdownChars.ReplaceAllString(text, "")
+	return strings.TrimSpace(text)
+}
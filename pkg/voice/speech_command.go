@@ -0,0 +1,123 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Placeholders in synthesizer command arguments.
+const (
+	TextPlaceholder   = "{text}"
+	OutputPlaceholder = "{output}"
+)
+
+// CommandSynthesizer runs a local TTS engine such as piper or espeak-ng.
+// The text is passed in place of "{text}" in the arguments, or on standard
+// input if no argument contains it. The engine writes audio to "{output}",
+// or to standard output if no argument contains it. The command is executed
+// directly, without a shell.
+type CommandSynthesizer struct {
+	command string
+	args    []string
+	format  string
+	timeout time.Duration
+}
+
+// NewCommandSynthesizer creates a synthesizer that runs command with args
+// and produces audio in format ("wav" if empty).
+func NewCommandSynthesizer(command string, args []string, format string, timeout time.Duration) *CommandSynthesizer {
+	if format == "" {
+		format = "wav"
+	}
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	return &CommandSynthesizer{
+		command: command,
+		args:    args,
+		format:  format,
+		timeout: timeout,
+	}
+}
+
+func (s *CommandSynthesizer) Synthesize(ctx context.Context, text string) (*SpeechResult, error) {
+	logger.InfoCF("voice", "Starting command speech synthesis",
+		map[string]any{"command": s.command, "text_length": len(text)})
+
+	file, contentType, err := createSpeechFile(s.format)
+	if err != nil {
+		return nil, err
+	}
+	outputPath := file.Name()
+
+	args := make([]string, 0, len(s.args))
+	textInArgs, outputInArgs := false, false
+	for _, a := range s.args {
+		if strings.Contains(a, TextPlaceholder) {
+			a = strings.ReplaceAll(a, TextPlaceholder, text)
+			textInArgs = true
+		}
+		if strings.Contains(a, OutputPlaceholder) {
+			a = strings.ReplaceAll(a, OutputPlaceholder, outputPath)
+			outputInArgs = true
+		}
+		args = append(args, a)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	cmd.Stderr = &stderr
+	if !textInArgs {
+		cmd.Stdin = strings.NewReader(text)
+	}
+	if outputInArgs {
+		file.Close()
+	} else {
+		cmd.Stdout = file
+	}
+
+	err = cmd.Run()
+	if !outputInArgs {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		logger.ErrorCF("voice", "Speech command failed", map[string]any{
+			"command": s.command,
+			"error":   err.Error(),
+			"stderr":  utils.Truncate(stderr.String(), 500),
+		})
+		return nil, fmt.Errorf("speech command failed: %w: %s",
+			err, utils.Truncate(strings.TrimSpace(stderr.String()), 200))
+	}
+
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		os.Remove(outputPath)
+		return nil, fmt.Errorf("speech command produced no audio")
+	}
+
+	logger.InfoCF("voice", "Speech synthesis completed", map[string]any{"path": outputPath})
+	return &SpeechResult{Path: outputPath, ContentType: contentType}, nil
+}
+
+// IsAvailable reports whether the command can be found.
+func (s *CommandSynthesizer) IsAvailable() bool {
+	if s.command == "" {
+		return false
+	}
+	_, err := exec.LookPath(s.command)
+	return err == nil
+}
@@ -0,0 +1,141 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestOpenAISynthesizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if body["input"] != "hello" || body["voice"] != "nova" || body["response_format"] != "opus" {
+			t.Errorf("body = %v", body)
+		}
+		w.Write([]byte("OggS fake speech"))
+	}))
+	defer server.Close()
+
+	s := NewOpenAISynthesizer(server.URL+"/v1/", "test-key", "", "nova", "")
+	result, err := s.Synthesize(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	defer os.Remove(result.Path)
+	data, _ := os.ReadFile(result.Path)
+	if string(data) != "OggS fake speech" || result.ContentType != "audio/ogg" {
+		t.Errorf("result = %+v with %q", result, data)
+	}
+}
+
+func TestOpenAISynthesizer_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad voice"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if _, err := NewOpenAISynthesizer(server.URL, "", "", "", "").Synthesize(context.Background(), "hi"); err == nil {
+		t.Fatal("expected an error for a 400 response")
+	}
+}
+
+func TestCommandSynthesizer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	// Text as an argument, audio written to the output file.
+	s := NewCommandSynthesizer("sh", []string{"-c", `printf 'RIFF %s' "$1" > "$2"`, "sh", "{text}", "{output}"}, "", 0)
+	result, err := s.Synthesize(context.Background(), "hi there")
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	defer os.Remove(result.Path)
+	data, _ := os.ReadFile(result.Path)
+	if string(data) != "RIFF hi there" || result.ContentType != "audio/wav" {
+		t.Errorf("result = %+v with %q", result, data)
+	}
+
+	// Text on stdin, audio on stdout.
+	piped := NewCommandSynthesizer("sh", []string{"-c", "printf 'OggS '; cat"}, "opus", 0)
+	result, err = piped.Synthesize(context.Background(), "piped")
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	defer os.Remove(result.Path)
+	data, _ = os.ReadFile(result.Path)
+	if string(data) != "OggS piped" || result.ContentType != "audio/ogg" {
+		t.Errorf("result = %+v with %q", result, data)
+	}
+
+	silent := NewCommandSynthesizer("sh", []string{"-c", "true"}, "", 0)
+	if _, err := silent.Synthesize(context.Background(), "hi"); err == nil {
+		t.Error("expected an error when the command writes no audio")
+	}
+}
+
+func TestSpeakableText(t *testing.T) {
+	in := "## Result\n**Done**, see [the docs](https://example.com).\n```go\nfmt.Println()\n```\n> `ok`"
+	want := "Result\nDone, see the docs.\n\n ok"
+	if got := SpeakableText(in); got != want {
+		t.Errorf("SpeakableText = %q, want %q", got, want)
+	}
+}
+
+func TestNewSynthesizer(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if s, err := NewSynthesizer(cfg); err != nil || s != nil {
+		t.Errorf("disabled speech = %v, %v; want nil", s, err)
+	}
+
+	cfg.Voice.Speech.Enabled = true
+	if s, err := NewSynthesizer(cfg); err != nil || s != nil {
+		t.Errorf("NewSynthesizer without an OpenAI key = %v, %v; want nil", s, err)
+	}
+
+	cfg.ModelList = []config.ModelConfig{{ModelName: "gpt", Model: "openai/gpt-4o", APIKey: "sk-test"}}
+	s, err := NewSynthesizer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if openai, ok := s.(*OpenAISynthesizer); !ok || openai.apiKey != "sk-test" {
+		t.Errorf("NewSynthesizer = %#v, want an OpenAI synthesizer with the model list key", s)
+	}
+
+	cfg.Voice.Speech.Format = "midi"
+	if _, err := NewSynthesizer(cfg); err == nil {
+		t.Error("unsupported format should fail")
+	}
+	cfg.Voice.Speech.Format = ""
+
+	cfg.Voice.Speech.Channels = map[string]string{"telegram": "sometimes"}
+	if _, err := NewSynthesizer(cfg); err == nil {
+		t.Error("invalid channel reply mode should fail")
+	}
+	cfg.Voice.Speech.Channels = nil
+
+	cfg.Voice.Speech.Provider = "command"
+	if _, err := NewSynthesizer(cfg); err == nil {
+		t.Error("provider command without a command should fail")
+	}
+
+	cfg.Voice.Speech.Provider = "carrier-pigeon"
+	if _, err := NewSynthesizer(cfg); err == nil {
+		t.Error("unknown provider should fail")
+	}
+}
@@ -10,16 +10,17 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
-type GroqTranscriber struct {
-	apiKey     string
-	apiBase    string
-	httpClient *http.Client
+// Transcriber converts an audio file to text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioFilePath string) (*TranscriptionResponse, error)
+	IsAvailable() bool
 }
 
 type TranscriptionResponse struct {
@@ -28,20 +29,70 @@ type TranscriptionResponse struct {
 	Duration float64 `json:"duration,omitempty"`
 }
 
+const (
+	groqAPIBase            = "https://api.groq.com/openai/v1"
+	groqTranscriptionModel = "whisper-large-v3"
+	openAIAPIBase          = "https://api.openai.com/v1"
+	defaultHTTPTimeout     = 60 * time.Second
+)
+
+// OpenAITranscriber uses an OpenAI-compatible /audio/transcriptions
+// endpoint: OpenAI, Groq, or a self-hosted Whisper server.
+type OpenAITranscriber struct {
+	apiKey     string
+	apiBase    string
+	model      string
+	language   string // optional ISO-639-1 hint
+	httpClient *http.Client
+}
+
+func NewOpenAITranscriber(apiBase, apiKey, model string) *OpenAITranscriber {
+	logger.DebugCF("voice", "Creating OpenAI-compatible transcriber",
+		map[string]any{"api_base": apiBase, "model": model, "has_api_key": apiKey != ""})
+
+	if apiBase == "" {
+		apiBase = openAIAPIBase
+	}
+	if model == "" {
+		model = "whisper-1"
+	}
+	return &OpenAITranscriber{
+		apiKey:  apiKey,
+		apiBase: strings.TrimRight(apiBase, "/"),
+		model:   model,
+		httpClient: &http.Client{
+			Timeout: defaultHTTPTimeout,
+		},
+	}
+}
+
+// GroqTranscriber uses Groq's hosted Whisper model.
+type GroqTranscriber struct {
+	*OpenAITranscriber
+}
+
 func NewGroqTranscriber(apiKey string) *GroqTranscriber {
 	logger.DebugCF("voice", "Creating Groq transcriber", map[string]any{"has_api_key": apiKey != ""})
 
-	apiBase := "https://api.groq.com/openai/v1"
 	return &GroqTranscriber{
-		apiKey:  apiKey,
-		apiBase: apiBase,
-		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+		OpenAITranscriber: &OpenAITranscriber{
+			apiKey:  apiKey,
+			apiBase: groqAPIBase,
+			model:   groqTranscriptionModel,
+			httpClient: &http.Client{
+				Timeout: defaultHTTPTimeout,
+			},
 		},
 	}
 }
 
-func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string) (*TranscriptionResponse, error) {
+func (t *GroqTranscriber) IsAvailable() bool {
+	available := t.apiKey != ""
+	logger.DebugCF("voice", "Checking transcriber availability", map[string]any{"available": available})
+	return available
+}
+
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, audioFilePath string) (*TranscriptionResponse, error) {
 	logger.InfoCF("voice", "Starting transcription", map[string]any{"audio_file": audioFilePath})
 
 	audioFile, err := os.Open(audioFilePath)
@@ -79,7 +130,7 @@ func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string)
 
 	logger.DebugCF("voice", "File copied to request", map[string]any{"bytes_copied": copied})
 
-	if err = writer.WriteField("model", "whisper-large-v3"); err != nil {
+	if err = writer.WriteField("model", t.model); err != nil {
 		logger.ErrorCF("voice", "Failed to write model field", map[string]any{"error": err})
 		return nil, fmt.Errorf("failed to write model field: %w", err)
 	}
@@ -89,6 +140,13 @@ func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string)
 		return nil, fmt.Errorf("failed to write response_format field: %w", err)
 	}
 
+	if t.language != "" {
+		if err = writer.WriteField("language", t.language); err != nil {
+			logger.ErrorCF("voice", "Failed to write language field", map[string]any{"error": err})
+			return nil, fmt.Errorf("failed to write language field: %w", err)
+		}
+	}
+
 	if err = writer.Close(); err != nil {
 		logger.ErrorCF("voice", "Failed to close multipart writer", map[string]any{"error": err})
 		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
@@ -102,9 +160,11 @@ func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string)
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
 
-	logger.DebugCF("voice", "Sending transcription request to Groq API", map[string]any{
+	logger.DebugCF("voice", "Sending transcription request", map[string]any{
 		"url":                url,
 		"request_size_bytes": requestBody.Len(),
 		"file_size_bytes":    fileInfo.Size(),
@@ -131,7 +191,7 @@ func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string)
 		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
 	}
 
-	logger.DebugCF("voice", "Received response from Groq API", map[string]any{
+	logger.DebugCF("voice", "Received transcription response", map[string]any{
 		"status_code":         resp.StatusCode,
 		"response_size_bytes": len(body),
 	})
@@ -152,8 +212,8 @@ func (t *GroqTranscriber) Transcribe(ctx context.Context, audioFilePath string)
 	return &result, nil
 }
 
-func (t *GroqTranscriber) IsAvailable() bool {
-	available := t.apiKey != ""
-	logger.DebugCF("voice", "Checking transcriber availability", map[string]any{"available": available})
-	return available
+// IsAvailable reports whether an endpoint is configured. Self-hosted
+// servers often need no API key, but api.openai.com always does.
+func (t *OpenAITranscriber) IsAvailable() bool {
+	return t.apiBase != "" && (t.apiKey != "" || t.apiBase != openAIAPIBase)
 }
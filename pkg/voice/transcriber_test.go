@@ -0,0 +1,161 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func writeAudio(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "voice.ogg")
+	if err := os.WriteFile(path, []byte("OggS fake audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenAITranscriber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("ParseMultipartForm: %v", err)
+		}
+		if r.FormValue("model") != "whisper-small" || r.FormValue("language") != "de" {
+			t.Errorf("model=%q language=%q", r.FormValue("model"), r.FormValue("language"))
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("FormFile: %v", err)
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "voice.ogg" || string(data) != "OggS fake audio" {
+			t.Errorf("file = %s %q", header.Filename, data)
+		}
+		json.NewEncoder(w).Encode(TranscriptionResponse{Text: "hallo welt", Language: "de"})
+	}))
+	defer server.Close()
+
+	tr := NewOpenAITranscriber(server.URL+"/v1/", "test-key", "whisper-small")
+	tr.language = "de"
+	result, err := tr.Transcribe(context.Background(), writeAudio(t))
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if result.Text != "hallo welt" {
+		t.Errorf("Text = %q", result.Text)
+	}
+}
+
+func TestOpenAITranscriber_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad audio"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := NewOpenAITranscriber(server.URL, "", "").Transcribe(context.Background(), writeAudio(t))
+	if err == nil {
+		t.Fatal("expected an error for a 400 response")
+	}
+}
+
+func TestCommandTranscriber(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	audio := writeAudio(t)
+
+	// The stub recognizer prints the file it was given over two lines.
+	tr := NewCommandTranscriber("sh", []string{"-c", `printf ' heard\n %s \n' "$(basename "$1")"`, "sh", "{file}"}, 0)
+	if !tr.IsAvailable() {
+		t.Fatal("sh should be available")
+	}
+	result, err := tr.Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if result.Text != "heard voice.ogg" {
+		t.Errorf("Text = %q", result.Text)
+	}
+
+	failing := NewCommandTranscriber("sh", []string{"-c", "echo model missing >&2; exit 3"}, 0)
+	if _, err := failing.Transcribe(context.Background(), audio); err == nil {
+		t.Error("expected an error when the command fails")
+	}
+
+	if NewCommandTranscriber("no-such-whisper-binary", nil, 0).IsAvailable() {
+		t.Error("a missing command should not be available")
+	}
+}
+
+func TestNewTranscriber(t *testing.T) {
+	cfg := config.DefaultConfig()
+
+	// Groq without an API key is unavailable.
+	if tr, err := NewTranscriber(cfg); err != nil || tr != nil {
+		t.Errorf("NewTranscriber without a Groq key = %v, %v; want nil", tr, err)
+	}
+
+	cfg.ModelList = []config.ModelConfig{{ModelName: "llama", Model: "groq/llama-3.3-70b", APIKey: "gsk-test"}}
+	tr, err := NewTranscriber(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groq, ok := tr.(*GroqTranscriber); !ok || groq.apiKey != "gsk-test" {
+		t.Errorf("NewTranscriber = %#v, want a Groq transcriber with the model list key", tr)
+	}
+
+	cfg.Voice.Transcription.TimeoutSeconds = 5
+	if groq := mustTranscriber(t, cfg).(*GroqTranscriber); groq.httpClient.Timeout != 5*time.Second {
+		t.Errorf("Groq timeout = %v, want 5s", groq.httpClient.Timeout)
+	}
+
+	// OpenAI's hosted API needs a key; without one the backend is unavailable.
+	cfg.Voice.Transcription.Provider = "openai"
+	if tr, err := NewTranscriber(cfg); err != nil || tr != nil {
+		t.Errorf("NewTranscriber for api.openai.com without a key = %v, %v; want nil", tr, err)
+	}
+
+	cfg.Voice.Transcription.APIBase = "http://localhost:8000/v1"
+	if _, ok := mustTranscriber(t, cfg).(*OpenAITranscriber); !ok {
+		t.Error("provider openai should create an OpenAITranscriber")
+	}
+
+	cfg.Voice.Transcription.Provider = "command"
+	if _, err := NewTranscriber(cfg); err == nil {
+		t.Error("provider command without a command should fail")
+	}
+
+	cfg.Voice.Transcription.Provider = "carrier-pigeon"
+	if _, err := NewTranscriber(cfg); err == nil {
+		t.Error("unknown provider should fail")
+	}
+
+	cfg.Voice.Transcription.Enabled = false
+	if tr, err := NewTranscriber(cfg); err != nil || tr != nil {
+		t.Errorf("disabled transcription = %v, %v; want nil", tr, err)
+	}
+}
+
+func mustTranscriber(t *testing.T, cfg *config.Config) Transcriber {
+	t.Helper()
+	tr, err := NewTranscriber(cfg)
+	if err != nil || tr == nil {
+		t.Fatalf("NewTranscriber = %v, %v", tr, err)
+	}
+	return tr
+}